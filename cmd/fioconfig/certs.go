@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/foundriesio/fioconfig/pkg/fioconfig"
+	"github.com/urfave/cli/v2"
+)
+
+func renewCert(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	if c.NArg() != 1 && c.NArg() != 2 {
+		cli.ShowCommandHelpAndExit(c, "renew-cert", 1)
+	}
+	server := c.Args().Get(0)
+	stateFile := filepath.Join(c.String("config"), "cert-rotation.state")
+	handler, err := fioconfig.NewCertRotationHandler(app, stateFile, server)
+	if err != nil {
+		return err
+	}
+	idsStr := c.String("pkcs11-key-ids")
+	handler.State.PkeySlotIds = strings.Split(idsStr, ",")
+	idsStr = c.String("pkcs11-cert-ids")
+	handler.State.CertSlotIds = strings.Split(idsStr, ",")
+
+	if c.NArg() == 2 {
+		handler.State.RotationId = c.Args().Get(1)
+	}
+
+	fioconfig.Logf(fioconfig.LevelInfo, "Performing certificate renewal")
+	if err = handler.Rotate(); err == nil {
+		fioconfig.LogEvent(fioconfig.EventRotationComplete, "Certificate rotation sequence complete")
+	}
+	return err
+}
+
+func pubkey(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("age") {
+		recipient, err := app.AgeRecipient()
+		if err != nil {
+			return err
+		}
+		fmt.Println(recipient)
+		return nil
+	}
+	pubPem, fingerprint, err := app.PublicKey()
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(pubPem))
+	fmt.Println("SHA256 Fingerprint:", fingerprint)
+	return nil
+}
+
+func deviceInfo(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	info, err := app.DeviceInfo()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(info)
+	}
+	fmt.Print(info)
+	return nil
+}
+
+func encryptConfig(c *cli.Context) error {
+	if len(c.String("dir")) > 0 == (len(c.String("manifest")) > 0) {
+		return errors.New("Give exactly one of --dir or --manifest")
+	}
+	pubPem, err := os.ReadFile(c.String("pubkey"))
+	if err != nil {
+		return err
+	}
+	pub, err := fioconfig.ParseDevicePublicKey(pubPem)
+	if err != nil {
+		return fmt.Errorf("Unable to read device public key: %w", err)
+	}
+	var config fioconfig.ConfigStruct
+	if dir := c.String("dir"); len(dir) > 0 {
+		config, err = fioconfig.ConfigFromDir(dir)
+	} else {
+		config, err = fioconfig.ConfigFromManifest(c.String("manifest"))
+	}
+	if err != nil {
+		return err
+	}
+	encrypted, err := fioconfig.EncryptConfig(pub, config)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.String("output"), encrypted, 0o640); err != nil {
+		return err
+	}
+	fioconfig.Logf(fioconfig.LevelInfo, "Encrypted %d files to %s", len(config), c.String("output"))
+	return nil
+}
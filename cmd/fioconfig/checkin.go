@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/foundriesio/fioconfig/pkg/fioconfig"
+	"github.com/urfave/cli/v2"
+)
+
+func extract(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(app.SecretsDir); os.IsNotExist(err) {
+		fioconfig.Logf(fioconfig.LevelInfo, "Creating secrets directory: %s", app.SecretsDir)
+		if err := os.Mkdir(app.SecretsDir, 0750); err != nil {
+			return err
+		}
+	}
+	fioconfig.Logf(fioconfig.LevelInfo, "Extracting keys from %s to %s", app.EncryptedConfig, app.SecretsDir)
+	var files []fileChange
+	defer trackChanges(app, &files)()
+	err = app.Extract()
+	if errors.Is(err, os.ErrNotExist) {
+		fioconfig.Logf(fioconfig.LevelInfo, "Encrypted config does not exist")
+		err = fioconfig.NoConfigError
+		if !jsonOutput {
+			return nil
+		}
+	}
+	if jsonOutput {
+		return printApplyResult(err, files)
+	}
+	return exitWith(err)
+}
+
+func importBundle(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowCommandHelpAndExit(c, "import", 1)
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(app.SecretsDir); os.IsNotExist(err) {
+		fioconfig.Logf(fioconfig.LevelInfo, "Creating secrets directory: %s", app.SecretsDir)
+		if err := os.Mkdir(app.SecretsDir, 0750); err != nil {
+			return err
+		}
+	}
+	var files []fileChange
+	defer trackChanges(app, &files)()
+	err = app.Import(c.Args().First())
+	if jsonOutput {
+		return printApplyResult(err, files)
+	}
+	return exitWith(err)
+}
+
+func checkin(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("dry-run") {
+		return dryRun(app, c.Bool("full"))
+	}
+	fioconfig.LogEvent(fioconfig.EventCheckIn, "Checking in with server")
+	var files []fileChange
+	defer trackChanges(app, &files)()
+	err = app.CheckIn()
+	if jsonOutput {
+		return printApplyResult(err, files)
+	}
+	return exitWith(err)
+}
+
+func setFile(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		cli.ShowCommandHelpAndExit(c, "set", 1)
+	}
+	var content []byte
+	var err error
+	if path := c.Args().Get(1); len(path) > 0 && path != "-" {
+		content, err = os.ReadFile(path)
+	} else {
+		content, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	cfgFile := fioconfig.NewConfigFile(content)
+	cfgFile.OnChanged = c.StringSlice("on-changed")
+	cfgFile.Unencrypted = c.Bool("unencrypted")
+	return app.SetConfigFile(c.Args().First(), cfgFile, c.String("reason"))
+}
+
+func deleteFile(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowCommandHelpAndExit(c, "delete", 1)
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	return app.DeleteConfigFile(c.Args().First())
+}
+
+func sealLocalSecret(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		cli.ShowCommandHelpAndExit(c, "seal", 1)
+	}
+	var value []byte
+	var err error
+	if path := c.Args().Get(1); len(path) > 0 && path != "-" {
+		value, err = os.ReadFile(path)
+	} else {
+		value, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	return app.SealLocalSecret(c.Args().First(), value)
+}
+
+func listLocalSecrets(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	names, err := app.LocalSecrets()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(names)
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func removeLocalSecret(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowCommandHelpAndExit(c, "remove", 1)
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	return app.DeleteLocalSecret(c.Args().First())
+}
+
+func hold(c *cli.Context) error {
+	if c.NArg() > 1 {
+		cli.ShowCommandHelpAndExit(c, "hold", 1)
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	return app.Hold(c.Args().First())
+}
+
+func unhold(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	return app.Unhold()
+}
+
+func confirm(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	return app.Confirm()
+}
+
+func dryRun(app *fioconfig.App, full bool) error {
+	result, err := app.DryRun(full)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(result)
+	}
+	if len(result.Changes) == 0 {
+		fmt.Println("No changes")
+	}
+	for _, change := range result.Changes {
+		fmt.Println(change)
+		if len(change.Diff) > 0 {
+			fmt.Print(change.Diff)
+		}
+	}
+	for _, handler := range result.Handlers {
+		if handler.Skipped {
+			fmt.Printf("Would skip unsafe on-change command for %s: %v\n", handler.File, handler.Command)
+		} else {
+			fmt.Printf("Would run on-change command for %s: %v\n", handler.File, handler.Command)
+		}
+	}
+	return nil
+}
+
+// How often a device that needs re-enrollment checks whether its
+// credentials have been fixed.
+const reenrollmentBackoff = time.Hour
+
+// The least time between long-poll check-ins the server answered before
+// the wait was up
+const longPollMinDelay = 5 * time.Second
+
+// A Retry-After is honored for at most this many check-in intervals so a
+// bad value can't park the device, and the backoff saved for it, for good
+const maxRetryAfterIntervals = 4
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/foundriesio/fioconfig/pkg/fioconfig"
+	"github.com/urfave/cli/v2"
+)
+
+func daemon(c *cli.Context) error {
+	interval := time.Second * time.Duration(c.Int("interval"))
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	if err := app.SelfTest(); err != nil {
+		return err
+	}
+	app.EnableClientReuse()
+	defer app.Close()
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	// Stop an in-flight check-in, and any on-changed commands it's
+	// running, when asked to shut down
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	longPollWait := time.Second * time.Duration(c.Int("long-poll"))
+	if longPollWait > 0 {
+		fioconfig.Logf(fioconfig.LevelInfo, "Enabling long-poll check-ins of up to %d seconds", c.Int("long-poll"))
+		app.EnableLongPoll(longPollWait)
+	}
+
+	stopMetrics, err := app.StartMetricsServer()
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	stopDBus, err := app.StartDBusService()
+	if err != nil {
+		return err
+	}
+	defer stopDBus()
+
+	// Watching is a convenience, so a daemon that can't create the socket
+	// still runs
+	if stopControl, err := app.StartControlSocket(); err != nil {
+		fioconfig.Logf(fioconfig.LevelWarn, "%s", err)
+	} else {
+		defer stopControl()
+	}
+
+	stopQuery, err := app.StartQuerySocket()
+	if err != nil {
+		return err
+	}
+	defer stopQuery()
+
+	stopDriftWatch, err := app.StartDriftWatch()
+	if err != nil {
+		return err
+	}
+	defer stopDriftWatch()
+
+	fioconfig.Logf(fioconfig.LevelInfo, "Running as daemon with interval %d seconds", c.Int("interval"))
+	if err := app.WaitForStartup(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	// Notifications only bring check-ins forward, so a daemon that can't
+	// reach the broker still runs
+	notify, stop, err := app.StartMqttNotifications()
+	if err != nil {
+		fioconfig.Logf(fioconfig.LevelWarn, "%s", err)
+		notify, stop = nil, func() {}
+	}
+	defer func() { stop() }()
+	// Honor a backoff from before the daemon was restarted
+	if backoff := app.CheckInBackoff(); backoff != nil && time.Until(backoff.Until) > 0 {
+		fioconfig.Logf(fioconfig.LevelInfo, "Not checking in until %s: %s", backoff.Until.Format(time.RFC3339), backoff.Reason)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(backoff.Until)):
+		}
+	}
+	splay := c.Bool("splay")
+	var offset time.Duration
+	if splay {
+		offset = app.CheckInOffset(interval)
+		fioconfig.Logf(fioconfig.LevelInfo, "Checking in %s into each interval", offset)
+		// Spread out the first check-in too, since that's when a fleet
+		// rebooted at the same time would all hit the server.
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(fioconfig.NextCheckIn(offset, interval)):
+		}
+	}
+	for {
+		if err := app.RenewCertIfDue(); err != nil {
+			fioconfig.LogEvent(fioconfig.EventCertRenewFailed, "ERROR: Unable to renew client certificate: %s", err)
+		}
+		if err := app.CheckDriftIfDue(); err != nil {
+			fioconfig.Logf(fioconfig.LevelError, "Unable to check for local changes: %s", err)
+		}
+		fioconfig.LogEvent(fioconfig.EventCheckIn, "Checking in with server")
+		delay := interval
+		if splay {
+			delay = fioconfig.NextCheckIn(offset, interval)
+		}
+		wakeup := notify
+		started := time.Now()
+		err := app.CheckInContext(ctx)
+		if ctx.Err() != nil {
+			fioconfig.Logf(fioconfig.LevelInfo, "Shutting down")
+			return nil
+		}
+		var rateLimited *fioconfig.RateLimitedError
+		var tlsErr *fioconfig.TlsError
+		var backoff *fioconfig.CheckInBackoff
+		result := fioconfig.LogFields{"checkin_result": fioconfig.CheckInResult(err)}
+		if errors.As(err, &rateLimited) {
+			fioconfig.LogEventWith(fioconfig.EventRateLimited, result, "%s", err)
+			// Don't let push notifications bring us back before the
+			// server is ready for us.
+			delay = rateLimited.RetryAfter
+			if limit := maxRetryAfterIntervals * interval; delay > limit {
+				fioconfig.Logf(fioconfig.LevelWarn, "Retrying in %s rather than the %s the server asked for", limit, delay)
+				delay = limit
+			}
+			wakeup = nil
+			backoff = &fioconfig.CheckInBackoff{Until: time.Now().Add(delay), Reason: err.Error()}
+		} else if errors.Is(err, fioconfig.NeedsReenrollmentError) {
+			fioconfig.LogEventWith(fioconfig.EventNeedsReenrollment, result, "%s", err)
+			// Retrying won't help until the device gets new credentials
+			if delay < reenrollmentBackoff {
+				delay = reenrollmentBackoff
+			}
+			wakeup = nil
+			backoff = &fioconfig.CheckInBackoff{Until: time.Now().Add(delay), Reason: "Device needs re-enrollment"}
+		} else if errors.As(err, &tlsErr) {
+			fioconfig.LogEventWith(fioconfig.EventTlsFailure, result, "%s", err)
+		} else if err != nil && !errors.Is(err, fioconfig.NotModifiedError) && !errors.Is(err, fioconfig.PartialExtractError) {
+			fioconfig.LogEventWith(fioconfig.EventCheckInFailed, result, "%s", err)
+		} else if app.LongPolling() {
+			// The server already held the request until something changed
+			// or the wait expired, so go straight back to waiting on it.
+			// An early answer gets a pause so a server that answers
+			// straight away isn't polled in a tight loop.
+			delay = 0
+			if time.Since(started) < longPollWait {
+				delay = longPollMinDelay
+			}
+		}
+		if errors.Is(err, fioconfig.ConfigPendingError) {
+			// The server answers long-polls straight away while a config
+			// is pending, so check in at the usual interval, or as soon
+			// as the window opens
+			delay = interval
+			if splay {
+				delay = fioconfig.NextCheckIn(offset, interval)
+			}
+			if pending, _ := app.PendingStatus(); pending != nil {
+				if opens := time.Until(pending.Opens); opens > 0 && opens < delay {
+					delay = opens
+				}
+			}
+		}
+		if err := app.SetCheckInBackoff(backoff); err != nil {
+			fioconfig.Logf(fioconfig.LevelWarn, "Unable to save check-in backoff: %s", err)
+		}
+		requests := app.CheckInRequests()
+		if backoff != nil {
+			// Asking for a check-in doesn't get around the backoff either
+			requests = nil
+		}
+		next := time.Now().Add(delay)
+		for waiting := true; waiting; {
+			waiting = false
+			select {
+			case <-ctx.Done():
+				fioconfig.Logf(fioconfig.LevelInfo, "Shutting down")
+				return nil
+			case <-sighup:
+				fioconfig.LogEvent(fioconfig.EventSighupReload, "Received SIGHUP, reloading sota.toml")
+				if err := app.Reload(); err != nil {
+					fioconfig.Logf(fioconfig.LevelError, "%s", err)
+				} else {
+					// Connect to the broker sota.toml has now
+					stop()
+					if notify, stop, err = app.StartMqttNotifications(); err != nil {
+						fioconfig.Logf(fioconfig.LevelWarn, "%s", err)
+						notify, stop = nil, func() {}
+					}
+				}
+				// Reloading doesn't end a backoff either
+				waiting = backoff != nil
+			case <-wakeup:
+			case <-requests:
+				fioconfig.Logf(fioconfig.LevelInfo, "Checking in on request")
+			case <-time.After(time.Until(next)):
+			}
+		}
+	}
+}
+
+// akliteCallback handles aktualizr-lite's $MESSAGE and $RESULT
+func akliteCallback(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	return app.AkliteCallback(os.Getenv("MESSAGE"), os.Getenv("RESULT"))
+}
+
+func watch(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	socket := c.String("socket")
+	if len(socket) == 0 {
+		socket = app.ControlSocket()
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	return fioconfig.Watch(ctx, socket, func(event fioconfig.WatchEvent) {
+		if jsonOutput {
+			// One object per line so the stream can be piped to jq
+			buf, err := json.Marshal(event)
+			if err == nil {
+				fmt.Println(string(buf))
+			}
+			return
+		}
+		fmt.Println(event)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/foundriesio/fioconfig/pkg/fioconfig"
+	"github.com/urfave/cli/v2"
+)
+
+// Exit codes of `fioconfig check-in` and `fioconfig extract`, so scripts
+// and systemd units can branch on the outcome. Anything else that fails
+// exits with 1.
+const (
+	// The server rejected the TLS handshake or couldn't be trusted
+	tlsFailureExitCode = 3
+	// The server rejected the device's credentials
+	authFailureExitCode = 4
+	// The server couldn't be reached or kept failing
+	networkFailureExitCode = 5
+	// The config couldn't be verified, decrypted or applied
+	extractFailureExitCode = 6
+	// The config was applied but some handlers failed
+	partialExtractExitCode = 7
+	// The config on the server hasn't changed
+	notModifiedExitCode = 10
+	// The server has no config for the device
+	noConfigExitCode = 11
+	// The server has a new config but the device is on hold
+	heldExitCode = 12
+	// The server has a new config that's waiting for an apply window
+	pendingExitCode = 13
+)
+
+func exitCode(err error) int {
+	var tlsErr *fioconfig.TlsError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &tlsErr):
+		return tlsFailureExitCode
+	case errors.Is(err, fioconfig.AuthFailedError):
+		return authFailureExitCode
+	case errors.Is(err, fioconfig.ServerUnreachableError):
+		return networkFailureExitCode
+	case errors.Is(err, fioconfig.PartialExtractError):
+		return partialExtractExitCode
+	case errors.Is(err, fioconfig.ExtractFailedError), errors.Is(err, fioconfig.DecryptFailedError), errors.Is(err, fioconfig.BadSignatureError):
+		return extractFailureExitCode
+	case errors.Is(err, fioconfig.NoConfigError):
+		return noConfigExitCode
+	case errors.Is(err, fioconfig.ConfigHeldError):
+		return heldExitCode
+	case errors.Is(err, fioconfig.ConfigPendingError):
+		return pendingExitCode
+	case errors.Is(err, fioconfig.NotModifiedError):
+		return notModifiedExitCode
+	}
+	return 1
+}
+
+// exitWith returns err with its exit code. Outcomes that aren't errors, or
+// that were already logged, exit without printing anything.
+func exitWith(err error) error {
+	code := exitCode(err)
+	switch code {
+	case 0:
+		return nil
+	case 1:
+		return err
+	case partialExtractExitCode, notModifiedExitCode, noConfigExitCode, heldExitCode, pendingExitCode:
+		return cli.Exit("", code)
+	}
+	return cli.Exit(err, code)
+}
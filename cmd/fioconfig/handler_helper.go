@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/foundriesio/fioconfig/pkg/fioconfig"
+	"github.com/urfave/cli/v2"
+)
+
+func handlerHelper(c *cli.Context) error {
+	u, err := user.Lookup(c.String("user"))
+	if err != nil {
+		if u, err = user.LookupId(c.String("user")); err != nil {
+			return fmt.Errorf("Unable to find daemon user %s: %w", c.String("user"), err)
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	socket := c.String("socket")
+	if len(socket) == 0 {
+		socket = app.HandlerHelperSocket()
+	}
+	l, err := fioconfig.ListenHandlerHelper(socket, uid)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	fioconfig.Logf(fioconfig.LevelInfo, "Running on-changed commands for %s from %s", u.Username, socket)
+	return app.ServeHandlerHelper(ctx, l, uint32(uid))
+}
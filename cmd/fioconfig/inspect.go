@@ -0,0 +1,470 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foundriesio/fioconfig/pkg/fioconfig"
+	toml "github.com/pelletier/go-toml"
+	"github.com/urfave/cli/v2"
+)
+
+func supportBundle(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	output := c.String("output")
+	if len(output) == 0 {
+		output = fmt.Sprintf("fioconfig-support-%d.tar.gz", time.Now().Unix())
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := app.WriteSupportBundle(f); err != nil {
+		return err
+	}
+	fioconfig.Logf(fioconfig.LevelInfo, "Support bundle written to %s", output)
+	return nil
+}
+
+func export(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	output := c.String("output")
+	if len(output) == 0 {
+		output = fmt.Sprintf("fioconfig-export-%d.tar.gz", time.Now().Unix())
+	}
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := app.WriteExport(f); err != nil {
+		return err
+	}
+	fioconfig.Logf(fioconfig.LevelInfo, "Config exported to %s", output)
+	return nil
+}
+
+func diagnose(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	return printDiagnostics(app.Diagnose(), "Connectivity diagnostics failed")
+}
+
+func validate(c *cli.Context) error {
+	return printDiagnostics(fioconfig.Validate(c.String("config")), "Validation failed")
+}
+
+// printDiagnostics prints a pass/fail report, failing with msg if any
+// check failed
+func printDiagnostics(results []fioconfig.DiagnosticResult, msg string) error {
+	if jsonOutput {
+		type check struct {
+			Name  string `json:"name"`
+			Error string `json:"error,omitempty"`
+			Hint  string `json:"hint,omitempty"`
+		}
+		checks := []check{}
+		for _, result := range results {
+			ck := check{Name: result.Name}
+			if result.Err != nil {
+				ck.Error, ck.Hint = result.Err.Error(), result.Hint
+			}
+			checks = append(checks, ck)
+		}
+		if err := printJSON(checks); err != nil {
+			return err
+		}
+	}
+	for _, result := range results {
+		if !jsonOutput {
+			fmt.Println(result)
+		}
+		if result.Err != nil {
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
+
+func showEffective(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	buf, err := toml.Marshal(app.EffectiveConfig())
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(buf))
+	return nil
+}
+
+func diff(c *cli.Context) error {
+	if c.NArg() != 2 {
+		cli.ShowCommandHelpAndExit(c, "diff", 1)
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	changes, err := app.DiffFiles(c.Args().Get(0), c.Args().Get(1), c.Bool("full"))
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(changes)
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+		if len(change.Diff) > 0 {
+			fmt.Print(change.Diff)
+		}
+	}
+	return nil
+}
+
+func list(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	entries, err := app.ListConfig()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(entries)
+	}
+	for _, entry := range entries {
+		line := fmt.Sprintf("%s\tsha256:%s\t%d bytes", entry.Name, entry.Sha256[:12], entry.Size)
+		if len(entry.OnChanged) > 0 {
+			line += "\t" + strings.Join(entry.OnChanged, " ")
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func show(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowCommandHelpAndExit(c, "show", 1)
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	plain := c.Bool("plain") || !c.Bool("redact")
+	entry, err := app.ShowConfigFile(c.Args().First(), plain)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(entry)
+	}
+	if plain {
+		// Print the value as is so it can be piped elsewhere
+		fmt.Print(entry.Value)
+		return nil
+	}
+	fmt.Printf("name: %s\n", entry.Name)
+	fmt.Printf("sha256: %s\n", entry.Sha256)
+	fmt.Printf("size: %d bytes\n", entry.Size)
+	if len(entry.OnChanged) > 0 {
+		fmt.Printf("on-changed: %s\n", strings.Join(entry.OnChanged, " "))
+	}
+	fmt.Printf("value: %s (use --plain to show it)\n", entry.Value)
+	return nil
+}
+
+func history(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("check-ins") {
+		return checkIns(app)
+	}
+	entries, err := app.History()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(entries)
+	}
+	for _, entry := range entries {
+		revertable := ""
+		if !entry.HasBlob {
+			revertable = " (metadata only)"
+		}
+		change := ""
+		if entry.Change != nil {
+			change = "\t" + entry.Change.String()
+		}
+		fmt.Printf("%d\t%s\tsha256:%s\t%d files%s%s\n",
+			entry.Version, entry.Applied.Format(time.RFC3339), entry.Sha256[:12], len(entry.Files), revertable, change)
+	}
+	return nil
+}
+
+func checkIns(app *fioconfig.App) error {
+	records, err := app.CheckIns()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(records)
+	}
+	for _, r := range records {
+		config := "-"
+		if len(r.Sha256) > 0 {
+			config = fmt.Sprintf("v%d sha256:%s", r.Version, r.Sha256[:12])
+		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%d changed", r.Time.Format(time.RFC3339), r.Result, config, len(r.Changed))
+		if len(r.Error) > 0 {
+			line += "\t" + r.Error
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func audit(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("verify") {
+		err := app.VerifyAuditLog()
+		if jsonOutput {
+			result := map[string]interface{}{"intact": err == nil}
+			if err != nil {
+				result["error"] = err.Error()
+			}
+			if jsonErr := printJSON(result); jsonErr != nil {
+				return jsonErr
+			}
+			if err != nil {
+				return cli.Exit("", 1)
+			}
+			return nil
+		}
+		if err != nil {
+			return cli.Exit(err, 1)
+		}
+		fmt.Println("Audit log is intact")
+		return nil
+	}
+	records, err := app.AuditLog()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(records)
+	}
+	for _, r := range records {
+		handler := ""
+		if r.ExitCode != nil {
+			handler = fmt.Sprintf("\thandler exited %d", *r.ExitCode)
+		}
+		fmt.Printf("%s\tv%d\t%s\t%s%s\n", r.Time.Format(time.RFC3339), r.Version, r.Action, r.File, handler)
+	}
+	return nil
+}
+
+func revert(c *cli.Context) error {
+	if c.NArg() > 1 {
+		cli.ShowCommandHelpAndExit(c, "revert", 1)
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	var version int
+	if c.NArg() == 1 {
+		if version, err = strconv.Atoi(c.Args().Get(0)); err != nil {
+			return fmt.Errorf("Invalid version: %w", err)
+		}
+	} else if version, err = app.PreviousVersion(); err != nil {
+		return err
+	}
+	fioconfig.LogEvent(fioconfig.EventConfigReverted, "Reverting to config version %d", version)
+	return app.Revert(version)
+}
+
+func verify(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	drift, err := app.CheckDrift()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		if drift == nil {
+			drift = []fioconfig.FileDrift{}
+		}
+		if err := printJSON(drift); err != nil {
+			return err
+		}
+	} else {
+		for _, d := range drift {
+			fmt.Println(d)
+		}
+	}
+	if len(drift) == 0 {
+		return nil
+	}
+	if c.Bool("repair") {
+		return app.RepairDrift()
+	}
+	return cli.Exit("Managed config files have been changed locally", 1)
+}
+
+func gc(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	report, err := app.CollectGarbage(c.Bool("dry-run"))
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(report)
+	}
+	verb := "removed"
+	if c.Bool("dry-run") {
+		verb = "would remove"
+	}
+	for _, fname := range report.Orphans {
+		fmt.Printf("%s: %s\n", verb, fname)
+	}
+	for _, fname := range report.TmpFiles {
+		fmt.Printf("%s: %s\n", verb, fname)
+	}
+	for _, fname := range report.Modified {
+		fmt.Printf("kept, modified locally: %s\n", fname)
+	}
+	return nil
+}
+
+func status(c *cli.Context) error {
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	hold, err := app.HoldStatus()
+	if err != nil {
+		return err
+	}
+	if hold != nil && !jsonOutput {
+		fmt.Printf("On hold since %s", hold.Since.Format(time.RFC3339))
+		if len(hold.Reason) > 0 {
+			fmt.Printf(": %s", hold.Reason)
+		}
+		fmt.Println()
+		if len(hold.Pending) > 0 {
+			fmt.Printf("Changes waiting: %s\n", strings.Join(hold.Pending, ", "))
+		}
+	}
+	pending, err := app.PendingStatus()
+	if err != nil {
+		return err
+	}
+	if pending != nil && !jsonOutput {
+		fmt.Printf("Waiting for the apply window at %s: %s\n", pending.Opens.Format(time.RFC3339), strings.Join(pending.Pending, ", "))
+	}
+	if backoff := app.CheckInBackoff(); backoff != nil && time.Until(backoff.Until) > 0 && !jsonOutput {
+		fmt.Printf("Not checking in until %s: %s\n", backoff.Until.Format(time.RFC3339), backoff.Reason)
+	}
+	report, err := app.LastReport()
+	if errors.Is(err, os.ErrNotExist) {
+		if jsonOutput {
+			return printJSON(nil)
+		}
+		fmt.Println("No config has been extracted yet")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(report)
+	}
+	fmt.Printf("Last extraction: %s (%s)\n", report.Timestamp.Format(time.RFC3339), report.Code)
+	if report.Change != nil {
+		fmt.Printf("Change: %s\n", report.Change)
+	}
+	fmt.Printf("Applied: %d files, removed: %d files\n", len(report.Applied), len(report.Removed))
+	if len(report.Rejected) > 0 {
+		fmt.Printf("Rejected: %s\n", report.Rejected)
+	}
+	var failed []string
+	for fname := range report.Failed {
+		failed = append(failed, fname)
+	}
+	sort.Strings(failed)
+	for _, fname := range failed {
+		fmt.Printf("FAILED: %s: %s\n", fname, report.Failed[fname])
+	}
+	for _, warning := range report.Warnings {
+		fmt.Printf("WARNING: %s\n", warning)
+	}
+	retries, err := app.HandlerRetries()
+	if err != nil {
+		return err
+	}
+	for _, retry := range retries {
+		fmt.Printf("RETRYING: %s: on-change command failed %d times, next attempt at %s\n",
+			retry.File, retry.Attempts, retry.Next.Format(time.RFC3339))
+	}
+	handlers := report.Handlers
+	if report.AfterExtract != nil {
+		handlers = append(handlers, *report.AfterExtract)
+	}
+	for _, h := range handlers {
+		if h.ExitCode == 0 || len(h.Output) == 0 {
+			continue
+		}
+		name := h.File
+		if len(name) == 0 {
+			name = "after-extract"
+		}
+		fmt.Printf("\n--- Output of %s: %v\n%s", name, h.Command, h.Output)
+		if !strings.HasSuffix(h.Output, "\n") {
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+func wait(c *cli.Context) error {
+	var files []string
+	for _, fname := range strings.Split(c.String("files"), ",") {
+		if fname = strings.TrimSpace(fname); len(fname) > 0 {
+			files = append(files, fname)
+		}
+	}
+	if len(files) == 0 {
+		cli.ShowCommandHelpAndExit(c, "wait", 1)
+	}
+	app, err := NewApp(c)
+	if err != nil {
+		return err
+	}
+	return app.WaitForFiles(files, c.Duration("timeout"))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/foundriesio/fioconfig/pkg/fioconfig"
+	"github.com/urfave/cli/v2"
+)
+
+// Set by the global --json flag. Logs still go to stderr, so stdout only
+// has the command's JSON.
+var jsonOutput bool
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+type fileChange struct {
+	File   string               `json:"file"`
+	Change fioconfig.ChangeType `json:"change"`
+}
+
+// applyResult is the JSON output of check-in and extract
+type applyResult struct {
+	Result   string       `json:"result"`
+	ExitCode int          `json:"exit-code"`
+	Files    []fileChange `json:"files"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// trackChanges collects the files changed while applying a config. The
+// returned function stops tracking.
+func trackChanges(app *fioconfig.App, files *[]fileChange) func() {
+	*files = []fileChange{}
+	return app.Subscribe(func(event fioconfig.ChangeEvent) {
+		if len(event.File) > 0 {
+			*files = append(*files, fileChange{event.File, event.Type})
+		}
+	})
+}
+
+// printApplyResult prints the outcome of a check-in or extraction and
+// exits with the same status as it would without --json
+func printApplyResult(err error, files []fileChange) error {
+	result := applyResult{
+		Result:   fioconfig.CheckInResult(err),
+		ExitCode: exitCode(err),
+		Files:    files,
+	}
+	if result.ExitCode != 0 && result.ExitCode != notModifiedExitCode && result.ExitCode != noConfigExitCode {
+		result.Error = err.Error()
+	}
+	if err := printJSON(result); err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return cli.Exit("", result.ExitCode)
+	}
+	return nil
+}
+
+// printJSONError reports a command's failure on stdout for --json callers
+func printJSONError(err error) {
+	_ = printJSON(map[string]string{"error": err.Error()})
+}
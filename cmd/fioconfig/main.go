@@ -0,0 +1,545 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/foundriesio/fioconfig/pkg/fioconfig"
+	"github.com/urfave/cli/v2"
+)
+
+func NewApp(c *cli.Context) (*fioconfig.App, error) {
+	app, err := fioconfig.NewApp(c.String("config"),
+		fioconfig.WithSecretsDir(c.String("secrets-dir")),
+		fioconfig.WithUnsafeHandlers(c.Bool("unsafe-handlers")),
+		fioconfig.WithExtractOnly(c.StringSlice("only")...))
+	if err != nil {
+		return nil, err
+	}
+	if c.Command.Name == "check-in" && c.Bool("dry-run") {
+		return app, nil
+	}
+	switch c.Command.Name {
+	case "handler-helper", "renew-cert", "pubkey", "device-info", "support-bundle", "export", "diagnose", "show-effective", "diff", "list", "show", "history", "wait", "watch", "aklite-callback", "status", "audit", "hold", "unhold", "confirm", "gc":
+		return app, nil
+	}
+	stateFile := filepath.Join(c.String("config"), "cert-rotation.state")
+	handler, err := fioconfig.RestoreCertRotationHandler(app, stateFile)
+	if err == nil && handler != nil {
+		online := c.Command.Name != "extract" && c.Command.Name != "import" && c.Command.Name != "revert" && c.Command.Name != "verify"
+		err = handler.ResumeRotation(online)
+	}
+	return app, err
+}
+
+func setupLogging(c *cli.Context) error {
+	jsonOutput = c.Bool("json")
+	level, err := fioconfig.ParseLogLevel(c.String("log-level"))
+	if err != nil {
+		return err
+	}
+	format := fioconfig.LogFormat(c.String("log-format"))
+	if len(format) == 0 {
+		format = fioconfig.DefaultLogFormat()
+	}
+	return fioconfig.SetLogOutput(os.Stderr, format, level)
+}
+
+func main() {
+	app := &cli.App{
+		Name:  "fioconfig",
+		Usage: "A daemon to handle configuration management for devices in a Foundries Factory",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Value:   "/var/sota",
+				Usage:   "Aktualizr config directory",
+				EnvVars: []string{"SOTA_DIR"},
+			},
+			&cli.StringFlag{
+				Name:    "secrets-dir",
+				Aliases: []string{"s"},
+				Value:   "/var/run/secrets",
+				Usage:   "Location to extract configuration to",
+				EnvVars: []string{"SECRETS_DIR"},
+			},
+			&cli.BoolFlag{
+				Name:    "unsafe-handlers",
+				Usage:   "Enable running on-changed handlers defined outside of /usr/share/fioconfig/handlers/",
+				EnvVars: []string{"UNSAFE_CALLBACKS"},
+			},
+			&cli.StringFlag{
+				Name:    "log-level",
+				Value:   "info",
+				Usage:   "Only log messages at this level or above: debug, info, warn, or error",
+				EnvVars: []string{"FIOCONFIG_LOG_LEVEL"},
+			},
+			&cli.StringFlag{
+				Name:    "log-format",
+				Usage:   "Format of log messages: text, json, logfmt, or journal. The default is journal when running under systemd and text otherwise",
+				EnvVars: []string{"FIOCONFIG_LOG_FORMAT"},
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the output of commands like check-in, status, and diff as JSON",
+			},
+		},
+		Before: setupLogging,
+		Commands: []*cli.Command{
+			{
+				Name:  "extract",
+				Usage: "Extract the current encrypted configuration to secrets directory",
+				Action: func(c *cli.Context) error {
+					return extract(c)
+				},
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "only",
+						Usage: "Only extract the files matching this pattern, e.g. 'wireguard/*'. May be given more than once",
+					},
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "Apply a config.encrypted bundle from removable media",
+				ArgsUsage: "<path>",
+				Action: func(c *cli.Context) error {
+					return importBundle(c)
+				},
+			},
+			{
+				Name:  "check-in",
+				Usage: "Check in with the server and update the local config",
+				Action: func(c *cli.Context) error {
+					return checkin(c)
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Only show the files that would change and the on-change commands that would run",
+					},
+					&cli.BoolFlag{
+						Name:  "full",
+						Usage: "Include a diff of the plaintext values with --dry-run",
+					},
+				},
+			},
+			{
+				Name:      "set",
+				Usage:     "Upload a file to this device's config on the server",
+				ArgsUsage: "<filename> [<path>|-]",
+				Action: func(c *cli.Context) error {
+					return setFile(c)
+				},
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "on-changed",
+						Usage: "A command to run when the file changes. Give once per argument",
+					},
+					&cli.BoolFlag{
+						Name:  "unencrypted",
+						Usage: "Store the value in plaintext on the server",
+					},
+					&cli.StringFlag{
+						Name:  "reason",
+						Usage: "The reason recorded with the change",
+					},
+				},
+			},
+			{
+				Name:      "delete",
+				Usage:     "Remove a file from this device's config on the server",
+				ArgsUsage: "<filename>",
+				Action: func(c *cli.Context) error {
+					return deleteFile(c)
+				},
+			},
+			{
+				Name:  "local-secret",
+				Usage: "Manage secrets sealed to the device's key that configs refer to with !local:<name>",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "seal",
+						Usage:     "Seal a secret read from a file, or stdin, to the device's key",
+						ArgsUsage: "<name> [<file>|-]",
+						Action: func(c *cli.Context) error {
+							return sealLocalSecret(c)
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List the names of the device's local secrets",
+						Action: func(c *cli.Context) error {
+							return listLocalSecrets(c)
+						},
+					},
+					{
+						Name:      "remove",
+						Usage:     "Remove a local secret",
+						ArgsUsage: "<name>",
+						Action: func(c *cli.Context) error {
+							return removeLocalSecret(c)
+						},
+					},
+				},
+			},
+			{
+				Name:  "daemon",
+				Usage: "Run check-in's with the server in an endless loop",
+				Action: func(c *cli.Context) error {
+					return daemon(c)
+				},
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:    "interval",
+						Aliases: []string{"i"},
+						Value:   300,
+						Usage:   "Interval in seconds for checking in for updates",
+						EnvVars: []string{"DAEMON_INTERVAL"},
+					},
+					&cli.BoolFlag{
+						Name:    "splay",
+						Usage:   "Check in at a fixed offset within each interval derived from the device ID",
+						EnvVars: []string{"DAEMON_SPLAY"},
+					},
+					&cli.IntFlag{
+						Name:    "long-poll",
+						Value:   0,
+						Usage:   "Ask the server to hold check-ins open for up to this many seconds waiting for config changes",
+						EnvVars: []string{"DAEMON_LONG_POLL"},
+					},
+				},
+			},
+			{
+				Name:  "watch",
+				Usage: "Stream check-ins, file changes, and handler results from the running daemon",
+				Action: func(c *cli.Context) error {
+					return watch(c)
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "socket",
+						Usage: "The daemon's control socket. Defaults to fioconfig.control_socket",
+					},
+				},
+			},
+			{
+				Name:  "aklite-callback",
+				Usage: "Coordinate with aktualizr-lite when run as, or from, its callback_program",
+				Action: func(c *cli.Context) error {
+					return akliteCallback(c)
+				},
+			},
+			{
+				Name:  "handler-helper",
+				Usage: "Run on-changed commands for a daemon running as an unprivileged user",
+				Action: func(c *cli.Context) error {
+					return handlerHelper(c)
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "user",
+						Usage:    "The user the daemon runs as. Only it and root may connect",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "socket",
+						Usage: "Listen here rather than at handler_helper_socket",
+					},
+				},
+			},
+			{
+				Name:     "renew-cert",
+				HelpName: "renew-cert <EST Server> [<rotation-id>]",
+				Usage:    "Renew device's TLS keypair used with device-gateway",
+				Action: func(c *cli.Context) error {
+					return renewCert(c)
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "pkcs11-key-ids",
+						Value: "01,07",
+						Usage: "The two pkcs11 slot IDs to use for private keys",
+					},
+					&cli.StringFlag{
+						Name:  "pkcs11-cert-ids",
+						Value: "03,09",
+						Usage: "The two pkcs11 slot IDs to use for client certificates",
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "Inspect fioconfig's configuration",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "show-effective",
+						Usage: "Print the fully resolved runtime configuration",
+						Action: func(c *cli.Context) error {
+							return showEffective(c)
+						},
+					},
+				},
+			},
+			{
+				Name:      "diff",
+				Usage:     "Show files added, removed, or changed between two encrypted configs",
+				ArgsUsage: "<a.encrypted> <b.encrypted>",
+				Action: func(c *cli.Context) error {
+					return diff(c)
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "full",
+						Usage: "Include a unified diff of the decrypted values rather than only their hashes",
+					},
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "List the files in the device's config and their on-changed handlers",
+				Action: func(c *cli.Context) error {
+					return list(c)
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Decrypt the device's config and print a single file from it",
+				ArgsUsage: "<filename>",
+				Action: func(c *cli.Context) error {
+					return show(c)
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "redact",
+						Usage: "Print the file's hash and size in place of its value",
+						Value: true,
+					},
+					&cli.BoolFlag{
+						Name:  "plain",
+						Usage: "Print the decrypted value",
+					},
+				},
+			},
+			{
+				Name:  "history",
+				Usage: "List the config versions applied to this device",
+				Action: func(c *cli.Context) error {
+					return history(c)
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "check-ins",
+						Usage: "List the most recent check-ins instead",
+					},
+				},
+			},
+			{
+				Name:  "audit",
+				Usage: "Show the audit log of config file changes",
+				Action: func(c *cli.Context) error {
+					return audit(c)
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "verify",
+						Usage: "Check that no records were changed or removed",
+					},
+				},
+			},
+			{
+				Name:      "revert",
+				Aliases:   []string{"rollback"},
+				Usage:     "Re-apply a config version, by default the previous one, from the history until the server's config changes",
+				ArgsUsage: "[<version>]",
+				Action: func(c *cli.Context) error {
+					return revert(c)
+				},
+			},
+			{
+				Name:      "hold",
+				Usage:     "Keep checking in but don't apply new configs until `unhold`, e.g. during maintenance",
+				ArgsUsage: "[<reason>]",
+				Action: func(c *cli.Context) error {
+					return hold(c)
+				},
+			},
+			{
+				Name:  "unhold",
+				Usage: "Let check-ins apply new configs again",
+				Action: func(c *cli.Context) error {
+					return unhold(c)
+				},
+			},
+			{
+				Name:  "confirm",
+				Usage: "Confirm that the config waiting for confirmation works, so it isn't reverted",
+				Action: func(c *cli.Context) error {
+					return confirm(c)
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "Show the outcome of the last extraction and any on-change commands that failed",
+				Action: func(c *cli.Context) error {
+					return status(c)
+				},
+			},
+			{
+				Name:  "verify",
+				Usage: "Check that the files extracted from the config haven't been modified or deleted",
+				Action: func(c *cli.Context) error {
+					return verify(c)
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "repair",
+						Usage: "Re-extract the config to restore changed files",
+					},
+				},
+			},
+			{
+				Name:  "gc",
+				Usage: "Remove files fioconfig created that are no longer in the config, and leftover temporary files",
+				Action: func(c *cli.Context) error {
+					return gc(c)
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "List what would be removed",
+					},
+				},
+			},
+			{
+				Name:  "pubkey",
+				Usage: "Display the public key config values are encrypted to",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "age",
+						Usage: "Print the age recipient offline config bundles are encrypted to",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return pubkey(c)
+				},
+			},
+			{
+				Name:  "device-info",
+				Usage: "Print the device's identity, certificate expiry, config URL, and key source",
+				Action: func(c *cli.Context) error {
+					return deviceInfo(c)
+				},
+			},
+			{
+				Name:  "encrypt",
+				Usage: "Build a config.encrypted for a device without the server, for offline provisioning",
+				Action: func(c *cli.Context) error {
+					return encryptConfig(c)
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "pubkey",
+						Usage:    "The device's public key, as printed by `fioconfig pubkey`, or its certificate",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "dir",
+						Usage: "Encrypt the files in this directory",
+					},
+					&cli.StringFlag{
+						Name:  "manifest",
+						Usage: "Encrypt the files in this JSON config with plaintext values",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   "config.encrypted",
+						Usage:   "Path to write the config to",
+					},
+				},
+			},
+			{
+				Name:  "diagnose",
+				Usage: "Run layered connectivity checks against the config server",
+				Action: func(c *cli.Context) error {
+					return diagnose(c)
+				},
+			},
+			{
+				Name:  "validate",
+				Usage: "Check sota.toml, the device's credentials, and that the config server accepts them",
+				Action: func(c *cli.Context) error {
+					return validate(c)
+				},
+			},
+			{
+				Name:  "support-bundle",
+				Usage: "Create a redacted tarball of information useful for support tickets",
+				Action: func(c *cli.Context) error {
+					return supportBundle(c)
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Path to write the bundle to",
+					},
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "Archive the encrypted config and its state to back up or move to a replacement unit",
+				Action: func(c *cli.Context) error {
+					return export(c)
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Path to write the archive to",
+					},
+				},
+			},
+			{
+				Name:  "wait",
+				Usage: "Block until the given config files have been extracted",
+				Action: func(c *cli.Context) error {
+					return wait(c)
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "files",
+						Usage:    "Comma separated list of config files to wait for",
+						Required: true,
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Value: 60 * time.Second,
+						Usage: "How long to wait before giving up",
+					},
+				},
+			},
+			{
+				Name:  "version",
+				Usage: "Display version of this command",
+				Action: func(c *cli.Context) error {
+					fmt.Println(fioconfig.Commit)
+					return nil
+				},
+			},
+		},
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		if jsonOutput {
+			printJSONError(err)
+		}
+		log.Fatal(err)
+	}
+}
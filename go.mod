@@ -1,30 +1,42 @@
 module github.com/foundriesio/fioconfig
 
-go 1.17
+go 1.19
 
 require (
+	filippo.io/age v1.0.0
 	github.com/ThalesIgnite/crypto11 v1.2.5
 	github.com/coreos/go-systemd/v22 v22.5.0
+	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/foundriesio/go-ecies v0.3.0
+	github.com/fxamacker/cbor/v2 v2.4.0
+	github.com/godbus/dbus/v5 v5.0.4
+	github.com/google/go-tpm v0.3.3
 	github.com/google/uuid v1.2.0
+	github.com/klauspost/compress v1.15.15
 	github.com/miekg/pkcs11 v1.0.3-0.20190429190417-a667d056470f
+	github.com/opencontainers/selinux v1.10.2
 	github.com/pelletier/go-toml v1.8.0
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.7.2
 	github.com/urfave/cli/v2 v2.10.2
 	go.mozilla.org/pkcs7 v0.0.0-20210826202110-33d05740a352
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/godbus/dbus/v5 v5.0.4 // indirect
+	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/thales-e-security/pool v0.0.2 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
+	golang.org/x/text v0.3.7 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 replace github.com/ThalesIgnite/crypto11 => github.com/foundriesio/crypto11 v0.0.0-20221104185643-b2344c63166b
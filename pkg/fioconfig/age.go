@@ -0,0 +1,184 @@
+package fioconfig
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	ecies "github.com/foundriesio/go-ecies"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Config bundles can also be encrypted with age (https://age-encryption.org)
+// so they can be produced offline with standard tools. Devices have P-256
+// keys, so bundles use the "piv-p256" recipient stanza defined by
+// age-plugin-yubikey, which only needs an ECDH with the device key and so
+// also works with keys in an HSM.
+const (
+	ageStanzaType    = "piv-p256"
+	ageRecipientHrp  = "age1yubikey"
+	ageBinaryHeader  = "age-encryption.org/v1\n"
+	ageFileKeyLength = 16
+)
+
+func isAgeBundle(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(ageBinaryHeader)) ||
+		bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header))
+}
+
+type ageIdentity struct {
+	key ecies.KeyProvider
+}
+
+func ageTag(compressedPub []byte) string {
+	sum := sha256.Sum256(compressedPub)
+	return base64.RawStdEncoding.EncodeToString(sum[:4])
+}
+
+func ageWrapKey(shared, epk, pk []byte) ([]byte, error) {
+	salt := append(append([]byte{}, epk...), pk...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(ageStanzaType)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (i ageIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	pub := i.key.Public()
+	pk := elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y)
+	tag := ageTag(pk)
+	for _, s := range stanzas {
+		if s.Type != ageStanzaType || len(s.Args) != 2 || s.Args[0] != tag {
+			continue
+		}
+		epk, err := base64.RawStdEncoding.DecodeString(s.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s ephemeral key: %w", ageStanzaType, err)
+		}
+		x, y := elliptic.UnmarshalCompressed(pub.Curve, epk)
+		if x == nil {
+			return nil, fmt.Errorf("Invalid %s ephemeral key", ageStanzaType)
+		}
+		shared, err := i.key.GenerateShared(ecies.ImportECDSAPublic(&ecdsa.PublicKey{Curve: pub.Curve, X: x, Y: y}))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to derive age file key: %w", err)
+		}
+		wrapKey, err := ageWrapKey(shared, epk, pk)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := chacha20poly1305.New(wrapKey)
+		if err != nil {
+			return nil, err
+		}
+		fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), s.Body, nil)
+		if err != nil || len(fileKey) != ageFileKeyLength {
+			return nil, fmt.Errorf("Unable to unwrap age file key: %w", age.ErrIncorrectIdentity)
+		}
+		return fileKey, nil
+	}
+	return nil, age.ErrIncorrectIdentity
+}
+
+// DecryptAge decrypts an age encrypted config bundle, armored or binary
+func (ec *EciesCrypto) DecryptAge(content []byte) ([]byte, error) {
+	var src io.Reader = bytes.NewReader(content)
+	if !bytes.HasPrefix(content, []byte(ageBinaryHeader)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(content)))
+	}
+	r, err := age.Decrypt(src, ageIdentity{ec.PrivKey})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt age bundle: %w", err)
+	}
+	return io.ReadAll(r)
+}
+
+// AgeRecipient returns the age recipient string offline tooling can encrypt
+// config bundles to, e.g. `age -r <recipient> -o config.encrypted`. The
+// age-plugin-yubikey plugin must be installed where age is run.
+func (ec *EciesCrypto) AgeRecipient() (string, error) {
+	pub := ec.PrivKey.Public()
+	if pub.Curve != elliptic.P256() {
+		return "", errors.New("age bundles require a P-256 device key")
+	}
+	return bech32Encode(ageRecipientHrp, elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y)), nil
+}
+
+type ageDecrypter interface {
+	DecryptAge(content []byte) ([]byte, error)
+}
+
+func decryptAgeBundle(c CryptoHandler, content []byte) ([]byte, error) {
+	dec, ok := c.(ageDecrypter)
+	if !ok {
+		return nil, errors.New("Config is an age bundle but the crypto handler can't decrypt it")
+	}
+	return dec.DecryptAge(content)
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	gen := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (b>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32Encode is the minimal encoder needed for age recipient strings
+func bech32Encode(hrp string, data []byte) string {
+	// Regroup 8 bit bytes into 5 bit words
+	var words []byte
+	acc, bits := 0, 0
+	for _, b := range data {
+		acc = acc<<8 | int(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			words = append(words, byte(acc>>uint(bits)&31))
+		}
+	}
+	if bits > 0 {
+		words = append(words, byte(acc<<uint(5-bits)&31))
+	}
+
+	var values []byte
+	for _, c := range hrp {
+		values = append(values, byte(c>>5))
+	}
+	values = append(values, 0)
+	for _, c := range hrp {
+		values = append(values, byte(c&31))
+	}
+	values = append(values, words...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, w := range words {
+		sb.WriteByte(bech32Charset[w])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
@@ -0,0 +1,116 @@
+package fioconfig
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ageTestRecipient does what age-plugin-yubikey does when encrypting
+type ageTestRecipient struct {
+	pub *ecdsa.PublicKey
+}
+
+func (r ageTestRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	eph, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	x, _ := r.pub.Curve.ScalarMult(r.pub.X, r.pub.Y, eph.D.Bytes())
+	shared := x.FillBytes(make([]byte, 32))
+	epk := elliptic.MarshalCompressed(eph.Curve, eph.X, eph.Y)
+	pk := elliptic.MarshalCompressed(r.pub.Curve, r.pub.X, r.pub.Y)
+	wrapKey, err := ageWrapKey(shared, epk, pk)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	body := aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+	sum := sha256.Sum256(pk)
+	args := []string{base64.RawStdEncoding.EncodeToString(sum[:4]), base64.RawStdEncoding.EncodeToString(epk)}
+	return []*age.Stanza{{Type: ageStanzaType, Args: args, Body: body}}, nil
+}
+
+func ageEncrypt(t *testing.T, pub *ecdsa.PublicKey, armored bool, content []byte) []byte {
+	var buf bytes.Buffer
+	var dst io.Writer = &buf
+	var aw io.WriteCloser
+	if armored {
+		aw = armor.NewWriter(&buf)
+		dst = aw
+	}
+	w, err := age.Encrypt(dst, ageTestRecipient{pub})
+	require.Nil(t, err)
+	_, err = w.Write(content)
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+	if aw != nil {
+		require.Nil(t, aw.Close())
+	}
+	return buf.Bytes()
+}
+
+func TestAgeBundle(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		ec := crypto.(*EciesCrypto)
+		pub := ec.PrivKey.Public().ExportECDSA()
+
+		bundle, err := json.Marshal(map[string]*ConfigFile{
+			"foo":     {Value: "offline foo"},
+			"sub/bar": {Value: "offline bar", Unencrypted: true},
+		})
+		require.Nil(t, err)
+
+		for _, armored := range []bool{false, true} {
+			encrypted := ageEncrypt(t, pub, armored, bundle)
+			require.True(t, isAgeBundle(encrypted))
+			require.Nil(t, os.WriteFile(app.EncryptedConfig, encrypted, 0o640))
+
+			require.Nil(t, app.Extract())
+			assertFile(t, filepath.Join(app.SecretsDir, "foo"), []byte("offline foo"))
+			assertFile(t, filepath.Join(app.SecretsDir, "sub/bar"), []byte("offline bar"))
+
+			// The file names are available to code that only needs them
+			config, err := UnmarshallFile(crypto, app.EncryptedConfig, false)
+			require.Nil(t, err)
+			require.Len(t, config, 2)
+		}
+
+		// A bundle for a different device
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(app.EncryptedConfig, ageEncrypt(t, &other.PublicKey, false, bundle), 0o640))
+		require.NotNil(t, app.Extract())
+
+		recipient, err := ec.AgeRecipient()
+		require.Nil(t, err)
+		require.Regexp(t, "^age1yubikey1[qpzry9x8gf2tvdw0s3jn54khce6mua7l]+$", recipient)
+	})
+}
+
+func TestBech32Encode(t *testing.T) {
+	// Test vectors from BIP-173
+	require.Equal(t, "a12uel5l", bech32Encode("a", nil))
+	require.Equal(t, "abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		bech32Encode("abcdef", []byte{0x00, 0x44, 0x32, 0x14, 0xc7, 0x42, 0x54, 0xb6, 0x35, 0xcf, 0x84, 0x65, 0x3a, 0x56, 0xd7, 0xc6, 0x75, 0xbe, 0x77, 0xdf}))
+}
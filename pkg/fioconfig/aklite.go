@@ -0,0 +1,74 @@
+package fioconfig
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// aktualizr-lite runs its [pacman] callback_program at each step of an
+// update with the step in $MESSAGE and, after it, the outcome in $RESULT.
+// `fioconfig aklite-callback` can be that program, or be called from it, so
+// the two daemons coordinate:
+//
+//   - After an install, which may have brought apps that need new config,
+//     the fioconfig daemon checks in right away.
+//   - With aklite_wait_for_config set, installs wait for fioconfig to have
+//     extracted the config so apps don't start without their secrets.
+const (
+	akliteInstallPre       = "install-pre"
+	akliteInstallPost      = "install-post"
+	akliteInstallFinalPre  = "install-final-pre"
+	akliteInstallFinalPost = "install-final-post"
+)
+
+// AkliteCallback handles a callback_program notification. It never fails
+// the update: problems coordinating with the daemon are only logged.
+func (a *App) AkliteCallback(message, result string) error {
+	switch message {
+	case akliteInstallPre, akliteInstallFinalPre:
+		if len(a.settings.AkliteWaitForConfig) == 0 {
+			return nil
+		}
+		timeout, err := time.ParseDuration(a.settings.AkliteWaitForConfig)
+		if err != nil {
+			return fmt.Errorf("Invalid fioconfig.aklite_wait_for_config: %w", err)
+		}
+		LogEvent(EventAkliteCallback, "Waiting up to %s for config to be extracted before %s", timeout, message)
+		if err := a.WaitForExtraction(timeout); err != nil {
+			LogEvent(EventAkliteCallback, "WARNING: %s, letting aktualizr-lite continue", err)
+		}
+	case akliteInstallPost, akliteInstallFinalPost:
+		if result != "OK" {
+			return nil
+		}
+		LogEvent(EventAkliteCallback, "Asking the daemon to check in after %s", message)
+		if err := RequestDaemonCheckIn(a.ControlSocket()); err != nil {
+			LogEvent(EventAkliteCallback, "WARNING: %s", err)
+		}
+	}
+	return nil
+}
+
+// WaitForExtraction blocks until a config has been extracted and all its
+// files are in place, or timeout expires. After a reboot this waits for
+// the boot time extraction when the secrets directory is a tmpfs.
+func (a *App) WaitForExtraction(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if applied := a.loadManifest(); len(applied) > 0 {
+			files := make([]string, 0, len(applied))
+			for fname := range applied {
+				files = append(files, fname)
+			}
+			sort.Strings(files)
+			if a.store != nil || len(a.filesPending(files)) == 0 {
+				return nil
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("Timed out waiting for config to be extracted to %s", a.SecretsDir)
+		}
+		time.Sleep(waitPollInterval)
+	}
+}
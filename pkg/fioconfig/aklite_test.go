@@ -0,0 +1,47 @@
+package fioconfig
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAkliteCallback(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		waitPollInterval = 10 * time.Millisecond
+		app.settings.ControlSocket = filepath.Join(tempdir, "run", "control.sock")
+
+		// Nothing to do, and no daemon to ask, doesn't fail the update
+		require.Nil(t, app.AkliteCallback("install-pre", ""))
+		require.Nil(t, app.AkliteCallback("install-post", "OK"))
+
+		stop, err := app.StartControlSocket()
+		require.Nil(t, err)
+		defer stop()
+		require.Nil(t, app.AkliteCallback("install-post", "NEEDS_COMPLETION"))
+		require.Len(t, app.CheckInRequests(), 0)
+		require.Nil(t, app.AkliteCallback("install-final-post", "OK"))
+		select {
+		case <-app.CheckInRequests():
+		case <-time.After(time.Second):
+			t.Fatal("No check-in requested")
+		}
+
+		app.settings.AkliteWaitForConfig = "50ms"
+		start := time.Now()
+		require.Nil(t, app.AkliteCallback("install-pre", ""))
+		require.True(t, time.Since(start) >= 50*time.Millisecond)
+
+		require.Nil(t, app.Extract())
+		require.Nil(t, app.WaitForExtraction(0))
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "foo")))
+		require.NotNil(t, app.WaitForExtraction(0))
+
+		app.settings.AkliteWaitForConfig = "bogus"
+		require.NotNil(t, app.AkliteCallback("install-pre", ""))
+	})
+}
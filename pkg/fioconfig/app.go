@@ -0,0 +1,1520 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+	"golang.org/x/sys/unix"
+)
+
+const onChangedForceExit = 123
+
+var NotModifiedError = errors.New("Config unchanged on server")
+var CryptoSelfTestError = errors.New("Crypto self-test failed, check the device's key configuration")
+
+// MissingConfigKeyError is returned when a setting fioconfig needs isn't in
+// sota.toml
+var MissingConfigKeyError = errors.New("Missing setting in sota.toml")
+
+// CryptoInitError is returned when the device's key and certificate can't
+// be loaded. The IdentityError it comes in has the reason.
+var CryptoInitError = errors.New("Unable to load device identity")
+
+type IdentityError struct {
+	Err error
+}
+
+func (e *IdentityError) Error() string {
+	return fmt.Sprintf("%s: %s", CryptoInitError, e.Err)
+}
+
+func (e *IdentityError) Unwrap() error {
+	return e.Err
+}
+
+func (e *IdentityError) Is(target error) bool {
+	return target == CryptoInitError
+}
+
+type CryptoHandler interface {
+	Decrypt(value string) ([]byte, error)
+	Close()
+}
+
+// ctxCrypto stops decrypting once its context is done. A PKCS#11 operation
+// already in progress can't be interrupted, but no new ones are started.
+type ctxCrypto struct {
+	CryptoHandler
+	ctx context.Context
+}
+
+func (c ctxCrypto) Decrypt(value string) ([]byte, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.CryptoHandler.Decrypt(value)
+}
+
+func (c ctxCrypto) DecryptAge(content []byte) ([]byte, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return decryptAgeBundle(c.CryptoHandler, content)
+}
+
+type configSnapshot struct {
+	prev ConfigStruct
+	next ConfigStruct
+}
+
+type App struct {
+	EncryptedConfig string
+	SecretsDir      string
+
+	configUrl      string
+	configUrls     []string // Failover candidates, including configUrl
+	unsafeHandlers bool
+	extractOnly    []string // Patterns of the files Extract is limited to
+	sota           *toml.Tree
+	sotaConfig     string
+	settings       Settings
+	deviceUuid     string // The common name of the client certificate
+	longPoll       time.Duration
+	// When the server last ignored a long-poll request
+	longPollIgnored time.Time
+
+	// Cached between check-ins when reuseClient is set
+	reuseClient bool
+	client      *http.Client
+	crypto      CryptoHandler
+	// Set when the trusted roots change so the client gets re-created
+	reloadClient bool
+	// A fio-remote-debug request waiting for the next check-in to finish
+	remoteDebug []byte
+	// When CheckDriftIfDue last ran
+	lastDriftCheck time.Time
+	// Set by StartDriftWatch
+	driftWatch *driftWatch
+	// Progress of the registered init functions
+	initStatus map[string]*initStatus
+
+	// Set by the options given to NewApp
+	configUrlOverride string
+	httpClient        *http.Client
+	cryptoHandler     CryptoHandler
+	storeOverride     SecretStore
+
+	// Where files are extracted to when it's not SecretsDir
+	store SecretStore
+	// Where files in SecretsDir are copied to, see kubernetes_mirror
+	mirror SecretStore
+
+	// Set when extracted files are relabeled for SELinux
+	fileContexts *fileContexts
+	// Unsubscribes sendWebhooks
+	stopWebhooks func()
+
+	subscribers subscribers
+	metrics     metrics
+	// What the running check-in extracted, for the check-in log
+	checkInReport *ExtractReport
+	// Who changed the config being applied and why, see change.go
+	change *ChangeInfo
+	// Check-ins asked for over the control socket
+	checkInRequests chan struct{}
+	// An interrupted config download to resume. See downloadConfig
+	partialDownload *partialDownload
+	// The system time wasn't trustworthy at the last check-in. See clock.go
+	clockUntrusted bool
+
+	exitFunc func(int)
+}
+
+func tomlGet(tree *toml.Tree, key string) (string, error) {
+	val, _ := tree.GetDefault(key, "").(string)
+	if len(val) == 0 {
+		return "", fmt.Errorf("%w: %s", MissingConfigKeyError, key)
+	}
+	return val, nil
+}
+
+func tomlAssertVal(tree *toml.Tree, key string, allowed []string) (string, error) {
+	val, err := tomlGet(tree, key)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range allowed {
+		if val == v {
+			return val, nil
+		}
+	}
+	fmt.Println("ERROR: Invalid value", val, "in sota.toml for", key)
+	return val, nil
+}
+
+// sota.toml has slot id's as "01". We need to turn that into []byte{1}
+func idToBytes(id string) []byte {
+	bytes := []byte(id)
+	start := -1
+	for idx, char := range bytes {
+		bytes[idx] = char - byte('0')
+		if bytes[idx] != 0 && start == -1 {
+			start = idx
+		}
+	}
+	//strip off leading 0's
+	return bytes[start:]
+}
+
+// createClient loads the device's identity from sota.toml and returns an
+// HTTP client for the device gateway along with the crypto handler for its
+// key. The crypto handler must be closed by the caller.
+func createClient(sota *toml.Tree) (*http.Client, CryptoHandler, error) {
+	if _, err := tomlAssertVal(sota, "tls.ca_source", []string{"file"}); err != nil {
+		return nil, nil, err
+	}
+	source, err := tomlAssertVal(sota, "tls.pkey_source", identityProviderNames())
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := tomlAssertVal(sota, "tls.cert_source", []string{source}); err != nil {
+		return nil, nil, err
+	}
+	provider, ok := identityProviders[source]
+	if !ok {
+		return nil, nil, &IdentityError{fmt.Errorf("Unsupported tls.pkey_source: %s", source)}
+	}
+	cert, crypto, err := provider(sota)
+	if err != nil {
+		return nil, nil, &IdentityError{err}
+	}
+	client, err := newDeviceClient(sota, cert)
+	if err != nil {
+		crypto.Close()
+		return nil, nil, err
+	}
+	return client, crypto, nil
+}
+
+func newDeviceClient(sota *toml.Tree, cert tls.Certificate) (*http.Client, error) {
+	caFile, err := tomlGet(sota, "import.tls_cacert_path")
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read CA certificate: %w", err)
+	}
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+	appendCaBundle(caCertPool, sota)
+
+	settings, err := loadSettings(sota)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caCertPool,
+		Time:         tlsTime,
+	}
+	if err := applyTlsSettings(tlsConfig, settings); err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		Proxy:             proxyFunc(settings),
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Timeout: defaultHttpTimeout, Transport: transport}
+	if err := applyTransportSettings(client, transport, settings); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewApp creates an App for the sota.toml in the given directory
+func NewApp(sota_config string, opts ...Option) (*App, error) {
+	sota, err := toml.LoadFile(filepath.Join(sota_config, "sota.toml"))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode sota.toml: %w", err)
+	}
+	app := App{
+		EncryptedConfig: filepath.Join(sota_config, "config.encrypted"),
+		SecretsDir:      "/var/run/secrets",
+		sota:            sota,
+		sotaConfig:      sota_config,
+		exitFunc:        os.Exit,
+		checkInRequests: make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(&app)
+	}
+
+	// Assert we have a sane configuration
+	client, crypto, err := app.loadClient(sota)
+	if err != nil {
+		return nil, err
+	}
+	warnUnknownSettings(sota)
+	app.deviceUuid = deviceId(client)
+	var writeOpts writeOptions
+	app.settings, err = loadSettings(sota)
+	if err == nil {
+		err = assertFips(app.settings, crypto)
+	}
+	if err == nil {
+		writeOpts, err = newWriteOptions(app.settings)
+	}
+	if err == nil {
+		_, err = parseApplyWindows(app.settings.ApplyWindows)
+	}
+	if err == nil {
+		err = createStateDirs(app.settings)
+	}
+	app.releaseCrypto(crypto)
+	if err != nil {
+		return nil, err
+	}
+	currentWriteOptions.Store(writeOpts)
+	app.setEncryptedConfig(app.settings)
+	if app.store, app.mirror, err = app.newStores(app.settings); err != nil {
+		return nil, err
+	}
+	app.setConfigUrls()
+	app.initFileContexts()
+	if app.settings.LockMemory {
+		if err := LockMemory(); err != nil {
+			logger.Printf("WARNING: %s", err)
+		}
+	}
+	app.setWebhooks()
+
+	return &app, nil
+}
+
+// loadClient is createClient with the HTTP client and crypto handler given
+// to NewApp used in place of the ones from sota.toml.
+func (a *App) loadClient(sota *toml.Tree) (*http.Client, CryptoHandler, error) {
+	if a.httpClient != nil && a.cryptoHandler != nil {
+		return a.httpClient, a.cryptoHandler, nil
+	}
+	client, crypto, err := createClient(sota)
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.httpClient != nil {
+		client = a.httpClient
+	}
+	if a.cryptoHandler != nil {
+		crypto.Close()
+		crypto = a.cryptoHandler
+	}
+	return client, crypto, nil
+}
+
+// releaseCrypto closes a crypto handler returned by loadClient unless it
+// belongs to the caller of NewApp.
+func (a *App) releaseCrypto(crypto CryptoHandler) {
+	if a.cryptoHandler == nil {
+		crypto.Close()
+	}
+}
+
+// configUrls returns the config endpoints to use in order of preference.
+// Sites with an on-prem mirror of the device gateway can list several
+// servers under `fioconfig.servers`.
+func configUrls(sota *toml.Tree, settings Settings) []string {
+	if url := os.Getenv("CONFIG_URL"); len(url) > 0 {
+		return []string{url}
+	}
+	var urls []string
+	for _, server := range settings.Servers {
+		if len(server) > 0 {
+			urls = append(urls, server+"/config")
+		}
+	}
+	if len(urls) == 0 {
+		url := sota.GetDefault("tls.server", "https://ota-lite.foundries.io:8443").(string)
+		urls = append(urls, url+"/config")
+	}
+	return urls
+}
+
+// setConfigUrls loads the config endpoints and picks the one that last
+// worked if it's still in the list
+func (a *App) setConfigUrls() {
+	if len(a.configUrlOverride) > 0 {
+		a.configUrls = []string{a.configUrlOverride}
+		a.configUrl = a.configUrlOverride
+		return
+	}
+	a.configUrls = configUrls(a.sota, a.settings)
+	a.configUrl = a.configUrls[0]
+	if server := a.loadCheckInState().Server; len(server) > 0 {
+		for _, url := range a.configUrls {
+			if url == server {
+				a.configUrl = url
+			}
+		}
+	}
+}
+
+// getConfig downloads the config trying each server once, starting with the
+// one that last worked. If none of them can handle the request, fall back to
+// retrying the preferred server.
+func (a *App) getConfig(ctx context.Context, client *http.Client, headers map[string]string) (*httpRes, error) {
+	if len(a.configUrls) > 1 {
+		urls := []string{a.configUrl}
+		for _, url := range a.configUrls {
+			if url != a.configUrl {
+				urls = append(urls, url)
+			}
+		}
+		for _, url := range urls {
+			res, err := a.downloadConfigOnce(ctx, client, url, headers)
+			if err == nil && res.StatusCode < 500 {
+				if url != a.configUrl {
+					LogEvent(EventServerFailover, "Failing over to config server %s", url)
+					a.configUrl = url
+					// The new server may support long-polling
+					a.longPollIgnored = time.Time{}
+					state := a.loadCheckInState()
+					state.Server = url
+					if err := a.saveCheckInState(state); err != nil {
+						LogEvent(EventStateSaveFailed, "Unable to save check-in state: %s", err)
+					}
+				}
+				return res, nil
+			}
+			LogEventWith(EventServerUnreachable, LogFields{"url": url}, "Unable to get config from %s, trying next server", url)
+		}
+	}
+	return a.downloadConfig(ctx, client, a.configUrl, headers)
+}
+
+// Reload re-reads sota.toml so that changes like a new server URL or new
+// certificate paths take effect without restarting the daemon. The secret
+// store, Kubernetes mirror, SELinux contexts and webhooks are set up again
+// too. Settings used by the daemon's listeners, like metrics_listen,
+// control_socket, query_socket and lock_memory, need a restart, and
+// mqtt_broker needs StartMqttNotifications to be called again. The current
+// configuration is kept if the new one can't be loaded.
+func (a *App) Reload() error {
+	sota, err := toml.LoadFile(filepath.Join(a.sotaConfig, "sota.toml"))
+	if err != nil {
+		return fmt.Errorf("Unable to decode sota.toml: %w", err)
+	}
+	_, crypto, err := a.loadClient(sota)
+	if err != nil {
+		return err
+	}
+	a.releaseCrypto(crypto)
+	warnUnknownSettings(sota)
+	settings, err := loadSettings(sota)
+	if err != nil {
+		return err
+	}
+	if _, err := parseApplyWindows(settings.ApplyWindows); err != nil {
+		return err
+	}
+	writeOpts, err := newWriteOptions(settings)
+	if err != nil {
+		return err
+	}
+	store, mirror := a.store, a.mirror
+	if storeSettingsChanged(a.settings, settings) {
+		if store, mirror, err = a.newStores(settings); err != nil {
+			return err
+		}
+	}
+	if err := createStateDirs(settings); err != nil {
+		return err
+	}
+
+	currentWriteOptions.Store(writeOpts)
+	a.setEncryptedConfig(settings)
+	a.sota = sota
+	a.settings = settings
+	a.store, a.mirror = store, mirror
+	a.initFileContexts()
+	a.setWebhooks()
+	a.setConfigUrls()
+	a.closeClient()
+	return nil
+}
+
+// EnableClientReuse keeps the HTTP client and crypto handler open between
+// check-ins rather than re-creating them each time, which is expensive with
+// slow HSMs, and allows keep-alive connections to the server. They are
+// re-created after a failed check-in. Close must be called when done.
+func (a *App) EnableClientReuse() {
+	a.reuseClient = true
+}
+
+func (a *App) getClient() (*http.Client, CryptoHandler, error) {
+	if a.client == nil {
+		client, crypto, err := a.loadClient(a.sota)
+		if err != nil {
+			return nil, nil, err
+		}
+		a.client, a.crypto = client, crypto
+		if t, ok := a.client.Transport.(*http.Transport); ok && a.reuseClient {
+			t.DisableKeepAlives = false
+		}
+	}
+	return a.client, a.crypto, nil
+}
+
+func (a *App) closeClient() {
+	if a.client != nil {
+		a.client.CloseIdleConnections()
+		a.releaseCrypto(a.crypto)
+		a.client = nil
+		a.crypto = nil
+	}
+}
+
+// Close releases the HTTP client and crypto handler kept by EnableClientReuse
+func (a *App) Close() {
+	a.closeClient()
+	closePkcs11Contexts()
+}
+
+// The mode config files are written with
+const secretFileMode os.FileMode = 0o640
+
+// Do an atomic write to the file which prevents race conditions for a reader.
+// Don't worry about writer synchronization as there is only one writer to these files.
+func safeWrite(name string, data []byte) error {
+	return safeWriteMeta(name, data, defaultFileMeta)
+}
+
+// safeWriteMeta is safeWrite with the permissions and owner set before the
+// file is moved into place, so it's never readable by the wrong user.
+func safeWriteMeta(name string, data []byte, meta fileMeta) error {
+	opts := getWriteOptions()
+	tmpfile := opts.tmpPath(name)
+	if err := writeTemp(tmpfile, data, meta, opts); err != nil {
+		return fmt.Errorf("Unable to create %s: %w", name, err)
+	}
+	defer os.Remove(tmpfile)
+	err := os.Rename(tmpfile, name)
+	if errors.Is(err, unix.EXDEV) {
+		// tmp_dir is on another filesystem, so copy the file next to name
+		// and rename that instead
+		sibling := name + ".tmp"
+		if err := copyTemp(tmpfile, sibling, meta); err != nil {
+			return fmt.Errorf("Unable to create %s: %w", name, err)
+		}
+		defer os.Remove(sibling)
+		err = os.Rename(sibling, name)
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// writeTemp writes the file that safeWriteMeta renames into place
+func writeTemp(tmpfile string, data []byte, meta fileMeta, opts writeOptions) error {
+	f, err := os.OpenFile(tmpfile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, secretFileMode)
+	if err != nil {
+		return err
+	}
+	err = meta.applyFile(f)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if opts.syncFile || opts.verify {
+		if err1 := f.Sync(); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	if opts.verify && err == nil {
+		err = verifyWritten(f, data)
+	}
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(tmpfile)
+	}
+	return err
+}
+
+// copyTemp copies the temporary file src to dst on another filesystem,
+// synced so the rename that follows can't leave dst empty
+func copyTemp(src, dst string, meta fileMeta) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	f, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, secretFileMode)
+	if err != nil {
+		return err
+	}
+	err = meta.applyFile(f)
+	if err == nil {
+		_, err = io.Copy(f, in)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+func (a *App) extract(ctx context.Context, crypto CryptoHandler, config configSnapshot) (*ExtractReport, error) {
+	report := newExtractReport()
+	report.Change = a.change
+	var dirMode os.FileMode
+	if a.store == nil {
+		if err := a.ensureVolatileSecretsDir(); err != nil {
+			return report, err
+		}
+		st, err := os.Stat(a.SecretsDir)
+		if err != nil {
+			return report, err
+		}
+		dirMode = st.Mode()
+		if err := a.ensureEncryptedSecretsDir(crypto); err != nil {
+			return report, err
+		}
+	}
+
+	state := a.readManifest()
+	applied := state.Files
+	report.prevHashes = make(manifest, len(applied))
+	for fname, hash := range applied {
+		report.prevHashes[fname] = hash
+	}
+	defer func() {
+		if err := a.saveManifest(state); err != nil {
+			LogEvent(EventManifestSaveFailed, "Unable to save manifest: %s", err)
+		}
+	}()
+
+	// Handlers are run once all files are in place so concurrency groups
+	// can run in parallel.
+	var handlers []pendingHandler
+	added := make(map[string]bool)
+	defer func() {
+		if a.settings.DedupeHandlers {
+			handlers = dedupeHandlers(handlers)
+		}
+		results := a.runHandlers(ctx, handlers)
+		for _, result := range results {
+			report.addHandler(result)
+			if result != nil {
+				a.publish(ChangeEvent{Type: ChangeHandlerRun, File: result.File, Handler: result})
+			}
+		}
+		a.queueHandlerRetries(handlers, results, applied)
+		for _, fname := range report.Applied {
+			if added[fname] {
+				a.publish(ChangeEvent{Type: ChangeFileAdded, File: fname})
+			} else {
+				a.publish(ChangeEvent{Type: ChangeFileChanged, File: fname})
+			}
+		}
+		for _, fname := range report.Removed {
+			a.publish(ChangeEvent{Type: ChangeFileRemoved, File: fname})
+		}
+		initialSkip := report.Initial && a.settings.SkipInitialHandlers
+		if !initialSkip {
+			for _, result := range a.runNamespaceHandlers(ctx, report) {
+				report.addHandler(result)
+				a.publish(ChangeEvent{Type: ChangeHandlerRun, File: result.File, Handler: result})
+			}
+		}
+		if len(report.Applied)+len(report.Removed) > 0 && !initialSkip {
+			report.AfterExtract = a.runAfterExtract(ctx, report)
+			if report.AfterExtract != nil {
+				a.publish(ChangeEvent{Type: ChangeHandlerRun, Handler: report.AfterExtract})
+			}
+		}
+		report.hashes = applied
+		if err := os.RemoveAll(filepath.Join(a.SecretsDir, prevDirName)); err != nil {
+			logger.Printf("Unable to remove previous config file versions: %s", err)
+		}
+		report.addWarnings()
+		for _, warning := range report.Warnings {
+			logger.Printf("WARNING: %s", warning)
+		}
+		if err := a.saveLastReport(report); err != nil {
+			logger.Printf("Unable to save extraction report: %s", err)
+		}
+	}()
+
+	var err error
+	if config.next, err = a.applyShadow(config.next, report); err != nil {
+		return report, fmt.Errorf("Unable to load local overrides: %w", err)
+	}
+	config = a.applyProtected(config, report)
+	for _, fname := range report.Protected {
+		delete(applied, fname) // Not managed by fioconfig anymore
+	}
+	if config.next, err = a.applyNamespaces(config.next); err != nil {
+		return report, err
+	}
+	secrets := a.newLocalSecrets(crypto)
+	defer secrets.close()
+	if config.next, err = a.resolveLocalSecrets(config.next, secrets, report); err != nil {
+		return report, err
+	}
+
+	// Check every name before writing anything so a bad one can't leave
+	// the config half applied. With partial_extract, bad files are left
+	// out instead.
+	for fname := range config.next {
+		err := validateFileName(fname)
+		if err == nil {
+			err = a.checkFilePath(fname)
+		}
+		if err == nil {
+			err = a.checkFileSize(fname, config.next[fname])
+		}
+		if err == nil {
+			err = validateCredential(config.next[fname].Credential)
+		}
+		if err == nil {
+			err = validateUbootEnv(config.next[fname])
+		}
+		if err == nil {
+			err = validateSSHKeys(config.next[fname])
+		}
+		if err == nil {
+			err = validateSystemSetting(fname, config.next[fname])
+		}
+		if err == nil {
+			err = a.validateTargetPath(config.next[fname])
+		}
+		if err == nil {
+			err = a.validateSymlink(fname, config.next[fname])
+		}
+		if err != nil {
+			report.fail(fname, err)
+			if !a.settings.PartialExtract {
+				return report, err
+			}
+		}
+	}
+	config.next = withoutFailed(config.next, report)
+
+	if err := checkEnvFiles(config.next); err != nil {
+		return report, err
+	}
+	if err := checkTargetPaths(config.next); err != nil {
+		return report, err
+	}
+
+	if config.next, err = a.renderTemplates(config.next, secrets, report); err != nil {
+		return report, err
+	}
+	// Templates are checked as they're rendered
+	if err := a.checkSchemas(config.next, report); err != nil {
+		return report, err
+	}
+	config.next = withoutFailed(config.next, report)
+
+	order, err := applyOrder(config.next)
+	if err != nil {
+		return report, err
+	}
+
+	migrated, err := a.migrateSecretsDir(state.SecretsDir, applied)
+	if err != nil {
+		return report, err
+	}
+
+	report.Initial = a.isInitialExtract(config.next)
+
+	txn, err := a.beginTxn(dirMode)
+	if err != nil {
+		return report, err
+	}
+	defer txn.close()
+
+	workers := 1
+	if a.store == nil && a.settings.ExtractWorkers > 1 {
+		// SecretStore implementations needn't be safe for concurrent use
+		workers = a.settings.ExtractWorkers
+	}
+	staged := stageFiles(ctx, txn, order, config.next, applied, workers, a.settings.PartialExtract)
+
+	all_fname := make(map[string]bool)
+	var changed []string
+	for i, fname := range order {
+		cfgFile := config.next[fname]
+		LogEventWith(EventFileExtracted, LogFields{"file": fname}, "Extracting %s", fname)
+		all_fname[fname] = true
+		if a.settings.ScanSecrets {
+			a.warnIfSecret(fname, cfgFile)
+		}
+		updated, err := staged[i].updated, staged[i].err
+		if err != nil {
+			report.fail(fname, err)
+			if a.settings.PartialExtract {
+				continue
+			}
+			return report, err
+		}
+		if updated || migrated[fname] {
+			changed = append(changed, fname)
+			if _, ok := applied[fname]; !ok {
+				added[fname] = true
+			}
+		}
+	}
+
+	// Now, watch for file removals (compare with a previous version if present)
+	var removed, released []string
+	for _, fname := range sortedNames(config.prev) {
+		if _, ok := all_fname[fname]; ok {
+			continue
+		}
+		if _, ok := report.Failed[fname]; ok {
+			continue // Keep the previous version of a file that failed
+		}
+		err := validateFileName(fname)
+		if err == nil {
+			err = a.checkFilePath(fname)
+		}
+		if err != nil {
+			LogEvent(EventExtractFailed, "Not removing %s: %s", fname, err)
+			continue
+		}
+		if a.keepOnRemove(fname, config.prev[fname]) {
+			LogEventWith(EventFileReleased, LogFields{"file": fname}, "Keeping %s, it's no longer managed", fname)
+			released = append(released, fname)
+			continue
+		}
+		LogEventWith(EventFileRemoved, LogFields{"file": fname}, "Removing %s", fname)
+		txn.remove(fname)
+		removed = append(removed, fname)
+	}
+
+	config.next = withoutFailed(config.next, report)
+	if len(released) > 0 {
+		// So nothing derived from them, like credentials, is removed
+		// either
+		prev := make(ConfigStruct, len(config.prev))
+		for fname, cfgFile := range config.prev {
+			prev[fname] = cfgFile
+		}
+		for _, fname := range released {
+			delete(prev, fname)
+		}
+		config.prev = prev
+	}
+
+	if a.hasVerifiers() {
+		// Only the filesystem store allows verifiers, see beginTxn
+		dir := filepath.Join(txn.(*extractTxn).dir, "verify")
+		if err := a.verify(dir, config.next, changed); err != nil {
+			if rejected, ok := err.(*ConfigRejectedError); ok {
+				LogEvent(EventConfigRejected, "ERROR: %s", err)
+				report.Code = EventConfigRejected
+				report.Rejected = rejected.Error()
+			}
+			return report, err
+		}
+	}
+
+	if err := txn.commit(); err != nil {
+		var txnErr *TxnError
+		if errors.As(err, &txnErr) {
+			report.fail(txnErr.File, err)
+		}
+		return report, err
+	}
+	a.fixNamespaceDirs(report)
+
+	// The new files are in place, so record them and let handlers know
+	for fname, cfgFile := range config.next {
+		content, _ := cfgFile.content() // stage already checked it decodes
+		applied[fname] = sha256Hex(content)
+		if fname == caBundleConfigFile {
+			if err := a.updateCaBundle(content); err != nil {
+				LogEvent(EventCaBundleRejected, "ERROR: Not trusting new CA bundle: %s", err)
+				report.fail(fname, err)
+			}
+		}
+		zeroize(content)
+	}
+	for _, fname := range changed {
+		cfgFile := config.next[fname]
+		if fname == remoteDebugConfigFile {
+			content, _ := cfgFile.content()
+			a.queueRemoteDebug(content)
+		}
+		report.Applied = append(report.Applied, fname)
+		h := a.newPendingHandler(fname, cfgFile)
+		if report.Initial && (a.settings.SkipInitialHandlers || cfgFile.SkipInitialHandler) {
+			if cfgFile.hasActions() {
+				logger.Printf("Not running handlers for %s on initial extraction", fname)
+			}
+			continue
+		}
+		if ftxn, ok := txn.(*extractTxn); ok && len(cfgFile.OnChanged) > 0 {
+			if err := a.keepPrevious(ftxn, &h, len(handlers)); err != nil {
+				logger.Printf("Unable to keep previous version of %s for its handler: %s", fname, err)
+			}
+		}
+		handlers = append(handlers, h)
+	}
+	for _, fname := range removed {
+		cfgFile := config.prev[fname]
+		delete(applied, fname)
+		if fname == caBundleConfigFile {
+			if err := a.updateCaBundle(nil); err != nil {
+				logger.Printf("Unable to remove CA bundle: %s", err)
+			}
+		}
+		report.Removed = append(report.Removed, fname)
+		h := a.newPendingHandler(fname, cfgFile)
+		h.content = nil // There's nothing to give a removed file's handler
+		handlers = append(handlers, h)
+	}
+	for _, fname := range released {
+		delete(applied, fname)
+		report.Released = append(report.Released, fname)
+	}
+	a.runPostCommitSteps(ctx, config, changed, removed, report)
+	if len(a.extractOnly) == 0 {
+		state.Orphans = orphans(applied, config.next, report)
+		report.Orphans = state.Orphans
+	}
+	if config.prev == nil || a.store != nil {
+		return report, nil
+	}
+	if err := DeleteEmptyDirs(a.SecretsDir); err != nil {
+		LogEvent(EventEmptyDirCleanFailed, "ERROR removing empty directories: %s", err)
+	}
+	return report, nil
+}
+
+func (a *App) Extract() error {
+	return a.ExtractContext(context.Background())
+}
+
+// ExtractContext is Extract with a context that stops decryption and kills
+// on-changed commands when it's done.
+func (a *App) ExtractContext(ctx context.Context) (err error) {
+	ctx, root := a.startTrace(ctx, "extract")
+	client, crypto, err := a.loadClient(a.sota)
+	defer func() { a.endTrace(root, client, err) }()
+	if err != nil {
+		return err
+	}
+	defer a.releaseCrypto(crypto)
+
+	if state := a.loadCheckInState(); !state.Reverted {
+		a.change = state.Change
+		defer func() { a.change = nil }()
+	}
+	_, decrypt := startSpan(ctx, "decrypt")
+	config, err := a.unmarshallCache(ctxCrypto{crypto, ctx}, a.EncryptedConfig, true)
+	if err == nil {
+		// With the layers as they were last applied
+		var layers *configLayers
+		if layers, err = a.loadLayers(ctx, nil); err == nil && layers != nil {
+			config, err = layers.merge(ctxCrypto{crypto, ctx}, config, layers.prev, true)
+		}
+	}
+	decrypt.finish(err)
+	if err != nil {
+		return err
+	}
+	if config, err = a.filterExtractOnly(config); err != nil {
+		return err
+	}
+	report, err := a.extract(ctx, crypto, configSnapshot{nil, config})
+	a.metrics.recordExtract(report, err)
+	version := a.latestVersion()
+	a.audit(report, version)
+	a.publishExtract(report, err, version)
+	if err != nil {
+		return &ExtractFailure{err}
+	}
+	return report.partialError()
+}
+
+// filterExtractOnly returns the files of config matching WithExtractOnly.
+// Since Extract doesn't remove files, the rest are left as they are.
+func (a *App) filterExtractOnly(config ConfigStruct) (ConfigStruct, error) {
+	if len(a.extractOnly) == 0 {
+		return config, nil
+	}
+	filtered := make(ConfigStruct)
+	for fname, cfgFile := range config {
+		for _, pattern := range a.extractOnly {
+			match, err := path.Match(pattern, fname)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pattern %s: %w", pattern, err)
+			}
+			if match {
+				filtered[fname] = cfgFile
+				break
+			}
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("No config files match %s", strings.Join(a.extractOnly, ", "))
+	}
+	return filtered, nil
+}
+
+func (a *App) runOnChanged(ctx context.Context, h pendingHandler) *HandlerResult {
+	path, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		logger.Printf("Unable to find path to self via /proc/self/exe: %s", err)
+	}
+	fname, onChanged := h.fname, h.cfgFile.OnChanged
+	if len(onChanged) == 0 {
+		return nil
+	}
+	result := &HandlerResult{File: fname, Command: onChanged}
+	ctx, handlerSpan := startSpan(ctx, "on-changed")
+	handlerSpan.set("fioconfig.file", fname)
+	handlerSpan.set("process.command", strings.Join(onChanged, " "))
+	defer func() {
+		handlerSpan.set("process.exit_code", result.ExitCode)
+		var err error
+		if len(result.Error) > 0 {
+			err = errors.New(result.Error)
+		}
+		handlerSpan.finish(err)
+	}()
+	if len(h.also) > 0 {
+		result.Files = append([]string{fname}, h.also...)
+	}
+	if a.handlerAllowed(onChanged) {
+		timeout, err := a.handlerTimeout(h.cfgFile)
+		if err != nil {
+			LogEvent(EventHandlerRejected, "Not running on-change command for %s: %s", fname, err)
+			result.Error = err.Error()
+			result.ExitCode = -1
+			return result
+		}
+		env := a.handlerEnv()
+		if len(h.fullpath) > 0 {
+			configFile, err := a.canonicalConfigFile(h.fullpath)
+			if err != nil {
+				LogEvent(EventHandlerRejected, "Not running on-change command for %s: %s", fname, err)
+				result.Error = err.Error()
+				result.ExitCode = -1
+				return result
+			}
+			env = append(env, "CONFIG_FILE="+configFile)
+		} else {
+			env = append(env, "CONFIG_NAME="+fname)
+		}
+		sotaDir, err := tomlGet(a.sota, "storage.path")
+		if err != nil {
+			LogEvent(EventHandlerRejected, "Not running on-change command for %s: %s", fname, err)
+			result.Error = err.Error()
+			result.ExitCode = -1
+			return result
+		}
+		env = append(env, "SOTA_DIR="+sotaDir)
+		env = append(env, "FIOCONFIG_BIN="+path)
+		if len(h.also) > 0 {
+			env = append(env, "CHANGED_FILES="+strings.Join(result.Files, "\n"))
+		}
+		if len(h.prevFile) > 0 {
+			env = append(env, "CONFIG_FILE_PREV="+h.prevFile)
+			env = append(env, "CONFIG_FILE_DIFF="+h.diffFile)
+		}
+		if a.settings.HandlerSandbox == SandboxHelper {
+			LogEventWith(EventHandlerRun, LogFields{"file": fname}, "Running on-change command for %s via handler helper: %v", fname, onChanged)
+			result.Output, err = a.runViaHelper(ctx, onChanged, h.cfgFile.RunAs, env, h.content, timeout)
+		} else {
+			cmd, cmdErr := a.handlerCommand(h.cfgFile, env, timeout)
+			if cmdErr != nil {
+				LogEvent(EventHandlerRejected, "Not running on-change command for %s: %s", fname, cmdErr)
+				result.Error = cmdErr.Error()
+				result.ExitCode = -1
+				return result
+			}
+			if h.content != nil {
+				cmd.Stdin = bytes.NewReader(h.content)
+			}
+			LogEventWith(EventHandlerRun, LogFields{"file": fname}, "Running on-change command for %s: %v", fname, onChanged)
+			result.Output, err = runCaptured(ctx, cmd, timeout)
+		}
+		if err != nil {
+			LogEventWith(EventHandlerFailed, LogFields{"file": fname}, "Unable to run command: %v", err)
+			result.Error = err.Error()
+			result.ExitCode = -1
+			result.TimedOut = errors.Is(err, errHandlerTimeout)
+			if code, ok := commandExitCode(err); ok {
+				result.ExitCode = code
+				if code == onChangedForceExit {
+					a.exitFunc(onChangedForceExit)
+				}
+			}
+		}
+	} else {
+		LogEvent(EventHandlerUnsafe, "Skipping unsafe on-change command for %s: %v.", fname, onChanged)
+		result.Skipped = true
+	}
+	return result
+}
+
+// readConfigRes checks the signature of a full config as it was served and
+// decodes its payload to JSON. It returns the version the config was signed
+// with, if any.
+func (a *App) readConfigRes(res *httpRes) (uint64, error) {
+	var signedVersion uint64
+	if res.StatusCode == 200 {
+		var err error
+		if signedVersion, err = a.verifyConfigSignature(res); err != nil {
+			return 0, err
+		}
+	}
+	return signedVersion, decodePayload(res)
+}
+
+// prepareExtract checks that config can be applied now, whether it's a new
+// config from the server or the device's config with changed layers. The
+// config isn't saved when it can't, so it's offered again at the next
+// check-in. encrypted is what will be written to the config cache.
+func (a *App) prepareExtract(ctx context.Context, client *http.Client, config configSnapshot, windows applyWindows, encrypted []byte) error {
+	if hold, err := a.HoldStatus(); err != nil {
+		return err
+	} else if hold != nil {
+		return a.holdConfig(ctx, client, hold, config.next)
+	}
+	if len(windows) > 0 && !windows.open(time.Now()) {
+		if err := a.deferConfig(ctx, client, windows, config.next); err != nil {
+			return err
+		}
+	}
+	return a.checkDiskSpace(config.next, encrypted)
+}
+
+func (a *App) checkin(ctx context.Context, client *http.Client, crypto CryptoHandler) error {
+	// So the config requested next already has them
+	a.publishFiles(client, crypto)
+
+	ctx = withResponseLimit(ctx, a.maxConfigSize())
+	crypto = ctxCrypto{crypto, ctx}
+	if err := a.recoverUnconfirmed(ctx, client, crypto); err != nil {
+		return err
+	}
+	headers := make(map[string]string)
+
+	if trusted := a.clockTrusted(time.Now()); trusted == a.clockUntrusted {
+		a.clockUntrusted = !trusted
+		if a.clockUntrusted {
+			LogEvent(EventClockUntrusted, "System time %s is before %s, deferring time-based checks until it's set",
+				time.Now().UTC().Format(time.RFC3339), a.clockFloor().UTC().Format(time.RFC3339))
+		} else {
+			logger.Printf("System time is now trusted")
+		}
+	}
+
+	addDeviceHeaders(a.sota, headers)
+	tags := headers["x-ats-tags"]
+
+	var state checkInState
+	if fi, err := os.Stat(a.EncryptedConfig); err == nil {
+		// Don't pull it down unless we need to
+		state = a.loadCheckInState()
+		// State saved before tags were recorded has none, and the server
+		// answering the next check-in says whether it's current for them
+		if len(state.Tags) > 0 && state.Tags != tags {
+			LogEvent(EventTagsChanged, "Device tags changed from %q to %q, fetching their config", state.Tags, tags)
+		} else {
+			if len(state.ETag) > 0 {
+				headers["If-None-Match"] = state.ETag
+				// Signatures only cover full configs as they're served, and
+				// deltas only apply to a JSON cache
+				if !state.Reverted && state.Patchable && len(a.settings.ConfigSigningKeys) == 0 {
+					headers["A-IM"] = deltaIM
+				}
+			}
+			if len(state.LastModified) > 0 {
+				headers["If-Modified-Since"] = state.LastModified
+			} else if !a.clockUntrusted {
+				headers["If-Modified-Since"] = fi.ModTime().UTC().Format(time.RFC1123)
+			}
+		}
+	}
+
+	longPoll := a.LongPolling()
+	if longPoll {
+		headers["Prefer"] = fmt.Sprintf("wait=%d", int(a.longPoll.Seconds()))
+		// Give the server time to hold the request open
+		if client.Timeout > 0 {
+			lpClient := *client
+			lpClient.Timeout += a.longPoll
+			client = &lpClient
+		}
+	}
+
+	headers["Accept"] = acceptPayloads
+	if schemes := supportedSchemes(crypto); len(schemes) > 0 {
+		headers[encryptionSchemesHeader] = schemes
+	}
+	if vpn := a.VpnStatus(ctx); vpn != nil {
+		headers["X-Fio-Vpn"] = vpn.header()
+	}
+	fetchCtx, fetch := startSpan(ctx, "http.fetch")
+	fetch.set("http.url", a.configUrl)
+	res, err := a.getConfig(fetchCtx, client, headers)
+	if res != nil {
+		fetch.set("http.status_code", res.StatusCode)
+	}
+	fetch.finish(err)
+	if err != nil {
+		// Unable to attempt request
+		err = classifyTlsError(err, client, time.Now())
+		var tlsErr *TlsError
+		if ctx.Err() == nil && !errors.As(err, &tlsErr) && !errors.Is(err, PayloadTooLargeError) {
+			err = &UnreachableError{a.configUrl, err}
+		}
+		return err
+	}
+	a.debugf("GET %s returned HTTP_%d", a.configUrl, res.StatusCode)
+	signedVersion, err := a.readConfigRes(res)
+	if err != nil {
+		return err
+	}
+	if longPoll && len(res.Header.Get("Preference-Applied")) == 0 {
+		LogEvent(EventLongPollUnsupported, "Server does not support long-polling, falling back to regular check-ins")
+		a.longPollIgnored = time.Now()
+	}
+
+	if res.StatusCode == 226 {
+		body, err := a.applyDelta(res, state.ETag)
+		if err == nil && len(a.settings.ConfigSigningKeys) > 0 {
+			err = errors.New("Config deltas can't be verified against config_signing_keys")
+		}
+		if err != nil {
+			LogEvent(EventDeltaFailed, "Unable to apply config delta, downloading full config: %s", err)
+			full := make(map[string]string, len(headers))
+			for k, v := range headers {
+				full[k] = v
+			}
+			for _, k := range []string{"If-None-Match", "If-Modified-Since", "A-IM", "Prefer"} {
+				delete(full, k)
+			}
+			if res, err = a.downloadConfig(ctx, client, a.configUrl, full); err != nil {
+				return err
+			}
+			if signedVersion, err = a.readConfigRes(res); err != nil {
+				return err
+			}
+		} else {
+			res.StatusCode = 200
+			res.Body = body
+		}
+	}
+
+	if res.StatusCode == 200 {
+		change := changeInfo(res)
+		msg := fmt.Sprintf("Downloaded new config from %s", a.configUrl)
+		if change != nil {
+			msg += ", " + change.String()
+		}
+		LogEventWith(EventConfigDownloaded, change.logFields(LogFields{"url": a.configUrl, "status": res.StatusCode, "etag": res.Header.Get("ETag")}),
+			"%s", msg)
+		if err := checkSignedVersion(signedVersion, a.loadCheckInState().SignedVersion); err != nil {
+			return err
+		}
+		a.change = change
+		defer func() { a.change = nil }()
+		var config configSnapshot
+		_, decrypt := startSpan(ctx, "decrypt")
+		config.next, err = UnmarshallBuffer(crypto, res.Body, true)
+		decrypt.set("fioconfig.files", len(config.next))
+		decrypt.finish(err)
+		if err != nil {
+			return err
+		}
+		if config.prev, err = a.unmarshallCache(crypto, a.EncryptedConfig, false); err != nil {
+			var perr *os.PathError
+			if !errors.As(err, &perr) || !os.IsNotExist(perr) {
+				LogEvent(EventPrevConfigUnusable, "Unable to load previous config version: %s", err)
+				return err
+			}
+		}
+		layers, err := a.loadLayers(ctx, client)
+		if err != nil {
+			return err
+		}
+		if config, err = layers.apply(crypto, config); err != nil {
+			return err
+		}
+		sha := sha256Hex(res.Body)
+		if a.confirmReverted(sha) {
+			a.debugf("Config on server is the one that was reverted, not applying it again")
+			return ConfigRevertedError
+		}
+		if err = a.prepareExtract(ctx, client, config, a.currentApplyWindows(res), res.Body); err != nil {
+			return err
+		}
+
+		report, err := a.extract(ctx, crypto, config)
+		a.metrics.recordExtract(report, err)
+		a.checkInReport = report
+		// This is the version recordHistory gives it
+		version := a.latestVersion() + 1
+		a.audit(report, version)
+		a.publishExtract(report, err, version)
+		if err != nil {
+			err = &ExtractFailure{err}
+		}
+		// The server always hears about rejections since the device will
+		// keep running its previous config.
+		if a.settings.ReportStatus || len(report.Rejected) > 0 || (a.settings.PartialExtract && len(report.Failed) > 0) {
+			a.reportStatus(ctx, client, report)
+		}
+		if err != nil {
+			return err
+		}
+		a.clearPending()
+		if err = a.awaitConfirmation(ctx, client, crypto, config, res.Body, sha); err != nil {
+			return err
+		}
+		if err = a.writeCache(a.EncryptedConfig, res.Body); err != nil {
+			return err
+		}
+
+		modtime, err := time.Parse(time.RFC1123, res.Header.Get("Date"))
+		if err != nil {
+			logger.Printf("Unable to get modtime of config file, defaulting to 'now': %s", err)
+			modtime = time.Now()
+		}
+		if err = os.Chtimes(a.EncryptedConfig, modtime, modtime); err != nil {
+			return fmt.Errorf("Unable to set modified time %s - %w", a.EncryptedConfig, err)
+		}
+		if err = a.saveLayers(layers); err != nil {
+			return fmt.Errorf("Unable to save config layers: %w", err)
+		}
+		state = checkInState{
+			ETag:          res.Header.Get("ETag"),
+			LastModified:  res.Header.Get("Last-Modified"),
+			Server:        a.configUrl,
+			Patchable:     isJsonObject(res.Body),
+			Tags:          tags,
+			Change:        change,
+			SignedVersion: signedVersion,
+		}
+		if err = a.saveCheckInState(state); err != nil {
+			LogEvent(EventStateSaveFailed, "Unable to save check-in state: %s", err)
+		}
+		if err = a.recordHistory(res.Body, config.next, state); err != nil {
+			LogEvent(EventHistorySaveFailed, "Unable to record config history: %s", err)
+		}
+		a.runRemoteDebug(client)
+		return report.partialError()
+	} else if res.StatusCode == 304 {
+		LogEventWith(EventConfigNotModified, LogFields{"url": a.configUrl, "status": res.StatusCode}, "Config on server has not changed")
+		if state.Tags != tags {
+			state.Tags = tags
+			if err := a.saveCheckInState(state); err != nil {
+				LogEvent(EventStateSaveFailed, "Unable to save check-in state: %s", err)
+			}
+		}
+		a.authSucceeded(state)
+		layers, err := a.loadLayers(ctx, client)
+		if err != nil {
+			return err
+		}
+		if layers.changed() {
+			return a.applyLayers(ctx, client, crypto, layers)
+		}
+		return NotModifiedError
+	} else if res.StatusCode == 204 {
+		LogEvent(EventNoConfig, "Device has no config defined on server")
+		a.authSucceeded(a.loadCheckInState())
+		return NoConfigError
+	} else if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return a.authFailed(res)
+	} else if res.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitedError{parseRetryAfter(res.Header.Get("Retry-After"), time.Now())}
+	}
+	err = fmt.Errorf("Unable to get %s - HTTP_%d: %s", a.configUrl, res.StatusCode, res.String())
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", DeviceNotFoundError, err)
+	} else if res.StatusCode >= 500 {
+		return &UnreachableError{a.configUrl, fmt.Errorf("HTTP_%d: %s", res.StatusCode, res.String())}
+	}
+	return err
+}
+
+// How long long-polling stays off after the server ignores it, in case
+// it's upgraded or a load balancer sends us to one that supports it
+var longPollRetry = time.Hour
+
+// EnableLongPoll asks the server to hold check-in requests open for up to
+// `wait` until a new config is available. The server signals support with a
+// Preference-Applied header. If it's missing, long-polling gets disabled
+// for longPollRetry, or until failing over to another server.
+func (a *App) EnableLongPoll(wait time.Duration) {
+	a.longPoll = wait
+}
+
+// LongPolling returns true while the server is honoring long-poll requests.
+func (a *App) LongPolling() bool {
+	return a.longPoll > 0 && time.Since(a.longPollIgnored) >= longPollRetry
+}
+
+func (a *App) CheckIn() error {
+	return a.CheckInContext(context.Background())
+}
+
+// CheckInContext is CheckIn with a context that cancels requests to the
+// server, decryption, and on-changed commands. Programs embedding fioconfig
+// can use it to stop a check-in or give it a deadline.
+func (a *App) CheckInContext(ctx context.Context) error {
+	ctx, root := a.startTrace(ctx, "check-in")
+	a.checkInReport = nil
+	a.publish(ChangeEvent{Type: ChangeCheckInStarted})
+	client, crypto, err := a.getClient()
+	if err != nil {
+		a.logCheckIn(err)
+		a.endTrace(root, nil, err)
+		return err
+	}
+	err = a.callInitFunctions(client, crypto)
+	if err == nil {
+		err = a.checkin(ctx, client, crypto)
+	}
+	if ctx.Err() == nil {
+		a.retryHandlers(ctx)
+	}
+	a.logCheckIn(err)
+	a.metrics.recordCheckIn(err, client)
+	a.writeMetricsFile()
+	root.set("fioconfig.checkin_result", CheckInResult(err))
+	a.endTrace(root, client, err)
+	// A partially applied config still means the server accepted us
+	ok := err == nil || errors.Is(err, NotModifiedError) || errors.Is(err, PartialExtractError)
+	if ok {
+		LogEventWith(EventCheckInDone, LogFields{"checkin_result": CheckInResult(err)}, "Check-in complete")
+		a.retireOldKey()
+		a.publish(ChangeEvent{Type: ChangeCheckInSucceeded})
+	} else {
+		a.publish(ChangeEvent{Type: ChangeCheckInFailed, Err: err})
+	}
+	if !a.reuseClient || a.reloadClient || !ok {
+		// Start from scratch next time in case the connection or HSM
+		// session is what's broken
+		a.closeClient()
+		a.reloadClient = false
+	}
+	return err
+}
+
+// CheckInResult summarizes the outcome of a check-in that returned err in
+// one word for logs and metrics
+func CheckInResult(err error) string {
+	var rateLimited *RateLimitedError
+	var tlsErr *TlsError
+	switch {
+	case err == nil:
+		return "applied"
+	case errors.Is(err, ConfigHeldError):
+		return "held"
+	case errors.Is(err, ConfigPendingError):
+		return "pending"
+	case errors.Is(err, ConfigRevertedError), errors.Is(err, ConfigNotConfirmedError):
+		return "reverted"
+	case errors.Is(err, NotModifiedError):
+		return "not-modified"
+	case errors.Is(err, PartialExtractError):
+		return "partial"
+	case errors.As(err, &rateLimited):
+		return "rate-limited"
+	case errors.Is(err, AuthFailedError), errors.As(err, &tlsErr):
+		return "auth-failed"
+	case errors.Is(err, ServerUnreachableError):
+		return "unreachable"
+	}
+	return "failed"
+}
+
+// SelfTest performs an encrypt/decrypt round trip with the device's key so
+// that a bad key or slot configuration is reported up front rather than
+// looking like a bad payload from the server during the first check-in.
+func (a *App) SelfTest() error {
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return err
+	}
+	defer a.releaseCrypto(crypto)
+	return selfTest(crypto)
+}
+
+// PublicKey returns the PEM encoded public key config values are encrypted
+// to along with its SHA256 fingerprint.
+func (a *App) PublicKey() ([]byte, string, error) {
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return nil, "", err
+	}
+	defer a.releaseCrypto(crypto)
+	ec, ok := crypto.(*EciesCrypto)
+	if !ok {
+		return nil, "", errors.New("Crypto handler does not expose a public key")
+	}
+	pubPem, err := ec.PublicKeyPem()
+	if err != nil {
+		return nil, "", err
+	}
+	fingerprint, err := ec.Fingerprint()
+	return pubPem, fingerprint, err
+}
+
+// AgeRecipient returns the age recipient offline config bundles for this
+// device are encrypted to.
+func (a *App) AgeRecipient() (string, error) {
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return "", err
+	}
+	defer a.releaseCrypto(crypto)
+	ec, ok := crypto.(*EciesCrypto)
+	if !ok {
+		return "", errors.New("Crypto handler does not expose a public key")
+	}
+	return ec.AgeRecipient()
+}
+
+// CallInitFunctions runs the registered init functions that haven't
+// succeeded yet. It returns an InitError if a required one fails.
+func (a *App) CallInitFunctions() error {
+	client, crypto, err := a.getClient()
+	if err != nil {
+		return err
+	}
+	err = a.callInitFunctions(client, crypto)
+	if !a.reuseClient {
+		a.closeClient()
+	}
+	return err
+}
@@ -0,0 +1,275 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Apply windows limit when new configs are applied, e.g. to a maintenance
+// window at night. Outside of them check-ins carry on and download new
+// configs, but extraction and handlers wait for the next window, and the
+// server is told which changes are pending. Windows are in the device's
+// local time, like "02:00-04:00" or "Mon-Fri 22:00-02:00", and end the
+// next day when they end before they start. They come from apply_windows
+// in sota.toml, or the X-Fio-Apply-Windows header of the config response,
+// separated by semicolons, which takes precedence.
+
+const applyWindowsHeader = "X-Fio-Apply-Windows"
+
+// ConfigPendingError is returned by a check-in that found a new config
+// outside of the apply windows. It matches NotModifiedError since nothing
+// was applied.
+var ConfigPendingError error = configPendingError{}
+
+type configPendingError struct{}
+
+func (configPendingError) Error() string {
+	return "New config not applied until the next apply window"
+}
+
+func (configPendingError) Is(target error) bool {
+	return target == NotModifiedError
+}
+
+// ConfigPending describes a config waiting for an apply window
+type ConfigPending struct {
+	Since time.Time
+	// When the next window opens
+	Opens time.Time
+	// The files the server's config would change or remove, as of the
+	// last check-in
+	Pending []string `json:",omitempty"`
+}
+
+type applyWindow struct {
+	days [7]bool
+	// Minutes since midnight
+	start, end int
+}
+
+type applyWindows []applyWindow
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseWeekday(name string) (int, error) {
+	for i, day := range weekdays {
+		if strings.EqualFold(name, day) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid day %q", name)
+}
+
+// parseDays handles lists of days and ranges, e.g. "Mon-Fri,Sun"
+func parseDays(spec string) ([7]bool, error) {
+	var days [7]bool
+	for _, item := range strings.Split(spec, ",") {
+		ends := strings.SplitN(item, "-", 2)
+		first, err := parseWeekday(ends[0])
+		if err != nil {
+			return days, err
+		}
+		last := first
+		if len(ends) == 2 {
+			if last, err = parseWeekday(ends[1]); err != nil {
+				return days, err
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock returns the minutes since midnight of "HH:MM", up to 24:00
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) == 2 && len(parts[1]) == 2 {
+		hours, err1 := strconv.Atoi(parts[0])
+		mins, err2 := strconv.Atoi(parts[1])
+		if err1 == nil && err2 == nil && hours >= 0 && mins >= 0 && mins < 60 && hours*60+mins <= 24*60 {
+			return hours*60 + mins, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid time %q", value)
+}
+
+func parseApplyWindow(spec string) (applyWindow, error) {
+	var w applyWindow
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return w, fmt.Errorf("Invalid apply window %q: %w", spec, err)
+		}
+		w.days = days
+		fields = fields[1:]
+	default:
+		return w, fmt.Errorf("Invalid apply window %q", spec)
+	}
+	ends := strings.SplitN(fields[0], "-", 2)
+	if len(ends) != 2 {
+		return w, fmt.Errorf("Invalid apply window %q", spec)
+	}
+	var err error
+	if w.start, err = parseClock(ends[0]); err == nil {
+		w.end, err = parseClock(ends[1])
+	}
+	if err != nil {
+		return w, fmt.Errorf("Invalid apply window %q: %w", spec, err)
+	} else if w.start == w.end || w.start == 24*60 {
+		return w, fmt.Errorf("Invalid apply window %q: it never opens", spec)
+	}
+	return w, nil
+}
+
+func parseApplyWindows(specs []string) (applyWindows, error) {
+	var windows applyWindows
+	for _, spec := range specs {
+		w, err := parseApplyWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func (w applyWindow) contains(t time.Time) bool {
+	mins := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	if w.start < w.end {
+		return w.days[day] && mins >= w.start && mins < w.end
+	}
+	// Windows ending after midnight belong to the day they start
+	return (w.days[day] && mins >= w.start) || (w.days[(day+6)%7] && mins < w.end)
+}
+
+func (ws applyWindows) open(t time.Time) bool {
+	for _, w := range ws {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpen returns when the first window after t opens
+func (ws applyWindows) nextOpen(t time.Time) time.Time {
+	var next time.Time
+	for _, w := range ws {
+		for d := 0; d <= 7; d++ {
+			day := t.AddDate(0, 0, d)
+			opens := time.Date(day.Year(), day.Month(), day.Day(), w.start/60, w.start%60, 0, 0, t.Location())
+			if w.days[opens.Weekday()] && opens.After(t) {
+				if next.IsZero() || opens.Before(next) {
+					next = opens
+				}
+				break
+			}
+		}
+	}
+	return next
+}
+
+// currentApplyWindows returns the windows the server sent with res, or
+// those in sota.toml. res is nil when only the config layers changed.
+func (a *App) currentApplyWindows(res *httpRes) applyWindows {
+	if res != nil {
+		if header := res.Header.Get(applyWindowsHeader); len(header) > 0 {
+			windows, err := parseApplyWindows(strings.Split(header, ";"))
+			if err == nil {
+				return windows
+			}
+			logger.Printf("Ignoring apply windows from the server: %s", err)
+		}
+	}
+	// Checked when sota.toml is loaded
+	windows, _ := parseApplyWindows(a.settings.ApplyWindows)
+	return windows
+}
+
+func (a *App) pendingFile() string {
+	return filepath.Join(a.stateDir(), "config.pending")
+}
+
+// PendingStatus returns the config waiting for an apply window, or nil
+func (a *App) PendingStatus() (*ConfigPending, error) {
+	buf, err := os.ReadFile(a.pendingFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pending ConfigPending
+	if err := json.Unmarshal(buf, &pending); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %w", a.pendingFile(), err)
+	}
+	return &pending, nil
+}
+
+func (a *App) clearPending() {
+	if err := os.Remove(a.pendingFile()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Printf("Unable to remove %s: %s", a.pendingFile(), err)
+	}
+}
+
+// deferConfig records what next would change instead of applying it
+// outside of the apply windows. It returns nil if next changes nothing, or
+// it's the device's first config, so there's no reason to wait.
+func (a *App) deferConfig(ctx context.Context, client *http.Client, windows applyWindows, next ConfigStruct) error {
+	if len(a.readManifest().Files) == 0 {
+		return nil
+	}
+	pending := a.pendingChanges(next)
+	if len(pending) == 0 {
+		a.clearPending()
+		return nil
+	}
+	now := time.Now()
+	opens := windows.nextOpen(now)
+	prev, err := a.PendingStatus()
+	if err != nil {
+		logger.Printf("Unable to read pending config: %s", err)
+	}
+	if prev != nil && prev.Opens.Equal(opens) && strings.Join(pending, "\n") == strings.Join(prev.Pending, "\n") {
+		a.debugf("Config changes still pending: %s", strings.Join(pending, ", "))
+		return ConfigPendingError
+	}
+	LogEvent(EventConfigPending, "Not applying changes to %d files until the apply window opens at %s", len(pending), opens.Format(time.RFC3339))
+	status := ConfigPending{Since: now.UTC(), Opens: opens, Pending: pending}
+	if prev != nil {
+		status.Since = prev.Since
+	}
+	buf, err := json.Marshal(status)
+	if err == nil {
+		err = safeWrite(a.pendingFile(), buf)
+	}
+	if err != nil {
+		logger.Printf("Unable to save pending config: %s", err)
+	}
+	report := newExtractReport()
+	report.Code = EventConfigPending
+	report.Change = a.change
+	report.Pending = pending
+	report.WindowOpens = &opens
+	a.reportStatus(ctx, client, report)
+	return ConfigPendingError
+}
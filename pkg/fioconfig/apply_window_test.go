@@ -0,0 +1,177 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyWindows(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(day int, clock string) time.Time {
+		mins, err := parseClock(clock)
+		require.Nil(t, err)
+		return time.Date(2024, 1, day, mins/60, mins%60, 0, 0, time.Local)
+	}
+
+	windows, err := parseApplyWindows([]string{"Mon-Fri 02:00-04:00", "sat,sun 22:00-01:30"})
+	require.Nil(t, err)
+	require.False(t, windows.open(at(1, "01:59")))
+	require.True(t, windows.open(at(1, "02:00")))
+	require.True(t, windows.open(at(5, "03:59")))
+	require.False(t, windows.open(at(5, "04:00")))
+	require.False(t, windows.open(at(6, "03:00")))
+	require.True(t, windows.open(at(6, "23:00")))
+	// Sunday's window runs into Monday morning
+	require.True(t, windows.open(at(8, "01:00")))
+	require.False(t, windows.open(at(8, "01:30")))
+	require.False(t, windows.open(at(5, "01:00")))
+
+	require.Equal(t, at(2, "02:00"), windows.nextOpen(at(1, "02:30")))
+	require.Equal(t, at(6, "22:00"), windows.nextOpen(at(5, "02:30")))
+	require.Equal(t, at(8, "02:00"), windows.nextOpen(at(7, "22:30")))
+
+	windows, err = parseApplyWindows([]string{"Fri-Mon 00:00-24:00"})
+	require.Nil(t, err)
+	require.True(t, windows.open(at(7, "12:00")))
+	require.True(t, windows.open(at(1, "23:59")))
+	require.False(t, windows.open(at(2, "12:00")))
+
+	for _, spec := range []string{"", "02:00", "02:00-02:00", "25:00-03:00", "2:0-3:00", "Mo 02:00-03:00", "Mon 02:00-03:00 extra"} {
+		_, err := parseApplyWindow(spec)
+		require.NotNil(t, err, spec)
+	}
+}
+
+func TestApplyWindowCheckIn(t *testing.T) {
+	var encbuf []byte
+	var reports []ExtractReport
+	header := ""
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/config-status" {
+			var report ExtractReport
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&report))
+			reports = append(reports, report)
+			w.WriteHeader(201)
+			return
+		}
+		if len(header) > 0 {
+			w.Header().Set(applyWindowsHeader, header)
+		}
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+		app.configUrl += "/config"
+
+		// A window that opens in two hours
+		now := time.Now()
+		opens := now.Add(2 * time.Hour)
+		closed := fmt.Sprintf("%02d:%02d-%02d:%02d", opens.Hour(), opens.Minute(), now.Add(3*time.Hour).Hour(), opens.Minute())
+		app.settings.ApplyWindows = []string{closed}
+
+		// The first config is applied straight away, and then there's nothing
+		// to wait for until the config changes something
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		pending, err := app.PendingStatus()
+		require.Nil(t, err)
+		require.Nil(t, pending)
+
+		var config map[string]*ConfigFile
+		require.Nil(t, json.Unmarshal(encbuf, &config))
+		config["bar"].Value = "new bar"
+		encbuf, err = json.Marshal(config)
+		require.Nil(t, err)
+
+		err = app.checkin(context.Background(), client, crypto)
+		require.Equal(t, ConfigPendingError, err)
+		require.True(t, errors.Is(err, NotModifiedError))
+		require.Equal(t, "pending", CheckInResult(err))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("bar file value"))
+		require.Len(t, reports, 1)
+		require.Equal(t, EventConfigPending, reports[0].Code)
+		require.Equal(t, []string{"bar"}, reports[0].Pending)
+		pending, err = app.PendingStatus()
+		require.Nil(t, err)
+		require.Equal(t, []string{"bar"}, pending.Pending)
+		require.Equal(t, opens.Truncate(time.Minute).Unix(), pending.Opens.Unix())
+
+		// The same changes aren't reported again
+		require.Equal(t, ConfigPendingError, app.checkin(context.Background(), client, crypto))
+		require.Len(t, reports, 1)
+
+		// The server's windows take precedence
+		header = "00:00-24:00"
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("new bar"))
+		pending, err = app.PendingStatus()
+		require.Nil(t, err)
+		require.Nil(t, pending)
+	})
+}
+
+func TestApplyWindowLayers(t *testing.T) {
+	var encbuf []byte
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(201)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+		app.configUrl += "/config"
+
+		local := filepath.Join(tempdir, "local.json")
+		require.Nil(t, os.WriteFile(local, []byte(`{"foo": {"Value": "local foo", "Unencrypted": true}}`), 0o644))
+		app.settings.ConfigLayers = []string{deviceLayer, local}
+		now := time.Now()
+		opens := now.Add(2 * time.Hour)
+		app.settings.ApplyWindows = []string{fmt.Sprintf("%02d:%02d-%02d:%02d", opens.Hour(), opens.Minute(), now.Add(3*time.Hour).Hour(), opens.Minute())}
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("local foo"))
+
+		// A layer changing waits for the window like a new config does
+		require.Nil(t, os.WriteFile(local, []byte(`{"foo": {"Value": "new foo", "Unencrypted": true}}`), 0o644))
+		require.Equal(t, ConfigPendingError, app.checkin(context.Background(), client, crypto))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("local foo"))
+		pending, err := app.PendingStatus()
+		require.Nil(t, err)
+		require.Equal(t, []string{"foo"}, pending.Pending)
+
+		app.settings.ApplyWindows = []string{"00:00-24:00"}
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("new foo"))
+		pending, err = app.PendingStatus()
+		require.Nil(t, err)
+		require.Nil(t, pending)
+	})
+}
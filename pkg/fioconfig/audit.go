@@ -0,0 +1,245 @@
+package fioconfig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuditLogMaxSize = 1024 * 1024
+	defaultAuditLogKeep    = 5
+)
+
+// AuditRecord is an entry in the audit log. Each one holds the sha256 of
+// the line before it, so editing or removing a record breaks the chain
+// VerifyAuditLog checks.
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Version int       `json:"version,omitempty"` // Config version from the history
+	File    string    `json:"file"`
+	Action  string    `json:"action"` // "added", "changed", or "removed"
+	OldHash string    `json:"old-sha256,omitempty"`
+	NewHash string    `json:"new-sha256,omitempty"`
+	// The on-changed command that ran for the file and how it exited
+	Handler  []string `json:"handler,omitempty"`
+	ExitCode *int     `json:"exit-code,omitempty"`
+	// Who changed the config and why, when the server said
+	Change *ChangeInfo `json:"change,omitempty"`
+	Prev   string      `json:"prev"`
+}
+
+// latestVersion returns the newest config version in the history, or 0
+func (a *App) latestVersion() int {
+	entries, err := a.History()
+	if err != nil || len(entries) == 0 {
+		return 0
+	}
+	return entries[len(entries)-1].Version
+}
+
+// audit appends the files an extraction changed to the audit log
+func (a *App) audit(report *ExtractReport, version int) {
+	path := a.settings.AuditLog
+	if len(path) == 0 || report == nil || len(report.Applied)+len(report.Removed) == 0 {
+		return
+	}
+	handlers := make(map[string]HandlerResult)
+	for _, h := range report.Handlers {
+		handlers[h.File] = h
+		for _, fname := range h.Files {
+			handlers[fname] = h
+		}
+	}
+	now := time.Now().UTC()
+	var records []AuditRecord
+	add := func(fname, action string) {
+		r := AuditRecord{
+			Time:    now,
+			Version: version,
+			File:    fname,
+			Action:  action,
+			OldHash: report.prevHashes[fname],
+			NewHash: report.hashes[fname],
+			Change:  report.Change,
+		}
+		if h, ok := handlers[fname]; ok {
+			exitCode := h.ExitCode
+			r.Handler = h.Command
+			r.ExitCode = &exitCode
+		}
+		records = append(records, r)
+	}
+	for _, fname := range report.Applied {
+		if _, ok := report.prevHashes[fname]; ok {
+			add(fname, "changed")
+		} else {
+			add(fname, "added")
+		}
+	}
+	for _, fname := range report.Removed {
+		add(fname, "removed")
+	}
+	if err := appendAudit(path, a.settings.AuditLogMaxSize, a.settings.AuditLogKeep, records); err != nil {
+		logger.Printf("ERROR: Unable to write audit log %s: %s", path, err)
+	}
+}
+
+// lastAuditLine returns the last line of the newest audit log file
+func lastAuditLine(path string) ([]byte, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if idx := bytes.LastIndexByte(buf, '\n'); idx >= 0 {
+		buf = buf[idx+1:]
+	}
+	return buf, nil
+}
+
+func appendAudit(path string, maxSize int64, keep int, records []AuditRecord) error {
+	if maxSize <= 0 {
+		maxSize = defaultAuditLogMaxSize
+	}
+	if keep <= 0 {
+		keep = defaultAuditLogKeep
+	}
+	prev := ""
+	last, err := lastAuditLine(path)
+	if err == nil && len(last) > 0 {
+		prev = sha256Hex(last)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if st, err := os.Stat(path); err == nil && st.Size() >= maxSize {
+		if err := rotateAudit(path, keep); err != nil {
+			return err
+		}
+	}
+
+	// The chain carries on into the new file so rotation can't hide a
+	// removed record
+	var buf bytes.Buffer
+	for _, r := range records {
+		r.Prev = prev
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		prev = sha256Hex(line)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// rotateAudit moves path to path.1, path.1 to path.2, and so on, dropping
+// the oldest beyond keep.
+func rotateAudit(path string, keep int) error {
+	for i := keep; i > 0; i-- {
+		src := path
+		if i > 1 {
+			src = path + "." + strconv.Itoa(i-1)
+		}
+		if err := os.Rename(src, path+"."+strconv.Itoa(i)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// AuditNotEnabledError is returned when reading the audit log without
+// fioconfig.audit_log set
+var AuditNotEnabledError = errors.New("The audit log is not enabled, set fioconfig.audit_log in sota.toml")
+
+// AuditLog returns the records of the audit log and its rotated files,
+// oldest first
+func (a *App) AuditLog() ([]AuditRecord, error) {
+	if len(a.settings.AuditLog) == 0 {
+		return nil, AuditNotEnabledError
+	}
+	var records []AuditRecord
+	err := walkAuditLog(a.settings.AuditLog, func(line []byte) error {
+		var r AuditRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		records = append(records, r)
+		return nil
+	})
+	return records, err
+}
+
+// VerifyAuditLog checks the hash chain of the audit log and its rotated
+// files. The first record kept can't be checked since the one it refers to
+// was rotated away.
+func (a *App) VerifyAuditLog() error {
+	if len(a.settings.AuditLog) == 0 {
+		return AuditNotEnabledError
+	}
+	prev := ""
+	n := 0
+	return walkAuditLog(a.settings.AuditLog, func(line []byte) error {
+		n++
+		var r AuditRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("Audit record %d is corrupt: %w", n, err)
+		}
+		if n > 1 && r.Prev != prev {
+			return fmt.Errorf("Audit record %d (%s at %s) doesn't follow the one before it", n, r.File, r.Time.Format(time.RFC3339))
+		}
+		prev = sha256Hex(line)
+		return nil
+	})
+}
+
+func walkAuditLog(path string, fn func(line []byte) error) error {
+	var files []string
+	for i := 1; ; i++ {
+		rotated := path + "." + strconv.Itoa(i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		files = append([]string{rotated}, files...)
+	}
+	files = append(files, path)
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			if err := fn(scanner.Bytes()); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package fioconfig
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, err := app.AuditLog()
+		require.True(t, errors.Is(err, AuditNotEnabledError))
+
+		path := filepath.Join(tempdir, "audit.log")
+		app.settings.AuditLog = path
+		require.Nil(t, app.Extract())
+		// Nothing changed, so nothing is logged
+		require.Nil(t, app.Extract())
+
+		records, err := app.AuditLog()
+		require.Nil(t, err)
+		require.Len(t, records, 4)
+		byFile := make(map[string]AuditRecord)
+		for _, r := range records {
+			require.Equal(t, "added", r.Action)
+			require.Empty(t, r.OldHash)
+			require.Len(t, r.NewHash, 64)
+			byFile[r.File] = r
+		}
+		require.Equal(t, sha256Hex([]byte("bar file value")), byFile["bar"].NewHash)
+		require.Equal(t, 0, *byFile["bar"].ExitCode)
+		require.Equal(t, "/usr/bin/touch", byFile["bar"].Handler[0])
+		require.Nil(t, byFile["foo"].ExitCode)
+		require.Nil(t, app.VerifyAuditLog())
+
+		st, err := os.Stat(path)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+
+		// Editing a record breaks the chain
+		buf, err := os.ReadFile(path)
+		require.Nil(t, err)
+		buf = bytes.Replace(buf, []byte(`"action":"added"`), []byte(`"action":"changed"`), 1)
+		require.Nil(t, os.WriteFile(path, buf, 0o600))
+		require.NotNil(t, app.VerifyAuditLog())
+	})
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		path := filepath.Join(tempdir, "audit.log")
+		app.settings.AuditLog = path
+		for i := 0; i < 10; i++ {
+			require.Nil(t, appendAudit(path, 100, 2, []AuditRecord{{File: "foo", Action: "changed"}}))
+		}
+		require.FileExists(t, path+".1")
+		require.FileExists(t, path+".2")
+		require.NoFileExists(t, path+".3")
+		// The chain carries on across rotated files
+		require.Nil(t, app.VerifyAuditLog())
+
+		require.Nil(t, os.Remove(path+".1"))
+		require.Nil(t, os.Rename(path+".2", path+".1"))
+		require.NotNil(t, app.VerifyAuditLog())
+	})
+}
@@ -0,0 +1,101 @@
+package fioconfig
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// Config file a factory can use to roll out new root CAs. Its certificates
+// are trusted in addition to import.tls_cacert_path so devices keep working
+// with the old root until the server switches over.
+const caBundleConfigFile = "fio-root-ca-bundle"
+
+func caBundlePath(sota *toml.Tree) string {
+	storage := sota.GetDefault("storage.path", "").(string)
+	if len(storage) == 0 {
+		return ""
+	}
+	return filepath.Join(storage, "root-ca-bundle.crt")
+}
+
+// parseCaBundle makes sure a bundle only holds valid CA certificates
+func parseCaBundle(bundle []byte, now time.Time) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("Unexpected PEM block in CA bundle: %s", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid certificate in CA bundle: %w", err)
+		}
+		if !cert.IsCA {
+			return nil, fmt.Errorf("Certificate %s in CA bundle is not a CA", cert.Subject)
+		}
+		if now.After(cert.NotAfter) {
+			return nil, fmt.Errorf("Certificate %s in CA bundle expired %s", cert.Subject, cert.NotAfter)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("CA bundle contains no certificates")
+	}
+	return certs, nil
+}
+
+// updateCaBundle validates a new CA bundle from the server and atomically
+// installs it. A nil bundle means the server removed it.
+func (a *App) updateCaBundle(bundle []byte) error {
+	path := caBundlePath(a.sota)
+	if len(path) == 0 {
+		return errors.New("storage.path is not set, unable to install CA bundle")
+	}
+	if bundle == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		a.reloadClient = true
+		return nil
+	}
+	if cur, err := os.ReadFile(path); err == nil && string(cur) == string(bundle) {
+		return nil
+	}
+	certs, err := parseCaBundle(bundle, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := safeWrite(path, bundle); err != nil {
+		return fmt.Errorf("Unable to write CA bundle: %w", err)
+	}
+	LogEvent(EventCaBundleInstalled, "Installed CA bundle with %d certificates", len(certs))
+	a.reloadClient = true
+	return nil
+}
+
+// appendCaBundle adds the server provided roots, if any, to the pool
+func appendCaBundle(pool *x509.CertPool, sota *toml.Tree) {
+	path := caBundlePath(sota)
+	if len(path) == 0 {
+		return
+	}
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to read CA bundle: %s", err)
+		}
+		return
+	}
+	pool.AppendCertsFromPEM(bundle)
+}
@@ -0,0 +1,81 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testCert(t *testing.T, isCA bool, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	require.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestParseCaBundle(t *testing.T) {
+	now := time.Now()
+	ca := testCert(t, true, now.Add(time.Hour))
+	certs, err := parseCaBundle([]byte(ca+ca), now)
+	require.Nil(t, err)
+	require.Len(t, certs, 2)
+
+	_, err = parseCaBundle([]byte(testCert(t, false, now.Add(time.Hour))), now)
+	require.NotNil(t, err)
+	_, err = parseCaBundle([]byte(testCert(t, true, now.Add(-time.Minute))), now)
+	require.NotNil(t, err)
+	_, err = parseCaBundle([]byte("not a bundle"), now)
+	require.NotNil(t, err)
+}
+
+func TestExtractCaBundle(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		bundlePath := filepath.Join(tempdir, "root-ca-bundle.crt")
+		ca := testCert(t, true, time.Now().Add(time.Hour))
+		config := ConfigStruct{caBundleConfigFile: &ConfigFile{Value: ca}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertFile(t, bundlePath, []byte(ca))
+		require.True(t, app.reloadClient)
+
+		// The client still comes up trusting both sets of roots
+		_, c, err := createClient(app.sota)
+		require.Nil(t, err)
+		c.Close()
+
+		// Invalid bundles leave the current one in place
+		bad := ConfigStruct{caBundleConfigFile: &ConfigFile{Value: testCert(t, false, time.Now().Add(time.Hour))}}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{config, bad})
+		require.Nil(t, err)
+		require.Contains(t, report.Failed, caBundleConfigFile)
+		assertFile(t, bundlePath, []byte(ca))
+
+		_, err = app.extract(context.Background(), crypto, configSnapshot{bad, ConfigStruct{}})
+		require.Nil(t, err)
+		assertNoFile(t, bundlePath)
+	})
+}
@@ -0,0 +1,193 @@
+package fioconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A CacheSealer transforms config.encrypted and the config history before
+// they are written to disk, e.g. to encrypt them under a key that never
+// leaves the device. Config values are still encrypted to the device key
+// inside, so a sealer adds protection rather than replacing it.
+type CacheSealer interface {
+	Seal(content []byte) ([]byte, error)
+	Open(sealed []byte) ([]byte, error)
+}
+
+type CacheSealerFactory func(a *App) (CacheSealer, error)
+
+var cacheSealers = map[string]CacheSealerFactory{
+	"gzip":    func(a *App) (CacheSealer, error) { return gzipSealer{}, nil },
+	"keyfile": newKeyFileSealer,
+}
+
+// RegisterCacheSealer makes a sealer available to the `cache_sealers`
+// setting.
+func RegisterCacheSealer(name string, factory CacheSealerFactory) {
+	cacheSealers[name] = factory
+}
+
+// Sealed files start with this followed by the sealers applied, in order,
+// and a newline. Files without it are plain JSON from before sealing was
+// enabled.
+const cacheHeader = "fioconfig-cache:v1:"
+
+func (a *App) getCacheSealer(name string) (CacheSealer, error) {
+	factory, ok := cacheSealers[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown cache sealer: %s", name)
+	}
+	return factory(a)
+}
+
+// sealCache applies the configured sealers to content
+func (a *App) sealCache(content []byte) ([]byte, error) {
+	if len(a.settings.CacheSealers) == 0 {
+		return content, nil
+	}
+	for _, name := range a.settings.CacheSealers {
+		sealer, err := a.getCacheSealer(name)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = sealer.Seal(content); err != nil {
+			return nil, fmt.Errorf("Unable to seal config cache with %s: %w", name, err)
+		}
+	}
+	header := cacheHeader + strings.Join(a.settings.CacheSealers, ",") + "\n"
+	return append([]byte(header), content...), nil
+}
+
+// openCache undoes sealCache using the sealers named in the file, so
+// changing `cache_sealers` doesn't make existing files unreadable.
+func (a *App) openCache(content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, []byte(cacheHeader)) {
+		return content, nil
+	}
+	idx := bytes.IndexByte(content, '\n')
+	if idx < 0 {
+		return nil, errors.New("Invalid config cache header")
+	}
+	names := strings.Split(string(content[len(cacheHeader):idx]), ",")
+	content = content[idx+1:]
+	for i := len(names) - 1; i >= 0; i-- {
+		sealer, err := a.getCacheSealer(names[i])
+		if err != nil {
+			return nil, err
+		}
+		if content, err = sealer.Open(content); err != nil {
+			return nil, fmt.Errorf("Unable to open config cache with %s: %w", names[i], err)
+		}
+	}
+	return content, nil
+}
+
+func (a *App) readCache(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return a.openCache(content)
+}
+
+func (a *App) writeCache(path string, content []byte) error {
+	sealed, err := a.sealCache(content)
+	if err != nil {
+		return err
+	}
+	return safeWrite(path, sealed)
+}
+
+// unmarshallCache is UnmarshallFile for files written with writeCache
+func (a *App) unmarshallCache(c CryptoHandler, path string, decrypt bool) (ConfigStruct, error) {
+	content, err := a.readCache(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read encrypted file: %w", err)
+	}
+	return UnmarshallBuffer(c, content, decrypt)
+}
+
+type gzipSealer struct{}
+
+func (gzipSealer) Seal(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipSealer) Open(sealed []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// aesSealer encrypts with AES-256-GCM. The nonce is prepended to the
+// ciphertext.
+type aesSealer struct {
+	key []byte
+}
+
+func (s aesSealer) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s aesSealer) Seal(content []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, content, nil), nil
+}
+
+func (s aesSealer) Open(sealed []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("Sealed data is truncated")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// newKeyFileSealer uses a 32 byte key from `cache_key_file`, which would
+// normally live on storage that isn't part of a disk image, like a
+// partition encrypted by the bootloader.
+func newKeyFileSealer(a *App) (CacheSealer, error) {
+	if len(a.settings.CacheKeyFile) == 0 {
+		return nil, errors.New("The keyfile cache sealer requires fioconfig.cache_key_file")
+	}
+	key, err := os.ReadFile(a.settings.CacheKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read cache key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("Cache key %s must be 32 bytes", a.settings.CacheKeyFile)
+	}
+	return aesSealer{key}, nil
+}
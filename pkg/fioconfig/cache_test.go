@@ -0,0 +1,50 @@
+package fioconfig
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheSealers(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		plain, err := os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+
+		keyFile := filepath.Join(tempdir, "cache.key")
+		require.Nil(t, os.WriteFile(keyFile, bytes.Repeat([]byte{7}, 32), 0o600))
+		app.settings.CacheSealers = []string{"gzip", "keyfile"}
+		app.settings.CacheKeyFile = keyFile
+
+		// Files from before sealing was enabled are still readable
+		content, err := app.readCache(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Equal(t, plain, content)
+
+		require.Nil(t, app.writeCache(app.EncryptedConfig, plain))
+		sealed, err := os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.True(t, bytes.HasPrefix(sealed, []byte("fioconfig-cache:v1:gzip,keyfile\n")))
+		require.NotContains(t, string(sealed), "bar file value")
+
+		require.Nil(t, app.Extract())
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+
+		// The file says how to open it even if the settings change
+		app.settings.CacheSealers = nil
+		content, err = app.readCache(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Equal(t, plain, content)
+
+		require.Nil(t, os.WriteFile(keyFile, bytes.Repeat([]byte{8}, 32), 0o600))
+		_, err = app.readCache(app.EncryptedConfig)
+		require.NotNil(t, err)
+
+		app.settings.CacheSealers = []string{"bogus"}
+		require.NotNil(t, app.writeCache(app.EncryptedConfig, plain))
+	})
+}
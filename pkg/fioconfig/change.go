@@ -0,0 +1,107 @@
+package fioconfig
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The server can say where a config came from with these headers. They
+// travel with the config into the history, the status reports, the audit
+// log, and the environment of handlers, so a change on a device can be
+// traced to who made it and why.
+const (
+	changeVersionHeader = "X-Fio-Config-Version"
+	changeAuthorHeader  = "X-Fio-Config-Author"
+	changeReasonHeader  = "X-Fio-Config-Reason"
+)
+
+// Long enough for a commit message subject, short enough for a log line
+const maxChangeField = 256
+
+// ChangeInfo describes a config change as the server reported it
+type ChangeInfo struct {
+	Version string `json:"version,omitempty"`
+	Author  string `json:"author,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// cleanChangeField makes a header value safe to log and pass to handlers
+func cleanChangeField(value string) string {
+	value = strings.ToValidUTF8(value, "")
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, value)
+	value = strings.TrimSpace(value)
+	if len(value) > maxChangeField {
+		value = value[:maxChangeField]
+		for !utf8.ValidString(value) {
+			value = value[:len(value)-1] // Don't split a character
+		}
+	}
+	return value
+}
+
+// changeInfo returns what the server said about the config in res, or nil
+func changeInfo(res *httpRes) *ChangeInfo {
+	change := &ChangeInfo{
+		Version: cleanChangeField(res.Header.Get(changeVersionHeader)),
+		Author:  cleanChangeField(res.Header.Get(changeAuthorHeader)),
+		Reason:  cleanChangeField(res.Header.Get(changeReasonHeader)),
+	}
+	if *change == (ChangeInfo{}) {
+		return nil
+	}
+	return change
+}
+
+func (c *ChangeInfo) String() string {
+	if c == nil {
+		return ""
+	}
+	var parts []string
+	if len(c.Version) > 0 {
+		parts = append(parts, "version "+c.Version)
+	}
+	if len(c.Author) > 0 {
+		parts = append(parts, "by "+c.Author)
+	}
+	s := strings.Join(parts, " ")
+	if len(c.Reason) > 0 {
+		if len(s) > 0 {
+			s += ": "
+		}
+		s += fmt.Sprintf("%q", c.Reason)
+	}
+	return s
+}
+
+// logFields adds the change to the fields of a log message
+func (c *ChangeInfo) logFields(fields LogFields) LogFields {
+	if c == nil {
+		return fields
+	}
+	if len(c.Version) > 0 {
+		fields["config_version"] = c.Version
+	}
+	if len(c.Author) > 0 {
+		fields["config_author"] = c.Author
+	}
+	return fields
+}
+
+// env is what handlers are told about the change
+func (c *ChangeInfo) env() []string {
+	if c == nil {
+		return nil
+	}
+	return []string{
+		"CONFIG_VERSION=" + c.Version,
+		"CONFIG_AUTHOR=" + c.Author,
+		"CONFIG_REASON=" + c.Reason,
+	}
+}
@@ -0,0 +1,82 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanChangeField(t *testing.T) {
+	require.Equal(t, "fix wifi  injected", cleanChangeField(" fix wifi\n\rinjected "))
+	long := cleanChangeField(strings.Repeat("é", maxChangeField))
+	require.LessOrEqual(t, len(long), maxChangeField)
+	require.Equal(t, strings.Repeat("é", maxChangeField/2), long)
+	require.Equal(t, "ok", cleanChangeField("o\xffk"))
+
+	var change *ChangeInfo
+	require.Empty(t, change.String())
+	require.Nil(t, change.env())
+	change = &ChangeInfo{Version: "42", Author: "jane", Reason: "rotate wifi key"}
+	require.Equal(t, `version 42 by jane: "rotate wifi key"`, change.String())
+	require.Equal(t, `"only a reason"`, (&ChangeInfo{Reason: "only a reason"}).String())
+}
+
+func TestCheckInChangeInfo(t *testing.T) {
+	var encbuf []byte
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(changeVersionHeader, "42")
+		w.Header().Set(changeAuthorHeader, "jane@example.com")
+		w.Header().Set(changeReasonHeader, "Rotate the wifi key")
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+
+		var config map[string]*ConfigFile
+		require.Nil(t, json.Unmarshal(encbuf, &config))
+		envFile := filepath.Join(tempdir, "handler-env")
+		config["traced"] = &ConfigFile{
+			Value:       "traced",
+			Unencrypted: true,
+			OnChanged:   []string{"/bin/sh", "-c", `echo "$CONFIG_VERSION|$CONFIG_AUTHOR|$CONFIG_REASON" > ` + envFile},
+		}
+		encbuf, err = json.Marshal(config)
+		require.Nil(t, err)
+		app.settings.AuditLog = filepath.Join(tempdir, "audit.log")
+
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		want := &ChangeInfo{Version: "42", Author: "jane@example.com", Reason: "Rotate the wifi key"}
+		assertFile(t, envFile, []byte("42|jane@example.com|Rotate the wifi key\n"))
+		report, err := app.LastReport()
+		require.Nil(t, err)
+		require.Equal(t, want, report.Change)
+		require.Equal(t, want, app.loadCheckInState().Change)
+		entries, err := app.History()
+		require.Nil(t, err)
+		require.Equal(t, want, entries[len(entries)-1].Change)
+		records, err := app.AuditLog()
+		require.Nil(t, err)
+		require.NotEmpty(t, records)
+		require.Equal(t, want, records[0].Change)
+		require.Nil(t, app.change)
+
+		// Extract at boot knows which change it's re-applying
+		require.Nil(t, os.Remove(envFile))
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "traced")))
+		require.Nil(t, app.Extract())
+		assertFile(t, envFile, []byte("42|jane@example.com|Rotate the wifi key\n"))
+	})
+}
@@ -0,0 +1,89 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultCheckInLogSize = 50
+
+// CheckInRecord describes one check-in with the server
+type CheckInRecord struct {
+	Time   time.Time `json:"time"`
+	Result string    `json:"result"` // See CheckInResult
+	// The config version the device was on afterwards and the sha256 of
+	// its encrypted config
+	Version int    `json:"version,omitempty"`
+	Sha256  string `json:"sha256,omitempty"`
+	// Files the check-in added, changed, or removed
+	Changed []string `json:"changed,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	// The system time wasn't trustworthy, so Time may be wrong too
+	ClockUntrusted bool `json:"clock_untrusted,omitempty"`
+}
+
+func (a *App) checkInLogFile() string {
+	return filepath.Join(a.stateDir(), "checkin-log.json")
+}
+
+// CheckIns returns the most recent check-ins, oldest first
+func (a *App) CheckIns() ([]CheckInRecord, error) {
+	var records []CheckInRecord
+	buf, err := os.ReadFile(a.checkInLogFile())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return records, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(buf, &records); err != nil {
+		return nil, fmt.Errorf("Unable to parse check-in log: %w", err)
+	}
+	return records, nil
+}
+
+func (a *App) logCheckIn(err error) {
+	if logErr := a.recordCheckInLog(err, a.checkInReport); logErr != nil {
+		logger.Printf("WARNING: Unable to record check-in log: %s", logErr)
+	}
+}
+
+// recordCheckInLog adds a check-in that returned err to the log, keeping
+// the last `fioconfig.checkin_log_size`
+func (a *App) recordCheckInLog(err error, report *ExtractReport) error {
+	records, readErr := a.CheckIns()
+	if readErr != nil {
+		// Start over rather than never logging again
+		logger.Printf("WARNING: %s", readErr)
+		records = nil
+	}
+	record := CheckInRecord{Time: time.Now().UTC(), Result: CheckInResult(err), ClockUntrusted: a.clockUntrusted}
+	if entries, _ := a.History(); len(entries) > 0 {
+		record.Version = entries[len(entries)-1].Version
+		record.Sha256 = entries[len(entries)-1].Sha256
+	}
+	if report != nil {
+		record.Changed = append(append(record.Changed, report.Applied...), report.Removed...)
+	}
+	if err != nil && !errors.Is(err, NotModifiedError) {
+		record.Error = err.Error()
+	}
+	records = append(records, record)
+
+	size := a.settings.CheckInLogSize
+	if size <= 0 {
+		size = defaultCheckInLogSize
+	}
+	if len(records) > size {
+		records = records[len(records)-size:]
+	}
+	buf, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return safeWrite(a.checkInLogFile(), buf)
+}
@@ -0,0 +1,65 @@
+package fioconfig
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckInLog(t *testing.T) {
+	var encbuf []byte
+	status := http.StatusOK
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		var err error
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		os.Remove(app.EncryptedConfig)
+
+		records, err := app.CheckIns()
+		require.Nil(t, err)
+		require.Len(t, records, 0)
+
+		require.Nil(t, app.CheckIn())
+		status = http.StatusNotModified
+		require.True(t, errors.Is(app.CheckIn(), NotModifiedError))
+		status = http.StatusNotFound
+		require.NotNil(t, app.CheckIn())
+
+		records, err = app.CheckIns()
+		require.Nil(t, err)
+		require.Len(t, records, 3)
+		require.Equal(t, "applied", records[0].Result)
+		require.Equal(t, 1, records[0].Version)
+		require.Len(t, records[0].Sha256, 64)
+		require.ElementsMatch(t, []string{"bar", "foo", "random", "with/subdir/1.txt"}, records[0].Changed)
+		require.Empty(t, records[0].Error)
+
+		require.Equal(t, "not-modified", records[1].Result)
+		require.Equal(t, records[0].Sha256, records[1].Sha256)
+		require.Empty(t, records[1].Changed)
+		require.Empty(t, records[1].Error)
+
+		require.Equal(t, "failed", records[2].Result)
+		require.Contains(t, records[2].Error, "HTTP_404")
+
+		// Only the newest are kept
+		app.settings.CheckInLogSize = 2
+		require.NotNil(t, app.CheckIn())
+		records, err = app.CheckIns()
+		require.Nil(t, err)
+		require.Len(t, records, 2)
+		require.Equal(t, "failed", records[0].Result)
+	})
+}
@@ -0,0 +1,98 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkInState holds the cache validators the server returned with the
+// last config we applied. They are persisted so that conditional requests
+// don't depend on the local clock or the mtime of config.encrypted.
+type checkInState struct {
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+
+	// The config URL that last worked when multiple servers are configured
+	Server string `json:",omitempty"`
+
+	// Consecutive check-ins rejected with 401/403
+	AuthFailures int `json:",omitempty"`
+
+	// config.encrypted holds a version from the history, or an imported
+	// bundle, rather than the one the validators are for, so deltas can't
+	// be applied to it
+	Reverted bool `json:",omitempty"`
+
+	// config.encrypted was written as a JSON config that deltas can be
+	// applied to. It's recorded when the cache is written so check-ins
+	// don't have to read and decode it to find out.
+	Patchable bool `json:",omitempty"`
+
+	// The tags the config was fetched for
+	Tags string `json:",omitempty"`
+
+	// What the server said about the config's change
+	Change *ChangeInfo `json:",omitempty"`
+
+	// The X-Config-Signature-Version of the config applied, so an older
+	// signed config isn't accepted
+	SignedVersion uint64 `json:",omitempty"`
+
+	// Set while the daemon is backing off so a restart doesn't bring it
+	// straight back to the server
+	Backoff *CheckInBackoff `json:",omitempty"`
+}
+
+// CheckInBackoff is when the daemon may next check in after the server
+// rate limited it or rejected its credentials
+type CheckInBackoff struct {
+	Until  time.Time
+	Reason string
+}
+
+func (a *App) checkInStateFile() string {
+	return filepath.Join(a.stateDir(), "checkin.state")
+}
+
+func (a *App) loadCheckInState() checkInState {
+	var state checkInState
+	bytes, err := os.ReadFile(a.checkInStateFile())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to read check-in state: %s", err)
+		}
+		return state
+	}
+	if err := json.Unmarshal(bytes, &state); err != nil {
+		logger.Printf("Unable to parse check-in state: %s", err)
+	}
+	return state
+}
+
+func (a *App) saveCheckInState(state checkInState) error {
+	bytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return safeWrite(a.checkInStateFile(), bytes)
+}
+
+// CheckInBackoff returns the backoff the daemon was in when it last
+// stopped, or nil if it wasn't backing off
+func (a *App) CheckInBackoff() *CheckInBackoff {
+	return a.loadCheckInState().Backoff
+}
+
+// SetCheckInBackoff records a backoff for the next daemon to honor, or
+// clears it when backoff is nil
+func (a *App) SetCheckInBackoff(backoff *CheckInBackoff) error {
+	state := a.loadCheckInState()
+	if backoff == nil && state.Backoff == nil {
+		return nil
+	}
+	state.Backoff = backoff
+	return a.saveCheckInState(state)
+}
@@ -0,0 +1,30 @@
+package fioconfig
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckInBackoff(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		require.Nil(t, app.CheckInBackoff())
+		require.Nil(t, app.SetCheckInBackoff(nil))
+		assertNoFile(t, app.checkInStateFile())
+
+		require.Nil(t, app.saveCheckInState(checkInState{ETag: "v1"}))
+		until := time.Now().Add(time.Minute).Round(time.Second)
+		require.Nil(t, app.SetCheckInBackoff(&CheckInBackoff{Until: until, Reason: "Rate limited"}))
+		backoff := app.CheckInBackoff()
+		require.NotNil(t, backoff)
+		require.True(t, until.Equal(backoff.Until))
+		require.Equal(t, "Rate limited", backoff.Reason)
+		require.Equal(t, "v1", app.loadCheckInState().ETag)
+
+		require.Nil(t, app.SetCheckInBackoff(nil))
+		require.Nil(t, app.CheckInBackoff())
+		require.Equal(t, "v1", app.loadCheckInState().ETag)
+	})
+}
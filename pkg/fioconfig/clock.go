@@ -0,0 +1,60 @@
+package fioconfig
+
+import (
+	"os"
+	"time"
+)
+
+// Devices without a battery-backed RTC boot with the clock at the epoch,
+// or wherever it was when they shut down, until NTP fixes it. Until then
+// certificates look expired or not yet valid, If-Modified-Since asks for
+// the wrong thing, and certificate renewal may think it's overdue. The
+// clock is trusted once systemd-timesyncd says it's synchronized, or when
+// it's later than anything we know has already happened.
+
+// BuildTime is set to the RFC 3339 time of the build with -ldflags
+var BuildTime string
+
+// minClockFloor is the floor for builds without a BuildTime
+var minClockFloor = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// timesyncSynchronized is created by systemd-timesyncd once it has
+// synchronized the clock
+var timesyncSynchronized = "/run/systemd/timesync/synchronized"
+
+// buildFloor is the earliest the time can be for this build
+func buildFloor() time.Time {
+	if t, err := time.Parse(time.RFC3339, BuildTime); err == nil && t.After(minClockFloor) {
+		return t
+	}
+	return minClockFloor
+}
+
+// clockFloor is the earliest the time can be: the build floor, or the
+// server's Date of the config we applied last
+func (a *App) clockFloor() time.Time {
+	floor := buildFloor()
+	if fi, err := os.Stat(a.EncryptedConfig); err == nil && fi.ModTime().After(floor) {
+		floor = fi.ModTime()
+	}
+	return floor
+}
+
+// clockTrusted reports whether now can be relied on
+func (a *App) clockTrusted(now time.Time) bool {
+	if _, err := os.Stat(timesyncSynchronized); err == nil {
+		return true
+	}
+	return !now.Before(a.clockFloor())
+}
+
+// tlsTime is the time certificates are checked against. A clock that's
+// behind the build floor is taken to be at the floor, so a device that
+// boots at the epoch can still check in and get its time from NTP later.
+func tlsTime() time.Time {
+	now := time.Now()
+	if floor := buildFloor(); now.Before(floor) {
+		return floor
+	}
+	return now
+}
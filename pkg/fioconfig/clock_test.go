@@ -0,0 +1,39 @@
+package fioconfig
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockTrusted(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		orig := timesyncSynchronized
+		timesyncSynchronized = filepath.Join(tempdir, "synchronized")
+		defer func() { timesyncSynchronized = orig }()
+
+		require.True(t, app.clockTrusted(time.Now()))
+		require.False(t, app.clockTrusted(time.Unix(0, 0)))
+
+		// The last config's Date is a floor too
+		lastConfig := time.Now().Add(48 * time.Hour)
+		require.Nil(t, os.Chtimes(app.EncryptedConfig, lastConfig, lastConfig))
+		require.False(t, app.clockTrusted(time.Now()))
+
+		require.Nil(t, os.WriteFile(timesyncSynchronized, nil, 0o644))
+		require.True(t, app.clockTrusted(time.Unix(0, 0)))
+	})
+}
+
+func TestTlsTime(t *testing.T) {
+	orig := BuildTime
+	defer func() { BuildTime = orig }()
+	BuildTime = time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	require.Equal(t, BuildTime, tlsTime().UTC().Format(time.RFC3339))
+	BuildTime = ""
+	require.True(t, time.Since(tlsTime()) < time.Minute)
+}
@@ -0,0 +1,61 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// The config payload encodings we can accept from the server in order of
+// preference. CBOR is smaller and cheaper to parse than JSON for large
+// configs on constrained devices.
+const acceptPayloads = "application/cbor, application/json;q=0.9"
+
+// payloadDecoder converts a config payload to its JSON equivalent. Configs
+// are always stored and processed locally as JSON regardless of the wire
+// format.
+type payloadDecoder func(body []byte) ([]byte, error)
+
+// Decoders keyed by Content-Type
+var payloadCodecs = map[string]payloadDecoder{
+	"application/cbor": cborToJson,
+}
+
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+}.DecMode()
+
+func cborToJson(body []byte) ([]byte, error) {
+	var val interface{}
+	if err := cborDecMode.Unmarshal(body, &val); err != nil {
+		return nil, err
+	}
+	return json.Marshal(val)
+}
+
+// decodePayload converts the response body to JSON if the server sent it in
+// another encoding.
+func decodePayload(res *httpRes) error {
+	ct := res.Header.Get("Content-Type")
+	if len(ct) == 0 {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil
+	}
+	decode, ok := payloadCodecs[mediaType]
+	if !ok {
+		return nil
+	}
+	body, err := decode(res.Body)
+	if err != nil {
+		return fmt.Errorf("Unable to decode %s config payload: %w", mediaType, err)
+	}
+	res.Body = body
+	res.Header.Set("Content-Type", "application/json")
+	return nil
+}
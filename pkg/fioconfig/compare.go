@@ -0,0 +1,63 @@
+package fioconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Comparison modes for ConfigFile.Compare. Services that normalize their
+// config on start would otherwise see it rewritten and restarted on every
+// check-in.
+const (
+	CompareExact   = "exact"
+	CompareNewline = "trailing-newline"
+	CompareJson    = "json"
+	CompareYaml    = "yaml"
+	CompareIni     = "ini"
+)
+
+// contentEqual reports whether the file's current content is equivalent to
+// the new value under the given comparison mode.
+func contentEqual(mode string, cur, next []byte) bool {
+	switch mode {
+	case "", CompareExact:
+	case CompareJson:
+		var a, b interface{}
+		if json.Unmarshal(cur, &a) == nil && json.Unmarshal(next, &b) == nil {
+			return reflect.DeepEqual(a, b)
+		}
+	case CompareNewline:
+		return bytes.Equal(bytes.TrimRight(cur, "\r\n"), bytes.TrimRight(next, "\r\n"))
+	case CompareYaml:
+		var a, b interface{}
+		if yaml.Unmarshal(cur, &a) == nil && yaml.Unmarshal(next, &b) == nil {
+			return reflect.DeepEqual(a, b)
+		}
+	case CompareIni:
+		return reflect.DeepEqual(normalizeIni(cur), normalizeIni(next))
+	default:
+		logger.Printf("Unknown comparison mode %s, using %s", mode, CompareExact)
+	}
+	return bytes.Equal(cur, next)
+}
+
+// normalizeIni strips the things that have no meaning in an INI file:
+// comments, blank lines, and whitespace around keys and values.
+func normalizeIni(content []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if idx := strings.Index(line, "="); idx > 0 && line[0] != '[' {
+			line = strings.TrimSpace(line[:idx]) + "=" + strings.TrimSpace(line[idx+1:])
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,73 @@
+package fioconfig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentEqual(t *testing.T) {
+	require.True(t, contentEqual("", []byte("a"), []byte("a")))
+	require.False(t, contentEqual(CompareExact, []byte(`{"a": 1}`), []byte(`{"a":1}`)))
+	require.True(t, contentEqual(CompareJson, []byte(`{"a": 1, "b": [1, 2]}`), []byte(`{"b":[1,2],"a":1}`)))
+	require.False(t, contentEqual(CompareJson, []byte(`{"a": 1}`), []byte(`{"a": 2}`)))
+	// Invalid json falls back to an exact comparison
+	require.False(t, contentEqual(CompareJson, []byte(`{"a": 1`), []byte(`{"a":1`)))
+
+	require.True(t, contentEqual(CompareNewline, []byte("value\n"), []byte("value")))
+	require.True(t, contentEqual(CompareNewline, []byte("value\r\n"), []byte("value\n")))
+	require.False(t, contentEqual(CompareNewline, []byte(" value"), []byte("value")))
+
+	require.True(t, contentEqual(CompareYaml, []byte("a: 1\nb: [1, 2]\n"), []byte("b:\n  - 1\n  - 2\na: 1")))
+	require.False(t, contentEqual(CompareYaml, []byte("a: 1"), []byte("a: \"1\"")))
+
+	cur := []byte("# normalized by the service\n[main]\nkey = value\n\n")
+	require.True(t, contentEqual(CompareIni, cur, []byte("[main]\nkey=value")))
+	require.False(t, contentEqual(CompareIni, cur, []byte("[main]\nkey=other")))
+}
+
+func TestExtractCompareModes(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		handled := filepath.Join(tempdir, "handled")
+		config := ConfigStruct{
+			"service.json": &ConfigFile{
+				Value:     `{"port": 80}`,
+				Compare:   CompareJson,
+				OnChanged: []string{"/usr/bin/touch", handled},
+			},
+			"normalized": &ConfigFile{
+				Value:             "original",
+				IgnoreHookChanges: true,
+				OnChanged:         []string{"/usr/bin/touch", handled},
+			},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertFile(t, handled, nil)
+		require.Nil(t, os.Remove(handled))
+
+		// Simulate the service rewriting its config files
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "service.json"), []byte("{\n  \"port\": 80\n}\n"), 0o644))
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "normalized"), []byte("rewritten"), 0o644))
+
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertNoFile(t, handled)
+		assertFile(t, filepath.Join(tempdir, "normalized"), []byte("rewritten"))
+
+		// A change on the server still gets applied
+		config["normalized"].Value = "new value"
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertFile(t, handled, nil)
+		assertFile(t, filepath.Join(tempdir, "normalized"), []byte("new value"))
+	})
+}
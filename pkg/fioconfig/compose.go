@@ -0,0 +1,140 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultDockerHost is the Docker engine compose apps are restarted through
+// unless docker_host says otherwise
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// ContainerResult is the outcome of restarting a compose app's container
+type ContainerResult struct {
+	App       string `json:"app"`
+	Service   string `json:"service,omitempty"`
+	Container string `json:"container,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// dockerClient returns a client for the Docker engine API and the URL to
+// reach it at. docker_host takes the same "unix://" and "tcp://" forms as
+// $DOCKER_HOST.
+func (a *App) dockerClient() (*http.Client, string, error) {
+	host := a.settings.DockerHost
+	if len(host) == 0 {
+		host = DefaultDockerHost
+	}
+	if strings.HasPrefix(host, "unix://") {
+		sock := strings.TrimPrefix(host, "unix://")
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://docker", nil
+	} else if strings.HasPrefix(host, "tcp://") {
+		return &http.Client{}, "http://" + strings.TrimPrefix(host, "tcp://"), nil
+	}
+	return nil, "", fmt.Errorf("Unsupported fioconfig.docker_host: %s", host)
+}
+
+// runComposeRestarts restarts the containers of the compose apps, or
+// "<app>/<service>" services of them, a config file names. Containers are
+// found by the labels docker compose gives them.
+func (a *App) runComposeRestarts(ctx context.Context, fname string, cfgFile *ConfigFile) []ContainerResult {
+	var results []ContainerResult
+	timeout, err := a.handlerTimeout(cfgFile)
+	if err == nil && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var client *http.Client
+	var base string
+	if err == nil {
+		client, base, err = a.dockerClient()
+	}
+	for _, target := range cfgFile.ComposeRestart {
+		parts := strings.SplitN(target, "/", 2)
+		app, service := parts[0], ""
+		if len(parts) == 2 {
+			service = parts[1]
+		}
+		if err != nil {
+			results = append(results, ContainerResult{App: app, Service: service, Error: err.Error()})
+			continue
+		}
+		containers, listErr := listComposeContainers(ctx, client, base, app, service)
+		if listErr == nil && len(containers) == 0 {
+			listErr = fmt.Errorf("No containers found for %s", target)
+		}
+		if listErr != nil {
+			LogEvent(EventHandlerFailed, "Unable to restart %s: %s", target, listErr)
+			results = append(results, ContainerResult{App: app, Service: service, Error: listErr.Error()})
+			continue
+		}
+		for _, c := range containers {
+			result := ContainerResult{App: app, Service: c.service, Container: c.name}
+			LogEvent(EventContainerRestart, "Restarting container %s of %s for %s", c.name, app, fname)
+			res, err := httpDoOnce(ctx, client, http.MethodPost, base+"/containers/"+url.PathEscape(c.id)+"/restart", nil, nil)
+			if err != nil {
+				result.Error = err.Error()
+			} else if res.StatusCode != http.StatusNoContent {
+				result.Error = fmt.Sprintf("HTTP_%d: %s", res.StatusCode, res.String())
+			}
+			if len(result.Error) > 0 {
+				LogEvent(EventHandlerFailed, "Unable to restart container %s: %s", c.name, result.Error)
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+type composeContainer struct {
+	id      string
+	name    string
+	service string
+}
+
+func listComposeContainers(ctx context.Context, client *http.Client, base, app, service string) ([]composeContainer, error) {
+	labels := []string{"com.docker.compose.project=" + app}
+	if len(service) > 0 {
+		labels = append(labels, "com.docker.compose.service="+service)
+	}
+	filters, err := json.Marshal(map[string][]string{"label": labels})
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpDoOnce(ctx, client, http.MethodGet, base+"/containers/json?filters="+url.QueryEscape(string(filters)), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to list containers - HTTP_%d: %s", res.StatusCode, res.String())
+	}
+	var list []struct {
+		Id     string
+		Names  []string
+		Labels map[string]string
+	}
+	if err := res.Json(&list); err != nil {
+		return nil, fmt.Errorf("Unable to parse container list: %w", err)
+	}
+	containers := make([]composeContainer, 0, len(list))
+	for _, c := range list {
+		name := c.Id
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		containers = append(containers, composeContainer{c.Id, name, c.Labels["com.docker.compose.service"]})
+	}
+	return containers, nil
+}
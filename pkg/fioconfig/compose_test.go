@@ -0,0 +1,88 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeRestart(t *testing.T) {
+	type container struct {
+		Id     string
+		Names  []string
+		Labels map[string]string
+	}
+	containers := []container{
+		{"1", []string{"/shellhttpd-web-1"}, map[string]string{"com.docker.compose.project": "shellhttpd", "com.docker.compose.service": "web"}},
+		{"2", []string{"/shellhttpd-db-1"}, map[string]string{"com.docker.compose.project": "shellhttpd", "com.docker.compose.service": "db"}},
+		{"3", []string{"/broken-app-1"}, map[string]string{"com.docker.compose.project": "broken", "com.docker.compose.service": "app"}},
+	}
+	var restarted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/containers/json" {
+			var filters map[string][]string
+			require.Nil(t, json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters))
+			matches := []container{}
+			for _, c := range containers {
+				match := true
+				for _, label := range filters["label"] {
+					kv := strings.SplitN(label, "=", 2)
+					match = match && len(kv) == 2 && c.Labels[kv[0]] == kv[1]
+				}
+				if match {
+					matches = append(matches, c)
+				}
+			}
+			require.Nil(t, json.NewEncoder(w).Encode(matches))
+			return
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/restart")
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/restart") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if id == "3" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		restarted = append(restarted, id)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		app.settings.DockerHost = "tcp://" + strings.TrimPrefix(server.URL, "http://")
+		config := ConfigStruct{
+			"shellhttpd.conf": &ConfigFile{Value: "a", ComposeRestart: []string{"shellhttpd/web"}},
+			"shared.conf":     &ConfigFile{Value: "b", ComposeRestart: []string{"shellhttpd", "broken", "missing"}},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Equal(t, []string{"1", "2", "1"}, restarted)
+		require.Len(t, report.Handlers, 2)
+
+		shared := report.Handlers[0]
+		require.Equal(t, "shared.conf", shared.File)
+		require.Len(t, shared.Containers, 4)
+		require.Equal(t, ContainerResult{App: "shellhttpd", Service: "db", Container: "shellhttpd-db-1"}, shared.Containers[1])
+		require.Equal(t, "broken-app-1", shared.Containers[2].Container)
+		require.Contains(t, shared.Containers[2].Error, "HTTP_500")
+		require.Contains(t, shared.Containers[3].Error, "No containers found for missing")
+
+		web := report.Handlers[1]
+		require.Equal(t, []ContainerResult{{App: "shellhttpd", Service: "web", Container: "shellhttpd-web-1"}}, web.Containers)
+
+		require.Len(t, report.Warnings, 2)
+		require.Contains(t, report.Warnings[0], "shared.conf: unable to restart broken-app-1")
+		require.Contains(t, report.Warnings[1], "shared.conf: unable to restart missing")
+	})
+}
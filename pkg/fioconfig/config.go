@@ -0,0 +1,284 @@
+package fioconfig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+var Commit string
+
+type ConfigFile struct {
+	Value       string
+	OnChanged   []string
+	Unencrypted bool
+
+	// How to decide if the file on disk needs updating. See contentEqual
+	Compare string `json:",omitempty"`
+	// Don't rewrite the file if its server value hasn't changed since we
+	// last wrote it, even if something (like its on-changed handler) has
+	// modified it since.
+	IgnoreHookChanges bool `json:",omitempty"`
+	// Handlers in the same group run serially, different groups in parallel
+	HandlerGroup string `json:",omitempty"`
+	// Overrides the handler_timeout setting for this file's handler
+	HandlerTimeout string `json:",omitempty"`
+	// Run the handler as "user" or "user:group" rather than fioconfig's user
+	RunAs string `json:",omitempty"`
+	// systemd units to reload or restart over D-Bus when the file changes
+	Reload  []string `json:",omitempty"`
+	Restart []string `json:",omitempty"`
+	// docker compose apps, or "<app>/<service>", whose containers are
+	// restarted when the file changes
+	ComposeRestart []string `json:",omitempty"`
+	// Don't run the handler or unit actions when the file is written by an
+	// initial extraction. See skip_initial_handlers
+	SkipInitialHandler bool `json:",omitempty"`
+	// Octal permissions (e.g. "0600") and numeric owner to give the file
+	// instead of 0640 and fioconfig's user.
+	Mode string `json:",omitempty"`
+	Uid  *int   `json:",omitempty"`
+	Gid  *int   `json:",omitempty"`
+	// How Value is encoded. Binary files use EncodingBase64 since Value
+	// must be valid UTF-8 to survive JSON.
+	Encoding string `json:",omitempty"`
+	// Files that must be applied before or after this one. See applyOrder
+	Before []string `json:",omitempty"`
+	After  []string `json:",omitempty"`
+	// Value is a text/template rendered with the DeviceFacts
+	Template bool `json:",omitempty"`
+	// Also write the file to the credentials directory under this name
+	// for systemd's LoadCredential=. See writeCredentials
+	Credential string `json:",omitempty"`
+	// Value is a NetworkManager keyfile to install and activate. See
+	// applyNMConnections
+	NetworkManager bool `json:",omitempty"`
+	// Value is key=value lines to set in the bootloader environment. See
+	// applyUbootEnv
+	UbootEnv bool `json:",omitempty"`
+	// Value is a JSON object of local users and their SSH authorized keys.
+	// See applySSHKeys
+	SSHKeys bool `json:",omitempty"`
+	// Also render the file as a variable, EnvKey or one named after the
+	// file, of this dotenv file. See writeEnvFiles
+	EnvFile string `json:",omitempty"`
+	EnvKey  string `json:",omitempty"`
+	// Also install the file at this absolute path, which target_paths
+	// must allow. See writeTargets
+	TargetPath string `json:",omitempty"`
+	// A JSON Schema the value must match: another file of the config or
+	// an absolute path on the device. See checkSchemas
+	Schema string `json:",omitempty"`
+	// Value is the relative target of a symbolic link to create rather
+	// than the content of a file. See validateSymlink
+	Symlink bool `json:",omitempty"`
+	// Leave the file on disk when it's removed from the config, and stop
+	// managing it. See keepOnRemove
+	KeepOnRemove bool `json:",omitempty"`
+}
+
+const EncodingBase64 = "base64"
+
+// content returns the bytes to write to the file
+func (c *ConfigFile) content() ([]byte, error) {
+	switch c.Encoding {
+	case "":
+		return []byte(c.Value), nil
+	case EncodingBase64:
+		buf, err := base64.StdEncoding.DecodeString(c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to base64 decode value: %w", err)
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("Unsupported value encoding: %s", c.Encoding)
+}
+
+// request returns the upload request that recreates this file
+func (c *ConfigFile) request(name string) ConfigFileReq {
+	return ConfigFileReq{
+		Name:               name,
+		Value:              c.Value,
+		Unencrypted:        c.Unencrypted,
+		OnChanged:          c.OnChanged,
+		Compare:            c.Compare,
+		IgnoreHookChanges:  c.IgnoreHookChanges,
+		HandlerGroup:       c.HandlerGroup,
+		HandlerTimeout:     c.HandlerTimeout,
+		RunAs:              c.RunAs,
+		Reload:             c.Reload,
+		Restart:            c.Restart,
+		ComposeRestart:     c.ComposeRestart,
+		SkipInitialHandler: c.SkipInitialHandler,
+		Mode:               c.Mode,
+		Uid:                c.Uid,
+		Gid:                c.Gid,
+		Encoding:           c.Encoding,
+		Before:             c.Before,
+		After:              c.After,
+		Template:           c.Template,
+		Credential:         c.Credential,
+		NetworkManager:     c.NetworkManager,
+		UbootEnv:           c.UbootEnv,
+		SSHKeys:            c.SSHKeys,
+		EnvFile:            c.EnvFile,
+		EnvKey:             c.EnvKey,
+		TargetPath:         c.TargetPath,
+		Schema:             c.Schema,
+		Symlink:            c.Symlink,
+		KeepOnRemove:       c.KeepOnRemove,
+	}
+}
+
+// newConfigFileReq prepares a file for upload, base64 encoding content
+// that isn't valid UTF-8.
+func newConfigFileReq(name string, content []byte, unencrypted bool) ConfigFileReq {
+	req := ConfigFileReq{Name: name, Value: string(content), Unencrypted: unencrypted}
+	if !utf8.Valid(content) {
+		req.Value = base64.StdEncoding.EncodeToString(content)
+		req.Encoding = EncodingBase64
+	}
+	return req
+}
+
+// NewConfigFile returns a file with the given content, base64 encoding it
+// if it isn't valid UTF-8.
+func NewConfigFile(content []byte) *ConfigFile {
+	cfgFile := &ConfigFile{Value: string(content)}
+	if !utf8.Valid(content) {
+		cfgFile.Value = base64.StdEncoding.EncodeToString(content)
+		cfgFile.Encoding = EncodingBase64
+	}
+	return cfgFile
+}
+
+type ConfigStruct = map[string]*ConfigFile
+
+func UnmarshallFile(c CryptoHandler, encFile string, decrypt bool) (ConfigStruct, error) {
+	content, err := os.ReadFile(encFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read encrypted file: %w", err)
+	}
+	return UnmarshallBuffer(c, content, decrypt)
+}
+
+func UnmarshallBuffer(c CryptoHandler, encContent []byte, decrypt bool) (ConfigStruct, error) {
+	// The values in an age bundle are plaintext once the bundle itself is
+	// decrypted, so it has to be decrypted even when the values aren't
+	// needed.
+	age := isAgeBundle(encContent)
+	if age {
+		var err error
+		if encContent, err = decryptAgeBundle(c, encContent); err != nil {
+			return nil, &DecryptError{"", err}
+		}
+	}
+	var config map[string]*ConfigFile
+	err := json.Unmarshal(encContent, &config)
+	if age {
+		zeroize(encContent)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse encrypted json: %v", err)
+	}
+	if decrypt && !age {
+		for fname, cfgFile := range config {
+			if !cfgFile.Unencrypted {
+				logger.Printf("Decoding value of %s", fname)
+				decrypted, err := c.Decrypt(cfgFile.Value)
+				if err != nil {
+					return nil, &DecryptError{fname, err}
+				}
+				cfgFile.Value = string(decrypted)
+				// Value is a copy that lives as long as the config. This
+				// only wipes the handler's buffer so it isn't left behind
+				// as well.
+				zeroize(decrypted)
+			}
+		}
+	}
+	return config, nil
+}
+
+type ConfigFileReq struct {
+	Name               string   `json:"name"`
+	Value              string   `json:"value"`
+	Unencrypted        bool     `json:"unencrypted"`
+	OnChanged          []string `json:"on-changed,omitempty"`
+	Compare            string   `json:"compare,omitempty"`
+	IgnoreHookChanges  bool     `json:"ignore-hook-changes,omitempty"`
+	HandlerGroup       string   `json:"handler-group,omitempty"`
+	HandlerTimeout     string   `json:"handler-timeout,omitempty"`
+	RunAs              string   `json:"run-as,omitempty"`
+	Reload             []string `json:"reload,omitempty"`
+	Restart            []string `json:"restart,omitempty"`
+	ComposeRestart     []string `json:"compose-restart,omitempty"`
+	SkipInitialHandler bool     `json:"skip-initial-handler,omitempty"`
+	Mode               string   `json:"mode,omitempty"`
+	Uid                *int     `json:"uid,omitempty"`
+	Gid                *int     `json:"gid,omitempty"`
+	Encoding           string   `json:"encoding,omitempty"`
+	Before             []string `json:"before,omitempty"`
+	After              []string `json:"after,omitempty"`
+	Template           bool     `json:"template,omitempty"`
+	Credential         string   `json:"credential,omitempty"`
+	NetworkManager     bool     `json:"network-manager,omitempty"`
+	UbootEnv           bool     `json:"uboot-env,omitempty"`
+	SSHKeys            bool     `json:"ssh-keys,omitempty"`
+	EnvFile            string   `json:"env-file,omitempty"`
+	EnvKey             string   `json:"env-key,omitempty"`
+	TargetPath         string   `json:"target-path,omitempty"`
+	Schema             string   `json:"schema,omitempty"`
+	Symlink            bool     `json:"symlink,omitempty"`
+	KeepOnRemove       bool     `json:"keep-on-remove,omitempty"`
+}
+
+type ConfigCreateRequest struct {
+	Reason string          `json:"reason"`
+	Files  []ConfigFileReq `json:"files"`
+	PubKey string          `json:"public-key"`
+}
+
+func updateConfig(app *App, client *http.Client, pubkey string) error {
+	updated := ""
+	content, err := os.ReadFile(filepath.Join(app.SecretsDir, "wireguard-client"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			updated = "enabled=0\n" // This isn't enabled
+		} else {
+			return err
+		}
+	}
+	written := false
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "pubkey=") {
+			updated += "pubkey=" + pubkey + "\n"
+			written = true
+		} else {
+			updated += line + "\n"
+		}
+	}
+	if !written {
+		updated += "pubkey=" + pubkey + "\n"
+	}
+	updated = strings.TrimSpace(updated)
+
+	ccr := ConfigCreateRequest{
+		Reason: "Set Wireguard pubkey from fioconfig",
+		Files:  []ConfigFileReq{newConfigFileReq("wireguard-client", []byte(updated), true)},
+	}
+	res, err := httpPatch(client, app.configUrl, ccr)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 201 {
+		return fmt.Errorf("Unable to update: %s - HTTP_%d: %s", app.configUrl, res.StatusCode, string(res.Body))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package fioconfig
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// With config_signing_keys set, a config is only applied if the server
+// sends an Ed25519 signature of it, made with one of the keys, in the
+// X-Config-Signature header. The signing keys are kept offline, so a
+// hijacked gateway can't alter a device's config even though it
+// terminates the device's TLS connection. The signature also covers the
+// version in X-Config-Signature-Version and the device's UUID, so a config
+// signed for one device can't be replayed to another, nor an older config
+// replayed to roll a device back.
+
+const (
+	configSignatureHeader        = "X-Config-Signature"
+	configSignatureVersionHeader = "X-Config-Signature-Version"
+)
+
+// BadSignatureError is returned when a downloaded config isn't signed by
+// one of config_signing_keys
+var BadSignatureError = errors.New("Config is not signed by a trusted key")
+
+// ConfigRollbackError is returned when a signed config has an older version
+// than the one the device last applied
+var ConfigRollbackError = errors.New("Config is older than the one applied")
+
+// parseSigningKeys decodes config_signing_keys, base64 Ed25519 public keys.
+// More than one key can be trusted while the signing key is rotated.
+func parseSigningKeys(keys []string) ([]ed25519.PublicKey, error) {
+	var parsed []ed25519.PublicKey
+	for _, key := range keys {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid fioconfig.config_signing_keys entry: %s", key)
+		}
+		parsed = append(parsed, ed25519.PublicKey(raw))
+	}
+	return parsed, nil
+}
+
+// signedConfigMessage is what a config's signature is made over: its
+// version and the device's UUID, each on a line, followed by the response
+// body as it was sent
+func signedConfigMessage(version uint64, deviceUuid string, body []byte) []byte {
+	msg := []byte(fmt.Sprintf("%d\n%s\n", version, deviceUuid))
+	return append(msg, body...)
+}
+
+// verifyConfigSignature checks the signature the server sent for a config
+// and returns the version it was signed with, or 0 when configs aren't
+// signed. Deltas can't be checked since the full config they produce isn't
+// what the server signed. An invalid key refuses every config rather than
+// none.
+func (a *App) verifyConfigSignature(res *httpRes) (uint64, error) {
+	if len(a.settings.ConfigSigningKeys) == 0 {
+		return 0, nil
+	}
+	version, err := strconv.ParseUint(res.Header.Get(configSignatureVersionHeader), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: missing or invalid %s header", BadSignatureError, configSignatureVersionHeader)
+	}
+	msg := signedConfigMessage(version, a.deviceUuid, res.Body)
+	if err := a.checkSignature(res.Header.Get(configSignatureHeader), configSignatureHeader+" header", msg); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// checkSignedVersion refuses a signed config older than applied, the
+// version of the last one applied from the same source
+func checkSignedVersion(version, applied uint64) error {
+	if version < applied {
+		return fmt.Errorf("%w: version %d, applied %d", ConfigRollbackError, version, applied)
+	}
+	return nil
+}
+
+// checkSignature checks a base64 signature of body from source, which
+// names where it came from in errors
+func (a *App) checkSignature(encoded, source string, body []byte) error {
+	if len(a.settings.ConfigSigningKeys) == 0 {
+		return nil
+	}
+	keys, err := parseSigningKeys(a.settings.ConfigSigningKeys)
+	if err != nil {
+		return err
+	}
+	if len(encoded) == 0 {
+		return fmt.Errorf("%w: no %s", BadSignatureError, source)
+	}
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: invalid %s", BadSignatureError, source)
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, body, sig) {
+			return nil
+		}
+	}
+	return BadSignatureError
+}
@@ -0,0 +1,173 @@
+package fioconfig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	otherPub, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	body := []byte(`{"foo": {"Value": "signed"}}`)
+	var signer ed25519.PrivateKey
+	var uuid string
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if signer != nil {
+			sig := ed25519.Sign(signer, signedConfigMessage(1, uuid, body))
+			w.Header().Set(configSignatureHeader, base64.StdEncoding.EncodeToString(sig))
+			w.Header().Set(configSignatureVersionHeader, "1")
+		}
+		_, err := w.Write(body)
+		require.Nil(t, err)
+	})
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		secrets := t.TempDir()
+		app, err := NewApp(tempdir,
+			WithSecretsDir(secrets),
+			WithConfigURL(app.configUrl),
+			WithHTTPClient(client),
+			WithCryptoHandler(&plainCrypto{}))
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+		app.settings.ConfigSigningKeys = []string{base64.StdEncoding.EncodeToString(pub)}
+		uuid = "another-device"
+
+		err = app.CheckIn()
+		require.True(t, errors.Is(err, BadSignatureError), err)
+		signer = otherPriv
+		err = app.CheckIn()
+		require.True(t, errors.Is(err, BadSignatureError), err)
+		// Signed for a different device
+		app.settings.ConfigSigningKeys = append(app.settings.ConfigSigningKeys, base64.StdEncoding.EncodeToString(otherPub))
+		err = app.CheckIn()
+		require.True(t, errors.Is(err, BadSignatureError), err)
+		_, err = os.Stat(filepath.Join(secrets, "foo"))
+		require.True(t, os.IsNotExist(err))
+
+		// Either key is trusted during a rotation
+		uuid = app.deviceUuid
+		require.Nil(t, app.CheckIn())
+		content, err := os.ReadFile(filepath.Join(secrets, "foo"))
+		require.Nil(t, err)
+		require.Equal(t, "signed", string(content))
+
+		signer = priv
+		app.settings.ConfigSigningKeys = []string{"not-a-key"}
+		require.NotNil(t, app.CheckIn())
+	})
+}
+
+func TestConfigSignatureDelta(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	body := []byte(`{"foo": {"Value": "v1"}}`)
+	var version uint64 = 1
+	var uuid string
+	askedForDelta := false
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		askedForDelta = askedForDelta || len(r.Header.Get("A-IM")) > 0
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			// A delta whatever the device asked for
+			delta := []byte(`{"changed": {"foo": {"Value": "delta"}}}`)
+			w.Header().Set(configSignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, delta)))
+			w.Header().Set(configSignatureVersionHeader, "2")
+			w.Header().Set("ETag", `"v2"`)
+			w.Header().Set("IM", deltaIM)
+			w.Header().Set("Delta-Base", `"v1"`)
+			w.WriteHeader(226)
+			_, err := w.Write(delta)
+			require.Nil(t, err)
+			return
+		}
+		w.Header().Set(configSignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, signedConfigMessage(version, uuid, body))))
+		w.Header().Set(configSignatureVersionHeader, strconv.FormatUint(version, 10))
+		w.Header().Set("ETag", `"v1"`)
+		_, err := w.Write(body)
+		require.Nil(t, err)
+	})
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		secrets := t.TempDir()
+		app, err := NewApp(tempdir,
+			WithSecretsDir(secrets),
+			WithConfigURL(app.configUrl),
+			WithHTTPClient(client),
+			WithCryptoHandler(&plainCrypto{}))
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+		app.settings.ConfigSigningKeys = []string{base64.StdEncoding.EncodeToString(pub)}
+		uuid = app.deviceUuid
+		require.Nil(t, app.CheckIn())
+
+		// Only full configs as served can be verified, so deltas aren't
+		// asked for and one that's sent anyway is replaced by the full config
+		body = []byte(`{"foo": {"Value": "v2"}}`)
+		version = 2
+		require.Nil(t, app.CheckIn())
+		require.False(t, askedForDelta)
+		content, err := os.ReadFile(filepath.Join(secrets, "foo"))
+		require.Nil(t, err)
+		require.Equal(t, "v2", string(content))
+	})
+}
+
+func TestConfigSignatureRollback(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	body := []byte(`{"foo": {"Value": "v2"}}`)
+	var version uint64 = 2
+	var uuid string
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(configSignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, signedConfigMessage(version, uuid, body))))
+		w.Header().Set(configSignatureVersionHeader, strconv.FormatUint(version, 10))
+		_, err := w.Write(body)
+		require.Nil(t, err)
+	})
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		secrets := t.TempDir()
+		app, err := NewApp(tempdir,
+			WithSecretsDir(secrets),
+			WithConfigURL(app.configUrl),
+			WithHTTPClient(client),
+			WithCryptoHandler(&plainCrypto{}))
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+		app.settings.ConfigSigningKeys = []string{base64.StdEncoding.EncodeToString(pub)}
+		uuid = app.deviceUuid
+		require.Nil(t, app.CheckIn())
+		require.Equal(t, uint64(2), app.loadCheckInState().SignedVersion)
+
+		// A config signed before the one applied is refused even when it's
+		// validly signed, and whether or not config.encrypted is there
+		body = []byte(`{"foo": {"Value": "v1"}}`)
+		version = 1
+		err = app.CheckIn()
+		require.True(t, errors.Is(err, ConfigRollbackError), err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+		err = app.CheckIn()
+		require.True(t, errors.Is(err, ConfigRollbackError), err)
+		content, err := os.ReadFile(filepath.Join(secrets, "foo"))
+		require.Nil(t, err)
+		require.Equal(t, "v2", string(content))
+
+		body = []byte(`{"foo": {"Value": "v3"}}`)
+		version = 3
+		require.Nil(t, app.CheckIn())
+		content, err = os.ReadFile(filepath.Join(secrets, "foo"))
+		require.Nil(t, err)
+		require.Equal(t, "v3", string(content))
+	})
+}
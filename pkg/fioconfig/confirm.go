@@ -0,0 +1,267 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// With confirm_timeout set, a new config has to be confirmed healthy
+// before it's committed to config.encrypted. confirm_command succeeding,
+// every unit in confirm_units being active, or `fioconfig confirm` all
+// confirm it. Otherwise the previous config is put back once the timeout
+// expires, so a change that breaks the service meant to confirm it undoes
+// itself. The reverted config isn't applied again until the server's
+// config changes.
+
+// ConfigNotConfirmedError is returned by the check-in that reverted a new
+// config because it wasn't confirmed in time
+var ConfigNotConfirmedError = errors.New("New config was not confirmed")
+
+// ConfigRevertedError is returned by check-ins that found the config that
+// was reverted still on the server. It matches NotModifiedError since
+// nothing was applied.
+var ConfigRevertedError error = configRevertedError{}
+
+type configRevertedError struct{}
+
+func (configRevertedError) Error() string {
+	return "Config on server was reverted because it was not confirmed"
+}
+
+func (configRevertedError) Is(target error) bool {
+	return target == NotModifiedError
+}
+
+// How often the confirmation checks run while waiting
+var confirmPollInterval = 5 * time.Second
+
+// confirmState tracks a config that's waiting to be confirmed, or that
+// was reverted. The unconfirmed config is kept so it can be reverted if
+// fioconfig is restarted while waiting.
+type confirmState struct {
+	Sha256    string
+	Deadline  time.Time
+	Confirmed bool `json:",omitempty"`
+	Reverted  bool `json:",omitempty"`
+}
+
+func (a *App) confirmFile() string {
+	return filepath.Join(a.stateDir(), "confirm.json")
+}
+
+func (a *App) unconfirmedConfig() string {
+	return filepath.Join(a.stateDir(), "config.unconfirmed")
+}
+
+func (a *App) confirmTimeout() (time.Duration, error) {
+	if len(a.settings.ConfirmTimeout) == 0 {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(a.settings.ConfirmTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid fioconfig.confirm_timeout: %w", err)
+	}
+	return timeout, nil
+}
+
+func (a *App) loadConfirmState() *confirmState {
+	buf, err := os.ReadFile(a.confirmFile())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to read confirmation state: %s", err)
+		}
+		return nil
+	}
+	var state confirmState
+	if err := json.Unmarshal(buf, &state); err != nil {
+		logger.Printf("Unable to parse confirmation state: %s", err)
+		return nil
+	}
+	return &state
+}
+
+func (a *App) saveConfirmState(state *confirmState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return safeWrite(a.confirmFile(), buf)
+}
+
+func (a *App) clearConfirmState() {
+	for _, path := range []string{a.confirmFile(), a.unconfirmedConfig()} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to remove %s: %s", path, err)
+		}
+	}
+}
+
+// Confirm marks the config waiting for confirmation as healthy, so the
+// check-in that applied it commits it
+func (a *App) Confirm() error {
+	state := a.loadConfirmState()
+	if state == nil || state.Reverted {
+		return errors.New("No config is waiting for confirmation")
+	}
+	state.Confirmed = true
+	return a.saveConfirmState(state)
+}
+
+// confirmReverted returns true if sha identifies the config that was last
+// reverted. A different config means the server has moved on.
+func (a *App) confirmReverted(sha string) bool {
+	state := a.loadConfirmState()
+	if state == nil || !state.Reverted {
+		return false
+	}
+	if state.Sha256 == sha {
+		return true
+	}
+	a.clearConfirmState()
+	return false
+}
+
+// healthy runs the automatic confirmation checks, if there are any
+func (a *App) healthy(ctx context.Context) bool {
+	if len(a.settings.ConfirmCommand) == 0 && len(a.settings.ConfirmUnits) == 0 {
+		return false
+	}
+	if len(a.settings.ConfirmCommand) > 0 {
+		cmd := exec.CommandContext(ctx, a.settings.ConfirmCommand[0], a.settings.ConfirmCommand[1:]...)
+		if err := cmd.Run(); err != nil {
+			a.debugf("Confirmation command failed: %s", err)
+			return false
+		}
+	}
+	if len(a.settings.ConfirmUnits) > 0 {
+		conn, err := newUnitManager(ctx)
+		if err != nil {
+			a.debugf("Unable to connect to systemd: %s", err)
+			return false
+		}
+		defer conn.Close()
+		for _, unit := range a.settings.ConfirmUnits {
+			prop, err := conn.GetUnitPropertyContext(ctx, unit, "ActiveState")
+			if err != nil {
+				return false
+			}
+			if state, _ := prop.Value.Value().(string); state != "active" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// awaitConfirmation waits for config.next, just extracted, to be confirmed
+// and reverts to config.prev if it isn't. encrypted is the device's config
+// that config.next came from and sha identifies config.next along with its
+// layers. The first config a device gets has nothing to go back to, so
+// it's never waited for.
+func (a *App) awaitConfirmation(ctx context.Context, client *http.Client, crypto CryptoHandler, config configSnapshot, encrypted []byte, sha string) error {
+	timeout, err := a.confirmTimeout()
+	if err != nil || timeout == 0 || config.prev == nil {
+		return err
+	}
+	state := a.loadConfirmState()
+	if state == nil || state.Sha256 != sha {
+		// Kept so a restart while waiting can still revert it
+		if err := a.writeCache(a.unconfirmedConfig(), encrypted); err != nil {
+			return err
+		}
+		state = &confirmState{Sha256: sha, Deadline: time.Now().Add(timeout)}
+		if err := a.saveConfirmState(state); err != nil {
+			return err
+		}
+	}
+	LogEvent(EventAwaitingConfirm, "Waiting until %s for the new config to be confirmed", state.Deadline.Format(time.RFC3339))
+	for {
+		if state = a.loadConfirmState(); state != nil && state.Confirmed {
+			break
+		}
+		checkCtx, cancel := context.WithDeadline(ctx, time.Now().Add(confirmPollInterval))
+		ok := a.healthy(checkCtx)
+		cancel()
+		if ok {
+			break
+		}
+		if state != nil && time.Now().After(state.Deadline) {
+			return a.revertUnconfirmed(ctx, client, crypto, configSnapshot{prev: config.next, next: config.prev}, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			// Reverted when fioconfig starts again
+			return ctx.Err()
+		case <-time.After(confirmPollInterval):
+		}
+	}
+	LogEvent(EventConfigConfirmed, "New config confirmed")
+	a.clearConfirmState()
+	return nil
+}
+
+// revertUnconfirmed puts config.next back in place of config.prev, the
+// config that wasn't confirmed
+func (a *App) revertUnconfirmed(ctx context.Context, client *http.Client, crypto CryptoHandler, config configSnapshot, timeout time.Duration) error {
+	reason := fmt.Sprintf("%s within %s", ConfigNotConfirmedError, timeout)
+	LogEvent(EventConfigAutoReverted, "%s, reverting to the previous config", reason)
+	report, err := a.extract(ctx, crypto, config)
+	report.Code = EventConfigAutoReverted
+	report.Rejected = reason
+	a.publishExtract(report, err, a.latestVersion())
+	a.reportStatus(ctx, client, report)
+	if err != nil {
+		return &ExtractFailure{fmt.Errorf("Unable to revert unconfirmed config: %w", err)}
+	}
+	if state := a.loadConfirmState(); state != nil {
+		state.Reverted = true
+		if err := a.saveConfirmState(state); err != nil {
+			logger.Printf("Unable to save confirmation state: %s", err)
+		}
+	}
+	if err := os.Remove(a.unconfirmedConfig()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Printf("Unable to remove %s: %s", a.unconfirmedConfig(), err)
+	}
+	return &ExtractFailure{fmt.Errorf("%w within %s", ConfigNotConfirmedError, timeout)}
+}
+
+// recoverUnconfirmed reverts a config that was still waiting for
+// confirmation when fioconfig stopped, unless it was confirmed
+func (a *App) recoverUnconfirmed(ctx context.Context, client *http.Client, crypto CryptoHandler) error {
+	state := a.loadConfirmState()
+	if state == nil || state.Reverted || state.Confirmed {
+		return nil
+	}
+	timeout, err := a.confirmTimeout()
+	if err != nil {
+		return err
+	}
+	var config configSnapshot
+	if config.prev, err = a.unmarshallCache(crypto, a.unconfirmedConfig(), false); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			a.clearConfirmState()
+			return nil
+		}
+		return err
+	}
+	if config.next, err = a.unmarshallCache(crypto, a.EncryptedConfig, true); err != nil {
+		return err
+	}
+	// The layers are saved once the config is confirmed, so these are the
+	// ones to go back to
+	layers, err := a.loadLayers(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if config, err = layers.apply(crypto, config); err != nil {
+		return err
+	}
+	return a.revertUnconfirmed(ctx, client, crypto, config, timeout)
+}
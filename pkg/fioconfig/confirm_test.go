@@ -0,0 +1,112 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirmedApply(t *testing.T) {
+	var encbuf []byte
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(201)
+			return
+		}
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+	orig := confirmPollInterval
+	confirmPollInterval = 10 * time.Millisecond
+	defer func() { confirmPollInterval = orig }()
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		cached, err := os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		app.configUrl += "/config"
+		setBar := func(value string) {
+			var config map[string]*ConfigFile
+			require.Nil(t, json.Unmarshal(cached, &config))
+			config["bar"].Value = value
+			encbuf, err = json.Marshal(config)
+			require.Nil(t, err)
+		}
+		bar := filepath.Join(tempdir, "bar")
+		ready := filepath.Join(tempdir, "ready")
+		app.settings.ConfirmTimeout = "200ms"
+		app.settings.ConfirmCommand = []string{"/bin/sh", "-c", "test -f " + ready}
+
+		// Not confirmed in time, so the previous config is put back
+		setBar("broken bar")
+		err = app.checkin(context.Background(), client, crypto)
+		require.True(t, errors.Is(err, ConfigNotConfirmedError), err)
+		require.True(t, errors.Is(err, ExtractFailedError))
+		require.Equal(t, "reverted", CheckInResult(err))
+		assertFile(t, bar, []byte("bar file value"))
+		cache, err := os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Equal(t, cached, cache)
+		assertNoFile(t, app.unconfirmedConfig())
+
+		// and isn't applied again
+		err = app.checkin(context.Background(), client, crypto)
+		require.Equal(t, ConfigRevertedError, err)
+		require.True(t, errors.Is(err, NotModifiedError))
+		assertFile(t, bar, []byte("bar file value"))
+
+		// A new config on the server that's confirmed by the command
+		require.Nil(t, os.WriteFile(ready, nil, 0o644))
+		setBar("good bar")
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertFile(t, bar, []byte("good bar"))
+		assertNoFile(t, app.confirmFile())
+		cache, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Equal(t, encbuf, cache)
+		cached = cache
+
+		// Confirmed by hand
+		require.NotNil(t, app.Confirm())
+		app.settings.ConfirmCommand = nil
+		app.settings.ConfirmTimeout = "10s"
+		setBar("confirmed bar")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for app.Confirm() != nil {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		<-done
+		assertFile(t, bar, []byte("confirmed bar"))
+		cached, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+
+		// Reverted by the next check-in when fioconfig stopped waiting
+		setBar("interrupted bar")
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for app.loadConfirmState() == nil {
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+		}()
+		require.True(t, errors.Is(app.checkin(ctx, client, crypto), context.Canceled))
+		assertFile(t, bar, []byte("interrupted bar"))
+		err = app.checkin(context.Background(), client, crypto)
+		require.True(t, errors.Is(err, ConfigNotConfirmedError), err)
+		assertFile(t, bar, []byte("confirmed bar"))
+		require.Equal(t, ConfigRevertedError, app.checkin(context.Background(), client, crypto))
+	})
+}
@@ -0,0 +1,238 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The daemon listens on a unix socket so tools on the device can talk to
+// it. A client sends one JSON request, e.g. {"command": "watch"}, and the
+// daemon replies on the same connection. "check-in" asks the daemon to
+// check in now rather than at its next interval.
+
+// DefaultControlSocket is where the daemon listens unless control_socket
+// says otherwise
+const DefaultControlSocket = "/run/fioconfig/control.sock"
+
+type controlRequest struct {
+	Command string `json:"command"`
+}
+
+type controlError struct {
+	Error string `json:"error,omitempty"`
+}
+
+// WatchEvent is a ChangeEvent as streamed to `fioconfig watch`
+type WatchEvent struct {
+	Type    ChangeType     `json:"type"`
+	Time    time.Time      `json:"time"`
+	File    string         `json:"file,omitempty"`
+	Version int            `json:"version,omitempty"`
+	Files   []string       `json:"files,omitempty"`
+	Handler *HandlerResult `json:"handler,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+func newWatchEvent(event ChangeEvent) WatchEvent {
+	e := WatchEvent{
+		Type:    event.Type,
+		Time:    event.Time,
+		File:    event.File,
+		Version: event.Version,
+		Files:   event.Files,
+		Handler: event.Handler,
+	}
+	if event.Err != nil {
+		e.Error = event.Err.Error()
+	}
+	return e
+}
+
+func (e WatchEvent) String() string {
+	line := e.Time.Format(time.RFC3339) + " " + string(e.Type)
+	if h := e.Handler; h != nil {
+		name := h.File
+		if len(name) == 0 {
+			name = "after-extract"
+		}
+		line += fmt.Sprintf(" %s: %v exited %d", name, h.Command, h.ExitCode)
+		if len(h.Error) > 0 {
+			line += ": " + h.Error
+		}
+	} else if len(e.File) > 0 {
+		line += " " + e.File
+	} else if len(e.Files) > 0 {
+		line += fmt.Sprintf(" v%d: %s", e.Version, strings.Join(e.Files, ", "))
+	}
+	if len(e.Error) > 0 {
+		line += ": " + e.Error
+	}
+	return line
+}
+
+// ControlSocket is where the daemon listens for tools on the device
+func (a *App) ControlSocket() string {
+	if len(a.settings.ControlSocket) > 0 {
+		return a.settings.ControlSocket
+	}
+	return DefaultControlSocket
+}
+
+// StartControlSocket listens on the control socket for `fioconfig watch`.
+// Only the daemon's user and root can connect. The returned function stops
+// listening and disconnects clients.
+func (a *App) StartControlSocket() (func(), error) {
+	path := a.ControlSocket()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Unable to remove stale control socket: %w", err)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("Unable to restrict access to control socket: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			conn, err := l.AcceptUnix()
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Printf("ERROR: Control socket stopped: %s", err)
+				}
+				return
+			}
+			go a.serveControlConn(ctx, conn)
+		}
+	}()
+	return func() {
+		cancel()
+		l.Close()
+	}, nil
+}
+
+func (a *App) serveControlConn(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+	var req controlRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		logger.Printf("ERROR: Invalid control request: %s", err)
+		return
+	}
+	switch req.Command {
+	case "watch":
+		a.streamEvents(ctx, conn)
+	case "check-in":
+		a.RequestCheckIn()
+		_ = json.NewEncoder(conn).Encode(controlError{})
+	default:
+		_ = json.NewEncoder(conn).Encode(controlError{fmt.Sprintf("Unknown command: %s", req.Command)})
+	}
+}
+
+// streamEvents writes the App's change events to conn, one JSON object per
+// line, until the client disconnects. A client too slow to keep up misses
+// events rather than holding up a check-in.
+func (a *App) streamEvents(ctx context.Context, conn *net.UnixConn) {
+	events := make(chan ChangeEvent, 64)
+	defer a.SubscribeChan(events)()
+	gone := make(chan struct{})
+	go func() {
+		_, _ = conn.Read(make([]byte, 1))
+		close(gone)
+	}()
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-gone:
+			return
+		case event := <-events:
+			if err := enc.Encode(newWatchEvent(event)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// RequestCheckIn asks the daemon to check in as soon as the current
+// check-in, if any, is done. Requests made in the meantime are merged.
+func (a *App) RequestCheckIn() {
+	select {
+	case a.checkInRequests <- struct{}{}:
+	default:
+	}
+}
+
+// CheckInRequests receives a value when a check-in is requested
+func (a *App) CheckInRequests() <-chan struct{} {
+	return a.checkInRequests
+}
+
+// RequestDaemonCheckIn asks the daemon listening on socket to check in now
+func RequestDaemonCheckIn(socket string) error {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to the daemon: %w", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(controlRequest{"check-in"}); err != nil {
+		return err
+	}
+	var reply controlError
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return fmt.Errorf("Invalid reply from the daemon: %w", err)
+	} else if len(reply.Error) > 0 {
+		return errors.New(reply.Error)
+	}
+	return nil
+}
+
+// Watch connects to the daemon's control socket and calls cb with each of
+// its change events until ctx is done or the daemon goes away.
+func Watch(ctx context.Context, socket string, cb func(WatchEvent)) error {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to the daemon: %w", err)
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := json.NewEncoder(conn).Encode(controlRequest{"watch"}); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var event WatchEvent
+		if err := dec.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			} else if errors.Is(err, io.EOF) {
+				return errors.New("The daemon closed the connection")
+			}
+			return err
+		}
+		cb(event)
+	}
+}
@@ -0,0 +1,52 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		app.settings.ControlSocket = filepath.Join(tempdir, "run", "control.sock")
+		stop, err := app.StartControlSocket()
+		require.Nil(t, err)
+		defer stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan WatchEvent, 16)
+		watchErr := make(chan error, 1)
+		go func() {
+			watchErr <- Watch(ctx, app.ControlSocket(), func(e WatchEvent) { events <- e })
+		}()
+		// Wait for the watcher to subscribe
+		require.Eventually(t, func() bool {
+			app.subscribers.lock.Lock()
+			defer app.subscribers.lock.Unlock()
+			return len(app.subscribers.subs) > 0
+		}, time.Second, 10*time.Millisecond)
+
+		require.Nil(t, app.Extract())
+		e := <-events
+		require.Equal(t, ChangeHandlerRun, e.Type)
+		require.Equal(t, "bar", e.Handler.File)
+		require.Contains(t, e.String(), " handler-run bar: [/usr/bin/touch ")
+
+		cancel()
+		require.Nil(t, <-watchErr)
+
+		conn, err := net.Dial("unix", app.ControlSocket())
+		require.Nil(t, err)
+		defer conn.Close()
+		require.Nil(t, json.NewEncoder(conn).Encode(controlRequest{"bogus"}))
+		var res controlError
+		require.Nil(t, json.NewDecoder(conn).Decode(&res))
+		require.Equal(t, "Unknown command: bogus", res.Error)
+	})
+}
@@ -0,0 +1,83 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultCredentialsDir is one of the directories systemd looks in for a
+// LoadCredential= without a path. It's a tmpfs, so credentials are written
+// again after a reboot even if the config hasn't changed.
+const DefaultCredentialsDir = "/run/credstore"
+
+// credentialFileMeta makes credentials readable by root only. systemd
+// copies them into the unit's own credentials directory.
+var credentialFileMeta = fileMeta{0o600, -1, -1}
+
+func (a *App) credentialsDir() string {
+	if len(a.settings.CredentialsDir) > 0 {
+		return a.settings.CredentialsDir
+	}
+	return DefaultCredentialsDir
+}
+
+// validateCredential checks a file's credential name is one systemd accepts
+func validateCredential(name string) error {
+	if len(name) == 0 {
+		return nil
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\x00") || len(name) > 255 {
+		return fmt.Errorf("Invalid credential name %q", name)
+	}
+	return nil
+}
+
+// writeCredentials puts the files with a Credential name in the credentials
+// directory, where services can load them with LoadCredential=<name> rather
+// than reading the secrets directory, and removes the ones no file provides
+// anymore.
+func (a *App) writeCredentials(ctx context.Context, c *committedConfig) {
+	dir := a.credentialsDir()
+	wanted := make(map[string]bool)
+	for _, fname := range sortedNames(c.next) {
+		cfgFile := c.next[fname]
+		if len(cfgFile.Credential) == 0 {
+			continue
+		}
+		wanted[cfgFile.Credential] = true
+		content, err := cfgFile.content()
+		if err != nil {
+			continue // stage already reported it
+		}
+		path := filepath.Join(dir, cfgFile.Credential)
+		cur, err := os.ReadFile(path)
+		if err == nil && bytes.Equal(cur, content) {
+			zeroize(content)
+			continue
+		}
+		zeroize(cur)
+		LogEventWith(EventCredentialWritten, LogFields{"file": fname}, "Writing %s as credential %s", fname, cfgFile.Credential)
+		err = os.MkdirAll(dir, 0o700)
+		if err == nil {
+			err = safeWriteMeta(path, content, credentialFileMeta)
+		}
+		zeroize(content)
+		if err != nil {
+			c.report.fail(fname, fmt.Errorf("Unable to write credential %s: %w", cfgFile.Credential, err))
+		}
+	}
+	for _, fname := range sortedNames(c.prev) {
+		name := c.prev[fname].Credential
+		if len(name) == 0 || wanted[name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to remove credential %s: %s", name, err)
+		}
+	}
+}
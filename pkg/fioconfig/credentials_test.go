@@ -0,0 +1,53 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCredentials(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		credstore := filepath.Join(tempdir, "credstore")
+		app.settings.CredentialsDir = credstore
+		config := ConfigStruct{
+			"db/password": &ConfigFile{Value: "hunter2", Credential: "db-password"},
+			"plain":       &ConfigFile{Value: "plain"},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertFile(t, filepath.Join(credstore, "db-password"), []byte("hunter2"))
+		st, err := os.Stat(filepath.Join(credstore, "db-password"))
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+		entries, err := os.ReadDir(credstore)
+		require.Nil(t, err)
+		require.Len(t, entries, 1)
+
+		// Credentials are written again if they're lost, e.g. on reboot
+		require.Nil(t, os.RemoveAll(credstore))
+		_, err = app.extract(context.Background(), crypto, configSnapshot{config, config})
+		require.Nil(t, err)
+		assertFile(t, filepath.Join(credstore, "db-password"), []byte("hunter2"))
+
+		// and removed when no file provides them
+		next := ConfigStruct{"plain": config["plain"]}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{config, next})
+		require.Nil(t, err)
+		_, err = os.Stat(filepath.Join(credstore, "db-password"))
+		require.True(t, errors.Is(err, os.ErrNotExist))
+
+		bad := ConfigStruct{"bad": &ConfigFile{Value: "x", Credential: "../escape"}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{next, bad})
+		require.NotNil(t, err)
+	})
+}
@@ -0,0 +1,48 @@
+package fioconfig
+
+import (
+	"os"
+)
+
+// CurrentConfig returns the config the device last downloaded with its
+// values decrypted. Nothing is written to disk, so tooling can inspect the
+// config without touching the secrets directory.
+func (a *App) CurrentConfig() (ConfigStruct, error) {
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return nil, err
+	}
+	defer a.releaseCrypto(crypto)
+	return a.unmarshallCache(crypto, a.EncryptedConfig, true)
+}
+
+// ReadFile returns a file from CurrentConfig as it would be extracted,
+// i.e., decoded and with templates rendered. Files not in the config return
+// an error matching os.ErrNotExist.
+func (a *App) ReadFile(name string) ([]byte, error) {
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return nil, err
+	}
+	defer a.releaseCrypto(crypto)
+	config, err := a.unmarshallCache(crypto, a.EncryptedConfig, true)
+	if err != nil {
+		return nil, err
+	}
+	cfgFile, ok := config[name]
+	if !ok {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	}
+	secrets := a.newLocalSecrets(crypto)
+	defer secrets.close()
+	config = ConfigStruct{name: cfgFile}
+	if config, err = a.resolveLocalSecrets(config, secrets, newExtractReport()); err != nil {
+		return nil, err
+	}
+	if cfgFile.Template {
+		if config, err = a.renderTemplates(config, secrets, newExtractReport()); err != nil {
+			return nil, err
+		}
+	}
+	return config[name].content()
+}
@@ -0,0 +1,34 @@
+package fioconfig
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCurrentConfig(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		config, err := app.CurrentConfig()
+		require.Nil(t, err)
+		require.Equal(t, "foo file value", config["foo"].Value)
+		require.Equal(t, "bar file value", config["bar"].Value)
+
+		content, err := app.ReadFile("with/subdir/1.txt")
+		require.Nil(t, err)
+		require.Equal(t, "sub", string(content))
+		content, err = app.ReadFile("random")
+		require.Nil(t, err)
+		require.Equal(t, config["random"].Value, string(content))
+
+		_, err = app.ReadFile("missing")
+		require.True(t, errors.Is(err, os.ErrNotExist), err)
+
+		// Nothing was extracted
+		_, err = os.Stat(filepath.Join(tempdir, "foo"))
+		require.True(t, errors.Is(err, os.ErrNotExist))
+	})
+}
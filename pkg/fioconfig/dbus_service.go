@@ -0,0 +1,127 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// The object the daemon publishes on the system bus when dbus_service is
+// set. contrib/dbus has the policy that lets it own the name.
+const (
+	DBusName      = "io.foundries.fioconfig"
+	DBusPath      = dbus.ObjectPath("/io/foundries/fioconfig")
+	DBusInterface = "io.foundries.fioconfig1"
+)
+
+// dbusSignals is the part of a bus connection the service emits signals
+// with, so tests can swap it out
+type dbusSignals interface {
+	Emit(path dbus.ObjectPath, name string, values ...interface{}) error
+}
+
+type dbusService struct {
+	app     *App
+	signals dbusSignals
+	setProp func(name string, value interface{})
+}
+
+// onChange emits FileChanged(file, change) for file events and
+// CheckedIn(result) for check-ins, and updates the properties
+func (s *dbusService) onChange(event ChangeEvent) {
+	var err error
+	switch event.Type {
+	case ChangeFileAdded, ChangeFileChanged, ChangeFileRemoved:
+		err = s.signals.Emit(DBusPath, DBusInterface+".FileChanged", event.File, string(event.Type))
+	case ChangeCheckInSucceeded, ChangeCheckInFailed:
+		result := "succeeded"
+		if event.Type == ChangeCheckInFailed {
+			result = CheckInResult(event.Err)
+			s.setProp("LastCheckInResult", result)
+		} else {
+			s.setProp("LastCheckIn", event.Time.Unix())
+			s.setProp("LastCheckInResult", result)
+			s.setProp("ConfigVersion", int32(s.app.latestVersion()))
+		}
+		err = s.signals.Emit(DBusPath, DBusInterface+".CheckedIn", result)
+	}
+	if err != nil {
+		logger.Printf("ERROR: Unable to emit D-Bus signal: %s", err)
+	}
+}
+
+func dbusIntrospection(props *prop.Properties) introspect.Introspectable {
+	node := &introspect.Node{
+		Name: string(DBusPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{
+				Name:       DBusInterface,
+				Properties: props.Introspection(DBusInterface),
+				Signals: []introspect.Signal{
+					{Name: "FileChanged", Args: []introspect.Arg{
+						{Name: "file", Type: "s"},
+						{Name: "change", Type: "s"},
+					}},
+					{Name: "CheckedIn", Args: []introspect.Arg{
+						{Name: "result", Type: "s"},
+					}},
+				},
+			},
+		},
+	}
+	return introspect.NewIntrospectable(node)
+}
+
+// StartDBusService publishes the App on the system bus when dbus_service
+// is set, so services on the device can subscribe to config changes rather
+// than polling the secrets directory. The returned function stops it.
+func (a *App) StartDBusService() (func(), error) {
+	if !a.settings.DBusService {
+		return func() {}, nil
+	}
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to the system bus: %w", err)
+	}
+	props, err := prop.Export(conn, DBusPath, map[string]map[string]*prop.Prop{
+		DBusInterface: {
+			"LastCheckIn":       {Value: int64(0), Emit: prop.EmitTrue},
+			"LastCheckInResult": {Value: "", Emit: prop.EmitTrue},
+			"ConfigVersion":     {Value: int32(a.latestVersion()), Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Unable to export D-Bus properties: %w", err)
+	}
+	if err := conn.Export(dbusIntrospection(props), DBusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Unable to export D-Bus introspection: %w", err)
+	}
+	reply, err := conn.RequestName(DBusName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		if err == nil {
+			err = errors.New("name is owned by another process")
+		}
+		return nil, fmt.Errorf("Unable to own D-Bus name %s: %w", DBusName, err)
+	}
+	s := &dbusService{
+		app:     a,
+		signals: conn,
+		setProp: func(name string, value interface{}) {
+			props.SetMust(DBusInterface, name, value)
+		},
+	}
+	unsubscribe := a.Subscribe(s.onChange)
+	logger.Printf("Publishing config changes on D-Bus as %s", DBusName)
+	return func() {
+		unsubscribe()
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,61 @@
+package fioconfig
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+)
+
+type dbusSignal struct {
+	name   string
+	values []interface{}
+}
+
+type fakeSignals struct {
+	sent []dbusSignal
+}
+
+func (f *fakeSignals) Emit(path dbus.ObjectPath, name string, values ...interface{}) error {
+	if path != DBusPath {
+		return errors.New("wrong path")
+	}
+	f.sent = append(f.sent, dbusSignal{name, values})
+	return nil
+}
+
+func TestDBusService(t *testing.T) {
+	app := &App{sotaConfig: t.TempDir()}
+	signals := &fakeSignals{}
+	props := make(map[string]interface{})
+	s := &dbusService{app, signals, func(name string, value interface{}) {
+		props[name] = value
+	}}
+	unsubscribe := app.Subscribe(s.onChange)
+	defer unsubscribe()
+
+	app.publish(ChangeEvent{Type: ChangeFileChanged, File: "foo"})
+	app.publish(ChangeEvent{Type: ChangeCheckInSucceeded})
+	require.Equal(t, []dbusSignal{
+		{DBusInterface + ".FileChanged", []interface{}{"foo", "file-changed"}},
+		{DBusInterface + ".CheckedIn", []interface{}{"succeeded"}},
+	}, signals.sent)
+	require.Equal(t, "succeeded", props["LastCheckInResult"])
+	require.Equal(t, int32(0), props["ConfigVersion"])
+	require.InDelta(t, time.Now().Unix(), props["LastCheckIn"], 5)
+
+	lastCheckIn := props["LastCheckIn"]
+	app.publish(ChangeEvent{Type: ChangeCheckInFailed, Err: &UnreachableError{"url", errors.New("down")}})
+	require.Equal(t, dbusSignal{DBusInterface + ".CheckedIn", []interface{}{"unreachable"}}, signals.sent[2])
+	require.Equal(t, "unreachable", props["LastCheckInResult"])
+	require.Equal(t, lastCheckIn, props["LastCheckIn"])
+}
+
+func TestDBusServiceDisabled(t *testing.T) {
+	app := &App{}
+	stop, err := app.StartDBusService()
+	require.Nil(t, err)
+	stop()
+}
@@ -0,0 +1,60 @@
+package fioconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Delta downloads follow the approach of RFC 3229. When we have a config
+// from the server we send `A-IM: fioconfig-delta` along with its ETag. A
+// server supporting deltas responds with "226 IM Used" and a body containing
+// only the files that changed or were removed since the Delta-Base version.
+const deltaIM = "fioconfig-delta"
+
+type configDelta struct {
+	Changed ConfigStruct `json:"changed"`
+	Removed []string     `json:"removed"`
+}
+
+// applyDelta merges a 226 response onto the current config.encrypted and
+// returns the resulting full, still encrypted, config.
+func (a *App) applyDelta(res *httpRes, baseETag string) ([]byte, error) {
+	if im := res.Header.Get("IM"); im != deltaIM {
+		return nil, fmt.Errorf("Unsupported instance manipulation: %s", im)
+	}
+	if base := res.Header.Get("Delta-Base"); base != baseETag {
+		return nil, fmt.Errorf("Delta base %s does not match local config version %s", base, baseETag)
+	}
+	var delta configDelta
+	if err := res.Json(&delta); err != nil {
+		return nil, fmt.Errorf("Unable to parse config delta: %w", err)
+	}
+
+	content, err := a.readCache(a.EncryptedConfig)
+	if err != nil {
+		return nil, err
+	}
+	if !isJsonObject(content) {
+		return nil, errors.New("Current config is not JSON and can't be patched")
+	}
+	var config ConfigStruct
+	if err = json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("Unable to parse current config: %w", err)
+	}
+	for name, cfgFile := range delta.Changed {
+		config[name] = cfgFile
+	}
+	for _, name := range delta.Removed {
+		delete(config, name)
+	}
+	return json.Marshal(config)
+}
+
+// isJsonObject reports whether content is a JSON config that a delta can be
+// applied to. Age bundles and payloads kept as served can't be.
+func isJsonObject(content []byte) bool {
+	content = bytes.TrimLeft(content, " \t\r\n")
+	return len(content) > 0 && content[0] == '{'
+}
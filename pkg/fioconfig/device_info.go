@@ -0,0 +1,45 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DeviceInfo identifies the device to support teams
+type DeviceInfo struct {
+	DeviceUUID string // Common name of the client certificate
+	Factory    string `json:",omitempty"` // Organizational unit of the client certificate
+	CertExpiry time.Time
+	ConfigUrl  string
+	KeySource  string // tls.pkey_source, e.g. "file" or "pkcs11"
+}
+
+func (d DeviceInfo) String() string {
+	return fmt.Sprintf("Device UUID: %s\nFactory: %s\nCertificate expires: %s\nConfig URL: %s\nKey source: %s\n",
+		d.DeviceUUID, d.Factory, d.CertExpiry.Format(time.RFC3339), d.ConfigUrl, d.KeySource)
+}
+
+// DeviceInfo returns who the device is to the server based on its client
+// certificate, along with where it checks in and how its key is held.
+func (a *App) DeviceInfo() (*DeviceInfo, error) {
+	client, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return nil, err
+	}
+	a.releaseCrypto(crypto)
+	cert := clientCert(client)
+	if cert == nil {
+		return nil, errors.New("Unable to read client certificate")
+	}
+	info := DeviceInfo{
+		DeviceUUID: cert.Subject.CommonName,
+		CertExpiry: cert.NotAfter,
+		ConfigUrl:  a.configUrl,
+	}
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		info.Factory = cert.Subject.OrganizationalUnit[0]
+	}
+	info.KeySource, _ = tomlGet(a.sota, "tls.pkey_source")
+	return &info, nil
+}
@@ -0,0 +1,26 @@
+package fioconfig
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceInfo(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		block, _ := pem.Decode([]byte(client_pem))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.Nil(t, err)
+
+		info, err := app.DeviceInfo()
+		require.Nil(t, err)
+		require.Equal(t, cert.Subject.CommonName, info.DeviceUUID)
+		require.Equal(t, cert.NotAfter, info.CertExpiry)
+		require.Equal(t, app.configUrl, info.ConfigUrl)
+		require.Equal(t, "file", info.KeySource)
+		require.Contains(t, info.String(), "Device UUID: "+cert.Subject.CommonName+"\n")
+	})
+}
@@ -0,0 +1,100 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DiagnosticResult is the outcome of one layer of Diagnose
+type DiagnosticResult struct {
+	Name string
+	Err  error
+	Hint string // Remediation hint when Err is set
+}
+
+func (r DiagnosticResult) String() string {
+	if r.Err == nil {
+		return fmt.Sprintf("[PASS] %s", r.Name)
+	}
+	return fmt.Sprintf("[FAIL] %s: %s\n       Hint: %s", r.Name, r.Err, r.Hint)
+}
+
+// Diagnose runs layered connectivity checks against the config server:
+// DNS, TCP, TLS, HTTP, and finally decryption of the config. Checks stop at
+// the first layer that fails since the following layers depend on it.
+func (a *App) Diagnose() []DiagnosticResult {
+	var results []DiagnosticResult
+	add := func(name, hint string, err error) bool {
+		results = append(results, DiagnosticResult{name, err, hint})
+		return err == nil
+	}
+
+	client, crypto, err := createClient(a.sota)
+	if err != nil {
+		add("Load device identity", "Check the tls and import sections of sota.toml", err)
+		return results
+	}
+	defer crypto.Close()
+
+	u, err := url.Parse(a.configUrl)
+	if !add("Parse config URL", "Check tls.server in sota.toml or the CONFIG_URL environment variable", err) {
+		return results
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	_, err = net.LookupHost(u.Hostname())
+	if !add("DNS resolve "+u.Hostname(), "Check the network is up and /etc/resolv.conf has working nameservers", err) {
+		return results
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if !add("TCP connect "+addr, "Check firewall rules and whether this network requires a proxy", err) {
+		return results
+	}
+	conn.Close()
+
+	tlsConfig := client.Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.ServerName = u.Hostname()
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	tlsConn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	if !add("TLS handshake", "Check import.tls_cacert_path matches the server and the system clock is correct", err) {
+		return results
+	}
+	tlsConn.Close()
+
+	res, err := httpDoOnce(context.Background(), client, http.MethodGet, a.configUrl, nil, nil)
+	if err == nil {
+		switch res.StatusCode {
+		case 200, 204:
+		case 401, 403:
+			err = fmt.Errorf("HTTP_%d: %s", res.StatusCode, strings.TrimSpace(res.String()))
+			return append(results, DiagnosticResult{"HTTP GET", err, "The server rejected the client certificate. Check it is valid and belongs to this factory"})
+		default:
+			err = fmt.Errorf("HTTP_%d: %s", res.StatusCode, strings.TrimSpace(res.String()))
+		}
+	}
+	if !add("HTTP GET", "The server could not handle the request. Try again later or contact support", err) {
+		return results
+	}
+
+	if res.StatusCode == 204 {
+		add("Decrypt config (device has no config defined)", "", nil)
+		return results
+	}
+	_, err = UnmarshallBuffer(crypto, res.Body, true)
+	add("Decrypt config", "The config was not encrypted to this device's key. Compare `fioconfig pubkey` with the key the server has", err)
+	return results
+}
@@ -0,0 +1,45 @@
+package fioconfig
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnose(t *testing.T) {
+	var encbuf []byte
+	status := 200
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		var err error
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+
+		results := app.Diagnose()
+		require.Len(t, results, 6)
+		for _, result := range results {
+			require.Nil(t, result.Err, result.String())
+		}
+
+		status = 403
+		results = app.Diagnose()
+		last := results[len(results)-1]
+		require.Equal(t, "HTTP GET", last.Name)
+		require.NotNil(t, last.Err)
+		require.Contains(t, last.Hint, "client certificate")
+
+		status = 200
+		encbuf = []byte(`{"foo": {"Value": "not encrypted"}}`)
+		results = app.Diagnose()
+		last = results[len(results)-1]
+		require.Equal(t, "Decrypt config", last.Name)
+		require.NotNil(t, last.Err)
+	})
+}
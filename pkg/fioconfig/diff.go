@@ -0,0 +1,111 @@
+package fioconfig
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	FileAdded   = "added"
+	FileChanged = "changed"
+	FileRemoved = "removed"
+)
+
+// FileChange describes how a file differs between two configs
+type FileChange struct {
+	Name    string
+	Action  string // FileAdded, FileChanged, or FileRemoved
+	OldHash string `json:",omitempty"`
+	NewHash string `json:",omitempty"`
+	Diff    string `json:",omitempty"` // Unified diff of the values when requested
+}
+
+func (f FileChange) String() string {
+	switch f.Action {
+	case FileAdded:
+		return fmt.Sprintf("A %s sha256:%s", f.Name, f.NewHash)
+	case FileRemoved:
+		return fmt.Sprintf("D %s sha256:%s", f.Name, f.OldHash)
+	}
+	return fmt.Sprintf("M %s sha256:%s -> sha256:%s", f.Name, f.OldHash, f.NewHash)
+}
+
+func valueHash(cfgFile *ConfigFile) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(cfgFile.Value)))
+}
+
+// DiffConfigs compares two decrypted configs and returns the changes sorted
+// by file name. When `full` is set each change includes a unified diff of
+// the plaintext values, so callers must be careful where they display it.
+func DiffConfigs(prev, next ConfigStruct, full bool) []FileChange {
+	var changes []FileChange
+	for name, cfgFile := range next {
+		change := FileChange{Name: name, NewHash: valueHash(cfgFile)}
+		old, ok := prev[name]
+		if !ok {
+			change.Action = FileAdded
+			old = &ConfigFile{}
+		} else if old.Value != cfgFile.Value || !reflect.DeepEqual(old.OnChanged, cfgFile.OnChanged) {
+			change.Action = FileChanged
+			change.OldHash = valueHash(old)
+		} else {
+			continue
+		}
+		if full {
+			change.Diff = unifiedDiff(name, old.Value, cfgFile.Value)
+		}
+		changes = append(changes, change)
+	}
+	for name, cfgFile := range prev {
+		if _, ok := next[name]; !ok {
+			change := FileChange{Name: name, Action: FileRemoved, OldHash: valueHash(cfgFile)}
+			if full {
+				change.Diff = unifiedDiff(name, cfgFile.Value, "")
+			}
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+func unifiedDiff(name, prev, next string) string {
+	diff := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(prev),
+		B:        difflib.SplitLines(next),
+		FromFile: "a/" + name,
+		ToFile:   "b/" + name,
+		Context:  3,
+	}
+	text, err := difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		return fmt.Sprintf("Unable to diff: %s", err)
+	}
+	return text
+}
+
+// DiffFiles decrypts two encrypted config bundles with the device's key and
+// compares them.
+func (a *App) DiffFiles(prevFile, nextFile string, full bool) ([]FileChange, error) {
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return nil, err
+	}
+	defer a.releaseCrypto(crypto)
+
+	prev, err := a.unmarshallCache(crypto, prevFile, true)
+	if err != nil {
+		return nil, err
+	}
+	next, err := a.unmarshallCache(crypto, nextFile, true)
+	if err != nil {
+		return nil, err
+	}
+	return DiffConfigs(prev, next, full), nil
+}
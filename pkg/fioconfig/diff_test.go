@@ -0,0 +1,58 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	prev := ConfigStruct{
+		"same":    &ConfigFile{Value: "same"},
+		"changed": &ConfigFile{Value: "line1\nline2\n"},
+		"handler": &ConfigFile{Value: "x", OnChanged: []string{"/bin/true"}},
+		"removed": &ConfigFile{Value: "gone"},
+	}
+	next := ConfigStruct{
+		"same":    &ConfigFile{Value: "same"},
+		"changed": &ConfigFile{Value: "line1\nline2 changed\n"},
+		"handler": &ConfigFile{Value: "x", OnChanged: []string{"/bin/false"}},
+		"added":   &ConfigFile{Value: "new"},
+	}
+
+	changes := DiffConfigs(prev, next, false)
+	require.Len(t, changes, 4)
+	require.Equal(t, "added", changes[0].Name)
+	require.Equal(t, FileAdded, changes[0].Action)
+	require.Equal(t, "changed", changes[1].Name)
+	require.Equal(t, FileChanged, changes[1].Action)
+	require.Empty(t, changes[1].Diff)
+	require.Equal(t, "handler", changes[2].Name)
+	require.Equal(t, FileChanged, changes[2].Action)
+	require.Equal(t, "removed", changes[3].Name)
+	require.Equal(t, FileRemoved, changes[3].Action)
+
+	changes = DiffConfigs(prev, next, true)
+	require.Contains(t, changes[1].Diff, "-line2\n+line2 changed\n")
+}
+
+func TestDiffFiles(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		config := ConfigStruct{"foo": &ConfigFile{Value: "foo changed"}}
+		encrypt(t, config)
+		buf, err := json.Marshal(config)
+		require.Nil(t, err)
+		next := filepath.Join(tempdir, "next.encrypted")
+		require.Nil(t, os.WriteFile(next, buf, 0o644))
+
+		changes, err := app.DiffFiles(app.EncryptedConfig, next, false)
+		require.Nil(t, err)
+		require.Len(t, changes, 4)
+		require.Equal(t, "foo", changes[1].Name)
+		require.Equal(t, FileChanged, changes[1].Action)
+	})
+}
@@ -0,0 +1,123 @@
+package fioconfig
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// A new config is written to the secrets directory, config.encrypted, and
+// the config history. Running out of space part way through leaves a
+// rolled back extraction at best, so the space they need is checked up
+// front and some left over for everything else on the filesystem.
+const defaultMinFreeSpace = 1024 * 1024
+
+// InsufficientSpaceError is returned when a filesystem doesn't have room
+// for a new config
+var InsufficientSpaceError = errors.New("Not enough disk space")
+
+// diskSpace returns the filesystem a path is on and the bytes available on
+// it to unprivileged users
+var diskSpace = func(path string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	var dev uint64
+	if fi, err := os.Stat(path); err == nil {
+		if sys, ok := fi.Sys().(*syscall.Stat_t); ok {
+			dev = uint64(sys.Dev)
+		}
+	}
+	return dev, st.Bavail * uint64(st.Bsize), nil
+}
+
+// existingDir returns path, or the closest parent of it that exists
+func existingDir(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			return path
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+// decodedSize returns how many bytes a config file is once written
+func decodedSize(cfgFile *ConfigFile) uint64 {
+	if cfgFile.Encoding == EncodingBase64 {
+		return uint64(base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(cfgFile.Value, "="))))
+	}
+	return uint64(len(cfgFile.Value))
+}
+
+// checkDiskSpace makes sure there's room to apply next, whose encrypted
+// form is encrypted, before anything is written. Files whose content is
+// what was last applied aren't rewritten so they don't need space.
+func (a *App) checkDiskSpace(next ConfigStruct, encrypted []byte) error {
+	headroom := uint64(defaultMinFreeSpace)
+	if a.settings.MinFreeSpace > 0 {
+		headroom = uint64(a.settings.MinFreeSpace)
+	} else if a.settings.MinFreeSpace < 0 {
+		return nil
+	}
+
+	needs := map[string]uint64{
+		filepath.Dir(a.EncryptedConfig): uint64(len(encrypted)),
+	}
+	needs[a.historyDir()] += uint64(len(encrypted))
+	if a.store == nil {
+		applied := a.readManifest().Files
+		var size uint64
+		for fname, cfgFile := range next {
+			if content, err := cfgFile.content(); err == nil && applied[fname] == sha256Hex(content) {
+				continue
+			}
+			size += decodedSize(cfgFile)
+		}
+		needs[a.SecretsDir] += size
+	}
+
+	paths := make([]string, 0, len(needs))
+	for path := range needs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	type filesystem struct {
+		path  string
+		need  uint64
+		avail uint64
+	}
+	var order []uint64
+	filesystems := make(map[uint64]*filesystem)
+	for _, path := range paths {
+		dev, avail, err := diskSpace(existingDir(path))
+		if err != nil {
+			// Not knowing isn't a reason to stop the update
+			logger.Printf("Unable to check free space of %s: %s", path, err)
+			continue
+		}
+		fs, ok := filesystems[dev]
+		if !ok {
+			fs = &filesystem{path: path, avail: avail}
+			filesystems[dev] = fs
+			order = append(order, dev)
+		}
+		fs.need += needs[path]
+	}
+	for _, dev := range order {
+		fs := filesystems[dev]
+		if fs.avail < fs.need+headroom {
+			err := fmt.Errorf("%w: %s has %d bytes free, the new config needs %d plus %d to spare",
+				InsufficientSpaceError, fs.path, fs.avail, fs.need, headroom)
+			LogEvent(EventDiskSpaceLow, "ERROR: %s", err)
+			return err
+		}
+	}
+	return nil
+}
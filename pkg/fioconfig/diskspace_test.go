@@ -0,0 +1,55 @@
+package fioconfig
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDiskSpace(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, avail, err := diskSpace(tempdir)
+		require.Nil(t, err)
+		require.NotZero(t, avail)
+
+		var free uint64
+		var checked []string
+		orig := diskSpace
+		diskSpace = func(path string) (uint64, uint64, error) {
+			checked = append(checked, path)
+			return 1, free, nil
+		}
+		defer func() { diskSpace = orig }()
+
+		encrypted := make([]byte, 1000)
+		next := ConfigStruct{
+			"foo": {Value: strings.Repeat("x", 4000)},
+			"bar": {Value: "YWJj", Encoding: EncodingBase64},
+		}
+		// Everything is on one filesystem, which needs room for the
+		// config, its history copy, and the files
+		free = defaultMinFreeSpace + 2*1000 + 4000 + 3
+		require.Nil(t, app.checkDiskSpace(next, encrypted))
+		require.Contains(t, checked, app.SecretsDir)
+
+		free--
+		err = app.checkDiskSpace(next, encrypted)
+		require.True(t, errors.Is(err, InsufficientSpaceError), err)
+
+		// Files that are already applied aren't written again
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "foo"), []byte(next["foo"].Value), 0o644))
+		require.Nil(t, app.saveManifest(manifestState{Files: manifest{"foo": sha256Hex([]byte(next["foo"].Value))}}))
+		require.Nil(t, app.checkDiskSpace(next, encrypted))
+
+		app.settings.MinFreeSpace = 10 * defaultMinFreeSpace
+		require.NotNil(t, app.checkDiskSpace(next, encrypted))
+		app.settings.MinFreeSpace = -1
+		free = 0
+		require.Nil(t, app.checkDiskSpace(next, encrypted))
+	})
+}
@@ -0,0 +1,158 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Embedded images often have broken or slow DNS, or an IPv6 route that
+// doesn't go anywhere, which leaves a check-in stuck in the dialer until
+// the client times out. The dns_servers, dns_cache_ttl, and ip_family
+// settings replace the system's resolver and Go's address selection for
+// the device client.
+
+const (
+	IpFamilyV4       = "ipv4"
+	IpFamilyV6       = "ipv6"
+	IpFamilyPreferV4 = "prefer-ipv4"
+	IpFamilyPreferV6 = "prefer-ipv6"
+)
+
+// dialAttemptTimeout is how long each address gets before the next one is
+// tried, so a dead address family fails fast, unless connect_timeout is set
+const dialAttemptTimeout = 5 * time.Second
+
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+type dialer struct {
+	// Dials each address, with the connect_timeout and tcp_keep_alive
+	// settings
+	base   net.Dialer
+	lookup func(ctx context.Context, network, host string) ([]net.IP, error)
+	family string
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]dnsEntry
+}
+
+// newDialer returns the dialer the settings ask for, or nil to use base
+func newDialer(settings Settings, base net.Dialer) (*dialer, error) {
+	if len(settings.DnsServers) == 0 && len(settings.DnsCacheTtl) == 0 && len(settings.IpFamily) == 0 {
+		return nil, nil
+	}
+	if base.Timeout == 0 {
+		base.Timeout = dialAttemptTimeout
+	}
+	d := &dialer{base: base, family: settings.IpFamily, cache: make(map[string]dnsEntry)}
+	switch d.family {
+	case "", IpFamilyV4, IpFamilyV6, IpFamilyPreferV4, IpFamilyPreferV6:
+	default:
+		return nil, fmt.Errorf("Invalid ip_family %q", d.family)
+	}
+	if len(settings.DnsCacheTtl) > 0 {
+		ttl, err := time.ParseDuration(settings.DnsCacheTtl)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid dns_cache_ttl: %w", err)
+		}
+		d.ttl = ttl
+	}
+
+	resolver := net.DefaultResolver
+	if len(settings.DnsServers) > 0 {
+		servers := make([]string, 0, len(settings.DnsServers))
+		for _, server := range settings.DnsServers {
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			servers = append(servers, server)
+		}
+		// Go's resolver retries with the same Dial, so each attempt goes
+		// to the next server
+		var next uint32
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				server := servers[int(atomic.AddUint32(&next, 1)-1)%len(servers)]
+				var nd net.Dialer
+				return nd.DialContext(ctx, network, server)
+			},
+		}
+	}
+	d.lookup = resolver.LookupIP
+	return d, nil
+}
+
+// resolve returns the addresses of host to try, in order
+func (d *dialer) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	d.mu.Lock()
+	entry, ok := d.cache[host]
+	d.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	network := "ip"
+	switch d.family {
+	case IpFamilyV4:
+		network = "ip4"
+	case IpFamilyV6:
+		network = "ip6"
+	}
+	ips, err := d.lookup(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	if d.family == IpFamilyPreferV4 || d.family == IpFamilyPreferV6 {
+		wantV4 := d.family == IpFamilyPreferV4
+		sort.SliceStable(ips, func(i, j int) bool {
+			return (ips[i].To4() != nil) == wantV4 && (ips[j].To4() != nil) != wantV4
+		})
+	}
+	if d.ttl > 0 {
+		d.mu.Lock()
+		d.cache[host] = dnsEntry{ips, time.Now().Add(d.ttl)}
+		d.mu.Unlock()
+	}
+	return ips, nil
+}
+
+// DialContext tries each address of the host in turn
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("No addresses found for %s", host)
+	}
+	var errs []string
+	for _, ip := range ips {
+		conn, err := d.base.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err.Error())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.New(strings.Join(errs, "; "))
+}
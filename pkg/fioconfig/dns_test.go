@@ -0,0 +1,63 @@
+package fioconfig
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialer(t *testing.T) {
+	d, err := newDialer(Settings{}, net.Dialer{})
+	require.Nil(t, err)
+	require.Nil(t, d)
+	_, err = newDialer(Settings{IpFamily: "ipv5"}, net.Dialer{})
+	require.NotNil(t, err)
+
+	d, err = newDialer(Settings{IpFamily: IpFamilyPreferV4, DnsCacheTtl: "1m", DnsServers: []string{"192.0.2.1"}}, net.Dialer{})
+	require.Nil(t, err)
+	lookups := 0
+	d.lookup = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		lookups++
+		require.Equal(t, "ip", network)
+		return []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}, nil
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	for i := 0; i < 2; i++ {
+		conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("gateway.test", port))
+		require.Nil(t, err)
+		require.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+		conn.Close()
+	}
+	require.Equal(t, 1, lookups)
+
+	d.cache["gateway.test"] = dnsEntry{nil, time.Now().Add(-time.Second)}
+	ips, err := d.resolve(context.Background(), "gateway.test")
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.1", ips[0].String())
+	require.Equal(t, 2, lookups)
+
+	d.family = IpFamilyV6
+	d.lookup = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		require.Equal(t, "ip6", network)
+		return []net.IP{net.ParseIP("::1")}, nil
+	}
+	_, err = d.resolve(context.Background(), "other.test")
+	require.Nil(t, err)
+}
@@ -0,0 +1,14 @@
+// Package fioconfig checks in with a Foundries.io device gateway and
+// extracts the device's config files. The fioconfig command is a thin CLI
+// over it, so factory tooling can embed the same check-in and extraction
+// logic:
+//
+//	app, err := fioconfig.NewApp("/var/sota", fioconfig.WithSecretsDir(dir))
+//	if err != nil {
+//		return err
+//	}
+//	err = app.CheckIn()
+//
+// Package fioconfigtest has a fake gateway and crypto for testing programs
+// built on it.
+package fioconfig
@@ -0,0 +1,166 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Configs can be several megabytes and devices are often on flaky cellular
+// links. The config download is checked against its Content-Length and any
+// digest the server sends, and an interrupted transfer is resumed with a
+// Range request instead of being started over.
+
+// partialDownload is what was received of a config before its transfer
+// was interrupted
+type partialDownload struct {
+	url    string
+	etag   string
+	header http.Header // Of the response being resumed
+	body   []byte      // Still content encoded
+}
+
+var errTruncated = errors.New("transfer interrupted")
+
+// downloadConfig is httpGetContext for the config, resuming transfers that
+// are interrupted between retries and check-ins
+func (a *App) downloadConfig(ctx context.Context, client *http.Client, url string, headers map[string]string) (*httpRes, error) {
+	return httpRetry(ctx, http.MethodGet, url, func() (*httpRes, error) {
+		return a.downloadConfigOnce(ctx, client, url, headers)
+	})
+}
+
+func (a *App) downloadConfigOnce(ctx context.Context, client *http.Client, url string, headers map[string]string) (*httpRes, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("User-Agent", "fioconfig-client/2")
+	req.Header.Add("Accept-Encoding", "zstd, gzip")
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+	partial := a.partialDownload
+	if partial != nil && partial.url != url {
+		partial = nil
+	}
+	if partial != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(partial.body)))
+		req.Header.Set("If-Range", partial.etag)
+	}
+
+	r, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to GET: %s - %w", url, err)
+	}
+	defer r.Body.Close()
+
+	header := r.Header
+	var raw []byte
+	switch {
+	case r.StatusCode == http.StatusPartialContent && partial != nil:
+		if start, ok := contentRangeStart(r.Header.Get("Content-Range")); !ok || start != int64(len(partial.body)) {
+			a.partialDownload = nil
+			return nil, fmt.Errorf("Unable to resume download of %s: unexpected Content-Range %q", url, r.Header.Get("Content-Range"))
+		}
+		LogEvent(EventDownloadResumed, "Resuming download of %s after %d bytes", url, len(partial.body))
+		header = partial.header
+		raw = partial.body
+	case r.StatusCode == http.StatusOK:
+		// The server sent the whole config, either because nothing was
+		// partially downloaded or because it changed since
+	default:
+		if r.StatusCode < 500 {
+			a.partialDownload = nil
+		}
+		return readResponse(r)
+	}
+
+	limit := responseLimit(ctx)
+	body, readErr := readLimited(r.Body, limit-int64(len(raw)))
+	if readErr == nil && r.ContentLength >= 0 && int64(len(body)) != r.ContentLength {
+		readErr = fmt.Errorf("%w: got %d of %d bytes", errTruncated, len(body), r.ContentLength)
+	}
+	raw = append(raw[:len(raw):len(raw)], body...)
+	if readErr != nil {
+		a.partialDownload = nil
+		etag := header.Get("ETag")
+		resumable := len(etag) > 0 && !strings.HasPrefix(etag, "W/") && header.Get("Accept-Ranges") != "none"
+		if resumable && len(raw) > 0 && !errors.Is(readErr, PayloadTooLargeError) {
+			LogEvent(EventDownloadInterrupted, "Download of %s interrupted after %d bytes: %s", url, len(raw), readErr)
+			a.partialDownload = &partialDownload{url: url, etag: etag, header: header, body: raw}
+		}
+		return nil, fmt.Errorf("Unable to read response from %s: %w", url, readErr)
+	}
+	a.partialDownload = nil
+
+	if err := checkDigest(header, raw); err != nil {
+		return nil, fmt.Errorf("Config download from %s is corrupt: %w", url, err)
+	}
+	decoded, err := decodeBody(header.Get("Content-Encoding"), bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode response from %s: %w", url, err)
+	}
+	defer decoded.Close()
+	res := &httpRes{StatusCode: http.StatusOK, Header: header}
+	if res.Body, err = readLimited(decoded, limit); err != nil {
+		return nil, fmt.Errorf("Unable to read response from %s: %w", url, err)
+	}
+	return res, nil
+}
+
+// contentRangeStart returns the first byte of a "bytes <start>-<end>/<size>"
+// Content-Range
+func contentRangeStart(contentRange string) (int64, bool) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(contentRange, "bytes "), "-", 2)
+	if len(parts) != 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(parts[0], 10, 64)
+	return n, err == nil
+}
+
+// checkDigest verifies a whole response body against the Content-Digest or
+// Repr-Digest (RFC 9530), or legacy Digest (RFC 3230), header if the server
+// sent one. Algorithms other than SHA-256 and SHA-512 are ignored.
+func checkDigest(header http.Header, body []byte) error {
+	for _, name := range []string{"Content-Digest", "Repr-Digest", "Digest"} {
+		for _, item := range strings.Split(strings.Join(header.Values(name), ","), ",") {
+			parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			alg, value := parts[0], parts[1]
+			var sum []byte
+			switch strings.ToLower(alg) {
+			case "sha-256":
+				s := sha256.Sum256(body)
+				sum = s[:]
+			case "sha-512":
+				s := sha512.Sum512(body)
+				sum = s[:]
+			default:
+				continue
+			}
+			// RFC 9530 wraps the value in colons as a structured field
+			expected, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+			if err != nil {
+				return fmt.Errorf("Invalid %s header: %w", name, err)
+			}
+			if !bytes.Equal(sum, expected) {
+				return fmt.Errorf("%s %s mismatch", name, alg)
+			}
+		}
+	}
+	return nil
+}
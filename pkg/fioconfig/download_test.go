@@ -0,0 +1,73 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadResume(t *testing.T) {
+	body := []byte(strings.Repeat("0123456789", 1000))
+	sum := sha256.Sum256(body)
+	var ranges []string
+	cut := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v2"`)
+		if rng := r.Header.Get("Range"); len(rng) > 0 {
+			require.Equal(t, `"v2"`, r.Header.Get("If-Range"))
+			start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			require.Nil(t, err)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(body)-1, len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(body[start:])
+			return
+		}
+		w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if cut {
+			cut = false
+			_, _ = w.Write(body[:4000])
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.Nil(t, err)
+			conn.Close()
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	app := &App{}
+	_, err := app.downloadConfigOnce(context.Background(), srv.Client(), srv.URL, nil)
+	require.NotNil(t, err)
+	require.NotNil(t, app.partialDownload)
+	require.Len(t, app.partialDownload.body, 4000)
+
+	res, err := app.downloadConfigOnce(context.Background(), srv.Client(), srv.URL, nil)
+	require.Nil(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	require.Equal(t, body, res.Body)
+	require.Equal(t, []string{"", "bytes=4000-"}, ranges)
+	require.Nil(t, app.partialDownload)
+}
+
+func TestDownloadDigestMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte("something else"))
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	app := &App{}
+	_, err := app.downloadConfigOnce(context.Background(), srv.Client(), srv.URL, nil)
+	require.ErrorContains(t, err, "Repr-Digest sha-256 mismatch")
+}
@@ -0,0 +1,145 @@
+package fioconfig
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DriftModified = "modified"
+	DriftMissing  = "missing"
+)
+
+// FileDrift is a managed file that no longer matches what fioconfig wrote
+type FileDrift struct {
+	Name    string `json:"name"`
+	Problem string `json:"problem"` // DriftModified or DriftMissing
+}
+
+func (d FileDrift) String() string {
+	return fmt.Sprintf("%s: %s", d.Problem, d.Name)
+}
+
+// CheckDrift compares the files fioconfig extracted against the sha256s in
+// its manifest to detect local modifications and deletions. Files allowed
+// to be changed by their on-changed handlers, and changes that are
+// equivalent under the file's compare mode, aren't reported.
+func (a *App) CheckDrift() ([]FileDrift, error) {
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return nil, err
+	}
+	defer a.releaseCrypto(crypto)
+	config, err := a.unmarshallCache(crypto, a.EncryptedConfig, false)
+	if err != nil {
+		return nil, err
+	}
+	// Only decrypted if a compare mode needs the actual value
+	var decrypted ConfigStruct
+
+	applied := a.loadManifest()
+	names := make([]string, 0, len(applied))
+	for fname := range applied {
+		names = append(names, fname)
+	}
+	sort.Strings(names)
+
+	var drift []FileDrift
+	for _, fname := range names {
+		cur, err := a.readManaged(fname)
+		if os.IsNotExist(err) {
+			drift = append(drift, FileDrift{fname, DriftMissing})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if sha256Hex(cur) == applied[fname] {
+			continue
+		}
+		cfgFile, ok := config[fname]
+		if ok && cfgFile.IgnoreHookChanges {
+			continue
+		}
+		if ok && len(cfgFile.Compare) > 0 && cfgFile.Compare != CompareExact {
+			if decrypted == nil {
+				if decrypted, err = a.unmarshallCache(crypto, a.EncryptedConfig, true); err != nil {
+					return nil, err
+				}
+			}
+			if want, err := decrypted[fname].content(); err == nil && contentEqual(cfgFile.Compare, cur, want) {
+				continue
+			}
+		}
+		drift = append(drift, FileDrift{fname, DriftModified})
+	}
+	return drift, nil
+}
+
+// CheckDriftIfDue runs CheckDrift every `fioconfig.drift_check_interval`,
+// and after StartDriftWatch saw a managed file change, reports any drift
+// to the server, and re-extracts the config when `fioconfig.drift_repair`
+// is set. It's a no-op if neither is set up.
+func (a *App) CheckDriftIfDue() error {
+	if w := a.driftWatch; w != nil {
+		// Pick up the directories of files the last check-in added
+		defer func() { w.refresh(a.loadManifest()) }()
+	}
+	touched := a.driftWatch.takeTouched()
+	due := len(touched) > 0
+	if len(a.settings.DriftCheckInterval) > 0 {
+		interval, err := time.ParseDuration(a.settings.DriftCheckInterval)
+		if err != nil {
+			return fmt.Errorf("Invalid fioconfig.drift_check_interval: %w", err)
+		}
+		due = due || time.Since(a.lastDriftCheck) >= interval
+	}
+	if !due {
+		return nil
+	}
+	a.lastDriftCheck = time.Now()
+	if len(touched) > 0 {
+		a.debugf("Checking for local changes, files changed on disk: %s", strings.Join(touched, ", "))
+	}
+
+	drift, err := a.CheckDrift()
+	if err != nil || len(drift) == 0 {
+		return err
+	}
+	for _, d := range drift {
+		LogEvent(EventFileDrift, "Managed file was %s locally: %s", d.Problem, d.Name)
+	}
+	client, _, err := a.getClient()
+	if err != nil {
+		return err
+	}
+	a.reportDrift(client, drift)
+	if a.settings.DriftRepair {
+		return a.RepairDrift()
+	}
+	return nil
+}
+
+// RepairDrift re-extracts the current config so modified and deleted files
+// are restored.
+func (a *App) RepairDrift() error {
+	LogEvent(EventDriftRepaired, "Restoring locally modified config files")
+	return a.Extract()
+}
+
+func (a *App) reportDrift(client *http.Client, drift []FileDrift) {
+	report := struct {
+		Timestamp time.Time   `json:"timestamp"`
+		Files     []FileDrift `json:"files"`
+	}{time.Now().UTC(), drift}
+	url := a.configUrl + "-drift"
+	res, err := httpPost(client, url, report)
+	if err != nil {
+		LogEvent(EventStatusReportFailed, "Unable to report drift: %s", err)
+	} else if res.StatusCode < 200 || res.StatusCode > 204 {
+		LogEvent(EventStatusReportFailed, "Server could not process drift report: HTTP_%d - %s", res.StatusCode, res.String())
+	}
+}
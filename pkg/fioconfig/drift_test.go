@@ -0,0 +1,134 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrift(t *testing.T) {
+	var reported struct {
+		Files []FileDrift `json:"files"`
+	}
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/config-drift" {
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&reported))
+			w.WriteHeader(201)
+		}
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		app.configUrl += "/config"
+		require.Nil(t, app.Extract())
+		drift, err := app.CheckDrift()
+		require.Nil(t, err)
+		require.Empty(t, drift)
+
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "foo"), []byte("tampered"), 0o640))
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "with/subdir/1.txt")))
+		drift, err = app.CheckDrift()
+		require.Nil(t, err)
+		expected := []FileDrift{{"foo", DriftModified}, {"with/subdir/1.txt", DriftMissing}}
+		require.Equal(t, expected, drift)
+
+		app.settings.DriftCheckInterval = "1h"
+		app.settings.DriftRepair = true
+		require.Nil(t, app.CheckDriftIfDue())
+		require.Equal(t, expected, reported.Files)
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+		assertFile(t, filepath.Join(tempdir, "with/subdir/1.txt"), []byte("sub"))
+
+		// Not due again for another hour
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "foo")))
+		reported.Files = nil
+		require.Nil(t, app.CheckDriftIfDue())
+		require.Nil(t, reported.Files)
+	})
+}
+
+func TestDriftWatch(t *testing.T) {
+	var reported []FileDrift
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/config-drift" {
+			var report struct {
+				Files []FileDrift `json:"files"`
+			}
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&report))
+			reported = report.Files
+			w.WriteHeader(201)
+		}
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		app.configUrl += "/config"
+		require.Nil(t, app.Extract())
+		app.settings.DriftWatch = true
+		app.settings.DriftRepair = true
+		stop, err := app.StartDriftWatch()
+		require.Nil(t, err)
+		defer stop()
+		w := app.driftWatch
+		require.NotNil(t, w)
+
+		touched := func() bool {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			return len(w.touched) > 0
+		}
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "not-managed"), []byte("x"), 0o640))
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "with/subdir/1.txt"), []byte("tampered"), 0o640))
+		require.Eventually(t, touched, 5*time.Second, 10*time.Millisecond)
+		w.mu.Lock()
+		require.Equal(t, map[string]bool{"with/subdir/1.txt": true}, w.touched)
+		w.mu.Unlock()
+
+		// No interval is set, the change alone makes the check due
+		require.Nil(t, app.CheckDriftIfDue())
+		require.Equal(t, []FileDrift{{"with/subdir/1.txt", DriftModified}}, reported)
+		assertFile(t, filepath.Join(tempdir, "with/subdir/1.txt"), []byte("sub"))
+
+		// The repair's own writes are checked, and found to be fine
+		require.Eventually(t, touched, 5*time.Second, 10*time.Millisecond)
+		reported = nil
+		require.Nil(t, app.CheckDriftIfDue())
+		require.Nil(t, reported)
+
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "foo")))
+		require.Eventually(t, touched, 5*time.Second, 10*time.Millisecond)
+		require.Nil(t, app.CheckDriftIfDue())
+		require.Equal(t, []FileDrift{{"foo", DriftMissing}}, reported)
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+
+		stop()
+		require.Nil(t, app.driftWatch)
+	})
+}
+
+func TestDriftCompareModes(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		config := ConfigStruct{
+			"hooked": &ConfigFile{Value: "a", Unencrypted: true, IgnoreHookChanges: true},
+			"json":   &ConfigFile{Value: `{"a": 1}`, Unencrypted: true, Compare: CompareJson},
+		}
+		buf, err := json.Marshal(config)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(app.EncryptedConfig, buf, 0o644))
+		require.Nil(t, app.Extract())
+
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "hooked"), []byte("b"), 0o640))
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "json"), []byte(`{ "a":1 }`), 0o640))
+		drift, err := app.CheckDrift()
+		require.Nil(t, err)
+		require.Empty(t, drift)
+
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "json"), []byte(`{"a": 2}`), 0o640))
+		drift, err = app.CheckDrift()
+		require.Nil(t, err)
+		require.Equal(t, []FileDrift{{"json", DriftModified}}, drift)
+	})
+}
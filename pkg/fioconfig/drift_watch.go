@@ -0,0 +1,153 @@
+package fioconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// With drift_watch, the daemon watches the directories of the files it
+// extracted with inotify rather than only checking every
+// drift_check_interval. An event just means a file may have changed, since
+// fioconfig's own writes cause them too. The next cycle runs CheckDrift on
+// the files that had one to find out.
+
+const driftWatchMask = unix.IN_CLOSE_WRITE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+type driftWatch struct {
+	secretsDir string
+	fd         int
+	file       *os.File
+
+	mu sync.Mutex
+	// Watch descriptors and the directories, relative to secretsDir, they
+	// watch
+	dirs    map[int]string
+	watched map[string]bool
+	// The managed files and those with events since the last check
+	files   map[string]bool
+	touched map[string]bool
+}
+
+// StartDriftWatch watches the files in the manifest for changes when
+// `fioconfig.drift_watch` is set. Only the filesystem store is watched.
+func (a *App) StartDriftWatch() (func(), error) {
+	if !a.settings.DriftWatch {
+		return func() {}, nil
+	}
+	if a.store != nil {
+		return nil, errors.New("drift_watch only supports the filesystem secret store")
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to watch for local changes: %w", err)
+	}
+	w := &driftWatch{
+		secretsDir: a.SecretsDir,
+		fd:         fd,
+		// Non-blocking, so it's read through the runtime poller and Close
+		// stops a pending Read
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		dirs:    make(map[int]string),
+		watched: make(map[string]bool),
+		touched: make(map[string]bool),
+	}
+	w.refresh(a.loadManifest())
+	a.driftWatch = w
+	go w.run()
+	return func() {
+		a.driftWatch = nil
+		w.file.Close()
+	}, nil
+}
+
+// refresh watches the directories of the files in applied that aren't yet
+func (w *driftWatch) refresh(applied manifest) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.files = make(map[string]bool, len(applied))
+	for fname := range applied {
+		w.files[fname] = true
+		dir := filepath.Dir(fname)
+		if w.watched[dir] {
+			continue
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, filepath.Join(w.secretsDir, dir), driftWatchMask)
+		if err != nil {
+			logger.Printf("Unable to watch %s for local changes: %s", dir, err)
+			continue
+		}
+		w.dirs[wd] = dir
+		w.watched[dir] = true
+	}
+}
+
+func (w *driftWatch) run() {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				logger.Printf("ERROR: Stopped watching for local changes: %s", err)
+			}
+			return
+		}
+		w.handle(buf[:n])
+	}
+}
+
+func (w *driftWatch) handle(buf []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(buf) >= unix.SizeofInotifyEvent {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := unix.SizeofInotifyEvent + int(event.Len)
+		if end > len(buf) {
+			return
+		}
+		name := string(bytes.TrimRight(buf[unix.SizeofInotifyEvent:end], "\x00"))
+		buf = buf[end:]
+
+		dir, ok := w.dirs[int(event.Wd)]
+		if !ok {
+			continue
+		}
+		if event.Mask&unix.IN_IGNORED != 0 {
+			// The directory was removed, refresh watches it again if needed
+			delete(w.dirs, int(event.Wd))
+			delete(w.watched, dir)
+			continue
+		}
+		fname := filepath.Join(dir, name)
+		if w.files[fname] {
+			w.touched[fname] = true
+		}
+	}
+}
+
+// takeTouched returns the managed files changed on disk since it was last
+// called
+func (w *driftWatch) takeTouched() []string {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	names := make([]string, 0, len(w.touched))
+	for fname := range w.touched {
+		names = append(names, fname)
+	}
+	sort.Strings(names)
+	w.touched = make(map[string]bool)
+	return names
+}
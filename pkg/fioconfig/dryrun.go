@@ -0,0 +1,93 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PlannedHandler is an on-changed command a config change would trigger
+type PlannedHandler struct {
+	File    string
+	Command []string
+	Skipped bool `json:",omitempty"` // Not allowed to run, see handlerAllowed
+}
+
+// DryRunResult is what a check-in would do to the device
+type DryRunResult struct {
+	Changes  []FileChange
+	Handlers []PlannedHandler
+}
+
+// DryRun fetches and decrypts the latest config and compares it with the
+// local one without changing anything on the device. When `full` is set
+// the changes include unified diffs of the plaintext values.
+func (a *App) DryRun(full bool) (*DryRunResult, error) {
+	client, crypto, err := a.getClient()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if !a.reuseClient {
+			a.closeClient()
+		}
+	}()
+
+	res, err := httpGet(client, a.configUrl, map[string]string{"Accept": acceptPayloads})
+	if err != nil {
+		return nil, classifyTlsError(err, client, time.Now())
+	}
+	if err := decodePayload(res); err != nil {
+		return nil, err
+	}
+	if res.StatusCode == 204 {
+		// Check-ins leave the current files alone in this case
+		return &DryRunResult{}, nil
+	} else if res.StatusCode != 200 {
+		return nil, fmt.Errorf("Unable to get %s - HTTP_%d: %s", a.configUrl, res.StatusCode, res.String())
+	}
+	next, err := UnmarshallBuffer(crypto, res.Body, true)
+	if err != nil {
+		return nil, err
+	}
+	if next, err = a.applyShadow(next, newExtractReport()); err != nil {
+		return nil, err
+	}
+	order, err := applyOrder(next)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, err := a.unmarshallCache(crypto, a.EncryptedConfig, true)
+	if err != nil {
+		var perr *os.PathError
+		if !errors.As(err, &perr) || !os.IsNotExist(perr) {
+			return nil, err
+		}
+	}
+	snapshot := a.applyProtected(configSnapshot{prev, next}, newExtractReport())
+	prev, next = snapshot.prev, snapshot.next
+
+	result := &DryRunResult{Changes: DiffConfigs(prev, next, full)}
+	changed := make(map[string]bool)
+	for _, change := range result.Changes {
+		changed[change.Name] = true
+	}
+	plan := func(fname string, cfgFile *ConfigFile) {
+		if len(cfgFile.OnChanged) > 0 {
+			result.Handlers = append(result.Handlers, PlannedHandler{fname, cfgFile.OnChanged, !a.handlerAllowed(cfgFile.OnChanged)})
+		}
+	}
+	for _, fname := range order {
+		if changed[fname] {
+			plan(fname, next[fname])
+		}
+	}
+	for _, fname := range sortedNames(prev) {
+		if _, ok := next[fname]; !ok {
+			plan(fname, prev[fname])
+		}
+	}
+	return result, nil
+}
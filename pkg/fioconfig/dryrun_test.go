@@ -0,0 +1,62 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	var encbuf []byte
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+
+		result, err := app.DryRun(false)
+		require.Nil(t, err)
+		require.Len(t, result.Changes, 4)
+		for _, change := range result.Changes {
+			require.Equal(t, FileAdded, change.Action)
+		}
+		require.Equal(t, []PlannedHandler{{"bar", []string{"/usr/bin/touch", filepath.Join(tempdir, "bar-changed")}, false}}, result.Handlers)
+		assertNoFile(t, filepath.Join(tempdir, "foo"))
+		assertNoFile(t, filepath.Join(tempdir, "bar-changed"))
+		assertNoFile(t, app.EncryptedConfig)
+
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "bar-changed")))
+
+		config := ConfigStruct{"foo": &ConfigFile{Value: "version 2"}}
+		encrypt(t, config)
+		encbuf, err = json.Marshal(config)
+		require.Nil(t, err)
+
+		app.unsafeHandlers = false
+		result, err = app.DryRun(true)
+		require.Nil(t, err)
+		require.Len(t, result.Changes, 4)
+		require.Equal(t, FileRemoved, result.Changes[0].Action)
+		require.Equal(t, "foo", result.Changes[1].Name)
+		require.Equal(t, FileChanged, result.Changes[1].Action)
+		require.Contains(t, result.Changes[1].Diff, "+version 2")
+		require.Len(t, result.Handlers, 1)
+		require.True(t, result.Handlers[0].Skipped)
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("bar file value"))
+		assertNoFile(t, filepath.Join(tempdir, "bar-changed"))
+	})
+}
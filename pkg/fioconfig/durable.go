@@ -0,0 +1,117 @@
+package fioconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// Values of write_sync. By default files and the directories they're
+// renamed into are fsynced so a power cut straight after a check-in can't
+// leave a file empty or missing. "file" skips the directory fsync and
+// "none" skips both, for slow flash where that risk is acceptable.
+const (
+	WriteSyncFull = ""
+	WriteSyncFile = "file"
+	WriteSyncNone = "none"
+)
+
+type writeOptions struct {
+	syncFile bool
+	syncDir  bool
+	// Read files back from storage before they're put in place
+	verify bool
+	// Where temporary files are written rather than next to the file
+	// they replace
+	tmpDir string
+}
+
+// safeWrite is called from all over without an App, so the options of the
+// last App created or reloaded apply.
+var currentWriteOptions atomic.Value
+
+func init() {
+	currentWriteOptions.Store(writeOptions{syncFile: true, syncDir: true})
+}
+
+func getWriteOptions() writeOptions {
+	return currentWriteOptions.Load().(writeOptions)
+}
+
+func setWriteOptions(settings Settings) error {
+	opts, err := newWriteOptions(settings)
+	if err != nil {
+		return err
+	}
+	currentWriteOptions.Store(opts)
+	return nil
+}
+
+func newWriteOptions(settings Settings) (writeOptions, error) {
+	opts := writeOptions{verify: settings.VerifyWrites, tmpDir: settings.TmpDir}
+	switch settings.WriteSync {
+	case WriteSyncFull:
+		opts.syncFile, opts.syncDir = true, true
+	case WriteSyncFile:
+		opts.syncFile = true
+	case WriteSyncNone:
+	default:
+		return opts, fmt.Errorf("Unknown fioconfig.write_sync: %s", settings.WriteSync)
+	}
+	return opts, nil
+}
+
+// tmpPath is where the new content of name is written before it's renamed
+// into place. Files in tmp_dir are named after a hash of the whole path so
+// two config files with the same name in different directories can't
+// collide.
+func (o writeOptions) tmpPath(name string) string {
+	if len(o.tmpDir) == 0 {
+		return name + ".tmp"
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = name
+	}
+	return filepath.Join(o.tmpDir, sha256Hex([]byte(abs))[:16]+"-"+filepath.Base(name)+".tmp")
+}
+
+// syncDir makes the renames into dir durable
+func syncDir(dir string) error {
+	if !getWriteOptions().syncDir {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("Unable to sync %s: %w", dir, err)
+	}
+	return nil
+}
+
+// verifyWritten reads f back, bypassing the page cache as far as the
+// kernel allows, and checks it holds data
+func verifyWritten(f *os.File, data []byte) error {
+	_ = unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	written, err := io.ReadAll(f)
+	defer zeroize(written)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(written, data) {
+		return errors.New("Content read back does not match what was written")
+	}
+	return nil
+}
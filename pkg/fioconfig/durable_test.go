@@ -0,0 +1,30 @@
+package fioconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOptions(t *testing.T) {
+	t.Cleanup(func() { require.Nil(t, setWriteOptions(Settings{})) })
+	require.Equal(t, writeOptions{syncFile: true, syncDir: true}, getWriteOptions())
+
+	require.Nil(t, setWriteOptions(Settings{WriteSync: WriteSyncFile}))
+	require.Equal(t, writeOptions{syncFile: true}, getWriteOptions())
+	require.Nil(t, setWriteOptions(Settings{WriteSync: WriteSyncNone, VerifyWrites: true}))
+	require.Equal(t, writeOptions{verify: true}, getWriteOptions())
+	require.NotNil(t, setWriteOptions(Settings{WriteSync: "sometimes"}))
+
+	path := filepath.Join(t.TempDir(), "sub", "file")
+	require.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	for _, opts := range []Settings{{VerifyWrites: true}, {WriteSync: WriteSyncNone}} {
+		require.Nil(t, setWriteOptions(opts))
+		require.Nil(t, safeWrite(path, []byte("durable")))
+		content, err := os.ReadFile(path)
+		require.Nil(t, err)
+		require.Equal(t, "durable", string(content))
+	}
+}
@@ -0,0 +1,151 @@
+package fioconfig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+	ecies "github.com/foundriesio/go-ecies"
+)
+
+type EciesCrypto struct {
+	PrivKey ecies.KeyProvider
+	ctx     *crypto11.Context
+	// ctx is a shared context that stays open when the handler is closed.
+	// See pkcs11.go
+	shared bool
+}
+
+func NewEciesLocalHandler(privKey crypto.PrivateKey) CryptoHandler {
+	if ec, ok := privKey.(*ecdsa.PrivateKey); ok {
+		return &EciesCrypto{PrivKey: ecies.ImportECDSA(ec)}
+	}
+	return nil
+}
+
+func (ec *EciesCrypto) Decrypt(value string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to base64 decode: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("Unable to decrypt an empty value")
+	}
+	scheme, err := schemeById(data[0])
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := scheme.decrypt(ec.PrivKey, data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to %s decrypt %v", scheme.name, err)
+	}
+	return decrypted, nil
+}
+
+// Encrypt encrypts value to the device's key with the original ECIES
+// scheme, which every version of fioconfig can decrypt
+func (ec *EciesCrypto) Encrypt(value string) (string, error) {
+	enc, err := encryptEcies(ec.PrivKey.Public(), []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(enc), nil
+}
+
+// PublicKeyPem returns the PEM encoded public key config values are
+// encrypted to.
+func (ec *EciesCrypto) PublicKeyPem() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(ec.PrivKey.Public().ExportECDSA())
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// Fingerprint returns the hex encoded SHA256 of the DER encoded public key.
+func (ec *EciesCrypto) Fingerprint() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(ec.PrivKey.Public().ExportECDSA())
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (ec *EciesCrypto) Close() {
+	if ec.ctx != nil && !ec.shared {
+		ec.ctx.Close()
+	}
+}
+
+func NewEciesPkcs11Handler(ctx *crypto11.Context, privKey crypto11.Signer) CryptoHandler {
+	return &EciesCrypto{PrivKey: ImportPcks11(ctx, privKey), ctx: ctx}
+}
+
+// newSharedPkcs11Handler decrypts with a key pair of a shared context
+func newSharedPkcs11Handler(key *pkcs11KeyPair) CryptoHandler {
+	pub := key.Public().(*ecdsa.PublicKey)
+	prv := &PrivateKeyPkcs11{PublicKey: ecies.ImportECDSAPublic(pub), ctx: key.ctx, signer: key.signer, key: key}
+	return &EciesCrypto{PrivKey: prv, ctx: key.ctx, shared: true}
+}
+
+type PrivateKeyPkcs11 struct {
+	*ecies.PublicKey
+	ctx    *crypto11.Context
+	signer crypto11.Signer
+	key    *pkcs11KeyPair // Set for shared contexts
+}
+
+func ImportPcks11(ctx *crypto11.Context, privKey crypto.PrivateKey) *PrivateKeyPkcs11 {
+	signer := privKey.(crypto11.Signer)
+	pub := signer.Public().(*ecdsa.PublicKey)
+	return &PrivateKeyPkcs11{PublicKey: ecies.ImportECDSAPublic(pub), ctx: ctx, signer: signer}
+}
+
+func (prv *PrivateKeyPkcs11) GenerateShared(pub *ecies.PublicKey) (sk []byte, err error) {
+	if prv.key != nil {
+		return prv.key.ecdh(pub.ExportECDSA())
+	}
+	return prv.ctx.ECDH1Derive(prv.signer, pub.ExportECDSA())
+}
+
+func (prv *PrivateKeyPkcs11) Public() *ecies.PublicKey {
+	return prv.PublicKey
+}
+
+type encrypter interface {
+	Encrypt(value string) (string, error)
+}
+
+func selfTest(c CryptoHandler) error {
+	enc, ok := c.(encrypter)
+	if !ok {
+		logger.Printf("Crypto handler does not support encryption, skipping self-test")
+		return nil
+	}
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("Unable to generate self-test nonce: %w", err)
+	}
+	value := base64.StdEncoding.EncodeToString(nonce)
+	encrypted, err := enc.Encrypt(value)
+	if err != nil {
+		return fmt.Errorf("%w: unable to encrypt: %s", CryptoSelfTestError, err)
+	}
+	decrypted, err := c.Decrypt(encrypted)
+	if err != nil {
+		return fmt.Errorf("%w: unable to decrypt: %s", CryptoSelfTestError, err)
+	}
+	if string(decrypted) != value {
+		return fmt.Errorf("%w: decrypted value does not match", CryptoSelfTestError)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Config files with EnvFile set are also rendered as a KEY=VALUE line of
+// that dotenv file, for docker compose's env_file. Several files can share
+// an env file, whose lines are sorted by key. The env file is written next
+// to the config files and removed once no file names it.
+
+// envKey returns the variable a file is rendered as: EnvKey, or its base
+// name in upper case with anything that isn't a letter, digit, or
+// underscore replaced by an underscore
+func envKey(fname string, cfgFile *ConfigFile) string {
+	if len(cfgFile.EnvKey) > 0 {
+		return cfgFile.EnvKey
+	}
+	key := []byte(strings.ToUpper(path.Base(fname)))
+	for i, c := range key {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '_' {
+			key[i] = '_'
+		}
+	}
+	if len(key) > 0 && key[0] >= '0' && key[0] <= '9' {
+		key = append([]byte{'_'}, key...)
+	}
+	return string(key)
+}
+
+func validEnvKey(key string) bool {
+	if len(key) == 0 || (key[0] >= '0' && key[0] <= '9') {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'A' && c <= 'Z') && !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// envQuote quotes a value so docker compose reads it back as is
+func envQuote(value string) string {
+	if len(value) > 0 && !strings.ContainsAny(value, " \t\n\r'\"\\$#=`") {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\n\r") {
+		// Compose takes single quoted values literally
+		return "'" + value + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// envFiles groups the files in config by the env file they're rendered in
+func envFiles(config ConfigStruct) map[string][]string {
+	files := make(map[string][]string)
+	for _, fname := range sortedNames(config) {
+		if target := config[fname].EnvFile; len(target) > 0 {
+			files[target] = append(files[target], fname)
+		}
+	}
+	return files
+}
+
+// checkEnvFiles makes sure the env files can be written before anything is
+func checkEnvFiles(config ConfigStruct) error {
+	for target, fnames := range envFiles(config) {
+		if err := validateFileName(target); err != nil {
+			return err
+		}
+		if _, ok := config[target]; ok {
+			return fmt.Errorf("Env file %s has the same name as a config file", target)
+		}
+		keys := make(map[string]string)
+		for _, fname := range fnames {
+			key := envKey(fname, config[fname])
+			if !validEnvKey(key) {
+				return fmt.Errorf("Invalid env file variable %q for %s", key, fname)
+			}
+			if other, ok := keys[key]; ok {
+				return fmt.Errorf("%s and %s are both %s in env file %s", other, fname, key, target)
+			}
+			keys[key] = fname
+		}
+	}
+	return nil
+}
+
+func renderEnvFile(config ConfigStruct, fnames []string) ([]byte, error) {
+	lines := make([]string, 0, len(fnames))
+	for _, fname := range fnames {
+		content, err := config[fname].content()
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, envKey(fname, config[fname])+"="+envQuote(string(content)))
+		zeroize(content)
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// writeEnvFiles renders the env files of the new config that are out of date
+// and removes the ones only the previous config had
+func (a *App) writeEnvFiles(ctx context.Context, c *committedConfig) {
+	store := a.secretStore()
+	next := envFiles(c.next)
+	targets := make([]string, 0, len(next))
+	for target := range next {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		rendered, err := renderEnvFile(c.next, next[target])
+		if err != nil {
+			continue // stage already reported it
+		}
+		cur, err := store.Read(target)
+		if err == nil && bytes.Equal(cur, rendered) {
+			zeroize(rendered)
+			continue
+		}
+		LogEvent(EventEnvFileWritten, "Writing env file %s", target)
+		err = store.Write(target, rendered)
+		zeroize(rendered)
+		if err != nil {
+			err = fmt.Errorf("Unable to write env file %s: %w", target, err)
+			for _, fname := range next[target] {
+				c.report.fail(fname, err)
+			}
+		}
+	}
+	for target := range envFiles(c.prev) {
+		if _, ok := next[target]; ok {
+			continue
+		}
+		if _, ok := c.next[target]; ok {
+			continue // Now a config file of its own
+		}
+		if err := store.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to remove env file %s: %s", target, err)
+		}
+	}
+}
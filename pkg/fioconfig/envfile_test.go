@@ -0,0 +1,58 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvQuote(t *testing.T) {
+	require.Equal(t, "plain", envQuote("plain"))
+	require.Equal(t, "''", envQuote(""))
+	require.Equal(t, "'a b $HOME'", envQuote("a b $HOME"))
+	require.Equal(t, `"it's \$HOME\n\"x\" \\"`, envQuote("it's $HOME\n\"x\" \\"))
+}
+
+func TestEnvFiles(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		config := ConfigStruct{
+			"db-password": &ConfigFile{Value: "s3cret pass", EnvFile: "app.env"},
+			"db/host":     &ConfigFile{Value: "db.local", EnvFile: "app.env", EnvKey: "DATABASE_HOST"},
+			"motd":        &ConfigFile{Value: "hi\nthere", EnvFile: "app.env"},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		envFile := filepath.Join(app.SecretsDir, "app.env")
+		assertFile(t, envFile, []byte("DATABASE_HOST=db.local\nDB_PASSWORD='s3cret pass'\nMOTD=\"hi\\nthere\"\n"))
+		assertFile(t, filepath.Join(app.SecretsDir, "db-password"), []byte("s3cret pass"))
+
+		next := ConfigStruct{
+			"db-password": &ConfigFile{Value: "rotated", EnvFile: "app.env"},
+			"db/host":     config["db/host"],
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{config, next})
+		require.Nil(t, err)
+		assertFile(t, envFile, []byte("DATABASE_HOST=db.local\nDB_PASSWORD=rotated\n"))
+
+		_, err = app.extract(context.Background(), crypto, configSnapshot{next, ConfigStruct{"foo": &ConfigFile{Value: "foo"}}})
+		require.Nil(t, err)
+		_, err = os.Stat(envFile)
+		require.True(t, errors.Is(err, os.ErrNotExist))
+
+		dup := ConfigStruct{
+			"a": &ConfigFile{Value: "1", EnvFile: "app.env", EnvKey: "X"},
+			"b": &ConfigFile{Value: "2", EnvFile: "app.env", EnvKey: "X"},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, dup})
+		require.NotNil(t, err)
+	})
+}
@@ -0,0 +1,149 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Errors a check-in can fail with, so callers can recover from each class
+// of failure differently. Each typed error matches its sentinel with
+// errors.Is and keeps the underlying error for errors.As.
+
+// AuthFailedError matches the AuthError returned when the server rejects
+// the device's credentials
+var AuthFailedError = errors.New("Server rejected the device's credentials")
+
+// DeviceNotFoundError is returned when the server doesn't know the device
+var DeviceNotFoundError = errors.New("Device not found on server")
+
+// DecryptFailedError matches the DecryptError returned when a config value
+// can't be decrypted
+var DecryptFailedError = errors.New("Unable to decrypt config")
+
+// PartialExtractError matches the ExtractError returned when a config was
+// applied but parts of it didn't take effect
+var PartialExtractError = errors.New("Config only partially applied")
+
+// ExtractFailedError matches the ExtractFailure returned when a downloaded
+// config couldn't be applied
+var ExtractFailedError = errors.New("Unable to apply config")
+
+// NoConfigError is returned when the server has no config for the device.
+// It matches NotModifiedError since there's nothing to apply.
+var NoConfigError error = noConfigError{}
+
+// ServerUnreachableError matches the UnreachableError returned when the
+// server can't be reached or keeps failing
+var ServerUnreachableError = errors.New("Unable to reach server")
+
+type AuthError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%s: %s", AuthFailedError, e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+func (e *AuthError) Is(target error) bool {
+	return target == AuthFailedError
+}
+
+type DecryptError struct {
+	File string // Empty when a whole age bundle couldn't be decrypted
+	Err  error
+}
+
+func (e *DecryptError) Error() string {
+	if len(e.File) == 0 {
+		return fmt.Sprintf("%s: %s", DecryptFailedError, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %s", DecryptFailedError, e.File, e.Err)
+}
+
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecryptError) Is(target error) bool {
+	return target == DecryptFailedError
+}
+
+// ExtractError lists what didn't take effect when the files of a config
+// were applied: files that failed after being written and handlers that
+// didn't succeed.
+type ExtractError struct {
+	Failed   map[string]string
+	Warnings []string
+}
+
+func (e *ExtractError) Error() string {
+	var problems []string
+	for _, fname := range sortedKeys(e.Failed) {
+		problems = append(problems, fmt.Sprintf("%s: %s", fname, e.Failed[fname]))
+	}
+	problems = append(problems, e.Warnings...)
+	return fmt.Sprintf("%s: %s", PartialExtractError, strings.Join(problems, "; "))
+}
+
+func (e *ExtractError) Is(target error) bool {
+	return target == PartialExtractError
+}
+
+type ExtractFailure struct {
+	Err error
+}
+
+func (e *ExtractFailure) Error() string {
+	return fmt.Sprintf("%s: %s", ExtractFailedError, e.Err)
+}
+
+func (e *ExtractFailure) Unwrap() error {
+	return e.Err
+}
+
+func (e *ExtractFailure) Is(target error) bool {
+	return target == ExtractFailedError
+}
+
+type noConfigError struct{}
+
+func (noConfigError) Error() string {
+	return "Device has no config defined on server"
+}
+
+func (noConfigError) Is(target error) bool {
+	return target == NotModifiedError
+}
+
+type UnreachableError struct {
+	Url string
+	Err error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("%s %s: %s", ServerUnreachableError, e.Url, e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnreachableError) Is(target error) bool {
+	return target == ServerUnreachableError
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
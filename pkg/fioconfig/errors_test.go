@@ -0,0 +1,111 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type failingCrypto struct{}
+
+func (c failingCrypto) Decrypt(value string) ([]byte, error) {
+	return nil, errors.New("wrong key")
+}
+func (c failingCrypto) Close() {}
+
+func TestDecryptError(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, err := app.unmarshallCache(failingCrypto{}, app.EncryptedConfig, true)
+		var decryptErr *DecryptError
+		require.True(t, errors.As(err, &decryptErr), err)
+		require.True(t, errors.Is(err, DecryptFailedError))
+		require.NotEmpty(t, decryptErr.File)
+		require.Contains(t, err.Error(), "wrong key")
+	})
+}
+
+func TestPartialExtractError(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		buf, err := os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		var config map[string]*ConfigFile
+		require.Nil(t, json.Unmarshal(buf, &config))
+		config["bar"].OnChanged = []string{"/bin/false"}
+		buf, err = json.Marshal(config)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(app.EncryptedConfig, buf, 0o644))
+
+		err = app.Extract()
+		var extractErr *ExtractError
+		require.True(t, errors.As(err, &extractErr), err)
+		require.True(t, errors.Is(err, PartialExtractError))
+		require.Equal(t, []string{"bar: on-change command exited with 1"}, extractErr.Warnings)
+
+		// The files were still applied
+		content, err := os.ReadFile(filepath.Join(tempdir, "bar"))
+		require.Nil(t, err)
+		require.Equal(t, "bar file value", string(content))
+	})
+}
+
+func TestNoConfigAndExtractErrors(t *testing.T) {
+	status := http.StatusNoContent
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		_, err := w.Write([]byte(`{"../escape": {"Value": "x", "Unencrypted": true}}`))
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		err := app.CheckIn()
+		require.True(t, errors.Is(err, NoConfigError))
+		// Callers that only know about NotModifiedError keep working
+		require.True(t, errors.Is(err, NotModifiedError))
+		require.False(t, errors.Is(NotModifiedError, NoConfigError))
+
+		status = http.StatusOK
+		err = app.CheckIn()
+		var failure *ExtractFailure
+		require.True(t, errors.As(err, &failure), err)
+		require.True(t, errors.Is(err, ExtractFailedError))
+		require.False(t, errors.Is(err, NotModifiedError))
+	})
+}
+
+func TestUnreachableError(t *testing.T) {
+	err := error(&UnreachableError{"https://example.com/config", io.ErrUnexpectedEOF})
+	require.True(t, errors.Is(err, ServerUnreachableError))
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		// Cancelling a check-in isn't the server's fault
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		err = app.checkin(ctx, client, crypto)
+		require.True(t, errors.Is(err, context.Canceled), err)
+		require.False(t, errors.Is(err, ServerUnreachableError))
+	})
+}
+
+func TestCheckInResult(t *testing.T) {
+	require.Equal(t, "applied", CheckInResult(nil))
+	require.Equal(t, "not-modified", CheckInResult(NotModifiedError))
+	require.Equal(t, "partial", CheckInResult(&ExtractError{}))
+	require.Equal(t, "rate-limited", CheckInResult(&RateLimitedError{}))
+	require.Equal(t, "auth-failed", CheckInResult(&AuthError{401, errors.New("no")}))
+	require.Equal(t, "unreachable", CheckInResult(&UnreachableError{"url", errors.New("down")}))
+	require.Equal(t, "failed", CheckInResult(errors.New("other")))
+}
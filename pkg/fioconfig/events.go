@@ -0,0 +1,175 @@
+package fioconfig
+
+// EventCode identifies a significant event in fioconfig's logs and status
+// reports. Messages may be reworded between releases but codes never
+// change meaning and are never reused, so alerting should match on them.
+type EventCode string
+
+// Check-in and server communication
+const (
+	EventCheckIn             EventCode = "FIO-1000"
+	EventConfigDownloaded    EventCode = "FIO-1001"
+	EventConfigNotModified   EventCode = "FIO-1002"
+	EventNoConfig            EventCode = "FIO-1003"
+	EventServerFailover      EventCode = "FIO-1004"
+	EventServerUnreachable   EventCode = "FIO-1005"
+	EventCheckInFailed       EventCode = "FIO-1006"
+	EventRateLimited         EventCode = "FIO-1007"
+	EventDeltaFailed         EventCode = "FIO-1008"
+	EventLongPollUnsupported EventCode = "FIO-1009"
+	EventStateSaveFailed     EventCode = "FIO-1010"
+	EventNotification        EventCode = "FIO-1011"
+	EventMqttLost            EventCode = "FIO-1012"
+	EventStatusReportFailed  EventCode = "FIO-1013"
+	EventRemoteDebugIgnored  EventCode = "FIO-1014"
+	EventRemoteDebugRun      EventCode = "FIO-1015"
+	EventRemoteDebugFailed   EventCode = "FIO-1016"
+	EventCheckInDone         EventCode = "FIO-1017"
+	EventWebhookFailed       EventCode = "FIO-1018"
+	EventConfigFileSet       EventCode = "FIO-1019"
+	EventConfigFileDeleted   EventCode = "FIO-1020"
+	EventDownloadInterrupted EventCode = "FIO-1021"
+	EventDownloadResumed     EventCode = "FIO-1022"
+	EventWaitingForStartup   EventCode = "FIO-1023"
+	EventStartupWaitExpired  EventCode = "FIO-1024"
+	EventClockUntrusted      EventCode = "FIO-1025"
+	EventTagsChanged         EventCode = "FIO-1026"
+	EventLayerUnavailable    EventCode = "FIO-1027"
+	EventLayerChanged        EventCode = "FIO-1028"
+	EventPublishFailed       EventCode = "FIO-1029"
+)
+
+// Extraction of config files
+const (
+	EventConfigApplied        EventCode = "FIO-2000"
+	EventFileExtracted        EventCode = "FIO-2001"
+	EventFileRemoved          EventCode = "FIO-2002"
+	EventExtractFailed        EventCode = "FIO-2003"
+	EventLocalChangeKept      EventCode = "FIO-2004"
+	EventLooksLikeSecret      EventCode = "FIO-2005"
+	EventCaBundleInstalled    EventCode = "FIO-2006"
+	EventCaBundleRejected     EventCode = "FIO-2007"
+	EventManifestSaveFailed   EventCode = "FIO-2008"
+	EventHistorySaveFailed    EventCode = "FIO-2009"
+	EventConfigReverted       EventCode = "FIO-2010"
+	EventPrevConfigUnusable   EventCode = "FIO-2011"
+	EventEmptyDirCleanFailed  EventCode = "FIO-2012"
+	EventSecretsDirMigrated   EventCode = "FIO-2013"
+	EventExtractRollback      EventCode = "FIO-2014"
+	EventShadowOverride       EventCode = "FIO-2015"
+	EventVerifyRun            EventCode = "FIO-2016"
+	EventConfigRejected       EventCode = "FIO-2017"
+	EventFileProtected        EventCode = "FIO-2018"
+	EventFileDrift            EventCode = "FIO-2019"
+	EventDriftRepaired        EventCode = "FIO-2020"
+	EventRelabelFailed        EventCode = "FIO-2021"
+	EventConfigImported       EventCode = "FIO-2022"
+	EventCredentialWritten    EventCode = "FIO-2023"
+	EventVpnConfigured        EventCode = "FIO-2024"
+	EventAkliteCallback       EventCode = "FIO-2025"
+	EventBootEnvUpdated       EventCode = "FIO-2026"
+	EventSSHKeysUpdated       EventCode = "FIO-2027"
+	EventSystemSettingApplied EventCode = "FIO-2028"
+	EventEnvFileWritten       EventCode = "FIO-2029"
+	EventDiskSpaceLow         EventCode = "FIO-2030"
+	EventTargetWritten        EventCode = "FIO-2031"
+	EventSchemaInvalid        EventCode = "FIO-2032"
+	EventConfigHeld           EventCode = "FIO-2033"
+	EventConfigUnheld         EventCode = "FIO-2034"
+	EventOrphanRemoved        EventCode = "FIO-2035"
+	EventQueryRejected        EventCode = "FIO-2036"
+	EventConfigPending        EventCode = "FIO-2037"
+	EventAwaitingConfirm      EventCode = "FIO-2038"
+	EventConfigConfirmed      EventCode = "FIO-2039"
+	EventConfigAutoReverted   EventCode = "FIO-2040"
+	EventFileReleased         EventCode = "FIO-2041"
+)
+
+// On-changed handlers
+const (
+	EventHandlerRun       EventCode = "FIO-3001"
+	EventHandlerFailed    EventCode = "FIO-3002"
+	EventHandlerUnsafe    EventCode = "FIO-3003"
+	EventHandlerRejected  EventCode = "FIO-3004"
+	EventUnitAction       EventCode = "FIO-3005"
+	EventContainerRestart EventCode = "FIO-3006"
+	EventNMConnection     EventCode = "FIO-3007"
+	EventHandlerRetry     EventCode = "FIO-3008"
+	EventHandlerGaveUp    EventCode = "FIO-3009"
+)
+
+// Device identity and credentials
+const (
+	EventNeedsReenrollment  EventCode = "FIO-4001"
+	EventReenrollFailed     EventCode = "FIO-4002"
+	EventReenrolling        EventCode = "FIO-4003"
+	EventNoRecoveryToken    EventCode = "FIO-4004"
+	EventCertRenewing       EventCode = "FIO-4005"
+	EventCertRenewed        EventCode = "FIO-4006"
+	EventCertRenewFailed    EventCode = "FIO-4007"
+	EventRotationStep       EventCode = "FIO-4008"
+	EventRotationResumed    EventCode = "FIO-4009"
+	EventRotationComplete   EventCode = "FIO-4010"
+	EventOldKeyRetired      EventCode = "FIO-4011"
+	EventOldKeyRetireFailed EventCode = "FIO-4012"
+	EventTlsFailure         EventCode = "FIO-4013"
+	EventPkcs11Reopened     EventCode = "FIO-4014"
+)
+
+// Configuration and build
+const (
+	EventUnknownSetting EventCode = "FIO-5001"
+	EventFipsViolation  EventCode = "FIO-5002"
+	EventTlsDebugBuild  EventCode = "FIO-5003"
+	EventTlsKeyLog      EventCode = "FIO-5004"
+	EventSighupReload   EventCode = "FIO-5005"
+	EventInitFailed     EventCode = "FIO-5006"
+	EventInitGaveUp     EventCode = "FIO-5007"
+)
+
+// eventLevels are the log levels of events that aren't just informational
+var eventLevels = map[EventCode]LogLevel{
+	EventServerUnreachable:   LevelWarn,
+	EventCheckInFailed:       LevelError,
+	EventRateLimited:         LevelWarn,
+	EventDeltaFailed:         LevelWarn,
+	EventStateSaveFailed:     LevelWarn,
+	EventMqttLost:            LevelWarn,
+	EventStatusReportFailed:  LevelWarn,
+	EventRemoteDebugFailed:   LevelWarn,
+	EventWebhookFailed:       LevelWarn,
+	EventDownloadInterrupted: LevelWarn,
+	EventStartupWaitExpired:  LevelWarn,
+	EventClockUntrusted:      LevelWarn,
+	EventLayerUnavailable:    LevelWarn,
+	EventPublishFailed:       LevelWarn,
+	EventExtractFailed:       LevelError,
+	EventDiskSpaceLow:        LevelError,
+	EventLooksLikeSecret:     LevelWarn,
+	EventCaBundleRejected:    LevelError,
+	EventManifestSaveFailed:  LevelWarn,
+	EventHistorySaveFailed:   LevelWarn,
+	EventPrevConfigUnusable:  LevelError,
+	EventQueryRejected:       LevelWarn,
+	EventConfigAutoReverted:  LevelError,
+	EventEmptyDirCleanFailed: LevelWarn,
+	EventExtractRollback:     LevelWarn,
+	EventConfigRejected:      LevelError,
+	EventSchemaInvalid:       LevelError,
+	EventConfigHeld:          LevelWarn,
+	EventFileDrift:           LevelWarn,
+	EventHandlerFailed:       LevelError,
+	EventHandlerGaveUp:       LevelError,
+	EventHandlerUnsafe:       LevelWarn,
+	EventHandlerRejected:     LevelError,
+	EventNeedsReenrollment:   LevelError,
+	EventReenrollFailed:      LevelError,
+	EventCertRenewFailed:     LevelError,
+	EventOldKeyRetireFailed:  LevelWarn,
+	EventTlsFailure:          LevelError,
+	EventPkcs11Reopened:      LevelWarn,
+	EventUnknownSetting:      LevelWarn,
+	EventFipsViolation:       LevelError,
+	EventInitFailed:          LevelWarn,
+	EventInitGaveUp:          LevelError,
+}
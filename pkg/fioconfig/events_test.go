@@ -0,0 +1,40 @@
+package fioconfig
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Codes are referenced by alerting and documentation, so make sure nobody
+// accidentally gives two events the same one.
+func TestEventCodesUnique(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "events.go", nil, 0)
+	require.Nil(t, err)
+	format := regexp.MustCompile(`^FIO-[1-9][0-9]{3}$`)
+	seen := make(map[string]string)
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok || len(spec.Values) != 1 {
+			return true
+		}
+		lit, ok := spec.Values[0].(*ast.BasicLit)
+		if !ok {
+			return true
+		}
+		code, err := strconv.Unquote(lit.Value)
+		require.Nil(t, err)
+		name := spec.Names[0].Name
+		require.Regexp(t, format, code, name)
+		prev, dup := seen[code]
+		require.False(t, dup, "%s and %s both use %s", prev, name, code)
+		seen[code] = name
+		return true
+	})
+	require.NotEmpty(t, seen)
+}
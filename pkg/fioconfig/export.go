@@ -0,0 +1,56 @@
+package fioconfig
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// WriteExport writes a gzip'd tarball to `w` with the device's
+// config.encrypted along with the manifest of managed files, check-in state,
+// and config history index. config.encrypted is written without any
+// cache_sealers applied, since a TPM sealed copy couldn't be opened
+// elsewhere, but its values stay encrypted to the device key. The archive
+// can only be used by a unit with the same key.
+func (a *App) WriteExport(w io.Writer) error {
+	encrypted, err := a.readCache(a.EncryptedConfig)
+	if err != nil {
+		return fmt.Errorf("Unable to read encrypted config: %w", err)
+	}
+	files := []struct {
+		name    string
+		content func() ([]byte, error)
+	}{
+		{"config.encrypted", func() ([]byte, error) { return encrypted, nil }},
+		{"manifest.json", func() ([]byte, error) { return readOptional(a.manifestFile()) }},
+		{"checkin.state", func() ([]byte, error) { return readOptional(a.checkInStateFile()) }},
+		{"history.json", func() ([]byte, error) { return readOptional(filepath.Join(a.historyDir(), "index.json")) }},
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range files {
+		content, err := f.content()
+		if err != nil {
+			return err
+		}
+		if len(content) == 0 {
+			continue
+		}
+		hdr := &tar.Header{Name: f.name, Mode: 0o600, Size: int64(len(content)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
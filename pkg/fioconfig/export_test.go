@@ -0,0 +1,48 @@
+package fioconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		require.Nil(t, app.Extract())
+
+		var buf bytes.Buffer
+		require.Nil(t, app.WriteExport(&buf))
+
+		gz, err := gzip.NewReader(&buf)
+		require.Nil(t, err)
+		tr := tar.NewReader(gz)
+		found := make(map[string]string)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.Nil(t, err)
+			content, err := io.ReadAll(tr)
+			require.Nil(t, err)
+			found[hdr.Name] = string(content)
+		}
+
+		encrypted, err := os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Equal(t, string(encrypted), found["config.encrypted"])
+		require.NotContains(t, found["config.encrypted"], "foo file value")
+		require.Contains(t, found["manifest.json"], "with/subdir/1.txt")
+		// Nothing has been checked in, so there's no state to include
+		require.NotContains(t, found, "checkin.state")
+
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+		require.NotNil(t, app.WriteExport(&buf))
+	})
+}
@@ -0,0 +1,92 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// validateFileName makes sure a config file name is a relative path that
+// stays inside the secrets directory. Names like "wireguard/wg0.conf" are
+// fine and their directories are created as needed.
+func validateFileName(fname string) error {
+	if len(fname) == 0 {
+		return fmt.Errorf("Invalid config file name: empty")
+	}
+	for _, c := range fname {
+		if c < 0x20 || c == 0x7f || c == '\\' {
+			return fmt.Errorf("Invalid config file name %q: must not contain control characters or backslashes", fname)
+		}
+	}
+	if filepath.IsAbs(fname) {
+		return fmt.Errorf("Invalid config file name %q: must be relative", fname)
+	}
+	for _, part := range strings.Split(fname, "/") {
+		if part == ".." {
+			return fmt.Errorf("Invalid config file name %q: must not contain ..", fname)
+		}
+	}
+	for _, reserved := range []string{txnDirName, prevDirName} {
+		if fname == reserved || strings.HasPrefix(fname, reserved+"/") {
+			return fmt.Errorf("Invalid config file name %q: %s is reserved", fname, reserved)
+		}
+	}
+	if filepath.Clean(fname) != fname || strings.HasSuffix(fname, "/") {
+		return fmt.Errorf("Invalid config file name %q: must be a clean path", fname)
+	}
+	return nil
+}
+
+// within returns true if path is dir or inside of it. Both must be clean.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// resolveDir resolves the symlinks in dir, or in the deepest parent of it
+// that exists
+func resolveDir(dir string) (string, error) {
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = filepath.Dir(dir)
+	}
+}
+
+// checkFilePath makes sure a valid file name doesn't escape the secrets
+// directory through a symlinked directory in it. The file itself may be a
+// symlink since extraction replaces it rather than writing through it.
+// Symlinks into allowed_dirs are fine.
+func (a *App) checkFilePath(fname string) error {
+	if a.store != nil {
+		return nil // Not a filesystem
+	}
+	root, err := filepath.EvalSymlinks(a.SecretsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // Nothing in it can be a symlink yet
+	} else if err != nil {
+		return err
+	}
+
+	dir, err := resolveDir(filepath.Dir(filepath.Join(root, fname)))
+	if err != nil {
+		return err
+	}
+	if within(dir, root) {
+		return nil
+	}
+	for _, allowed := range a.settings.AllowedDirs {
+		if within(dir, filepath.Clean(allowed)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid config file name %q: resolves to %s, outside of %s", fname, dir, a.SecretsDir)
+}
@@ -0,0 +1,104 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFileName(t *testing.T) {
+	for _, name := range []string{"foo", "wireguard/wg0.conf", "a/b/c.txt", ".hidden", "x..y"} {
+		require.Nil(t, validateFileName(name), name)
+	}
+	for _, name := range []string{"", "/etc/passwd", "../foo", "a/../../foo", "a/..", "./foo", "a//b", "a/", ".fioconfig-txn/x", ".fioconfig-prev", "a\x00b", "a\nb", "a\\b"} {
+		require.NotNil(t, validateFileName(name), name)
+	}
+}
+
+func TestExtractRejectsTraversal(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		secrets := filepath.Join(tempdir, "secrets")
+		require.Nil(t, os.Mkdir(secrets, 0o750))
+		app.SecretsDir = secrets
+
+		config := map[string]*ConfigFile{
+			"ok":         {Value: "ok", Unencrypted: true},
+			"../escaped": {Value: "bad", Unencrypted: true},
+		}
+		buf, err := json.Marshal(config)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(app.EncryptedConfig, buf, 0o640))
+
+		require.NotNil(t, app.Extract())
+		assertNoFile(t, filepath.Join(tempdir, "escaped"))
+		assertNoFile(t, filepath.Join(secrets, "ok"))
+	})
+}
+
+func TestExtractRejectsSymlinkEscape(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		secrets := filepath.Join(tempdir, "secrets")
+		outside := filepath.Join(tempdir, "outside")
+		require.Nil(t, os.Mkdir(secrets, 0o750))
+		require.Nil(t, os.Mkdir(outside, 0o750))
+		require.Nil(t, os.Symlink(outside, filepath.Join(secrets, "link")))
+		require.Nil(t, os.Symlink("..", filepath.Join(secrets, "up")))
+		app.SecretsDir = secrets
+
+		require.Nil(t, app.checkFilePath("ok"))
+		require.Nil(t, app.checkFilePath("new/dir/file"))
+		require.NotNil(t, app.checkFilePath("up/escaped"))
+		require.NotNil(t, app.checkFilePath("link/new/file"))
+
+		config := map[string]*ConfigFile{
+			"ok":       {Value: "ok", Unencrypted: true},
+			"link/wg0": {Value: "bad", Unencrypted: true},
+		}
+		buf, err := json.Marshal(config)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(app.EncryptedConfig, buf, 0o640))
+		require.NotNil(t, app.Extract())
+		assertNoFile(t, filepath.Join(outside, "wg0"))
+		assertNoFile(t, filepath.Join(secrets, "ok"))
+
+		// Unless the directory is allowed
+		app.settings.AllowedDirs = []string{outside + "/"}
+		require.Nil(t, app.Extract())
+		assertFile(t, filepath.Join(outside, "wg0"), []byte("bad"))
+		require.NotNil(t, app.checkFilePath("up/escaped"))
+	})
+}
+
+func TestExtractMaxFileSize(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		secrets := filepath.Join(tempdir, "secrets")
+		require.Nil(t, os.Mkdir(secrets, 0o750))
+		app.SecretsDir = secrets
+		app.settings.MaxFileSize = 4
+
+		config := map[string]*ConfigFile{
+			"ok":    {Value: "1234", Unencrypted: true},
+			"b64":   {Value: "MTIzNA==", Unencrypted: true, Encoding: EncodingBase64},
+			"large": {Value: "12345", Unencrypted: true},
+		}
+		buf, err := json.Marshal(config)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(app.EncryptedConfig, buf, 0o640))
+		err = app.Extract()
+		require.True(t, errors.Is(err, PayloadTooLargeError), err)
+		require.Contains(t, err.Error(), "large")
+		assertNoFile(t, filepath.Join(secrets, "ok"))
+
+		delete(config, "large")
+		buf, err = json.Marshal(config)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(app.EncryptedConfig, buf, 0o640))
+		require.Nil(t, app.Extract())
+		assertFile(t, filepath.Join(secrets, "b64"), []byte("1234"))
+	})
+}
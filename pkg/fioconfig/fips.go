@@ -0,0 +1,56 @@
+package fioconfig
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+)
+
+// Set by builds with the fips build tag. The daemon then refuses to start
+// with any non-approved algorithm rather than just logging about it.
+var fipsMode = false
+
+// Whether FIPS builds are really using the BoringCrypto module
+var fipsCryptoEnabled = true
+
+// ECIES uses ECDH, the concatenation KDF with SHA-256, AES-CTR, and
+// HMAC-SHA256, which are all approved. It's the curve that matters.
+var fipsCurves = map[elliptic.Curve]bool{
+	elliptic.P256(): true,
+	elliptic.P384(): true,
+	elliptic.P521(): true,
+}
+
+// checkFips makes sure the TLS settings and the device's key only use FIPS
+// approved algorithms.
+func checkFips(settings Settings, crypto CryptoHandler) error {
+	for _, curve := range settings.TlsCurves {
+		if curve == "X25519" {
+			return errors.New("Curve X25519 is not FIPS approved")
+		}
+	}
+	if ec, ok := crypto.(*EciesCrypto); ok {
+		curve := ec.PrivKey.Public().Curve
+		if !fipsCurves[curve] {
+			return fmt.Errorf("Device key curve %s is not FIPS approved", curve.Params().Name)
+		}
+	}
+	return nil
+}
+
+// assertFips enforces checkFips in FIPS builds. Other builds using the fips
+// TLS preset get a loud warning instead.
+func assertFips(settings Settings, crypto CryptoHandler) error {
+	if !fipsMode && settings.TlsPreset != TlsPresetFips {
+		return nil
+	}
+	if fipsMode && !fipsCryptoEnabled {
+		return errors.New("FIPS build of fioconfig is not using BoringCrypto")
+	}
+	err := checkFips(settings, crypto)
+	if err != nil && !fipsMode {
+		LogEvent(EventFipsViolation, "WARNING: fioconfig.tls_preset is fips but: %s", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,19 @@
+//go:build fips
+// +build fips
+
+package fioconfig
+
+// FIPS builds need the BoringCrypto module:
+//   GOEXPERIMENT=boringcrypto go build -tags fips
+// fipsonly restricts crypto/tls to FIPS approved versions, cipher suites,
+// and curves.
+
+import (
+	"crypto/boring"
+	_ "crypto/tls/fipsonly"
+)
+
+func init() {
+	fipsMode = true
+	fipsCryptoEnabled = boring.Enabled()
+}
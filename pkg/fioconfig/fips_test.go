@@ -0,0 +1,26 @@
+package fioconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFips(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	require.Nil(t, checkFips(Settings{}, NewEciesLocalHandler(key)))
+	require.NotNil(t, checkFips(Settings{TlsCurves: []string{"P256", "X25519"}}, NewEciesLocalHandler(key)))
+
+	key, err = ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	require.Nil(t, err)
+	require.NotNil(t, checkFips(Settings{}, NewEciesLocalHandler(key)))
+
+	// Only a warning unless this is a FIPS build
+	if !fipsMode {
+		require.Nil(t, assertFips(Settings{TlsPreset: TlsPresetFips}, NewEciesLocalHandler(key)))
+	}
+}
@@ -0,0 +1,187 @@
+package fioconfig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Values of secrets_encryption. "require" refuses to extract plaintext
+// into a secrets directory without an fscrypt policy, leaving it to
+// something else to set one up. "fscrypt" sets up the policy itself with a
+// key only this device can unwrap.
+const (
+	SecretsEncryptionNone    = ""
+	SecretsEncryptionRequire = "require"
+	SecretsEncryptionFscrypt = "fscrypt"
+)
+
+// UnencryptedSecretsDirError is returned instead of extracting to a secrets
+// directory that isn't encrypted when secrets_encryption requires it
+var UnencryptedSecretsDirError = errors.New("Refusing to extract config to an unencrypted secrets directory")
+
+// The fscrypt key is encrypted to the device key, and then sealed by
+// cache_sealers, so it can only be recovered with the device's crypto
+// handler and, with the tpm2 sealer, its TPM.
+const fscryptKeyFile = "fscrypt.key"
+
+type keyEncrypter interface {
+	Encrypt(value string) (string, error)
+}
+
+// fscryptPolicy returns the secrets directory's fscrypt v2 policy, or nil
+// if it isn't encrypted
+func fscryptPolicy(dir *os.File) (*unix.FscryptPolicyV2, error) {
+	arg := unix.FscryptGetPolicyExArg{Size: uint64(unsafe.Sizeof(unix.FscryptPolicyV2{}))}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, dir.Fd(), unix.FS_IOC_GET_ENCRYPTION_POLICY_EX, uintptr(unsafe.Pointer(&arg)))
+	switch errno {
+	case 0:
+	case unix.ENODATA:
+		return nil, nil
+	case unix.ENOTTY, unix.EOPNOTSUPP:
+		return nil, fmt.Errorf("%w: %s does not support fscrypt", UnencryptedSecretsDirError, dir.Name())
+	default:
+		return nil, fmt.Errorf("Unable to get encryption policy of %s: %w", dir.Name(), errno)
+	}
+	if arg.Policy[0] != unix.FSCRYPT_POLICY_V2 {
+		return nil, fmt.Errorf("%s uses a v1 fscrypt policy, which is not supported", dir.Name())
+	}
+	policy := *(*unix.FscryptPolicyV2)(unsafe.Pointer(&arg.Policy[0]))
+	return &policy, nil
+}
+
+// addFscryptKey adds key to the filesystem of dir and returns its
+// identifier. Adding a key that's already there is harmless.
+func addFscryptKey(dir *os.File, key []byte) ([16]byte, error) {
+	var id [16]byte
+	arg := unix.FscryptAddKeyArg{
+		Key_spec: unix.FscryptKeySpecifier{Type: unix.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER},
+		Raw_size: uint32(len(key)),
+	}
+	// The key follows the struct
+	size := unsafe.Sizeof(arg)
+	buf := make([]byte, int(size)+len(key))
+	copy(buf, (*[unsafe.Sizeof(unix.FscryptAddKeyArg{})]byte)(unsafe.Pointer(&arg))[:])
+	copy(buf[size:], key)
+	defer zeroize(buf)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, dir.Fd(), unix.FS_IOC_ADD_ENCRYPTION_KEY, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return id, fmt.Errorf("Unable to add fscrypt key for %s: %w", dir.Name(), errno)
+	}
+	arg = *(*unix.FscryptAddKeyArg)(unsafe.Pointer(&buf[0]))
+	copy(id[:], arg.Key_spec.U[:16])
+	return id, nil
+}
+
+func setFscryptPolicy(dir *os.File, id [16]byte) error {
+	policy := unix.FscryptPolicyV2{
+		Version:                   unix.FSCRYPT_POLICY_V2,
+		Contents_encryption_mode:  unix.FSCRYPT_MODE_AES_256_XTS,
+		Filenames_encryption_mode: unix.FSCRYPT_MODE_AES_256_CTS,
+		Flags:                     unix.FSCRYPT_POLICY_FLAGS_PAD_32,
+		Master_key_identifier:     id,
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, dir.Fd(), unix.FS_IOC_SET_ENCRYPTION_POLICY, uintptr(unsafe.Pointer(&policy)))
+	if errno != 0 {
+		return fmt.Errorf("Unable to encrypt %s: %w", dir.Name(), errno)
+	}
+	return nil
+}
+
+// fscryptKey returns the key protecting the secrets directory, creating
+// one if needed
+func (a *App) fscryptKey(crypto CryptoHandler, create bool) ([]byte, error) {
+	path := filepath.Join(a.stateDir(), fscryptKeyFile)
+	wrapped, err := a.readCache(path)
+	if err == nil {
+		key, err := crypto.Decrypt(string(wrapped))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to unwrap fscrypt key: %w", err)
+		}
+		return key, nil
+	} else if !errors.Is(err, os.ErrNotExist) || !create {
+		return nil, fmt.Errorf("Unable to read fscrypt key: %w", err)
+	}
+
+	if c, ok := crypto.(ctxCrypto); ok {
+		crypto = c.CryptoHandler
+	}
+	enc, ok := crypto.(keyEncrypter)
+	if !ok {
+		return nil, errors.New("Crypto handler can't wrap an fscrypt key")
+	}
+	key := make([]byte, unix.FSCRYPT_MAX_KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	value, err := enc.Encrypt(string(key))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to wrap fscrypt key: %w", err)
+	}
+	if err := a.writeCache(path, []byte(value)); err != nil {
+		return nil, fmt.Errorf("Unable to save fscrypt key: %w", err)
+	}
+	return key, nil
+}
+
+// ensureEncryptedSecretsDir makes sure the secrets directory is encrypted
+// as secrets_encryption asks before anything is extracted to it
+func (a *App) ensureEncryptedSecretsDir(crypto CryptoHandler) error {
+	mode := a.settings.SecretsEncryption
+	switch mode {
+	case SecretsEncryptionNone:
+		return nil
+	case SecretsEncryptionRequire, SecretsEncryptionFscrypt:
+	default:
+		return fmt.Errorf("Unknown fioconfig.secrets_encryption: %s", mode)
+	}
+	dir, err := os.Open(a.SecretsDir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	policy, err := fscryptPolicy(dir)
+	if err != nil {
+		return err
+	}
+	if mode == SecretsEncryptionRequire {
+		if policy == nil {
+			return fmt.Errorf("%w: %s", UnencryptedSecretsDirError, a.SecretsDir)
+		}
+		return nil
+	}
+
+	if policy == nil {
+		names, err := dir.Readdirnames(1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(names) > 0 {
+			return fmt.Errorf("%w: %s must be empty to be encrypted", UnencryptedSecretsDirError, a.SecretsDir)
+		}
+	}
+	key, err := a.fscryptKey(crypto, policy == nil)
+	if err != nil {
+		return err
+	}
+	defer zeroize(key)
+	id, err := addFscryptKey(dir, key)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		logger.Printf("Encrypting %s with fscrypt", a.SecretsDir)
+		return setFscryptPolicy(dir, id)
+	}
+	if !bytes.Equal(policy.Master_key_identifier[:], id[:]) {
+		return fmt.Errorf("%s is encrypted with a key other than fioconfig's", a.SecretsDir)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package fioconfig
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretsEncryptionRequired(t *testing.T) {
+	app := &App{SecretsDir: t.TempDir()}
+	require.Nil(t, app.ensureEncryptedSecretsDir(nil))
+
+	// Test directories aren't encrypted, or don't support it at all
+	app.settings.SecretsEncryption = SecretsEncryptionRequire
+	err := app.ensureEncryptedSecretsDir(nil)
+	require.True(t, errors.Is(err, UnencryptedSecretsDirError), err)
+
+	app.settings.SecretsEncryption = "bitlocker"
+	require.NotNil(t, app.ensureEncryptedSecretsDir(nil))
+}
+
+func TestFscryptKey(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	crypto := NewEciesLocalHandler(priv)
+	app := &App{sotaConfig: t.TempDir()}
+
+	_, err = app.fscryptKey(crypto, false)
+	require.True(t, errors.Is(err, os.ErrNotExist), err)
+
+	key, err := app.fscryptKey(crypto, true)
+	require.Nil(t, err)
+	require.Len(t, key, 64)
+	wrapped, err := os.ReadFile(filepath.Join(app.sotaConfig, fscryptKeyFile))
+	require.Nil(t, err)
+	require.False(t, bytes.Contains(wrapped, key))
+
+	again, err := app.fscryptKey(crypto, true)
+	require.Nil(t, err)
+	require.Equal(t, key, again)
+}
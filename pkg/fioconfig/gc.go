@@ -0,0 +1,153 @@
+package fioconfig
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The manifest is the record of what fioconfig created in the secrets
+// directory. A file stays in it until fioconfig removes the file, so files
+// a config dropped without being removed, e.g. by versions that didn't
+// track removals or after a local override went away, are still known to
+// be fioconfig's. Full extractions note these orphans and CollectGarbage
+// removes them along with the temporary files of interrupted writes.
+// Anything else in the directory is left alone.
+
+// How old a temporary file must be before it's considered left over rather
+// than part of a write in progress
+const staleTmpAge = time.Hour
+
+// GarbageReport lists what CollectGarbage removed, or would remove
+type GarbageReport struct {
+	Orphans  []string `json:"orphans"`
+	TmpFiles []string `json:"tmp-files"`
+	// Orphans changed since fioconfig wrote them, which are kept
+	Modified []string `json:"modified,omitempty"`
+}
+
+// orphans returns the files in applied that next doesn't have
+func orphans(applied manifest, next ConfigStruct, report *ExtractReport) []string {
+	var names []string
+	for fname := range applied {
+		if _, ok := next[fname]; ok {
+			continue
+		}
+		if _, ok := report.Failed[fname]; ok {
+			continue
+		}
+		names = append(names, fname)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CollectGarbage removes the orphans found by the last full extraction and
+// leftover temporary files of the files fioconfig manages. Orphans modified
+// since they were written are kept. With dryRun nothing is removed.
+func (a *App) CollectGarbage(dryRun bool) (*GarbageReport, error) {
+	state := a.readManifest()
+	report := &GarbageReport{Orphans: []string{}, TmpFiles: []string{}}
+	var kept []string
+	dirty := false
+	for _, fname := range state.Orphans {
+		hash, ok := state.Files[fname]
+		if !ok {
+			continue // Applied again or removed since
+		}
+		cur, err := a.readManaged(fname)
+		if errors.Is(err, os.ErrNotExist) {
+			delete(state.Files, fname)
+			dirty = true
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if sha256Hex(cur) != hash {
+			report.Modified = append(report.Modified, fname)
+			kept = append(kept, fname)
+			continue
+		}
+		report.Orphans = append(report.Orphans, fname)
+		if dryRun {
+			kept = append(kept, fname)
+			continue
+		}
+		if err := a.secretStore().Remove(fname); err != nil {
+			return nil, err
+		}
+		LogEventWith(EventOrphanRemoved, LogFields{"file": fname}, "Removed %s, it's no longer in the config", fname)
+		delete(state.Files, fname)
+		dirty = true
+	}
+	state.Orphans = kept
+
+	if a.store == nil {
+		tmpFiles, err := a.staleTmpFiles(state)
+		if err != nil {
+			return nil, err
+		}
+		for _, fname := range tmpFiles {
+			report.TmpFiles = append(report.TmpFiles, fname)
+			if dryRun {
+				continue
+			}
+			if err := os.Remove(filepath.Join(a.SecretsDir, fname)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			LogEventWith(EventOrphanRemoved, LogFields{"file": fname}, "Removed leftover temporary file %s", fname)
+		}
+	}
+	if dryRun || !dirty {
+		return report, nil
+	}
+	if err := a.saveManifest(state); err != nil {
+		return nil, err
+	}
+	if a.store == nil && len(report.Orphans) > 0 {
+		if err := DeleteEmptyDirs(a.SecretsDir); err != nil {
+			LogEvent(EventEmptyDirCleanFailed, "ERROR removing empty directories: %s", err)
+		}
+	}
+	return report, nil
+}
+
+// staleTmpFiles returns the `<name>.tmp` files safeWrite left behind for
+// the files in state
+func (a *App) staleTmpFiles(state manifestState) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(a.SecretsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == txnDirName || d.Name() == prevDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(a.SecretsDir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := state.Files[strings.TrimSuffix(rel, ".tmp")]; !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if time.Since(info.ModTime()) >= staleTmpAge {
+			names = append(names, rel)
+		}
+		return nil
+	})
+	return names, err
+}
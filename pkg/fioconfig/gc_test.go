@@ -0,0 +1,82 @@
+package fioconfig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		config := ConfigStruct{
+			"kept":        {Value: "kept"},
+			"dropped":     {Value: "dropped"},
+			"sub/dropped": {Value: "dropped"},
+			"edited":      {Value: "edited"},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+
+		// Without the previous config nothing is removed, the files are
+		// orphaned instead
+		next := ConfigStruct{"kept": config["kept"]}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, next})
+		require.Nil(t, err)
+		require.Equal(t, []string{"dropped", "edited", "sub/dropped"}, report.Orphans)
+		assertFile(t, filepath.Join(tempdir, "dropped"), []byte("dropped"))
+
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "edited"), []byte("by hand"), 0o644))
+		old := time.Now().Add(-2 * staleTmpAge)
+		for _, name := range []string{"kept.tmp", "mine", "mine.tmp"} {
+			path := filepath.Join(tempdir, name)
+			require.Nil(t, os.WriteFile(path, []byte("x"), 0o644))
+			require.Nil(t, os.Chtimes(path, old, old))
+		}
+
+		gc, err := app.CollectGarbage(true)
+		require.Nil(t, err)
+		want := &GarbageReport{
+			Orphans:  []string{"dropped", "sub/dropped"},
+			TmpFiles: []string{"kept.tmp"},
+			Modified: []string{"edited"},
+		}
+		require.Equal(t, want, gc)
+		assertFile(t, filepath.Join(tempdir, "dropped"), []byte("dropped"))
+		assertFile(t, filepath.Join(tempdir, "kept.tmp"), []byte("x"))
+
+		gc, err = app.CollectGarbage(false)
+		require.Nil(t, err)
+		require.Equal(t, want, gc)
+		assertNoFile(t, filepath.Join(tempdir, "dropped"))
+		assertNoFile(t, filepath.Join(tempdir, "sub"))
+		assertNoFile(t, filepath.Join(tempdir, "kept.tmp"))
+		assertFile(t, filepath.Join(tempdir, "edited"), []byte("by hand"))
+		assertFile(t, filepath.Join(tempdir, "mine"), []byte("x"))
+		assertFile(t, filepath.Join(tempdir, "mine.tmp"), []byte("x"))
+		assertFile(t, filepath.Join(tempdir, "kept"), []byte("kept"))
+		state := app.readManifest()
+		require.Equal(t, []string{"edited"}, state.Orphans)
+		require.NotContains(t, state.Files, "dropped")
+
+		// Nothing left to collect
+		gc, err = app.CollectGarbage(false)
+		require.Nil(t, err)
+		require.Empty(t, gc.Orphans)
+		require.Empty(t, gc.TmpFiles)
+
+		// An orphan that's back in the config isn't one anymore
+		report, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Empty(t, report.Orphans)
+		require.Empty(t, app.readManifest().Orphans)
+	})
+}
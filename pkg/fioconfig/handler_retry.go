@@ -0,0 +1,196 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A handler that fails, e.g. because the service it restarts isn't
+// installed yet, would otherwise never run again for that version of its
+// file since the file doesn't change anymore. With handler_retries, failed
+// handlers are saved and retried on later check-ins with a backoff, until
+// they succeed, a new version of the file runs its own, or they've been
+// retried that many times.
+
+const (
+	handlerRetryBackoff    = time.Minute
+	maxHandlerRetryBackoff = time.Hour
+)
+
+// HandlerRetry is an on-changed command waiting to be run again
+type HandlerRetry struct {
+	File string `json:"file"`
+	// The sha256 of the version of the file it's for
+	Hash string `json:"sha256"`
+	// The file's entry without its value, so the handler runs as it was
+	// configured then
+	Config *ConfigFile `json:"config"`
+	// How many times it has failed
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func (a *App) handlerRetriesFile() string {
+	return filepath.Join(a.stateDir(), "handler-retries.json")
+}
+
+// HandlerRetries returns the failed handlers waiting to be retried
+func (a *App) HandlerRetries() ([]HandlerRetry, error) {
+	buf, err := os.ReadFile(a.handlerRetriesFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var retries []HandlerRetry
+	if err := json.Unmarshal(buf, &retries); err != nil {
+		return nil, err
+	}
+	return retries, nil
+}
+
+func (a *App) saveHandlerRetries(retries []HandlerRetry) error {
+	if len(retries) == 0 {
+		if err := os.Remove(a.handlerRetriesFile()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	sort.Slice(retries, func(i, j int) bool { return retries[i].File < retries[j].File })
+	buf, err := json.Marshal(retries)
+	if err != nil {
+		return err
+	}
+	return safeWrite(a.handlerRetriesFile(), buf)
+}
+
+func handlerFailed(result *HandlerResult) bool {
+	if result == nil || result.Skipped {
+		return false
+	}
+	return result.TimedOut || (result.ExitCode != 0 && result.ExitCode != onChangedForceExit)
+}
+
+// retryAfter returns when a handler that failed attempts times is next run
+func retryAfter(attempts int) time.Time {
+	backoff := maxHandlerRetryBackoff
+	if attempts < 8 {
+		backoff = handlerRetryBackoff << (attempts - 1)
+		if backoff > maxHandlerRetryBackoff {
+			backoff = maxHandlerRetryBackoff
+		}
+	}
+	return time.Now().Add(backoff)
+}
+
+// queueHandlerRetries saves the handlers an extraction ran that failed, and
+// forgets earlier failures of the ones that succeeded
+func (a *App) queueHandlerRetries(handlers []pendingHandler, results []*HandlerResult, applied manifest) {
+	if a.settings.HandlerRetries <= 0 || len(handlers) == 0 {
+		return
+	}
+	retries, err := a.HandlerRetries()
+	if err != nil {
+		logger.Printf("Unable to load handler retries: %s", err)
+	}
+	byFile := make(map[string]HandlerRetry, len(retries))
+	for _, retry := range retries {
+		byFile[retry.File] = retry
+	}
+	for i, h := range handlers {
+		hash, ok := applied[h.fname]
+		if !ok || !handlerFailed(results[i]) {
+			// Removed files' handlers aren't retried
+			delete(byFile, h.fname)
+			continue
+		}
+		retry := byFile[h.fname]
+		if retry.Hash != hash {
+			cfgFile := *h.cfgFile
+			cfgFile.Value = ""
+			retry = HandlerRetry{File: h.fname, Hash: hash, Config: &cfgFile}
+		}
+		retry.Attempts++
+		retry.Next = retryAfter(retry.Attempts)
+		retry.Error = results[i].Error
+		byFile[h.fname] = retry
+	}
+	a.saveRetries(byFile)
+}
+
+func (a *App) saveRetries(byFile map[string]HandlerRetry) {
+	retries := make([]HandlerRetry, 0, len(byFile))
+	for _, retry := range byFile {
+		retries = append(retries, retry)
+	}
+	if err := a.saveHandlerRetries(retries); err != nil {
+		logger.Printf("Unable to save handler retries: %s", err)
+	}
+}
+
+// retryHandlers runs the failed handlers that are due again
+func (a *App) retryHandlers(ctx context.Context) {
+	if a.settings.HandlerRetries <= 0 {
+		return
+	}
+	retries, err := a.HandlerRetries()
+	if err != nil {
+		logger.Printf("Unable to load handler retries: %s", err)
+		return
+	} else if len(retries) == 0 {
+		return
+	}
+	applied := a.loadManifest()
+	byFile := make(map[string]HandlerRetry, len(retries))
+	var handlers []pendingHandler
+	var due []HandlerRetry
+	for _, retry := range retries {
+		if applied[retry.File] != retry.Hash || retry.Config == nil {
+			continue // The file changed or was removed since
+		}
+		byFile[retry.File] = retry
+		if time.Now().Before(retry.Next) {
+			continue
+		}
+		h := a.newPendingHandler(retry.File, retry.Config)
+		if a.store != nil {
+			if h.content, err = a.secretStore().Read(retry.File); err != nil {
+				logger.Printf("Unable to read %s to retry its handler: %s", retry.File, err)
+				continue
+			}
+		}
+		LogEventWith(EventHandlerRetry, LogFields{"file": retry.File},
+			"Retrying on-change command for %s, attempt %d of %d", retry.File, retry.Attempts+1, a.settings.HandlerRetries+1)
+		handlers = append(handlers, h)
+		due = append(due, retry)
+	}
+	for i, result := range a.runHandlers(ctx, handlers) {
+		if result != nil {
+			a.publish(ChangeEvent{Type: ChangeHandlerRun, File: result.File, Handler: result})
+		}
+		retry := due[i]
+		if !handlerFailed(result) {
+			delete(byFile, retry.File)
+			continue
+		}
+		if retry.Attempts >= a.settings.HandlerRetries {
+			LogEventWith(EventHandlerGaveUp, LogFields{"file": retry.File},
+				"ERROR: Giving up on the on-change command for %s after %d attempts", retry.File, retry.Attempts+1)
+			delete(byFile, retry.File)
+			continue
+		}
+		retry.Attempts++
+		retry.Next = retryAfter(retry.Attempts)
+		retry.Error = result.Error
+		byFile[retry.File] = retry
+	}
+	if len(byFile) != len(retries) || len(due) > 0 {
+		a.saveRetries(byFile)
+	}
+}
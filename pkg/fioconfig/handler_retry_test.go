@@ -0,0 +1,90 @@
+package fioconfig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerRetries(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		app.settings.HandlerRetries = 2
+
+		// Fails until the service is "installed"
+		installed := filepath.Join(tempdir, "installed")
+		ran := filepath.Join(tempdir, "ran")
+		onChanged := []string{"/bin/sh", "-c", "test -f " + installed + " && cat $CONFIG_FILE > " + ran}
+		makeDue := func() {
+			retries, err := app.HandlerRetries()
+			require.Nil(t, err)
+			for i := range retries {
+				retries[i].Next = time.Now().Add(-time.Second)
+			}
+			require.Nil(t, app.saveHandlerRetries(retries))
+		}
+
+		v1 := ConfigStruct{"svc.conf": {Value: "v1", OnChanged: onChanged}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, v1})
+		require.Nil(t, err)
+		retries, err := app.HandlerRetries()
+		require.Nil(t, err)
+		require.Len(t, retries, 1)
+		require.Equal(t, "svc.conf", retries[0].File)
+		require.Equal(t, 1, retries[0].Attempts)
+		require.Empty(t, retries[0].Config.Value)
+		require.Equal(t, onChanged, retries[0].Config.OnChanged)
+		require.True(t, retries[0].Next.After(time.Now()))
+
+		// Not due yet
+		require.Nil(t, os.WriteFile(installed, nil, 0o644))
+		app.retryHandlers(context.Background())
+		assertNoFile(t, ran)
+
+		makeDue()
+		app.retryHandlers(context.Background())
+		assertFile(t, ran, []byte("v1"))
+		retries, err = app.HandlerRetries()
+		require.Nil(t, err)
+		require.Empty(t, retries)
+		assertNoFile(t, app.handlerRetriesFile())
+
+		// Gives up after handler_retries more attempts
+		require.Nil(t, os.Remove(installed))
+		require.Nil(t, os.Remove(ran))
+		v2 := ConfigStruct{"svc.conf": {Value: "v2", OnChanged: onChanged}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{v1, v2})
+		require.Nil(t, err)
+		makeDue()
+		app.retryHandlers(context.Background())
+		retries, err = app.HandlerRetries()
+		require.Nil(t, err)
+		require.Equal(t, 2, retries[0].Attempts)
+		makeDue()
+		app.retryHandlers(context.Background())
+		retries, err = app.HandlerRetries()
+		require.Nil(t, err)
+		require.Empty(t, retries)
+
+		// A newer version of the file replaces the retry
+		v3 := ConfigStruct{"svc.conf": {Value: "v3", OnChanged: onChanged}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{v2, v3})
+		require.Nil(t, err)
+		v4 := ConfigStruct{"svc.conf": {Value: "v4"}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{v3, v4})
+		require.Nil(t, err)
+		makeDue()
+		app.retryHandlers(context.Background())
+		assertNoFile(t, ran)
+		retries, err = app.HandlerRetries()
+		require.Nil(t, err)
+		require.Empty(t, retries)
+	})
+}
@@ -0,0 +1,506 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The PATH handlers get unless inherit_handler_env is set
+const handlerPath = "/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Variables passed through to handlers from fioconfig's own environment
+var handlerEnvAllowed = []string{"LANG", "LC_ALL", "TZ"}
+
+// Variables that can change how a handler's binary gets loaded. These are
+// dropped even when inherit_handler_env is set.
+var handlerEnvDenied = []string{"LD_PRELOAD", "LD_LIBRARY_PATH", "LD_AUDIT", "BASH_ENV", "ENV", "IFS"}
+
+// How long on-changed commands may run unless handler_timeout is set
+const defaultHandlerTimeout = 10 * time.Minute
+
+var errHandlerTimeout = errors.New("Timed out")
+
+// How much of a handler's output to keep for the status report
+const handlerOutputLimit = 4096
+
+// runCaptured runs cmd like runWithTimeout, but with its stdout and stderr
+// going to a temporary file. This way a handler that leaves a daemon running
+// can't block us by holding a pipe open. The output is then passed on to our
+// own stdout and its end, which is usually where the error is, returned.
+func runCaptured(ctx context.Context, cmd *exec.Cmd, timeout time.Duration) (string, error) {
+	f, err := os.CreateTemp("", "fioconfig-handler-")
+	if err != nil {
+		return "", fmt.Errorf("Unable to capture handler output: %w", err)
+	}
+	os.Remove(f.Name())
+	defer f.Close()
+	cmd.Stdout = f
+	cmd.Stderr = f
+	err = runWithTimeout(ctx, cmd, timeout)
+
+	size, serr := f.Seek(0, io.SeekCurrent)
+	if serr != nil {
+		return "", err
+	}
+	if _, serr := f.Seek(0, io.SeekStart); serr == nil {
+		_, _ = io.Copy(os.Stdout, f)
+	}
+	offset := size - handlerOutputLimit
+	if offset < 0 {
+		offset = 0
+	}
+	output := make([]byte, size-offset)
+	if _, serr := f.ReadAt(output, offset); serr != nil && serr != io.EOF {
+		return "", err
+	}
+	return string(output), err
+}
+
+// Where the previous versions of changed files are kept while their
+// handlers run. It's inside the secrets directory so the copies stay
+// readable from the systemd sandbox.
+const prevDirName = ".fioconfig-prev"
+
+// pendingHandler is an on-changed command waiting to be run once a config
+// has been written out.
+type pendingHandler struct {
+	fname    string
+	fullpath string
+	cfgFile  *ConfigFile
+	// Other files whose identical handler this one runs for
+	also []string
+	// The file's content before this extraction and a unified diff of the
+	// change, if it existed
+	prevFile string
+	diffFile string
+	// What's sent to the handler's stdin when the file is in a SecretStore
+	// rather than at fullpath
+	content []byte
+}
+
+func (a *App) newPendingHandler(fname string, cfgFile *ConfigFile) pendingHandler {
+	if a.store != nil {
+		content, _ := cfgFile.content() // stage already checked it decodes
+		return pendingHandler{fname: fname, cfgFile: cfgFile, content: content}
+	}
+	fullpath := filepath.Join(a.SecretsDir, fname)
+	return pendingHandler{fname: fname, fullpath: fullpath, cfgFile: cfgFile}
+}
+
+// keepPrevious gives a handler the version of its file that was just
+// replaced, and a diff against the new one, so it can decide things like
+// only restarting a service when its port changed.
+func (a *App) keepPrevious(txn *extractTxn, h *pendingHandler, idx int) error {
+	dir := filepath.Join(a.SecretsDir, prevDirName, strconv.Itoa(idx))
+	if err := os.MkdirAll(dir, txn.dirMode); err != nil {
+		return err
+	}
+	prevFile := filepath.Join(dir, filepath.Base(h.fname))
+	kept, err := txn.keepBackup(h.fname, prevFile)
+	if err != nil || !kept {
+		return err
+	}
+	prev, err := readLinkOrFile(prevFile)
+	if err != nil {
+		return err
+	}
+	next, err := h.cfgFile.content()
+	if err != nil {
+		return err
+	}
+	meta, err := h.cfgFile.fileMeta()
+	if err != nil {
+		return err
+	}
+	diffFile := prevFile + ".diff"
+	diff := unifiedDiff(h.fname, string(prev), string(next))
+	zeroize(prev)
+	zeroize(next)
+	if err := safeWriteMeta(diffFile, []byte(diff), meta); err != nil {
+		return err
+	}
+	h.prevFile, h.diffFile = prevFile, diffFile
+	return nil
+}
+
+// dedupeHandlers merges handlers with the same command line, user, and
+// systemd actions into the first one queued, so a service is only restarted
+// once per check-in no matter how many of its files changed.
+func dedupeHandlers(handlers []pendingHandler) []pendingHandler {
+	var deduped []pendingHandler
+	seen := make(map[string]int)
+	for _, h := range handlers {
+		c := h.cfgFile
+		if !c.hasActions() {
+			continue
+		}
+		key := strings.Join([]string{
+			strings.Join(c.OnChanged, "\x00"),
+			c.RunAs,
+			strings.Join(c.Reload, "\x00"),
+			strings.Join(c.Restart, "\x00"),
+			strings.Join(c.ComposeRestart, "\x00"),
+		}, "\x01")
+		if i, ok := seen[key]; ok {
+			deduped[i].also = append(deduped[i].also, h.fname)
+			continue
+		}
+		seen[key] = len(deduped)
+		deduped = append(deduped, h)
+	}
+	return deduped
+}
+
+// isInitialExtract returns true when none of a config's files exist in the
+// secrets directory yet. This is the case the first time a device gets its
+// config, and at every boot when the secrets directory is a tmpfs.
+func (a *App) isInitialExtract(config ConfigStruct) bool {
+	for fname := range config {
+		if a.store != nil {
+			if _, err := a.store.Read(fname); err == nil {
+				return false
+			}
+		} else if _, err := os.Lstat(filepath.Join(a.SecretsDir, fname)); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// runAfterExtract runs the after_extract_command once all of an
+// extraction's handlers are done.
+func (a *App) runAfterExtract(ctx context.Context, report *ExtractReport) *HandlerResult {
+	command := a.settings.AfterExtractCommand
+	if len(command) == 0 {
+		return nil
+	}
+	return a.runAggregate(ctx, "after-extract command", command, report.Applied, report.Removed, nil)
+}
+
+// runAggregate runs a command once for a set of changed and removed files,
+// which it gets in $CHANGED_FILES and $REMOVED_FILES
+func (a *App) runAggregate(ctx context.Context, what string, command, changed, removed, extraEnv []string) *HandlerResult {
+	result := &HandlerResult{Command: command}
+	timeout, err := a.handlerTimeout(&ConfigFile{})
+	if err != nil {
+		result.Error = err.Error()
+		result.ExitCode = -1
+		return result
+	}
+	LogEvent(EventHandlerRun, "Running %s: %v", what, command)
+	env := append(a.handlerEnv(), "SECRETS_DIR="+a.SecretsDir)
+	env = append(env, "CHANGED_FILES="+strings.Join(changed, "\n"))
+	env = append(env, "REMOVED_FILES="+strings.Join(removed, "\n"))
+	env = append(env, extraEnv...)
+	if a.settings.HandlerSandbox == SandboxHelper {
+		result.Output, err = a.runViaHelper(ctx, command, "", env, nil, timeout)
+	} else {
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Env = env
+		result.Output, err = runCaptured(ctx, cmd, timeout)
+	}
+	if err != nil {
+		LogEvent(EventHandlerFailed, "Unable to run %s: %v", what, err)
+		result.Error = err.Error()
+		result.ExitCode = -1
+		result.TimedOut = errors.Is(err, errHandlerTimeout)
+		if code, ok := commandExitCode(err); ok {
+			result.ExitCode = code
+		}
+	}
+	return result
+}
+
+// runHandlers runs the on-changed commands of changed files. Handlers in the
+// same concurrency group run one after another in the order they were
+// queued, while different groups run in parallel. Files without a group all
+// share the default one, so handlers run serially unless configured
+// otherwise, or handler_workers allows more. A handler still waits for the
+// handlers of the files it's ordered after (see applyOrder).
+func (a *App) runHandlers(ctx context.Context, handlers []pendingHandler) []*HandlerResult {
+	var order []string
+	groups := make(map[string][]int)
+	for i, h := range handlers {
+		group := h.cfgFile.HandlerGroup
+		if _, ok := groups[group]; !ok {
+			order = append(order, group)
+		}
+		groups[group] = append(groups[group], i)
+	}
+
+	done := make([]chan struct{}, len(handlers))
+	for i := range handlers {
+		done[i] = make(chan struct{})
+	}
+	results := make([]*HandlerResult, len(handlers))
+	var wg sync.WaitGroup
+	for _, group := range order {
+		// Workers take handlers in the order they were queued, so the
+		// ones waited on are always running or finished
+		queue := make(chan int, len(groups[group]))
+		for _, idx := range groups[group] {
+			queue <- idx
+		}
+		close(queue)
+		workers := 1
+		if len(group) == 0 && a.settings.HandlerWorkers > 1 {
+			workers = a.settings.HandlerWorkers
+		}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for idx := range queue {
+					for _, dep := range handlerDeps(handlers, idx) {
+						<-done[dep]
+					}
+					h := handlers[idx]
+					result := a.runOnChanged(ctx, h)
+					if result == nil && len(h.cfgFile.unitActions())+len(h.cfgFile.ComposeRestart) > 0 {
+						result = &HandlerResult{File: h.fname}
+						if len(h.also) > 0 {
+							result.Files = append([]string{h.fname}, h.also...)
+						}
+					}
+					if len(h.cfgFile.unitActions()) > 0 {
+						result.Units = a.runUnitActions(ctx, h.fname, h.cfgFile)
+					}
+					if len(h.cfgFile.ComposeRestart) > 0 {
+						result.Containers = a.runComposeRestarts(ctx, h.fname, h.cfgFile)
+					}
+					results[idx] = result
+					close(done[idx])
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return results
+}
+
+// handlerDeps returns the handlers queued before handlers[idx] that it must
+// wait for. Only earlier ones are considered since they're queued in apply
+// order, which also means waiting on them can't deadlock.
+func handlerDeps(handlers []pendingHandler, idx int) []int {
+	names := func(h pendingHandler) []string {
+		return append([]string{h.fname}, h.also...)
+	}
+	contains := func(list []string, names []string) bool {
+		for _, item := range list {
+			for _, name := range names {
+				if item == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	var deps []int
+	cur := handlers[idx]
+	for i := 0; i < idx; i++ {
+		if contains(cur.cfgFile.After, names(handlers[i])) || contains(handlers[i].cfgFile.Before, names(cur)) {
+			deps = append(deps, i)
+		}
+	}
+	return deps
+}
+
+// handlerAllowed reports whether an on-changed command may run. Only the
+// handlers shipped with fioconfig are allowed unless unsafe handlers are
+// enabled.
+func (a *App) handlerAllowed(onChanged []string) bool {
+	binary := filepath.Clean(onChanged[0])
+	return a.unsafeHandlers || strings.HasPrefix(binary, "/usr/share/fioconfig/handlers/")
+}
+
+// handlerTimeout returns how long a file's on-changed command may run. A
+// timeout of 0 means it can run forever.
+func (a *App) handlerTimeout(cfgFile *ConfigFile) (time.Duration, error) {
+	timeout := a.settings.HandlerTimeout
+	if len(cfgFile.HandlerTimeout) > 0 {
+		timeout = cfgFile.HandlerTimeout
+	}
+	if len(timeout) == 0 {
+		return defaultHandlerTimeout, nil
+	}
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid handler timeout: %w", err)
+	}
+	return duration, nil
+}
+
+// handlerCredential resolves a run-as value of "user" or "user:group", by
+// name or number, to the credentials a handler runs with. The user's
+// supplementary groups are kept.
+func handlerCredential(runAs string) (*syscall.Credential, error) {
+	parts := strings.SplitN(runAs, ":", 2)
+	u, err := user.Lookup(parts[0])
+	if err != nil {
+		if u, err = user.LookupId(parts[0]); err != nil {
+			return nil, fmt.Errorf("Unknown run-as user %s", parts[0])
+		}
+	}
+	gid := u.Gid
+	if len(parts) == 2 {
+		g, err := user.LookupGroup(parts[1])
+		if err != nil {
+			if g, err = user.LookupGroupId(parts[1]); err != nil {
+				return nil, fmt.Errorf("Unknown run-as group %s", parts[1])
+			}
+		}
+		gid = g.Gid
+	}
+
+	cred := &syscall.Credential{}
+	if cred.Uid, err = parseId(u.Uid); err != nil {
+		return nil, err
+	}
+	if cred.Gid, err = parseId(gid); err != nil {
+		return nil, err
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to look up groups of %s: %w", u.Username, err)
+	}
+	for _, group := range groups {
+		id, err := parseId(group)
+		if err != nil {
+			return nil, err
+		}
+		cred.Groups = append(cred.Groups, id)
+	}
+	return cred, nil
+}
+
+func parseId(id string) (uint32, error) {
+	val, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid user or group ID %s: %w", id, err)
+	}
+	return uint32(val), nil
+}
+
+// runWithTimeout runs cmd in its own process group so that it, and anything
+// it started, can be killed if it runs longer than the timeout or the
+// context is done.
+func runWithTimeout(ctx context.Context, cmd *exec.Cmd, timeout time.Duration) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return fmt.Errorf("%w after %s", errHandlerTimeout, timeout)
+	case <-ctx.Done():
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return ctx.Err()
+	}
+}
+
+// handlerEnv returns the environment on-changed handlers run with, minus the
+// variables set for each file. By default this is a minimal, controlled
+// environment rather than whatever fioconfig was started with.
+func (a *App) handlerEnv() []string {
+	if a.settings.InheritHandlerEnv {
+		var env []string
+		for _, kv := range os.Environ() {
+			name := strings.SplitN(kv, "=", 2)[0]
+			denied := false
+			for _, d := range handlerEnvDenied {
+				if name == d {
+					denied = true
+					break
+				}
+			}
+			if !denied {
+				env = append(env, kv)
+			}
+		}
+		return append(env, a.change.env()...)
+	}
+	env := []string{"PATH=" + handlerPath}
+	for _, name := range handlerEnvAllowed {
+		if val, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+val)
+		}
+	}
+	return append(env, a.change.env()...)
+}
+
+// canonicalConfigFile makes sure the CONFIG_FILE a handler is given is an
+// absolute path that really lives under the secrets directory. Symlinks in
+// its parent directories are resolved first so a linked directory can't
+// point a handler outside of it.
+func (a *App) canonicalConfigFile(fullpath string) (string, error) {
+	secretsDir, err := resolveParents(a.SecretsDir)
+	if err != nil {
+		return "", err
+	}
+	configFile, err := resolveParents(fullpath)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(configFile, secretsDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("Config file %s is outside of %s", configFile, secretsDir)
+	}
+	return configFile, nil
+}
+
+// resolveParents returns the absolute form of path with symlinks resolved in
+// the deepest parent directory that exists. The last element is left alone
+// since a config file may itself be a symlink, and the parents of a removed
+// file may be gone.
+func resolveParents(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir, rest := filepath.Dir(path), filepath.Base(path)
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return path, nil
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
@@ -0,0 +1,395 @@
+package fioconfig
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerGroups(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		// Fails if another handler in the group is running at the same time
+		lock := filepath.Join(tempdir, "lock")
+		serial := []string{"/bin/sh", "-c", "mkdir " + lock + " && sleep 0.3 && rmdir " + lock}
+		config := ConfigStruct{
+			"a1": &ConfigFile{Value: "a1", OnChanged: serial, HandlerGroup: "a"},
+			"a2": &ConfigFile{Value: "a2", OnChanged: serial, HandlerGroup: "a"},
+			"b":  &ConfigFile{Value: "b", OnChanged: []string{"/bin/sleep", "0.3"}, HandlerGroup: "b"},
+			"c":  &ConfigFile{Value: "c", OnChanged: []string{"/bin/sleep", "0.3"}, HandlerGroup: "c"},
+		}
+		start := time.Now()
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		elapsed := time.Since(start)
+
+		require.Len(t, report.Handlers, 4)
+		for _, h := range report.Handlers {
+			require.Equal(t, 0, h.ExitCode, h.File)
+		}
+		// Group a takes ~0.6s and b and c run alongside it
+		require.Less(t, elapsed, 900*time.Millisecond)
+		require.GreaterOrEqual(t, elapsed, 600*time.Millisecond)
+	})
+}
+
+func TestHandlerEnv(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		t.Setenv("LD_PRELOAD", "/tmp/evil.so")
+		t.Setenv("PATH", "/tmp/evil:/usr/bin")
+		t.Setenv("TZ", "UTC")
+
+		env := app.handlerEnv()
+		require.Contains(t, env, "PATH="+handlerPath)
+		require.Contains(t, env, "TZ=UTC")
+		require.NotContains(t, env, "LD_PRELOAD=/tmp/evil.so")
+
+		app.settings.InheritHandlerEnv = true
+		env = app.handlerEnv()
+		require.Contains(t, env, "PATH=/tmp/evil:/usr/bin")
+		require.NotContains(t, env, "LD_PRELOAD=/tmp/evil.so")
+
+		// Handlers see the minimal environment
+		app.settings.InheritHandlerEnv = false
+		out := filepath.Join(tempdir, "env")
+		config := ConfigStruct{
+			"foo": &ConfigFile{Value: "changed", OnChanged: []string{"/bin/sh", "-c", "env > " + out}},
+		}
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		buf, err := os.ReadFile(out)
+		require.Nil(t, err)
+		require.NotContains(t, string(buf), "LD_PRELOAD")
+		require.Contains(t, string(buf), "CONFIG_FILE="+filepath.Join(tempdir, "foo"))
+	})
+}
+
+func TestCanonicalConfigFile(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		path, err := app.canonicalConfigFile(filepath.Join(tempdir, "sub/../foo"))
+		require.Nil(t, err)
+		require.Equal(t, filepath.Join(tempdir, "foo"), path)
+
+		_, err = app.canonicalConfigFile(filepath.Join(tempdir, "../foo"))
+		require.NotNil(t, err)
+
+		// A config file may be a symlink, but not through a linked directory
+		outside := t.TempDir()
+		require.Nil(t, os.Symlink(outside, filepath.Join(tempdir, "link")))
+		_, err = app.canonicalConfigFile(filepath.Join(tempdir, "link/foo"))
+		require.NotNil(t, err)
+		require.Nil(t, os.Symlink(outside, filepath.Join(tempdir, "foo")))
+		path, err = app.canonicalConfigFile(filepath.Join(tempdir, "foo"))
+		require.Nil(t, err)
+		require.Equal(t, filepath.Join(tempdir, "foo"), path)
+
+		// Files whose directories were removed still resolve
+		path, err = app.canonicalConfigFile(filepath.Join(tempdir, "gone/dir/foo"))
+		require.Nil(t, err)
+		require.Equal(t, filepath.Join(tempdir, "gone/dir/foo"), path)
+	})
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		// The child of the handler gets killed too
+		marker := filepath.Join(tempdir, "marker")
+		app.settings.HandlerTimeout = "200ms"
+		config := ConfigStruct{
+			"slow":   &ConfigFile{Value: "slow", OnChanged: []string{"/bin/sh", "-c", "(sleep 0.5; touch " + marker + ") & wait"}},
+			"longer": &ConfigFile{Value: "longer", OnChanged: []string{"/bin/sleep", "0.3"}, HandlerTimeout: "2s"},
+		}
+		start := time.Now()
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Less(t, time.Since(start), time.Second)
+
+		require.Len(t, report.Handlers, 2)
+		require.Equal(t, "longer", report.Handlers[0].File)
+		require.False(t, report.Handlers[0].TimedOut)
+		require.Equal(t, 0, report.Handlers[0].ExitCode)
+		require.Equal(t, "slow", report.Handlers[1].File)
+		require.True(t, report.Handlers[1].TimedOut)
+		require.Equal(t, -1, report.Handlers[1].ExitCode)
+
+		time.Sleep(500 * time.Millisecond)
+		assertNoFile(t, marker)
+	})
+}
+
+func TestHandlerCredential(t *testing.T) {
+	cred, err := handlerCredential("root")
+	require.Nil(t, err)
+	require.Equal(t, uint32(0), cred.Uid)
+	require.Equal(t, uint32(0), cred.Gid)
+	require.Contains(t, cred.Groups, uint32(0))
+
+	cred, err = handlerCredential("0:0")
+	require.Nil(t, err)
+	require.Equal(t, uint32(0), cred.Uid)
+
+	_, err = handlerCredential("no-such-user-here")
+	require.NotNil(t, err)
+	_, err = handlerCredential("root:no-such-group-here")
+	require.NotNil(t, err)
+}
+
+func TestHandlerRunAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Must be root to change the user handlers run as")
+	}
+	nobody, err := handlerCredential("nobody")
+	if err != nil {
+		t.Skip("No nobody user on this system")
+	}
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		check := fmt.Sprintf("test $(id -u) = %d", nobody.Uid)
+		config := ConfigStruct{
+			"dropped": &ConfigFile{Value: "a", OnChanged: []string{"/bin/sh", "-c", check}, RunAs: "nobody"},
+			"root":    &ConfigFile{Value: "b", OnChanged: []string{"/bin/sh", "-c", check}},
+			"unknown": &ConfigFile{Value: "c", OnChanged: []string{"/bin/true"}, RunAs: "no-such-user-here"},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Len(t, report.Handlers, 3)
+		require.Equal(t, 0, report.Handlers[0].ExitCode)
+		require.Equal(t, 1, report.Handlers[1].ExitCode)
+		require.Equal(t, -1, report.Handlers[2].ExitCode)
+		require.Contains(t, report.Handlers[2].Error, "Unknown run-as user")
+	})
+}
+
+func TestHandlerDedupe(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		count := filepath.Join(tempdir, "count")
+		restart := []string{"/bin/sh", "-c", "echo \"$CHANGED_FILES\" >> " + count}
+		after := filepath.Join(tempdir, "after")
+		app.settings.DedupeHandlers = true
+		app.settings.AfterExtractCommand = []string{"/bin/sh", "-c", "echo \"$CHANGED_FILES\" > " + after}
+		config := ConfigStruct{
+			"svc/a": &ConfigFile{Value: "a", OnChanged: restart},
+			"svc/b": &ConfigFile{Value: "b", OnChanged: restart},
+			"svc/c": &ConfigFile{Value: "c", OnChanged: restart},
+			"other": &ConfigFile{Value: "d", OnChanged: []string{"/bin/true"}},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Len(t, report.Handlers, 2)
+		require.Equal(t, "other", report.Handlers[0].File)
+		require.Equal(t, []string{"svc/a", "svc/b", "svc/c"}, report.Handlers[1].Files)
+		assertFile(t, count, []byte("svc/a\nsvc/b\nsvc/c\n"))
+
+		require.NotNil(t, report.AfterExtract)
+		require.Equal(t, 0, report.AfterExtract.ExitCode)
+		assertFile(t, after, []byte("other\nsvc/a\nsvc/b\nsvc/c\n"))
+
+		// Nothing changed, so nothing runs
+		require.Nil(t, os.Remove(after))
+		report, err = app.extract(context.Background(), crypto, configSnapshot{config, config})
+		require.Nil(t, err)
+		require.Nil(t, report.AfterExtract)
+		assertNoFile(t, after)
+	})
+}
+
+func TestHandlerOrderAcrossGroups(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		marker := filepath.Join(tempdir, "ca-updated")
+		config := ConfigStruct{
+			"ca":      &ConfigFile{Value: "ca", OnChanged: []string{"/bin/sh", "-c", "sleep 0.2 && touch " + marker}, HandlerGroup: "ca"},
+			"service": &ConfigFile{Value: "svc", OnChanged: []string{"/bin/test", "-f", marker}, HandlerGroup: "svc", After: []string{"ca"}},
+			"zlast":   &ConfigFile{Value: "z", OnChanged: []string{"/bin/test", "-f", marker}, HandlerGroup: "z", Before: []string{"service"}},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Len(t, report.Handlers, 3)
+		for _, h := range report.Handlers {
+			switch h.File {
+			case "service":
+				require.Equal(t, 0, h.ExitCode)
+			case "zlast":
+				// Nothing makes it wait for the CA handler
+				require.Equal(t, 1, h.ExitCode)
+			}
+		}
+	})
+}
+
+func TestHandlerWorkers(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		sleep := []string{"/bin/sleep", "0.3"}
+		config := ConfigStruct{
+			"a": &ConfigFile{Value: "a", OnChanged: sleep},
+			"b": &ConfigFile{Value: "b", OnChanged: sleep},
+			"c": &ConfigFile{Value: "c", OnChanged: sleep},
+			"d": &ConfigFile{Value: "d", OnChanged: sleep},
+		}
+		app.settings.HandlerWorkers = 2
+		start := time.Now()
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		elapsed := time.Since(start)
+
+		require.Len(t, report.Handlers, 4)
+		// Results are in the order the handlers were queued
+		for i, h := range report.Handlers {
+			require.Equal(t, string(rune('a'+i)), h.File)
+			require.Equal(t, 0, h.ExitCode)
+		}
+		require.Less(t, elapsed, 900*time.Millisecond)
+		require.GreaterOrEqual(t, elapsed, 600*time.Millisecond)
+	})
+}
+
+func TestHandlerOutput(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		long := fmt.Sprintf("head -c %d /dev/zero | tr '\\0' x; echo end", 2*handlerOutputLimit)
+		config := ConfigStruct{
+			"fails": &ConfigFile{Value: "fails", OnChanged: []string{"/bin/sh", "-c", "echo out; echo err >&2; exit 3"}},
+			"long":  &ConfigFile{Value: "long", OnChanged: []string{"/bin/sh", "-c", long}},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+
+		require.Len(t, report.Handlers, 2)
+		require.Equal(t, "fails", report.Handlers[0].File)
+		require.Equal(t, 3, report.Handlers[0].ExitCode)
+		require.Equal(t, "out\nerr\n", report.Handlers[0].Output)
+		require.Equal(t, 0, report.Handlers[1].ExitCode)
+		require.Len(t, report.Handlers[1].Output, handlerOutputLimit)
+		require.True(t, strings.HasSuffix(report.Handlers[1].Output, "xend\n"))
+		require.Equal(t, []string{"fails: on-change command exited with 3"}, report.Warnings)
+
+		last, err := app.LastReport()
+		require.Nil(t, err)
+		require.Equal(t, report.Warnings, last.Warnings)
+		require.Equal(t, "out\nerr\n", last.Handlers[0].Output)
+	})
+}
+
+func TestHandlerPrevious(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		prev := filepath.Join(tempdir, "prev")
+		diff := filepath.Join(tempdir, "diff")
+		onChanged := []string{"/bin/sh", "-c", `if [ -n "$CONFIG_FILE_PREV" ]; then cat $CONFIG_FILE_PREV > ` + prev + `; cat $CONFIG_FILE_DIFF > ` + diff + `; fi`}
+		config := ConfigStruct{
+			"dir/app.conf": &ConfigFile{Value: "port=80\n", OnChanged: onChanged},
+		}
+		// A new file has no previous version
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertNoFile(t, prev)
+
+		config = ConfigStruct{
+			"dir/app.conf": &ConfigFile{Value: "port=8080\n", OnChanged: onChanged},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertFile(t, prev, []byte("port=80\n"))
+		content, err := os.ReadFile(diff)
+		require.Nil(t, err)
+		require.Contains(t, string(content), "-port=80\n+port=8080\n")
+		assertNoFile(t, filepath.Join(app.SecretsDir, prevDirName))
+	})
+}
+
+func TestHandlerSkipInitial(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		touch := func(name string) []string {
+			return []string{"/usr/bin/touch", filepath.Join(tempdir, name)}
+		}
+		config := func(value string) ConfigStruct {
+			return ConfigStruct{
+				"a": &ConfigFile{Value: value, OnChanged: touch("a-changed"), SkipInitialHandler: true},
+				"b": &ConfigFile{Value: value, OnChanged: touch("b-changed")},
+			}
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config("1")})
+		require.Nil(t, err)
+		require.True(t, report.Initial)
+		assertNoFile(t, filepath.Join(tempdir, "a-changed"))
+		assertFile(t, filepath.Join(tempdir, "b-changed"), nil)
+
+		// Later changes run the handler
+		report, err = app.extract(context.Background(), crypto, configSnapshot{nil, config("2")})
+		require.Nil(t, err)
+		require.False(t, report.Initial)
+		assertFile(t, filepath.Join(tempdir, "a-changed"), nil)
+
+		// Like a reboot with a tmpfs secrets dir
+		require.Nil(t, os.Remove(filepath.Join(app.SecretsDir, "a")))
+		require.Nil(t, os.Remove(filepath.Join(app.SecretsDir, "b")))
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "b-changed")))
+		app.settings.SkipInitialHandlers = true
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config("2")})
+		require.Nil(t, err)
+		assertNoFile(t, filepath.Join(tempdir, "b-changed"))
+		assertFile(t, filepath.Join(app.SecretsDir, "b"), []byte("2"))
+	})
+}
+
+func TestHandlerCancel(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		config := ConfigStruct{
+			"slow": &ConfigFile{Value: "slow", OnChanged: []string{"/bin/sleep", "5"}},
+		}
+		time.AfterFunc(200*time.Millisecond, cancel)
+		start := time.Now()
+		report, err := app.extract(ctx, crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Less(t, time.Since(start), 2*time.Second)
+		require.Len(t, report.Handlers, 1)
+		require.Equal(t, -1, report.Handlers[0].ExitCode)
+		require.Equal(t, context.Canceled.Error(), report.Handlers[0].Error)
+	})
+}
@@ -0,0 +1,287 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The daemon can run as an unprivileged user and leave on-changed commands
+// to `fioconfig handler-helper`, a small privileged process it reaches over
+// a unix socket. The helper only accepts connections from root and the
+// daemon's user and still refuses unsafe handlers.
+
+// DefaultHandlerHelperSocket is where the helper listens unless
+// handler_helper_socket says otherwise
+const DefaultHandlerHelperSocket = "/run/fioconfig/handler.sock"
+
+// helperRequest is a command for the helper to run. Requests and responses
+// are one JSON object per connection.
+type helperRequest struct {
+	Command []string      `json:"command"`
+	Env     []string      `json:"env"`
+	RunAs   string        `json:"run-as,omitempty"`
+	Timeout time.Duration `json:"timeout"`
+	Stdin   []byte        `json:"stdin,omitempty"`
+}
+
+// Variables the daemon sets for each file that the helper passes on to the
+// command. See helperEnv
+var helperEnvFromDaemon = []string{
+	"CONFIG_FILE", "CONFIG_NAME", "CHANGED_FILES", "CONFIG_FILE_PREV", "CONFIG_FILE_DIFF",
+	"CONFIG_VERSION", "CONFIG_AUTHOR", "CONFIG_REASON",
+}
+
+type helperResponse struct {
+	ExitCode int    `json:"exit-code"`
+	Output   string `json:"output"`
+	TimedOut bool   `json:"timed-out,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// helperExitError is how a command the helper ran exited non-zero. Like an
+// exec.ExitError, it has an ExitCode.
+type helperExitError struct {
+	code int
+}
+
+func (e *helperExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *helperExitError) ExitCode() int {
+	return e.code
+}
+
+// commandExitCode returns the exit code of a command that ran but failed
+func commandExitCode(err error) (int, bool) {
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
+
+// HandlerHelperSocket is where the daemon reaches the handler helper
+func (a *App) HandlerHelperSocket() string {
+	if len(a.settings.HandlerHelperSocket) > 0 {
+		return a.settings.HandlerHelperSocket
+	}
+	return DefaultHandlerHelperSocket
+}
+
+// runViaHelper has the handler helper run a command and returns its output
+// the way runCaptured would
+func (a *App) runViaHelper(ctx context.Context, command []string, runAs string, env []string, stdin []byte, timeout time.Duration) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", a.HandlerHelperSocket())
+	if err != nil {
+		return "", fmt.Errorf("Unable to reach handler helper: %w", err)
+	}
+	defer conn.Close()
+	// The helper kills the command when the connection goes away
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	req := helperRequest{Command: command, Env: env, RunAs: runAs, Timeout: timeout, Stdin: stdin}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return "", fmt.Errorf("Unable to send command to handler helper: %w", err)
+	}
+	var res helperResponse
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("Unable to read handler helper response: %w", err)
+	}
+	switch {
+	case res.TimedOut:
+		return res.Output, fmt.Errorf("%w after %s", errHandlerTimeout, timeout)
+	case len(res.Error) > 0:
+		return res.Output, errors.New(res.Error)
+	case res.ExitCode != 0:
+		return res.Output, &helperExitError{res.ExitCode}
+	}
+	return res.Output, nil
+}
+
+// ServeHandlerHelper runs on-changed commands for the daemon connecting to
+// l until ctx is done. Only root and allowedUid may connect.
+func (a *App) ServeHandlerHelper(ctx context.Context, l *net.UnixListener, allowedUid uint32) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go a.serveHelperConn(ctx, conn, allowedUid)
+	}
+}
+
+func peerUid(conn *net.UnixConn) (uint32, error) {
+	cred, err := peerCred(conn)
+	if err != nil {
+		return 0, err
+	}
+	return cred.Uid, nil
+}
+
+func (a *App) serveHelperConn(ctx context.Context, conn *net.UnixConn, allowedUid uint32) {
+	defer conn.Close()
+	uid, err := peerUid(conn)
+	if err != nil {
+		logger.Printf("ERROR: Unable to check handler helper client: %s", err)
+		return
+	} else if uid != 0 && uid != allowedUid {
+		LogEvent(EventHandlerRejected, "Refusing handler helper connection from uid %d", uid)
+		return
+	}
+	var req helperRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		logger.Printf("ERROR: Invalid handler helper request: %s", err)
+		return
+	}
+
+	// Stop the command if the daemon gives up on it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		_, _ = conn.Read(make([]byte, 1))
+		cancel()
+	}()
+	res := a.runHelperRequest(ctx, req)
+	if err := json.NewEncoder(conn).Encode(res); err != nil {
+		logger.Printf("ERROR: Unable to send handler helper response: %s", err)
+	}
+}
+
+// runHelperRequest runs a command for the daemon. What it may run is
+// decided here rather than by the daemon, which is what the helper
+// protects against.
+func (a *App) runHelperRequest(ctx context.Context, req helperRequest) helperResponse {
+	if len(req.Command) == 0 {
+		return helperResponse{ExitCode: -1, Error: "No command given"}
+	}
+	if !a.handlerAllowed(req.Command) {
+		LogEvent(EventHandlerUnsafe, "Refusing unsafe command from daemon: %v", req.Command)
+		return helperResponse{ExitCode: -1, Error: fmt.Sprintf("Unsafe command refused by handler helper: %v", req.Command)}
+	}
+	env, err := a.helperEnv(req.Env)
+	if err != nil {
+		LogEvent(EventHandlerRejected, "Refusing command from daemon: %s", err)
+		return helperResponse{ExitCode: -1, Error: err.Error()}
+	}
+	cmd := exec.Command(req.Command[0], req.Command[1:]...)
+	cmd.Env = env
+	if len(req.RunAs) > 0 {
+		allowed := false
+		for _, runAs := range a.settings.HandlerRunAs {
+			allowed = allowed || req.RunAs == runAs
+		}
+		if !allowed {
+			LogEvent(EventHandlerUnsafe, "Refusing to run command from daemon as %s: %v", req.RunAs, req.Command)
+			return helperResponse{ExitCode: -1, Error: fmt.Sprintf("Run-as %s refused by handler helper", req.RunAs)}
+		}
+		cred, err := handlerCredential(req.RunAs)
+		if err != nil {
+			return helperResponse{ExitCode: -1, Error: err.Error()}
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	}
+	if req.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(req.Stdin)
+	}
+	LogEvent(EventHandlerRun, "Running command for daemon: %v", req.Command)
+	output, err := runCaptured(ctx, cmd, req.Timeout)
+	res := helperResponse{Output: output}
+	if err != nil {
+		if code, ok := commandExitCode(err); ok {
+			res.ExitCode = code
+		} else {
+			res.ExitCode = -1
+			res.TimedOut = errors.Is(err, errHandlerTimeout)
+			if !res.TimedOut {
+				res.Error = err.Error()
+			}
+		}
+	}
+	return res
+}
+
+// helperEnv builds the environment of a command the helper runs. Only the
+// variables the daemon sets for each file are taken from its request. The
+// rest, like PATH, SOTA_DIR and FIOCONFIG_BIN, come from the helper itself.
+func (a *App) helperEnv(daemonEnv []string) ([]string, error) {
+	env := a.handlerEnv()
+	for _, kv := range daemonEnv {
+		parts := strings.SplitN(kv, "=", 2)
+		allowed := false
+		for _, name := range helperEnvFromDaemon {
+			allowed = allowed || parts[0] == name
+		}
+		if !allowed || len(parts) != 2 {
+			continue
+		}
+		if parts[0] == "CONFIG_FILE" {
+			if _, err := a.canonicalConfigFile(parts[1]); err != nil {
+				return nil, err
+			}
+		}
+		env = append(env, kv)
+	}
+	sotaDir, err := tomlGet(a.sota, "storage.path")
+	if err != nil {
+		return nil, err
+	}
+	env = append(env, "SOTA_DIR="+sotaDir)
+	path, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find path to self via /proc/self/exe: %w", err)
+	}
+	return append(env, "FIOCONFIG_BIN="+path), nil
+}
+
+// ListenHandlerHelper creates the helper's socket at path, owned by uid so
+// only that user and root can connect to it
+func ListenHandlerHelper(path string, uid int) (*net.UnixListener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Unable to remove stale handler helper socket: %w", err)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chown(path, uid, -1); err == nil {
+		err = os.Chmod(path, 0o600)
+	}
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("Unable to restrict access to handler helper socket: %w", err)
+	}
+	return l, nil
+}
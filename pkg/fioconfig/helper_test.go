@@ -0,0 +1,67 @@
+package fioconfig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerHelper(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "handler.sock")
+	l, err := ListenHandlerHelper(socket, os.Getuid())
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sota, err := toml.Load("[storage]\npath = \"/var/sota\"")
+	require.Nil(t, err)
+	helper := &App{unsafeHandlers: false, SecretsDir: "/run/secrets", sota: sota}
+	go func() {
+		_ = helper.ServeHandlerHelper(ctx, l, uint32(os.Getuid()))
+	}()
+
+	daemon := &App{}
+	daemon.settings.HandlerHelperSocket = socket
+	daemon.settings.HandlerSandbox = SandboxHelper
+
+	// The helper decides what's safe, not the daemon
+	_, err = daemon.runViaHelper(ctx, []string{"/bin/sh", "-c", "echo hi"}, "", nil, nil, time.Second)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Unsafe command refused")
+
+	helper.unsafeHandlers = true
+	output, err := daemon.runViaHelper(ctx, []string{"/bin/sh", "-c", "cat; echo $CONFIG_NAME"}, "", []string{"CONFIG_NAME=bar"}, []byte("in\n"), time.Second)
+	require.Nil(t, err)
+	require.Contains(t, output, "in\nbar")
+
+	// The daemon only gets to set the per-file variables
+	env := []string{"PATH=/tmp/evil", "SOTA_DIR=/tmp/evil", "CONFIG_FILE=/run/secrets/foo"}
+	output, err = daemon.runViaHelper(ctx, []string{"/bin/sh", "-c", "echo $PATH $SOTA_DIR $CONFIG_FILE"}, "", env, nil, time.Second)
+	require.Nil(t, err)
+	require.Equal(t, handlerPath+" /var/sota /run/secrets/foo\n", output)
+	env = []string{"CONFIG_FILE=/etc/shadow"}
+	_, err = daemon.runViaHelper(ctx, []string{"/bin/true"}, "", env, nil, time.Second)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "outside of /run/secrets")
+
+	// As are the users commands run as
+	_, err = daemon.runViaHelper(ctx, []string{"/bin/true"}, "root", nil, nil, time.Second)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Run-as root refused")
+	helper.settings.HandlerRunAs = []string{"no-such-user"}
+	_, err = daemon.runViaHelper(ctx, []string{"/bin/true"}, "no-such-user", nil, nil, time.Second)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Unknown run-as user")
+
+	_, err = daemon.runViaHelper(ctx, []string{"/bin/sh", "-c", "exit 3"}, "", nil, nil, time.Second)
+	code, ok := commandExitCode(err)
+	require.True(t, ok)
+	require.Equal(t, 3, code)
+
+	_, err = daemon.runViaHelper(ctx, []string{"/bin/sh", "-c", "sleep 5"}, "", nil, nil, 100*time.Millisecond)
+	require.ErrorIs(t, err, errHandlerTimeout)
+}
@@ -0,0 +1,190 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const defaultHistorySize = 10
+
+// HistoryEntry describes a config version that was applied to the device
+type HistoryEntry struct {
+	Version      int
+	Applied      time.Time
+	Sha256       string      // Of the encrypted config
+	Files        []string    // Names of the files in the config
+	ETag         string      `json:",omitempty"`
+	LastModified string      `json:",omitempty"`
+	HasBlob      bool        // If the encrypted config was kept so it can be reverted to
+	Change       *ChangeInfo `json:",omitempty"`
+}
+
+func (a *App) historyDir() string {
+	return filepath.Join(a.stateDir(), "config-history")
+}
+
+func (a *App) historyBlob(version int) string {
+	return filepath.Join(a.historyDir(), strconv.Itoa(version)+".encrypted")
+}
+
+// History returns the config versions applied to this device, oldest first
+func (a *App) History() ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	buf, err := os.ReadFile(filepath.Join(a.historyDir(), "index.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(buf, &entries); err != nil {
+		return nil, fmt.Errorf("Unable to parse config history: %w", err)
+	}
+	return entries, nil
+}
+
+// recordHistory adds the config just applied to the history and prunes the
+// oldest entries beyond `fioconfig.history_size`.
+func (a *App) recordHistory(encrypted []byte, config ConfigStruct, state checkInState) error {
+	entries, err := a.History()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(a.historyDir(), 0o700); err != nil {
+		return err
+	}
+
+	entry := HistoryEntry{
+		Version:      1,
+		Applied:      time.Now().UTC(),
+		Sha256:       fmt.Sprintf("%x", sha256.Sum256(encrypted)),
+		ETag:         state.ETag,
+		LastModified: state.LastModified,
+		HasBlob:      !a.settings.HistoryMetadataOnly,
+		Change:       state.Change,
+	}
+	if len(entries) > 0 {
+		entry.Version = entries[len(entries)-1].Version + 1
+	}
+	for name := range config {
+		entry.Files = append(entry.Files, name)
+	}
+	sort.Strings(entry.Files)
+	if entry.HasBlob {
+		if err = a.writeCache(a.historyBlob(entry.Version), encrypted); err != nil {
+			return err
+		}
+	}
+	entries = append(entries, entry)
+
+	size := a.settings.HistorySize
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	for len(entries) > size {
+		if err := os.Remove(a.historyBlob(entries[0].Version)); err != nil && !os.IsNotExist(err) {
+			logger.Printf("Unable to remove old config version: %s", err)
+		}
+		entries = entries[1:]
+	}
+
+	buf, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err = safeWrite(filepath.Join(a.historyDir(), "index.json"), buf); err != nil {
+		return err
+	}
+	LogEventWith(EventConfigApplied, LogFields{"version": entry.Version, "sha256": entry.Sha256},
+		"Applied config version %d", entry.Version)
+	return nil
+}
+
+// PreviousVersion returns the newest version in the history, older than the
+// one currently applied, that can be reverted to.
+func (a *App) PreviousVersion() (int, error) {
+	entries, err := a.History()
+	if err != nil {
+		return 0, err
+	}
+	current := len(entries)
+	if encrypted, err := a.readCache(a.EncryptedConfig); err == nil {
+		sha := fmt.Sprintf("%x", sha256.Sum256(encrypted))
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].Sha256 == sha {
+				current = i
+				break
+			}
+		}
+	}
+	for i := current - 1; i >= 0; i-- {
+		if entries[i].HasBlob {
+			return entries[i].Version, nil
+		}
+	}
+	return 0, errors.New("No previous config version is available to revert to")
+}
+
+// Revert re-applies a config version from the history. The device stays on
+// this version until a check-in finds a different config on the server.
+func (a *App) Revert(version int) error {
+	entries, err := a.History()
+	if err != nil {
+		return err
+	}
+	var entry *HistoryEntry
+	for i := range entries {
+		if entries[i].Version == version {
+			entry = &entries[i]
+		}
+	}
+	if entry == nil {
+		return fmt.Errorf("Config version %d is not in the history", version)
+	}
+	if !entry.HasBlob {
+		return fmt.Errorf("Config version %d was not retained, only its metadata", version)
+	}
+
+	encrypted, err := a.readCache(a.historyBlob(version))
+	if err != nil {
+		return err
+	}
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return err
+	}
+	defer a.releaseCrypto(crypto)
+
+	var config configSnapshot
+	if config.next, err = UnmarshallBuffer(crypto, encrypted, true); err != nil {
+		return err
+	}
+	if config.prev, err = a.unmarshallCache(crypto, a.EncryptedConfig, false); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err = a.checkDiskSpace(config.next, encrypted); err != nil {
+		return err
+	}
+	report, err := a.extract(context.Background(), crypto, config)
+	a.audit(report, version)
+	a.publishExtract(report, err, version)
+	if err != nil {
+		return err
+	}
+	if err = a.writeCache(a.EncryptedConfig, encrypted); err != nil {
+		return err
+	}
+	// The check-in state keeps the validators of the server's config so
+	// that it isn't downloaded again until it changes.
+	state := a.loadCheckInState()
+	state.Reverted = true
+	return a.saveCheckInState(state)
+}
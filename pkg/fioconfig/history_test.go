@@ -0,0 +1,110 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	var encbuf []byte
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		os.Remove(app.EncryptedConfig)
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+
+		config := ConfigStruct{"foo": &ConfigFile{Value: "version 2"}}
+		encrypt(t, config)
+		encbuf, err = json.Marshal(config)
+		require.Nil(t, err)
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertNoFile(t, filepath.Join(tempdir, "bar"))
+
+		entries, err := app.History()
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, 1, entries[0].Version)
+		require.Equal(t, []string{"bar", "foo", "random", "with/subdir/1.txt"}, entries[0].Files)
+		require.Equal(t, 2, entries[1].Version)
+		require.Equal(t, []string{"foo"}, entries[1].Files)
+
+		require.Nil(t, app.Revert(1))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("bar file value"))
+		require.NotNil(t, app.Revert(3))
+
+		// Old versions get pruned
+		app.settings.HistorySize = 2
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		entries, err = app.History()
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, 2, entries[0].Version)
+		assertNoFile(t, app.historyBlob(1))
+	})
+}
+
+func TestRollback(t *testing.T) {
+	var encbuf []byte
+	etag := `"v1"`
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		os.Remove(app.EncryptedConfig)
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+
+		_, err = app.PreviousVersion()
+		require.NotNil(t, err)
+
+		config := ConfigStruct{"foo": &ConfigFile{Value: "bad version"}}
+		encrypt(t, config)
+		encbuf, err = json.Marshal(config)
+		require.Nil(t, err)
+		etag = `"v2"`
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+
+		version, err := app.PreviousVersion()
+		require.Nil(t, err)
+		require.Equal(t, 1, version)
+		require.Nil(t, app.Revert(version))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+		require.True(t, app.loadCheckInState().Reverted)
+
+		// The server's config hasn't changed, so the rollback sticks
+		require.Equal(t, NotModifiedError, app.checkin(context.Background(), client, crypto))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+
+		// Once it changes it's applied again
+		etag = `"v3"`
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("bad version"))
+		require.False(t, app.loadCheckInState().Reverted)
+	})
+}
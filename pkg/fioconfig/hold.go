@@ -0,0 +1,143 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A hold keeps the device on the config it has, e.g. during on-site
+// maintenance where a config change mid-procedure would be dangerous.
+// Check-ins carry on so the server still sees the device and hears about
+// the changes that are waiting, but nothing is applied until the hold is
+// released. It's kept in a file so it survives reboots.
+
+// ConfigHeldError is returned by a check-in that found a new config while
+// the device is on hold. It matches NotModifiedError since nothing was
+// applied.
+var ConfigHeldError error = configHeldError{}
+
+type configHeldError struct{}
+
+func (configHeldError) Error() string {
+	return "New config not applied, the device is on hold"
+}
+
+func (configHeldError) Is(target error) bool {
+	return target == NotModifiedError
+}
+
+// ConfigHold is a hold placed with Hold
+type ConfigHold struct {
+	Since  time.Time
+	Reason string `json:",omitempty"`
+	// The files the server's config would change or remove, as of the
+	// last check-in
+	Pending []string `json:",omitempty"`
+}
+
+func (a *App) holdFile() string {
+	return filepath.Join(a.stateDir(), "config.hold")
+}
+
+// Hold stops check-ins from applying new configs until Unhold. Holding a
+// device that's already on hold updates the reason.
+func (a *App) Hold(reason string) error {
+	hold, err := a.HoldStatus()
+	if err != nil {
+		return err
+	}
+	if hold == nil {
+		hold = &ConfigHold{Since: time.Now().UTC()}
+	}
+	hold.Reason = reason
+	if err := a.saveHold(hold); err != nil {
+		return err
+	}
+	LogEvent(EventConfigHeld, "Config changes are on hold: %s", reason)
+	return nil
+}
+
+// Unhold lets the next check-in apply the server's config again
+func (a *App) Unhold() error {
+	if err := os.Remove(a.holdFile()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	LogEvent(EventConfigUnheld, "Config changes are no longer on hold")
+	return nil
+}
+
+// HoldStatus returns the hold the device is on, or nil
+func (a *App) HoldStatus() (*ConfigHold, error) {
+	buf, err := os.ReadFile(a.holdFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var hold ConfigHold
+	if err := json.Unmarshal(buf, &hold); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %w", a.holdFile(), err)
+	}
+	return &hold, nil
+}
+
+func (a *App) saveHold(hold *ConfigHold) error {
+	buf, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+	return safeWrite(a.holdFile(), buf)
+}
+
+// pendingChanges returns the files applying next would change or remove
+func (a *App) pendingChanges(next ConfigStruct) []string {
+	applied := a.readManifest().Files
+	var pending []string
+	for fname, cfgFile := range next {
+		content, err := cfgFile.content()
+		if err == nil && applied[fname] == sha256Hex(content) {
+			zeroize(content)
+			continue
+		}
+		zeroize(content)
+		pending = append(pending, fname)
+	}
+	for fname := range applied {
+		if _, ok := next[fname]; !ok {
+			pending = append(pending, fname)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// holdConfig records what next would change instead of applying it. The
+// server is told about the changes each time they're different.
+func (a *App) holdConfig(ctx context.Context, client *http.Client, hold *ConfigHold, next ConfigStruct) error {
+	pending := a.pendingChanges(next)
+	if strings.Join(pending, "\n") == strings.Join(hold.Pending, "\n") {
+		a.debugf("Config changes still on hold: %s", strings.Join(pending, ", "))
+		return ConfigHeldError
+	}
+	LogEvent(EventConfigHeld, "Not applying changes to %d files, config is on hold: %s", len(pending), hold.Reason)
+	hold.Pending = pending
+	if err := a.saveHold(hold); err != nil {
+		logger.Printf("Unable to save config hold: %s", err)
+	}
+	if len(pending) > 0 {
+		report := newExtractReport()
+		report.Code = EventConfigHeld
+		report.Change = a.change
+		report.Held = pending
+		a.reportStatus(ctx, client, report)
+	}
+	return ConfigHeldError
+}
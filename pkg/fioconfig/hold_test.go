@@ -0,0 +1,90 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHold(t *testing.T) {
+	var encbuf []byte
+	etag := `"v1"`
+	var reports []ExtractReport
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/config-status" {
+			var report ExtractReport
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&report))
+			reports = append(reports, report)
+			w.WriteHeader(201)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+		app.configUrl += "/config"
+
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		hold, err := app.HoldStatus()
+		require.Nil(t, err)
+		require.Nil(t, hold)
+
+		require.Nil(t, app.Hold("replacing the pump"))
+		hold, err = app.HoldStatus()
+		require.Nil(t, err)
+		require.Equal(t, "replacing the pump", hold.Reason)
+
+		// The server's new config is reported but not applied
+		var config map[string]*ConfigFile
+		require.Nil(t, json.Unmarshal(encbuf, &config))
+		config["bar"].Value = "new bar"
+		config["new"] = &ConfigFile{Value: "new", Unencrypted: true}
+		delete(config, "random")
+		encbuf, err = json.Marshal(config)
+		require.Nil(t, err)
+		etag = `"v2"`
+
+		err = app.checkin(context.Background(), client, crypto)
+		require.Equal(t, ConfigHeldError, err)
+		require.True(t, errors.Is(err, NotModifiedError))
+		require.Equal(t, "held", CheckInResult(err))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("bar file value"))
+		assertNoFile(t, filepath.Join(tempdir, "new"))
+		require.FileExists(t, filepath.Join(tempdir, "random"))
+		require.Len(t, reports, 1)
+		require.Equal(t, EventConfigHeld, reports[0].Code)
+		require.Equal(t, []string{"bar", "new", "random"}, reports[0].Held)
+		hold, err = app.HoldStatus()
+		require.Nil(t, err)
+		require.Equal(t, []string{"bar", "new", "random"}, hold.Pending)
+
+		// The same changes aren't reported again
+		require.Equal(t, ConfigHeldError, app.checkin(context.Background(), client, crypto))
+		require.Len(t, reports, 1)
+
+		require.Nil(t, app.Unhold())
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("new bar"))
+		assertFile(t, filepath.Join(tempdir, "new"), []byte("new"))
+		assertNoFile(t, filepath.Join(tempdir, "random"))
+		require.Nil(t, app.Unhold())
+	})
+}
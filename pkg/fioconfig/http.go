@@ -0,0 +1,146 @@
+package fioconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type httpRes struct {
+	StatusCode int
+	Body       []byte
+	Header     http.Header
+}
+
+func (res httpRes) Json(data interface{}) error {
+	return json.Unmarshal(res.Body, data)
+}
+
+func (res httpRes) String() string {
+	return string(res.Body)
+}
+
+func readResponse(r *http.Response) (*httpRes, error) {
+	defer r.Body.Close()
+	res := &httpRes{
+		StatusCode: r.StatusCode,
+		Header:     r.Header,
+	}
+	body, err := decodeBody(r.Header.Get("Content-Encoding"), r.Body)
+	if err != nil {
+		return res, fmt.Errorf("Unable to decode response from %s: %w", r.Request.URL, err)
+	}
+	defer body.Close()
+	res.Body, err = readLimited(body, responseLimit(r.Request.Context()))
+	if err != nil {
+		return res, fmt.Errorf("Unable to read response from %s: %w", r.Request.URL, err)
+	}
+	return res, nil
+}
+
+// decodeBody handles the Content-Encodings we advertise. We have to do this
+// ourselves because Go's transport only decompresses transparently when it
+// set the Accept-Encoding header itself. The reader must be closed, which
+// stops the zstd decoder's goroutines, but doesn't close body.
+func decodeBody(encoding string, body io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewReader(body)
+	case "zstd":
+		zr, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(body), nil
+}
+
+func httpDoOnce(ctx context.Context, client *http.Client, method, url string, headers map[string]string, data interface{}) (*httpRes, error) {
+	var dataBytes []byte
+	if data != nil {
+		var err error
+		dataBytes, err = json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(dataBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("User-Agent", "fioconfig-client/2")
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept-Encoding", "zstd, gzip")
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to %s: %s - %w", method, url, err)
+	}
+	return readResponse(res)
+}
+
+func httpDo(ctx context.Context, client *http.Client, method, url string, headers map[string]string, data interface{}) (*httpRes, error) {
+	return httpRetry(ctx, method, url, func() (*httpRes, error) {
+		return httpDoOnce(ctx, client, method, url, headers, data)
+	})
+}
+
+// httpRetry calls do until the server answers with something other than a
+// 5xx, backing off between attempts
+func httpRetry(ctx context.Context, method, url string, do func() (*httpRes, error)) (*httpRes, error) {
+	var err error
+	var res *httpRes
+	for _, delay := range []int{0, 1, 2, 5, 13, 30} {
+		if delay != 0 {
+			logger.Printf("HTTP %s to %s failed, trying again in %d seconds", url, method, delay)
+			select {
+			case <-time.After(time.Second * time.Duration(delay)):
+			case <-ctx.Done():
+				return res, ctx.Err()
+			}
+		}
+		res, err = do()
+		if err == nil && res.StatusCode != 0 && res.StatusCode < 500 {
+			break
+		} else if errors.Is(err, PayloadTooLargeError) {
+			break // It won't get any smaller
+		}
+	}
+	return res, err
+}
+
+func httpGet(client *http.Client, url string, headers map[string]string) (*httpRes, error) {
+	return httpGetContext(context.Background(), client, url, headers)
+}
+
+func httpGetContext(ctx context.Context, client *http.Client, url string, headers map[string]string) (*httpRes, error) {
+	return httpDo(ctx, client, http.MethodGet, url, headers, nil)
+}
+
+func httpPatch(client *http.Client, url string, data interface{}) (*httpRes, error) {
+	return httpDo(context.Background(), client, http.MethodPatch, url, nil, data)
+}
+
+func httpDelete(client *http.Client, url string) (*httpRes, error) {
+	return httpDo(context.Background(), client, http.MethodDelete, url, nil, nil)
+}
+
+func httpPost(client *http.Client, url string, data interface{}) (*httpRes, error) {
+	return httpPostContext(context.Background(), client, url, data)
+}
+
+func httpPostContext(ctx context.Context, client *http.Client, url string, data interface{}) (*httpRes, error) {
+	return httpDo(ctx, client, http.MethodPost, url, nil, data)
+}
@@ -0,0 +1,144 @@
+package fioconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpRetry(t *testing.T) {
+	codes := []int{501, 200}
+	idx := 0
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(codes[idx])
+		idx++
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		start := time.Now()
+		res, err := httpGet(client, app.configUrl, nil)
+		elapsed := time.Since(start)
+		require.Nil(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		require.Less(t, int64(1000), elapsed.Milliseconds())
+		require.Equal(t, 2, idx)
+
+		// Now do a test that won't retry:
+		codes = []int{400}
+		idx = 0
+		res, err = httpGet(client, app.configUrl, nil)
+		require.Nil(t, err)
+		require.Equal(t, 400, res.StatusCode)
+		require.Equal(t, 1, idx)
+	})
+}
+
+func TestHttpCancel(t *testing.T) {
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		// Retries stop as soon as the context is done
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := httpGetContext(ctx, client, app.configUrl, nil)
+		require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+		require.Less(t, time.Since(start), time.Second)
+
+		_, err = httpGetContext(ctx, client, app.configUrl, nil)
+		require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	})
+}
+
+func TestHttpCompressed(t *testing.T) {
+	encoding := "gzip"
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Contains(t, r.Header.Get("Accept-Encoding"), encoding)
+		w.Header().Set("Content-Encoding", encoding)
+		var buf bytes.Buffer
+		var wc io.WriteCloser
+		if encoding == "gzip" {
+			wc = gzip.NewWriter(&buf)
+		} else {
+			var err error
+			wc, err = zstd.NewWriter(&buf)
+			require.Nil(t, err)
+		}
+		_, err := wc.Write([]byte("compressed body"))
+		require.Nil(t, err)
+		require.Nil(t, wc.Close())
+		_, err = w.Write(buf.Bytes())
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		for _, encoding = range []string{"gzip", "zstd"} {
+			res, err := httpGet(client, app.configUrl, nil)
+			require.Nil(t, err)
+			require.Equal(t, "compressed body", res.String())
+		}
+	})
+}
+
+func TestDecodeBodyClose(t *testing.T) {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	require.Nil(t, err)
+	_, err = zw.Write([]byte("compressed body"))
+	require.Nil(t, err)
+	require.Nil(t, zw.Close())
+
+	// The decoders' goroutines don't outlive them
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		body, err := decodeBody("zstd", bytes.NewReader(buf.Bytes()))
+		require.Nil(t, err)
+		content, err := io.ReadAll(body)
+		require.Nil(t, err)
+		require.Equal(t, "compressed body", string(content))
+		require.Nil(t, body.Close())
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestHttpTooLarge(t *testing.T) {
+	requests := 0
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// A small response that expands to 1MiB
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		_, err := gz.Write(make([]byte, 1024*1024))
+		require.Nil(t, err)
+		require.Nil(t, gz.Close())
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		ctx := withResponseLimit(context.Background(), 1024)
+		_, err := httpGetContext(ctx, client, app.configUrl, nil)
+		require.True(t, errors.Is(err, PayloadTooLargeError), err)
+		require.Equal(t, 1, requests)
+
+		res, err := httpGet(client, app.configUrl, nil)
+		require.Nil(t, err)
+		require.Len(t, res.Body, 1024*1024)
+
+		// Check-ins use max_config_size and don't treat it as the server
+		// being unreachable
+		app.settings.MaxConfigSize = 1024
+		err = app.checkin(context.Background(), client, nil)
+		require.True(t, errors.Is(err, PayloadTooLargeError), err)
+		require.False(t, errors.Is(err, ServerUnreachableError))
+	})
+}
@@ -0,0 +1,200 @@
+package fioconfig
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	toml "github.com/pelletier/go-toml"
+)
+
+// IdentityProvider loads the device's TLS client certificate and the
+// CryptoHandler able to decrypt config values encrypted to it.
+type IdentityProvider func(sota *toml.Tree) (tls.Certificate, CryptoHandler, error)
+
+// Identity providers keyed by the `tls.pkey_source` value in sota.toml
+var identityProviders = map[string]IdentityProvider{
+	"file":   fileIdentity,
+	"pkcs11": pkcs11Identity,
+	"se05x":  se05xIdentity,
+}
+
+// RegisterIdentityProvider allows support for new key sources like a SoC's
+// fused keys to live in their own files that get included at build time
+// (via build tags) and register themselves from an init function.
+func RegisterIdentityProvider(pkeySource string, provider IdentityProvider) {
+	identityProviders[pkeySource] = provider
+}
+
+func identityProviderNames() []string {
+	names := make([]string, 0, len(identityProviders))
+	for name := range identityProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func fileIdentity(sota *toml.Tree) (tls.Certificate, CryptoHandler, error) {
+	certFile, err := tomlGet(sota, "import.tls_clientcert_path")
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	keyFile, err := tomlGet(sota, "import.tls_pkey_path")
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return cert, nil, err
+	}
+	if handler := NewEciesLocalHandler(cert.PrivateKey); handler != nil {
+		return cert, handler, nil
+	}
+	return cert, nil, errors.New("Unsupported private key")
+}
+
+// Environment variable that overrides the PKCS#11 PIN in sota.toml
+const pkcs11PinEnv = "FIOCONFIG_P11_PIN"
+
+// pkcs11Pin finds the token's PIN. So that it needn't be stored in plain
+// text in sota.toml, it can come from the environment or from `p11.pass_file`
+// (e.g. on a tmpfs populated by an earlier boot stage). The value itself must
+// never be logged.
+func pkcs11Pin(sota *toml.Tree) (string, error) {
+	if pin, ok := os.LookupEnv(pkcs11PinEnv); ok {
+		return pin, nil
+	}
+	if passFile, ok := sota.Get("p11.pass_file").(string); ok {
+		buf, err := os.ReadFile(passFile)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read p11.pass_file: %w", err)
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	}
+	return tomlGet(sota, "p11.pass")
+}
+
+// pkcs11Config returns the crypto11 config for the token in sota.toml
+func pkcs11Config(sota *toml.Tree) (crypto11.Config, pkcs11Login, error) {
+	module, err := tomlGet(sota, "p11.module")
+	if err != nil {
+		return crypto11.Config{}, pkcs11Login{}, err
+	}
+	cfg := crypto11.Config{Path: module}
+	login, err := pkcs11Tuning(sota, "p11", &cfg)
+	if err != nil {
+		return cfg, login, err
+	}
+	pin, err := pkcs11Pin(sota)
+	if err != nil {
+		return cfg, login, err
+	}
+	cfg.Pin = pin
+
+	// Tokens provisioned by other stacks won't use aktualizr's label, so
+	// allow selecting them by slot or serial number instead.
+	if slot, ok := sota.Get("p11.slot").(int64); ok {
+		slotNumber := int(slot)
+		cfg.SlotNumber = &slotNumber
+	} else if serial, ok := sota.Get("p11.serial").(string); ok {
+		cfg.TokenSerial = serial
+	} else {
+		cfg.TokenLabel = sota.GetDefault("p11.label", "aktualizr").(string)
+	}
+	return cfg, login, nil
+}
+
+func pkcs11Identity(sota *toml.Tree) (tls.Certificate, CryptoHandler, error) {
+	pkeyId, err := tomlGet(sota, "p11.tls_pkey_id")
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	certId, err := tomlGet(sota, "p11.tls_clientcert_id")
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cfg, login, err := pkcs11Config(sota)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cert, handler, err := pkcs11LoadIdentity(&cfg, login, idToBytes(pkeyId), idToBytes(certId))
+	if err == nil {
+		return cert, handler, nil
+	}
+	logger.Printf("Unable to load pkcs11 identity from configured token (%s), scanning slots", err)
+	if cert, handler, serr := pkcs11ScanSlots(cfg, login, idToBytes(pkeyId), idToBytes(certId)); serr == nil {
+		return cert, handler, nil
+	}
+	return cert, nil, err
+}
+
+// pkcs11ScanSlots looks through every slot with a token present for one
+// holding the given key pair and certificate.
+func pkcs11ScanSlots(cfg crypto11.Config, login pkcs11Login, pkeyId, certId []byte) (tls.Certificate, CryptoHandler, error) {
+	p := pkcs11.New(cfg.Path)
+	if p == nil {
+		return tls.Certificate{}, nil, fmt.Errorf("Unable to load pkcs11 module %s", cfg.Path)
+	}
+	if err := p.Initialize(); err != nil {
+		p.Destroy()
+		return tls.Certificate{}, nil, err
+	}
+	slots, err := p.GetSlotList(true)
+	_ = p.Finalize()
+	p.Destroy()
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cfg.TokenLabel = ""
+	cfg.TokenSerial = ""
+	for _, slot := range slots {
+		slotNumber := int(slot)
+		cfg.SlotNumber = &slotNumber
+		if cert, handler, err := pkcs11LoadIdentity(&cfg, login, pkeyId, certId); err == nil {
+			logger.Printf("Found pkcs11 identity in slot %d", slot)
+			return cert, handler, nil
+		}
+	}
+	return tls.Certificate{}, nil, errors.New("No slot has the pkcs11 client cert and private key")
+}
+
+// pkcs11LoadIdentity finds the client certificate and its key pair in the
+// token described by cfg, using the token's shared context.
+func pkcs11LoadIdentity(cfg *crypto11.Config, login pkcs11Login, pkeyId, certId []byte) (tls.Certificate, CryptoHandler, error) {
+	var tlsCert tls.Certificate
+	shared := sharedPkcs11(cfg, login)
+	ctx, err := shared.get()
+	if err != nil {
+		return tlsCert, nil, err
+	}
+
+	key, err := newPkcs11KeyPair(shared, pkeyId)
+	if err != nil {
+		shared.reset(ctx)
+		return tlsCert, nil, err
+	}
+	cert, err := ctx.FindCertificate(certId, nil, nil)
+	if err != nil {
+		shared.reset(ctx)
+		return tlsCert, nil, err
+	}
+	if cert == nil {
+		shared.reset(ctx)
+		return tlsCert, nil, fmt.Errorf("Unable to load pkcs11 client cert and/or private key")
+	}
+	tlsCert = tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+	}
+	return tlsCert, newSharedPkcs11Handler(key), nil
+}
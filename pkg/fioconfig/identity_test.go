@@ -0,0 +1,74 @@
+package fioconfig
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityProvider(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		var crypto CryptoHandler
+		RegisterIdentityProvider("test", func(sota *toml.Tree) (tls.Certificate, CryptoHandler, error) {
+			cert, handler, err := fileIdentity(sota)
+			crypto = handler
+			return cert, handler, err
+		})
+		defer delete(identityProviders, "test")
+
+		app.sota.Set("tls.pkey_source", "test")
+		app.sota.Set("tls.cert_source", "test")
+		_, handler, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer handler.Close()
+		require.NotNil(t, crypto)
+		require.Equal(t, crypto, handler)
+	})
+}
+
+func TestSe05xObjectId(t *testing.T) {
+	id, err := se05xObjectId("0x7FFF0201")
+	require.Nil(t, err)
+	require.Equal(t, []byte{0x7f, 0xff, 0x02, 0x01}, id)
+
+	id, err = se05xObjectId("f0000012")
+	require.Nil(t, err)
+	require.Equal(t, []byte{0xf0, 0x00, 0x00, 0x12}, id)
+
+	_, err = se05xObjectId("0x7fff02")
+	require.NotNil(t, err)
+	_, err = se05xObjectId("not-hex!")
+	require.NotNil(t, err)
+}
+
+func TestPkcs11Pin(t *testing.T) {
+	dir := t.TempDir()
+	sota, err := toml.Load("[p11]\npass = \"from-sota\"\n")
+	require.Nil(t, err)
+
+	pin, err := pkcs11Pin(sota)
+	require.Nil(t, err)
+	require.Equal(t, "from-sota", pin)
+
+	passFile := filepath.Join(dir, "pin")
+	require.Nil(t, os.WriteFile(passFile, []byte("from-file\n"), 0o600))
+	sota.Set("p11.pass_file", passFile)
+	pin, err = pkcs11Pin(sota)
+	require.Nil(t, err)
+	require.Equal(t, "from-file", pin)
+
+	t.Setenv(pkcs11PinEnv, "from-env")
+	pin, err = pkcs11Pin(sota)
+	require.Nil(t, err)
+	require.Equal(t, "from-env", pin)
+	os.Unsetenv(pkcs11PinEnv)
+
+	sota.Set("p11.pass_file", filepath.Join(dir, "missing"))
+	_, err = pkcs11Pin(sota)
+	require.NotNil(t, err)
+}
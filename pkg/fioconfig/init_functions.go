@@ -0,0 +1,123 @@
+package fioconfig
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InitFunc is run before check-ins until it succeeds
+type InitFunc func(app *App, client *http.Client, crypto CryptoHandler) error
+
+// InitPolicy controls how an init function is retried. The zero value
+// retries before every check-in until the function succeeds.
+type InitPolicy struct {
+	// Give up after this many failed attempts. Zero never gives up.
+	MaxAttempts int
+	// Wait at least this long after a failure before trying again
+	Backoff time.Duration
+	// Check-ins fail with an InitError until the function succeeds
+	Required bool
+}
+
+// InitError is returned by check-ins when a required init function hasn't
+// succeeded
+type InitError struct {
+	Name string
+	Err  error
+}
+
+func (e *InitError) Error() string {
+	return fmt.Sprintf("Required initialization %s failed: %s", e.Name, e.Err)
+}
+
+func (e *InitError) Unwrap() error {
+	return e.Err
+}
+
+type initFunction struct {
+	name   string
+	prio   int
+	fn     InitFunc
+	policy InitPolicy
+}
+
+// initStatus tracks an init function's attempts for one App
+type initStatus struct {
+	done     bool
+	attempts int
+	last     time.Time
+	err      error
+}
+
+var (
+	initLock      sync.Mutex
+	initFunctions []initFunction
+)
+
+// RegisterInitFunction adds a function to run before check-ins. Functions
+// run in order of prio, lowest first, and then by name. Registering a name
+// again replaces the previous function.
+func RegisterInitFunction(name string, prio int, fn InitFunc, policy InitPolicy) {
+	initLock.Lock()
+	defer initLock.Unlock()
+	for i, f := range initFunctions {
+		if f.name == name {
+			initFunctions = append(initFunctions[:i], initFunctions[i+1:]...)
+			break
+		}
+	}
+	initFunctions = append(initFunctions, initFunction{name, prio, fn, policy})
+	sort.SliceStable(initFunctions, func(i, j int) bool {
+		if initFunctions[i].prio != initFunctions[j].prio {
+			return initFunctions[i].prio < initFunctions[j].prio
+		}
+		return initFunctions[i].name < initFunctions[j].name
+	})
+}
+
+func registeredInitFunctions() []initFunction {
+	initLock.Lock()
+	defer initLock.Unlock()
+	return append([]initFunction(nil), initFunctions...)
+}
+
+// callInitFunctions runs the init functions that haven't succeeded yet. It
+// stops at the first required one that fails so later ones can depend on it.
+func (a *App) callInitFunctions(client *http.Client, crypto CryptoHandler) error {
+	if a.initStatus == nil {
+		a.initStatus = make(map[string]*initStatus)
+	}
+	for _, f := range registeredInitFunctions() {
+		status := a.initStatus[f.name]
+		if status == nil {
+			status = &initStatus{}
+			a.initStatus[f.name] = status
+		}
+		if status.done {
+			continue
+		}
+		gaveUp := f.policy.MaxAttempts > 0 && status.attempts >= f.policy.MaxAttempts
+		waiting := status.attempts > 0 && time.Since(status.last) < f.policy.Backoff
+		if !gaveUp && !waiting {
+			logger.Printf("Running %s initialization", f.name)
+			status.attempts++
+			status.last = time.Now()
+			status.err = f.fn(a, client, crypto)
+			if status.err == nil {
+				status.done = true
+				continue
+			}
+			LogEvent(EventInitFailed, "Initialization %s failed (attempt %d): %s", f.name, status.attempts, status.err)
+			if f.policy.MaxAttempts > 0 && status.attempts >= f.policy.MaxAttempts {
+				LogEvent(EventInitGaveUp, "Giving up on initialization %s after %d attempts", f.name, status.attempts)
+			}
+		}
+		if f.policy.Required {
+			return &InitError{f.name, status.err}
+		}
+	}
+	return nil
+}
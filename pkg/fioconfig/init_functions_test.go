@@ -0,0 +1,100 @@
+package fioconfig
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// saveInitFunctions lets a test register init functions without leaking
+// them into other tests
+func saveInitFunctions(t *testing.T) {
+	saved := registeredInitFunctions()
+	initFunctions = nil
+	t.Cleanup(func() { initFunctions = saved })
+}
+
+func TestInitFunctionOrder(t *testing.T) {
+	saveInitFunctions(t)
+	var order []string
+	record := func(name string) InitFunc {
+		return func(app *App, client *http.Client, crypto CryptoHandler) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	RegisterInitFunction("c", 10, record("c"), InitPolicy{})
+	RegisterInitFunction("b", 0, record("b"), InitPolicy{})
+	RegisterInitFunction("a", 0, record("a"), InitPolicy{})
+	RegisterInitFunction("first", -1, record("wrong"), InitPolicy{})
+	RegisterInitFunction("first", -1, record("first"), InitPolicy{})
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		require.Nil(t, app.CallInitFunctions())
+		require.Equal(t, []string{"first", "a", "b", "c"}, order)
+	})
+}
+
+func TestInitFunctionRetry(t *testing.T) {
+	saveInitFunctions(t)
+	failure := errors.New("not yet")
+	attempts := 0
+	RegisterInitFunction("flaky", 0, func(app *App, client *http.Client, crypto CryptoHandler) error {
+		attempts++
+		return failure
+	}, InitPolicy{MaxAttempts: 2})
+	backoffAttempts := 0
+	RegisterInitFunction("slow", 1, func(app *App, client *http.Client, crypto CryptoHandler) error {
+		backoffAttempts++
+		return failure
+	}, InitPolicy{Backoff: time.Hour})
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		for i := 0; i < 3; i++ {
+			require.Nil(t, app.CallInitFunctions())
+		}
+		require.Equal(t, 2, attempts)
+		require.Equal(t, 1, backoffAttempts)
+	})
+}
+
+func TestInitFunctionRequired(t *testing.T) {
+	saveInitFunctions(t)
+	failure := errors.New("no network yet")
+	fail := true
+	laterCalled := false
+	RegisterInitFunction("needed", 0, func(app *App, client *http.Client, crypto CryptoHandler) error {
+		if fail {
+			return failure
+		}
+		return nil
+	}, InitPolicy{Required: true})
+	RegisterInitFunction("later", 1, func(app *App, client *http.Client, crypto CryptoHandler) error {
+		laterCalled = true
+		return nil
+	}, InitPolicy{})
+
+	checkedIn := false
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checkedIn = true
+		w.WriteHeader(304)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		err := app.CheckIn()
+		var initErr *InitError
+		require.True(t, errors.As(err, &initErr), err)
+		require.Equal(t, "needed", initErr.Name)
+		require.True(t, errors.Is(err, failure))
+		require.False(t, checkedIn)
+		require.False(t, laterCalled)
+
+		fail = false
+		require.Equal(t, NotModifiedError, app.CheckIn())
+		require.True(t, checkedIn)
+		require.True(t, laterCalled)
+	})
+}
@@ -0,0 +1,76 @@
+//go:build integration
+// +build integration
+
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// These tests talk to a real device gateway, normally the Foundries staging
+// backend, and only run with `go test -tags integration`. They're configured
+// with:
+//
+//	FIOCONFIG_IT_SOTA_DIR     - sota.toml directory of a registered device
+//	FIOCONFIG_IT_REGISTER_CMD - command to register a new device instead,
+//	                            e.g. "lmp-device-register -T $TOKEN ...". It
+//	                            runs with $SOTA_DIR set to where it must
+//	                            write sota.toml and the device credentials.
+//
+// The device's check-in state is modified, so use one that only exists for
+// testing.
+func integrationSotaDir(t *testing.T) string {
+	if cmd := os.Getenv("FIOCONFIG_IT_REGISTER_CMD"); len(cmd) > 0 {
+		dir := t.TempDir()
+		register := exec.Command("/bin/sh", "-c", cmd)
+		register.Env = append(os.Environ(), "SOTA_DIR="+dir)
+		out, err := register.CombinedOutput()
+		require.Nil(t, err, "Unable to register device: %s", out)
+		return dir
+	}
+	dir := os.Getenv("FIOCONFIG_IT_SOTA_DIR")
+	if len(dir) == 0 {
+		t.Skip("FIOCONFIG_IT_SOTA_DIR or FIOCONFIG_IT_REGISTER_CMD must be set")
+	}
+	return dir
+}
+
+func TestIntegrationCheckIn(t *testing.T) {
+	app, err := NewApp(integrationSotaDir(t), WithSecretsDir(t.TempDir()))
+	require.Nil(t, err)
+	client, crypto, err := createClient(app.sota)
+	require.Nil(t, err)
+	defer crypto.Close()
+
+	require.Nil(t, selfTest(crypto))
+
+	// Start from scratch so the full config is downloaded and extracted
+	require.Nil(t, os.RemoveAll(app.EncryptedConfig))
+	require.Nil(t, os.RemoveAll(app.checkInStateFile()))
+	require.Nil(t, os.RemoveAll(app.manifestFile()))
+	if err := app.checkin(context.Background(), client, crypto); errors.Is(err, NotModifiedError) {
+		t.Skip("The device has no config defined on the server")
+	} else {
+		require.Nil(t, err)
+	}
+	config, err := app.unmarshallCache(crypto, app.EncryptedConfig, true)
+	require.Nil(t, err)
+	for fname := range config {
+		assertFile(t, filepath.Join(app.SecretsDir, fname), nil)
+	}
+
+	// The server has nothing new, and local extraction works offline
+	require.Equal(t, NotModifiedError, app.checkin(context.Background(), client, crypto))
+	report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+	require.Nil(t, err)
+	require.Empty(t, report.Failed)
+
+	require.Nil(t, app.postStatus(context.Background(), client, report))
+}
@@ -0,0 +1,64 @@
+package fioconfig
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+)
+
+var journalPriorities = []journal.Priority{
+	LevelDebug: journal.PriDebug,
+	LevelInfo:  journal.PriInfo,
+	LevelWarn:  journal.PriWarning,
+	LevelError: journal.PriErr,
+}
+
+// Fields with names the journal already has a convention for
+var journalFieldNames = map[string]string{
+	"file": "CONFIG_FILE",
+}
+
+// journalSend is replaced by tests
+var journalSend = journal.Send
+
+// DefaultLogFormat returns LogFormatJournal when stderr is connected to the
+// systemd journal, as it is when fioconfig runs as a service, and
+// LogFormatText otherwise.
+func DefaultLogFormat() LogFormat {
+	if ok, err := journal.StderrIsJournalStream(); err == nil && ok && journal.Enabled() {
+		return LogFormatJournal
+	}
+	return LogFormatText
+}
+
+// sendJournal writes a message to the journal with its priority so that
+// `journalctl -p` works. The message is kept as text logs have it.
+func sendJournal(level LogLevel, code EventCode, fields LogFields, msg string) error {
+	vars := make(map[string]string, len(fields)+1)
+	if len(code) > 0 {
+		vars["FIOCONFIG_EVENT"] = string(code)
+	}
+	for k, v := range fields {
+		vars[journalFieldName(k)] = fmt.Sprint(v)
+	}
+	return journalSend(msg, journalPriorities[level], vars)
+}
+
+// journalFieldName converts a field name to the upper case letters,
+// digits, and underscores the journal allows.
+func journalFieldName(name string) string {
+	if jname, ok := journalFieldNames[name]; ok {
+		return jname
+	}
+	mapped := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	return strings.TrimLeft(mapped, "_")
+}
@@ -0,0 +1,52 @@
+package fioconfig
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	type entry struct {
+		msg  string
+		pri  journal.Priority
+		vars map[string]string
+	}
+	var sent []entry
+	orig := journalSend
+	journalSend = func(msg string, pri journal.Priority, vars map[string]string) error {
+		if vars["FAIL"] == "1" {
+			return errors.New("No journal")
+		}
+		sent = append(sent, entry{msg, pri, vars})
+		return nil
+	}
+	t.Cleanup(func() { journalSend = orig })
+	buf := captureLogs(t, LogFormatJournal, LevelInfo)
+
+	LogEventWith(EventHandlerFailed, LogFields{"file": "foo", "checkin_result": "failed"}, "Unable to run command")
+	logger.Printf("WARNING: careful")
+	LogEventWith(EventFileExtracted, LogFields{"fail": 1}, "Extracting")
+
+	require.Len(t, sent, 2)
+	require.Equal(t, "FIO-3002 Unable to run command", sent[0].msg)
+	require.Equal(t, journal.PriErr, sent[0].pri)
+	require.Equal(t, map[string]string{
+		"FIOCONFIG_EVENT": "FIO-3002",
+		"CONFIG_FILE":     "foo",
+		"CHECKIN_RESULT":  "failed",
+	}, sent[0].vars)
+	require.Equal(t, journal.PriWarning, sent[1].pri)
+	require.Equal(t, "WARNING: careful", sent[1].msg)
+
+	// Falls back to text when the journal can't be written to
+	require.Contains(t, buf.String(), "FIO-2001 Extracting\n")
+}
+
+func TestJournalFieldName(t *testing.T) {
+	require.Equal(t, "CONFIG_FILE", journalFieldName("file"))
+	require.Equal(t, "HTTP_STATUS", journalFieldName("http-status"))
+	require.Equal(t, "PRIVATE", journalFieldName("_private"))
+}
@@ -0,0 +1,428 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Devices running k3s or microk8s can have their config files mirrored into
+// Kubernetes Secrets so pods can mount them. The store talks to the API
+// server with the credentials in the local kubeconfig.
+
+// kubeconfigPaths are where k3s and microk8s leave their admin kubeconfig
+var kubeconfigPaths = []string{
+	"/etc/rancher/k3s/k3s.yaml",
+	"/var/snap/microk8s/current/credentials/client.config",
+}
+
+const kubeManagedByLabel = "app.kubernetes.io/managed-by"
+
+// kubeStore keeps each config file as a key in a Kubernetes Secret. By
+// default a file gets its own Secret, named after the file, in the
+// kubernetes_namespace. kubernetes_secrets maps files to a Secret of their
+// choosing, so related files can be mounted together.
+type kubeStore struct {
+	client    *http.Client
+	server    string
+	token     string
+	namespace string
+	mappings  []kubeMapping
+}
+
+type kubeMapping struct {
+	pattern   string
+	namespace string
+	name      string
+}
+
+type kubeSecret struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Type       string                 `json:"type,omitempty"`
+	Data       map[string]string      `json:"data,omitempty"`
+}
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server string `yaml:"server"`
+			CA     string `yaml:"certificate-authority"`
+			CAData string `yaml:"certificate-authority-data"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			ClientCert     string `yaml:"client-certificate"`
+			ClientCertData string `yaml:"client-certificate-data"`
+			ClientKey      string `yaml:"client-key"`
+			ClientKeyData  string `yaml:"client-key-data"`
+			Token          string `yaml:"token"`
+			TokenFile      string `yaml:"tokenFile"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// findKubeconfig returns the kubeconfig to use when the kubeconfig setting
+// is empty: $KUBECONFIG, then the ones k3s and microk8s install
+func findKubeconfig() (string, error) {
+	if env := os.Getenv("KUBECONFIG"); len(env) > 0 {
+		return strings.Split(env, string(os.PathListSeparator))[0], nil
+	}
+	for _, p := range kubeconfigPaths {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", errors.New("Unable to find a kubeconfig, set fioconfig.kubeconfig")
+}
+
+// readKubeData returns the value of a kubeconfig "-data" field, or the
+// content of the file the field without the suffix points to
+func readKubeData(data, file string) ([]byte, error) {
+	if len(data) > 0 {
+		return base64.StdEncoding.DecodeString(data)
+	} else if len(file) > 0 {
+		return os.ReadFile(file)
+	}
+	return nil, nil
+}
+
+func newKubeStore(settings Settings) (*kubeStore, error) {
+	cfgPath := settings.Kubeconfig
+	if len(cfgPath) == 0 {
+		var err error
+		if cfgPath, err = findKubeconfig(); err != nil {
+			return nil, err
+		}
+	}
+	buf, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read kubeconfig: %w", err)
+	}
+	var cfg kubeconfig
+	if err := yaml.Unmarshal(buf, &cfg); err != nil {
+		return nil, fmt.Errorf("Unable to parse kubeconfig %s: %w", cfgPath, err)
+	}
+
+	idx := -1
+	for i, c := range cfg.Contexts {
+		if c.Name == cfg.CurrentContext || (len(cfg.CurrentContext) == 0 && i == 0) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("Context %q not found in kubeconfig %s", cfg.CurrentContext, cfgPath)
+	}
+	ctx := cfg.Contexts[idx].Context
+
+	store := kubeStore{namespace: settings.KubernetesNamespace}
+	if len(store.namespace) == 0 {
+		store.namespace = ctx.Namespace
+	}
+	if len(store.namespace) == 0 {
+		store.namespace = "default"
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	found := false
+	for _, c := range cfg.Clusters {
+		if c.Name != ctx.Cluster {
+			continue
+		}
+		found = true
+		store.server = strings.TrimRight(c.Cluster.Server, "/")
+		ca, err := readKubeData(c.Cluster.CAData, c.Cluster.CA)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read cluster CA: %w", err)
+		}
+		if len(ca) > 0 {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("Invalid cluster CA in kubeconfig %s", cfgPath)
+			}
+		}
+	}
+	if !found || len(store.server) == 0 {
+		return nil, fmt.Errorf("Cluster %q not found in kubeconfig %s", ctx.Cluster, cfgPath)
+	}
+
+	for _, u := range cfg.Users {
+		if u.Name != ctx.User {
+			continue
+		}
+		store.token = u.User.Token
+		if len(store.token) == 0 && len(u.User.TokenFile) > 0 {
+			token, err := os.ReadFile(u.User.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read kubeconfig token: %w", err)
+			}
+			store.token = strings.TrimSpace(string(token))
+		}
+		certPem, err := readKubeData(u.User.ClientCertData, u.User.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read kubeconfig client certificate: %w", err)
+		}
+		keyPem, err := readKubeData(u.User.ClientKeyData, u.User.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read kubeconfig client key: %w", err)
+		}
+		if len(certPem) > 0 {
+			cert, err := tls.X509KeyPair(certPem, keyPem)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid client certificate in kubeconfig %s: %w", cfgPath, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	for _, entry := range settings.KubernetesSecrets {
+		m, err := parseKubeMapping(entry, store.namespace)
+		if err != nil {
+			return nil, err
+		}
+		store.mappings = append(store.mappings, m)
+	}
+
+	store.client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return &store, nil
+}
+
+// parseKubeMapping parses a kubernetes_secrets entry: <pattern>=[<namespace>/]<name>
+func parseKubeMapping(entry, namespace string) (kubeMapping, error) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return kubeMapping{}, fmt.Errorf("Invalid fioconfig.kubernetes_secrets entry %q, it must be <pattern>=[<namespace>/]<name>", entry)
+	}
+	pattern, target := parts[0], parts[1]
+	if _, err := path.Match(pattern, ""); err != nil {
+		return kubeMapping{}, fmt.Errorf("Invalid fioconfig.kubernetes_secrets pattern %q: %w", pattern, err)
+	}
+	m := kubeMapping{pattern: pattern, namespace: namespace, name: target}
+	if parts := strings.SplitN(target, "/", 2); len(parts) == 2 {
+		m.namespace, m.name = parts[0], parts[1]
+	}
+	return m, nil
+}
+
+// kubeName makes a valid Secret name, a DNS subdomain, from a file name
+func kubeName(fname string) string {
+	name := []byte("fioconfig-" + strings.ToLower(fname))
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '.' && c != '-' {
+			name[i] = '-'
+		}
+	}
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return strings.TrimRight(string(name), ".-")
+}
+
+// kubeKey makes a valid Secret data key from a file name. It's the base
+// name so the file keeps its name when the Secret is mounted.
+func kubeKey(fname string) string {
+	key := []byte(path.Base(fname))
+	for i, c := range key {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '.' && c != '-' && c != '_' {
+			key[i] = '_'
+		}
+	}
+	return string(key)
+}
+
+// locate returns the namespace, Secret, and key a file is kept in
+func (s *kubeStore) locate(fname string) (string, string, string) {
+	for _, m := range s.mappings {
+		if ok, _ := path.Match(m.pattern, fname); ok {
+			return m.namespace, m.name, kubeKey(fname)
+		}
+	}
+	return s.namespace, kubeName(fname), kubeKey(fname)
+}
+
+func (s *kubeStore) url(namespace, name string) string {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets", s.server, url.PathEscape(namespace))
+	if len(name) > 0 {
+		u += "/" + url.PathEscape(name)
+	}
+	return u
+}
+
+func (s *kubeStore) do(method, url string, data interface{}) (*httpRes, error) {
+	var headers map[string]string
+	if len(s.token) > 0 {
+		headers = map[string]string{"Authorization": "Bearer " + s.token}
+	}
+	return httpDoOnce(context.Background(), s.client, method, url, headers, data)
+}
+
+func (s *kubeStore) get(namespace, name string) (*kubeSecret, error) {
+	res, err := s.do(http.MethodGet, s.url(namespace, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return nil, &os.PathError{Op: "read", Path: namespace + "/" + name, Err: os.ErrNotExist}
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to read Secret %s/%s - HTTP_%d: %s", namespace, name, res.StatusCode, res.String())
+	}
+	var secret kubeSecret
+	if err := res.Json(&secret); err != nil {
+		return nil, fmt.Errorf("Unable to parse Secret %s/%s: %w", namespace, name, err)
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string]string)
+	}
+	return &secret, nil
+}
+
+// put replaces an existing Secret. The resourceVersion from get makes the
+// API server refuse it if someone else changed the Secret in the meantime.
+func (s *kubeStore) put(namespace, name string, secret *kubeSecret) error {
+	res, err := s.do(http.MethodPut, s.url(namespace, name), secret)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Unable to update Secret %s/%s - HTTP_%d: %s", namespace, name, res.StatusCode, res.String())
+	}
+	return nil
+}
+
+func (s *kubeStore) Read(name string) ([]byte, error) {
+	ns, secretName, key := s.locate(name)
+	secret, err := s.get(ns, secretName)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+func (s *kubeStore) Write(name string, content []byte) error {
+	ns, secretName, key := s.locate(name)
+	value := base64.StdEncoding.EncodeToString(content)
+	secret, err := s.get(ns, secretName)
+	if err == nil {
+		secret.Data[key] = value
+		return s.put(ns, secretName, secret)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	secret = &kubeSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: map[string]interface{}{
+			"name":      secretName,
+			"namespace": ns,
+			"labels":    map[string]string{kubeManagedByLabel: "fioconfig"},
+		},
+		Type: "Opaque",
+		Data: map[string]string{key: value},
+	}
+	res, err := s.do(http.MethodPost, s.url(ns, ""), secret)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to create Secret %s/%s - HTTP_%d: %s", ns, secretName, res.StatusCode, res.String())
+	}
+	return nil
+}
+
+// Remove drops the file's key from its Secret. A Secret fioconfig created
+// is deleted once it has no keys left.
+func (s *kubeStore) Remove(name string) error {
+	ns, secretName, key := s.locate(name)
+	secret, err := s.get(ns, secretName)
+	if err != nil {
+		return err
+	}
+	if _, ok := secret.Data[key]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(secret.Data, key)
+	labels, _ := secret.Metadata["labels"].(map[string]interface{})
+	if len(secret.Data) > 0 || labels[kubeManagedByLabel] != "fioconfig" {
+		return s.put(ns, secretName, secret)
+	}
+	res, err := s.do(http.MethodDelete, s.url(ns, secretName), nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Unable to delete Secret %s/%s - HTTP_%d: %s", ns, secretName, res.StatusCode, res.String())
+	}
+	return nil
+}
+
+// mirrorFiles copies the extracted config to the kubernetes_mirror store.
+// Every file is compared rather than just the changed ones so Secrets
+// deleted in the cluster are put back. Failures are warnings: the files
+// are already in the secrets directory.
+func (a *App) mirrorFiles(ctx context.Context, c *committedConfig) {
+	if a.mirror == nil {
+		return
+	}
+	for _, fname := range sortedNames(c.next) {
+		content, err := c.next[fname].content()
+		if c.next[fname].Symlink {
+			// The mirror gets a copy of what it points to
+			content, err = os.ReadFile(filepath.Join(a.SecretsDir, fname))
+		}
+		if err != nil {
+			continue // stage already reported it
+		}
+		cur, err := a.mirror.Read(fname)
+		if err == nil && bytes.Equal(cur, content) {
+			zeroize(content)
+			continue
+		}
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			err = a.mirror.Write(fname, content)
+		}
+		zeroize(content)
+		if err != nil {
+			c.report.Warnings = append(c.report.Warnings, fmt.Sprintf("%s: unable to mirror to Kubernetes: %s", fname, err))
+		}
+	}
+	for _, fname := range c.removed {
+		if err := a.mirror.Remove(fname); err != nil && !errors.Is(err, os.ErrNotExist) {
+			c.report.Warnings = append(c.report.Warnings, fmt.Sprintf("%s: unable to remove from Kubernetes: %s", fname, err))
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package fioconfig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKubeServer serves the Secret endpoints of the API server, keyed by
+// "<namespace>/<name>"
+func fakeKubeServer(t *testing.T) (*httptest.Server, map[string]*kubeSecret) {
+	var lock sync.Mutex
+	secrets := make(map[string]*kubeSecret)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Header.Get("Authorization") != "Bearer sekret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+		if len(parts) < 2 || parts[1] != "secrets" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var secret kubeSecret
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			body, err := io.ReadAll(r.Body)
+			require.Nil(t, err)
+			require.Nil(t, json.Unmarshal(body, &secret))
+		}
+		switch {
+		case r.Method == http.MethodPost && len(parts) == 2:
+			secrets[parts[0]+"/"+secret.Metadata["name"].(string)] = &secret
+			w.WriteHeader(http.StatusCreated)
+		case len(parts) == 3:
+			key := parts[0] + "/" + parts[2]
+			cur, ok := secrets[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			switch r.Method {
+			case http.MethodGet:
+				require.Nil(t, json.NewEncoder(w).Encode(cur))
+			case http.MethodPut:
+				secrets[key] = &secret
+			case http.MethodDelete:
+				delete(secrets, key)
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return server, secrets
+}
+
+func writeKubeconfig(t *testing.T, server *httptest.Server, dir string) string {
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	cfg := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: default
+clusters:
+- name: default
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+users:
+- name: default
+  user:
+    token: sekret
+contexts:
+- name: default
+  context:
+    cluster: default
+    user: default
+`, server.URL, base64.StdEncoding.EncodeToString(ca))
+	path := filepath.Join(dir, "kubeconfig")
+	require.Nil(t, os.WriteFile(path, []byte(cfg), 0o600))
+	return path
+}
+
+func TestKubeStore(t *testing.T) {
+	server, secrets := fakeKubeServer(t)
+	defer server.Close()
+	settings := Settings{
+		SecretStore:         SecretStoreKubernetes,
+		Kubeconfig:          writeKubeconfig(t, server, t.TempDir()),
+		KubernetesNamespace: "apps",
+		KubernetesSecrets:   []string{"wireguard/*=vpn/wireguard"},
+	}
+	store, err := newSecretStore(settings)
+	require.Nil(t, err)
+
+	_, err = store.Read("Foo_Bar.conf")
+	require.True(t, errors.Is(err, os.ErrNotExist), err)
+	require.Nil(t, store.Write("Foo_Bar.conf", []byte("text")))
+	secret := secrets["apps/fioconfig-foo-bar.conf"]
+	require.NotNil(t, secret)
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("text")), secret.Data["Foo_Bar.conf"])
+	content, err := store.Read("Foo_Bar.conf")
+	require.Nil(t, err)
+	require.Equal(t, "text", string(content))
+
+	// Mapped files share a Secret
+	require.Nil(t, store.Write("wireguard/wg0.conf", []byte("wg0")))
+	require.Nil(t, store.Write("wireguard/wg0.key", []byte("key")))
+	require.Len(t, secrets["vpn/wireguard"].Data, 2)
+	require.Nil(t, store.Remove("wireguard/wg0.key"))
+	require.Len(t, secrets["vpn/wireguard"].Data, 1)
+	_, err = store.Read("wireguard/wg0.key")
+	require.True(t, errors.Is(err, os.ErrNotExist), err)
+
+	// Secrets fioconfig created go away with their last key
+	require.Nil(t, store.Remove("wireguard/wg0.conf"))
+	require.Nil(t, store.Remove("Foo_Bar.conf"))
+	require.Empty(t, secrets)
+	require.True(t, errors.Is(store.Remove("Foo_Bar.conf"), os.ErrNotExist))
+
+	settings.KubernetesSecrets = []string{"wireguard/*"}
+	_, err = newSecretStore(settings)
+	require.NotNil(t, err)
+}
+
+func TestKubernetesMirror(t *testing.T) {
+	server, secrets := fakeKubeServer(t)
+	defer server.Close()
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		var err error
+		app.settings.Kubeconfig = writeKubeconfig(t, server, t.TempDir())
+		app.mirror, err = newKubeStore(app.settings)
+		require.Nil(t, err)
+
+		require.Nil(t, app.Extract())
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+		require.Len(t, secrets, 4)
+		require.Equal(t, base64.StdEncoding.EncodeToString([]byte("foo file value")), secrets["default/fioconfig-foo"].Data["foo"])
+		require.Contains(t, secrets, "default/fioconfig-with-subdir-1.txt")
+
+		// Secrets deleted in the cluster are put back
+		delete(secrets, "default/fioconfig-foo")
+		require.Nil(t, app.Extract())
+		require.Contains(t, secrets, "default/fioconfig-foo")
+
+		// A failure to mirror doesn't undo the extraction
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "foo")))
+		err = app.Extract()
+		var extractErr *ExtractError
+		require.True(t, errors.As(err, &extractErr), err)
+		require.Contains(t, extractErr.Warnings[0], "unable to mirror to Kubernetes")
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+	})
+}
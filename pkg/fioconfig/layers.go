@@ -0,0 +1,284 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// config_layers adds config sources, e.g. factory-wide and group endpoints
+// or an on-prem file, that are merged with the device's own config before
+// it's extracted. They're listed lowest precedence first and a file in a
+// later layer replaces the one with the same name in an earlier layer. The
+// device's config is where "device" is listed, or last.
+//
+// URLs are fetched with the device's client at every check-in and files
+// are read then too. What was last applied of each is kept in
+// config-layers so Extract can merge them again at boot, and so files a
+// layer drops are removed.
+
+const deviceLayer = "device"
+
+type layerState struct {
+	ETag          string `json:",omitempty"`
+	SignedVersion uint64 `json:",omitempty"`
+}
+
+// configLayers is what a check-in has of each layer
+type configLayers struct {
+	sources []string
+	// Where the device's config goes among sources
+	device int
+	// What to apply and what was applied of each source. nil when the
+	// source has no config.
+	next  [][]byte
+	prev  [][]byte
+	state map[string]layerState
+}
+
+func (a *App) layersDir() string {
+	return filepath.Join(a.stateDir(), "config-layers")
+}
+
+func (a *App) layerFile(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(a.layersDir(), hex.EncodeToString(sum[:8])+".encrypted")
+}
+
+func isUrlLayer(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// loadLayers reads what was applied of each layer and, when client is set,
+// gets their current versions. It's nil when there are no layers. A layer
+// that can't be fetched keeps its applied version.
+func (a *App) loadLayers(ctx context.Context, client *http.Client) (*configLayers, error) {
+	if len(a.settings.ConfigLayers) == 0 {
+		return nil, nil
+	}
+	l := &configLayers{device: -1, state: make(map[string]layerState)}
+	for _, source := range a.settings.ConfigLayers {
+		if source == deviceLayer {
+			l.device = len(l.sources)
+		} else if isUrlLayer(source) || filepath.IsAbs(source) {
+			l.sources = append(l.sources, source)
+		} else {
+			return nil, fmt.Errorf("Invalid config layer %q: must be %q, a URL, or an absolute path", source, deviceLayer)
+		}
+	}
+	if l.device < 0 {
+		l.device = len(l.sources)
+	}
+	if buf, err := os.ReadFile(filepath.Join(a.layersDir(), "state.json")); err == nil {
+		if err := json.Unmarshal(buf, &l.state); err != nil {
+			logger.Printf("Unable to parse config layer state: %s", err)
+		}
+	}
+
+	for _, source := range l.sources {
+		prev, err := a.readCache(a.layerFile(source))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("Unable to read config layer %s: %w", source, err)
+		}
+		next := prev
+		if client != nil {
+			if next, err = a.fetchLayer(ctx, client, source, l.state, prev); err != nil {
+				LogEvent(EventLayerUnavailable, "Unable to get config layer %s, using the version applied: %s", source, err)
+				next = prev
+			}
+		}
+		l.prev = append(l.prev, prev)
+		l.next = append(l.next, next)
+	}
+	return l, nil
+}
+
+func (a *App) fetchLayer(ctx context.Context, client *http.Client, source string, state map[string]layerState, prev []byte) ([]byte, error) {
+	if !isUrlLayer(source) {
+		buf, err := os.ReadFile(source)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return buf, err
+	}
+	headers := map[string]string{"Accept": acceptPayloads}
+	addDeviceHeaders(a.sota, headers)
+	if etag := state[source].ETag; len(etag) > 0 && prev != nil {
+		headers["If-None-Match"] = etag
+	}
+	res, err := a.downloadConfigOnce(ctx, client, source, headers)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case 200:
+		signedVersion, err := a.readConfigRes(res)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSignedVersion(signedVersion, state[source].SignedVersion); err != nil {
+			return nil, err
+		}
+		state[source] = layerState{ETag: res.Header.Get("ETag"), SignedVersion: signedVersion}
+		return res.Body, nil
+	case 304:
+		return prev, nil
+	case 204:
+		delete(state, source)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("HTTP_%d: %s", res.StatusCode, res.String())
+}
+
+// changed reports whether any layer differs from what was applied
+func (l *configLayers) changed() bool {
+	if l == nil {
+		return false
+	}
+	for i := range l.sources {
+		if !bytes.Equal(l.next[i], l.prev[i]) || (l.next[i] == nil) != (l.prev[i] == nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// sha256 identifies the device's config, encrypted, merged with the layers
+// to apply
+func (l *configLayers) sha256(encrypted []byte) string {
+	h := sha256.New()
+	h.Write(encrypted)
+	for _, layer := range l.next {
+		sum := sha256.Sum256(layer)
+		h.Write(sum[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// merge layers the device's config with the layers' content
+func (l *configLayers) merge(c CryptoHandler, device ConfigStruct, layers [][]byte, decrypt bool) (ConfigStruct, error) {
+	merged := make(ConfigStruct)
+	add := func(config ConfigStruct) {
+		for fname, cfgFile := range config {
+			merged[fname] = cfgFile
+		}
+	}
+	for i := 0; i <= len(l.sources); i++ {
+		if i == l.device {
+			add(device)
+		}
+		if i == len(l.sources) || layers[i] == nil {
+			continue
+		}
+		config, err := UnmarshallBuffer(c, layers[i], decrypt)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load config layer %s: %w", l.sources[i], err)
+		}
+		add(config)
+	}
+	return merged, nil
+}
+
+// apply merges the layers into the device's config about to be extracted.
+// Its previous version gets the layers as they were applied.
+func (l *configLayers) apply(c CryptoHandler, config configSnapshot) (configSnapshot, error) {
+	if l == nil {
+		return config, nil
+	}
+	var err error
+	if config.next, err = l.merge(c, config.next, l.next, true); err != nil {
+		return config, err
+	}
+	if config.prev, err = l.merge(c, config.prev, l.prev, false); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// save records the layers' content as applied
+func (a *App) saveLayers(l *configLayers) error {
+	if l == nil {
+		return nil
+	}
+	if err := os.MkdirAll(a.layersDir(), 0o700); err != nil {
+		return err
+	}
+	for i, source := range l.sources {
+		path := a.layerFile(source)
+		if l.next[i] == nil {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		} else if err := a.writeCache(path, l.next[i]); err != nil {
+			return err
+		}
+		l.prev[i] = l.next[i]
+	}
+	buf, err := json.Marshal(l.state)
+	if err != nil {
+		return err
+	}
+	return safeWrite(filepath.Join(a.layersDir(), "state.json"), buf)
+}
+
+// applyLayers extracts the device's current config with layers that
+// changed while it didn't
+func (a *App) applyLayers(ctx context.Context, client *http.Client, crypto CryptoHandler, layers *configLayers) error {
+	LogEvent(EventLayerChanged, "Config layers changed, applying them")
+	if state := a.loadCheckInState(); !state.Reverted {
+		a.change = state.Change
+		defer func() { a.change = nil }()
+	}
+	var config configSnapshot
+	var err error
+	if config.next, err = a.unmarshallCache(crypto, a.EncryptedConfig, true); err != nil {
+		return err
+	}
+	if config.prev, err = a.unmarshallCache(crypto, a.EncryptedConfig, false); err != nil {
+		return err
+	}
+	if config, err = layers.apply(crypto, config); err != nil {
+		return err
+	}
+	encrypted, err := a.readCache(a.EncryptedConfig)
+	if err != nil {
+		return err
+	}
+	sha := layers.sha256(encrypted)
+	if a.confirmReverted(sha) {
+		a.debugf("Config layers are the ones that were reverted, not applying them again")
+		return ConfigRevertedError
+	}
+	// Only the layers are saved, so there's no config cache to make room for
+	if err = a.prepareExtract(ctx, client, config, a.currentApplyWindows(nil), nil); err != nil {
+		return err
+	}
+	report, err := a.extract(ctx, crypto, config)
+	a.metrics.recordExtract(report, err)
+	a.checkInReport = report
+	version := a.latestVersion()
+	a.audit(report, version)
+	a.publishExtract(report, err, version)
+	if a.settings.ReportStatus || len(report.Rejected) > 0 || (a.settings.PartialExtract && len(report.Failed) > 0) {
+		a.reportStatus(ctx, client, report)
+	}
+	if err != nil {
+		return &ExtractFailure{err}
+	}
+	a.clearPending()
+	if err = a.awaitConfirmation(ctx, client, crypto, config, encrypted, sha); err != nil {
+		return err
+	}
+	if err = a.saveLayers(layers); err != nil {
+		return fmt.Errorf("Unable to save config layers: %w", err)
+	}
+	return report.partialError()
+}
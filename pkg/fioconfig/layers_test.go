@@ -0,0 +1,98 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigLayers(t *testing.T) {
+	var encbuf []byte
+	factoryDown := false
+	factoryTag := `"f1"`
+	factory := `{"factory": {"Value": "factory", "Unencrypted": true}, "bar": {"Value": "factory bar", "Unencrypted": true}}`
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/factory" {
+			if factoryDown {
+				w.WriteHeader(500)
+				return
+			}
+			if r.Header.Get("If-None-Match") == factoryTag {
+				w.WriteHeader(304)
+				return
+			}
+			// Layers are served like the device's config
+			require.Contains(t, r.Header.Get("Accept"), "application/cbor")
+			var val map[string]interface{}
+			require.Nil(t, json.Unmarshal([]byte(factory), &val))
+			buf, err := cbor.Marshal(val)
+			require.Nil(t, err)
+			w.Header().Set("ETag", factoryTag)
+			w.Header().Set("Content-Type", "application/cbor")
+			_, err = w.Write(buf)
+			require.Nil(t, err)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(app.EncryptedConfig))
+
+		local := filepath.Join(tempdir, "local.json")
+		require.Nil(t, os.WriteFile(local, []byte(`{"foo": {"Value": "local foo", "Unencrypted": true}}`), 0o644))
+		app.settings.ConfigLayers = []string{app.configUrl + "/factory", deviceLayer, local}
+
+		// The device's config overrides the factory's, the local file overrides both
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertFile(t, filepath.Join(tempdir, "factory"), []byte("factory"))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("bar file value"))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("local foo"))
+		assertFile(t, filepath.Join(tempdir, "with/subdir/1.txt"), []byte("sub"))
+		require.Equal(t, NotModifiedError, app.checkin(context.Background(), client, crypto))
+
+		// A layer changing is applied while the device's config isn't
+		require.Nil(t, os.WriteFile(local, []byte(`{"local": {"Value": "local", "Unencrypted": true}}`), 0o644))
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+		assertFile(t, filepath.Join(tempdir, "local"), []byte("local"))
+		require.Equal(t, NotModifiedError, app.checkin(context.Background(), client, crypto))
+
+		// Files a layer drops are removed
+		factory = `{"bar": {"Value": "factory bar", "Unencrypted": true}}`
+		factoryTag = `"f2"`
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		assertNoFile(t, filepath.Join(tempdir, "factory"))
+		assertFile(t, filepath.Join(tempdir, "local"), []byte("local"))
+
+		// A layer that can't be fetched keeps the version applied
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "local")))
+		factoryDown = true
+		require.Equal(t, NotModifiedError, app.checkin(context.Background(), client, crypto))
+
+		// Extract merges the layers as they were applied
+		require.Nil(t, app.Extract())
+		assertFile(t, filepath.Join(tempdir, "local"), []byte("local"))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("bar file value"))
+
+		app.settings.ConfigLayers = []string{"relative/path"}
+		require.NotNil(t, app.checkin(context.Background(), client, crypto))
+	})
+}
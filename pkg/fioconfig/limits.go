@@ -0,0 +1,67 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Responses are read into memory, and compressed ones can expand by orders
+// of magnitude, so they're limited to what a device can reasonably hold.
+const defaultMaxConfigSize = 16 * 1024 * 1024
+
+// PayloadTooLargeError is returned when a response or config file is over
+// its size limit
+var PayloadTooLargeError = errors.New("Payload is too large")
+
+type responseLimitKey struct{}
+
+// withResponseLimit makes requests using ctx fail once their decoded body
+// is over limit bytes
+func withResponseLimit(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, responseLimitKey{}, limit)
+}
+
+func responseLimit(ctx context.Context) int64 {
+	if limit, ok := ctx.Value(responseLimitKey{}).(int64); ok && limit > 0 {
+		return limit
+	}
+	return defaultMaxConfigSize
+}
+
+// readLimited reads r, failing rather than reading more than limit bytes
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err == nil && int64(len(buf)) > limit {
+		return nil, fmt.Errorf("%w: over %d bytes", PayloadTooLargeError, limit)
+	}
+	return buf, err
+}
+
+// maxConfigSize returns fioconfig.max_config_size or its default
+func (a *App) maxConfigSize() int64 {
+	if a.settings.MaxConfigSize > 0 {
+		return a.settings.MaxConfigSize
+	}
+	return defaultMaxConfigSize
+}
+
+// checkFileSize enforces fioconfig.max_file_size on a config file's
+// decoded content
+func (a *App) checkFileSize(fname string, cfgFile *ConfigFile) error {
+	limit := a.settings.MaxFileSize
+	if limit <= 0 {
+		return nil
+	}
+	size := int64(len(cfgFile.Value))
+	if cfgFile.Encoding == EncodingBase64 {
+		size = int64(base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(cfgFile.Value, "="))))
+	}
+	if size > limit {
+		return fmt.Errorf("%w: %s is over fioconfig.max_file_size of %d bytes", PayloadTooLargeError, fname, limit)
+	}
+	return nil
+}
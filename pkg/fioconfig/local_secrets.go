@@ -0,0 +1,170 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Some per-device secrets, like a Wi-Fi PSK set at installation, must never
+// pass through the server. They're sealed on the device to its own key, the
+// one config values are encrypted to, so only its TPM or HSM can open them.
+// A config file refers to one with a value of `!local:<name>`, or with
+// `{{ localSecret "<name>" }}` in a template, and it's filled in at extract
+// time.
+
+const localSecretPrefix = "!local:"
+
+var localSecretName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// LocalSecretNotFoundError is returned when a config refers to a local
+// secret the device doesn't have
+var LocalSecretNotFoundError = errors.New("Local secret not found")
+
+func (a *App) localSecretsDir() string {
+	if len(a.settings.LocalSecretsDir) > 0 {
+		return a.settings.LocalSecretsDir
+	}
+	return filepath.Join(a.stateDir(), "local-secrets")
+}
+
+func (a *App) localSecretFile(name string) (string, error) {
+	if !localSecretName.MatchString(name) {
+		return "", fmt.Errorf("Invalid local secret name %q", name)
+	}
+	return filepath.Join(a.localSecretsDir(), name+".sealed"), nil
+}
+
+// SealLocalSecret encrypts value to the device's key and saves it as the
+// local secret name, replacing any it had
+func (a *App) SealLocalSecret(name string, value []byte) error {
+	path, err := a.localSecretFile(name)
+	if err != nil {
+		return err
+	}
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return err
+	}
+	defer a.releaseCrypto(crypto)
+	ec, ok := crypto.(*EciesCrypto)
+	if !ok {
+		return errors.New("Crypto handler does not expose a public key")
+	}
+	sealed, err := ec.Encrypt(string(value))
+	if err != nil {
+		return fmt.Errorf("Unable to seal local secret: %w", err)
+	}
+	if err := os.MkdirAll(a.localSecretsDir(), 0o700); err != nil {
+		return err
+	}
+	return safeWrite(path, []byte(sealed))
+}
+
+// DeleteLocalSecret removes the local secret name
+func (a *App) DeleteLocalSecret(name string) error {
+	path, err := a.localSecretFile(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", LocalSecretNotFoundError, name)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// LocalSecrets returns the names of the device's local secrets
+func (a *App) LocalSecrets() ([]string, error) {
+	entries, err := os.ReadDir(a.localSecretsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name := strings.TrimSuffix(entry.Name(), ".sealed"); name != entry.Name() && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// localSecrets opens the local secrets an extraction needs, each once
+type localSecrets struct {
+	app    *App
+	crypto CryptoHandler
+	values map[string][]byte
+}
+
+func (a *App) newLocalSecrets(crypto CryptoHandler) *localSecrets {
+	return &localSecrets{app: a, crypto: crypto, values: make(map[string][]byte)}
+}
+
+func (s *localSecrets) get(name string) ([]byte, error) {
+	if s == nil || s.crypto == nil {
+		return nil, errors.New("Local secrets are not available here")
+	}
+	if value, ok := s.values[name]; ok {
+		return value, nil
+	}
+	path, err := s.app.localSecretFile(name)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", LocalSecretNotFoundError, name)
+	} else if err != nil {
+		return nil, err
+	}
+	value, err := s.crypto.Decrypt(strings.TrimSpace(string(sealed)))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open local secret %s: %w", name, err)
+	}
+	s.values[name] = value
+	return value, nil
+}
+
+// close wipes the opened secrets from memory
+func (s *localSecrets) close() {
+	if s == nil {
+		return
+	}
+	for _, value := range s.values {
+		zeroize(value)
+	}
+	s.values = nil
+}
+
+// resolveLocalSecrets returns a copy of config with the values that refer
+// to a local secret replaced by it. Templates get theirs when rendered.
+func (a *App) resolveLocalSecrets(config ConfigStruct, secrets *localSecrets, report *ExtractReport) (ConfigStruct, error) {
+	resolved := make(ConfigStruct, len(config))
+	for _, fname := range sortedNames(config) {
+		cfgFile := config[fname]
+		resolved[fname] = cfgFile
+		if cfgFile.Template || cfgFile.Encoding != "" || !strings.HasPrefix(cfgFile.Value, localSecretPrefix) {
+			continue
+		}
+		value, err := secrets.get(strings.TrimPrefix(cfgFile.Value, localSecretPrefix))
+		if err != nil {
+			report.fail(fname, err)
+			if a.settings.PartialExtract {
+				continue // extract drops the files that failed
+			}
+			return nil, err
+		}
+		copied := *cfgFile
+		copied.Value = string(value)
+		resolved[fname] = &copied
+	}
+	return resolved, nil
+}
@@ -0,0 +1,58 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalSecrets(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		names, err := app.LocalSecrets()
+		require.Nil(t, err)
+		require.Empty(t, names)
+
+		require.Nil(t, app.SealLocalSecret("wifi-psk", []byte("hunter2")))
+		sealed, err := os.ReadFile(filepath.Join(tempdir, "local-secrets", "wifi-psk.sealed"))
+		require.Nil(t, err)
+		require.False(t, bytes.Contains(sealed, []byte("hunter2")))
+		require.NotNil(t, app.SealLocalSecret("../escape", []byte("x")))
+		names, err = app.LocalSecrets()
+		require.Nil(t, err)
+		require.Equal(t, []string{"wifi-psk"}, names)
+
+		config := ConfigStruct{
+			"psk":      {Value: "!local:wifi-psk"},
+			"wpa.conf": {Value: `psk="{{ localSecret "wifi-psk" }}"`, Template: true},
+			// base64 of "!local:wifi-psk", which isn't resolved
+			"literal": {Value: "IWxvY2FsOndpZmktcHNr", Encoding: EncodingBase64},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertFile(t, filepath.Join(tempdir, "psk"), []byte("hunter2"))
+		assertFile(t, filepath.Join(tempdir, "wpa.conf"), []byte(`psk="hunter2"`))
+		assertFile(t, filepath.Join(tempdir, "literal"), []byte("!local:wifi-psk"))
+
+		// A secret the device doesn't have fails the extraction
+		missing := ConfigStruct{"psk": {Value: "!local:other"}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{config, missing})
+		require.True(t, errors.Is(err, LocalSecretNotFoundError), err)
+		assertFile(t, filepath.Join(tempdir, "psk"), []byte("hunter2"))
+
+		require.Nil(t, app.DeleteLocalSecret("wifi-psk"))
+		require.True(t, errors.Is(app.DeleteLocalSecret("wifi-psk"), LocalSecretNotFoundError))
+		names, err = app.LocalSecrets()
+		require.Nil(t, err)
+		require.Empty(t, names)
+	})
+}
@@ -0,0 +1,249 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log message
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLogLevel converts the name of a level, e.g. "warn", to a LogLevel
+func ParseLogLevel(name string) (LogLevel, error) {
+	name = strings.ToLower(name)
+	if name == "warning" {
+		name = "warn"
+	}
+	for i, n := range levelNames {
+		if n == name {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("Invalid log level: %s", name)
+}
+
+// LogFormat selects how log messages are written
+type LogFormat string
+
+const (
+	// LogFormatText is the classic free-form output of the standard logger
+	LogFormatText LogFormat = "text"
+	// LogFormatJSON writes one JSON object per message
+	LogFormatJSON LogFormat = "json"
+	// LogFormatLogfmt writes one line of key=value pairs per message
+	LogFormatLogfmt LogFormat = "logfmt"
+	// LogFormatJournal sends messages to the systemd journal with their
+	// priority and fields
+	LogFormatJournal LogFormat = "journal"
+)
+
+// LogFields are the structured data attached to a log message, such as
+// the file or HTTP status it's about. Text logs leave them out.
+type LogFields map[string]interface{}
+
+type structLogger struct {
+	lock   sync.Mutex
+	std    *log.Logger // Used by LogFormatText
+	out    io.Writer   // Used by the other formats
+	format LogFormat
+	level  LogLevel
+}
+
+// logger is where all of the package's logs go. See WithLogger and
+// SetLogOutput.
+var logger = &structLogger{std: log.Default(), format: LogFormatText, level: LevelInfo}
+
+// SetLogOutput sends the package's logs to w in the given format, skipping
+// messages below level. Logging is shared by the whole package, so this
+// applies to every App in the process.
+func SetLogOutput(w io.Writer, format LogFormat, level LogLevel) error {
+	switch format {
+	case LogFormatText, LogFormatJSON, LogFormatLogfmt, LogFormatJournal:
+	default:
+		return fmt.Errorf("Invalid log format: %s", format)
+	}
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+	logger.std = log.New(w, "", log.LstdFlags)
+	logger.out = w
+	logger.format = format
+	logger.level = level
+	return nil
+}
+
+// useStdLogger sends text logs to l, keeping the current level
+func (l *structLogger) useStdLogger(std *log.Logger) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.std = std
+	l.format = LogFormatText
+}
+
+// Logf logs a message at the given level. It's for programs built on this
+// package that want their logs to match fioconfig's.
+func Logf(level LogLevel, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if prefix := levelPrefixes[level].prefix; levelOf(msg) != level && len(prefix) > 0 {
+		msg = prefix + " " + msg
+	}
+	logger.log(level, "", nil, msg)
+}
+
+// LogEvent logs a message prefixed with its event code
+func LogEvent(code EventCode, format string, v ...interface{}) {
+	LogEventWith(code, nil, format, v...)
+}
+
+// LogEventWith is LogEvent with structured fields for log aggregation
+func LogEventWith(code EventCode, fields LogFields, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	level := levelOf(msg)
+	if l, ok := eventLevels[code]; ok && l > level {
+		level = l
+	}
+	logger.log(level, code, fields, msg)
+}
+
+// Printf logs at the level given by the message's "ERROR:" or "WARNING:"
+// prefix, or info when it has neither.
+func (l *structLogger) Printf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	l.log(levelOf(msg), "", nil, msg)
+}
+
+func (l *structLogger) Println(v ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+	l.log(levelOf(msg), "", nil, msg)
+}
+
+func (l *structLogger) Print(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	l.log(levelOf(msg), "", nil, msg)
+}
+
+// The first levelPrefixes are indexed by level for the prefix text logs
+// are given
+var levelPrefixes = []struct {
+	prefix string
+	level  LogLevel
+}{
+	{"DEBUG:", LevelDebug},
+	{"", LevelInfo},
+	{"WARNING:", LevelWarn},
+	{"ERROR:", LevelError},
+	{"WARN:", LevelWarn},
+}
+
+// levelOf returns the level a message's prefix asks for. The prefix is
+// kept in text logs, which people grep for it, but not structured ones.
+func levelOf(msg string) LogLevel {
+	for _, p := range levelPrefixes {
+		if len(p.prefix) > 0 && strings.HasPrefix(msg, p.prefix) {
+			return p.level
+		}
+	}
+	return LevelInfo
+}
+
+func (l *structLogger) log(level LogLevel, code EventCode, fields LogFields, msg string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if level < l.level {
+		return
+	}
+	if l.format == LogFormatText || l.format == LogFormatJournal {
+		if len(code) > 0 {
+			msg = fmt.Sprintf("%s %s", code, msg)
+		}
+		if l.format == LogFormatJournal && sendJournal(level, code, fields, msg) == nil {
+			return
+		}
+		l.std.Print(msg)
+		return
+	}
+	for _, p := range levelPrefixes {
+		if p.level == level && len(p.prefix) > 0 && strings.HasPrefix(msg, p.prefix) {
+			msg = strings.TrimSpace(msg[len(p.prefix):])
+			break
+		}
+	}
+	record := LogFields{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	if len(code) > 0 {
+		record["event"] = string(code)
+	}
+	for k, v := range fields {
+		if _, reserved := record[k]; !reserved {
+			record[k] = v
+		}
+	}
+	var line []byte
+	if l.format == LogFormatJSON {
+		var err error
+		if line, err = json.Marshal(record); err != nil {
+			line = []byte(strconv.Quote(msg))
+		}
+	} else {
+		line = logfmt(record)
+	}
+	_, _ = l.out.Write(append(line, '\n'))
+}
+
+// logfmt writes time, level, event, and msg first so lines read naturally,
+// and then the other fields in sorted order.
+func logfmt(record LogFields) []byte {
+	keys := []string{"time", "level", "event", "msg"}
+	var extra []string
+	for k := range record {
+		switch k {
+		case "time", "level", "event", "msg":
+		default:
+			extra = append(extra, k)
+		}
+	}
+	sort.Strings(extra)
+	var sb strings.Builder
+	for _, k := range append(keys, extra...) {
+		v, ok := record[k]
+		if !ok {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		val := fmt.Sprint(v)
+		if len(val) == 0 || strings.ContainsAny(val, " =\"\t\n") {
+			val = strconv.Quote(val)
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(val)
+	}
+	return []byte(sb.String())
+}
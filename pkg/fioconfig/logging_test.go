@@ -0,0 +1,78 @@
+package fioconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func captureLogs(t *testing.T, format LogFormat, level LogLevel) *bytes.Buffer {
+	var buf bytes.Buffer
+	require.Nil(t, SetLogOutput(&buf, format, level))
+	t.Cleanup(func() {
+		require.Nil(t, SetLogOutput(log.Writer(), LogFormatText, LevelInfo))
+		logger.useStdLogger(log.Default())
+	})
+	return &buf
+}
+
+func TestLogJSON(t *testing.T) {
+	buf := captureLogs(t, LogFormatJSON, LevelInfo)
+
+	LogEventWith(EventFileExtracted, LogFields{"file": "foo", "msg": "ignored"}, "Extracting %s", "foo")
+	logger.Printf("ERROR: Unable to do it: %s", "boom")
+	Logf(LevelDebug, "Not logged")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var record map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &record))
+	require.Equal(t, "info", record["level"])
+	require.Equal(t, string(EventFileExtracted), record["event"])
+	require.Equal(t, "Extracting foo", record["msg"])
+	require.Equal(t, "foo", record["file"])
+	require.NotEmpty(t, record["time"])
+
+	record = nil
+	require.Nil(t, json.Unmarshal([]byte(lines[1]), &record))
+	require.Equal(t, "error", record["level"])
+	require.Equal(t, "Unable to do it: boom", record["msg"])
+	require.Nil(t, record["event"])
+}
+
+func TestLogLogfmt(t *testing.T) {
+	buf := captureLogs(t, LogFormatLogfmt, LevelWarn)
+
+	LogEvent(EventFileExtracted, "Not logged")
+	LogEventWith(EventHandlerFailed, LogFields{"file": "foo bar", "status": 2}, "Unable to run command")
+
+	line := strings.TrimSpace(buf.String())
+	require.Regexp(t, `^time=\S+ level=error event=FIO-3002 msg="Unable to run command" file="foo bar" status=2$`, line)
+}
+
+func TestLogText(t *testing.T) {
+	var buf bytes.Buffer
+	logger.useStdLogger(log.New(&buf, "", 0))
+	t.Cleanup(func() { logger.useStdLogger(log.Default()) })
+
+	LogEventWith(EventFileExtracted, LogFields{"file": "foo"}, "Extracting %s", "foo")
+	Logf(LevelError, "Unable to do it")
+	Logf(LevelWarn, "WARNING: Already prefixed")
+	require.Equal(t, "FIO-2001 Extracting foo\nERROR: Unable to do it\nWARNING: Already prefixed\n", buf.String())
+}
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("WARNING")
+	require.Nil(t, err)
+	require.Equal(t, LevelWarn, level)
+	level, err = ParseLogLevel("debug")
+	require.Nil(t, err)
+	require.Equal(t, LevelDebug, level)
+	_, err = ParseLogLevel("loud")
+	require.NotNil(t, err)
+	require.NotNil(t, SetLogOutput(&bytes.Buffer{}, "xml", LevelInfo))
+}
@@ -0,0 +1,67 @@
+package fioconfig
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// manifest tracks the sha256 of the value fioconfig last wrote for each file
+type manifest map[string]string
+
+// manifestState is what's saved to disk. The secrets directory is recorded
+// so files can be migrated if it changes.
+type manifestState struct {
+	SecretsDir string   `json:"secrets-dir"`
+	Files      manifest `json:"files"`
+	// Files the last full extraction found aren't in the config anymore,
+	// see CollectGarbage
+	Orphans []string `json:"orphans,omitempty"`
+}
+
+func sha256Hex(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+func (a *App) manifestFile() string {
+	return filepath.Join(a.stateDir(), "manifest.json")
+}
+
+func (a *App) readManifest() manifestState {
+	var state manifestState
+	buf, err := os.ReadFile(a.manifestFile())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to read manifest: %s", err)
+		}
+		state.Files = make(manifest)
+		return state
+	}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		logger.Printf("Unable to parse manifest: %s", err)
+	}
+	if state.Files == nil {
+		// Older versions saved just the file hashes
+		state.Files = make(manifest)
+		if err := json.Unmarshal(buf, &state.Files); err != nil {
+			logger.Printf("Unable to parse manifest: %s", err)
+		}
+	}
+	return state
+}
+
+func (a *App) loadManifest() manifest {
+	return a.readManifest().Files
+}
+
+func (a *App) saveManifest(state manifestState) error {
+	state.SecretsDir = a.SecretsDir
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return safeWrite(a.manifestFile(), buf)
+}
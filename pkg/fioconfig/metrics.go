@@ -0,0 +1,153 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics are the counters and gauges exported in the Prometheus text
+// format for fleet observability
+type metrics struct {
+	lock sync.Mutex
+
+	checkInsAttempted   int
+	checkInsSucceeded   int
+	checkInsNotModified int
+	extractFailures     int
+	handlerFailures     int
+	lastCheckIn         time.Time
+	certExpiry          time.Time
+}
+
+func (m *metrics) recordCheckIn(err error, client *http.Client) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.checkInsAttempted++
+	if err == nil || errors.Is(err, NotModifiedError) || errors.Is(err, PartialExtractError) {
+		m.checkInsSucceeded++
+		m.lastCheckIn = time.Now()
+	}
+	if errors.Is(err, NotModifiedError) {
+		m.checkInsNotModified++
+	}
+	if client != nil {
+		if cert := clientCert(client); cert != nil {
+			m.certExpiry = cert.NotAfter
+		}
+	}
+}
+
+func (m *metrics) recordExtract(report *ExtractReport, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err != nil || report == nil || len(report.Failed) > 0 {
+		m.extractFailures++
+	}
+	if report == nil {
+		return
+	}
+	handlers := report.Handlers
+	if report.AfterExtract != nil {
+		handlers = append(handlers, *report.AfterExtract)
+	}
+	for _, h := range handlers {
+		if h.ExitCode != 0 && h.ExitCode != onChangedForceExit {
+			m.handlerFailures++
+		}
+	}
+}
+
+// WriteMetrics writes the App's metrics in the Prometheus text format
+func (a *App) WriteMetrics(w io.Writer) error {
+	m := &a.metrics
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var sb strings.Builder
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+	}
+	metric("fioconfig_checkins_attempted_total", "counter", "Check-ins attempted", float64(m.checkInsAttempted))
+	metric("fioconfig_checkins_succeeded_total", "counter", "Check-ins the server accepted", float64(m.checkInsSucceeded))
+	metric("fioconfig_checkins_not_modified_total", "counter", "Check-ins where the config was unchanged", float64(m.checkInsNotModified))
+	metric("fioconfig_extract_failures_total", "counter", "Config extractions that failed", float64(m.extractFailures))
+	metric("fioconfig_handler_failures_total", "counter", "On-changed handlers that failed", float64(m.handlerFailures))
+	if !m.lastCheckIn.IsZero() {
+		metric("fioconfig_last_checkin_success_seconds", "gauge", "Seconds since the last successful check-in",
+			time.Since(m.lastCheckIn).Seconds())
+	}
+	if !m.certExpiry.IsZero() {
+		metric("fioconfig_client_cert_expiry_days", "gauge", "Days until the client certificate expires",
+			time.Until(m.certExpiry).Hours()/24)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// writeMetricsFile updates the node_exporter textfile when one is set.
+// The collector reads the directory at any time, so the file is replaced
+// atomically.
+func (a *App) writeMetricsFile() {
+	path := a.settings.MetricsTextfile
+	if len(path) == 0 {
+		return
+	}
+	var sb strings.Builder
+	if err := a.WriteMetrics(&sb); err != nil {
+		logger.Printf("ERROR: Unable to generate metrics: %s", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		logger.Printf("ERROR: Unable to create metrics directory: %s", err)
+		return
+	}
+	// Not safeWrite, since the collector may not run as our user
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0o644); err != nil {
+		logger.Printf("ERROR: Unable to write metrics to %s: %s", path, err)
+	} else if err := os.Rename(tmp, path); err != nil {
+		logger.Printf("ERROR: Unable to write metrics to %s: %s", path, err)
+	}
+}
+
+// StartMetricsServer serves the App's metrics at /metrics on the address
+// set by metrics_listen. It's a no-op when that isn't set. The returned
+// function stops the server.
+func (a *App) StartMetricsServer() (func(), error) {
+	addr := a.settings.MetricsListen
+	if len(addr) == 0 {
+		return func() {}, nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen for metrics requests: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := a.WriteMetrics(w); err != nil {
+			logger.Printf("ERROR: Unable to write metrics: %s", err)
+		}
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Printf("ERROR: Metrics server stopped: %s", err)
+		}
+	}()
+	logger.Printf("Serving metrics on http://%s/metrics", listener.Addr())
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,57 @@
+package fioconfig
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	status := http.StatusNotModified
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		textfile := filepath.Join(tempdir, "textfile", "fioconfig.prom")
+		app.settings.MetricsTextfile = textfile
+
+		require.True(t, errors.Is(app.CheckIn(), NotModifiedError))
+		status = http.StatusNotFound
+		require.NotNil(t, app.CheckIn())
+
+		buf, err := os.ReadFile(textfile)
+		require.Nil(t, err)
+		st, err := os.Stat(textfile)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0o644), st.Mode().Perm())
+		text := string(buf)
+		require.Contains(t, text, "# TYPE fioconfig_checkins_attempted_total counter\nfioconfig_checkins_attempted_total 2\n")
+		require.Contains(t, text, "\nfioconfig_checkins_succeeded_total 1\n")
+		require.Contains(t, text, "\nfioconfig_checkins_not_modified_total 1\n")
+		require.Contains(t, text, "\nfioconfig_extract_failures_total 0\n")
+		require.Regexp(t, regexp.MustCompile(`(?m)^fioconfig_last_checkin_success_seconds [0-9.e-]+$`), text)
+		require.Regexp(t, regexp.MustCompile(`(?m)^fioconfig_client_cert_expiry_days [0-9.e+]+$`), text)
+
+		app.metrics.recordExtract(&ExtractReport{
+			Failed:   map[string]string{"foo": "failed"},
+			Handlers: []HandlerResult{{ExitCode: 1}, {ExitCode: 0}, {ExitCode: onChangedForceExit}},
+		}, nil)
+
+		app.settings.MetricsListen = "127.0.0.1:0"
+		stop, err := app.StartMetricsServer()
+		require.Nil(t, err)
+		stop()
+
+		var sb strings.Builder
+		require.Nil(t, app.WriteMetrics(&sb))
+		require.Contains(t, sb.String(), "\nfioconfig_extract_failures_total 1\n")
+		require.Contains(t, sb.String(), "\nfioconfig_handler_failures_total 1\n")
+	})
+}
@@ -0,0 +1,84 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// migrateSecretsDir moves the files fioconfig manages from the secrets
+// directory recorded in the manifest to the current one, e.g. after an image
+// upgrade changes where secrets live. Each file is moved atomically and
+// files already moved are skipped, so an interrupted migration is finished
+// by the next extraction. It returns the files that moved so their handlers
+// can be rerun.
+func (a *App) migrateSecretsDir(oldDir string, applied manifest) (map[string]bool, error) {
+	migrated := make(map[string]bool)
+	if a.store != nil || len(oldDir) == 0 || filepath.Clean(oldDir) == filepath.Clean(a.SecretsDir) {
+		return migrated, nil
+	}
+	if _, err := os.Stat(oldDir); errors.Is(err, os.ErrNotExist) {
+		return migrated, nil
+	}
+	LogEvent(EventSecretsDirMigrated, "Secrets directory changed from %s to %s, migrating files", oldDir, a.SecretsDir)
+	st, err := os.Stat(a.SecretsDir)
+	if err != nil {
+		return migrated, err
+	}
+	for fname := range applied {
+		src := filepath.Join(oldDir, fname)
+		dst := filepath.Join(a.SecretsDir, fname)
+		if _, err := os.Lstat(src); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), st.Mode()); err != nil {
+			return migrated, fmt.Errorf("Unable to create parent directory for %s: %w", dst, err)
+		}
+		if err := moveFile(src, dst); err != nil {
+			return migrated, fmt.Errorf("Unable to migrate %s: %w", src, err)
+		}
+		migrated[fname] = true
+		// Only clean up directories fioconfig created
+		for dir := filepath.Dir(src); dir != filepath.Clean(oldDir); dir = filepath.Dir(dir) {
+			if err := os.Remove(dir); err != nil {
+				break
+			}
+		}
+	}
+	if err := os.Remove(oldDir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Not empty, so something other than fioconfig uses it
+		LogEvent(EventSecretsDirMigrated, "Leaving old secrets directory %s in place: %s", oldDir, err)
+	}
+	return migrated, nil
+}
+
+// moveFile renames src to dst, falling back to a copy when they're on
+// different filesystems. The copy keeps src's mode and owner.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	meta := fileMeta{st.Mode().Perm(), -1, -1}
+	if uid, gid, ok := fileOwner(st); ok {
+		meta.uid, meta.gid = uid, gid
+	}
+	if err := safeWriteMeta(dst, data, meta); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
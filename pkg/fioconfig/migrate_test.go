@@ -0,0 +1,61 @@
+package fioconfig
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateSecretsDir(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		oldDir := filepath.Join(tempdir, "old")
+		require.Nil(t, os.Mkdir(oldDir, 0o750))
+		app.SecretsDir = oldDir
+		require.Nil(t, app.Extract())
+		require.Equal(t, oldDir, app.readManifest().SecretsDir)
+		barChanged := filepath.Join(tempdir, "bar-changed")
+		require.Nil(t, os.Remove(barChanged))
+
+		newDir := filepath.Join(tempdir, "new")
+		require.Nil(t, os.Mkdir(newDir, 0o750))
+		app.SecretsDir = newDir
+		require.Nil(t, app.Extract())
+
+		assertFile(t, filepath.Join(newDir, "foo"), []byte("foo file value"))
+		assertFile(t, filepath.Join(newDir, "bar"), []byte("bar file value"))
+		assertFile(t, filepath.Join(newDir, "with/subdir/1.txt"), []byte("sub"))
+		assertNoFile(t, oldDir)
+		require.Equal(t, newDir, app.readManifest().SecretsDir)
+		// The handler learns the file moved
+		assertFile(t, barChanged, nil)
+	})
+}
+
+func TestMigrateSecretsDirKeepsForeignFiles(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		oldDir := filepath.Join(tempdir, "old")
+		require.Nil(t, os.Mkdir(oldDir, 0o750))
+		app.SecretsDir = oldDir
+		require.Nil(t, app.Extract())
+		other := filepath.Join(oldDir, "not-ours")
+		require.Nil(t, os.WriteFile(other, []byte("x"), 0o640))
+
+		app.SecretsDir = filepath.Join(tempdir, "new")
+		require.Nil(t, os.Mkdir(app.SecretsDir, 0o750))
+		require.Nil(t, app.Extract())
+		assertFile(t, other, []byte("x"))
+		assertNoFile(t, filepath.Join(oldDir, "foo"))
+	})
+}
+
+func TestLegacyManifest(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		require.Nil(t, os.WriteFile(app.manifestFile(), []byte(`{"foo": "abc"}`), 0o640))
+		state := app.readManifest()
+		require.Equal(t, "", state.SecretsDir)
+		require.Equal(t, manifest{"foo": "abc"}, state.Files)
+	})
+}
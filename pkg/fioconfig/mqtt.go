@@ -0,0 +1,83 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// StartMqttNotifications subscribes to the MQTT topic configured in
+// sota.toml. The backend publishes to this topic when the device's config
+// changes, so each message is forwarded to the returned channel to let the
+// daemon check in right away. The returned channel is nil when no broker is
+// configured. The stop function must be called to disconnect.
+func (a *App) StartMqttNotifications() (<-chan struct{}, func(), error) {
+	broker := a.settings.MqttBroker
+	if len(broker) == 0 {
+		return nil, func() {}, nil
+	}
+	topic := a.settings.MqttTopic
+	if len(topic) == 0 {
+		return nil, nil, errors.New("fioconfig.mqtt_broker is set but fioconfig.mqtt_topic is not")
+	}
+
+	// The crypto handler needs to stay open for the life of the connection
+	// since a PKCS#11 key is used for the TLS handshake. The client comes
+	// from the same place as the check-ins' so the broker is held to the
+	// same pins and revocation checks.
+	client, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		a.releaseCrypto(crypto)
+		return nil, nil, errors.New("Unable to use the HTTP client's TLS config for MQTT")
+	}
+	tlsConfig := transport.TLSClientConfig.Clone()
+
+	clientId := deviceId(client)
+	if len(clientId) == 0 {
+		clientId = "fioconfig"
+	}
+
+	notify := make(chan struct{}, 1)
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientId).
+		SetTLSConfig(tlsConfig).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(5 * time.Minute)
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		// Subscriptions don't survive a reconnect with a clean session
+		token := c.Subscribe(topic, 1, func(c mqtt.Client, msg mqtt.Message) {
+			LogEvent(EventNotification, "Config change notification received on %s", msg.Topic())
+			select {
+			case notify <- struct{}{}:
+			default: // A check-in is already pending
+			}
+		})
+		if token.Wait() && token.Error() != nil {
+			logger.Printf("Unable to subscribe to %s: %s", topic, token.Error())
+		}
+	})
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		LogEvent(EventMqttLost, "MQTT connection lost: %s", err)
+	})
+
+	mc := mqtt.NewClient(opts)
+	if token := mc.Connect(); token.Wait() && token.Error() != nil {
+		a.releaseCrypto(crypto)
+		return nil, nil, fmt.Errorf("Unable to connect to MQTT broker %s: %w", broker, token.Error())
+	}
+	logger.Printf("Listening for config change notifications on %s", topic)
+
+	stop := func() {
+		mc.Disconnect(250)
+		a.releaseCrypto(crypto)
+	}
+	return notify, stop, nil
+}
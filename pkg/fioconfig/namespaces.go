@@ -0,0 +1,154 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Namespace is a top-level directory of the secrets directory that one app
+// owns, e.g. the "web" namespace is every file named "web/...". Its files
+// default to the namespace's owner and its on-changed command runs once
+// for all of them, so several compose apps can each own a slice of the
+// config without colliding or restarting each other.
+type Namespace struct {
+	// Owner of the directory, and of its files that don't set their own
+	Uid *int `toml:"uid"`
+	Gid *int `toml:"gid"`
+	// Octal permissions of the directory, e.g. "0750"
+	Mode string `toml:"mode"`
+	// Run once after an extraction that changed or removed any of its
+	// files, with them in $CHANGED_FILES and $REMOVED_FILES
+	OnChanged []string `toml:"on_changed"`
+	// Users and groups besides its owner that can read its files through
+	// query_socket
+	QueryUids []int `toml:"query_uids"`
+	QueryGids []int `toml:"query_gids"`
+}
+
+// namespaceOf returns the configured namespace a file is in, if any
+func (a *App) namespaceOf(fname string) (string, bool) {
+	parts := strings.SplitN(fname, "/", 2)
+	if len(parts) < 2 {
+		return "", false
+	}
+	_, ok := a.settings.Namespaces[parts[0]]
+	return parts[0], ok
+}
+
+func (ns Namespace) dirMeta() (fileMeta, error) {
+	meta := fileMeta{0, -1, -1}
+	if len(ns.Mode) > 0 {
+		mode, err := strconv.ParseUint(ns.Mode, 8, 32)
+		if err != nil || mode > 0o777 {
+			return meta, fmt.Errorf("Invalid mode %q", ns.Mode)
+		}
+		meta.mode = os.FileMode(mode)
+	}
+	if ns.Uid != nil {
+		meta.uid = *ns.Uid
+	}
+	if ns.Gid != nil {
+		meta.gid = *ns.Gid
+	}
+	return meta, nil
+}
+
+// applyNamespaces gives the files of each namespace its owner unless they
+// set their own, leaving config as it is
+func (a *App) applyNamespaces(config ConfigStruct) (ConfigStruct, error) {
+	if len(a.settings.Namespaces) == 0 {
+		return config, nil
+	}
+	for name, ns := range a.settings.Namespaces {
+		if err := validateFileName(name); err != nil || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("Invalid namespace name %q", name)
+		}
+		if _, err := ns.dirMeta(); err != nil {
+			return nil, fmt.Errorf("Invalid namespace %s: %w", name, err)
+		}
+	}
+	next := make(ConfigStruct, len(config))
+	for fname, cfgFile := range config {
+		next[fname] = cfgFile
+		name, ok := a.namespaceOf(fname)
+		if !ok {
+			continue
+		}
+		ns := a.settings.Namespaces[name]
+		if cfgFile.Symlink {
+			continue // Links have no owner of their own
+		}
+		if (ns.Uid == nil || cfgFile.Uid != nil) && (ns.Gid == nil || cfgFile.Gid != nil) {
+			continue
+		}
+		copied := *cfgFile
+		if copied.Uid == nil {
+			copied.Uid = ns.Uid
+		}
+		if copied.Gid == nil {
+			copied.Gid = ns.Gid
+		}
+		next[fname] = &copied
+	}
+	return next, nil
+}
+
+// fixNamespaceDirs gives the namespace directories that exist their owner
+// and mode
+func (a *App) fixNamespaceDirs(report *ExtractReport) {
+	if a.store != nil {
+		return // Not a filesystem
+	}
+	for name, ns := range a.settings.Namespaces {
+		meta, _ := ns.dirMeta() // applyNamespaces already checked it
+		if meta == (fileMeta{0, -1, -1}) {
+			continue
+		}
+		dir := filepath.Join(a.SecretsDir, name)
+		if err := meta.apply(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("Namespace %s: %s", name, err))
+		}
+	}
+}
+
+// runNamespaceHandlers runs the on-changed command of each namespace with
+// changed or removed files
+func (a *App) runNamespaceHandlers(ctx context.Context, report *ExtractReport) []*HandlerResult {
+	changed := make(map[string][]string)
+	removed := make(map[string][]string)
+	for _, fname := range report.Applied {
+		if name, ok := a.namespaceOf(fname); ok {
+			changed[name] = append(changed[name], fname)
+		}
+	}
+	for _, fname := range report.Removed {
+		if name, ok := a.namespaceOf(fname); ok {
+			removed[name] = append(removed[name], fname)
+		}
+	}
+	names := make([]string, 0, len(a.settings.Namespaces))
+	for name := range a.settings.Namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var results []*HandlerResult
+	for _, name := range names {
+		command := a.settings.Namespaces[name].OnChanged
+		if len(command) == 0 || len(changed[name])+len(removed[name]) == 0 {
+			continue
+		}
+		env := []string{"NAMESPACE=" + name, "NAMESPACE_DIR=" + filepath.Join(a.SecretsDir, name)}
+		result := a.runAggregate(ctx, "namespace "+name+" command", command, changed[name], removed[name], env)
+		result.File = name + "/"
+		result.Files = append(changed[name][:len(changed[name]):len(changed[name])], removed[name]...)
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,90 @@
+package fioconfig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaces(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		out := filepath.Join(t.TempDir(), "web-hook")
+		uid := os.Getuid()
+		app.settings.Namespaces = map[string]Namespace{
+			"web": {
+				Uid:       &uid,
+				Mode:      "0750",
+				OnChanged: []string{"/bin/sh", "-c", `echo "$NAMESPACE $NAMESPACE_DIR [$CHANGED_FILES] [$REMOVED_FILES]" >> ` + out},
+			},
+			"db": {},
+		}
+
+		config := ConfigStruct{
+			"web/a": {Value: "a"},
+			"web/b": {Value: "b"},
+			"db/x":  {Value: "x"},
+			"top":   {Value: "top"},
+		}
+		report, err := app.extract(context.Background(), nil, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertFile(t, out, []byte("web "+filepath.Join(tempdir, "web")+" [web/a\nweb/b] []\n"))
+		st, err := os.Stat(filepath.Join(tempdir, "web"))
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0o750), st.Mode().Perm())
+		var nsResult *HandlerResult
+		for i, h := range report.Handlers {
+			if h.File == "web/" {
+				nsResult = &report.Handlers[i]
+			}
+		}
+		require.NotNil(t, nsResult)
+		require.Equal(t, 0, nsResult.ExitCode)
+		require.Equal(t, []string{"web/a", "web/b"}, nsResult.Files)
+
+		// Files outside the namespace don't run its command
+		next := ConfigStruct{
+			"web/a": config["web/a"],
+			"web/b": config["web/b"],
+			"db/x":  {Value: "changed"},
+			"top":   config["top"],
+		}
+		_, err = app.extract(context.Background(), nil, configSnapshot{config, next})
+		require.Nil(t, err)
+		assertFile(t, out, []byte("web "+filepath.Join(tempdir, "web")+" [web/a\nweb/b] []\n"))
+
+		last := ConfigStruct{
+			"web/a": config["web/a"],
+			"db/x":  next["db/x"],
+		}
+		require.Nil(t, os.Remove(out))
+		_, err = app.extract(context.Background(), nil, configSnapshot{next, last})
+		require.Nil(t, err)
+		assertFile(t, out, []byte("web "+filepath.Join(tempdir, "web")+" [] [web/b]\n"))
+
+		app.settings.Namespaces["bad/name"] = Namespace{}
+		_, err = app.extract(context.Background(), nil, configSnapshot{last, last})
+		require.NotNil(t, err)
+	})
+}
+
+func TestApplyNamespaces(t *testing.T) {
+	uid, gid, own := 1000, 1001, 0
+	app := &App{settings: Settings{Namespaces: map[string]Namespace{"web": {Uid: &uid, Gid: &gid}}}}
+	config := ConfigStruct{
+		"web/a":   {Value: "a"},
+		"web/own": {Value: "own", Uid: &own},
+		"webapp":  {Value: "not in it"},
+	}
+	next, err := app.applyNamespaces(config)
+	require.Nil(t, err)
+	require.Equal(t, uid, *next["web/a"].Uid)
+	require.Equal(t, gid, *next["web/a"].Gid)
+	require.Equal(t, own, *next["web/own"].Uid)
+	require.Equal(t, gid, *next["web/own"].Gid)
+	require.Nil(t, next["webapp"].Uid)
+	require.Nil(t, config["web/a"].Uid)
+}
@@ -0,0 +1,224 @@
+package fioconfig
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Config files with NetworkManager set are NetworkManager keyfiles, e.g. a
+// Wi-Fi network or an LTE APN. fioconfig installs them in
+// nm_connections_dir, has NetworkManager load them over D-Bus, and brings
+// the connection up. Removing the file deletes the connection.
+
+// DefaultNMConnectionsDir is where NetworkManager reads keyfiles from
+const DefaultNMConnectionsDir = "/etc/NetworkManager/system-connections"
+
+const (
+	nmBusName           = "org.freedesktop.NetworkManager"
+	nmPath              = dbus.ObjectPath("/org/freedesktop/NetworkManager")
+	nmSettingsPath      = dbus.ObjectPath("/org/freedesktop/NetworkManager/Settings")
+	nmSettingsInterface = "org.freedesktop.NetworkManager.Settings"
+)
+
+// ConnectionResult is the outcome of applying a NetworkManager profile
+type ConnectionResult struct {
+	File   string `json:"file"`
+	UUID   string `json:"uuid"`
+	Action string `json:"action"` // "activate", "load", or "delete"
+	Error  string `json:"error,omitempty"`
+}
+
+// nmSettings is the part of the NetworkManager D-Bus API used for profiles
+type nmSettings interface {
+	LoadConnections(paths []string) error
+	Activate(uuid string) error
+	Delete(uuid string) error
+	Close()
+}
+
+var newNMSettings = func() (nmSettings, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+	return nmBus{conn}, nil
+}
+
+type nmBus struct {
+	conn *dbus.Conn
+}
+
+func (b nmBus) LoadConnections(paths []string) error {
+	var ok bool
+	var failures []string
+	err := b.conn.Object(nmBusName, nmSettingsPath).Call(nmSettingsInterface+".LoadConnections", 0, paths).Store(&ok, &failures)
+	if err == nil && len(failures) > 0 {
+		err = fmt.Errorf("NetworkManager was unable to load %s", strings.Join(failures, ", "))
+	}
+	return err
+}
+
+func (b nmBus) connection(uuid string) (dbus.ObjectPath, error) {
+	var path dbus.ObjectPath
+	err := b.conn.Object(nmBusName, nmSettingsPath).Call(nmSettingsInterface+".GetConnectionByUuid", 0, uuid).Store(&path)
+	return path, err
+}
+
+func (b nmBus) Activate(uuid string) error {
+	path, err := b.connection(uuid)
+	if err != nil {
+		return err
+	}
+	var active dbus.ObjectPath
+	return b.conn.Object(nmBusName, nmPath).Call(nmBusName+".ActivateConnection", 0, path, dbus.ObjectPath("/"), dbus.ObjectPath("/")).Store(&active)
+}
+
+func (b nmBus) Delete(uuid string) error {
+	path, err := b.connection(uuid)
+	if err != nil {
+		return err
+	}
+	return b.conn.Object(nmBusName, path).Call(nmSettingsInterface+".Connection.Delete", 0).Err
+}
+
+func (b nmBus) Close() {
+	b.conn.Close()
+}
+
+// nmProfile is what fioconfig needs from a keyfile's [connection] section
+type nmProfile struct {
+	uuid        string
+	autoconnect bool
+}
+
+func parseNMProfile(content []byte) (nmProfile, error) {
+	profile := nmProfile{autoconnect: true}
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || section != "connection" {
+			continue
+		}
+		key, val := kv[0], kv[1]
+		switch strings.TrimSpace(key) {
+		case "uuid":
+			profile.uuid = strings.TrimSpace(val)
+		case "autoconnect":
+			profile.autoconnect = strings.TrimSpace(val) != "false"
+		}
+	}
+	if len(profile.uuid) == 0 {
+		return profile, errors.New("NetworkManager keyfile has no [connection] uuid")
+	}
+	return profile, nil
+}
+
+func (a *App) nmConnectionsDir() string {
+	if len(a.settings.NMConnectionsDir) > 0 {
+		return a.settings.NMConnectionsDir
+	}
+	return DefaultNMConnectionsDir
+}
+
+// nmKeyfile is where a config file's profile is installed
+func (a *App) nmKeyfile(fname string) string {
+	name := strings.ReplaceAll(fname, "/", "-")
+	return filepath.Join(a.nmConnectionsDir(), "fioconfig-"+strings.TrimSuffix(name, ".nmconnection")+".nmconnection")
+}
+
+// applyNMConnections installs and activates the profiles that changed and
+// deletes the ones that were removed, or are no longer NetworkManager
+// profiles.
+func (a *App) applyNMConnections(ctx context.Context, c *committedConfig) {
+	type pending struct {
+		fname   string
+		cfgFile *ConfigFile
+		remove  bool
+	}
+	var work []pending
+	for _, fname := range c.changed {
+		if next := c.next[fname]; next.NetworkManager {
+			work = append(work, pending{fname, next, false})
+		} else if prev, ok := c.prev[fname]; ok && prev.NetworkManager {
+			work = append(work, pending{fname, prev, true})
+		}
+	}
+	for _, fname := range c.removed {
+		if prev := c.prev[fname]; prev.NetworkManager {
+			work = append(work, pending{fname, prev, true})
+		}
+	}
+	if len(work) == 0 {
+		return
+	}
+
+	nm, err := newNMSettings()
+	if err != nil {
+		err = fmt.Errorf("Unable to connect to NetworkManager: %w", err)
+	}
+	for _, w := range work {
+		result := ConnectionResult{File: w.fname, Action: "activate"}
+		content, cerr := w.cfgFile.content()
+		var profile nmProfile
+		if cerr == nil {
+			profile, cerr = parseNMProfile(content)
+		}
+		result.UUID = profile.uuid
+		keyfile := a.nmKeyfile(w.fname)
+		switch {
+		case cerr != nil:
+			result.Error = cerr.Error()
+		case err != nil:
+			result.Error = err.Error()
+		case w.remove:
+			result.Action = "delete"
+			LogEvent(EventNMConnection, "Deleting NetworkManager connection %s for %s", profile.uuid, w.fname)
+			if derr := nm.Delete(profile.uuid); derr != nil {
+				result.Error = derr.Error()
+			}
+			if rerr := os.Remove(keyfile); rerr != nil && !errors.Is(rerr, os.ErrNotExist) && len(result.Error) == 0 {
+				result.Error = rerr.Error()
+			}
+		default:
+			LogEvent(EventNMConnection, "Loading NetworkManager connection %s from %s", profile.uuid, w.fname)
+			lerr := os.MkdirAll(filepath.Dir(keyfile), 0o755)
+			if lerr == nil {
+				// NetworkManager ignores keyfiles others can read
+				lerr = safeWriteMeta(keyfile, content, fileMeta{0o600, -1, -1})
+			}
+			if lerr == nil {
+				lerr = nm.LoadConnections([]string{keyfile})
+			}
+			if lerr == nil && profile.autoconnect {
+				lerr = nm.Activate(profile.uuid)
+			} else {
+				result.Action = "load"
+			}
+			if lerr != nil {
+				result.Error = lerr.Error()
+			}
+		}
+		zeroize(content)
+		if len(result.Error) > 0 {
+			LogEvent(EventHandlerFailed, "Unable to %s NetworkManager connection for %s: %s", result.Action, w.fname, result.Error)
+		}
+		c.report.Connections = append(c.report.Connections, result)
+	}
+	if nm != nil {
+		nm.Close()
+	}
+}
@@ -0,0 +1,91 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNM struct {
+	calls []string
+}
+
+func (f *fakeNM) LoadConnections(paths []string) error {
+	f.calls = append(f.calls, "load "+filepath.Base(paths[0]))
+	return nil
+}
+
+func (f *fakeNM) Activate(uuid string) error {
+	f.calls = append(f.calls, "activate "+uuid)
+	if uuid == "no-device" {
+		return errors.New("No suitable device found for this connection")
+	}
+	return nil
+}
+
+func (f *fakeNM) Delete(uuid string) error {
+	f.calls = append(f.calls, "delete "+uuid)
+	return nil
+}
+
+func (f *fakeNM) Close() {}
+
+func TestNMConnections(t *testing.T) {
+	nm := &fakeNM{}
+	orig := newNMSettings
+	newNMSettings = func() (nmSettings, error) { return nm, nil }
+	defer func() { newNMSettings = orig }()
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		nmDir := filepath.Join(tempdir, "system-connections")
+		app.settings.NMConnectionsDir = nmDir
+		wifi := "[connection]\nid=office\nuuid=1234\ntype=wifi\n\n[wifi-security]\npsk=hunter2\n"
+		config := ConfigStruct{
+			"nm/office.nmconnection": &ConfigFile{Value: wifi, NetworkManager: true},
+			"nm/lte.nmconnection":    &ConfigFile{Value: "[connection]\nuuid=no-device\n", NetworkManager: true},
+			"nm/manual.nmconnection": &ConfigFile{Value: "[connection]\nuuid=5678\nautoconnect=false\n", NetworkManager: true},
+			"nm/broken":              &ConfigFile{Value: "[connection]\nid=broken\n", NetworkManager: true},
+			"plain":                  &ConfigFile{Value: "[connection]\nuuid=ignored\n"},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Equal(t, []string{
+			"load fioconfig-nm-lte.nmconnection", "activate no-device",
+			"load fioconfig-nm-manual.nmconnection",
+			"load fioconfig-nm-office.nmconnection", "activate 1234",
+		}, nm.calls)
+		require.Len(t, report.Connections, 4)
+		require.Equal(t, ConnectionResult{"nm/office.nmconnection", "1234", "activate", ""}, report.Connections[3])
+		require.Equal(t, "load", report.Connections[2].Action)
+		require.Contains(t, report.Connections[0].Error, "no [connection] uuid")
+		require.Len(t, report.Warnings, 2)
+
+		keyfile := filepath.Join(nmDir, "fioconfig-nm-office.nmconnection")
+		assertFile(t, keyfile, []byte(wifi))
+		st, err := os.Stat(keyfile)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+
+		// Removing the file deletes the connection
+		nm.calls = nil
+		next := ConfigStruct{"plain": config["plain"]}
+		for _, fname := range []string{"nm/lte.nmconnection", "nm/manual.nmconnection", "nm/broken"} {
+			next[fname] = config[fname]
+		}
+		report, err = app.extract(context.Background(), crypto, configSnapshot{config, next})
+		require.Nil(t, err)
+		require.Equal(t, []string{"delete 1234"}, nm.calls)
+		require.Equal(t, ConnectionResult{"nm/office.nmconnection", "1234", "delete", ""}, report.Connections[0])
+		_, err = os.Stat(keyfile)
+		require.True(t, errors.Is(err, os.ErrNotExist))
+	})
+}
@@ -0,0 +1,188 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ecies "github.com/foundriesio/go-ecies"
+)
+
+// Air-gapped factories can pre-seed a device's config without the server
+// by building the config.encrypted it would have downloaded. Values are
+// encrypted to the device's public key just as the server does, so
+// Extract applies the result like any other config.
+
+// ParseDevicePublicKey reads the key config values are encrypted to from
+// a PEM public key, as printed by `fioconfig pubkey`, or the device's
+// certificate.
+func ParseDevicePublicKey(pemBytes []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("No PEM data found")
+	}
+	var pub interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub = cert.PublicKey
+	default:
+		return nil, fmt.Errorf("Unsupported PEM block: %s", block.Type)
+	}
+	ec, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Device key is not an ECDSA key")
+	}
+	return ec, nil
+}
+
+// ConfigFromDir builds a config of the files under dir, each named by its
+// path relative to dir
+func ConfigFromDir(dir string) (ConfigStruct, error) {
+	config := make(ConfigStruct)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", path)
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if err := validateFileName(name); err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		config[name] = NewConfigFile(content)
+		return nil
+	})
+	return config, err
+}
+
+// ConfigFromManifest reads a config in the format of config.encrypted but
+// with plaintext values, so on-changed commands and the other file
+// settings can be given too
+func ConfigFromManifest(path string) (ConfigStruct, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config ConfigStruct
+	if err := json.Unmarshal(buf, &config); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %w", path, err)
+	}
+	for name := range config {
+		if err := validateFileName(name); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// EncryptConfig returns the config.encrypted for a device, with each value
+// not marked Unencrypted encrypted to its public key
+func EncryptConfig(pub *ecdsa.PublicKey, config ConfigStruct) ([]byte, error) {
+	eciesPub := ecies.ImportECDSAPublic(pub)
+	encrypted := make(ConfigStruct, len(config))
+	for name, cfgFile := range config {
+		enc := *cfgFile
+		if !enc.Unencrypted {
+			value, err := ecies.Encrypt(rand.Reader, eciesPub, []byte(cfgFile.Value), nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to encrypt %s: %w", name, err)
+			}
+			enc.Value = base64.StdEncoding.EncodeToString(value)
+		}
+		encrypted[name] = &enc
+	}
+	return json.Marshal(encrypted)
+}
+
+// Import validates a config.encrypted bundle brought to the device on
+// removable media and applies it as a check-in would: files missing from it
+// are removed and on-changed handlers run. With config_signing_keys set,
+// a base64 signature of the bundle must be next to it in `<path>.sig`.
+// Like a reverted config, the device stays on it until the config on the
+// server changes.
+func (a *App) Import(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	encrypted, err := readLimited(f, a.maxConfigSize())
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %w", path, err)
+	}
+	if len(a.settings.ConfigSigningKeys) > 0 {
+		sig, err := os.ReadFile(path + ".sig")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := a.checkSignature(strings.TrimSpace(string(sig)), "signature in "+path+".sig", encrypted); err != nil {
+			return err
+		}
+	}
+
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return err
+	}
+	defer a.releaseCrypto(crypto)
+
+	var config configSnapshot
+	if config.next, err = UnmarshallBuffer(crypto, encrypted, true); err != nil {
+		return err
+	}
+	if config.prev, err = a.unmarshallCache(crypto, a.EncryptedConfig, false); err != nil && !errors.Is(err, os.ErrNotExist) {
+		LogEvent(EventPrevConfigUnusable, "Unable to load previous config version: %s", err)
+		return err
+	}
+	if err = a.checkDiskSpace(config.next, encrypted); err != nil {
+		return err
+	}
+	LogEvent(EventConfigImported, "Importing config from %s", path)
+	report, err := a.extract(context.Background(), crypto, config)
+	version := a.latestVersion() + 1
+	a.audit(report, version)
+	a.publishExtract(report, err, version)
+	if err != nil {
+		return &ExtractFailure{err}
+	}
+	if err = a.writeCache(a.EncryptedConfig, encrypted); err != nil {
+		return err
+	}
+	state := a.loadCheckInState()
+	state.Reverted = true
+	if err = a.saveCheckInState(state); err != nil {
+		LogEvent(EventStateSaveFailed, "Unable to save check-in state: %s", err)
+	}
+	if err = a.recordHistory(encrypted, config.next, state); err != nil {
+		LogEvent(EventHistorySaveFailed, "Unable to record config history: %s", err)
+	}
+	return report.partialError()
+}
@@ -0,0 +1,103 @@
+package fioconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptConfig(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(priv.Public())
+	require.Nil(t, err)
+	pub, err := ParseDevicePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.Nil(t, err)
+	crypto := NewEciesLocalHandler(priv)
+
+	dir := t.TempDir()
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o755))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "foo"), []byte("foo value"), 0o644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "sub", "bin"), []byte{0xff, 0x00, 0xfe}, 0o644))
+	config, err := ConfigFromDir(dir)
+	require.Nil(t, err)
+	encrypted, err := EncryptConfig(pub, config)
+	require.Nil(t, err)
+	require.NotContains(t, string(encrypted), "foo value")
+
+	decrypted, err := UnmarshallBuffer(crypto, encrypted, true)
+	require.Nil(t, err)
+	require.Equal(t, "foo value", decrypted["foo"].Value)
+	content, err := decrypted["sub/bin"].content()
+	require.Nil(t, err)
+	require.Equal(t, []byte{0xff, 0x00, 0xfe}, content)
+
+	manifest := filepath.Join(t.TempDir(), "config.json")
+	require.Nil(t, os.WriteFile(manifest, []byte(`{
+		"secret": {"Value": "hidden", "OnChanged": ["/usr/share/fioconfig/handlers/restart"]},
+		"public": {"Value": "visible", "Unencrypted": true}
+	}`), 0o644))
+	config, err = ConfigFromManifest(manifest)
+	require.Nil(t, err)
+	encrypted, err = EncryptConfig(pub, config)
+	require.Nil(t, err)
+	require.NotContains(t, string(encrypted), "hidden")
+	require.Contains(t, string(encrypted), "visible")
+	decrypted, err = UnmarshallBuffer(crypto, encrypted, true)
+	require.Nil(t, err)
+	require.Equal(t, "hidden", decrypted["secret"].Value)
+	require.Equal(t, []string{"/usr/share/fioconfig/handlers/restart"}, decrypted["secret"].OnChanged)
+
+	_, err = ParseDevicePublicKey([]byte("not pem"))
+	require.NotNil(t, err)
+}
+
+func TestImport(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		require.Nil(t, app.Extract())
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+
+		config := ConfigStruct{"foo": &ConfigFile{Value: "imported"}}
+		encrypt(t, config)
+		buf, err := json.Marshal(config)
+		require.Nil(t, err)
+		bundle := filepath.Join(t.TempDir(), "config.encrypted")
+		require.Nil(t, os.WriteFile(bundle, buf, 0o644))
+
+		// A bundle signed by a trusted key is required once keys are set
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.Nil(t, err)
+		app.settings.ConfigSigningKeys = []string{base64.StdEncoding.EncodeToString(pub)}
+		err = app.Import(bundle)
+		require.True(t, errors.Is(err, BadSignatureError), err)
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, buf))
+		require.Nil(t, os.WriteFile(bundle+".sig", []byte(sig+"\n"), 0o644))
+
+		require.Nil(t, app.Import(bundle))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("imported"))
+		_, err = os.Stat(filepath.Join(tempdir, "bar"))
+		require.True(t, os.IsNotExist(err))
+		require.True(t, app.loadCheckInState().Reverted)
+		entries, err := app.History()
+		require.Nil(t, err)
+		require.Len(t, entries, 1)
+
+		// Bundles that can't be decrypted aren't applied
+		require.Nil(t, os.WriteFile(bundle, []byte(`{"foo": {"Value": "not encrypted"}}`), 0o644))
+		app.settings.ConfigSigningKeys = nil
+		require.NotNil(t, app.Import(bundle))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("imported"))
+	})
+}
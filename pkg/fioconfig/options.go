@@ -0,0 +1,76 @@
+package fioconfig
+
+import (
+	"log"
+	"net/http"
+)
+
+// Option customizes an App created by NewApp
+type Option func(*App)
+
+// WithSecretsDir sets where config files are extracted to. The default is
+// /var/run/secrets.
+func WithSecretsDir(dir string) Option {
+	return func(a *App) {
+		a.SecretsDir = dir
+	}
+}
+
+// WithUnsafeHandlers allows on-changed handlers outside of the directories
+// fioconfig normally restricts them to.
+func WithUnsafeHandlers(unsafe bool) Option {
+	return func(a *App) {
+		a.unsafeHandlers = unsafe
+	}
+}
+
+// WithConfigURL makes the App use a single config endpoint instead of the
+// servers from sota.toml.
+func WithConfigURL(url string) Option {
+	return func(a *App) {
+		a.configUrlOverride = url
+	}
+}
+
+// WithHTTPClient makes the App talk to the server with the given client
+// instead of one built from the identity in sota.toml.
+func WithHTTPClient(client *http.Client) Option {
+	return func(a *App) {
+		a.httpClient = client
+	}
+}
+
+// WithCryptoHandler makes the App decrypt config values with the given
+// handler instead of the device's key. The handler belongs to the caller:
+// the App never closes it.
+func WithCryptoHandler(crypto CryptoHandler) Option {
+	return func(a *App) {
+		a.cryptoHandler = crypto
+	}
+}
+
+// WithSecretStore extracts files to the given store instead of the one
+// selected by the secret_store setting
+func WithSecretStore(store SecretStore) Option {
+	return func(a *App) {
+		a.storeOverride = store
+	}
+}
+
+// WithLogger sends fioconfig's logs to the given logger, in the text
+// format, rather than the standard one. Logging is shared by the whole package, so this applies to
+// every App in the process.
+func WithLogger(l *log.Logger) Option {
+	return func(a *App) {
+		logger.useStdLogger(l)
+	}
+}
+
+// WithExtractOnly limits Extract to the config files matching the given
+// path.Match patterns, e.g. "wireguard/*", so a single file can be restored
+// without running every on-changed handler. Check-ins aren't affected.
+func WithExtractOnly(patterns ...string) Option {
+	return func(a *App) {
+		a.extractOnly = patterns
+	}
+}
@@ -0,0 +1,82 @@
+package fioconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// applyOrder returns the names in config in the order they should be
+// applied: files named in a file's After come before it and files named in
+// its Before come after it. Otherwise files are applied in lexical order so
+// the same config is always applied the same way. Relationships to files
+// not in the config are ignored.
+func applyOrder(config ConfigStruct) ([]string, error) {
+	edges := make(map[string][]string) // file -> files that must come after it
+	indegree := make(map[string]int, len(config))
+	for fname := range config {
+		indegree[fname] += 0
+	}
+	addEdge := func(first, then string) {
+		if _, ok := config[first]; !ok {
+			return
+		}
+		if _, ok := config[then]; !ok {
+			return
+		}
+		edges[first] = append(edges[first], then)
+		indegree[then]++
+	}
+	for fname, cfgFile := range config {
+		for _, dep := range cfgFile.After {
+			addEdge(dep, fname)
+		}
+		for _, dep := range cfgFile.Before {
+			addEdge(fname, dep)
+		}
+	}
+
+	var ready []string
+	for fname, n := range indegree {
+		if n == 0 {
+			ready = append(ready, fname)
+		}
+	}
+	sort.Strings(ready)
+	order := make([]string, 0, len(config))
+	for len(ready) > 0 {
+		fname := ready[0]
+		ready = ready[1:]
+		order = append(order, fname)
+		for _, next := range edges[fname] {
+			indegree[next]--
+			if indegree[next] == 0 {
+				// Keep the queue sorted so ties break lexically
+				idx := sort.SearchStrings(ready, next)
+				ready = append(ready, "")
+				copy(ready[idx+1:], ready[idx:])
+				ready[idx] = next
+			}
+		}
+	}
+	if len(order) != len(config) {
+		var cycle []string
+		for fname, n := range indegree {
+			if n > 0 {
+				cycle = append(cycle, fname)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("Config files have circular before/after relationships: %s", strings.Join(cycle, ", "))
+	}
+	return order, nil
+}
+
+func sortedNames(config ConfigStruct) []string {
+	names := make([]string, 0, len(config))
+	for fname := range config {
+		names = append(names, fname)
+	}
+	sort.Strings(names)
+	return names
+}
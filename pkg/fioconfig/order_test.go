@@ -0,0 +1,29 @@
+package fioconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyOrder(t *testing.T) {
+	order, err := applyOrder(ConfigStruct{"c": {}, "a": {}, "b": {}})
+	require.Nil(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, order)
+
+	order, err = applyOrder(ConfigStruct{
+		"a":        {After: []string{"z-ca.pem"}},
+		"b":        {},
+		"z-ca.pem": {Before: []string{"b"}},
+		"c":        {After: []string{"missing"}},
+	})
+	require.Nil(t, err)
+	require.Equal(t, []string{"c", "z-ca.pem", "a", "b"}, order)
+
+	_, err = applyOrder(ConfigStruct{
+		"a": {After: []string{"b"}},
+		"b": {After: []string{"a"}},
+		"c": {},
+	})
+	require.EqualError(t, err, "Config files have circular before/after relationships: a, b")
+}
@@ -0,0 +1,85 @@
+package fioconfig
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// fileMeta is the permissions and owner a config file should have. Zero
+// mode and -1 ids leave what safeWrite creates alone.
+type fileMeta struct {
+	mode     os.FileMode
+	uid, gid int
+}
+
+var defaultFileMeta = fileMeta{0, -1, -1}
+
+func (c *ConfigFile) fileMeta() (fileMeta, error) {
+	meta := defaultFileMeta
+	if c.Mode != "" {
+		mode, err := strconv.ParseUint(c.Mode, 8, 32)
+		if err != nil || mode > 0o777 {
+			return meta, fmt.Errorf("Invalid file mode %q", c.Mode)
+		}
+		meta.mode = os.FileMode(mode)
+	}
+	if c.Uid != nil {
+		if *c.Uid < 0 {
+			return meta, fmt.Errorf("Invalid uid %d", *c.Uid)
+		}
+		meta.uid = *c.Uid
+	}
+	if c.Gid != nil {
+		if *c.Gid < 0 {
+			return meta, fmt.Errorf("Invalid gid %d", *c.Gid)
+		}
+		meta.gid = *c.Gid
+	}
+	return meta, nil
+}
+
+func (m fileMeta) applyFile(f *os.File) error {
+	if m.uid != -1 || m.gid != -1 {
+		if err := f.Chown(m.uid, m.gid); err != nil {
+			return err
+		}
+	}
+	if m.mode != 0 {
+		return f.Chmod(m.mode)
+	}
+	return nil
+}
+
+// apply fixes the permissions and owner of a file whose content is already
+// up to date.
+func (m fileMeta) apply(path string) error {
+	if m == defaultFileMeta {
+		return nil
+	}
+	st, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if uid, gid, ok := fileOwner(st); ok {
+		if (m.uid != -1 && uid != m.uid) || (m.gid != -1 && gid != m.gid) {
+			if err := os.Lchown(path, m.uid, m.gid); err != nil {
+				return fmt.Errorf("Unable to set owner of %s: %w", path, err)
+			}
+		}
+	}
+	if m.mode != 0 && st.Mode().Perm() != m.mode {
+		if err := os.Chmod(path, m.mode); err != nil {
+			return fmt.Errorf("Unable to set mode of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func fileOwner(st os.FileInfo) (int, int, bool) {
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+		return int(sys.Uid), int(sys.Gid), true
+	}
+	return 0, 0, false
+}
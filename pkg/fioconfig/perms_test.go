@@ -0,0 +1,57 @@
+package fioconfig
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func updateSecret(t *testing.T, dir string, cfg *ConfigFile) (bool, error) {
+	txn, err := beginExtract(dir, 0o750)
+	require.Nil(t, err)
+	defer txn.close()
+	changed, err := txn.stage(0, "creds", cfg, "")
+	if err == nil {
+		err = txn.commit()
+	}
+	return changed, err
+}
+
+func TestUpdateSecretMeta(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "creds")
+	uid, gid := os.Getuid(), os.Getgid()
+
+	cfg := &ConfigFile{Value: "secret", Mode: "0600", Uid: &uid, Gid: &gid}
+	changed, err := updateSecret(t, dir, cfg)
+	require.Nil(t, err)
+	require.True(t, changed)
+	st, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+	require.Equal(t, uint32(uid), st.Sys().(*syscall.Stat_t).Uid)
+
+	// Only the metadata changed
+	cfg.Mode = "644"
+	changed, err = updateSecret(t, dir, cfg)
+	require.Nil(t, err)
+	require.False(t, changed)
+	st, err = os.Stat(path)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0o644), st.Mode().Perm())
+
+	cfg.Mode = "0999"
+	_, err = updateSecret(t, dir, cfg)
+	require.NotNil(t, err)
+	cfg.Mode = "01777"
+	_, err = updateSecret(t, dir, cfg)
+	require.NotNil(t, err)
+	bad := -2
+	cfg.Mode = ""
+	cfg.Uid = &bad
+	_, err = updateSecret(t, dir, cfg)
+	require.NotNil(t, err)
+}
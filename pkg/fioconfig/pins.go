@@ -0,0 +1,77 @@
+package fioconfig
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pinning the gateway's certificate means a mis-issued certificate from a
+// CA in the trust store isn't enough to impersonate it. tls_pins lists
+// pins as "spki:<base64 sha256 of the public key>" or "cert:<hex sha256 of
+// the certificate>". A connection is accepted if any certificate in the
+// verified chain matches any pin, so a new pin can be added ahead of a
+// certificate rotation and the old one removed after.
+
+// ServerNotPinnedError is returned when the server's certificate chain
+// matches none of tls_pins
+var ServerNotPinnedError = errors.New("Server certificate does not match any of fioconfig.tls_pins")
+
+type certPin struct {
+	spki bool
+	hash [sha256.Size]byte
+}
+
+func parseCertPins(pins []string) ([]certPin, error) {
+	var parsed []certPin
+	for _, pin := range pins {
+		parts := strings.SplitN(pin, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid fioconfig.tls_pins entry: %s", pin)
+		}
+		var hash []byte
+		var err error
+		switch parts[0] {
+		case "spki":
+			hash, err = base64.StdEncoding.DecodeString(parts[1])
+		case "cert":
+			hash, err = hex.DecodeString(parts[1])
+		default:
+			return nil, fmt.Errorf("Invalid fioconfig.tls_pins entry: %s", pin)
+		}
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("Invalid fioconfig.tls_pins entry: %s", pin)
+		}
+		p := certPin{spki: parts[0] == "spki"}
+		copy(p.hash[:], hash)
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+func (p certPin) matches(cert *x509.Certificate) bool {
+	if p.spki {
+		return sha256.Sum256(cert.RawSubjectPublicKeyInfo) == p.hash
+	}
+	return sha256.Sum256(cert.Raw) == p.hash
+}
+
+// verifyPins checks the chains the server's certificate was verified with
+// against the pins
+func verifyPins(pins []certPin, cs tls.ConnectionState) error {
+	for _, chain := range cs.VerifiedChains {
+		for _, cert := range chain {
+			for _, pin := range pins {
+				if pin.matches(cert) {
+					return nil
+				}
+			}
+		}
+	}
+	return ServerNotPinnedError
+}
@@ -0,0 +1,53 @@
+package fioconfig
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertPins(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	cert := srv.Certificate()
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	raw := sha256.Sum256(cert.Raw)
+	other := sha256.Sum256([]byte("some other key"))
+
+	get := func(pins ...string) error {
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		cfg := &tls.Config{RootCAs: roots}
+		require.Nil(t, applyTlsSettings(cfg, Settings{TlsPins: pins}))
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		res, err := client.Get(srv.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		return classifyTlsError(err, client, time.Now())
+	}
+
+	require.Nil(t, get())
+	require.Nil(t, get("spki:"+base64.StdEncoding.EncodeToString(spki[:])))
+	require.Nil(t, get("cert:"+hex.EncodeToString(raw[:])))
+	// Either pin of a rotation is accepted
+	require.Nil(t, get("spki:"+base64.StdEncoding.EncodeToString(other[:]), "cert:"+hex.EncodeToString(raw[:])))
+
+	err := get("spki:" + base64.StdEncoding.EncodeToString(other[:]))
+	var tlsErr *TlsError
+	require.True(t, errors.As(err, &tlsErr), err)
+	require.Equal(t, ServerCertNotPinned, tlsErr.Cause)
+
+	for _, pin := range []string{"sha256:abcd", "cert:abcd", "spki:not-base64", "spki"} {
+		require.NotNil(t, applyTlsSettings(&tls.Config{}, Settings{TlsPins: []string{pin}}), pin)
+	}
+}
@@ -0,0 +1,249 @@
+package fioconfig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	toml "github.com/pelletier/go-toml"
+)
+
+// Configuring crypto11 initializes the module, logs in, and opens a pool of
+// sessions, which is slow and which some HSMs don't cope with when it's
+// repeated for every check-in. So the context of each token is configured
+// the first time it's needed and kept open until App.Close. Identity
+// providers only get sota.toml, so the contexts are shared by the process
+// rather than held by an App.
+
+const defaultPkcs11MaxSessions = 2
+
+// pkcs11Login is how hard to try configuring a token that isn't ready,
+// e.g. one still coming up at boot. A wrong PIN is never retried since
+// that could lock the token.
+type pkcs11Login struct {
+	retries int
+	delay   time.Duration
+}
+
+// pkcs11Tuning applies the <section>.max_sessions and pool_wait_timeout
+// settings of sota.toml to cfg and returns its login_retries and
+// login_retry_delay
+func pkcs11Tuning(sota *toml.Tree, section string, cfg *crypto11.Config) (pkcs11Login, error) {
+	login := pkcs11Login{delay: time.Second}
+	cfg.MaxSessions = int(sota.GetDefault(section+".max_sessions", int64(defaultPkcs11MaxSessions)).(int64))
+	if cfg.MaxSessions < 2 {
+		return login, fmt.Errorf("%s.max_sessions must be at least 2", section)
+	}
+	if wait, ok := sota.Get(section + ".pool_wait_timeout").(string); ok {
+		d, err := time.ParseDuration(wait)
+		if err != nil {
+			return login, fmt.Errorf("Invalid %s.pool_wait_timeout: %w", section, err)
+		}
+		cfg.PoolWaitTimeout = d
+	}
+	login.retries = int(sota.GetDefault(section+".login_retries", int64(0)).(int64))
+	if delay, ok := sota.Get(section + ".login_retry_delay").(string); ok {
+		d, err := time.ParseDuration(delay)
+		if err != nil {
+			return login, fmt.Errorf("Invalid %s.login_retry_delay: %w", section, err)
+		}
+		login.delay = d
+	}
+	return login, nil
+}
+
+// pkcs11Shared is the long-lived context of one token
+type pkcs11Shared struct {
+	cfg   crypto11.Config
+	login pkcs11Login
+
+	mu  sync.Mutex
+	ctx *crypto11.Context
+}
+
+var (
+	pkcs11ContextsLock sync.Mutex
+	pkcs11Contexts     = make(map[string]*pkcs11Shared)
+)
+
+func pkcs11ContextKey(cfg *crypto11.Config) string {
+	slot := ""
+	if cfg.SlotNumber != nil {
+		slot = strconv.Itoa(*cfg.SlotNumber)
+	}
+	return cfg.Path + "\x00" + slot + "\x00" + cfg.TokenLabel + "\x00" + cfg.TokenSerial
+}
+
+// sharedPkcs11 returns the shared context of the token cfg selects
+func sharedPkcs11(cfg *crypto11.Config, login pkcs11Login) *pkcs11Shared {
+	pkcs11ContextsLock.Lock()
+	defer pkcs11ContextsLock.Unlock()
+	key := pkcs11ContextKey(cfg)
+	shared, ok := pkcs11Contexts[key]
+	if !ok {
+		shared = &pkcs11Shared{cfg: *cfg, login: login}
+		pkcs11Contexts[key] = shared
+	}
+	return shared
+}
+
+// closePkcs11Contexts closes every shared context. They're reopened if
+// they're needed again.
+func closePkcs11Contexts() {
+	pkcs11ContextsLock.Lock()
+	defer pkcs11ContextsLock.Unlock()
+	for _, shared := range pkcs11Contexts {
+		shared.reset(nil)
+	}
+}
+
+func pkcs11Is(err error, codes ...uint) bool {
+	var p11Err pkcs11.Error
+	if !errors.As(err, &p11Err) {
+		return false
+	}
+	for _, code := range codes {
+		if uint(p11Err) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenReset reports whether err means the token, or the module, lost the
+// state the context was opened with
+func tokenReset(err error) bool {
+	return pkcs11Is(err, pkcs11.CKR_SESSION_HANDLE_INVALID, pkcs11.CKR_SESSION_CLOSED,
+		pkcs11.CKR_TOKEN_NOT_PRESENT, pkcs11.CKR_DEVICE_REMOVED, pkcs11.CKR_DEVICE_ERROR,
+		pkcs11.CKR_USER_NOT_LOGGED_IN, pkcs11.CKR_OBJECT_HANDLE_INVALID, pkcs11.CKR_KEY_HANDLE_INVALID,
+		pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)
+}
+
+// get returns the open context, configuring it if needed
+func (s *pkcs11Shared) get() (*crypto11.Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil {
+		return s.ctx, nil
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		cfg := s.cfg // Configure fills in defaults
+		if s.ctx, err = crypto11.Configure(&cfg); err == nil {
+			return s.ctx, nil
+		}
+		if attempt >= s.login.retries || pkcs11Is(err, pkcs11.CKR_PIN_INCORRECT, pkcs11.CKR_PIN_LOCKED,
+			pkcs11.CKR_PIN_EXPIRED, pkcs11.CKR_PIN_INVALID, pkcs11.CKR_PIN_LEN_RANGE) {
+			return nil, err
+		}
+		logger.Printf("Unable to open PKCS#11 token (attempt %d), retrying in %s: %s", attempt+1, s.login.delay, err)
+		time.Sleep(s.login.delay)
+	}
+}
+
+// reset closes ctx so the next get reopens the token. It's a no-op if
+// ctx was already replaced, and a nil ctx closes whatever is open.
+func (s *pkcs11Shared) reset(ctx *crypto11.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil || (ctx != nil && ctx != s.ctx) {
+		return
+	}
+	if err := s.ctx.Close(); err != nil {
+		logger.Printf("Unable to close PKCS#11 context: %s", err)
+	}
+	s.ctx = nil
+}
+
+// pkcs11KeyPair is a key pair of a shared context. It finds the key again
+// when the context is reopened, and reopens it when the token was reset.
+type pkcs11KeyPair struct {
+	shared *pkcs11Shared
+	id     []byte
+	public crypto.PublicKey
+
+	mu     sync.Mutex
+	ctx    *crypto11.Context
+	signer crypto11.Signer
+}
+
+func newPkcs11KeyPair(shared *pkcs11Shared, id []byte) (*pkcs11KeyPair, error) {
+	k := &pkcs11KeyPair{shared: shared, id: id}
+	_, signer, err := k.current()
+	if err != nil {
+		return nil, err
+	}
+	k.public = signer.Public()
+	return k, nil
+}
+
+// current returns the key on the shared context as it is now
+func (k *pkcs11KeyPair) current() (*crypto11.Context, crypto11.Signer, error) {
+	ctx, err := k.shared.get()
+	if err != nil {
+		return nil, nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if ctx != k.ctx {
+		signer, err := ctx.FindKeyPair(k.id, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if signer == nil {
+			return nil, nil, errors.New("Unable to find pkcs11 private key")
+		}
+		k.ctx, k.signer = ctx, signer
+	}
+	return k.ctx, k.signer, nil
+}
+
+// do runs op with the key, reopening the token and trying again once if
+// it was reset
+func (k *pkcs11KeyPair) do(op func(*crypto11.Context, crypto11.Signer) error) error {
+	ctx, signer, err := k.current()
+	if err == nil {
+		if err = op(ctx, signer); !tokenReset(err) {
+			return err
+		}
+		LogEvent(EventPkcs11Reopened, "PKCS#11 token was reset, reopening it: %s", err)
+		k.shared.reset(ctx)
+	} else if !tokenReset(err) {
+		return err
+	}
+	if ctx, signer, err = k.current(); err != nil {
+		return err
+	}
+	return op(ctx, signer)
+}
+
+func (k *pkcs11KeyPair) Public() crypto.PublicKey {
+	return k.public
+}
+
+func (k *pkcs11KeyPair) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var sig []byte
+	err := k.do(func(_ *crypto11.Context, signer crypto11.Signer) error {
+		var err error
+		sig, err = signer.Sign(rand, digest, opts)
+		return err
+	})
+	return sig, err
+}
+
+func (k *pkcs11KeyPair) ecdh(pub *ecdsa.PublicKey) ([]byte, error) {
+	var shared []byte
+	err := k.do(func(ctx *crypto11.Context, signer crypto11.Signer) error {
+		var err error
+		shared, err = ctx.ECDH1Derive(signer, pub)
+		return err
+	})
+	return shared, err
+}
@@ -0,0 +1,61 @@
+package fioconfig
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	toml "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPkcs11Tuning(t *testing.T) {
+	sota, err := toml.Load("[p11]\nmodule = \"/usr/lib/libckteec.so.0\"\n")
+	require.Nil(t, err)
+
+	var cfg crypto11.Config
+	login, err := pkcs11Tuning(sota, "p11", &cfg)
+	require.Nil(t, err)
+	require.Equal(t, defaultPkcs11MaxSessions, cfg.MaxSessions)
+	require.Equal(t, pkcs11Login{delay: time.Second}, login)
+
+	sota.Set("p11.max_sessions", int64(8))
+	sota.Set("p11.pool_wait_timeout", "3s")
+	sota.Set("p11.login_retries", int64(5))
+	sota.Set("p11.login_retry_delay", "250ms")
+	login, err = pkcs11Tuning(sota, "p11", &cfg)
+	require.Nil(t, err)
+	require.Equal(t, 8, cfg.MaxSessions)
+	require.Equal(t, 3*time.Second, cfg.PoolWaitTimeout)
+	require.Equal(t, pkcs11Login{retries: 5, delay: 250 * time.Millisecond}, login)
+
+	sota.Set("p11.max_sessions", int64(1))
+	_, err = pkcs11Tuning(sota, "p11", &cfg)
+	require.NotNil(t, err)
+	sota.Set("p11.max_sessions", int64(2))
+	sota.Set("p11.login_retry_delay", "soon")
+	_, err = pkcs11Tuning(sota, "p11", &cfg)
+	require.NotNil(t, err)
+}
+
+func TestPkcs11Contexts(t *testing.T) {
+	slot := 1
+	cfg := crypto11.Config{Path: "/usr/lib/libckteec.so.0", SlotNumber: &slot}
+	shared := sharedPkcs11(&cfg, pkcs11Login{})
+	require.Same(t, shared, sharedPkcs11(&cfg, pkcs11Login{}))
+
+	other := 2
+	cfg.SlotNumber = &other
+	require.NotSame(t, shared, sharedPkcs11(&cfg, pkcs11Login{}))
+
+	// Nothing is opened until a key is used
+	require.Nil(t, shared.ctx)
+	closePkcs11Contexts()
+
+	require.True(t, tokenReset(fmt.Errorf("sign: %w", pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID))))
+	require.True(t, tokenReset(pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED)))
+	require.False(t, tokenReset(pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)))
+	require.False(t, tokenReset(fmt.Errorf("not a pkcs11 error")))
+}
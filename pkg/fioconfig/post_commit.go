@@ -0,0 +1,45 @@
+package fioconfig
+
+import (
+	"context"
+)
+
+// committedConfig is an extraction whose files are in place in the secrets
+// directory, as given to the post-commit steps
+type committedConfig struct {
+	configSnapshot
+	changed []string
+	removed []string
+	report  *ExtractReport
+}
+
+// touched returns the files that were changed or removed
+func (c *committedConfig) touched() []string {
+	return append(c.changed[:len(c.changed):len(c.changed)], c.removed...)
+}
+
+// A postCommitStep applies a committed config somewhere besides the secrets
+// directory. Steps record failures in the report rather than returning
+// them so one failing doesn't keep the others from running.
+type postCommitStep func(a *App, ctx context.Context, c *committedConfig)
+
+// postCommitSteps run in this order after every successful extraction
+var postCommitSteps = []postCommitStep{
+	(*App).updateWireguard,
+	(*App).applyNMConnections,
+	(*App).applyUbootEnv,
+	(*App).applySSHKeys,
+	(*App).applySystemSettings,
+	(*App).writeEnvFiles,
+	(*App).writeTargets,
+	(*App).mirrorFiles,
+	(*App).writeCredentials,
+}
+
+// runPostCommitSteps hands a committed extraction to each step in turn
+func (a *App) runPostCommitSteps(ctx context.Context, config configSnapshot, changed, removed []string, report *ExtractReport) {
+	c := &committedConfig{config, changed, removed, report}
+	for _, step := range postCommitSteps {
+		step(a, ctx, c)
+	}
+}
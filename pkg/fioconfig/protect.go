@@ -0,0 +1,68 @@
+package fioconfig
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// isProtected returns true if a file matches one of the protected_files
+// patterns. Patterns use filepath.Match syntax against the name relative to
+// the secrets directory, and a pattern matching a directory protects
+// everything under it.
+func (a *App) isProtected(fname string) bool {
+	return matchesPatterns(a.settings.ProtectedFiles, fname)
+}
+
+// keepOnRemove returns true if a file the server removed should be left
+// on disk, because its entry or keep_on_remove says so. It's no longer
+// managed afterwards, so it won't be updated, checked for drift, or
+// removed by a later config.
+func (a *App) keepOnRemove(fname string, cfgFile *ConfigFile) bool {
+	return (cfgFile != nil && cfgFile.KeepOnRemove) || matchesPatterns(a.settings.KeepOnRemove, fname)
+}
+
+func matchesPatterns(patterns []string, fname string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		for name := fname; name != "."; name = path.Dir(name) {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyProtected drops protected files from both versions of a config so
+// they are neither written nor removed.
+func (a *App) applyProtected(config configSnapshot, report *ExtractReport) configSnapshot {
+	if len(a.settings.ProtectedFiles) == 0 {
+		return config
+	}
+	filter := func(cfg ConfigStruct, protected map[string]bool) ConfigStruct {
+		if cfg == nil {
+			return nil
+		}
+		filtered := make(ConfigStruct, len(cfg))
+		for fname, cfgFile := range cfg {
+			if a.isProtected(fname) {
+				protected[fname] = true
+			} else {
+				filtered[fname] = cfgFile
+			}
+		}
+		return filtered
+	}
+	protected := make(map[string]bool)
+	config.next = filter(config.next, protected)
+	config.prev = filter(config.prev, protected)
+	for fname := range protected {
+		report.Protected = append(report.Protected, fname)
+	}
+	sort.Strings(report.Protected)
+	for _, fname := range report.Protected {
+		LogEvent(EventFileProtected, "Not changing protected file %s", fname)
+	}
+	return config
+}
@@ -0,0 +1,88 @@
+package fioconfig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsProtected(t *testing.T) {
+	app := &App{settings: Settings{ProtectedFiles: []string{"*.key", "local/", "with/subdir/1.txt"}}}
+	require.True(t, app.isProtected("device.key"))
+	require.True(t, app.isProtected("local/override"))
+	require.True(t, app.isProtected("local/a/b"))
+	require.True(t, app.isProtected("with/subdir/1.txt"))
+	require.False(t, app.isProtected("keys/device.keys"))
+	require.False(t, app.isProtected("localx"))
+	require.False(t, app.isProtected("with/subdir/2.txt"))
+}
+
+func TestExtractProtected(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		config, err := UnmarshallFile(crypto, app.EncryptedConfig, true)
+		require.Nil(t, err)
+
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "bar"), []byte("device bar"), 0o644))
+		app.settings.ProtectedFiles = []string{"bar", "with"}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Equal(t, []string{"bar", "with/subdir/1.txt"}, report.Protected)
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("device bar"))
+		assertNoFile(t, filepath.Join(tempdir, "bar-changed"))
+		assertNoFile(t, filepath.Join(tempdir, "with/subdir/1.txt"))
+
+		// Protected files aren't removed either
+		app.settings.ProtectedFiles = []string{"bar"}
+		next := ConfigStruct{"foo": config["foo"]}
+		report, err = app.extract(context.Background(), crypto, configSnapshot{config, next})
+		require.Nil(t, err)
+		require.Equal(t, []string{"bar"}, report.Protected)
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("device bar"))
+		assertNoFile(t, filepath.Join(tempdir, "random"))
+	})
+}
+
+func TestKeepOnRemove(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		app.settings.CredentialsDir = filepath.Join(tempdir, "credentials")
+		app.settings.KeepOnRemove = []string{"legacy/"}
+
+		changed := filepath.Join(tempdir, "changed")
+		onChanged := []string{"/usr/bin/touch", changed}
+		v1 := ConfigStruct{
+			"old.crt":        {Value: "old cert", KeepOnRemove: true, Credential: "old.crt", OnChanged: onChanged},
+			"legacy/api.key": {Value: "legacy key"},
+			"tmp":            {Value: "tmp"},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, v1})
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(changed))
+
+		report, err := app.extract(context.Background(), crypto, configSnapshot{v1, ConfigStruct{}})
+		require.Nil(t, err)
+		require.Equal(t, []string{"legacy/api.key", "old.crt"}, report.Released)
+		require.Equal(t, []string{"tmp"}, report.Removed)
+		assertFile(t, filepath.Join(tempdir, "old.crt"), []byte("old cert"))
+		assertFile(t, filepath.Join(tempdir, "legacy/api.key"), []byte("legacy key"))
+		assertFile(t, filepath.Join(app.settings.CredentialsDir, "old.crt"), []byte("old cert"))
+		assertNoFile(t, filepath.Join(tempdir, "tmp"))
+		assertNoFile(t, changed)
+
+		// They're no longer managed
+		state := app.readManifest()
+		require.NotContains(t, state.Files, "old.crt")
+		require.NotContains(t, state.Files, "legacy/api.key")
+		require.Empty(t, state.Orphans)
+	})
+}
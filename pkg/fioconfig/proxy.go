@@ -0,0 +1,26 @@
+package fioconfig
+
+import (
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyFunc honors the standard HTTPS_PROXY/NO_PROXY environment variables
+// unless a proxy is configured in sota.toml. This configured proxy may use
+// the socks5 scheme, which net/http supports natively.
+func proxyFunc(settings Settings) func(*http.Request) (*url.URL, error) {
+	if len(settings.Proxy) == 0 {
+		return http.ProxyFromEnvironment
+	}
+	cfg := httpproxy.Config{
+		HTTPProxy:  settings.Proxy,
+		HTTPSProxy: settings.Proxy,
+		NoProxy:    settings.NoProxy,
+	}
+	fn := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return fn(req.URL)
+	}
+}
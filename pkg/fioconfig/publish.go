@@ -0,0 +1,101 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Files generated on the device, like a WireGuard public key or a CSR, can
+// be published to its config on the server so nobody has to copy them over
+// by hand. Each check-in uploads the ones that appeared or changed since
+// they were last published, before asking for the config, so the server's
+// answer already has them.
+
+// Publish is a local file uploaded to the device's config as it changes
+type Publish struct {
+	// The file to read
+	Path string `toml:"path"`
+	// Upload it as is rather than encrypted to the device's key
+	Unencrypted bool `toml:"unencrypted"`
+	// The on-changed command of the config file it becomes
+	OnChanged []string `toml:"on_changed"`
+}
+
+func (a *App) publishedFile() string {
+	return filepath.Join(a.stateDir(), "published.json")
+}
+
+// loadPublished returns the sha256 of what was last published for each
+// config file name
+func (a *App) loadPublished() map[string]string {
+	published := make(map[string]string)
+	buf, err := os.ReadFile(a.publishedFile())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to read published files: %s", err)
+		}
+		return published
+	}
+	if err := json.Unmarshal(buf, &published); err != nil {
+		logger.Printf("Unable to parse published files: %s", err)
+	}
+	return published
+}
+
+// publishFiles uploads the files in `fioconfig.publish` that changed since
+// they were last published. Failures are logged and retried by the next
+// check-in.
+func (a *App) publishFiles(client *http.Client, crypto CryptoHandler) {
+	if len(a.settings.Publish) == 0 {
+		return
+	}
+	names := make([]string, 0, len(a.settings.Publish))
+	for name := range a.settings.Publish {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	published := a.loadPublished()
+	changed := false
+	for _, name := range names {
+		pub := a.settings.Publish[name]
+		content, err := os.ReadFile(pub.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // Not generated yet
+		} else if err != nil {
+			LogEvent(EventPublishFailed, "Unable to read %s to publish it: %s", pub.Path, err)
+			continue
+		}
+		hash := sha256Hex(content)
+		if published[name] == hash {
+			zeroize(content)
+			continue
+		}
+		cfgFile := NewConfigFile(content)
+		zeroize(content)
+		cfgFile.Unencrypted = pub.Unencrypted
+		cfgFile.OnChanged = pub.OnChanged
+		reason := fmt.Sprintf("Published %s from the device", pub.Path)
+		if err := a.setConfigFile(client, crypto, name, cfgFile, reason); err != nil {
+			LogEvent(EventPublishFailed, "Unable to publish %s: %s", pub.Path, err)
+			continue
+		}
+		published[name] = hash
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	buf, err := json.Marshal(published)
+	if err == nil {
+		err = safeWrite(a.publishedFile(), buf)
+	}
+	if err != nil {
+		logger.Printf("Unable to save published files: %s", err)
+	}
+}
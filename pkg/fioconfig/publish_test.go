@@ -0,0 +1,77 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishFiles(t *testing.T) {
+	var uploads []ConfigCreateRequest
+	status := http.StatusCreated
+	doGet := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			var ccr ConfigCreateRequest
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&ccr))
+			uploads = append(uploads, ccr)
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusNotModified)
+	}
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		pubkey := filepath.Join(tempdir, "generated", "wg.pub")
+		app.settings.Publish = map[string]Publish{
+			"wireguard/device.pub": {Path: pubkey, Unencrypted: true, OnChanged: []string{"/usr/bin/true"}},
+		}
+
+		// Nothing to publish until the file is generated
+		require.True(t, errors.Is(app.checkin(context.Background(), client, crypto), NotModifiedError))
+		require.Empty(t, uploads)
+
+		require.Nil(t, os.MkdirAll(filepath.Dir(pubkey), 0o755))
+		require.Nil(t, os.WriteFile(pubkey, []byte("key1"), 0o644))
+		require.True(t, errors.Is(app.checkin(context.Background(), client, crypto), NotModifiedError))
+		require.Len(t, uploads, 1)
+		req := uploads[0].Files[0]
+		require.Equal(t, "wireguard/device.pub", req.Name)
+		require.Equal(t, "key1", req.Value)
+		require.True(t, req.Unencrypted)
+		require.Equal(t, []string{"/usr/bin/true"}, req.OnChanged)
+		require.Equal(t, "Published "+pubkey+" from the device", uploads[0].Reason)
+
+		// Unchanged files aren't uploaded again
+		require.True(t, errors.Is(app.checkin(context.Background(), client, crypto), NotModifiedError))
+		require.Len(t, uploads, 1)
+
+		// A failed upload is retried by the next check-in
+		require.Nil(t, os.WriteFile(pubkey, []byte("key2"), 0o644))
+		status = http.StatusForbidden
+		require.True(t, errors.Is(app.checkin(context.Background(), client, crypto), NotModifiedError))
+		require.Len(t, uploads, 2)
+		status = http.StatusCreated
+		require.True(t, errors.Is(app.checkin(context.Background(), client, crypto), NotModifiedError))
+		require.Len(t, uploads, 3)
+		require.Equal(t, "key2", uploads[2].Files[0].Value)
+		require.True(t, errors.Is(app.checkin(context.Background(), client, crypto), NotModifiedError))
+		require.Len(t, uploads, 3)
+
+		// Encrypted to the device's key by default
+		app.settings.Publish = map[string]Publish{"csr": {Path: pubkey}}
+		require.True(t, errors.Is(app.checkin(context.Background(), client, crypto), NotModifiedError))
+		require.Len(t, uploads, 4)
+		plain, err := crypto.Decrypt(uploads[3].Files[0].Value)
+		require.Nil(t, err)
+		require.Equal(t, "key2", string(plain))
+	})
+}
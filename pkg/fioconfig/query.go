@@ -0,0 +1,182 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Services on the device can fetch config values from the daemon over
+// HTTP on query_socket rather than all reading a shared directory:
+//
+//	GET /v1/files         names of the files the caller can read
+//	GET /v1/files/<name>  the content of a file
+//
+// Callers are identified by the credentials of their end of the socket.
+// Root and the daemon's user can read everything. Anyone else can only
+// read the files of namespaces owned by their uid or primary gid, or that
+// list them in query_uids or query_gids.
+
+type peerCredKey struct{}
+
+// StartQuerySocket serves config values on query_socket if it's set. The
+// returned function stops serving.
+func (a *App) StartQuerySocket() (func(), error) {
+	path := a.settings.QuerySocket
+	if len(path) == 0 {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Unable to remove stale query socket: %w", err)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on query socket: %w", err)
+	}
+	// Access is checked per request
+	if err := os.Chmod(path, 0o666); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("Unable to open up query socket: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/files", a.serveQueryList)
+	mux.HandleFunc("/v1/files/", a.serveQueryFile)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if conn, ok := c.(*net.UnixConn); ok {
+				if cred, err := peerCred(conn); err == nil {
+					return context.WithValue(ctx, peerCredKey{}, cred)
+				}
+			}
+			return ctx
+		},
+	}
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Printf("ERROR: Query socket stopped: %s", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}, nil
+}
+
+func peerCred(conn *net.UnixConn) (*syscall.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+// canQuery returns true if the peer with cred may read fname
+func (a *App) canQuery(cred *syscall.Ucred, fname string) bool {
+	if cred.Uid == 0 || int(cred.Uid) == os.Getuid() {
+		return true
+	}
+	name, ok := a.namespaceOf(fname)
+	if !ok {
+		return false
+	}
+	ns := a.settings.Namespaces[name]
+	if (ns.Uid != nil && *ns.Uid == int(cred.Uid)) || (ns.Gid != nil && *ns.Gid == int(cred.Gid)) {
+		return true
+	}
+	for _, uid := range ns.QueryUids {
+		if uid == int(cred.Uid) {
+			return true
+		}
+	}
+	for _, gid := range ns.QueryGids {
+		if gid == int(cred.Gid) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryPeer returns the caller's credentials, or writes an error if the
+// request can't be served
+func queryPeer(w http.ResponseWriter, r *http.Request) *syscall.Ucred {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+		return nil
+	}
+	cred, ok := r.Context().Value(peerCredKey{}).(*syscall.Ucred)
+	if !ok {
+		http.Error(w, "Unable to identify the caller", http.StatusForbidden)
+		return nil
+	}
+	return cred
+}
+
+func (a *App) serveQueryList(w http.ResponseWriter, r *http.Request) {
+	cred := queryPeer(w, r)
+	if cred == nil {
+		return
+	}
+	names := []string{}
+	for fname := range a.readManifest().Files {
+		if a.canQuery(cred, fname) {
+			names = append(names, fname)
+		}
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(names)
+}
+
+func (a *App) serveQueryFile(w http.ResponseWriter, r *http.Request) {
+	cred := queryPeer(w, r)
+	if cred == nil {
+		return
+	}
+	fname := strings.TrimPrefix(r.URL.Path, "/v1/files/")
+	// Only what fioconfig extracted is served, which also keeps names
+	// like "../" out of the secrets directory
+	if _, ok := a.readManifest().Files[fname]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !a.canQuery(cred, fname) {
+		LogEvent(EventQueryRejected, "Refusing to serve %s to uid %d", fname, cred.Uid)
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+	content, err := a.readManaged(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		logger.Printf("ERROR: Unable to read %s for a query: %s", fname, err)
+		http.Error(w, "Unable to read the file", http.StatusInternalServerError)
+		return
+	}
+	defer zeroize(content)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(content)
+}
@@ -0,0 +1,97 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuerySocket(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		uid, gid := 1000, 2000
+		app.settings.Namespaces = map[string]Namespace{
+			"web": {Uid: &uid, Gid: &gid, QueryUids: []int{1001}, QueryGids: []int{3000}},
+			"db":  {},
+		}
+		app.settings.QuerySocket = filepath.Join(tempdir, "run", "query.sock")
+		stop, err := app.StartQuerySocket()
+		require.Nil(t, err)
+		defer stop()
+
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, ConfigStruct{
+			"web/api.key": {Value: "web-key", Uid: &uid},
+			"db/pass":     {Value: "db-pass"},
+			"top":         {Value: "top"},
+		}})
+		require.Nil(t, err)
+
+		query := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", app.settings.QuerySocket)
+			},
+		}}
+		get := func(path string) (int, string) {
+			res, err := query.Get("http://fioconfig" + path)
+			require.Nil(t, err)
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			require.Nil(t, err)
+			return res.StatusCode, string(body)
+		}
+
+		// The test runs as the daemon's user, which can read everything
+		status, body := get("/v1/files")
+		require.Equal(t, http.StatusOK, status)
+		var names []string
+		require.Nil(t, json.Unmarshal([]byte(body), &names))
+		require.Equal(t, []string{"db/pass", "top", "web/api.key"}, names)
+
+		status, body = get("/v1/files/web/api.key")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "web-key", body)
+
+		status, _ = get("/v1/files/missing")
+		require.Equal(t, http.StatusNotFound, status)
+		status, _ = get("/v1/files/../sota.toml")
+		require.Equal(t, http.StatusNotFound, status)
+
+		res, err := query.Post("http://fioconfig/v1/files/top", "text/plain", nil)
+		require.Nil(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	})
+}
+
+func TestQueryAccess(t *testing.T) {
+	uid, gid := 1000, 2000
+	app := &App{}
+	app.settings.Namespaces = map[string]Namespace{
+		"web": {Uid: &uid, Gid: &gid, QueryUids: []int{1001}, QueryGids: []int{3000}},
+		"db":  {},
+	}
+	cred := func(uid, gid uint32) *syscall.Ucred {
+		return &syscall.Ucred{Uid: uid, Gid: gid}
+	}
+	require.True(t, app.canQuery(cred(0, 0), "top"))
+	require.True(t, app.canQuery(cred(0, 0), "db/pass"))
+
+	require.True(t, app.canQuery(cred(1000, 100), "web/api.key"))
+	require.True(t, app.canQuery(cred(1500, 2000), "web/api.key"))
+	require.True(t, app.canQuery(cred(1001, 100), "web/api.key"))
+	require.True(t, app.canQuery(cred(1500, 3000), "web/api.key"))
+	require.False(t, app.canQuery(cred(1500, 100), "web/api.key"))
+
+	require.False(t, app.canQuery(cred(1000, 2000), "db/pass"))
+	require.False(t, app.canQuery(cred(1000, 2000), "top"))
+}
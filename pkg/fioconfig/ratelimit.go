@@ -0,0 +1,42 @@
+package fioconfig
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Used when the server throttles us without saying for how long
+const defaultRetryAfter = 60 * time.Second
+
+// RateLimitedError is returned when the server responds with HTTP 429. The
+// caller should wait RetryAfter before checking in again.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("Rate limited by server, retry after %s", e.RetryAfter)
+}
+
+// parseRetryAfter handles both forms of Retry-After: delay-seconds and an
+// HTTP-date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if len(value) == 0 {
+		return defaultRetryAfter
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return defaultRetryAfter
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if delay := when.Sub(now); delay > 0 {
+			return delay.Round(time.Second)
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,82 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+var NeedsReenrollmentError = errors.New("Device needs re-enrollment, the server keeps rejecting its credentials")
+
+// Consecutive 401/403 responses before a device is considered to need
+// re-enrollment.
+const defaultAuthFailureLimit = 3
+
+func (a *App) authFailureLimit() int {
+	if a.settings.AuthFailureLimit > 0 {
+		return a.settings.AuthFailureLimit
+	}
+	return defaultAuthFailureLimit
+}
+
+// authFailed records a rejected check-in. Once the limit is reached it
+// returns NeedsReenrollmentError so callers can stop hammering the server,
+// and kicks off the re-enrollment command if a recovery token is present.
+func (a *App) authFailed(res *httpRes) error {
+	state := a.loadCheckInState()
+	state.AuthFailures++
+	if err := a.saveCheckInState(state); err != nil {
+		logger.Printf("Unable to save check-in state: %s", err)
+	}
+	err := fmt.Errorf("Unable to get %s - HTTP_%d: %s", a.configUrl, res.StatusCode, res.String())
+	limit := a.authFailureLimit()
+	if state.AuthFailures < limit {
+		return &AuthError{res.StatusCode, err}
+	}
+	LogEvent(EventNeedsReenrollment, "ERROR: %d consecutive authentication failures, device needs re-enrollment", state.AuthFailures)
+	if state.AuthFailures == limit {
+		ran, rerr := a.reenroll()
+		if rerr != nil {
+			LogEvent(EventReenrollFailed, "ERROR: Unable to re-enroll device: %s", rerr)
+		} else if ran {
+			return &AuthError{res.StatusCode, err}
+		}
+	}
+	return &AuthError{res.StatusCode, fmt.Errorf("%w: %s", NeedsReenrollmentError, err)}
+}
+
+// authSucceeded clears the failure count after the server accepts us again.
+func (a *App) authSucceeded(state checkInState) {
+	if state.AuthFailures == 0 {
+		return
+	}
+	state.AuthFailures = 0
+	if err := a.saveCheckInState(state); err != nil {
+		logger.Printf("Unable to save check-in state: %s", err)
+	}
+}
+
+// reenroll runs the configured re-enrollment command when a recovery token
+// has been provisioned. It returns false if there was nothing to run.
+func (a *App) reenroll() (bool, error) {
+	if len(a.settings.ReenrollCommand) == 0 || len(a.settings.RecoveryToken) == 0 {
+		return false, nil
+	}
+	if _, err := os.Stat(a.settings.RecoveryToken); err != nil {
+		LogEvent(EventNoRecoveryToken, "No recovery token at %s, not re-enrolling", a.settings.RecoveryToken)
+		return false, nil
+	}
+	LogEvent(EventReenrolling, "Running re-enrollment command: %v", a.settings.ReenrollCommand)
+	cmd := exec.Command(a.settings.ReenrollCommand[0], a.settings.ReenrollCommand[1:]...)
+	cmd.Env = append(os.Environ(), "RECOVERY_TOKEN_FILE="+a.settings.RecoveryToken)
+	cmd.Env = append(cmd.Env, "SOTA_DIR="+a.sotaConfig)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return true, err
+	}
+	// The command has presumably given us new credentials
+	a.authSucceeded(a.loadCheckInState())
+	return true, a.Reload()
+}
@@ -0,0 +1,72 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAuthFailures(t *testing.T) {
+	status := http.StatusForbidden
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		app.settings.AuthFailureLimit = 2
+		err = app.checkin(context.Background(), client, crypto)
+		require.NotNil(t, err)
+		require.False(t, errors.Is(err, NeedsReenrollmentError))
+		var authErr *AuthError
+		require.True(t, errors.As(err, &authErr), err)
+		require.Equal(t, http.StatusForbidden, authErr.StatusCode)
+		err = app.checkin(context.Background(), client, crypto)
+		require.True(t, errors.Is(err, NeedsReenrollmentError))
+		require.True(t, errors.Is(err, AuthFailedError))
+		require.Equal(t, 2, app.loadCheckInState().AuthFailures)
+
+		// The server accepting us again clears the state
+		status = http.StatusNotModified
+		require.True(t, errors.Is(app.checkin(context.Background(), client, crypto), NotModifiedError))
+		require.Equal(t, 0, app.loadCheckInState().AuthFailures)
+	})
+}
+
+func TestCheckReenroll(t *testing.T) {
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		marker := filepath.Join(tempdir, "reenrolled")
+		app.settings.AuthFailureLimit = 1
+		app.settings.ReenrollCommand = []string{"/usr/bin/touch", marker}
+		app.settings.RecoveryToken = filepath.Join(tempdir, "recovery-token")
+
+		// Without a recovery token nothing gets run
+		err = app.checkin(context.Background(), client, crypto)
+		require.True(t, errors.Is(err, NeedsReenrollmentError))
+		assertNoFile(t, marker)
+
+		require.Nil(t, os.WriteFile(app.settings.RecoveryToken, []byte("token"), 0o600))
+		require.Nil(t, app.saveCheckInState(checkInState{}))
+		err = app.checkin(context.Background(), client, crypto)
+		require.NotNil(t, err)
+		require.False(t, errors.Is(err, NeedsReenrollmentError))
+		assertFile(t, marker, nil)
+		require.Equal(t, 0, app.loadCheckInState().AuthFailures)
+	})
+}
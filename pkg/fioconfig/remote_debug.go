@@ -0,0 +1,191 @@
+package fioconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Config file support teams can use to request diagnostics from a device
+// when remote_debug is enabled. Its value is a JSON object of action names
+// to their arguments, e.g.:
+//
+//	{"verbose": {"hours": 4}, "support-bundle": {"id": "ticket-123"}}
+//
+// Actions run once each time the file changes.
+const remoteDebugConfigFile = "fio-remote-debug"
+
+// An action may only run this often no matter how often it's requested
+const remoteDebugInterval = time.Hour
+
+// The longest verbose logging can be enabled for with one request
+const maxVerboseDuration = 24 * time.Hour
+
+// RemoteDebugAction performs a diagnostic requested by the server. `args`
+// is the action's value from the fio-remote-debug config file.
+type RemoteDebugAction func(app *App, client *http.Client, args json.RawMessage) error
+
+var remoteDebugActions = map[string]RemoteDebugAction{
+	"verbose":        verboseAction,
+	"support-bundle": supportBundleAction,
+}
+
+// RegisterRemoteDebugAction allows programs embedding fioconfig to add
+// diagnostics the server can request.
+func RegisterRemoteDebugAction(name string, action RemoteDebugAction) {
+	remoteDebugActions[name] = action
+}
+
+// remoteDebugState is persisted so rate limits and verbose logging survive
+// restarts of the daemon.
+type remoteDebugState struct {
+	VerboseUntil time.Time            `json:"verbose-until,omitempty"`
+	LastRun      map[string]time.Time `json:"last-run,omitempty"`
+}
+
+func (a *App) remoteDebugStateFile() string {
+	return filepath.Join(a.stateDir(), "remote-debug.state")
+}
+
+func (a *App) loadRemoteDebugState() remoteDebugState {
+	var state remoteDebugState
+	bytes, err := os.ReadFile(a.remoteDebugStateFile())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to read remote debug state: %s", err)
+		}
+		return state
+	}
+	if err := json.Unmarshal(bytes, &state); err != nil {
+		logger.Printf("Unable to parse remote debug state: %s", err)
+	}
+	return state
+}
+
+func (a *App) saveRemoteDebugState(state remoteDebugState) error {
+	bytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return safeWrite(a.remoteDebugStateFile(), bytes)
+}
+
+// verbose returns true when extra details should be logged, either because
+// of the debug setting or because the server asked for it.
+func (a *App) verbose() bool {
+	return a.settings.Debug || time.Now().Before(a.loadRemoteDebugState().VerboseUntil)
+}
+
+func (a *App) debugf(format string, args ...interface{}) {
+	if a.verbose() {
+		logger.Printf("DEBUG: "+format, args...)
+	}
+}
+
+// queueRemoteDebug saves a new debug request to run once the client is
+// available after extraction.
+func (a *App) queueRemoteDebug(request []byte) {
+	if !a.settings.RemoteDebug {
+		LogEvent(EventRemoteDebugIgnored, "Ignoring %s since remote_debug is not enabled", remoteDebugConfigFile)
+		return
+	}
+	a.remoteDebug = request
+}
+
+// runRemoteDebug performs the actions of a queued debug request. Failures
+// are only logged since they must never break a check-in.
+func (a *App) runRemoteDebug(client *http.Client) {
+	request := a.remoteDebug
+	a.remoteDebug = nil
+	if request == nil {
+		return
+	}
+	var actions map[string]json.RawMessage
+	if err := json.Unmarshal(request, &actions); err != nil {
+		LogEvent(EventRemoteDebugFailed, "Unable to parse %s: %s", remoteDebugConfigFile, err)
+		return
+	}
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		action, ok := remoteDebugActions[name]
+		if !ok {
+			LogEvent(EventRemoteDebugFailed, "Unknown remote debug action: %s", name)
+			continue
+		}
+		state := a.loadRemoteDebugState()
+		if last, ok := state.LastRun[name]; ok && time.Since(last) < remoteDebugInterval {
+			LogEvent(EventRemoteDebugFailed, "Not running remote debug action %s, it last ran at %s", name, last.Format(time.RFC3339))
+			continue
+		}
+		LogEvent(EventRemoteDebugRun, "Running remote debug action %s", name)
+		if err := action(a, client, actions[name]); err != nil {
+			LogEvent(EventRemoteDebugFailed, "Remote debug action %s failed: %s", name, err)
+		}
+		// The action may have updated the state too
+		state = a.loadRemoteDebugState()
+		if state.LastRun == nil {
+			state.LastRun = make(map[string]time.Time)
+		}
+		state.LastRun[name] = time.Now()
+		if err := a.saveRemoteDebugState(state); err != nil {
+			LogEvent(EventStateSaveFailed, "Unable to save remote debug state: %s", err)
+		}
+	}
+}
+
+// verboseAction enables debug logging for a number of hours. Zero turns it
+// back off.
+func verboseAction(app *App, client *http.Client, args json.RawMessage) error {
+	var opts struct {
+		Hours float64 `json:"hours"`
+	}
+	if err := json.Unmarshal(args, &opts); err != nil {
+		return fmt.Errorf("Invalid arguments: %w", err)
+	}
+	duration := time.Duration(opts.Hours * float64(time.Hour))
+	if duration > maxVerboseDuration {
+		duration = maxVerboseDuration
+	}
+	state := app.loadRemoteDebugState()
+	state.VerboseUntil = time.Now().Add(duration).UTC()
+	logger.Printf("Verbose logging enabled until %s", state.VerboseUntil.Format(time.RFC3339))
+	return app.saveRemoteDebugState(state)
+}
+
+// supportBundleAction uploads the same redacted bundle as
+// `fioconfig support-bundle` so support can look at it without SSH access.
+func supportBundleAction(app *App, client *http.Client, args json.RawMessage) error {
+	var opts struct {
+		Id string `json:"id"`
+	}
+	if err := json.Unmarshal(args, &opts); err != nil {
+		return fmt.Errorf("Invalid arguments: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := app.WriteSupportBundle(&buf); err != nil {
+		return err
+	}
+	upload := struct {
+		Id     string `json:"id"`
+		Bundle []byte `json:"bundle"`
+	}{opts.Id, buf.Bytes()}
+	url := app.configUrl + "-support-bundle"
+	res, err := httpPost(client, url, upload)
+	if err != nil {
+		return err
+	} else if res.StatusCode < 200 || res.StatusCode > 204 {
+		return fmt.Errorf("Unable to upload support bundle: HTTP_%d - %s", res.StatusCode, res.String())
+	}
+	return nil
+}
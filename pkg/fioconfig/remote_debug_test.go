@@ -0,0 +1,70 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteDebug(t *testing.T) {
+	var encbuf []byte
+	uploads := 0
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/config-support-bundle" {
+			var upload struct {
+				Id     string `json:"id"`
+				Bundle []byte `json:"bundle"`
+			}
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&upload))
+			require.Equal(t, "ticket-1", upload.Id)
+			require.NotEmpty(t, upload.Bundle)
+			uploads++
+			w.WriteHeader(201)
+			return
+		}
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		app.configUrl += "/config"
+
+		serve := func(request string) {
+			config := ConfigStruct{remoteDebugConfigFile: &ConfigFile{Value: request, Unencrypted: true}}
+			var err error
+			encbuf, err = json.Marshal(config)
+			require.Nil(t, err)
+			require.Nil(t, app.checkin(context.Background(), client, crypto))
+		}
+
+		// Nothing happens unless it's enabled
+		serve(`{"verbose": {"hours": 100}, "support-bundle": {"id": "ticket-1"}}`)
+		require.False(t, app.verbose())
+		require.Equal(t, 0, uploads)
+
+		app.settings.RemoteDebug = true
+		serve(`{"verbose": {"hours": 48}, "support-bundle": {"id": "ticket-1"}}`)
+		require.True(t, app.verbose())
+		require.Equal(t, 1, uploads)
+		until := app.loadRemoteDebugState().VerboseUntil
+		require.True(t, until.Before(time.Now().Add(maxVerboseDuration+time.Minute)))
+
+		// Actions are rate limited
+		serve(`{"support-bundle": {"id": "ticket-1"}, "unknown": {}}`)
+		require.Equal(t, 1, uploads)
+
+		// Unchanged requests don't run again
+		state := app.loadRemoteDebugState()
+		state.LastRun = nil
+		require.Nil(t, app.saveRemoteDebugState(state))
+		require.Nil(t, app.checkin(context.Background(), client, crypto))
+		require.Equal(t, 1, uploads)
+	})
+}
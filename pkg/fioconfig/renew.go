@@ -0,0 +1,160 @@
+package fioconfig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Default slots to alternate between when writing a renewed cert to an HSM
+var defaultP11CertIds = []string{"03", "09"}
+
+// certRenewBefore returns how long before the certificate expires it should
+// be renewed. Unless configured, that's once two thirds of its lifetime has
+// passed.
+func (a *App) certRenewBefore(cert *x509.Certificate) (time.Duration, error) {
+	if len(a.settings.CertRenewBefore) > 0 {
+		before, err := time.ParseDuration(a.settings.CertRenewBefore)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid fioconfig.cert_renew_before: %w", err)
+		}
+		return before, nil
+	}
+	return cert.NotAfter.Sub(cert.NotBefore) / 3, nil
+}
+
+// certRenewalDue returns the client certificate if it's due for renewal
+func (a *App) certRenewalDue(client *http.Client, now time.Time) (*x509.Certificate, error) {
+	tlsCert := client.Transport.(*http.Transport).TLSClientConfig.Certificates[0]
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse client certificate: %w", err)
+	}
+	before, err := a.certRenewBefore(cert)
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(cert.NotAfter.Add(-before)) {
+		return nil, nil
+	}
+	return cert, nil
+}
+
+// RenewCertIfDue re-enrolls the client certificate with the EST server
+// configured by `fioconfig.est_server` once it's close to expiring. Unlike
+// the renew-cert command, the existing private key is kept so config values
+// don't need to be re-encrypted. It's a no-op if no EST server is set.
+func (a *App) RenewCertIfDue() error {
+	if len(a.settings.EstServer) == 0 {
+		return nil
+	}
+	client, _, err := a.getClient()
+	if err != nil {
+		return err
+	}
+	if !a.clockTrusted(time.Now()) {
+		logger.Printf("Not checking if the client certificate is due for renewal until the system time is set")
+		return nil
+	}
+	cert, err := a.certRenewalDue(client, time.Now())
+	if err != nil || cert == nil {
+		return err
+	}
+	LogEvent(EventCertRenewing, "Client certificate expires %s, renewing", cert.NotAfter)
+
+	tlsCert := client.Transport.(*http.Transport).TLSClientConfig.Certificates[0]
+	signer, ok := tlsCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("Client private key can't sign a certificate request")
+	}
+	newCert, err := estReenroll(client, a.settings.EstServer, signer, cert)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(newCert.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo) {
+		return errors.New("Renewed certificate is not for the device's existing key")
+	}
+	if err := a.installCert(newCert); err != nil {
+		return err
+	}
+	LogEvent(EventCertRenewed, "Client certificate renewed, now expires %s", newCert.NotAfter)
+	a.publish(ChangeEvent{Type: ChangeCertRenewed})
+	// Rebuild the TLS client with the new cert
+	return a.Reload()
+}
+
+// installCert atomically swaps the renewed certificate into place
+func (a *App) installCert(cert *x509.Certificate) error {
+	source, err := tomlGet(a.sota, "tls.pkey_source")
+	if err != nil {
+		return err
+	}
+	switch source {
+	case "file":
+		certFile, err := tomlGet(a.sota, "import.tls_clientcert_path")
+		if err != nil {
+			return err
+		}
+		certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		return safeWrite(certFile, certPem)
+	case "pkcs11":
+		return a.installCertPkcs11(cert)
+	default:
+		return fmt.Errorf("Certificate renewal is not supported for pkey_source %s", source)
+	}
+}
+
+// installCertPkcs11 imports the cert into the slot not currently in use and
+// then points sota.toml at it, so a failure part way leaves the current cert
+// in place.
+func (a *App) installCertPkcs11(cert *x509.Certificate) error {
+	_, crypto, err := a.getClient()
+	if err != nil {
+		return err
+	}
+	ec, ok := crypto.(*EciesCrypto)
+	if !ok || ec.ctx == nil {
+		return errors.New("Unable to access PKCS#11 context")
+	}
+	ids := defaultP11CertIds
+	if len(a.settings.P11CertIds) > 0 {
+		ids = strings.Split(a.settings.P11CertIds, ",")
+	}
+	cur, err := tomlGet(a.sota, "p11.tls_clientcert_id")
+	if err != nil {
+		return err
+	}
+	newId := ""
+	for _, id := range ids {
+		if id != cur {
+			newId = id
+			break
+		}
+	}
+	if len(newId) == 0 {
+		return errors.New("No free PKCS#11 slot for the renewed certificate")
+	}
+	if err := ec.ctx.DeleteCertificate(idToBytes(newId), nil, nil); err != nil {
+		return fmt.Errorf("Unable to free up slot(%s) for new cert: %w", newId, err)
+	}
+	if err := ec.ctx.ImportCertificateWithLabel(idToBytes(newId), []byte("client"), cert); err != nil {
+		return fmt.Errorf("Unable to import new cert into HSM: %w", err)
+	}
+
+	a.sota.Set("p11.tls_clientcert_id", newId)
+	buf, err := a.sota.Marshal()
+	if err != nil {
+		return fmt.Errorf("Unable to marshall new sota.toml: %w", err)
+	}
+	if err := safeWrite(filepath.Join(a.sotaConfig, "sota.toml"), buf); err != nil {
+		return fmt.Errorf("Unable to update sota.toml with new cert location: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package fioconfig
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mozilla.org/pkcs7"
+)
+
+func TestRenewCertIfDue(t *testing.T) {
+	kp, err := tls.X509KeyPair([]byte(client_pem), []byte(pkey_pem))
+	require.Nil(t, err)
+
+	requests := 0
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/est/simplereenroll", r.URL.Path)
+		requests++
+		// Hand back the same cert, as if it were freshly issued
+		w.Header().Add("content-type", "application/pkcs7-mime")
+		w.WriteHeader(201)
+		bytes, err := pkcs7.DegenerateCertificate(kp.Certificate[0])
+		require.Nil(t, err)
+		_, err = w.Write([]byte(base64.StdEncoding.EncodeToString(bytes)))
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		// Nothing to do without an EST server
+		require.Nil(t, app.RenewCertIfDue())
+		require.Equal(t, 0, requests)
+
+		estServer := app.configUrl + "/est"
+		app.settings.EstServer = estServer
+		app.settings.CertRenewBefore = "1s"
+		require.Nil(t, app.RenewCertIfDue())
+		require.Equal(t, 0, requests)
+
+		certFile := filepath.Join(tempdir, "client.pem")
+		require.Nil(t, os.WriteFile(certFile, []byte(client_pem), 0o644))
+		old := time.Now().Add(-time.Hour)
+		require.Nil(t, os.Chtimes(certFile, old, old))
+
+		app.settings.CertRenewBefore = "876000h"
+		require.Nil(t, app.RenewCertIfDue())
+		require.Equal(t, 1, requests)
+		fi, err := os.Stat(certFile)
+		require.Nil(t, err)
+		require.True(t, fi.ModTime().After(old))
+
+		// Renewing reloads sota.toml which doesn't have these settings
+		app.settings.EstServer = estServer
+		app.settings.CertRenewBefore = "bad"
+		require.NotNil(t, app.RenewCertIfDue())
+	})
+}
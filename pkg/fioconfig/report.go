@@ -0,0 +1,209 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HandlerResult is the outcome of running a file's on-changed command
+type HandlerResult struct {
+	File     string   `json:"file"`
+	Command  []string `json:"command"`
+	ExitCode int      `json:"exit-code"`
+	Error    string   `json:"error,omitempty"`
+	Skipped  bool     `json:"skipped,omitempty"` // Not run because it's unsafe
+	TimedOut bool     `json:"timed-out,omitempty"`
+	// The end of what the command wrote to stdout and stderr
+	Output string `json:"output,omitempty"`
+	// All the files a deduplicated handler ran for
+	Files []string `json:"files,omitempty"`
+	// The file's systemd reload and restart actions
+	Units []UnitResult `json:"units,omitempty"`
+	// The containers restarted for the file's compose_restart apps
+	Containers []ContainerResult `json:"containers,omitempty"`
+}
+
+// ExtractReport describes the outcome of applying a config to the device
+type ExtractReport struct {
+	Code      EventCode         `json:"code"`
+	Timestamp time.Time         `json:"timestamp"`
+	Applied   []string          `json:"applied"`
+	Removed   []string          `json:"removed"`
+	Failed    map[string]string `json:"failed,omitempty"`
+	// None of the files existed before this extraction
+	Initial bool `json:"initial,omitempty"`
+	// Why a verifier refused to apply the config
+	Rejected string `json:"rejected,omitempty"`
+	// Files whose value came from the local shadow_dir
+	Overridden []string `json:"overridden,omitempty"`
+	// Files the server sent or removed that protected_files kept as is
+	Protected []string `json:"protected,omitempty"`
+	// Files the server removed that keep_on_remove left on disk
+	Released []string `json:"released,omitempty"`
+	// Files a new config would change that a hold kept as they are
+	Held []string `json:"held,omitempty"`
+	// Files a new config would change that are waiting for an apply
+	// window, and when it opens
+	Pending     []string   `json:"pending,omitempty"`
+	WindowOpens *time.Time `json:"window-opens,omitempty"`
+	// Files fioconfig created that aren't in the config anymore, see
+	// CollectGarbage
+	Orphans  []string        `json:"orphans,omitempty"`
+	Handlers []HandlerResult `json:"handlers,omitempty"`
+	// Who changed the config and why, when the server said
+	Change *ChangeInfo `json:"change,omitempty"`
+	// The after_extract_command, run once all handlers are done
+	AfterExtract *HandlerResult `json:"after-extract,omitempty"`
+	// The VPN interface, when the extraction changed its config
+	Vpn *VpnStatus `json:"vpn,omitempty"`
+	// The NetworkManager profiles the extraction changed
+	Connections []ConnectionResult `json:"connections,omitempty"`
+	// Handlers, systemd actions, and container restarts that didn't succeed. The files were
+	// still applied.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// The manifest before and after, for the audit log
+	prevHashes manifest
+	hashes     manifest
+}
+
+func newExtractReport() *ExtractReport {
+	return &ExtractReport{
+		Code:      EventConfigApplied,
+		Timestamp: time.Now().UTC(),
+		Applied:   []string{},
+		Removed:   []string{},
+	}
+}
+
+func (r *ExtractReport) fail(fname string, err error) {
+	r.Code = EventExtractFailed
+	if r.Failed == nil {
+		r.Failed = make(map[string]string)
+	}
+	r.Failed[fname] = err.Error()
+}
+
+// withoutFailed returns config without the files report has failures for,
+// leaving config as it is
+func withoutFailed(config ConfigStruct, report *ExtractReport) ConfigStruct {
+	if len(report.Failed) == 0 {
+		return config
+	}
+	next := make(ConfigStruct, len(config))
+	for fname, cfgFile := range config {
+		if _, ok := report.Failed[fname]; !ok {
+			next[fname] = cfgFile
+		}
+	}
+	return next
+}
+
+// partialError returns an ExtractError if anything failed once the files
+// were in place
+func (r *ExtractReport) partialError() error {
+	if len(r.Failed) == 0 && len(r.Warnings) == 0 {
+		return nil
+	}
+	return &ExtractError{r.Failed, r.Warnings}
+}
+
+func (r *ExtractReport) addHandler(result *HandlerResult) {
+	if result != nil {
+		r.Handlers = append(r.Handlers, *result)
+	}
+}
+
+// addWarnings records the handlers that exited non-zero, failed to run, or
+// whose systemd actions failed, and the NetworkManager profiles and VPN
+// that couldn't be brought up.
+// Asking fioconfig to exit isn't a failure.
+func (r *ExtractReport) addWarnings() {
+	handlers := r.Handlers
+	if r.AfterExtract != nil {
+		handlers = append(handlers[:len(handlers):len(handlers)], *r.AfterExtract)
+	}
+	for _, h := range handlers {
+		name := h.File
+		if len(name) == 0 {
+			name = "after-extract"
+		}
+		if h.TimedOut {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("%s: on-change command timed out", name))
+		} else if h.ExitCode != 0 && h.ExitCode != onChangedForceExit {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("%s: on-change command exited with %d", name, h.ExitCode))
+		}
+		for _, u := range h.Units {
+			if len(u.Error) > 0 {
+				r.Warnings = append(r.Warnings, fmt.Sprintf("%s: unable to %s %s: %s", name, u.Action, u.Unit, u.Error))
+			}
+		}
+		for _, c := range h.Containers {
+			if len(c.Error) > 0 {
+				target := c.App
+				if len(c.Container) > 0 {
+					target = c.Container
+				}
+				r.Warnings = append(r.Warnings, fmt.Sprintf("%s: unable to restart %s: %s", name, target, c.Error))
+			}
+		}
+	}
+	for _, c := range r.Connections {
+		if len(c.Error) > 0 {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("%s: unable to %s NetworkManager connection: %s", c.File, c.Action, c.Error))
+		}
+	}
+	if r.Vpn != nil && len(r.Vpn.Error) > 0 {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%s: %s", r.Vpn.Interface, r.Vpn.Error))
+	}
+}
+
+func (a *App) lastReportFile() string {
+	return filepath.Join(a.stateDir(), "last-extract.json")
+}
+
+func (a *App) saveLastReport(report *ExtractReport) error {
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return safeWrite(a.lastReportFile(), bytes)
+}
+
+// LastReport returns the outcome of the most recent extraction, including
+// the output of its on-changed commands.
+func (a *App) LastReport() (*ExtractReport, error) {
+	bytes, err := os.ReadFile(a.lastReportFile())
+	if err != nil {
+		return nil, err
+	}
+	var report ExtractReport
+	if err := json.Unmarshal(bytes, &report); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %w", a.lastReportFile(), err)
+	}
+	return &report, nil
+}
+
+// reportStatus lets the server know whether a config change actually took
+// effect on the device rather than just that it was downloaded.
+func (a *App) reportStatus(ctx context.Context, client *http.Client, report *ExtractReport) {
+	if err := a.postStatus(ctx, client, report); err != nil {
+		LogEvent(EventStatusReportFailed, "%s", err)
+	}
+}
+
+func (a *App) postStatus(ctx context.Context, client *http.Client, report *ExtractReport) error {
+	url := a.configUrl + "-status"
+	res, err := httpPostContext(ctx, client, url, report)
+	if err != nil {
+		return fmt.Errorf("Unable to report extraction status: %w", err)
+	} else if res.StatusCode < 200 || res.StatusCode > 204 {
+		return fmt.Errorf("Server could not process extraction status: HTTP_%d - %s", res.StatusCode, res.String())
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package fioconfig
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Values of tls_ocsp. "stapled" checks the OCSP response the server staples
+// to the handshake, if any. "required" also asks the responder in the
+// certificate when nothing is stapled, and fails if no good response can be
+// had.
+const (
+	OcspOff      = ""
+	OcspStapled  = "stapled"
+	OcspRequired = "required"
+)
+
+var (
+	// ServerCertRevokedError is returned when a CRL or OCSP response says a
+	// certificate in the server's chain has been revoked
+	ServerCertRevokedError = errors.New("Server certificate has been revoked")
+	// RevocationUnknownError is returned when a CRL has expired, or when
+	// tls_ocsp is "required" and the server certificate's status can't be
+	// confirmed
+	RevocationUnknownError = errors.New("Unable to confirm server certificate has not been revoked")
+)
+
+// revocationChecker checks the server's chain against tls_crl_file and
+// OCSP as set by tls_ocsp
+type revocationChecker struct {
+	crls   []*x509.RevocationList
+	ocsp   string
+	client *http.Client
+	now    func() time.Time
+}
+
+// newRevocationChecker returns nil when no revocation checking is set up
+func newRevocationChecker(settings Settings) (*revocationChecker, error) {
+	switch settings.TlsOcsp {
+	case OcspOff, OcspStapled, OcspRequired:
+	default:
+		return nil, fmt.Errorf("Unknown fioconfig.tls_ocsp: %s", settings.TlsOcsp)
+	}
+	if len(settings.TlsCrlFile) == 0 && settings.TlsOcsp == OcspOff {
+		return nil, nil
+	}
+	rc := &revocationChecker{
+		ocsp: settings.TlsOcsp,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{Proxy: proxyFunc(settings)},
+		},
+		now: time.Now,
+	}
+	if len(settings.TlsCrlFile) > 0 {
+		var err error
+		if rc.crls, err = loadCrls(settings.TlsCrlFile); err != nil {
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// loadCrls reads a file of PEM CRLs, or a single DER one
+func loadCrls(path string) ([]*x509.RevocationList, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read CRL file: %w", err)
+	}
+	if !bytes.Contains(buf, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(buf)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse %s: %w", path, err)
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+	var crls []*x509.RevocationList
+	for {
+		var block *pem.Block
+		block, buf = pem.Decode(buf)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse %s: %w", path, err)
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, fmt.Errorf("No CRLs found in %s", path)
+	}
+	return crls, nil
+}
+
+func (rc *revocationChecker) check(cs tls.ConnectionState) error {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+		return nil
+	}
+	chain := cs.VerifiedChains[0]
+	for i := 0; i < len(chain)-1; i++ {
+		if err := rc.checkCrls(chain[i], chain[i+1]); err != nil {
+			return err
+		}
+	}
+	if rc.ocsp == OcspOff {
+		return nil
+	}
+	return rc.checkOcsp(chain[0], chain[1], cs.OCSPResponse)
+}
+
+// checkCrls looks for cert in the CRLs issuer signed. A CRL past its
+// NextUpdate can't say a certificate is still good, since it may have been
+// revoked since.
+func (rc *revocationChecker) checkCrls(cert, issuer *x509.Certificate) error {
+	now := rc.now()
+	for _, crl := range rc.crls {
+		if crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		for _, revoked := range crl.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("%w: %s is listed in the CRL", ServerCertRevokedError, cert.Subject)
+			}
+		}
+		if now.Before(crl.ThisUpdate) || (!crl.NextUpdate.IsZero() && now.After(crl.NextUpdate)) {
+			return fmt.Errorf("%w: CRL from %s is not current", RevocationUnknownError, issuer.Subject)
+		}
+	}
+	return nil
+}
+
+func (rc *revocationChecker) checkOcsp(cert, issuer *x509.Certificate, stapled []byte) error {
+	raw := stapled
+	if len(raw) == 0 {
+		if rc.ocsp != OcspRequired {
+			return nil
+		}
+		var err error
+		if raw, err = rc.fetchOcsp(cert, issuer); err != nil {
+			return fmt.Errorf("%w: %s", RevocationUnknownError, err)
+		}
+	}
+	res, err := ocsp.ParseResponseForCert(raw, cert, issuer)
+	if err != nil {
+		return fmt.Errorf("%w: invalid OCSP response: %s", RevocationUnknownError, err)
+	}
+	now := rc.now()
+	if now.Before(res.ThisUpdate) || (!res.NextUpdate.IsZero() && now.After(res.NextUpdate)) {
+		return fmt.Errorf("%w: OCSP response is not current", RevocationUnknownError)
+	}
+	switch res.Status {
+	case ocsp.Revoked:
+		return fmt.Errorf("%w: %s was revoked at %s", ServerCertRevokedError, cert.Subject, res.RevokedAt)
+	case ocsp.Unknown:
+		if rc.ocsp == OcspRequired {
+			return fmt.Errorf("%w: OCSP responder doesn't know %s", RevocationUnknownError, cert.Subject)
+		}
+	}
+	return nil
+}
+
+func (rc *revocationChecker) fetchOcsp(cert, issuer *x509.Certificate) ([]byte, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, errors.New("certificate has no OCSP responder")
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := rc.client.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned HTTP_%d", res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 64*1024))
+}
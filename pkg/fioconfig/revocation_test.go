@@ -0,0 +1,138 @@
+package fioconfig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+func revocationChain(t *testing.T, ocspServer string) (*x509.Certificate, *x509.Certificate, crypto.Signer) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	caTmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &caTmpl, &caTmpl, caKey.Public(), caKey)
+	require.Nil(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "gateway"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if len(ocspServer) > 0 {
+		tmpl.OCSPServer = []string{ocspServer}
+	}
+	der, err = x509.CreateCertificate(rand.Reader, &tmpl, ca, key.Public(), caKey)
+	require.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return leaf, ca, caKey
+}
+
+func ocspResponse(t *testing.T, leaf, ca *x509.Certificate, key crypto.Signer, status int) []byte {
+	res, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+		Status:       status,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now().Add(-time.Minute),
+	}, key)
+	require.Nil(t, err)
+	return res
+}
+
+func TestRevocationCrl(t *testing.T) {
+	leaf, ca, caKey := revocationChain(t, "")
+	writeCrl := func(revoked []pkix.RevokedCertificate, nextUpdate time.Time) string {
+		crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:              big.NewInt(1),
+			ThisUpdate:          time.Now().Add(-time.Hour),
+			NextUpdate:          nextUpdate,
+			RevokedCertificates: revoked,
+		}, ca, caKey)
+		require.Nil(t, err)
+		crlFile := filepath.Join(t.TempDir(), "crl.pem")
+		require.Nil(t, os.WriteFile(crlFile, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}), 0o644))
+		return crlFile
+	}
+	revoked := []pkix.RevokedCertificate{{SerialNumber: leaf.SerialNumber, RevocationTime: time.Now()}}
+
+	rc, err := newRevocationChecker(Settings{TlsCrlFile: writeCrl(revoked, time.Now().Add(time.Hour))})
+	require.Nil(t, err)
+	err = rc.check(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}})
+	require.True(t, errors.Is(err, ServerCertRevokedError), err)
+	// The CA itself isn't revoked
+	require.Nil(t, rc.checkCrls(ca, ca))
+
+	// An expired CRL can't vouch for anything
+	rc, err = newRevocationChecker(Settings{TlsCrlFile: writeCrl(nil, time.Now().Add(-time.Minute))})
+	require.Nil(t, err)
+	err = rc.check(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}})
+	require.True(t, errors.Is(err, RevocationUnknownError), err)
+
+	rc, err = newRevocationChecker(Settings{})
+	require.Nil(t, err)
+	require.Nil(t, rc)
+	_, err = newRevocationChecker(Settings{TlsOcsp: "sometimes"})
+	require.NotNil(t, err)
+}
+
+func TestRevocationOcsp(t *testing.T) {
+	status := ocsp.Good
+	var leaf, ca *x509.Certificate
+	var caKey crypto.Signer
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(ocspResponse(t, leaf, ca, caKey, status))
+	}))
+	defer responder.Close()
+	leaf, ca, caKey = revocationChain(t, responder.URL)
+	chains := [][]*x509.Certificate{{leaf, ca}}
+
+	rc, err := newRevocationChecker(Settings{TlsOcsp: OcspStapled})
+	require.Nil(t, err)
+	require.Nil(t, rc.check(tls.ConnectionState{VerifiedChains: chains}))
+	require.Nil(t, rc.check(tls.ConnectionState{VerifiedChains: chains, OCSPResponse: ocspResponse(t, leaf, ca, caKey, ocsp.Good)}))
+	err = rc.check(tls.ConnectionState{VerifiedChains: chains, OCSPResponse: ocspResponse(t, leaf, ca, caKey, ocsp.Revoked)})
+	require.True(t, errors.Is(err, ServerCertRevokedError), err)
+	err = rc.check(tls.ConnectionState{VerifiedChains: chains, OCSPResponse: []byte("garbage")})
+	require.True(t, errors.Is(err, RevocationUnknownError), err)
+
+	// Without a staple the responder is asked
+	rc, err = newRevocationChecker(Settings{TlsOcsp: OcspRequired})
+	require.Nil(t, err)
+	require.Nil(t, rc.check(tls.ConnectionState{VerifiedChains: chains}))
+	status = ocsp.Revoked
+	err = rc.check(tls.ConnectionState{VerifiedChains: chains})
+	require.True(t, errors.Is(err, ServerCertRevokedError), err)
+	status = ocsp.Unknown
+	err = rc.check(tls.ConnectionState{VerifiedChains: chains})
+	require.True(t, errors.Is(err, RevocationUnknownError), err)
+}
@@ -1,11 +1,10 @@
-package internal
+package fioconfig
 
 import (
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -33,6 +32,12 @@ type CertRotationState struct {
 
 	// Used by finalizeStep
 	Finalized bool
+
+	// The key and cert being replaced. Recorded by verifyStep and removed
+	// once the device has checked in with the new ones.
+	OldKey  string
+	OldCert string
+	Retired bool
 }
 
 type CertRotationHandler struct {
@@ -52,21 +57,37 @@ type CertRotationStep interface {
 }
 
 // NewCertRotationHandler constructs a new handler to initiate a rotation with
-func NewCertRotationHandler(app *App, stateFile, estServer string) *CertRotationHandler {
-	eventUrl := tomlGet(app.sota, "tls.server") + "/events"
+func NewCertRotationHandler(app *App, stateFile, estServer string) (*CertRotationHandler, error) {
+	server, err := tomlGet(app.sota, "tls.server")
+	if err != nil {
+		return nil, err
+	}
+	eventUrl := server + "/events"
 
-	target, err := LoadCurrentTarget(filepath.Join(tomlGet(app.sota, "storage.path"), "current-target"))
+	storagePath, err := tomlGet(app.sota, "storage.path")
+	if err != nil {
+		return nil, err
+	}
+	target, err := LoadCurrentTarget(filepath.Join(storagePath, "current-target"))
 	if err != nil {
-		log.Printf("Unable to parse current-target. Events posted to server will be missing content: %s", err)
+		logger.Printf("Unable to parse current-target. Events posted to server will be missing content: %s", err)
 	}
 
-	client, crypto := createClient(app.sota)
+	client, crypto, err := createClient(app.sota)
+	if err != nil {
+		return nil, err
+	}
+	ecies, ok := crypto.(*EciesCrypto)
+	if !ok {
+		crypto.Close()
+		return nil, errors.New("Certificate rotation is not supported for this tls.pkey_source")
+	}
 	return &CertRotationHandler{
 		State:     CertRotationState{EstServer: estServer},
 		stateFile: stateFile,
 		app:       app,
 		client:    client,
-		crypto:    crypto.(*EciesCrypto),
+		crypto:    ecies,
 		eventSync: &DgEventSync{
 			client: client,
 			url:    eventUrl,
@@ -74,33 +95,37 @@ func NewCertRotationHandler(app *App, stateFile, estServer string) *CertRotation
 		},
 		steps: []CertRotationStep{
 			&estStep{},
+			&verifyStep{},
 			&lockStep{},
 			&fullCfgStep{},
 			&deviceCfgStep{},
 			&finalizeStep{},
 		},
-	}
+	}, nil
 }
 
 // RestoreCertRotationHandler will attempt to load a previous rotation attempt's
 // state and return a handler that can process it. This function returns nil when
 // `stateFile` does not exist
-func RestoreCertRotationHandler(app *App, stateFile string) *CertRotationHandler {
+func RestoreCertRotationHandler(app *App, stateFile string) (*CertRotationHandler, error) {
 	bytes, err := os.ReadFile(stateFile)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil
+			return nil, nil
 		} else {
 			// Looks like we started a rotation, we should try and finish it
-			log.Printf("Error reading %s, return empty rotation state: %s", stateFile, err)
+			logger.Printf("Error reading %s, return empty rotation state: %s", stateFile, err)
 		}
 	}
-	handler := NewCertRotationHandler(app, stateFile, "")
+	handler, err := NewCertRotationHandler(app, stateFile, "")
+	if err != nil {
+		return nil, err
+	}
 	if err = json.Unmarshal(bytes, &handler.State); err != nil {
-		log.Printf("Error unmarshalling rotation state, return empty rotation state %s", err)
-		return handler
+		logger.Printf("Error unmarshalling rotation state, return empty rotation state %s", err)
+		return handler, nil
 	}
-	return handler
+	return handler, nil
 }
 
 func (h *CertRotationHandler) Save() error {
@@ -114,7 +139,7 @@ func (h *CertRotationHandler) Save() error {
 func (h *CertRotationHandler) Rotate() error {
 	if len(h.State.RotationId) == 0 {
 		h.State.RotationId = fmt.Sprintf("certs-%d", time.Now().Unix())
-		log.Printf("Setting default rotation id to: %s", h.State.RotationId)
+		logger.Printf("Setting default rotation id to: %s", h.State.RotationId)
 	}
 	h.eventSync.SetCorrelationId(h.State.RotationId)
 	var err error
@@ -128,9 +153,9 @@ func (h *CertRotationHandler) Rotate() error {
 	}
 	for idx, step := range h.steps {
 		if idx < h.State.StepIdx {
-			log.Printf("Step already completed: %s", step.Name())
+			logger.Printf("Step already completed: %s", step.Name())
 		} else {
-			log.Printf("Executing step: %s", step.Name())
+			LogEvent(EventRotationStep, "Executing step: %s", step.Name())
 			if err = step.Execute(h); err != nil {
 				h.eventSync.NotifyStep(step.Name(), err)
 				return err
@@ -143,12 +168,16 @@ func (h *CertRotationHandler) Rotate() error {
 		}
 	}
 	err = os.Rename(h.stateFile, h.stateFile+".completed")
+	if err == nil {
+		h.app.publish(ChangeEvent{Type: ChangeCertRenewed})
+	}
 
 	// restart aklite and fioconfig *after* being "complete". Otherwise,
 	// we could wind up in a loop of: try-to-complete-rotation,
 	// restart-ourself-before marking complete
-	h.RestartServices()
-
+	if restartErr := h.RestartServices(); restartErr != nil && err == nil {
+		err = restartErr
+	}
 	return err
 }
 
@@ -163,7 +192,7 @@ func (h *CertRotationHandler) ResumeRotation(online bool) error {
 		// update sota.toml but didn't update config.encrypted. In this case
 		// we can complete that one step locally and be good.
 		if h.State.DeviceConfigUpdated && !h.State.Finalized {
-			log.Print("Incomplete certificate rotation state found. Will attempt to complete")
+			LogEvent(EventRotationResumed, "Incomplete certificate rotation state found. Will attempt to complete")
 			step := finalizeStep{}
 			if err := step.Execute(h); err != nil {
 				return err
@@ -173,10 +202,10 @@ func (h *CertRotationHandler) ResumeRotation(online bool) error {
 			// event to the device gateway
 			return h.Save()
 		}
-		log.Print("Incomplete certificate rotation state found.")
+		logger.Print("Incomplete certificate rotation state found.")
 		return nil
 	}
-	log.Print("Incomplete certificate rotation state found. Will attempt to complete")
+	LogEvent(EventRotationResumed, "Incomplete certificate rotation state found. Will attempt to complete")
 	return h.Rotate()
 }
 
@@ -185,30 +214,32 @@ func (h *CertRotationHandler) usePkcs11() bool {
 	return h.crypto.ctx != nil
 }
 
-func (h *CertRotationHandler) RestartServices() {
+func (h *CertRotationHandler) RestartServices() error {
 	if h.cienv {
 		fmt.Println("Skipping systemctl restarts for CI")
-		return
+		return nil
 	}
 
 	ctx := context.Background()
 	con, err := dbus.NewSystemConnectionContext(ctx)
 	if err != nil {
-		log.Fatalf("Unable to connect to DBUS for service restarts: %s", err)
+		return fmt.Errorf("Unable to connect to DBUS for service restarts: %w", err)
 	}
+	defer con.Close()
 
 	for _, svc := range []string{"aktualizr-lite.service", "fioconfig.service"} {
 		restartChan := make(chan string)
 		_, err = con.RestartUnitContext(ctx, svc, "replace", restartChan)
 		if err != nil {
-			log.Fatalf("Unable to restart: %s, %s", svc, err)
+			return fmt.Errorf("Unable to restart: %s, %w", svc, err)
 		}
 		result := <-restartChan
 		switch result {
 		case "done":
 			continue
 		default:
-			log.Fatalf("Error restarting %s: %s", svc, result)
+			return fmt.Errorf("Error restarting %s: %s", svc, result)
 		}
 	}
+	return nil
 }
@@ -1,13 +1,10 @@
-package internal
+package fioconfig
 
 import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
-	"log"
-
-	"github.com/ThalesIgnite/crypto11"
 )
 
 type fullCfgStep struct{}
@@ -24,7 +21,7 @@ func (s fullCfgStep) Execute(handler *CertRotationHandler) error {
 	defer crypto.Close()
 
 	// Open/decrypt full config with current key
-	config, err := UnmarshallFile(handler.crypto, handler.app.EncryptedConfig, true)
+	config, err := handler.app.unmarshallCache(handler.crypto, handler.app.EncryptedConfig, true)
 	if err != nil {
 		return fmt.Errorf("Unable open current encrypted config: %w", err)
 	}
@@ -51,12 +48,10 @@ func (s deviceCfgStep) Execute(handler *CertRotationHandler) error {
 		return err
 	}
 	defer crypto.Close()
-	pub := crypto.PrivKey.Public()
-	pubDer, err := x509.MarshalPKIXPublicKey(pub.ExportECDSA())
+	pubPem, err := crypto.PublicKeyPem()
 	if err != nil {
 		return err
 	}
-	pubPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer})
 
 	// Download/decrypt current device config with current key
 	url := handler.app.configUrl + "-device"
@@ -73,7 +68,7 @@ func (s deviceCfgStep) Execute(handler *CertRotationHandler) error {
 	}
 	config, err := UnmarshallBuffer(handler.crypto, res.Body, true)
 	if err != nil {
-		log.Printf("Unable to decrypt device config with old key, trying new key: %s", err)
+		logger.Printf("Unable to decrypt device config with old key, trying new key: %s", err)
 		// There's a chance that we'd uploaded this config with the new key and
 		// had a power failure before we saved the state to disk. Check if
 		// we can decrypt with that key before giving up.
@@ -97,12 +92,7 @@ func (s deviceCfgStep) Execute(handler *CertRotationHandler) error {
 		PubKey: string(pubPem),
 	}
 	for name, entry := range config {
-		ccr.Files = append(ccr.Files, ConfigFileReq{
-			Name:        name,
-			Value:       entry.Value,
-			Unencrypted: entry.Unencrypted,
-			OnChanged:   entry.OnChanged,
-		})
+		ccr.Files = append(ccr.Files, entry.request(name))
 	}
 	res, err = httpPatch(handler.client, handler.app.configUrl, ccr)
 	if err != nil {
@@ -142,24 +132,14 @@ func getCryptoHandler(h *CertRotationHandler) (*EciesCrypto, error) {
 		return NewEciesLocalHandler(key).(*EciesCrypto), nil
 	}
 
-	module := tomlGet(h.app.sota, "p11.module")
-	pin := tomlGet(h.app.sota, "p11.pass")
-
-	cfg := crypto11.Config{
-		Path:        module,
-		TokenLabel:  h.app.sota.GetDefault("p11.label", "aktualizr").(string),
-		Pin:         pin,
-		MaxSessions: 2,
-	}
-
-	ctx, err := crypto11.Configure(&cfg)
+	cfg, login, err := pkcs11Config(h.app.sota)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to configure crypto11 library: %w", err)
+		return nil, err
 	}
 
-	privKey, err := ctx.FindKeyPair(idToBytes(h.State.NewKey), nil)
+	key, err := newPkcs11KeyPair(sharedPkcs11(&cfg, login), idToBytes(h.State.NewKey))
 	if err != nil {
 		return nil, fmt.Errorf("Unable to find new HSM private key: %w", err)
 	}
-	return NewEciesPkcs11Handler(ctx, privKey).(*EciesCrypto), nil
+	return newSharedPkcs11Handler(key).(*EciesCrypto), nil
 }
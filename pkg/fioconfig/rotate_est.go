@@ -1,4 +1,4 @@
-package internal
+package fioconfig
 
 import (
 	"bytes"
@@ -14,7 +14,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 
 	"github.com/ThalesIgnite/crypto11"
@@ -80,74 +79,85 @@ func (s estStep) Execute(handler *CertRotationHandler) error {
 	}
 
 	// Ask EST server for new cert
-	csrBytes, err := createB64CsrDer(signer, cert)
+	estCert, err := estReenroll(handler.client, handler.State.EstServer, signer, cert)
 	if err != nil {
 		return err
 	}
 
-	url := handler.State.EstServer + "/simplereenroll"
-	res, err := handler.client.Post(url, "application/pkcs10", bytes.NewBuffer(csrBytes))
+	// Update our state
+	if handler.usePkcs11() {
+		newCert := s.nextCertId(handler)
+		if err = handler.crypto.ctx.DeleteCertificate(idToBytes(newCert), nil, nil); err != nil {
+			return fmt.Errorf("Unable to free up slot(%s) for new cert: %w", newCert, err)
+		}
+		if err = handler.crypto.ctx.ImportCertificateWithLabel(idToBytes(newCert), []byte("client"), estCert); err != nil {
+			return fmt.Errorf("Unable to import new cert into HSM: %w", err)
+		}
+		handler.State.NewCert = newCert
+	} else {
+		handler.State.NewCert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: estCert.Raw}))
+	}
+
+	handler.State.NewKey = newKey
+	return nil
+}
+
+// estReenroll performs an EST simplereenroll of the current certificate for
+// the given key and sanity checks the certificate the server returns.
+func estReenroll(client *http.Client, estServer string, signer crypto.Signer, cert *x509.Certificate) (*x509.Certificate, error) {
+	csrBytes, err := createB64CsrDer(signer, cert)
 	if err != nil {
-		return fmt.Errorf("Unable to submit certificate signing request: %w", err)
+		return nil, err
 	}
+
+	url := estServer + "/simplereenroll"
+	res, err := client.Post(url, "application/pkcs10", bytes.NewBuffer(csrBytes))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to submit certificate signing request: %w", err)
+	}
+	defer res.Body.Close()
 	buf, err := io.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("Unable to read certificate response body: HTTP_%d - %w", res.StatusCode, err)
+		return nil, fmt.Errorf("Unable to read certificate response body: HTTP_%d - %w", res.StatusCode, err)
 	}
 	if res.StatusCode != 201 {
-		return fmt.Errorf("Unable to obtain new certificate: HTTP_%d - %s", res.StatusCode, string(buf))
+		return nil, fmt.Errorf("Unable to obtain new certificate: HTTP_%d - %s", res.StatusCode, string(buf))
 	}
 	ct := res.Header.Get("content-type")
 	if ct != "application/pkcs7-mime" {
-		return fmt.Errorf("Unexpected content-type return in certificate response: %s", ct)
+		return nil, fmt.Errorf("Unexpected content-type return in certificate response: %s", ct)
 	}
 	estCert, err := decodeEstResponse(string(buf))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Do minimal sanity checking on the new cert
 	if err = verifyNewCert(cert, estCert); err != nil {
-		return err
-	}
-
-	// Update our state
-	if handler.usePkcs11() {
-		newCert := s.nextCertId(handler)
-		if err = handler.crypto.ctx.DeleteCertificate(idToBytes(newCert), nil, nil); err != nil {
-			return fmt.Errorf("Unable to free up slot(%s) for new cert: %w", newCert, err)
-		}
-		if err = handler.crypto.ctx.ImportCertificateWithLabel(idToBytes(newCert), []byte("client"), estCert); err != nil {
-			return fmt.Errorf("Unable to import new cert into HSM: %w", err)
-		}
-		handler.State.NewCert = newCert
-	} else {
-		handler.State.NewCert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: estCert.Raw}))
+		return nil, err
 	}
-
-	handler.State.NewKey = newKey
-	return nil
+	return estCert, nil
 }
 
 func (s estStep) nextPkeyId(handler *CertRotationHandler) string {
-	cur := tomlGet(handler.app.sota, "p11.tls_pkey_id")
+	cur, _ := tomlGet(handler.app.sota, "p11.tls_pkey_id")
 	for _, val := range handler.State.PkeySlotIds {
 		if val != cur {
 			return val
 		}
 	}
-	log.Printf("ERROR: Unable to find a new key id. Will use slot 07")
+	logger.Printf("ERROR: Unable to find a new key id. Will use slot 07")
 	return "07"
 }
 
 func (s estStep) nextCertId(handler *CertRotationHandler) string {
-	cur := tomlGet(handler.app.sota, "p11.tls_clientcert_id")
+	cur, _ := tomlGet(handler.app.sota, "p11.tls_clientcert_id")
 	for _, val := range handler.State.CertSlotIds {
 		if val != cur {
 			return val
 		}
 	}
-	log.Printf("ERROR: Unable to find a new clientcert id. Will use slot 09")
+	logger.Printf("ERROR: Unable to find a new clientcert id. Will use slot 09")
 	return "09"
 }
 
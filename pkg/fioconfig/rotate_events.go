@@ -1,7 +1,6 @@
-package internal
+package fioconfig
 
 import (
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -68,9 +67,9 @@ func (s *DgEventSync) notify(event string, err error) {
 	}
 	res, err := httpPost(s.client, s.url, evt)
 	if err != nil {
-		log.Printf("Unable to send event: %s", err)
+		logger.Printf("Unable to send event: %s", err)
 	} else if res.StatusCode < 200 || res.StatusCode > 204 {
-		log.Printf("Server could not process event(%s): HTTP_%d - %s", event, res.StatusCode, res.String())
+		logger.Printf("Server could not process event(%s): HTTP_%d - %s", event, res.StatusCode, res.String())
 	}
 }
 
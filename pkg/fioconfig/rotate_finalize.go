@@ -1,4 +1,4 @@
-package internal
+package fioconfig
 
 import (
 	"fmt"
@@ -13,7 +13,10 @@ func (s finalizeStep) Name() string {
 }
 
 func (s finalizeStep) Execute(handler *CertRotationHandler) error {
-	storagePath := tomlGet(handler.app.sota, "storage.path")
+	storagePath, err := tomlGet(handler.app.sota, "storage.path")
+	if err != nil {
+		return err
+	}
 	if handler.usePkcs11() {
 		// Point at the new key ids
 		handler.app.sota.Set("p11.tls_pkey_id", handler.State.NewKey)
@@ -51,7 +54,7 @@ func (s finalizeStep) Execute(handler *CertRotationHandler) error {
 	}
 
 	path = filepath.Join(storagePath, "config.encrypted")
-	if err := safeWrite(path, []byte(handler.State.FullConfigEncrypted)); err != nil {
+	if err := handler.app.writeCache(path, []byte(handler.State.FullConfigEncrypted)); err != nil {
 		return fmt.Errorf("Error updating config.encrypted: %w", err)
 	}
 	handler.State.Finalized = true
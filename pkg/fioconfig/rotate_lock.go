@@ -1,8 +1,6 @@
-package internal
+package fioconfig
 
 import (
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 )
 
@@ -21,17 +19,17 @@ func (s lockStep) Execute(handler *CertRotationHandler) error {
 	if err != nil {
 		return err
 	}
-	pub := crypto.PrivKey.Public()
+	pubBytes, err := crypto.PublicKeyPem()
 	crypto.Close()
-
-	pubBytes, err := x509.MarshalPKIXPublicKey(pub.ExportECDSA())
 	if err != nil {
 		return err
 	}
 
-	pubBytes = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
-
-	url := tomlGet(handler.app.sota, "tls.server") + "/device"
+	server, err := tomlGet(handler.app.sota, "tls.server")
+	if err != nil {
+		return err
+	}
+	url := server + "/device"
 	if res, err := httpPatch(handler.client, url, DeviceUpdate{string(pubBytes)}); err != nil {
 		return err
 	} else if res.StatusCode != 200 {
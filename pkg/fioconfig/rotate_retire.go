@@ -0,0 +1,156 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+type verifyStep struct{}
+
+func (s verifyStep) Name() string {
+	return "Verify new certificate with server"
+}
+
+// Execute makes sure the server accepts the new cert before anything is
+// committed to, and remembers the current key and cert so they can be
+// retired once the rotation has been confirmed.
+func (s verifyStep) Execute(handler *CertRotationHandler) error {
+	if len(handler.State.OldKey) == 0 {
+		keyName, certName := "import.tls_pkey_path", "import.tls_clientcert_path"
+		if handler.usePkcs11() {
+			keyName, certName = "p11.tls_pkey_id", "p11.tls_clientcert_id"
+		}
+		oldKey, err := tomlGet(handler.app.sota, keyName)
+		if err != nil {
+			return err
+		}
+		oldCert, err := tomlGet(handler.app.sota, certName)
+		if err != nil {
+			return err
+		}
+		handler.State.OldKey, handler.State.OldCert = oldKey, oldCert
+	}
+
+	cert, closer, err := newTlsCertificate(handler)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	transport := handler.client.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	client := *handler.client
+	client.Transport = transport
+	defer transport.CloseIdleConnections()
+
+	res, err := httpDoOnce(context.Background(), &client, http.MethodGet, handler.app.configUrl, nil, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to connect with new certificate: %w", err)
+	}
+	switch res.StatusCode {
+	case 200, 204, 226, 304:
+		return nil
+	}
+	return fmt.Errorf("Server rejected new certificate: HTTP_%d - %s", res.StatusCode, res.String())
+}
+
+// newTlsCertificate returns the TLS client certificate for the new key. The
+// returned function must be called once it's no longer needed.
+func newTlsCertificate(handler *CertRotationHandler) (tls.Certificate, func(), error) {
+	if !handler.usePkcs11() {
+		cert, err := tls.X509KeyPair([]byte(handler.State.NewCert), []byte(handler.State.NewKey))
+		return cert, func() {}, err
+	}
+	var tlsCert tls.Certificate
+	crypto, err := getCryptoHandler(handler)
+	if err != nil {
+		return tlsCert, nil, err
+	}
+	cert, err := crypto.ctx.FindCertificate(idToBytes(handler.State.NewCert), nil, nil)
+	if err != nil || cert == nil {
+		crypto.Close()
+		return tlsCert, nil, fmt.Errorf("Unable to find new certificate in HSM: %v", err)
+	}
+	tlsCert.Certificate = [][]byte{cert.Raw}
+	tlsCert.PrivateKey = crypto.PrivKey.(*PrivateKeyPkcs11).signer
+	return tlsCert, crypto.Close, nil
+}
+
+// retireOldKey removes the key and cert replaced by a completed rotation.
+// This is only done after a check-in has succeeded with the new ones, so an
+// unexpected problem with them can't leave the device unable to connect.
+func (a *App) retireOldKey() {
+	stateFile := filepath.Join(a.sotaConfig, "cert-rotation.state.completed")
+	buf, err := os.ReadFile(stateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to read completed rotation state: %s", err)
+		}
+		return
+	}
+	var state CertRotationState
+	if err := json.Unmarshal(buf, &state); err != nil {
+		logger.Printf("Unable to parse completed rotation state: %s", err)
+		return
+	}
+	if state.Retired || len(state.OldKey) == 0 {
+		return
+	}
+
+	if source, _ := tomlGet(a.sota, "tls.pkey_source"); source == "pkcs11" {
+		err = a.retirePkcs11(state.OldKey, state.OldCert)
+	} else {
+		err = retireFiles(a.sota.GetDefault("import.tls_pkey_path", "").(string), state.OldKey,
+			a.sota.GetDefault("import.tls_clientcert_path", "").(string), state.OldCert)
+	}
+	if err != nil {
+		LogEvent(EventOldKeyRetireFailed, "Unable to retire old device key: %s", err)
+		return
+	}
+	LogEvent(EventOldKeyRetired, "Retired old device key %s", state.OldKey)
+	state.Retired = true
+	if buf, err = json.Marshal(state); err == nil {
+		err = safeWrite(stateFile, buf)
+	}
+	if err != nil {
+		logger.Printf("Unable to save completed rotation state: %s", err)
+	}
+}
+
+func retireFiles(curKey, oldKey, curCert, oldCert string) error {
+	if curKey == oldKey || curCert == oldCert {
+		return errors.New("Old key is still in use")
+	}
+	for _, path := range []string{oldKey, oldCert} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *App) retirePkcs11(oldKey, oldCert string) error {
+	curKey, _ := tomlGet(a.sota, "p11.tls_pkey_id")
+	curCert, _ := tomlGet(a.sota, "p11.tls_clientcert_id")
+	if curKey == oldKey || curCert == oldCert {
+		return errors.New("Old key is still in use")
+	}
+	_, crypto, err := a.getClient()
+	if err != nil {
+		return err
+	}
+	ec, ok := crypto.(*EciesCrypto)
+	if !ok || ec.ctx == nil {
+		return errors.New("Unable to access PKCS#11 context")
+	}
+	if err := ec.ctx.DeleteKeyPair(idToBytes(oldKey), []byte("tls")); err != nil {
+		return err
+	}
+	return ec.ctx.DeleteCertificate(idToBytes(oldCert), nil, nil)
+}
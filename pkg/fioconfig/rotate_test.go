@@ -1,4 +1,4 @@
-package internal
+package fioconfig
 
 import (
 	"crypto/ecdsa"
@@ -76,7 +76,8 @@ func WithEstServer(t *testing.T, testFunc func(tc testClient)) {
 func TestRotationHandler(t *testing.T) {
 	testWrapper(t, nil, func(app *App, client *http.Client, tmpdir string) {
 		stateFile := filepath.Join(tmpdir, "rotate.state")
-		handler := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		handler, err := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		require.Nil(t, err)
 		handler.eventSync = NoOpEventSync{}
 		handler.cienv = true
 
@@ -86,7 +87,7 @@ func TestRotationHandler(t *testing.T) {
 
 		require.Nil(t, handler.Rotate())
 
-		_, err := os.Stat(stateFile + ".completed")
+		_, err = os.Stat(stateFile + ".completed")
 		require.Nil(t, err)
 
 		// Do one that fails, it should leave a statefile so we know where
@@ -96,7 +97,8 @@ func TestRotationHandler(t *testing.T) {
 			&testStep{"step1", errors.New("1")},
 		}
 		require.NotNil(t, handler.Rotate())
-		handler = RestoreCertRotationHandler(app, stateFile)
+		handler, err = RestoreCertRotationHandler(app, stateFile)
+		require.Nil(t, err)
 		handler.cienv = true
 		require.NotNil(t, handler)
 		require.Equal(t, "est-server-doesn't-matter", handler.State.EstServer)
@@ -116,7 +118,8 @@ func TestEst(t *testing.T) {
 	WithEstServer(t, func(tc testClient) {
 		testWrapper(t, nil, func(app *App, client *http.Client, tmpdir string) {
 			stateFile := filepath.Join(tmpdir, "rotate.state")
-			handler := NewCertRotationHandler(app, stateFile, tc.srv.URL+"/.well-known/est")
+			handler, err := NewCertRotationHandler(app, stateFile, tc.srv.URL+"/.well-known/est")
+			require.Nil(t, err)
 
 			step := estStep{}
 
@@ -136,7 +139,8 @@ func TestRotateFullConfig(t *testing.T) {
 
 	testWrapper(t, nil, func(app *App, client *http.Client, tmpdir string) {
 		stateFile := filepath.Join(tmpdir, "rotate.state")
-		handler := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		handler, err := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		require.Nil(t, err)
 		handler.State.NewKey = string(keyBytes)
 
 		step := fullCfgStep{}
@@ -186,7 +190,8 @@ func TestRotateLock(t *testing.T) {
 	testWrapper(t, dgHandler, func(app *App, client *http.Client, tmpdir string) {
 		app.configUrl += "/"
 		stateFile := filepath.Join(tmpdir, "rotate.state")
-		handler := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		handler, err := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		require.Nil(t, err)
 		handler.State.NewKey = string(keyBytes)
 
 		step := lockStep{}
@@ -241,7 +246,8 @@ func TestRotateDeviceConfig(t *testing.T) {
 		encbuf, err = os.ReadFile(app.EncryptedConfig)
 		require.Nil(t, err)
 		stateFile := filepath.Join(tmpdir, "rotate.state")
-		handler := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		handler, err := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		require.Nil(t, err)
 		handler.State.NewKey = string(keyBytes)
 
 		step := deviceCfgStep{}
@@ -278,7 +284,8 @@ func TestRotateFinalize(t *testing.T) {
 		require.Nil(t, err)
 		require.Nil(t, os.WriteFile(filepath.Join(tmpdir, "sota.toml"), bytes, 0o740))
 		stateFile := filepath.Join(tmpdir, "rotate.state")
-		handler := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		handler, err := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		require.Nil(t, err)
 		handler.State.NewKey = "newkey"
 		handler.State.NewCert = "newcert"
 
@@ -287,11 +294,15 @@ func TestRotateFinalize(t *testing.T) {
 
 		sota, err := toml.LoadFile(filepath.Join(tmpdir, "sota.toml"))
 		require.Nil(t, err)
-		bytes, err = os.ReadFile(tomlGet(sota, "import.tls_pkey_path"))
+		keyFile, err := tomlGet(sota, "import.tls_pkey_path")
+		require.Nil(t, err)
+		bytes, err = os.ReadFile(keyFile)
 		require.Nil(t, err)
 		require.Equal(t, "newkey", string(bytes))
 
-		bytes, err = os.ReadFile(tomlGet(sota, "import.tls_clientcert_path"))
+		certFile, err := tomlGet(sota, "import.tls_clientcert_path")
+		require.Nil(t, err)
+		bytes, err = os.ReadFile(certFile)
 		require.Nil(t, err)
 		require.Equal(t, "newcert", string(bytes))
 
@@ -299,3 +310,57 @@ func TestRotateFinalize(t *testing.T) {
 		require.Nil(t, err)
 	})
 }
+
+func TestRotateVerify(t *testing.T) {
+	status := 200
+	dgHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+
+	testWrapper(t, dgHandler, func(app *App, client *http.Client, tmpdir string) {
+		stateFile := filepath.Join(tmpdir, "rotate.state")
+		handler, err := NewCertRotationHandler(app, stateFile, "est-server-doesn't-matter")
+		require.Nil(t, err)
+		handler.State.NewKey = pkey_pem
+		handler.State.NewCert = client_pem
+
+		step := verifyStep{}
+		require.Nil(t, step.Execute(handler))
+		require.Equal(t, filepath.Join(tmpdir, "pkey.pem"), handler.State.OldKey)
+		require.Equal(t, filepath.Join(tmpdir, "client.pem"), handler.State.OldCert)
+
+		status = 403
+		require.NotNil(t, step.Execute(handler))
+	})
+}
+
+func TestRetireOldKey(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tmpdir string) {
+		oldKey := filepath.Join(tmpdir, "old-pkey.pem")
+		oldCert := filepath.Join(tmpdir, "old-client.pem")
+		require.Nil(t, os.WriteFile(oldKey, []byte("old"), 0o600))
+		require.Nil(t, os.WriteFile(oldCert, []byte("old"), 0o600))
+
+		stateFile := filepath.Join(tmpdir, "cert-rotation.state.completed")
+		state := CertRotationState{OldKey: oldKey, OldCert: oldCert}
+		buf, err := json.Marshal(state)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(stateFile, buf, 0o600))
+
+		app.retireOldKey()
+		assertNoFile(t, oldKey)
+		assertNoFile(t, oldCert)
+		buf, err = os.ReadFile(stateFile)
+		require.Nil(t, err)
+		require.Nil(t, json.Unmarshal(buf, &state))
+		require.True(t, state.Retired)
+
+		// Never remove the key that's in use
+		state = CertRotationState{OldKey: filepath.Join(tmpdir, "pkey.pem"), OldCert: filepath.Join(tmpdir, "client.pem")}
+		buf, err = json.Marshal(state)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(stateFile, buf, 0o600))
+		app.retireOldKey()
+		assertFile(t, filepath.Join(tmpdir, "pkey.pem"), nil)
+	})
+}
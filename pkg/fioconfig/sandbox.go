@@ -0,0 +1,92 @@
+package fioconfig
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Values of handler_sandbox
+const (
+	SandboxNone    = ""
+	SandboxSystemd = "systemd"
+	SandboxHelper  = "helper"
+)
+
+// Restrictions applied to sandboxed handlers before handler_sandbox_properties.
+// Handlers can only write to the secrets directory and storage.path, and
+// have no network access.
+var systemdSandboxProperties = []string{
+	"PrivateNetwork=yes",
+	"PrivateTmp=yes",
+	"PrivateDevices=yes",
+	"ProtectSystem=strict",
+	"ProtectHome=yes",
+	"ProtectKernelTunables=yes",
+	"ProtectKernelModules=yes",
+	"ProtectControlGroups=yes",
+	"NoNewPrivileges=yes",
+	"RestrictSUIDSGID=yes",
+	"LockPersonality=yes",
+	"SystemCallFilter=@system-service",
+	"SystemCallArchitectures=native",
+}
+
+// handlerCommand prepares a file's on-changed command to run with the
+// given environment, in the sandbox configured by handler_sandbox.
+func (a *App) handlerCommand(cfgFile *ConfigFile, env []string, timeout time.Duration) (*exec.Cmd, error) {
+	onChanged := cfgFile.OnChanged
+	switch a.settings.HandlerSandbox {
+	case SandboxNone:
+		cmd := exec.Command(onChanged[0], onChanged[1:]...)
+		cmd.Env = env
+		if len(cfgFile.RunAs) > 0 {
+			cred, err := handlerCredential(cfgFile.RunAs)
+			if err != nil {
+				return nil, err
+			}
+			cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+		}
+		return cmd, nil
+	case SandboxSystemd:
+		args := a.systemdRunArgs(cfgFile, env, timeout)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = a.handlerEnv()
+		return cmd, nil
+	}
+	return nil, fmt.Errorf("Unknown handler_sandbox: %s", a.settings.HandlerSandbox)
+}
+
+// systemdRunArgs wraps a command in a transient systemd service. Its
+// environment has to be passed explicitly since the service is started by
+// systemd rather than by fioconfig.
+func (a *App) systemdRunArgs(cfgFile *ConfigFile, env []string, timeout time.Duration) []string {
+	args := []string{"systemd-run", "--wait", "--pipe", "--collect", "--quiet", "--service-type=exec"}
+	writable := []string{a.SecretsDir}
+	if storage, ok := a.sota.Get("storage.path").(string); ok && len(storage) > 0 {
+		writable = append(writable, storage)
+	}
+	props := append([]string{}, systemdSandboxProperties...)
+	props = append(props, "ReadWritePaths="+strings.Join(writable, " "))
+	if timeout > 0 {
+		props = append(props, fmt.Sprintf("RuntimeMaxSec=%d", int(timeout.Seconds()+0.5)))
+	}
+	props = append(props, a.settings.HandlerSandboxProperties...)
+	for _, prop := range props {
+		args = append(args, "--property="+prop)
+	}
+	if len(cfgFile.RunAs) > 0 {
+		parts := strings.SplitN(cfgFile.RunAs, ":", 2)
+		args = append(args, "--uid="+parts[0])
+		if len(parts) == 2 {
+			args = append(args, "--gid="+parts[1])
+		}
+	}
+	for _, kv := range env {
+		args = append(args, "--setenv="+kv)
+	}
+	args = append(args, "--")
+	return append(args, cfgFile.OnChanged...)
+}
@@ -0,0 +1,36 @@
+package fioconfig
+
+import (
+	"testing"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemdSandbox(t *testing.T) {
+	sota, err := toml.Load("[storage]\npath = \"/var/sota\"")
+	require.Nil(t, err)
+	app := &App{SecretsDir: "/run/secrets", sota: sota}
+	app.settings.HandlerSandbox = SandboxSystemd
+	app.settings.HandlerSandboxProperties = []string{"PrivateNetwork=no"}
+
+	cfgFile := &ConfigFile{OnChanged: []string{"/usr/bin/restart", "svc"}, RunAs: "svc:svc"}
+	cmd, err := app.handlerCommand(cfgFile, []string{"CONFIG_FILE=/run/secrets/foo"}, 30*time.Second)
+	require.Nil(t, err)
+	args := cmd.Args
+	require.Equal(t, "systemd-run", args[0])
+	require.Equal(t, []string{"--", "/usr/bin/restart", "svc"}, args[len(args)-3:])
+	require.Contains(t, args, "--property=PrivateNetwork=yes")
+	require.Contains(t, args, "--property=PrivateNetwork=no")
+	require.Contains(t, args, "--property=ReadWritePaths=/run/secrets /var/sota")
+	require.Contains(t, args, "--property=RuntimeMaxSec=30")
+	require.Contains(t, args, "--uid=svc")
+	require.Contains(t, args, "--gid=svc")
+	require.Contains(t, args, "--setenv=CONFIG_FILE=/run/secrets/foo")
+	require.NotContains(t, cmd.Env, "CONFIG_FILE=/run/secrets/foo")
+
+	app.settings.HandlerSandbox = "chroot"
+	_, err = app.handlerCommand(cfgFile, nil, 0)
+	require.NotNil(t, err)
+}
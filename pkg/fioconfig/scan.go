@@ -0,0 +1,89 @@
+package fioconfig
+
+import (
+	"math"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var privateKeyPem = regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`)
+
+// Tokens are split on anything that can't appear in a base64, base64url or
+// hex encoded credential.
+var credentialToken = regexp.MustCompile(`[A-Za-z0-9+/_\-]+={0,2}`)
+
+// shannonEntropy returns the bits of entropy per character of s
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	var entropy float64
+	n := float64(len(s))
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func isHex(s string) bool {
+	return strings.Trim(s, "0123456789abcdefABCDEF") == ""
+}
+
+// looksLikeCredential reports whether value contains a token random enough
+// to be a password, API key, or similar.
+func looksLikeCredential(value string) bool {
+	for _, token := range credentialToken.FindAllString(value, -1) {
+		if isHex(token) {
+			if len(token) >= 32 && shannonEntropy(token) >= 3.5 {
+				return true
+			}
+		} else if len(token) >= 24 && shannonEntropy(token) >= 4.2 {
+			return true
+		}
+	}
+	return false
+}
+
+// scanSecret returns the reasons a config entry looks like a secret that
+// wasn't handled as one: it was sent unencrypted or will be written with a
+// world-readable mode. Only plaintext is examined, so this is a safety net
+// against authoring mistakes rather than a guarantee.
+func scanSecret(cfgFile *ConfigFile, mode os.FileMode) []string {
+	exposed := cfgFile.Unencrypted || mode&0o004 != 0
+	if !exposed {
+		return nil
+	}
+	content, err := cfgFile.content()
+	if err != nil {
+		return nil
+	}
+	defer zeroize(content)
+	var findings []string
+	if privateKeyPem.Match(content) {
+		findings = append(findings, "contains a private key")
+	} else if looksLikeCredential(string(content)) {
+		findings = append(findings, "contains a high-entropy credential")
+	}
+	if len(findings) > 0 {
+		if cfgFile.Unencrypted {
+			findings = append(findings, "was not encrypted by the server")
+		}
+		if mode&0o004 != 0 {
+			findings = append(findings, "will be world-readable")
+		}
+	}
+	return findings
+}
+
+func (a *App) warnIfSecret(fname string, cfgFile *ConfigFile) {
+	mode := secretFileMode
+	if meta, err := cfgFile.fileMeta(); err == nil && meta.mode != 0 {
+		mode = meta.mode
+	}
+	if findings := scanSecret(cfgFile, mode); len(findings) > 0 {
+		LogEvent(EventLooksLikeSecret, "WARNING: %s looks like a secret: %s", fname, strings.Join(findings, ", "))
+	}
+}
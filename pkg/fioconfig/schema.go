@@ -0,0 +1,392 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	toml "github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// A file with a Schema has its decrypted value checked against that JSON
+// Schema before anything is written, so a typo in a service's config is
+// rejected and reported instead of crashing the service. Schema is the name
+// of another file of the config, so one schema can cover several files, or
+// an absolute path to one shipped with the OS image. Values are parsed as
+// YAML or TOML when their name ends in .yaml, .yml or .toml, and as JSON
+// otherwise.
+//
+// Only the common keywords are supported: type, enum, const, the numeric
+// and length limits, pattern, items, properties, required,
+// additionalProperties, allOf, anyOf, oneOf and not. Others are ignored
+// like the spec says unknown keywords are, except $ref, which is rejected
+// rather than silently not checked.
+
+// checkSchemas validates each file with a Schema. With partial_extract the
+// files that don't match are left out, otherwise the config is rejected.
+func (a *App) checkSchemas(config ConfigStruct, report *ExtractReport) error {
+	schemas := make(map[string]interface{})
+	for _, fname := range sortedNames(config) {
+		name := config[fname].Schema
+		if len(name) == 0 {
+			continue
+		}
+		schema, ok := schemas[name]
+		var err error
+		if !ok {
+			if schema, err = loadSchema(config, name); err == nil {
+				schemas[name] = schema
+			}
+		}
+		if err == nil {
+			err = validateWithSchema(fname, config[fname], schema)
+		}
+		if err == nil {
+			continue
+		}
+		rejected := &ConfigRejectedError{"schema " + name, fmt.Sprintf("%s: %s", fname, err)}
+		LogEventWith(EventSchemaInvalid, LogFields{"file": fname}, "ERROR: %s", rejected)
+		report.fail(fname, rejected)
+		if !a.settings.PartialExtract {
+			report.Code = EventSchemaInvalid
+			report.Rejected = rejected.Error()
+			return rejected
+		}
+	}
+	return nil
+}
+
+// loadSchema returns the schema a file names
+func loadSchema(config ConfigStruct, name string) (interface{}, error) {
+	var buf []byte
+	var err error
+	if filepath.IsAbs(name) {
+		buf, err = os.ReadFile(name)
+	} else if cfgFile, ok := config[name]; ok {
+		buf, err = cfgFile.content()
+	} else {
+		return nil, fmt.Errorf("Schema %s isn't in the config", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read schema: %w", err)
+	}
+	var schema interface{}
+	if err := json.Unmarshal(buf, &schema); err != nil {
+		return nil, fmt.Errorf("Invalid schema: %w", err)
+	}
+	return schema, nil
+}
+
+// validateWithSchema parses a file's value and checks it against schema
+func validateWithSchema(fname string, cfgFile *ConfigFile, schema interface{}) error {
+	content, err := cfgFile.content()
+	if err != nil {
+		return err
+	}
+	defer zeroize(content)
+	value, err := parseStructured(fname, content)
+	if err != nil {
+		return err
+	}
+	return checkSchema(schema, value, "")
+}
+
+// parseStructured decodes a JSON, YAML or TOML value into what
+// encoding/json would give for the same document
+func parseStructured(fname string, content []byte) (interface{}, error) {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".yaml", ".yml":
+		var doc interface{}
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, fmt.Errorf("Invalid YAML: %w", err)
+		}
+		return normalizeStructured(doc)
+	case ".toml":
+		tree, err := toml.LoadBytes(content)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TOML: %w", err)
+		}
+		return normalizeStructured(tree.ToMap())
+	}
+	var doc interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("Invalid JSON: %w", err)
+	}
+	return doc, nil
+}
+
+func normalizeStructured(doc interface{}) (interface{}, error) {
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to check value: %w", err)
+	}
+	var normalized interface{}
+	err = json.Unmarshal(buf, &normalized)
+	return normalized, err
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func hasType(value interface{}, want string) bool {
+	got := jsonType(value)
+	return got == want || (want == "number" && got == "integer")
+}
+
+// schemaNumber returns a numeric keyword of schema
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool, error) {
+	v, ok := schema[key]
+	if !ok {
+		return 0, false, nil
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return 0, false, fmt.Errorf("Invalid schema: %s must be a number", key)
+	}
+	return n, true, nil
+}
+
+// checkSchema returns why value, at path within the document, doesn't
+// match schema
+func checkSchema(schema interface{}, value interface{}, path string) error {
+	where := path
+	if len(where) == 0 {
+		where = "/"
+	}
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			return fmt.Errorf("%s: not allowed", where)
+		}
+		return nil
+	case map[string]interface{}:
+		return checkSchemaObject(s, value, path, where)
+	}
+	return fmt.Errorf("Invalid schema at %s: must be an object or a boolean", where)
+}
+
+func checkSchemaObject(schema map[string]interface{}, value interface{}, path, where string) error {
+	if _, ok := schema["$ref"]; ok {
+		return fmt.Errorf("Invalid schema at %s: $ref is not supported", where)
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []interface{}:
+			for _, item := range t {
+				if name, ok := item.(string); ok {
+					types = append(types, name)
+				}
+			}
+		}
+		match := false
+		for _, name := range types {
+			match = match || hasType(value, name)
+		}
+		if !match {
+			return fmt.Errorf("%s: must be %s, not %s", where, strings.Join(types, " or "), jsonType(value))
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		match := false
+		for _, item := range enum {
+			match = match || reflect.DeepEqual(item, value)
+		}
+		if !match {
+			return fmt.Errorf("%s: must be one of %s", where, compactJSON(enum))
+		}
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		return fmt.Errorf("%s: must be %s", where, compactJSON(c))
+	}
+
+	if err := checkSchemaLimits(schema, value, where); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		if items, ok := schema["items"]; ok {
+			for i, item := range v {
+				if err := checkSchema(items, item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+		if unique, _ := schema["uniqueItems"].(bool); unique {
+			for i := range v {
+				for j := i + 1; j < len(v); j++ {
+					if reflect.DeepEqual(v[i], v[j]) {
+						return fmt.Errorf("%s: items %d and %d are the same", where, i, j)
+					}
+				}
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, key := range required {
+				if name, ok := key.(string); ok {
+					if _, ok := v[name]; !ok {
+						return fmt.Errorf("%s: %s is required", where, name)
+					}
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, key := range propertyNames(v) {
+			sub := path + "/" + key
+			if propSchema, ok := properties[key]; ok {
+				if err := checkSchema(propSchema, v[key], sub); err != nil {
+					return err
+				}
+			} else if additional, ok := schema["additionalProperties"]; ok {
+				if allowed, ok := additional.(bool); ok && !allowed {
+					return fmt.Errorf("%s: %s is not allowed", where, key)
+				}
+				if err := checkSchema(additional, v[key], sub); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if err := checkSchema(sub, value, path); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		var first error
+		for _, sub := range anyOf {
+			if first = checkSchema(sub, value, path); first == nil {
+				break
+			}
+		}
+		if first != nil {
+			return fmt.Errorf("%s: doesn't match any of the allowed schemas", where)
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, sub := range oneOf {
+			if checkSchema(sub, value, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: matches %d of the schemas instead of exactly one", where, matches)
+		}
+	}
+	if not, ok := schema["not"]; ok && checkSchema(not, value, path) == nil {
+		return fmt.Errorf("%s: matches a schema it must not", where)
+	}
+	return nil
+}
+
+// checkSchemaLimits applies the keywords limiting numbers, strings, arrays
+// and objects
+func checkSchemaLimits(schema map[string]interface{}, value interface{}, where string) error {
+	type limit struct {
+		key   string
+		fails func(n, limit float64) bool
+		msg   string
+	}
+	var size float64
+	var limits []limit
+	switch v := value.(type) {
+	case float64:
+		size = v
+		limits = []limit{
+			{"minimum", func(n, l float64) bool { return n < l }, "must be at least %v"},
+			{"maximum", func(n, l float64) bool { return n > l }, "must be at most %v"},
+			{"exclusiveMinimum", func(n, l float64) bool { return n <= l }, "must be more than %v"},
+			{"exclusiveMaximum", func(n, l float64) bool { return n >= l }, "must be less than %v"},
+			{"multipleOf", func(n, l float64) bool { return l > 0 && math.Mod(n, l) != 0 }, "must be a multiple of %v"},
+		}
+	case string:
+		size = float64(utf8.RuneCountInString(v))
+		limits = []limit{
+			{"minLength", func(n, l float64) bool { return n < l }, "must be at least %v characters"},
+			{"maxLength", func(n, l float64) bool { return n > l }, "must be at most %v characters"},
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("Invalid schema at %s: %w", where, err)
+			}
+			if !re.MatchString(v) {
+				return fmt.Errorf("%s: must match %s", where, pattern)
+			}
+		}
+	case []interface{}:
+		size = float64(len(v))
+		limits = []limit{
+			{"minItems", func(n, l float64) bool { return n < l }, "must have at least %v items"},
+			{"maxItems", func(n, l float64) bool { return n > l }, "must have at most %v items"},
+		}
+	case map[string]interface{}:
+		size = float64(len(v))
+		limits = []limit{
+			{"minProperties", func(n, l float64) bool { return n < l }, "must have at least %v properties"},
+			{"maxProperties", func(n, l float64) bool { return n > l }, "must have at most %v properties"},
+		}
+	}
+	for _, l := range limits {
+		n, ok, err := schemaNumber(schema, l.key)
+		if err != nil {
+			return err
+		}
+		if ok && l.fails(size, n) {
+			return fmt.Errorf("%s: "+l.msg, where, n)
+		}
+	}
+	return nil
+}
+
+func propertyNames(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func compactJSON(v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(buf)
+}
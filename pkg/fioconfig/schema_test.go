@@ -0,0 +1,128 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const portSchema = `{
+	"type": "object",
+	"required": ["port"],
+	"properties": {
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"mode": {"enum": ["fast", "safe"]},
+		"hosts": {"type": "array", "items": {"type": "string", "pattern": "^[a-z.]+$"}, "uniqueItems": true}
+	},
+	"additionalProperties": false
+}`
+
+func TestCheckSchema(t *testing.T) {
+	var schema interface{}
+	require.Nil(t, json.Unmarshal([]byte(portSchema), &schema))
+
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{`{"port": 80}`, ""},
+		{`{"port": 80, "mode": "safe", "hosts": ["a.example", "b.example"]}`, ""},
+		{`[]`, "/: must be object, not array"},
+		{`{}`, "/: port is required"},
+		{`{"port": 0}`, "/port: must be at least 1"},
+		{`{"port": 80.5}`, "/port: must be integer, not number"},
+		{`{"port": "80"}`, "/port: must be integer, not string"},
+		{`{"port": 80, "mode": "slow"}`, `/mode: must be one of ["fast","safe"]`},
+		{`{"port": 80, "hosts": ["A"]}`, "/hosts/0: must match ^[a-z.]+$"},
+		{`{"port": 80, "hosts": ["a", "a"]}`, "/hosts: items 0 and 1 are the same"},
+		{`{"port": 80, "extra": 1}`, "/: extra is not allowed"},
+	}
+	for _, tc := range tests {
+		var value interface{}
+		require.Nil(t, json.Unmarshal([]byte(tc.value), &value))
+		err := checkSchema(schema, value, "")
+		if len(tc.err) == 0 {
+			require.Nil(t, err, tc.value)
+		} else {
+			require.NotNil(t, err, tc.value)
+			require.Equal(t, tc.err, err.Error())
+		}
+	}
+
+	var combined interface{}
+	require.Nil(t, json.Unmarshal([]byte(`{"anyOf": [{"type": "string", "maxLength": 2}, {"type": "null"}], "not": {"const": "no"}}`), &combined))
+	require.Nil(t, checkSchema(combined, "ok", ""))
+	require.Nil(t, checkSchema(combined, nil, ""))
+	require.NotNil(t, checkSchema(combined, "toolong", ""))
+	require.NotNil(t, checkSchema(combined, "no", ""))
+
+	require.NotNil(t, checkSchema(map[string]interface{}{"$ref": "#/definitions/x"}, 1.0, ""))
+}
+
+func TestParseStructured(t *testing.T) {
+	for fname, content := range map[string]string{
+		"app.json": `{"port": 80, "hosts": ["a"]}`,
+		"app.yaml": "port: 80\nhosts:\n  - a\n",
+		"app.toml": "port = 80\nhosts = [\"a\"]\n",
+	} {
+		value, err := parseStructured(fname, []byte(content))
+		require.Nil(t, err, fname)
+		require.Equal(t, map[string]interface{}{"port": 80.0, "hosts": []interface{}{"a"}}, value, fname)
+	}
+	_, err := parseStructured("app.json", []byte("port: 80"))
+	require.NotNil(t, err)
+}
+
+func TestExtractSchema(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		prev := ConfigStruct{
+			"schemas/app.json": {Value: portSchema},
+			"app/config.yaml":  {Value: "port: 80\n", Schema: "schemas/app.json"},
+		}
+		_, err := app.extract(context.Background(), nil, configSnapshot{nil, prev})
+		require.Nil(t, err)
+		assertFile(t, filepath.Join(tempdir, "app/config.yaml"), []byte("port: 80\n"))
+
+		// A value that doesn't match rejects the config
+		next := ConfigStruct{
+			"schemas/app.json": {Value: portSchema},
+			"app/config.yaml":  {Value: "port: 99999\n", Schema: "schemas/app.json"},
+			"other":            {Value: "other"},
+		}
+		report, err := app.extract(context.Background(), nil, configSnapshot{prev, next})
+		var rejected *ConfigRejectedError
+		require.True(t, errors.As(err, &rejected), err)
+		require.Equal(t, "schema schemas/app.json", rejected.Verifier)
+		require.Equal(t, EventSchemaInvalid, report.Code)
+		require.Contains(t, report.Rejected, "app/config.yaml: /port: must be at most 65535")
+		assertFile(t, filepath.Join(tempdir, "app/config.yaml"), []byte("port: 80\n"))
+		assertNoFile(t, filepath.Join(tempdir, "other"))
+
+		// With partial_extract only that file is left out
+		app.settings.PartialExtract = true
+		report, err = app.extract(context.Background(), nil, configSnapshot{prev, next})
+		require.Nil(t, err)
+		require.Contains(t, report.Failed, "app/config.yaml")
+		assertFile(t, filepath.Join(tempdir, "app/config.yaml"), []byte("port: 80\n"))
+		assertFile(t, filepath.Join(tempdir, "other"), []byte("other"))
+		app.settings.PartialExtract = false
+
+		// Schemas can be shipped with the image
+		schema := filepath.Join(t.TempDir(), "app.json")
+		require.Nil(t, os.WriteFile(schema, []byte(portSchema), 0o644))
+		local := ConfigStruct{"app/config.yaml": {Value: "port: 443\n", Schema: schema}}
+		_, err = app.extract(context.Background(), nil, configSnapshot{prev, local})
+		require.Nil(t, err)
+		assertFile(t, filepath.Join(tempdir, "app/config.yaml"), []byte("port: 443\n"))
+
+		missing := ConfigStruct{"app/config.yaml": {Value: "port: 443\n", Schema: "schemas/missing.json"}}
+		_, err = app.extract(context.Background(), nil, configSnapshot{local, missing})
+		require.NotNil(t, err)
+	})
+}
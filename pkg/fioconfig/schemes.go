@@ -0,0 +1,152 @@
+package fioconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	ecies "github.com/foundriesio/go-ecies"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Config values are encrypted to the device's key with one of these
+// schemes, and the first byte of a value's payload says which. Values from
+// before there were IDs start with the 0x04 of ECIES's uncompressed
+// ephemeral key, so that's the ID of the original scheme. New schemes get
+// other IDs, letting the server move to new parameters without breaking
+// devices that only know the old ones. Check-ins list the schemes the
+// device can decrypt in the X-Fio-Encryption-Schemes header.
+
+const encryptionSchemesHeader = "X-Fio-Encryption-Schemes"
+
+// UnsupportedSchemeError is returned for values encrypted with a scheme
+// this version of fioconfig doesn't know
+var UnsupportedSchemeError = errors.New("Unsupported encryption scheme")
+
+const (
+	// ECIES with the go-ecies parameters for the key's curve, e.g.
+	// AES-128-CTR and HMAC-SHA256 for P-256
+	schemeEcies byte = 0x04
+	// ECDH with an ephemeral key, HKDF-SHA256 and ChaCha20-Poly1305
+	schemeEcdhChaCha byte = 0x10
+
+	ecdhChaChaName = "ecdh-hkdf-chacha20poly1305"
+)
+
+type encryptionScheme struct {
+	id   byte
+	name string
+	// Both work on the whole payload, including the scheme ID
+	encrypt func(pub *ecies.PublicKey, plain []byte) ([]byte, error)
+	decrypt func(key ecies.KeyProvider, payload []byte) ([]byte, error)
+}
+
+var encryptionSchemes = []encryptionScheme{
+	{schemeEcies, "ecies", encryptEcies, decryptEcies},
+	{schemeEcdhChaCha, ecdhChaChaName, encryptEcdhChaCha, decryptEcdhChaCha},
+}
+
+func schemeById(id byte) (*encryptionScheme, error) {
+	for i := range encryptionSchemes {
+		if encryptionSchemes[i].id == id {
+			return &encryptionSchemes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: 0x%02x", UnsupportedSchemeError, id)
+}
+
+// supportedSchemes returns the value of the X-Fio-Encryption-Schemes
+// header, or nothing if crypto doesn't decrypt with these schemes
+func supportedSchemes(crypto CryptoHandler) string {
+	if c, ok := crypto.(ctxCrypto); ok {
+		crypto = c.CryptoHandler
+	}
+	if _, ok := crypto.(*EciesCrypto); !ok {
+		return ""
+	}
+	names := make([]string, len(encryptionSchemes))
+	for i, scheme := range encryptionSchemes {
+		names[i] = scheme.name
+	}
+	return strings.Join(names, ", ")
+}
+
+func encryptEcies(pub *ecies.PublicKey, plain []byte) ([]byte, error) {
+	return ecies.Encrypt(rand.Reader, pub, plain, nil, nil)
+}
+
+func decryptEcies(key ecies.KeyProvider, payload []byte) ([]byte, error) {
+	return ecies.Decrypt(key, payload, nil, nil)
+}
+
+// ecdhChaChaKey derives the ChaCha20-Poly1305 key from the ECDH shared
+// secret, salted with both public keys like age's piv-p256 stanza
+func ecdhChaChaKey(shared, epk []byte, pub *ecies.PublicKey) ([]byte, error) {
+	pk := elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y)
+	salt := append(append([]byte{}, epk...), pk...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("fioconfig "+ecdhChaChaName)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// The payload is the ID, the compressed ephemeral public key, and the
+// sealed value. Each value has its own key, so the nonce is always zero.
+func encryptEcdhChaCha(pub *ecies.PublicKey, plain []byte) ([]byte, error) {
+	ephemeral, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ecies.ImportECDSA(ephemeral).GenerateShared(pub)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(shared)
+	epk := elliptic.MarshalCompressed(pub.Curve, ephemeral.X, ephemeral.Y)
+	key, err := ecdhChaChaKey(shared, epk, pub)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(key)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte{schemeEcdhChaCha}, epk...)
+	return aead.Seal(header, make([]byte, chacha20poly1305.NonceSize), plain, header[:1]), nil
+}
+
+func decryptEcdhChaCha(key ecies.KeyProvider, payload []byte) ([]byte, error) {
+	pub := key.Public()
+	epkLen := 1 + (pub.Curve.Params().BitSize+7)/8
+	if len(payload) < 1+epkLen {
+		return nil, ecies.ErrInvalidMessage
+	}
+	epk := payload[1 : 1+epkLen]
+	x, y := elliptic.UnmarshalCompressed(pub.Curve, epk)
+	if x == nil {
+		return nil, errors.New("Invalid ephemeral key")
+	}
+	shared, err := key.GenerateShared(ecies.ImportECDSAPublic(&ecdsa.PublicKey{Curve: pub.Curve, X: x, Y: y}))
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(shared)
+	aeadKey, err := ecdhChaChaKey(shared, epk, pub)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(aeadKey)
+	aead, err := chacha20poly1305.New(aeadKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), payload[1+epkLen:], payload[:1])
+}
@@ -0,0 +1,63 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptionSchemes(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		require.Nil(t, err)
+		crypto := NewEciesLocalHandler(key).(*EciesCrypto)
+
+		// The original scheme's ID is the start of every old value
+		enc, err := crypto.Encrypt("legacy")
+		require.Nil(t, err)
+		data, err := base64.StdEncoding.DecodeString(enc)
+		require.Nil(t, err)
+		require.Equal(t, schemeEcies, data[0])
+
+		for _, scheme := range encryptionSchemes {
+			payload, err := scheme.encrypt(crypto.PrivKey.Public(), []byte("secret"))
+			require.Nil(t, err)
+			require.Equal(t, scheme.id, payload[0])
+			decrypted, err := crypto.Decrypt(base64.StdEncoding.EncodeToString(payload))
+			require.Nil(t, err, scheme.name)
+			require.Equal(t, "secret", string(decrypted))
+
+			payload[len(payload)-1] ^= 1
+			_, err = crypto.Decrypt(base64.StdEncoding.EncodeToString(payload))
+			require.NotNil(t, err, scheme.name)
+		}
+
+		payload, err := encryptEcdhChaCha(crypto.PrivKey.Public(), []byte("secret"))
+		require.Nil(t, err)
+		payload[0] = 0x7f
+		_, err = crypto.Decrypt(base64.StdEncoding.EncodeToString(payload))
+		require.True(t, errors.Is(err, UnsupportedSchemeError), err)
+	}
+}
+
+func TestEncryptionSchemesHeader(t *testing.T) {
+	var schemes string
+	doGet := func(w http.ResponseWriter, r *http.Request) {
+		schemes = r.Header.Get(encryptionSchemesHeader)
+		w.WriteHeader(http.StatusNotModified)
+	}
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		require.True(t, errors.Is(app.checkin(context.Background(), client, crypto), NotModifiedError))
+		require.Equal(t, "ecies, ecdh-hkdf-chacha20poly1305", schemes)
+	})
+}
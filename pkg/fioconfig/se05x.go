@@ -0,0 +1,63 @@
+package fioconfig
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ThalesIgnite/crypto11"
+	toml "github.com/pelletier/go-toml"
+)
+
+// NXP's PKCS#11 middleware for the SE050/SE051 secure elements
+const se05xDefaultModule = "/usr/lib/libsss_pkcs11.so"
+
+// se05xObjectId converts an SE05x object ID like "0x7fff0201" into the
+// CKA_ID the middleware exposes it with.
+func se05xObjectId(id string) ([]byte, error) {
+	id = strings.TrimPrefix(strings.ToLower(id), "0x")
+	val, err := hex.DecodeString(id)
+	if err != nil || len(val) != 4 {
+		return nil, fmt.Errorf("Invalid SE05x object ID %s: must be 4 bytes of hex", id)
+	}
+	return val, nil
+}
+
+// se05xIdentity uses the secure element found on many i.MX boards via its
+// PKCS#11 middleware. The key pair is used for the mTLS handshake and ECDH,
+// so neither operation happens outside the SE.
+func se05xIdentity(sota *toml.Tree) (tls.Certificate, CryptoHandler, error) {
+	pkeyVal, err := tomlGet(sota, "se05x.tls_pkey_id")
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pkeyId, err := se05xObjectId(pkeyVal)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	certVal, err := tomlGet(sota, "se05x.tls_clientcert_id")
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	certId, err := se05xObjectId(certVal)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	slot := int(sota.GetDefault("se05x.slot", int64(0)).(int64))
+	pin := sota.GetDefault("se05x.pass", "").(string)
+	cfg := crypto11.Config{
+		Path:       sota.GetDefault("se05x.module", se05xDefaultModule).(string),
+		SlotNumber: &slot,
+		Pin:        pin,
+		// The middleware doesn't require a login unless the objects were
+		// provisioned with a user PIN
+		LoginNotSupported: len(pin) == 0,
+	}
+	login, err := pkcs11Tuning(sota, "se05x", &cfg)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	return pkcs11LoadIdentity(&cfg, login, pkeyId, certId)
+}
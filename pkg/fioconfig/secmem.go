@@ -0,0 +1,30 @@
+package fioconfig
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// Decrypted config values are held in memory while they're extracted.
+// Go strings can't be wiped, so zeroize only covers the byte slices the
+// plaintext passes through. lock_memory keeps all of it out of swap.
+
+// zeroize overwrites plaintext that's no longer needed
+func zeroize(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	runtime.KeepAlive(buf)
+}
+
+// LockMemory locks the process's current and future memory in RAM so
+// decrypted config values are never written to swap. It needs
+// CAP_IPC_LOCK or a large enough RLIMIT_MEMLOCK, e.g. LimitMEMLOCK=infinity
+// in the systemd unit.
+func LockMemory() error {
+	if err := syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE); err != nil {
+		return fmt.Errorf("Unable to lock memory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package fioconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// keptCrypto hands out a buffer the test can look at afterwards
+type keptCrypto struct {
+	buf []byte
+}
+
+func (c *keptCrypto) Decrypt(value string) ([]byte, error) {
+	c.buf = []byte(value)
+	return c.buf, nil
+}
+
+func (c *keptCrypto) Close() {}
+
+func TestDecryptedBufferZeroized(t *testing.T) {
+	crypto := &keptCrypto{}
+	config, err := UnmarshallBuffer(crypto, []byte(`{"foo": {"Value": "s3cret"}}`), true)
+	require.Nil(t, err)
+	require.Equal(t, "s3cret", config["foo"].Value)
+	require.Equal(t, make([]byte, 6), crypto.buf)
+}
@@ -0,0 +1,358 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// SecretStore is where extracted config files are kept. The filesystem
+// store is the default and is extracted to transactionally with renames.
+// Other stores are for devices that must never write plaintext secrets to
+// flash.
+type SecretStore interface {
+	// Read returns an error matching os.ErrNotExist for files not in the
+	// store
+	Read(name string) ([]byte, error)
+	Write(name string, content []byte) error
+	Remove(name string) error
+}
+
+const (
+	SecretStoreFilesystem = "filesystem"
+	SecretStoreMemory     = "memory"
+	SecretStoreVault      = "vault"
+	SecretStoreKubernetes = "kubernetes"
+)
+
+// newStores returns the secret store and Kubernetes mirror settings select.
+// A store given to NewApp is always used.
+func (a *App) newStores(settings Settings) (SecretStore, SecretStore, error) {
+	store := a.storeOverride
+	if store == nil {
+		var err error
+		if store, err = newSecretStore(settings); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !settings.KubernetesMirror {
+		return store, nil, nil
+	}
+	if store != nil {
+		return nil, nil, errors.New("fioconfig.kubernetes_mirror can only be used with the filesystem secret store")
+	}
+	mirror, err := newKubeStore(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, mirror, nil
+}
+
+// storeSettingsChanged reports whether the stores made from prev need
+// replacing for next. The memory store loses its files when it's replaced,
+// so it's only done when they change.
+func storeSettingsChanged(prev, next Settings) bool {
+	return prev.SecretStore != next.SecretStore ||
+		prev.VaultAddr != next.VaultAddr ||
+		prev.VaultPath != next.VaultPath ||
+		prev.KubernetesMirror != next.KubernetesMirror ||
+		prev.Kubeconfig != next.Kubeconfig ||
+		prev.KubernetesNamespace != next.KubernetesNamespace ||
+		strings.Join(prev.KubernetesSecrets, "\n") != strings.Join(next.KubernetesSecrets, "\n")
+}
+
+// newSecretStore returns the store selected by the secret_store setting,
+// or nil for the filesystem.
+func newSecretStore(settings Settings) (SecretStore, error) {
+	switch settings.SecretStore {
+	case "", SecretStoreFilesystem:
+		return nil, nil
+	case SecretStoreMemory:
+		return NewMemoryStore(), nil
+	case SecretStoreVault:
+		return newVaultStore(settings.VaultAddr, settings.VaultPath)
+	case SecretStoreKubernetes:
+		return newKubeStore(settings)
+	}
+	return nil, fmt.Errorf("Unsupported fioconfig.secret_store: %s", settings.SecretStore)
+}
+
+// secretStore returns the store files are read from
+func (a *App) secretStore() SecretStore {
+	if a.store != nil {
+		return a.store
+	}
+	return fsStore{a.SecretsDir}
+}
+
+type fsStore struct {
+	dir string
+}
+
+func (s fsStore) Read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+func (s fsStore) Write(name string, content []byte) error {
+	path := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("Unable to create parent directory: %w", err)
+	}
+	return safeWrite(path, content)
+}
+
+func (s fsStore) Remove(name string) error {
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+// MemoryStore keeps extracted files in the process, for programs embedding
+// fioconfig in a container
+type MemoryStore struct {
+	lock  sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{files: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Read(name string) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	content, ok := s.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), content...), nil
+}
+
+func (s *MemoryStore) Write(name string, content []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files[name] = append([]byte(nil), content...)
+	return nil
+}
+
+func (s *MemoryStore) Remove(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(s.files, name)
+	return nil
+}
+
+// Names returns the stored files in sorted order
+func (s *MemoryStore) Names() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// vaultStore writes files to a Vault KV version 2 secrets engine through
+// the local Vault agent, which adds its auto-auth token to our requests.
+type vaultStore struct {
+	client *http.Client
+	addr   string
+	mount  string
+	prefix string
+}
+
+type vaultSecret struct {
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func newVaultStore(addr, path string) (*vaultStore, error) {
+	if len(addr) == 0 {
+		addr = "http://127.0.0.1:8100"
+	}
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, fmt.Errorf("Invalid fioconfig.vault_path %q, it must be <mount>/<path>", path)
+	}
+	return &vaultStore{
+		client: &http.Client{Timeout: 30 * time.Second},
+		addr:   strings.TrimRight(addr, "/"),
+		mount:  parts[0],
+		prefix: parts[1],
+	}, nil
+}
+
+func (s *vaultStore) url(kind, name string) string {
+	return fmt.Sprintf("%s/v1/%s/%s/%s/%s", s.addr, s.mount, kind, s.prefix, name)
+}
+
+func (s *vaultStore) Read(name string) ([]byte, error) {
+	res, err := httpDoOnce(context.Background(), s.client, http.MethodGet, s.url("data", name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to read %s from Vault - HTTP_%d: %s", name, res.StatusCode, res.String())
+	}
+	var body struct {
+		Data struct {
+			Data vaultSecret `json:"data"`
+		} `json:"data"`
+	}
+	if err := res.Json(&body); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s from Vault: %w", name, err)
+	}
+	secret := body.Data.Data
+	if secret.Encoding == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(secret.Value)
+	}
+	return []byte(secret.Value), nil
+}
+
+func (s *vaultStore) Write(name string, content []byte) error {
+	secret := vaultSecret{Value: string(content)}
+	if !utf8.Valid(content) {
+		secret = vaultSecret{base64.StdEncoding.EncodeToString(content), EncodingBase64}
+	}
+	data := map[string]vaultSecret{"data": secret}
+	res, err := httpDoOnce(context.Background(), s.client, http.MethodPost, s.url("data", name), nil, data)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("Unable to write %s to Vault - HTTP_%d: %s", name, res.StatusCode, res.String())
+	}
+	return nil
+}
+
+// Remove deletes every version of the file so no plaintext copy is left
+func (s *vaultStore) Remove(name string) error {
+	res, err := httpDoOnce(context.Background(), s.client, http.MethodDelete, s.url("metadata", name), nil, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("Unable to remove %s from Vault - HTTP_%d: %s", name, res.StatusCode, res.String())
+	}
+	return nil
+}
+
+// storeTxn applies a config to a SecretStore. Stores can't rename, so the
+// previous content of the files it changes is kept in memory and written
+// back if a step fails.
+type storeTxn struct {
+	store SecretStore
+	ops   []storeOp
+	done  []storeOp
+}
+
+type storeOp struct {
+	fname   string
+	content []byte // nil when removing the file
+	prev    []byte // nil when the file didn't exist
+}
+
+func beginStoreTxn(store SecretStore) *storeTxn {
+	return &storeTxn{store: store}
+}
+
+// beginTxn starts applying a config to the secrets directory or the
+// configured SecretStore. Verifiers need the new config written out as
+// files, which a store that keeps plaintext off disk can't allow.
+func (a *App) beginTxn(dirMode os.FileMode) (configTxn, error) {
+	if a.store == nil {
+		txn, err := beginExtract(a.SecretsDir, dirMode)
+		if err != nil {
+			return nil, err
+		}
+		txn.labels = a.fileContexts
+		return txn, nil
+	}
+	if a.hasVerifiers() {
+		return nil, errors.New("Config verifiers can only be used with the filesystem secret store")
+	}
+	return beginStoreTxn(a.store), nil
+}
+
+func (t *storeTxn) stage(i int, fname string, cfgFile *ConfigFile, appliedHash string) (bool, error) {
+	content, err := cfgFile.content()
+	if err != nil {
+		return false, err
+	}
+	cur, err := t.store.Read(fname)
+	if err == nil {
+		if contentEqual(cfgFile.Compare, cur, content) {
+			return false, nil
+		}
+		if cfgFile.IgnoreHookChanges && appliedHash == sha256Hex(content) {
+			LogEvent(EventLocalChangeKept, "%s was modified locally but is unchanged on the server, leaving it as is", fname)
+			return false, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if content == nil {
+		content = []byte{}
+	}
+	t.ops = append(t.ops, storeOp{fname: fname, content: content})
+	return true, nil
+}
+
+func (t *storeTxn) remove(fname string) {
+	t.ops = append(t.ops, storeOp{fname: fname})
+}
+
+func (t *storeTxn) commit() error {
+	for _, op := range t.ops {
+		prev, err := t.store.Read(op.fname)
+		if err == nil {
+			op.prev = prev
+		} else if !errors.Is(err, os.ErrNotExist) {
+			t.rollback()
+			return &TxnError{op.fname, err}
+		}
+		if op.content != nil {
+			err = t.store.Write(op.fname, op.content)
+		} else if op.prev != nil {
+			err = t.store.Remove(op.fname)
+		}
+		if err != nil {
+			t.rollback()
+			return &TxnError{op.fname, err}
+		}
+		t.done = append(t.done, op)
+	}
+	return nil
+}
+
+func (t *storeTxn) rollback() {
+	for i := len(t.done) - 1; i >= 0; i-- {
+		op := t.done[i]
+		var err error
+		if op.prev != nil {
+			err = t.store.Write(op.fname, op.prev)
+		} else if op.content != nil {
+			err = t.store.Remove(op.fname)
+		}
+		if err != nil {
+			LogEvent(EventExtractRollback, "ERROR: Unable to restore %s: %s", op.fname, err)
+		}
+	}
+	LogEvent(EventExtractRollback, "Restored previous config files")
+}
+
+func (t *storeTxn) close() {}
@@ -0,0 +1,148 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		store := NewMemoryStore()
+		app.store = store
+
+		buf, err := os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		var config map[string]*ConfigFile
+		require.Nil(t, json.Unmarshal(buf, &config))
+		stdin := filepath.Join(tempdir, "bar-stdin")
+		config["bar"].OnChanged = []string{"/bin/sh", "-c", "cat > " + stdin + "; echo $CONFIG_NAME >> " + stdin}
+		buf, err = json.Marshal(config)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(app.EncryptedConfig, buf, 0o644))
+
+		require.Nil(t, app.Extract())
+		require.Equal(t, []string{"bar", "foo", "random", "with/subdir/1.txt"}, store.Names())
+		content, err := store.Read("foo")
+		require.Nil(t, err)
+		require.Equal(t, "foo file value", string(content))
+		_, err = os.Stat(filepath.Join(tempdir, "foo"))
+		require.True(t, errors.Is(err, os.ErrNotExist))
+
+		// The handler gets the content on stdin rather than a path
+		content, err = os.ReadFile(stdin)
+		require.Nil(t, err)
+		require.Equal(t, "bar file valuebar\n", string(content))
+
+		// Nothing changed so nothing is written
+		require.Nil(t, os.Remove(stdin))
+		require.Nil(t, app.Extract())
+		_, err = os.Stat(stdin)
+		require.True(t, errors.Is(err, os.ErrNotExist))
+
+		drift, err := app.CheckDrift()
+		require.Nil(t, err)
+		require.Empty(t, drift)
+	})
+}
+
+// failingStore fails to write the file named fail
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s failingStore) Write(name string, content []byte) error {
+	if name == "fail" {
+		return errors.New("store is full")
+	}
+	return s.MemoryStore.Write(name, content)
+}
+
+func TestStoreTxnRollback(t *testing.T) {
+	store := failingStore{NewMemoryStore()}
+	require.Nil(t, store.Write("changed", []byte("old")))
+	require.Nil(t, store.Write("removed", []byte("keep me")))
+
+	txn := beginStoreTxn(store)
+	for i, fname := range []string{"changed", "new", "fail"} {
+		updated, err := txn.stage(i, fname, &ConfigFile{Value: "new value"}, "")
+		require.Nil(t, err)
+		require.True(t, updated)
+	}
+	txn.remove("removed")
+	err := txn.commit()
+	var txnErr *TxnError
+	require.True(t, errors.As(err, &txnErr), err)
+	require.Equal(t, "fail", txnErr.File)
+
+	require.Equal(t, []string{"changed", "removed"}, store.Names())
+	content, err := store.Read("changed")
+	require.Nil(t, err)
+	require.Equal(t, "old", string(content))
+}
+
+func TestVaultStore(t *testing.T) {
+	var lock sync.Mutex
+	secrets := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		path := r.URL.Path
+		switch {
+		case r.Method == http.MethodPost && strings.HasPrefix(path, "/v1/secret/data/fioconfig/"):
+			body, err := io.ReadAll(r.Body)
+			require.Nil(t, err)
+			var req struct {
+				Data json.RawMessage `json:"data"`
+			}
+			require.Nil(t, json.Unmarshal(body, &req))
+			secrets[strings.TrimPrefix(path, "/v1/secret/data/")] = string(req.Data)
+		case r.Method == http.MethodGet && strings.HasPrefix(path, "/v1/secret/data/fioconfig/"):
+			data, ok := secrets[strings.TrimPrefix(path, "/v1/secret/data/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, err := w.Write([]byte(`{"data": {"data": ` + data + `}}`))
+			require.Nil(t, err)
+		case r.Method == http.MethodDelete && strings.HasPrefix(path, "/v1/secret/metadata/fioconfig/"):
+			delete(secrets, strings.TrimPrefix(path, "/v1/secret/metadata/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	_, err := newSecretStore(Settings{SecretStore: SecretStoreVault, VaultPath: "secret"})
+	require.NotNil(t, err)
+	store, err := newSecretStore(Settings{SecretStore: SecretStoreVault, VaultAddr: server.URL, VaultPath: "secret/fioconfig"})
+	require.Nil(t, err)
+
+	_, err = store.Read("foo")
+	require.True(t, errors.Is(err, os.ErrNotExist), err)
+	require.Nil(t, store.Write("foo", []byte("text")))
+	binary := []byte{0xff, 0x00, 0xfe}
+	require.Nil(t, store.Write("sub/bin", binary))
+	require.Contains(t, secrets["fioconfig/sub/bin"], `"encoding":"base64"`)
+
+	content, err := store.Read("foo")
+	require.Nil(t, err)
+	require.Equal(t, "text", string(content))
+	content, err = store.Read("sub/bin")
+	require.Nil(t, err)
+	require.Equal(t, binary, content)
+
+	require.Nil(t, store.Remove("foo"))
+	_, err = store.Read("foo")
+	require.True(t, errors.Is(err, os.ErrNotExist), err)
+}
@@ -0,0 +1,158 @@
+package fioconfig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/opencontainers/selinux/go-selinux"
+)
+
+// Extracted files are staged and then renamed into place, so they keep the
+// label of the staging directory rather than the one the policy gives their
+// path. With selinux_relabel, fioconfig does what restorecon would to each
+// file it puts in place.
+
+const selinuxConfig = "/etc/selinux/config"
+
+// fileContexts is the policy's file_contexts, in the order matches are
+// tried
+type fileContexts struct {
+	specs []fileContextSpec
+}
+
+type fileContextSpec struct {
+	re       *regexp.Regexp
+	fileType string // e.g. "--" for regular files, empty for any
+	context  string
+}
+
+// loadFileContexts reads the file contexts of the policy in use
+func loadFileContexts(root string) (*fileContexts, error) {
+	policy := "targeted"
+	if f, err := os.Open(filepath.Join(root, selinuxConfig)); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "SELINUXTYPE=") {
+				policy = strings.Trim(strings.TrimPrefix(line, "SELINUXTYPE="), `"`)
+			}
+		}
+		f.Close()
+	}
+	dir := filepath.Join(root, "/etc/selinux", policy, "contexts/files")
+	var exact, regexes []fileContextSpec
+	for _, name := range []string{"file_contexts", "file_contexts.homedirs", "file_contexts.local"} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) && name != "file_contexts" {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Unable to read SELinux file contexts: %w", err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			re, err := regexp.Compile("^(?:" + fields[0] + ")$")
+			if err != nil {
+				continue
+			}
+			spec := fileContextSpec{re: re, context: fields[len(fields)-1]}
+			if len(fields) > 2 {
+				spec.fileType = fields[1]
+			}
+			// Like libselinux, paths without regex characters win over
+			// patterns, and later entries win over earlier ones
+			if regexp.QuoteMeta(fields[0]) == fields[0] {
+				exact = append([]fileContextSpec{spec}, exact...)
+			} else {
+				regexes = append([]fileContextSpec{spec}, regexes...)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("Unable to read SELinux file contexts: %w", err)
+		}
+	}
+	return &fileContexts{append(exact, regexes...)}, nil
+}
+
+// lookup returns the context the policy gives path, or false if it should
+// be left alone
+func (fc *fileContexts) lookup(path string, mode os.FileMode) (string, bool) {
+	fileType := "--"
+	switch {
+	case mode.IsDir():
+		fileType = "-d"
+	case mode&os.ModeSymlink != 0:
+		fileType = "-l"
+	}
+	for _, spec := range fc.specs {
+		if len(spec.fileType) > 0 && spec.fileType != fileType {
+			continue
+		}
+		if spec.re.MatchString(path) {
+			return spec.context, spec.context != "<<none>>"
+		}
+	}
+	return "", false
+}
+
+// relabel gives path the context the policy says it should have
+func (fc *fileContexts) relabel(path string) error {
+	if fc == nil {
+		return nil
+	}
+	st, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	want, ok := fc.lookup(path, st.Mode())
+	if !ok {
+		return nil
+	}
+	if cur, err := selinux.LfileLabel(path); err == nil && cur == want {
+		return nil
+	}
+	if err := selinux.LsetFileLabel(path, want); err != nil {
+		return fmt.Errorf("Unable to set SELinux context of %s: %w", path, err)
+	}
+	return nil
+}
+
+// relabelTree relabels path and its parents up to, but not including, top
+func (fc *fileContexts) relabelTree(top, path string) {
+	if fc == nil {
+		return
+	}
+	top = filepath.Clean(top)
+	for ; strings.HasPrefix(path, top+"/"); path = filepath.Dir(path) {
+		if err := fc.relabel(path); err != nil {
+			LogEvent(EventRelabelFailed, "WARNING: %s", err)
+		}
+	}
+}
+
+// initFileContexts loads the file contexts when selinux_relabel is set
+func (a *App) initFileContexts() {
+	a.fileContexts = nil
+	if !a.settings.SelinuxRelabel || a.store != nil {
+		return
+	}
+	if !selinux.GetEnabled() {
+		logger.Printf("SELinux is disabled, not relabeling extracted files")
+		return
+	}
+	fc, err := loadFileContexts("/")
+	if err != nil {
+		LogEvent(EventRelabelFailed, "WARNING: %s", err)
+		return
+	}
+	a.fileContexts = fc
+}
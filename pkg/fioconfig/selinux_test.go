@@ -0,0 +1,49 @@
+package fioconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileContexts(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "etc/selinux/custom/contexts/files")
+	require.Nil(t, os.MkdirAll(dir, 0o755))
+	require.Nil(t, os.WriteFile(filepath.Join(root, "etc/selinux/config"), []byte("SELINUX=enforcing\nSELINUXTYPE=custom\n"), 0o644))
+	contexts := `
+# comment
+/run/secrets(/.*)?		system_u:object_r:secrets_t:s0
+/run/secrets/nginx(/.*)?	system_u:object_r:nginx_secrets_t:s0
+/run/secrets/nginx	-d	system_u:object_r:secrets_t:s0
+/run/secrets/unlabeled	--	<<none>>
+`
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "file_contexts"), []byte(contexts), 0o644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "file_contexts.local"), []byte("/run/secrets/local.*	system_u:object_r:local_t:s0\n"), 0o644))
+
+	fc, err := loadFileContexts(root)
+	require.Nil(t, err)
+
+	label, ok := fc.lookup("/run/secrets/foo", 0)
+	require.True(t, ok)
+	require.Equal(t, "system_u:object_r:secrets_t:s0", label)
+
+	// Later entries win
+	label, _ = fc.lookup("/run/secrets/nginx/tls.key", 0)
+	require.Equal(t, "system_u:object_r:nginx_secrets_t:s0", label)
+	label, _ = fc.lookup("/run/secrets/local-file", 0)
+	require.Equal(t, "system_u:object_r:local_t:s0", label)
+
+	// Exact paths win over patterns, and only for their file type
+	label, _ = fc.lookup("/run/secrets/nginx", os.ModeDir)
+	require.Equal(t, "system_u:object_r:secrets_t:s0", label)
+	label, _ = fc.lookup("/run/secrets/nginx", 0)
+	require.Equal(t, "system_u:object_r:nginx_secrets_t:s0", label)
+
+	_, ok = fc.lookup("/run/secrets/unlabeled", 0)
+	require.False(t, ok)
+	_, ok = fc.lookup("/etc/passwd", 0)
+	require.False(t, ok)
+}
@@ -0,0 +1,83 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SetConfigFile uploads a file to this device's config on the server so
+// that settings generated on the device, like calibration data, go through
+// the same pipeline as everything else. Unless it's marked Unencrypted, the
+// value is encrypted to the device's own key first. The file is applied by
+// the next check-in like any other change.
+func (a *App) SetConfigFile(name string, cfgFile *ConfigFile, reason string) error {
+	if err := validateFileName(name); err != nil {
+		return err
+	}
+	client, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return err
+	}
+	defer a.releaseCrypto(crypto)
+	return a.setConfigFile(client, crypto, name, cfgFile, reason)
+}
+
+func (a *App) setConfigFile(client *http.Client, crypto CryptoHandler, name string, cfgFile *ConfigFile, reason string) error {
+	if err := validateFileName(name); err != nil {
+		return err
+	}
+	var err error
+	req := cfgFile.request(name)
+	if !cfgFile.Unencrypted {
+		enc, ok := crypto.(keyEncrypter)
+		if !ok {
+			return errors.New("Crypto handler can't encrypt config values")
+		}
+		if req.Value, err = enc.Encrypt(cfgFile.Value); err != nil {
+			return fmt.Errorf("Unable to encrypt %s: %w", name, err)
+		}
+	}
+	if len(reason) == 0 {
+		reason = fmt.Sprintf("Set %s from fioconfig", name)
+	}
+	ccr := ConfigCreateRequest{Reason: reason, Files: []ConfigFileReq{req}}
+	res, err := httpPatch(client, a.configUrl, ccr)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 204 {
+		return fmt.Errorf("Unable to set %s: %s - HTTP_%d: %s", name, a.configUrl, res.StatusCode, res.String())
+	}
+	LogEvent(EventConfigFileSet, "Uploaded %s to the device config", name)
+	return nil
+}
+
+// DeleteConfigFile removes a file from this device's config on the server.
+// The next check-in removes it from the secrets directory like any other
+// file dropped from the config.
+func (a *App) DeleteConfigFile(name string) error {
+	if err := validateFileName(name); err != nil {
+		return err
+	}
+	client, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return err
+	}
+	defer a.releaseCrypto(crypto)
+
+	fileUrl := strings.TrimSuffix(a.configUrl, "/") + "/" + url.PathEscape(name)
+	res, err := httpDelete(client, fileUrl)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s is not in the device config", name)
+	} else if res.StatusCode < 200 || res.StatusCode > 204 {
+		return fmt.Errorf("Unable to delete %s: %s - HTTP_%d: %s", name, fileUrl, res.StatusCode, res.String())
+	}
+	LogEvent(EventConfigFileDeleted, "Deleted %s from the device config", name)
+	return nil
+}
@@ -0,0 +1,80 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetConfigFile(t *testing.T) {
+	var ccr ConfigCreateRequest
+	status := http.StatusCreated
+	doGet := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		require.Nil(t, json.Unmarshal(data, &ccr))
+		w.WriteHeader(status)
+	}
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		cfgFile := NewConfigFile([]byte("gain=1.5"))
+		cfgFile.OnChanged = []string{"/usr/bin/calibrate"}
+		require.Nil(t, app.SetConfigFile("calibration", cfgFile, ""))
+		require.Equal(t, "Set calibration from fioconfig", ccr.Reason)
+		require.Len(t, ccr.Files, 1)
+		req := ccr.Files[0]
+		require.Equal(t, "calibration", req.Name)
+		require.False(t, req.Unencrypted)
+		require.Equal(t, []string{"/usr/bin/calibrate"}, req.OnChanged)
+		require.NotEqual(t, "gain=1.5", req.Value)
+
+		// It must be encrypted to the device's own key
+		_, crypto, err := app.loadClient(app.sota)
+		require.Nil(t, err)
+		defer app.releaseCrypto(crypto)
+		plain, err := crypto.Decrypt(req.Value)
+		require.Nil(t, err)
+		require.Equal(t, "gain=1.5", string(plain))
+
+		cfgFile = NewConfigFile([]byte("public"))
+		cfgFile.Unencrypted = true
+		require.Nil(t, app.SetConfigFile("public", cfgFile, "reason"))
+		require.Equal(t, "reason", ccr.Reason)
+		require.Equal(t, "public", ccr.Files[0].Value)
+
+		status = http.StatusForbidden
+		require.NotNil(t, app.SetConfigFile("public", cfgFile, ""))
+		require.NotNil(t, app.SetConfigFile("../escape", cfgFile, ""))
+	})
+}
+
+func TestDeleteConfigFile(t *testing.T) {
+	var deleted string
+	doGet := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.EscapedPath() == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		deleted = r.URL.EscapedPath()
+		w.WriteHeader(http.StatusNoContent)
+	}
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		require.Nil(t, app.DeleteConfigFile("with/subdir/1.txt"))
+		require.Equal(t, "/with%2Fsubdir%2F1.txt", deleted)
+
+		err := app.DeleteConfigFile("missing")
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "not in the device config")
+		require.NotNil(t, app.DeleteConfigFile("../escape"))
+	})
+}
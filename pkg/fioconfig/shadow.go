@@ -0,0 +1,74 @@
+package fioconfig
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// loadShadow reads the plaintext files under `shadow_dir` that override
+// the server's config. This is meant for iterating on a bench device
+// without pushing every change through the backend.
+func (a *App) loadShadow() (map[string][]byte, error) {
+	shadow := make(map[string][]byte)
+	root := a.settings.ShadowDir
+	if len(root) == 0 {
+		return shadow, nil
+	}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		shadow[filepath.ToSlash(name)] = content
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return shadow, nil
+	}
+	return shadow, err
+}
+
+// applyShadow overlays the shadow files onto config. A shadowed file keeps
+// the server's settings, like its on-changed handler, but takes its value
+// from the local file.
+func (a *App) applyShadow(config ConfigStruct, report *ExtractReport) (ConfigStruct, error) {
+	shadow, err := a.loadShadow()
+	if err != nil || len(shadow) == 0 {
+		return config, err
+	}
+	merged := make(ConfigStruct, len(config)+len(shadow))
+	for fname, cfgFile := range config {
+		merged[fname] = cfgFile
+	}
+	for fname, content := range shadow {
+		LogEvent(EventShadowOverride, "WARNING: Using local override of %s from %s", fname, a.settings.ShadowDir)
+		var cfgFile ConfigFile
+		if orig, ok := config[fname]; ok {
+			cfgFile = *orig
+		}
+		cfgFile.Value = string(content)
+		cfgFile.Encoding = ""
+		cfgFile.Unencrypted = true
+		if !utf8.Valid(content) {
+			cfgFile.Value = base64.StdEncoding.EncodeToString(content)
+			cfgFile.Encoding = EncodingBase64
+		}
+		merged[fname] = &cfgFile
+		report.Overridden = append(report.Overridden, fname)
+	}
+	return merged, nil
+}
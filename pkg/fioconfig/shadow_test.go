@@ -0,0 +1,38 @@
+package fioconfig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadowOverrides(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		shadow := t.TempDir()
+		require.Nil(t, os.WriteFile(filepath.Join(shadow, "bar"), []byte("local bar"), 0o644))
+		require.Nil(t, os.MkdirAll(filepath.Join(shadow, "dev"), 0o755))
+		require.Nil(t, os.WriteFile(filepath.Join(shadow, "dev/extra"), []byte{0xff, 0x00}, 0o644))
+		app.settings.ShadowDir = shadow
+
+		_, crypto, err := createClient(app.sota)
+
+		require.Nil(t, err)
+		defer crypto.Close()
+		config, err := UnmarshallFile(crypto, app.EncryptedConfig, true)
+		require.Nil(t, err)
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+
+		require.ElementsMatch(t, []string{"bar", "dev/extra"}, report.Overridden)
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo file value"))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("local bar"))
+		assertFile(t, filepath.Join(tempdir, "dev/extra"), []byte{0xff, 0x00})
+		// The server's handler still runs for the overridden file
+		assertFile(t, filepath.Join(tempdir, "bar-changed"), nil)
+		require.Equal(t, "bar file value", config["bar"].Value)
+	})
+}
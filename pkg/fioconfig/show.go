@@ -0,0 +1,73 @@
+package fioconfig
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ConfigEntry describes one file of the config the device last received
+type ConfigEntry struct {
+	Name        string
+	OnChanged   []string `json:",omitempty"`
+	Unencrypted bool     `json:",omitempty"`
+	Sha256      string
+	Size        int
+	// Value is "<redacted>" unless the plaintext was asked for
+	Value string `json:",omitempty"`
+}
+
+func newConfigEntry(name string, cfgFile *ConfigFile) ConfigEntry {
+	return ConfigEntry{
+		Name:        name,
+		OnChanged:   cfgFile.OnChanged,
+		Unencrypted: cfgFile.Unencrypted,
+		Sha256:      valueHash(cfgFile),
+		Size:        len(cfgFile.Value),
+	}
+}
+
+func (a *App) decryptedConfig() (ConfigStruct, error) {
+	_, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return nil, err
+	}
+	defer a.releaseCrypto(crypto)
+	return a.unmarshallCache(crypto, a.EncryptedConfig, true)
+}
+
+// ListConfig returns the files in the device's config sorted by name. Values
+// are left out.
+func (a *App) ListConfig() ([]ConfigEntry, error) {
+	config, err := a.decryptedConfig()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ConfigEntry, 0, len(config))
+	for name, cfgFile := range config {
+		entries = append(entries, newConfigEntry(name, cfgFile))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// ShowConfigFile decrypts the device's config and returns a single file from
+// it. The value is redacted unless `plain` is set, so callers must be careful
+// where they display it.
+func (a *App) ShowConfigFile(name string, plain bool) (*ConfigEntry, error) {
+	config, err := a.decryptedConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfgFile, ok := config[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the config", name)
+	}
+	entry := newConfigEntry(name, cfgFile)
+	entry.Value = redacted
+	if plain {
+		entry.Value = cfgFile.Value
+	}
+	return &entry, nil
+}
@@ -0,0 +1,38 @@
+package fioconfig
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListConfig(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		entries, err := app.ListConfig()
+		require.Nil(t, err)
+		require.Len(t, entries, 4)
+		require.Equal(t, "bar", entries[0].Name)
+		require.Len(t, entries[0].OnChanged, 2)
+		require.True(t, entries[0].Unencrypted)
+		require.Equal(t, "foo", entries[1].Name)
+		require.Equal(t, len("foo file value"), entries[1].Size)
+		require.Empty(t, entries[1].Value)
+	})
+}
+
+func TestShowConfigFile(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		entry, err := app.ShowConfigFile("foo", false)
+		require.Nil(t, err)
+		require.Equal(t, redacted, entry.Value)
+		require.Equal(t, valueHash(&ConfigFile{Value: "foo file value"}), entry.Sha256)
+
+		entry, err = app.ShowConfigFile("foo", true)
+		require.Nil(t, err)
+		require.Equal(t, "foo file value", entry.Value)
+
+		_, err = app.ShowConfigFile("missing", true)
+		require.NotNil(t, err)
+	})
+}
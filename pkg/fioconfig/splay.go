@@ -0,0 +1,58 @@
+package fioconfig
+
+import (
+	"hash/fnv"
+	"net/http"
+	"time"
+)
+
+// deviceId returns the common name of the device's client certificate
+func deviceId(client *http.Client) string {
+	if cert := clientCert(client); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// splayOffset deterministically maps a device ID to an offset within the
+// interval so a fleet's check-ins are spread evenly across it.
+func splayOffset(id string, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// nextSplayedCheckIn returns how long to wait until the device's next
+// check-in window. Windows are aligned to the wall clock rather than to
+// when the daemon started, so devices rebooted at the same time still check
+// in at different times.
+func nextSplayedCheckIn(offset, interval time.Duration, now time.Time) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	sinceWindow := time.Duration(now.UnixNano()) % interval
+	delay := (offset - sinceWindow + interval) % interval
+	if delay == 0 {
+		delay = interval
+	}
+	return delay
+}
+
+// CheckInOffset returns this device's offset within the check-in interval
+func (a *App) CheckInOffset(interval time.Duration) time.Duration {
+	client, _, err := a.getClient()
+	if err != nil {
+		logger.Printf("Unable to find device ID for check-in splay: %s", err)
+		return splayOffset("", interval)
+	}
+	return splayOffset(deviceId(client), interval)
+}
+
+// NextCheckIn returns how long to wait until the device's next check-in
+// window given its offset from CheckInOffset.
+func NextCheckIn(offset, interval time.Duration) time.Duration {
+	return nextSplayedCheckIn(offset, interval, time.Now())
+}
@@ -0,0 +1,33 @@
+package fioconfig
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplayOffset(t *testing.T) {
+	interval := 5 * time.Minute
+	require.Equal(t, splayOffset("device-1", interval), splayOffset("device-1", interval))
+
+	// A fleet should spread roughly evenly over the interval
+	buckets := make([]int, 10)
+	for i := 0; i < 10000; i++ {
+		offset := splayOffset(fmt.Sprintf("device-%d", i), interval)
+		require.Less(t, offset, interval)
+		buckets[offset*10/interval]++
+	}
+	for _, count := range buckets {
+		require.InDelta(t, 1000, count, 150)
+	}
+}
+
+func TestNextSplayedCheckIn(t *testing.T) {
+	interval := 5 * time.Minute
+	now := time.Unix(1000*300+60, 0) // 1 minute into a window
+	require.Equal(t, time.Minute, nextSplayedCheckIn(2*time.Minute, interval, now))
+	require.Equal(t, 4*time.Minute, nextSplayedCheckIn(0, interval, now))
+	require.Equal(t, interval, nextSplayedCheckIn(time.Minute, interval, now))
+}
@@ -0,0 +1,159 @@
+package fioconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Config files with SSHKeys set are a JSON object of local users and the
+// authorized keys they should have, e.g.
+// {"fio": ["ssh-ed25519 AAAA... alice@example.com"]}. fioconfig keeps the
+// keys of every such file between markers in each user's authorized_keys,
+// leaving keys added on the device alone, so a key removed from the config
+// is revoked on the next extraction.
+const (
+	sshKeysBegin = "# BEGIN fioconfig managed keys"
+	sshKeysEnd   = "# END fioconfig managed keys"
+)
+
+var lookupUser = user.Lookup
+
+// parseSSHKeys returns the keys of an SSHKeys file by user
+func parseSSHKeys(cfgFile *ConfigFile) (map[string][]string, error) {
+	if cfgFile == nil || !cfgFile.SSHKeys {
+		return nil, nil
+	}
+	content, err := cfgFile.content()
+	if err != nil {
+		return nil, err
+	}
+	var keys map[string][]string
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return nil, fmt.Errorf("Invalid SSH keys: %w", err)
+	}
+	for name, userKeys := range keys {
+		for _, key := range userKeys {
+			if strings.ContainsAny(key, "\r\n") {
+				return nil, fmt.Errorf("Invalid SSH key for %s: must be a single line", name)
+			}
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+				return nil, fmt.Errorf("Invalid SSH key for %s: %w", name, err)
+			}
+		}
+	}
+	return keys, nil
+}
+
+func validateSSHKeys(cfgFile *ConfigFile) error {
+	_, err := parseSSHKeys(cfgFile)
+	return err
+}
+
+// applySSHKeys updates the authorized_keys of the users in the SSHKeys
+// files that changed or were removed
+func (a *App) applySSHKeys(ctx context.Context, c *committedConfig) {
+	users := make(map[string][]string) // The files each user is updated for
+	for _, fname := range c.touched() {
+		next, _ := parseSSHKeys(c.next[fname]) // validateSSHKeys already checked it
+		prev, _ := parseSSHKeys(c.prev[fname])
+		for _, keys := range []map[string][]string{next, prev} {
+			for name := range keys {
+				users[name] = append(users[name], fname)
+			}
+		}
+	}
+	if len(users) == 0 {
+		return
+	}
+
+	// A user's keys can come from more than one file
+	wanted := make(map[string][]string)
+	for _, fname := range sortedNames(c.next) {
+		keys, _ := parseSSHKeys(c.next[fname])
+		for name, userKeys := range keys {
+			wanted[name] = append(wanted[name], userKeys...)
+		}
+	}
+
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		LogEvent(EventSSHKeysUpdated, "Updating SSH authorized keys of %s", name)
+		if err := writeAuthorizedKeys(name, wanted[name]); err != nil {
+			err = fmt.Errorf("Unable to update SSH keys of %s: %w", name, err)
+			LogEvent(EventHandlerFailed, "%s", err)
+			for _, fname := range users[name] {
+				c.report.fail(fname, err)
+			}
+		}
+	}
+}
+
+// writeAuthorizedKeys replaces the managed keys in a user's authorized_keys
+func writeAuthorizedKeys(name string, keys []string) error {
+	u, err := lookupUser(name)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(u.HomeDir, ".ssh")
+	path := filepath.Join(dir, "authorized_keys")
+
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var lines []string
+	managed := false
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		switch {
+		case line == sshKeysBegin:
+			managed = true
+		case line == sshKeysEnd:
+			managed = false
+		case !managed && len(line) > 0:
+			lines = append(lines, line)
+		}
+	}
+	if len(keys) > 0 {
+		lines = append(lines, sshKeysBegin)
+		lines = append(lines, keys...)
+		lines = append(lines, sshKeysEnd)
+	}
+	updated := ""
+	if len(lines) > 0 {
+		updated = strings.Join(lines, "\n") + "\n"
+	}
+	if updated == string(content) {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	// sshd refuses keys others can write to
+	if err := (fileMeta{0o700, uid, gid}).apply(dir); err != nil {
+		return err
+	}
+	return safeWriteMeta(path, []byte(updated), fileMeta{0o600, uid, gid})
+}
@@ -0,0 +1,96 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newAuthorizedKey(t *testing.T, comment string) string {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.Nil(t, err)
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment
+}
+
+func TestSSHKeys(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		orig := lookupUser
+		lookupUser = func(name string) (*user.User, error) {
+			if name != "fio" && name != "root" {
+				return nil, user.UnknownUserError(name)
+			}
+			uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+			return &user.User{Username: name, Uid: uid, Gid: gid, HomeDir: filepath.Join(tempdir, "home", name)}, nil
+		}
+		defer func() { lookupUser = orig }()
+
+		alice, bob, local := newAuthorizedKey(t, "alice"), newAuthorizedKey(t, "bob"), newAuthorizedKey(t, "local")
+		authorized := filepath.Join(tempdir, "home", "fio", ".ssh", "authorized_keys")
+		require.Nil(t, os.MkdirAll(filepath.Dir(authorized), 0o755))
+		require.Nil(t, os.WriteFile(authorized, []byte(local+"\n"), 0o644))
+
+		keysFile := func(keys map[string][]string) *ConfigFile {
+			buf, err := json.Marshal(keys)
+			require.Nil(t, err)
+			return &ConfigFile{Value: string(buf), SSHKeys: true}
+		}
+		config := ConfigStruct{
+			"ssh/team":  keysFile(map[string][]string{"fio": {alice, bob}}),
+			"ssh/admin": keysFile(map[string][]string{"root": {alice}}),
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertFile(t, authorized, []byte(local+"\n"+sshKeysBegin+"\n"+alice+"\n"+bob+"\n"+sshKeysEnd+"\n"))
+		st, err := os.Stat(authorized)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+		st, err = os.Stat(filepath.Dir(authorized))
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0o700), st.Mode().Perm())
+		assertFile(t, filepath.Join(tempdir, "home", "root", ".ssh", "authorized_keys"),
+			[]byte(sshKeysBegin+"\n"+alice+"\n"+sshKeysEnd+"\n"))
+
+		// Revoking a key removes it and leaves local keys alone
+		next := ConfigStruct{
+			"ssh/team":  keysFile(map[string][]string{"fio": {bob}}),
+			"ssh/admin": config["ssh/admin"],
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{config, next})
+		require.Nil(t, err)
+		assertFile(t, authorized, []byte(local+"\n"+sshKeysBegin+"\n"+bob+"\n"+sshKeysEnd+"\n"))
+
+		// Removing the file removes its users' managed keys
+		last := ConfigStruct{"ssh/admin": config["ssh/admin"]}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{next, last})
+		require.Nil(t, err)
+		assertFile(t, authorized, []byte(local+"\n"))
+
+		report, err := app.extract(context.Background(), crypto, configSnapshot{last, ConfigStruct{
+			"ssh/admin": config["ssh/admin"],
+			"ssh/ghost": keysFile(map[string][]string{"ghost": {alice}}),
+		}})
+		require.Nil(t, err)
+		require.Contains(t, report.Failed["ssh/ghost"], "unknown user ghost")
+
+		bad := ConfigStruct{"ssh/bad": keysFile(map[string][]string{"fio": {"not a key"}})}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, bad})
+		require.NotNil(t, err)
+	})
+}
@@ -0,0 +1,109 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// At boot the daemon can start before the network is up or before NTP has
+// set the clock, and its first check-ins then fail with connection or
+// certificate errors that look much worse than they are. WaitForStartup
+// holds the first check-in until the server, or the proxy in front of it,
+// accepts connections and the clock is past the start of the client
+// certificate's validity.
+
+// startupPollInterval is how long WaitForStartup sleeps between checks
+var startupPollInterval = 2 * time.Second
+
+// startupReady returns why the device isn't ready to check in yet, or nil
+func (a *App) startupReady(ctx context.Context, client *http.Client, now time.Time) error {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	if certs := transport.TLSClientConfig.Certificates; len(certs) > 0 && len(certs[0].Certificate) > 0 {
+		cert, err := x509.ParseCertificate(certs[0].Certificate[0])
+		if err != nil {
+			return fmt.Errorf("Unable to parse client certificate: %w", err)
+		}
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("System time %s is before the client certificate is valid, waiting for NTP",
+				now.UTC().Format(time.RFC3339))
+		}
+	}
+
+	target, err := url.Parse(a.configUrl)
+	if err != nil {
+		return err
+	}
+	if transport.Proxy != nil {
+		proxy, err := transport.Proxy(&http.Request{URL: target})
+		if err != nil {
+			return err
+		}
+		if proxy != nil {
+			target = proxy
+		}
+	}
+	port := target.Port()
+	if len(port) == 0 {
+		port = map[string]string{"http": "80", "https": "443", "socks5": "1080"}[target.Scheme]
+	}
+	addr := net.JoinHostPort(target.Hostname(), port)
+	dial := (&net.Dialer{}).DialContext
+	if transport.DialContext != nil {
+		dial = transport.DialContext
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := dial(dialCtx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to %s: %w", addr, err)
+	}
+	return conn.Close()
+}
+
+// WaitForStartup blocks until the device looks able to check in, or until
+// the startup_wait setting expires. Giving up isn't an error since the
+// check-ins that follow will retry anyway. Errors are ctx's, an invalid
+// startup_wait, or a client that can't be created from sota.toml.
+func (a *App) WaitForStartup(ctx context.Context) error {
+	if len(a.settings.StartupWait) == 0 {
+		return nil
+	}
+	timeout, err := time.ParseDuration(a.settings.StartupWait)
+	if err != nil {
+		return fmt.Errorf("Invalid fioconfig.startup_wait: %w", err)
+	}
+	client, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return err
+	}
+	a.releaseCrypto(crypto)
+
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		err := a.startupReady(ctx, client, time.Now())
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !time.Now().Before(deadline) {
+			LogEvent(EventStartupWaitExpired, "Checking in anyway after waiting %s for startup: %s", timeout, err)
+			return nil
+		}
+		LogEvent(EventWaitingForStartup, "Not ready to check in (attempt %d): %s", attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(startupPollInterval):
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package fioconfig
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForStartup(t *testing.T) {
+	testWrapper(t, nil, func(app *App, _ *http.Client, tempdir string) {
+		client, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		crypto.Close()
+
+		require.Nil(t, app.startupReady(context.Background(), client, time.Now()))
+
+		err = app.startupReady(context.Background(), client, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC))
+		require.ErrorContains(t, err, "waiting for NTP")
+
+		// A port nothing listens on
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.Nil(t, err)
+		l.Close()
+		orig := app.configUrl
+		app.configUrl = "https://" + l.Addr().String() + "/config"
+		err = app.startupReady(context.Background(), client, time.Now())
+		require.ErrorContains(t, err, "Unable to connect")
+
+		origInterval := startupPollInterval
+		startupPollInterval = 10 * time.Millisecond
+		defer func() { startupPollInterval = origInterval }()
+		app.settings.StartupWait = "50ms"
+		start := time.Now()
+		require.Nil(t, app.WaitForStartup(context.Background()))
+		require.True(t, time.Since(start) >= 50*time.Millisecond)
+
+		app.configUrl = orig
+		app.settings.StartupWait = "1m"
+		require.Nil(t, app.WaitForStartup(context.Background()))
+	})
+}
@@ -0,0 +1,51 @@
+package fioconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Images with a read-only root filesystem can't keep fioconfig's state next
+// to sota.toml. state_dir moves the files fioconfig writes as it runs, like
+// the manifest and config history, encrypted_config moves the config cache,
+// and tmp_dir moves the temporary files new content is written to before
+// it's renamed into place. Renames from tmp_dir onto another filesystem
+// fall back to a synced copy next to the destination, see safeWriteMeta.
+
+// stateDir is where fioconfig keeps its runtime state
+func (a *App) stateDir() string {
+	if len(a.settings.StateDir) > 0 {
+		return a.settings.StateDir
+	}
+	return a.sotaConfig
+}
+
+// createStateDirs creates the directories settings has in place of the
+// sota.toml directory
+func createStateDirs(settings Settings) error {
+	dirs := []string{settings.StateDir, settings.TmpDir}
+	if len(settings.EncryptedConfig) > 0 {
+		dirs = append(dirs, filepath.Dir(settings.EncryptedConfig))
+	}
+	for _, dir := range dirs {
+		if len(dir) == 0 {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("Unable to create %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// setEncryptedConfig moves the config cache to where settings, about to
+// replace a.settings, put it
+func (a *App) setEncryptedConfig(settings Settings) {
+	if len(settings.EncryptedConfig) > 0 {
+		a.EncryptedConfig = settings.EncryptedConfig
+	} else if len(a.settings.EncryptedConfig) > 0 && a.EncryptedConfig == a.settings.EncryptedConfig {
+		// encrypted_config was removed
+		a.EncryptedConfig = filepath.Join(a.sotaConfig, "config.encrypted")
+	}
+}
@@ -0,0 +1,85 @@
+package fioconfig
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatePaths(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		t.Cleanup(func() { require.Nil(t, setWriteOptions(Settings{})) })
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		data := filepath.Join(tempdir, "data")
+		sotaFile := filepath.Join(tempdir, "sota.toml")
+		orig, err := os.ReadFile(sotaFile)
+		require.Nil(t, err)
+		writeSota := func(settings string) {
+			require.Nil(t, os.WriteFile(sotaFile, append(append([]byte{}, orig...), settings...), 0o644))
+		}
+
+		// Nothing changes when a directory can't be created
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "file"), nil, 0o644))
+		writeSota(fmt.Sprintf("[fioconfig]\nstate_dir = \"%s/file/state\"\ntmp_dir = \"%s/tmp\"\n", tempdir, data))
+		require.NotNil(t, app.Reload())
+		require.Empty(t, app.settings.StateDir)
+		require.Empty(t, getWriteOptions().tmpDir)
+
+		writeSota(fmt.Sprintf("[fioconfig]\nstate_dir = \"%s/state\"\nencrypted_config = \"%s/cache/config.encrypted\"\ntmp_dir = \"%s/tmp\"\n", data, data, data))
+		require.Nil(t, app.Reload())
+		require.Equal(t, filepath.Join(data, "cache", "config.encrypted"), app.EncryptedConfig)
+		require.Equal(t, filepath.Join(data, "tmp"), getWriteOptions().tmpDir)
+
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, ConfigStruct{"foo": {Value: "foo"}}})
+		require.Nil(t, err)
+		_, err = os.Stat(filepath.Join(data, "state", "manifest.json"))
+		require.Nil(t, err)
+		assertNoFile(t, filepath.Join(tempdir, "manifest.json"))
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("foo"))
+		entries, err := os.ReadDir(filepath.Join(data, "tmp"))
+		require.Nil(t, err)
+		require.Empty(t, entries)
+
+		// The cache goes back next to sota.toml when encrypted_config is removed
+		writeSota("")
+		require.Nil(t, app.Reload())
+		require.Equal(t, filepath.Join(tempdir, "config.encrypted"), app.EncryptedConfig)
+		require.Empty(t, getWriteOptions().tmpDir)
+	})
+}
+
+func TestSafeWriteAcrossFilesystems(t *testing.T) {
+	t.Cleanup(func() { require.Nil(t, setWriteOptions(Settings{})) })
+	dest := t.TempDir()
+	tmpDir, err := os.MkdirTemp("/dev/shm", "fioconfig-test")
+	if err != nil {
+		t.Skip("No tmpfs at /dev/shm")
+	}
+	defer os.RemoveAll(tmpDir)
+	var destSt, tmpSt syscall.Stat_t
+	require.Nil(t, syscall.Stat(dest, &destSt))
+	require.Nil(t, syscall.Stat(tmpDir, &tmpSt))
+	if destSt.Dev == tmpSt.Dev {
+		t.Skip("/dev/shm is on the same filesystem as the test directory")
+	}
+
+	require.Nil(t, setWriteOptions(Settings{TmpDir: tmpDir}))
+	path := filepath.Join(dest, "file")
+	require.True(t, strings.HasPrefix(getWriteOptions().tmpPath(path), tmpDir))
+	require.Nil(t, safeWrite(path, []byte("moved")))
+	assertFile(t, path, []byte("moved"))
+	assertNoFile(t, path+".tmp")
+	entries, err := os.ReadDir(tmpDir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,106 @@
+package fioconfig
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ChangeType identifies what a ChangeEvent is about
+type ChangeType string
+
+const (
+	ChangeFileAdded        ChangeType = "file-added"
+	ChangeFileChanged      ChangeType = "file-changed"
+	ChangeFileRemoved      ChangeType = "file-removed"
+	ChangeCheckInStarted   ChangeType = "check-in-started"
+	ChangeCheckInSucceeded ChangeType = "check-in-succeeded"
+	ChangeCheckInFailed    ChangeType = "check-in-failed"
+	ChangeConfigApplied    ChangeType = "config-applied"
+	ChangeExtractFailed    ChangeType = "extract-failed"
+	ChangeHandlerRun       ChangeType = "handler-run"
+	ChangeCertRenewed      ChangeType = "cert-renewed"
+)
+
+// ChangeEvent is sent to the App's subscribers. File events are sent once
+// the new config is in place and its on-changed handlers have run.
+type ChangeEvent struct {
+	Type ChangeType
+	Time time.Time
+	File string // Set for file events
+	Err  error  // Why a check-in or extraction failed
+	// The config version applied and the files it changed
+	Version int
+	Files   []string
+	// Set for handler events, whose File is empty for after-extract
+	Handler *HandlerResult
+}
+
+type subscribers struct {
+	lock sync.Mutex
+	next int
+	subs map[int]func(ChangeEvent)
+}
+
+// Subscribe registers a callback for the App's change events so programs
+// embedding fioconfig can react in-process rather than with on-changed
+// commands. Callbacks run in the goroutine doing the check-in, so they
+// should return quickly. The returned function unsubscribes.
+func (a *App) Subscribe(cb func(ChangeEvent)) func() {
+	s := &a.subscribers
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.subs == nil {
+		s.subs = make(map[int]func(ChangeEvent))
+	}
+	id := s.next
+	s.next++
+	s.subs[id] = cb
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.subs, id)
+	}
+}
+
+// SubscribeChan is Subscribe for a channel. Events are dropped rather than
+// holding up a check-in when the channel is full.
+func (a *App) SubscribeChan(ch chan<- ChangeEvent) func() {
+	return a.Subscribe(func(event ChangeEvent) {
+		select {
+		case ch <- event:
+		default:
+		}
+	})
+}
+
+// publishExtract sends ChangeConfigApplied when an extraction changed files
+// or ChangeExtractFailed when it failed
+func (a *App) publishExtract(report *ExtractReport, err error, version int) {
+	if err != nil {
+		a.publish(ChangeEvent{Type: ChangeExtractFailed, Err: err, Version: version})
+	} else if report != nil && len(report.Applied)+len(report.Removed) > 0 {
+		files := append(append([]string{}, report.Applied...), report.Removed...)
+		a.publish(ChangeEvent{Type: ChangeConfigApplied, Version: version, Files: files})
+	}
+}
+
+func (a *App) publish(event ChangeEvent) {
+	event.Time = time.Now()
+	s := &a.subscribers
+	s.lock.Lock()
+	ids := make([]int, 0, len(s.subs))
+	for id := range s.subs {
+		ids = append(ids, id)
+	}
+	cbs := make([]func(ChangeEvent), 0, len(ids))
+	sort.Ints(ids)
+	for _, id := range ids {
+		cbs = append(cbs, s.subs[id])
+	}
+	s.lock.Unlock()
+	// Called without the lock so callbacks can unsubscribe
+	for _, cb := range cbs {
+		cb(event)
+	}
+}
@@ -0,0 +1,83 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	status := http.StatusNotModified
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		var events []ChangeEvent
+		unsubscribe := app.Subscribe(func(event ChangeEvent) {
+			events = append(events, event)
+		})
+		ch := make(chan ChangeEvent, 1)
+		defer app.SubscribeChan(ch)()
+
+		require.Nil(t, app.Extract())
+		// bar's handler runs before the file events are sent
+		require.Equal(t, ChangeHandlerRun, events[0].Type)
+		require.Equal(t, "bar", events[0].File)
+		require.Equal(t, 0, events[0].Handler.ExitCode)
+		var added []string
+		for _, event := range events[1 : len(events)-1] {
+			require.Equal(t, ChangeFileAdded, event.Type)
+			added = append(added, event.File)
+		}
+		require.ElementsMatch(t, []string{"bar", "foo", "random", "with/subdir/1.txt"}, added)
+		// Followed by the config as a whole
+		applied := events[len(events)-1]
+		require.Equal(t, ChangeConfigApplied, applied.Type)
+		require.ElementsMatch(t, added, applied.Files)
+		// A full channel doesn't block
+		require.Equal(t, ChangeHandlerRun, (<-ch).Type)
+
+		buf, err := os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		var config map[string]*ConfigFile
+		require.Nil(t, json.Unmarshal(buf, &config))
+		config["bar"].Value = "new bar value"
+		buf, err = json.Marshal(config)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(app.EncryptedConfig, buf, 0o644))
+		events = nil
+		require.Nil(t, app.Extract())
+		require.Len(t, events, 3)
+		require.Equal(t, ChangeHandlerRun, events[0].Type)
+		require.Equal(t, ChangeEvent{Type: ChangeFileChanged, File: "bar", Time: events[1].Time}, events[1])
+		require.Equal(t, ChangeEvent{Type: ChangeConfigApplied, Files: []string{"bar"}, Time: events[2].Time}, events[2])
+		<-ch
+
+		events = nil
+		require.Equal(t, NotModifiedError, app.CheckIn())
+		require.Len(t, events, 2)
+		require.Equal(t, ChangeCheckInStarted, events[0].Type)
+		require.Equal(t, ChangeCheckInSucceeded, events[1].Type)
+		<-ch
+
+		status = http.StatusNotFound
+		events = nil
+		err = app.CheckIn()
+		require.Len(t, events, 2)
+		require.Equal(t, ChangeCheckInFailed, events[1].Type)
+		require.True(t, errors.Is(events[1].Err, DeviceNotFoundError))
+		require.Equal(t, err, events[1].Err)
+		<-ch
+
+		unsubscribe()
+		events = nil
+		_ = app.CheckIn()
+		require.Empty(t, events)
+		require.Equal(t, ChangeCheckInStarted, (<-ch).Type)
+	})
+}
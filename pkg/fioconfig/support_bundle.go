@@ -0,0 +1,130 @@
+package fioconfig
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+const redacted = "<redacted>"
+
+// WriteSupportBundle writes a gzip'd tarball to `w` containing the
+// information support teams need to debug a device. Config values and key
+// material are never included: files are listed by their sha256 and sota.toml
+// is sanitized.
+func (a *App) WriteSupportBundle(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	sota, err := sanitizeSota(a.sota)
+	if err != nil {
+		return err
+	}
+	files := []struct {
+		name    string
+		content func() ([]byte, error)
+	}{
+		{"version.txt", func() ([]byte, error) { return []byte(Commit + "\n"), nil }},
+		{"sota.toml", func() ([]byte, error) { return sota, nil }},
+		{"secrets.sha256", a.secretsManifest},
+		{"checkin.state", func() ([]byte, error) { return readOptional(a.checkInStateFile()) }},
+		{"connectivity.txt", a.connectivityReport},
+	}
+	now := time.Now()
+	for _, f := range files {
+		content, err := f.content()
+		if err != nil {
+			content = []byte(fmt.Sprintf("ERROR: %s\n", err))
+		}
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(content)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// sanitizeSota returns a copy of sota.toml with anything that looks like a
+// credential removed.
+func sanitizeSota(sota *toml.Tree) ([]byte, error) {
+	clean, err := toml.LoadBytes([]byte(sota.String()))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range tomlLeafKeys(clean, "") {
+		parts := strings.Split(key, ".")
+		name := strings.ToLower(parts[len(parts)-1])
+		for _, word := range []string{"pass", "pin", "secret", "token"} {
+			if strings.Contains(name, word) {
+				clean.Set(key, redacted)
+				break
+			}
+		}
+	}
+	return clean.Marshal()
+}
+
+func tomlLeafKeys(tree *toml.Tree, prefix string) []string {
+	var keys []string
+	for _, key := range tree.Keys() {
+		if sub, ok := tree.Get(key).(*toml.Tree); ok {
+			keys = append(keys, tomlLeafKeys(sub, prefix+key+".")...)
+		} else {
+			keys = append(keys, prefix+key)
+		}
+	}
+	return keys
+}
+
+// secretsManifest lists the sha256 of every file under the secrets
+// directory in the format used by sha256sum.
+func (a *App) secretsManifest() ([]byte, error) {
+	var buf strings.Builder
+	err := filepath.WalkDir(a.SecretsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(a.SecretsDir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%x  %s\n", sha256.Sum256(content), rel)
+		return nil
+	})
+	return []byte(buf.String()), err
+}
+
+func (a *App) connectivityReport() ([]byte, error) {
+	var buf strings.Builder
+	for _, result := range a.Diagnose() {
+		fmt.Fprintln(&buf, result)
+	}
+	return []byte(buf.String()), nil
+}
+
+func readOptional(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	return content, err
+}
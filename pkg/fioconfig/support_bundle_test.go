@@ -0,0 +1,44 @@
+package fioconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportBundle(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		require.Nil(t, app.Extract())
+		app.sota.Set("p11.pass", "1234-dont-leak")
+
+		var buf bytes.Buffer
+		require.Nil(t, app.WriteSupportBundle(&buf))
+
+		gz, err := gzip.NewReader(&buf)
+		require.Nil(t, err)
+		tr := tar.NewReader(gz)
+		found := make(map[string]string)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.Nil(t, err)
+			content, err := io.ReadAll(tr)
+			require.Nil(t, err)
+			found[hdr.Name] = string(content)
+		}
+
+		require.NotContains(t, found["sota.toml"], "1234-dont-leak")
+		require.Contains(t, found["sota.toml"], redacted)
+		require.Contains(t, found["secrets.sha256"], "  foo\n")
+		require.Contains(t, found["secrets.sha256"], "  with/subdir/1.txt\n")
+		require.NotContains(t, found["secrets.sha256"], "foo file value")
+		require.Contains(t, found["connectivity.txt"], "[PASS] HTTP GET")
+	})
+}
@@ -0,0 +1,77 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A file with Symlink set is a symbolic link to its Value, so a config can
+// switch a "current" pointer like cert.pem -> cert-2024.pem in one step.
+// The link is replaced atomically like any other file and its handler runs
+// when the target changes. Links must stay within the secrets directory
+// and, since they have no content of their own, can't be installed
+// anywhere else.
+
+// validateSymlink checks a symlink entry before any file is written
+func (a *App) validateSymlink(fname string, cfgFile *ConfigFile) error {
+	if !cfgFile.Symlink {
+		return nil
+	}
+	if a.store != nil {
+		return errors.New("Symlinks are only supported by the filesystem secret store")
+	}
+	target := cfgFile.Value
+	if len(target) == 0 || filepath.IsAbs(target) || filepath.Clean(target) != target {
+		return fmt.Errorf("Invalid symlink target %q: must be a clean relative path", target)
+	}
+	resolved := filepath.Join(filepath.Dir(fname), target)
+	if resolved == "." || resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("Symlink target %s is outside of the secrets directory", target)
+	} else if resolved == fname {
+		return errors.New("Symlink points to itself")
+	}
+	switch {
+	case cfgFile.Encoding != "":
+		return errors.New("Symlinks can't be encoded")
+	case len(cfgFile.Mode) > 0 || cfgFile.Uid != nil || cfgFile.Gid != nil:
+		return errors.New("Symlinks have no permissions of their own")
+	case len(cfgFile.TargetPath) > 0, len(cfgFile.Credential) > 0, len(cfgFile.EnvFile) > 0:
+		return errors.New("Symlinks can only be installed in the secrets directory")
+	case cfgFile.NetworkManager, cfgFile.UbootEnv, cfgFile.SSHKeys:
+		return errors.New("Symlinks have no content to apply")
+	}
+	return nil
+}
+
+// stageSymlink stages the link fname should be, unless it already is
+func (t *extractTxn) stageSymlink(i int, fname string, cfgFile *ConfigFile) (bool, error) {
+	if cur, err := os.Readlink(filepath.Join(t.secretsDir, fname)); err == nil && cur == cfgFile.Value {
+		return false, nil
+	}
+	staged := t.stagedPath()
+	if err := os.Symlink(cfgFile.Value, staged); err != nil {
+		return false, err
+	}
+	t.add(i, &txnOp{fname: fname, staged: staged})
+	return true, nil
+}
+
+// readLinkOrFile returns the target of path if it's a symlink, and its
+// content otherwise
+func readLinkOrFile(path string) ([]byte, error) {
+	if target, err := os.Readlink(path); err == nil {
+		return []byte(target), nil
+	}
+	return os.ReadFile(path)
+}
+
+// readManaged returns what fioconfig wrote to fname
+func (a *App) readManaged(fname string) ([]byte, error) {
+	if a.store == nil {
+		return readLinkOrFile(filepath.Join(a.SecretsDir, fname))
+	}
+	return a.secretStore().Read(fname)
+}
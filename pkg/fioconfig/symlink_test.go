@@ -0,0 +1,81 @@
+package fioconfig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymlinks(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		changed := filepath.Join(tempdir, "changed")
+		onChanged := []string{"/bin/sh", "-c", `echo "$CONFIG_FILE_PREV" > ` + changed}
+		config := ConfigStruct{
+			"cert-2024.pem": {Value: "2024"},
+			"cert-2025.pem": {Value: "2025"},
+			"cert.pem":      {Value: "cert-2024.pem", Symlink: true, OnChanged: onChanged},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Contains(t, report.Applied, "cert.pem")
+		target, err := os.Readlink(filepath.Join(tempdir, "cert.pem"))
+		require.Nil(t, err)
+		require.Equal(t, "cert-2024.pem", target)
+		assertFile(t, filepath.Join(tempdir, "cert.pem"), []byte("2024"))
+		cur, err := app.readManaged("cert.pem")
+		require.Nil(t, err)
+		require.Equal(t, "cert-2024.pem", string(cur))
+
+		// An unchanged link isn't applied again
+		require.Nil(t, os.Remove(changed))
+		report, err = app.extract(context.Background(), crypto, configSnapshot{config, config})
+		require.Nil(t, err)
+		require.NotContains(t, report.Applied, "cert.pem")
+		assertNoFile(t, changed)
+
+		// Switching the target replaces the link
+		next := ConfigStruct{
+			"cert-2024.pem": config["cert-2024.pem"],
+			"cert-2025.pem": config["cert-2025.pem"],
+			"cert.pem":      {Value: "cert-2025.pem", Symlink: true, OnChanged: onChanged},
+		}
+		report, err = app.extract(context.Background(), crypto, configSnapshot{config, next})
+		require.Nil(t, err)
+		require.Contains(t, report.Applied, "cert.pem")
+		assertFile(t, filepath.Join(tempdir, "cert.pem"), []byte("2025"))
+		_, err = os.Stat(changed)
+		require.Nil(t, err)
+
+		// A regular file can replace a link
+		plain := ConfigStruct{"cert.pem": {Value: "plain"}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{next, plain})
+		require.Nil(t, err)
+		fi, err := os.Lstat(filepath.Join(tempdir, "cert.pem"))
+		require.Nil(t, err)
+		require.True(t, fi.Mode().IsRegular())
+		assertFile(t, filepath.Join(tempdir, "cert.pem"), []byte("plain"))
+
+		invalid := []*ConfigFile{
+			{Value: "../outside", Symlink: true},
+			{Value: "/etc/passwd", Symlink: true},
+			{Value: "link", Symlink: true},
+			{Value: "cert.pem", Symlink: true, Mode: "0600"},
+			{Value: "cert.pem", Symlink: true, TargetPath: "/etc/cert.pem"},
+		}
+		for _, cfgFile := range invalid {
+			bad := ConfigStruct{"cert.pem": plain["cert.pem"], "link": cfgFile}
+			_, err = app.extract(context.Background(), crypto, configSnapshot{plain, bad})
+			require.NotNil(t, err, cfgFile)
+			_, err = os.Lstat(filepath.Join(tempdir, "link"))
+			require.True(t, os.IsNotExist(err))
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Well-known config files that personalize the device without an
+// on-changed script. Their values are applied with hostnamectl,
+// timedatectl, and a systemd-timesyncd drop-in.
+const (
+	hostnameConfigFile   = "fio-hostname"
+	timezoneConfigFile   = "fio-timezone"
+	ntpServersConfigFile = "fio-ntp-servers"
+)
+
+// timesyncdDropIn is where fio-ntp-servers is written for systemd-timesyncd
+var timesyncdDropIn = "/etc/systemd/timesyncd.conf.d/fioconfig.conf"
+
+// runSystemCommand runs hostnamectl, timedatectl, or systemctl
+var runSystemCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+func validHostname(name string) bool {
+	if len(name) == 0 || len(name) > 64 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateSystemSetting checks the value of a well-known file before any
+// file is written
+func validateSystemSetting(fname string, cfgFile *ConfigFile) error {
+	if fname != hostnameConfigFile && fname != timezoneConfigFile && fname != ntpServersConfigFile {
+		return nil
+	}
+	content, err := cfgFile.content()
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(string(content))
+	switch fname {
+	case hostnameConfigFile:
+		if !validHostname(value) {
+			return fmt.Errorf("Invalid hostname %q", value)
+		}
+	case timezoneConfigFile:
+		if len(value) == 0 || strings.HasPrefix(value, "/") || strings.Contains(value, "..") || strings.ContainsAny(value, " \t\n") {
+			return fmt.Errorf("Invalid timezone %q", value)
+		}
+	case ntpServersConfigFile:
+		for _, server := range ntpServers(value) {
+			if strings.ContainsAny(server, "[]=#") {
+				return fmt.Errorf("Invalid NTP server %q", server)
+			}
+		}
+	}
+	return nil
+}
+
+// ntpServers splits fio-ntp-servers on whitespace and commas
+func ntpServers(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// applySystemSettings applies the well-known files that changed. Removing
+// fio-ntp-servers goes back to the image's NTP servers, while the hostname
+// and timezone are left as they are since there's nothing to go back to.
+func (a *App) applySystemSettings(ctx context.Context, c *committedConfig) {
+	run := func(fname, name string, args ...string) {
+		if out, err := runSystemCommand(ctx, name, args...); err != nil {
+			err = fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(string(out)))
+			LogEvent(EventHandlerFailed, "Unable to apply %s: %s", fname, err)
+			c.report.fail(fname, err)
+		}
+	}
+	for _, fname := range c.changed {
+		if fname != hostnameConfigFile && fname != timezoneConfigFile && fname != ntpServersConfigFile {
+			continue
+		}
+		content, _ := c.next[fname].content() // validateSystemSetting already checked it
+		value := strings.TrimSpace(string(content))
+		LogEvent(EventSystemSettingApplied, "Applying %s: %s", fname, value)
+		switch fname {
+		case hostnameConfigFile:
+			run(fname, "hostnamectl", "set-hostname", value)
+		case timezoneConfigFile:
+			run(fname, "timedatectl", "set-timezone", value)
+		case ntpServersConfigFile:
+			dropIn := "[Time]\nNTP=" + strings.Join(ntpServers(value), " ") + "\n"
+			err := os.MkdirAll(filepath.Dir(timesyncdDropIn), 0o755)
+			if err == nil {
+				err = safeWriteMeta(timesyncdDropIn, []byte(dropIn), fileMeta{0o644, -1, -1})
+			}
+			if err != nil {
+				c.report.fail(fname, fmt.Errorf("Unable to write %s: %w", timesyncdDropIn, err))
+				continue
+			}
+			run(fname, "systemctl", "try-restart", "systemd-timesyncd.service")
+		}
+	}
+	for _, fname := range c.removed {
+		if fname != ntpServersConfigFile {
+			continue
+		}
+		LogEvent(EventSystemSettingApplied, "Removing %s", timesyncdDropIn)
+		if err := os.Remove(timesyncdDropIn); err != nil && !errors.Is(err, os.ErrNotExist) {
+			c.report.fail(fname, err)
+			continue
+		}
+		run(fname, "systemctl", "try-restart", "systemd-timesyncd.service")
+	}
+}
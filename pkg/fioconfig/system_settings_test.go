@@ -0,0 +1,67 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemSettings(t *testing.T) {
+	var commands []string
+	origRun, origDropIn := runSystemCommand, timesyncdDropIn
+	runSystemCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		cmd := name + " " + strings.Join(args, " ")
+		commands = append(commands, cmd)
+		if strings.HasSuffix(cmd, "Mars/Olympus_Mons") {
+			return []byte("Invalid or not installed time zone"), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+	defer func() { runSystemCommand, timesyncdDropIn = origRun, origDropIn }()
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+		timesyncdDropIn = filepath.Join(tempdir, "timesyncd.conf.d", "fioconfig.conf")
+
+		config := ConfigStruct{
+			hostnameConfigFile:   &ConfigFile{Value: "kiosk-42\n"},
+			timezoneConfigFile:   &ConfigFile{Value: "Europe/Lisbon"},
+			ntpServersConfigFile: &ConfigFile{Value: "0.pool.ntp.org, 1.pool.ntp.org"},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Equal(t, []string{
+			"hostnamectl set-hostname kiosk-42",
+			"systemctl try-restart systemd-timesyncd.service",
+			"timedatectl set-timezone Europe/Lisbon",
+		}, commands)
+		assertFile(t, timesyncdDropIn, []byte("[Time]\nNTP=0.pool.ntp.org 1.pool.ntp.org\n"))
+
+		commands = nil
+		next := ConfigStruct{
+			hostnameConfigFile: config[hostnameConfigFile],
+			timezoneConfigFile: &ConfigFile{Value: "Mars/Olympus_Mons"},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{config, next})
+		require.Nil(t, err)
+		require.Equal(t, []string{
+			"timedatectl set-timezone Mars/Olympus_Mons",
+			"systemctl try-restart systemd-timesyncd.service",
+		}, commands)
+		require.Contains(t, report.Failed[timezoneConfigFile], "Invalid or not installed time zone")
+		_, err = os.Stat(timesyncdDropIn)
+		require.True(t, errors.Is(err, os.ErrNotExist))
+
+		bad := ConfigStruct{hostnameConfigFile: &ConfigFile{Value: "-not_valid"}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, bad})
+		require.NotNil(t, err)
+	})
+}
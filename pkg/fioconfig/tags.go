@@ -0,0 +1,42 @@
+package fioconfig
+
+import (
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// The server can give devices a different config depending on the tag they
+// follow, e.g. "devel" or "production". Check-ins send the device's tags
+// and hardware ID the way aktualizr-lite does, and the config is fetched
+// again in full when the tags change since the cache validators belong to
+// the config of the old ones.
+
+// deviceTags returns the `pacman.tags` in sota.toml
+func deviceTags(sota *toml.Tree) []string {
+	var tags []string
+	if value, ok := sota.Get("pacman.tags").(string); ok {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); len(tag) > 0 {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// hardwareId returns the hardware ID the device was provisioned with
+func hardwareId(sota *toml.Tree) string {
+	value, _ := sota.Get("provision.primary_ecu_hardware_id").(string)
+	return value
+}
+
+// addDeviceHeaders adds the device's tags and hardware ID to a check-in
+func addDeviceHeaders(sota *toml.Tree, headers map[string]string) {
+	if tags := deviceTags(sota); len(tags) > 0 {
+		headers["x-ats-tags"] = strings.Join(tags, ",")
+	}
+	if hwid := hardwareId(sota); len(hwid) > 0 {
+		headers["x-ats-hardware-id"] = hwid
+	}
+}
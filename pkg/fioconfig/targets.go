@@ -0,0 +1,129 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Files with a TargetPath are also installed at that absolute path, so
+// configs like /etc/wpa_supplicant/wpa_supplicant.conf don't need an
+// on-changed script to copy them out of the secrets directory. Since that
+// lets the server write outside of it, only the paths the operator lists
+// in target_paths are allowed.
+
+// targetAllowed reports whether target_paths lists path or a directory it's
+// in
+func (a *App) targetAllowed(path string) bool {
+	for _, allowed := range a.settings.TargetPaths {
+		if within(path, filepath.Clean(allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTargetPath checks a file's TargetPath, and where its symlinks
+// lead, against target_paths before any file is written
+func (a *App) validateTargetPath(cfgFile *ConfigFile) error {
+	target := cfgFile.TargetPath
+	if len(target) == 0 {
+		return nil
+	}
+	if !filepath.IsAbs(target) || filepath.Clean(target) != target || target == "/" {
+		return fmt.Errorf("Invalid target path %q: must be a clean absolute path", target)
+	}
+	if !a.targetAllowed(target) {
+		return fmt.Errorf("Target path %s is not in fioconfig.target_paths", target)
+	}
+	dir, err := resolveDir(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	resolved := filepath.Join(dir, filepath.Base(target))
+	if !a.targetAllowed(resolved) {
+		return fmt.Errorf("Target path %s resolves to %s, which is not in fioconfig.target_paths", target, resolved)
+	}
+	if a.store == nil && within(resolved, filepath.Clean(a.SecretsDir)) {
+		return fmt.Errorf("Target path %s is in the secrets directory", target)
+	}
+	return nil
+}
+
+// checkTargetPaths makes sure no two files are installed at the same path
+func checkTargetPaths(config ConfigStruct) error {
+	targets := make(map[string]string)
+	for _, fname := range sortedNames(config) {
+		target := config[fname].TargetPath
+		if len(target) == 0 {
+			continue
+		}
+		if other, ok := targets[target]; ok {
+			return fmt.Errorf("%s and %s are both installed at %s", other, fname, target)
+		}
+		targets[target] = fname
+	}
+	return nil
+}
+
+// writeTargets installs the files with a TargetPath that are out of date
+// and removes the targets no file has anymore. Only targets target_paths
+// still allows are removed.
+func (a *App) writeTargets(ctx context.Context, c *committedConfig) {
+	wanted := make(map[string]bool)
+	for _, fname := range sortedNames(c.next) {
+		cfgFile := c.next[fname]
+		target := cfgFile.TargetPath
+		if len(target) == 0 {
+			continue
+		}
+		wanted[target] = true
+		meta, err := cfgFile.fileMeta()
+		if err != nil {
+			continue // stage already reported it
+		}
+		content, err := cfgFile.content()
+		if err != nil {
+			continue
+		}
+		cur, err := os.ReadFile(target)
+		if err == nil && bytes.Equal(cur, content) {
+			zeroize(cur)
+			zeroize(content)
+			if err := meta.apply(target); err != nil {
+				c.report.fail(fname, fmt.Errorf("Unable to set permissions of %s: %w", target, err))
+			}
+			continue
+		}
+		zeroize(cur)
+		LogEventWith(EventTargetWritten, LogFields{"file": fname}, "Installing %s at %s", fname, target)
+		err = os.MkdirAll(filepath.Dir(target), 0o755)
+		if err == nil {
+			err = safeWriteMeta(target, content, meta)
+		}
+		zeroize(content)
+		if err != nil {
+			c.report.fail(fname, fmt.Errorf("Unable to install at %s: %w", target, err))
+		}
+	}
+	for _, fname := range sortedNames(c.prev) {
+		target := c.prev[fname].TargetPath
+		if len(target) == 0 || wanted[target] {
+			continue
+		}
+		if _, ok := c.report.Failed[fname]; ok {
+			continue // partial_extract kept its previous version
+		}
+		if !filepath.IsAbs(target) || !a.targetAllowed(target) {
+			logger.Printf("Not removing %s, it's no longer in fioconfig.target_paths", target)
+			continue
+		}
+		LogEventWith(EventTargetWritten, LogFields{"file": fname}, "Removing %s", target)
+		if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Printf("Unable to remove %s: %s", target, err)
+		}
+	}
+}
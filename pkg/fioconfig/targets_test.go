@@ -0,0 +1,67 @@
+package fioconfig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetPaths(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		etc := t.TempDir()
+		wpa := filepath.Join(etc, "wpa_supplicant", "wpa_supplicant.conf")
+		app.settings.TargetPaths = []string{filepath.Join(etc, "wpa_supplicant"), filepath.Join(etc, "motd")}
+
+		config := ConfigStruct{
+			"wpa":  {Value: "network={}\n", Mode: "0600", TargetPath: wpa},
+			"motd": {Value: "hello\n", TargetPath: filepath.Join(etc, "motd")},
+		}
+		_, err := app.extract(context.Background(), nil, configSnapshot{nil, config})
+		require.Nil(t, err)
+		assertFile(t, filepath.Join(tempdir, "wpa"), []byte("network={}\n"))
+		assertFile(t, wpa, []byte("network={}\n"))
+		st, err := os.Stat(wpa)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+		assertFile(t, filepath.Join(etc, "motd"), []byte("hello\n"))
+
+		// Dropping a target removes it
+		next := ConfigStruct{
+			"wpa":  {Value: "network={ssid=\"x\"}\n", Mode: "0600", TargetPath: wpa},
+			"motd": {Value: "hello\n"},
+		}
+		_, err = app.extract(context.Background(), nil, configSnapshot{config, next})
+		require.Nil(t, err)
+		assertFile(t, wpa, []byte("network={ssid=\"x\"}\n"))
+		assertNoFile(t, filepath.Join(etc, "motd"))
+
+		for _, target := range []string{
+			filepath.Join(etc, "passwd"),
+			filepath.Join(etc, "wpa_supplicant", "..", "passwd"),
+			"relative/path",
+			filepath.Join(tempdir, "wpa"),
+		} {
+			bad := ConfigStruct{"bad": {Value: "x", TargetPath: target}}
+			_, err = app.extract(context.Background(), nil, configSnapshot{next, bad})
+			require.NotNil(t, err, target)
+		}
+
+		// A symlink can't lead out of the allowed paths
+		require.Nil(t, os.Symlink(t.TempDir(), filepath.Join(etc, "wpa_supplicant", "escape")))
+		bad := ConfigStruct{"bad": {Value: "x", TargetPath: filepath.Join(etc, "wpa_supplicant", "escape", "x")}}
+		_, err = app.extract(context.Background(), nil, configSnapshot{next, bad})
+		require.NotNil(t, err)
+
+		dup := ConfigStruct{
+			"a": {Value: "a", TargetPath: wpa},
+			"b": {Value: "b", TargetPath: wpa},
+		}
+		_, err = app.extract(context.Background(), nil, configSnapshot{next, dup})
+		require.NotNil(t, err)
+		assertFile(t, wpa, []byte("network={ssid=\"x\"}\n"))
+	})
+}
@@ -0,0 +1,106 @@
+package fioconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// DeviceFacts are the values config files with Template set can use, e.g.
+// `{{ .Hostname }}` or `{{ .DeviceUUID }}`.
+type DeviceFacts struct {
+	Hostname   string
+	DeviceUUID string // Common name of the client certificate
+	Factory    string // Organizational unit of the client certificate
+	Tag        string // First of the `pacman.tags` in sota.toml
+	Target     string // Name of the running target, if known
+}
+
+func (a *App) deviceFacts() (DeviceFacts, error) {
+	var facts DeviceFacts
+	var err error
+	if facts.Hostname, err = os.Hostname(); err != nil {
+		return facts, fmt.Errorf("Unable to get hostname: %w", err)
+	}
+
+	client, crypto, err := a.loadClient(a.sota)
+	if err != nil {
+		return facts, err
+	}
+	a.releaseCrypto(crypto)
+	cert := clientCert(client)
+	if cert == nil {
+		return facts, errors.New("Unable to read client certificate")
+	}
+	facts.DeviceUUID = cert.Subject.CommonName
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		facts.Factory = cert.Subject.OrganizationalUnit[0]
+	}
+
+	if tags := deviceTags(a.sota); len(tags) > 0 {
+		facts.Tag = tags[0]
+	}
+	if storage, ok := a.sota.Get("storage.path").(string); ok {
+		if target, err := LoadCurrentTarget(filepath.Join(storage, "current-target")); err == nil {
+			facts.Target = target.Name
+		}
+	}
+	return facts, nil
+}
+
+// renderTemplates returns a copy of config with the values of templated
+// files rendered. The device facts are only looked up if a file needs them.
+func (a *App) renderTemplates(config ConfigStruct, secrets *localSecrets, report *ExtractReport) (ConfigStruct, error) {
+	var facts *DeviceFacts
+	rendered := make(ConfigStruct, len(config))
+	for fname, cfgFile := range config {
+		rendered[fname] = cfgFile
+		if !cfgFile.Template {
+			continue
+		}
+		if facts == nil {
+			f, err := a.deviceFacts()
+			if err != nil {
+				return nil, fmt.Errorf("Unable to look up device facts for templates: %w", err)
+			}
+			facts = &f
+		}
+		value, err := renderTemplate(fname, cfgFile, *facts, secrets)
+		if err != nil {
+			report.fail(fname, err)
+			if a.settings.PartialExtract {
+				continue // extract drops the files that failed
+			}
+			return nil, err
+		}
+		copied := *cfgFile
+		copied.Value = value
+		rendered[fname] = &copied
+	}
+	return rendered, nil
+}
+
+func renderTemplate(fname string, cfgFile *ConfigFile, facts DeviceFacts, secrets *localSecrets) (string, error) {
+	if cfgFile.Encoding != "" {
+		return "", fmt.Errorf("Templates are not supported for %s encoded values", cfgFile.Encoding)
+	}
+	funcs := template.FuncMap{
+		// See local_secrets.go
+		"localSecret": func(name string) (string, error) {
+			value, err := secrets.get(name)
+			return string(value), err
+		},
+	}
+	tmpl, err := template.New(fname).Option("missingkey=error").Funcs(funcs).Parse(cfgFile.Value)
+	if err != nil {
+		return "", fmt.Errorf("Invalid template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, facts); err != nil {
+		return "", fmt.Errorf("Unable to render template: %w", err)
+	}
+	return buf.String(), nil
+}
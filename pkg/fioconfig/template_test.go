@@ -0,0 +1,43 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		block, _ := pem.Decode([]byte(client_pem))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.Nil(t, err)
+		hostname, err := os.Hostname()
+		require.Nil(t, err)
+
+		app.sota.Set("pacman.tags", "main, devel")
+		config := ConfigStruct{
+			"templated": &ConfigFile{Value: "{{ .Hostname }} {{ .DeviceUUID }} {{ .Tag }}", Template: true},
+			"plain":     &ConfigFile{Value: "{{ .Hostname }}"},
+		}
+		report, err := app.extract(context.Background(), nil, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Empty(t, report.Failed)
+		assertFile(t, filepath.Join(tempdir, "templated"), []byte(hostname+" "+cert.Subject.CommonName+" main"))
+		assertFile(t, filepath.Join(tempdir, "plain"), []byte("{{ .Hostname }}"))
+		require.Equal(t, "{{ .Hostname }} {{ .DeviceUUID }} {{ .Tag }}", config["templated"].Value)
+
+		// Nothing is applied if a template can't be rendered
+		config["templated"] = &ConfigFile{Value: "{{ .Unknown }}", Template: true}
+		config["plain"] = &ConfigFile{Value: "new"}
+		report, err = app.extract(context.Background(), nil, configSnapshot{config, config})
+		require.NotNil(t, err)
+		require.Contains(t, report.Failed, "templated")
+		assertFile(t, filepath.Join(tempdir, "plain"), []byte("{{ .Hostname }}"))
+	})
+}
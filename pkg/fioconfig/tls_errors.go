@@ -0,0 +1,113 @@
+package fioconfig
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Why a TLS connection to the server failed
+type TlsFailureCause string
+
+const (
+	ClientCertExpired     TlsFailureCause = "client-cert-expired"
+	ClientCertNotYetValid TlsFailureCause = "client-cert-not-yet-valid"
+	ClientCertRevoked     TlsFailureCause = "client-cert-revoked"
+	// The server doesn't know the CA that issued the client cert. This
+	// usually means the device belongs to a different factory.
+	ClientCertUnknownCa TlsFailureCause = "client-cert-unknown-ca"
+	ClientCertRejected  TlsFailureCause = "client-cert-rejected"
+	ServerCertUntrusted TlsFailureCause = "server-cert-untrusted"
+	ServerCertNotPinned TlsFailureCause = "server-cert-not-pinned"
+	ServerCertRevoked   TlsFailureCause = "server-cert-revoked"
+)
+
+// TlsError is returned instead of a generic network error when the mTLS
+// handshake with the server fails for a reason we can identify.
+type TlsError struct {
+	Cause TlsFailureCause
+	Err   error
+}
+
+func (e *TlsError) Error() string {
+	return fmt.Sprintf("TLS connection failed (%s): %s", e.Cause, e.Err)
+}
+
+func (e *TlsError) Unwrap() error {
+	return e.Err
+}
+
+// The TLS alerts a server sends when it rejects a client certificate
+var tlsAlertCauses = []struct {
+	alert string
+	cause TlsFailureCause
+}{
+	{"tls: expired certificate", ClientCertExpired},
+	{"tls: revoked certificate", ClientCertRevoked},
+	{"tls: unknown certificate authority", ClientCertUnknownCa},
+	{"tls: bad certificate", ClientCertRejected},
+	{"tls: unsupported certificate", ClientCertRejected},
+	{"tls: unknown certificate", ClientCertRejected},
+	{"tls: certificate required", ClientCertRejected},
+	{"tls: access denied", ClientCertRejected},
+}
+
+// classifyTlsError turns a failed request into a TlsError when the problem
+// was with the handshake. Other errors are returned as is.
+func classifyTlsError(err error, client *http.Client, now time.Time) error {
+	if err == nil {
+		return nil
+	}
+	var uae x509.UnknownAuthorityError
+	var cie x509.CertificateInvalidError
+	var hne x509.HostnameError
+	if errors.As(err, &uae) || errors.As(err, &cie) || errors.As(err, &hne) {
+		return &TlsError{ServerCertUntrusted, err}
+	}
+	if errors.Is(err, ServerNotPinnedError) {
+		return &TlsError{ServerCertNotPinned, err}
+	} else if errors.Is(err, ServerCertRevokedError) {
+		return &TlsError{ServerCertRevoked, err}
+	} else if errors.Is(err, RevocationUnknownError) {
+		return &TlsError{ServerCertUntrusted, err}
+	}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "remote error: tls:") {
+		return err
+	}
+	cause := ClientCertRejected
+	for _, ac := range tlsAlertCauses {
+		if strings.Contains(msg, ac.alert) {
+			cause = ac.cause
+			break
+		}
+	}
+	// Servers often just send "bad certificate", so check the obvious
+	// problems with our cert ourselves.
+	if cause == ClientCertRejected {
+		if cert := clientCert(client); cert != nil {
+			if now.After(cert.NotAfter) {
+				cause = ClientCertExpired
+			} else if now.Before(cert.NotBefore) {
+				cause = ClientCertNotYetValid
+			}
+		}
+	}
+	return &TlsError{cause, err}
+}
+
+func clientCert(client *http.Client) *x509.Certificate {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil || len(transport.TLSClientConfig.Certificates) == 0 {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(transport.TLSClientConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return cert
+}
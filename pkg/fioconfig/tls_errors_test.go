@@ -0,0 +1,65 @@
+package fioconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func tlsErrorsClient(t *testing.T, notBefore, notAfter time.Time) *http.Client {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "device"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, key.Public(), key)
+	require.Nil(t, err)
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}}
+}
+
+func TestClassifyTlsError(t *testing.T) {
+	now := time.Now()
+	client := tlsErrorsClient(t, now.Add(-time.Hour), now.Add(time.Hour))
+	remote := func(alert string) error {
+		return &url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("remote error: tls: " + alert)}
+	}
+	cause := func(err error) TlsFailureCause {
+		var tlsErr *TlsError
+		require.True(t, errors.As(err, &tlsErr), err)
+		return tlsErr.Cause
+	}
+
+	require.Nil(t, classifyTlsError(nil, client, now))
+	plain := errors.New("connection refused")
+	require.Equal(t, plain, classifyTlsError(plain, client, now))
+
+	require.Equal(t, ClientCertUnknownCa, cause(classifyTlsError(remote("unknown certificate authority"), client, now)))
+	require.Equal(t, ClientCertRevoked, cause(classifyTlsError(remote("revoked certificate"), client, now)))
+	require.Equal(t, ClientCertExpired, cause(classifyTlsError(remote("expired certificate"), client, now)))
+	require.Equal(t, ClientCertRejected, cause(classifyTlsError(remote("bad certificate"), client, now)))
+	require.Equal(t, ServerCertUntrusted, cause(classifyTlsError(&url.Error{Err: x509.UnknownAuthorityError{}}, client, now)))
+
+	// A generic rejection of a cert we can see is expired
+	expired := tlsErrorsClient(t, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	require.Equal(t, ClientCertExpired, cause(classifyTlsError(remote("bad certificate"), expired, now)))
+	future := tlsErrorsClient(t, now.Add(time.Hour), now.Add(2*time.Hour))
+	require.Equal(t, ClientCertNotYetValid, cause(classifyTlsError(remote("bad certificate"), future, now)))
+
+	err := classifyTlsError(remote("bad certificate"), client, now)
+	require.Contains(t, err.Error(), "client-cert-rejected")
+}
@@ -0,0 +1,134 @@
+package fioconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// TLS presets for security-reviewed deployments. "fips" limits the
+// handshake to FIPS 140 approved algorithms.
+const (
+	TlsPresetTls13 = "tls13"
+	TlsPresetFips  = "fips"
+)
+
+// Set by TLS debug builds (the tlsdebug build tag) to add key logging and
+// verbose handshake logging to each TLS client config.
+var tlsDebugHook func(cfg *tls.Config)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+func tlsCipherSuite(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return 0, fmt.Errorf("Refusing to enable insecure cipher suite %s", name)
+		}
+	}
+	return 0, fmt.Errorf("Unknown cipher suite %s", name)
+}
+
+// applyTlsSettings restricts the TLS client config according to the
+// `tls_*` settings. A preset is applied first so that individual settings
+// can further adjust it.
+func applyTlsSettings(cfg *tls.Config, settings Settings) error {
+	switch settings.TlsPreset {
+	case "":
+	case TlsPresetTls13:
+		cfg.MinVersion = tls.VersionTLS13
+	case TlsPresetFips:
+		cfg.MinVersion = tls.VersionTLS12
+		cfg.CipherSuites = fipsCipherSuites
+		cfg.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+	default:
+		return fmt.Errorf("Unknown fioconfig.tls_preset: %s", settings.TlsPreset)
+	}
+
+	if len(settings.TlsMinVersion) > 0 {
+		version, ok := tlsVersions[settings.TlsMinVersion]
+		if !ok {
+			return fmt.Errorf("Unsupported fioconfig.tls_min_version: %s", settings.TlsMinVersion)
+		}
+		cfg.MinVersion = version
+	}
+	if len(settings.TlsCiphers) > 0 {
+		cfg.CipherSuites = nil
+		for _, name := range settings.TlsCiphers {
+			id, err := tlsCipherSuite(name)
+			if err != nil {
+				return err
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	if len(settings.TlsCurves) > 0 {
+		cfg.CurvePreferences = nil
+		for _, name := range settings.TlsCurves {
+			curve, ok := tlsCurves[name]
+			if !ok {
+				return fmt.Errorf("Unknown curve %s", name)
+			}
+			cfg.CurvePreferences = append(cfg.CurvePreferences, curve)
+		}
+	}
+
+	pins, err := parseCertPins(settings.TlsPins)
+	if err != nil {
+		return err
+	}
+	revocation, err := newRevocationChecker(settings)
+	if err != nil {
+		return err
+	}
+	if settings.Debug || len(pins) > 0 || revocation != nil {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if settings.Debug {
+				logger.Printf("DEBUG: TLS connection to %s: version=%s cipher=%s",
+					cs.ServerName, tlsVersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite))
+			}
+			if len(pins) > 0 {
+				if err := verifyPins(pins, cs); err != nil {
+					return err
+				}
+			}
+			if revocation != nil {
+				return revocation.check(cs)
+			}
+			return nil
+		}
+	}
+	if tlsDebugHook != nil {
+		tlsDebugHook(cfg)
+	}
+	return nil
+}
+
+func tlsVersionName(version uint16) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return "TLS " + name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
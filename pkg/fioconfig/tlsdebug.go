@@ -0,0 +1,67 @@
+//go:build tlsdebug
+// +build tlsdebug
+
+package fioconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+)
+
+// Developer builds only: this lets anyone with the key log file decrypt the
+// device's traffic, including config payloads, so it must never be enabled
+// in production images.
+func init() {
+	tlsDebugHook = tlsDebug
+}
+
+func tlsDebug(cfg *tls.Config) {
+	LogEvent(EventTlsDebugBuild, "WARNING: This is a TLS debug build of fioconfig. It must not be used in production")
+
+	if path := os.Getenv("SSLKEYLOGFILE"); len(path) > 0 {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			logger.Printf("Unable to open SSLKEYLOGFILE: %s", err)
+		} else {
+			LogEvent(EventTlsKeyLog, "WARNING: Writing TLS session secrets to %s. Anyone with this file can decrypt this device's traffic", path)
+			cfg.KeyLogWriter = f
+		}
+	}
+
+	// Certificates is left in place since other code reads the client cert
+	// from it. GetClientCertificate takes precedence during the handshake.
+	certificates := cfg.Certificates
+	cfg.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		logger.Printf("TLS DEBUG: Server requested a client certificate. Signature schemes: %v", cri.SignatureSchemes)
+		for _, dn := range cri.AcceptableCAs {
+			logger.Printf("TLS DEBUG:   Acceptable CA (DER subject): %x", dn)
+		}
+		for i := range certificates {
+			if err := cri.SupportsCertificate(&certificates[i]); err != nil {
+				logger.Printf("TLS DEBUG: Client certificate %d not acceptable to server: %s", i, err)
+				continue
+			}
+			return &certificates[i], nil
+		}
+		logger.Print("TLS DEBUG: No acceptable client certificate, sending none")
+		return &tls.Certificate{}, nil
+	}
+
+	verify := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		logger.Printf("TLS DEBUG: Connected to %s: version=0x%04x cipher=%s resumed=%v alpn=%q",
+			cs.ServerName, cs.Version, tls.CipherSuiteName(cs.CipherSuite), cs.DidResume, cs.NegotiatedProtocol)
+		for _, cert := range cs.PeerCertificates {
+			logPeerCert(cert)
+		}
+		if verify != nil {
+			return verify(cs)
+		}
+		return nil
+	}
+}
+
+func logPeerCert(cert *x509.Certificate) {
+	logger.Printf("TLS DEBUG:   Server cert subject=%q issuer=%q not-after=%s", cert.Subject, cert.Issuer, cert.NotAfter)
+}
@@ -0,0 +1,84 @@
+package fioconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Values of secrets_tmpfs. "require" refuses to extract plaintext into a
+// secrets directory that isn't on tmpfs, leaving it to something else to
+// mount one. "mount" mounts a tmpfs on the empty directory itself, so the
+// secrets never reach flash and are gone after a reboot.
+const (
+	SecretsTmpfsNone    = ""
+	SecretsTmpfsRequire = "require"
+	SecretsTmpfsMount   = "mount"
+)
+
+// PersistentSecretsDirError is returned instead of extracting to a secrets
+// directory on persistent storage when secrets_tmpfs requires tmpfs
+var PersistentSecretsDirError = errors.New("Refusing to extract config to a secrets directory that isn't on tmpfs")
+
+// isVolatile returns true if path is on a filesystem that only lives in
+// memory
+func isVolatile(path string) (bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false, fmt.Errorf("Unable to check the filesystem of %s: %w", path, err)
+	}
+	return st.Type == unix.TMPFS_MAGIC || st.Type == unix.RAMFS_MAGIC, nil
+}
+
+// ensureVolatileSecretsDir applies secrets_tmpfs before anything is
+// extracted
+func (a *App) ensureVolatileSecretsDir() error {
+	mode := a.settings.SecretsTmpfs
+	switch mode {
+	case SecretsTmpfsNone:
+		return nil
+	case SecretsTmpfsRequire, SecretsTmpfsMount:
+	default:
+		return fmt.Errorf("Unknown fioconfig.secrets_tmpfs: %s", mode)
+	}
+	if mode == SecretsTmpfsMount {
+		if err := os.MkdirAll(a.SecretsDir, 0o700); err != nil {
+			return err
+		}
+	}
+	volatile, err := isVolatile(a.SecretsDir)
+	if err != nil || volatile {
+		return err
+	}
+	if mode == SecretsTmpfsRequire {
+		return fmt.Errorf("%w: %s", PersistentSecretsDirError, a.SecretsDir)
+	}
+
+	// Anything already in the directory would be left on flash under the
+	// mount
+	dir, err := os.Open(a.SecretsDir)
+	if err != nil {
+		return err
+	}
+	names, err := dir.Readdirnames(1)
+	dir.Close()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("%w: %s must be empty to mount a tmpfs on it", PersistentSecretsDirError, a.SecretsDir)
+	}
+	st, err := os.Stat(a.SecretsDir)
+	if err != nil {
+		return err
+	}
+	opts := fmt.Sprintf("mode=%04o", st.Mode().Perm())
+	if err := unix.Mount("tmpfs", a.SecretsDir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, opts); err != nil {
+		return fmt.Errorf("Unable to mount a tmpfs on %s: %w", a.SecretsDir, err)
+	}
+	logger.Printf("Mounted a tmpfs on %s", a.SecretsDir)
+	return nil
+}
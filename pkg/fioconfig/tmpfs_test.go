@@ -0,0 +1,59 @@
+package fioconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSecretsTmpfsRequired(t *testing.T) {
+	app := &App{SecretsDir: t.TempDir()}
+	require.Nil(t, app.ensureVolatileSecretsDir())
+
+	app.settings.SecretsTmpfs = SecretsTmpfsRequire
+	if volatile, err := isVolatile(app.SecretsDir); err != nil || volatile {
+		t.Skip("The test directory is on tmpfs")
+	}
+	err := app.ensureVolatileSecretsDir()
+	require.True(t, errors.Is(err, PersistentSecretsDirError), err)
+
+	shm, err := os.MkdirTemp("/dev/shm", "fioconfig-test")
+	if err == nil {
+		defer os.RemoveAll(shm)
+		app.SecretsDir = shm
+		require.Nil(t, app.ensureVolatileSecretsDir())
+	}
+
+	app.settings.SecretsTmpfs = "ramdisk"
+	require.NotNil(t, app.ensureVolatileSecretsDir())
+}
+
+func TestSecretsTmpfsMount(t *testing.T) {
+	app := &App{SecretsDir: filepath.Join(t.TempDir(), "secrets")}
+	app.settings.SecretsTmpfs = SecretsTmpfsMount
+	require.Nil(t, os.MkdirAll(app.SecretsDir, 0o750))
+	require.Nil(t, os.WriteFile(filepath.Join(app.SecretsDir, "leftover"), nil, 0o600))
+	err := app.ensureVolatileSecretsDir()
+	require.True(t, errors.Is(err, PersistentSecretsDirError), err)
+	require.Nil(t, os.Remove(filepath.Join(app.SecretsDir, "leftover")))
+
+	err = app.ensureVolatileSecretsDir()
+	if errors.Is(err, unix.EPERM) {
+		t.Skip("Mounting needs CAP_SYS_ADMIN")
+	}
+	require.Nil(t, err)
+	defer func() { require.Nil(t, unix.Unmount(app.SecretsDir, 0)) }()
+	volatile, err := isVolatile(app.SecretsDir)
+	require.Nil(t, err)
+	require.True(t, volatile)
+	st, err := os.Stat(app.SecretsDir)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0o750), st.Mode().Perm())
+
+	// Already mounted
+	require.Nil(t, app.ensureVolatileSecretsDir())
+}
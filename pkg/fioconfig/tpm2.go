@@ -0,0 +1,163 @@
+//go:build tpm2
+// +build tpm2
+
+package fioconfig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+
+	ecies "github.com/foundriesio/go-ecies"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	toml "github.com/pelletier/go-toml"
+)
+
+func init() {
+	RegisterIdentityProvider("tpm2", tpm2Identity)
+}
+
+// tpm2Key is an ECC key held by the TPM. The key must be created with both
+// the sign and decrypt attributes so it can be used for the TLS handshake
+// and for ECDH when decrypting config values.
+type tpm2Key struct {
+	*ecies.PublicKey
+	// The TPM connection can't handle concurrent commands
+	lock     sync.Mutex
+	rw       io.ReadWriteCloser
+	handle   tpmutil.Handle
+	password string
+}
+
+func (k *tpm2Key) Public() crypto.PublicKey {
+	return k.PublicKey.ExportECDSA()
+}
+
+func (k *tpm2Key) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var hashAlg tpm2.Algorithm
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		hashAlg = tpm2.AlgSHA256
+	case crypto.SHA384:
+		hashAlg = tpm2.AlgSHA384
+	case crypto.SHA512:
+		hashAlg = tpm2.AlgSHA512
+	default:
+		return nil, fmt.Errorf("Unsupported TPM signing hash: %v", opts.HashFunc())
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	sig, err := tpm2.Sign(k.rw, k.handle, k.password, digest, nil, &tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: hashAlg})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to sign with TPM: %w", err)
+	}
+	if sig.ECC == nil {
+		return nil, errors.New("TPM did not return an ECDSA signature")
+	}
+	return asn1.Marshal(struct{ R, S *big.Int }{sig.ECC.R, sig.ECC.S})
+}
+
+// tpm2Ecies lets the ECIES code use the TPM key via its KeyProvider interface
+type tpm2Ecies struct {
+	*tpm2Key
+}
+
+func (k tpm2Ecies) Public() *ecies.PublicKey {
+	return k.tpm2Key.PublicKey
+}
+
+func (k tpm2Ecies) GenerateShared(pub *ecies.PublicKey) ([]byte, error) {
+	if pub.Curve != k.Curve {
+		return nil, ecies.ErrInvalidCurve
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	in := tpm2.ECPoint{
+		XRaw: pub.X.FillBytes(make([]byte, size)),
+		YRaw: pub.Y.FillBytes(make([]byte, size)),
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	z, err := tpm2.ECDHZGen(k.rw, k.handle, k.password, in)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to derive shared secret with TPM: %w", err)
+	}
+	return z.X().FillBytes(make([]byte, size)), nil
+}
+
+type tpm2Crypto struct {
+	*EciesCrypto
+	rw io.Closer
+}
+
+func (c *tpm2Crypto) Close() {
+	c.rw.Close()
+}
+
+func tpm2Identity(sota *toml.Tree) (tls.Certificate, CryptoHandler, error) {
+	var tlsCert tls.Certificate
+	device := sota.GetDefault("tpm2.device", "/dev/tpmrm0").(string)
+	handleVal, err := tomlGet(sota, "tpm2.key_handle")
+	if err != nil {
+		return tlsCert, nil, err
+	}
+	certFile, err := tomlGet(sota, "import.tls_clientcert_path")
+	if err != nil {
+		return tlsCert, nil, err
+	}
+	handle, err := strconv.ParseUint(handleVal, 0, 32)
+	if err != nil {
+		return tlsCert, nil, fmt.Errorf("Invalid tpm2.key_handle: %w", err)
+	}
+
+	rw, err := tpm2.OpenTPM(device)
+	if err != nil {
+		return tlsCert, nil, fmt.Errorf("Unable to open TPM %s: %w", device, err)
+	}
+	pub, _, _, err := tpm2.ReadPublic(rw, tpmutil.Handle(handle))
+	if err != nil {
+		rw.Close()
+		return tlsCert, nil, fmt.Errorf("Unable to read TPM key 0x%x: %w", handle, err)
+	}
+	pubKey, err := pub.Key()
+	if err != nil {
+		rw.Close()
+		return tlsCert, nil, err
+	}
+	ecPub, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		rw.Close()
+		return tlsCert, nil, fmt.Errorf("TPM key 0x%x is not an ECC key", handle)
+	}
+
+	key := &tpm2Key{
+		PublicKey: ecies.ImportECDSAPublic(ecPub),
+		rw:        rw,
+		handle:    tpmutil.Handle(handle),
+		password:  sota.GetDefault("tpm2.password", "").(string),
+	}
+	certPem, err := os.ReadFile(certFile)
+	if err != nil {
+		rw.Close()
+		return tlsCert, nil, err
+	}
+	block, _ := pem.Decode(certPem)
+	if block == nil || block.Type != "CERTIFICATE" {
+		rw.Close()
+		return tlsCert, nil, fmt.Errorf("Unable to decode client certificate %s", certFile)
+	}
+	tlsCert = tls.Certificate{
+		Certificate: [][]byte{block.Bytes},
+		PrivateKey:  key,
+	}
+	return tlsCert, &tpm2Crypto{&EciesCrypto{PrivKey: tpm2Ecies{key}}, rw}, nil
+}
@@ -0,0 +1,99 @@
+//go:build tpm2
+// +build tpm2
+
+package fioconfig
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+func init() {
+	RegisterCacheSealer("tpm2", newTpm2CacheSealer)
+}
+
+// The storage key sealed objects are created under. CreatePrimary always
+// returns the same key for this template, so it doesn't need to be
+// persisted.
+var tpm2SrkTemplate = tpm2.Public{
+	Type:       tpm2.AlgECC,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagStorageDefault | tpm2.FlagNoDA,
+	ECCParameters: &tpm2.ECCParams{
+		Symmetric: &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 128, Mode: tpm2.AlgCFB},
+		CurveID:   tpm2.CurveNISTP256,
+	},
+}
+
+var tpm2SealedTemplate = tpm2.Public{
+	Type:       tpm2.AlgKeyedHash,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagUserWithAuth | tpm2.FlagNoDA,
+}
+
+// tpm2SealedKey is the cache key sealed by the TPM, as saved to disk
+type tpm2SealedKey struct {
+	Public  []byte
+	Private []byte
+}
+
+// newTpm2CacheSealer encrypts the cache with a random AES key sealed by
+// the TPM, so the cache can only be read on this device. The key is created
+// the first time it's needed.
+func newTpm2CacheSealer(a *App) (CacheSealer, error) {
+	device := a.sota.GetDefault("tpm2.device", "/dev/tpmrm0").(string)
+	rw, err := tpm2.OpenTPM(device)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open TPM %s: %w", device, err)
+	}
+	defer rw.Close()
+
+	srk, _, err := tpm2.CreatePrimary(rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", tpm2SrkTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create TPM storage key: %w", err)
+	}
+	defer func() { _ = tpm2.FlushContext(rw, srk) }()
+
+	path := filepath.Join(a.stateDir(), "cache-key.tpm2")
+	var sealed tpm2SealedKey
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		sealed.Private, sealed.Public, _, _, _, err = tpm2.CreateKeyWithSensitive(rw, srk, tpm2.PCRSelection{}, "", "", tpm2SealedTemplate, key)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to seal cache key: %w", err)
+		}
+		if buf, err = json.Marshal(sealed); err != nil {
+			return nil, err
+		}
+		if err := safeWrite(path, buf); err != nil {
+			return nil, err
+		}
+		return aesSealer{key}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &sealed); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %w", path, err)
+	}
+
+	handle, _, err := tpm2.Load(rw, srk, "", sealed.Public, sealed.Private)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load sealed cache key: %w", err)
+	}
+	defer func() { _ = tpm2.FlushContext(rw, handle) }()
+	key, err := tpm2.Unseal(rw, handle, "")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unseal cache key: %w", err)
+	}
+	return aesSealer{key}, nil
+}
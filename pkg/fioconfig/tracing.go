@@ -0,0 +1,235 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Check-ins and extractions can be traced with OpenTelemetry by setting
+// otlp_endpoint. The spans are exported with OTLP/HTTP's JSON encoding
+// once the check-in is done. Nothing is recorded when it isn't set.
+
+// tracer collects the spans of one traced operation
+type tracer struct {
+	lock    sync.Mutex
+	traceId string
+	spans   []*span
+}
+
+type span struct {
+	tracer *tracer
+	id     string
+	parent string
+	name   string
+	start  time.Time
+	end    time.Time
+	attrs  map[string]interface{}
+	err    error
+}
+
+type spanKey struct{}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// startTrace returns a context that spans are recorded in when tracing is
+// enabled, along with its root span.
+func (a *App) startTrace(ctx context.Context, name string) (context.Context, *span) {
+	if len(a.settings.OtlpEndpoint) == 0 {
+		return ctx, nil
+	}
+	t := &tracer{traceId: randomHex(16)}
+	root := &span{tracer: t, id: randomHex(8), name: name, start: time.Now()}
+	t.spans = append(t.spans, root)
+	return context.WithValue(ctx, spanKey{}, root), root
+}
+
+// startSpan starts a child of the context's span. It returns a nil span,
+// which is safe to use, when the context isn't being traced.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	parent, _ := ctx.Value(spanKey{}).(*span)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: parent.tracer, id: randomHex(8), parent: parent.id, name: name, start: time.Now()}
+	t := parent.tracer
+	t.lock.Lock()
+	t.spans = append(t.spans, s)
+	t.lock.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// finish ends the span, marking it failed when err is set
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.end = time.Now()
+	s.err = err
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"` // int64s are strings in OTLP JSON
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOk         = 1
+	otlpStatusError      = 2
+)
+
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch val := value.(type) {
+	case int:
+		s := strconv.Itoa(val)
+		v.IntValue = &s
+	case bool:
+		v.BoolValue = &val
+	default:
+		s := fmt.Sprint(val)
+		v.StringValue = &s
+	}
+	return otlpAttribute{key, v}
+}
+
+func sortedAttrs(attrs map[string]interface{}) []otlpAttribute {
+	keys := make(map[string]string, len(attrs))
+	for k := range attrs {
+		keys[k] = k
+	}
+	var out []otlpAttribute
+	for _, k := range sortedKeys(keys) {
+		out = append(out, otlpAttr(k, attrs[k]))
+	}
+	return out
+}
+
+// payload returns the spans as an OTLP ExportTraceServiceRequest
+func (t *tracer) payload(deviceId string) ([]byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var spans []otlpSpan
+	for _, s := range t.spans {
+		end := s.end
+		if end.IsZero() {
+			end = time.Now()
+		}
+		status := otlpStatus{Code: otlpStatusOk}
+		if s.err != nil {
+			status = otlpStatus{otlpStatusError, s.err.Error()}
+		}
+		spans = append(spans, otlpSpan{
+			TraceId:           t.traceId,
+			SpanId:            s.id,
+			ParentSpanId:      s.parent,
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+			Attributes:        sortedAttrs(s.attrs),
+			Status:            status,
+		})
+	}
+	resource := map[string]interface{}{"service.name": "fioconfig"}
+	if len(deviceId) > 0 {
+		resource["service.instance.id"] = deviceId
+	}
+	if len(Commit) > 0 {
+		resource["service.version"] = Commit
+	}
+	return json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": sortedAttrs(resource)},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/foundriesio/fioconfig"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	})
+}
+
+// endTrace finishes the root span and exports the trace. Export failures
+// are only logged since tracing must never break a check-in.
+func (a *App) endTrace(root *span, client *http.Client, err error) {
+	if root == nil {
+		return
+	}
+	root.finish(err)
+	var deviceId string
+	if client != nil {
+		if cert := clientCert(client); cert != nil {
+			deviceId = cert.Subject.CommonName
+		}
+	}
+	buf, err := root.tracer.payload(deviceId)
+	if err != nil {
+		logger.Printf("ERROR: Unable to encode trace: %s", err)
+		return
+	}
+	url := strings.TrimRight(a.settings.OtlpEndpoint, "/") + "/v1/traces"
+	otlpClient := &http.Client{Timeout: 10 * time.Second}
+	res, err := otlpClient.Post(url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		logger.Printf("ERROR: Unable to export trace to %s: %s", url, err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		logger.Printf("ERROR: Unable to export trace to %s: HTTP_%d", url, res.StatusCode)
+	}
+}
@@ -0,0 +1,73 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracing(t *testing.T) {
+	var payload struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpAttribute
+			}
+			ScopeSpans []struct {
+				Spans []otlpSpan
+			}
+		}
+	}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		buf, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		require.Nil(t, json.Unmarshal(buf, &payload))
+	}))
+	defer collector.Close()
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		// Untraced until an endpoint is set
+		require.Nil(t, app.Extract())
+		require.Empty(t, payload.ResourceSpans)
+
+		app.settings.OtlpEndpoint = collector.URL + "/"
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "bar")))
+		require.Nil(t, app.Extract())
+		require.Len(t, payload.ResourceSpans, 1)
+		resource := payload.ResourceSpans[0].Resource.Attributes
+		require.Equal(t, "service.instance.id", resource[0].Key)
+		require.Equal(t, "service.name", resource[1].Key)
+		require.Equal(t, "fioconfig", *resource[1].Value.StringValue)
+		spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+
+		byName := make(map[string][]otlpSpan)
+		for _, s := range spans {
+			require.Equal(t, spans[0].TraceId, s.TraceId)
+			require.Len(t, s.TraceId, 32)
+			require.Len(t, s.SpanId, 16)
+			byName[s.Name] = append(byName[s.Name], s)
+		}
+		root := byName["extract"][0]
+		require.Empty(t, root.ParentSpanId)
+		require.Equal(t, otlpStatusOk, root.Status.Code)
+		require.Equal(t, root.SpanId, byName["decrypt"][0].ParentSpanId)
+		require.Len(t, byName["extract.file"], 4)
+		for _, s := range byName["extract.file"] {
+			require.Equal(t, root.SpanId, s.ParentSpanId)
+			require.Equal(t, "fioconfig.changed", s.Attributes[0].Key)
+			require.Equal(t, "fioconfig.file", s.Attributes[1].Key)
+		}
+		handler := byName["on-changed"][0]
+		require.Equal(t, root.SpanId, handler.ParentSpanId)
+		require.Equal(t, "fioconfig.file", handler.Attributes[0].Key)
+		require.Equal(t, "bar", *handler.Attributes[0].Value.StringValue)
+		require.Equal(t, "0", *handler.Attributes[2].Value.IntValue)
+	})
+}
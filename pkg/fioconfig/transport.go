@@ -0,0 +1,61 @@
+package fioconfig
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultHttpTimeout limits each request of the device client unless the
+// http_timeout setting changes it
+const defaultHttpTimeout = 30 * time.Second
+
+// settingDuration parses a duration setting, returning def when it's unset
+func settingDuration(name, value string, def time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid fioconfig.%s: %w", name, err)
+	}
+	return d, nil
+}
+
+// applyTransportSettings tunes the device client's timeouts and connection
+// reuse for the link it's on, e.g. a satellite link that needs minutes for
+// a large config but should give up quickly on a dead connection.
+func applyTransportSettings(client *http.Client, transport *http.Transport, settings Settings) error {
+	var err error
+	var base net.Dialer
+	if base.Timeout, err = settingDuration("connect_timeout", settings.ConnectTimeout, 0); err != nil {
+		return err
+	}
+	if base.KeepAlive, err = settingDuration("tcp_keep_alive", settings.TcpKeepAlive, 0); err != nil {
+		return err
+	}
+	if transport.TLSHandshakeTimeout, err = settingDuration("tls_handshake_timeout", settings.TlsHandshakeTimeout, 0); err != nil {
+		return err
+	}
+	if transport.ResponseHeaderTimeout, err = settingDuration("response_header_timeout", settings.ResponseHeaderTimeout, 0); err != nil {
+		return err
+	}
+	if transport.IdleConnTimeout, err = settingDuration("idle_conn_timeout", settings.IdleConnTimeout, 0); err != nil {
+		return err
+	}
+	if client.Timeout, err = settingDuration("http_timeout", settings.HttpTimeout, defaultHttpTimeout); err != nil {
+		return err
+	}
+
+	dialer, err := newDialer(settings, base)
+	if err != nil {
+		return err
+	}
+	if dialer != nil {
+		transport.DialContext = dialer.DialContext
+	} else {
+		transport.DialContext = base.DialContext
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package fioconfig
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportSettings(t *testing.T) {
+	client := &http.Client{Timeout: defaultHttpTimeout}
+	transport := &http.Transport{}
+	require.Nil(t, applyTransportSettings(client, transport, Settings{}))
+	require.Equal(t, defaultHttpTimeout, client.Timeout)
+	require.Equal(t, time.Duration(0), transport.TLSHandshakeTimeout)
+	require.NotNil(t, transport.DialContext)
+
+	settings := Settings{
+		ConnectTimeout:        "20s",
+		TlsHandshakeTimeout:   "40s",
+		ResponseHeaderTimeout: "2m",
+		HttpTimeout:           "0",
+		IdleConnTimeout:       "90s",
+		IpFamily:              IpFamilyPreferV4,
+	}
+	require.Nil(t, applyTransportSettings(client, transport, settings))
+	require.Equal(t, time.Duration(0), client.Timeout)
+	require.Equal(t, 40*time.Second, transport.TLSHandshakeTimeout)
+	require.Equal(t, 2*time.Minute, transport.ResponseHeaderTimeout)
+	require.Equal(t, 90*time.Second, transport.IdleConnTimeout)
+
+	d, err := newDialer(settings, net.Dialer{Timeout: 20 * time.Second})
+	require.Nil(t, err)
+	require.Equal(t, 20*time.Second, d.base.Timeout)
+
+	err = applyTransportSettings(client, transport, Settings{HttpTimeout: "soon"})
+	require.ErrorContains(t, err, "fioconfig.http_timeout")
+}
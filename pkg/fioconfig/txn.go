@@ -0,0 +1,299 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Where extractions are staged. It's inside the secrets directory so
+// commits are renames on the same filesystem.
+const txnDirName = ".fioconfig-txn"
+
+// configTxn applies a config to where its files are kept all or nothing
+type configTxn interface {
+	// stage prepares fname, the i'th file of the config, to be updated and
+	// returns whether its content will change
+	stage(i int, fname string, cfgFile *ConfigFile, appliedHash string) (bool, error)
+	// remove schedules fname to be removed when the transaction commits
+	remove(fname string)
+	commit() error
+	close()
+}
+
+// extractTxn applies a config to the secrets directory all or nothing.
+// Changed files are written to a staging area first and then renamed into
+// place, with the files they replace and the files being removed moved
+// aside so they can be put back if any step fails. Files can be staged
+// concurrently, but are committed in the order of the config followed by
+// the removals.
+type extractTxn struct {
+	secretsDir string
+	dir        string
+	dirMode    os.FileMode
+	labels     *fileContexts
+	lock       sync.Mutex
+	nstaged    uint32
+	files      []*txnOp // By position in the config
+	removals   []*txnOp
+	done       []*txnOp
+}
+
+type txnOp struct {
+	fname    string
+	staged   string // empty when removing the file
+	meta     fileMeta
+	metaOnly bool // Content is up to date, only fix permissions
+	backup   string
+}
+
+func beginExtract(secretsDir string, dirMode os.FileMode) (*extractTxn, error) {
+	txn := &extractTxn{
+		secretsDir: secretsDir,
+		dir:        filepath.Join(secretsDir, txnDirName),
+		dirMode:    dirMode,
+	}
+	// Left over from a crash. Nothing was committed from it since the
+	// downloaded config is only saved after a successful commit, so the
+	// next extraction will apply it again.
+	if err := os.RemoveAll(txn.dir); err != nil {
+		return nil, err
+	}
+	for _, sub := range []string{"staged", "backup"} {
+		if err := os.MkdirAll(filepath.Join(txn.dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("Unable to create staging directory: %w", err)
+		}
+	}
+	return txn, nil
+}
+
+// stage prepares fname, the i'th file of the config, to be updated and
+// returns whether its content will change. appliedHash is the sha256 of the
+// value we last wrote to the file.
+func (t *extractTxn) stage(i int, fname string, cfgFile *ConfigFile, appliedHash string) (bool, error) {
+	if cfgFile.Symlink {
+		return t.stageSymlink(i, fname, cfgFile)
+	}
+	meta, err := cfgFile.fileMeta()
+	if err != nil {
+		return false, err
+	}
+	newContent, err := cfgFile.content()
+	if err != nil {
+		return false, err
+	}
+	defer zeroize(newContent)
+	secretFile := filepath.Join(t.secretsDir, fname)
+	curContent, err := os.ReadFile(secretFile)
+	defer zeroize(curContent)
+	if st, lerr := os.Lstat(secretFile); lerr == nil && st.Mode()&os.ModeSymlink != 0 {
+		err = errors.New("Replacing a symlink") // Its target's content doesn't count
+	}
+	if err == nil {
+		if contentEqual(cfgFile.Compare, curContent, newContent) {
+			t.add(i, &txnOp{fname: fname, meta: meta, metaOnly: true})
+			return false, nil
+		}
+		if cfgFile.IgnoreHookChanges && appliedHash == sha256Hex(newContent) {
+			LogEvent(EventLocalChangeKept, "%s was modified locally but is unchanged on the server, leaving it as is", secretFile)
+			t.add(i, &txnOp{fname: fname, meta: meta, metaOnly: true})
+			return false, nil
+		}
+	}
+	staged := t.stagedPath()
+	if err := safeWriteMeta(staged, newContent, meta); err != nil {
+		return false, err
+	}
+	t.add(i, &txnOp{fname: fname, staged: staged, meta: meta})
+	return true, nil
+}
+
+// stagedPath returns a new path in the staging area
+func (t *extractTxn) stagedPath() string {
+	n := atomic.AddUint32(&t.nstaged, 1)
+	return filepath.Join(t.dir, "staged", strconv.FormatUint(uint64(n), 10))
+}
+
+// add records the op for the i'th file of the config. Files finish staging
+// in any order, so they're kept by position rather than appended.
+func (t *extractTxn) add(i int, op *txnOp) {
+	t.lock.Lock()
+	for len(t.files) <= i {
+		t.files = append(t.files, nil)
+	}
+	t.files[i] = op
+	t.lock.Unlock()
+}
+
+// remove schedules fname to be removed when the transaction commits
+func (t *extractTxn) remove(fname string) {
+	t.lock.Lock()
+	t.removals = append(t.removals, &txnOp{fname: fname})
+	t.lock.Unlock()
+}
+
+type stageResult struct {
+	updated bool
+	err     error
+}
+
+// stageFiles stages the files in order with up to workers at a time, since
+// writing them out is most of an extraction on slow storage. Unless
+// keepGoing is set, it stops taking new files once one fails. The results
+// are in the order of the files.
+func stageFiles(ctx context.Context, txn configTxn, order []string, config ConfigStruct, applied manifest, workers int, keepGoing bool) []stageResult {
+	results := make([]stageResult, len(order))
+	queue := make(chan int, len(order))
+	for i := range order {
+		queue <- i
+	}
+	close(queue)
+	var failed uint32
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if atomic.LoadUint32(&failed) != 0 {
+					return
+				}
+				fname := order[i]
+				_, fileSpan := startSpan(ctx, "extract.file")
+				fileSpan.set("fioconfig.file", fname)
+				updated, err := txn.stage(i, fname, config[fname], applied[fname])
+				fileSpan.set("fioconfig.changed", updated)
+				fileSpan.finish(err)
+				results[i] = stageResult{updated, err}
+				if err != nil && !keepGoing {
+					atomic.StoreUint32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// keepBackup moves the version of fname a commit replaced to dst so it
+// outlives the transaction. It returns false if the file didn't exist
+// before.
+func (t *extractTxn) keepBackup(fname, dst string) (bool, error) {
+	for _, op := range t.done {
+		if op.fname == fname && len(op.backup) > 0 {
+			if err := os.Rename(op.backup, dst); err != nil {
+				return false, err
+			}
+			op.backup = ""
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TxnError is returned when a transaction couldn't be committed. The
+// secrets directory has been restored to how it was before.
+type TxnError struct {
+	File string
+	Err  error
+}
+
+func (e *TxnError) Error() string {
+	return fmt.Sprintf("Unable to apply %s, previous config restored: %s", e.File, e.Err)
+}
+
+func (e *TxnError) Unwrap() error {
+	return e.Err
+}
+
+func (t *extractTxn) commit() error {
+	var ops []*txnOp
+	for _, op := range t.files {
+		if op != nil { // Failed to stage
+			ops = append(ops, op)
+		}
+	}
+	ops = append(ops, t.removals...)
+	dirs := make(map[string]bool)
+	for i, op := range ops {
+		if err := t.apply(i, op); err != nil {
+			t.rollback()
+			return &TxnError{op.fname, err}
+		}
+		if !op.metaOnly {
+			dirs[filepath.Dir(filepath.Join(t.secretsDir, op.fname))] = true
+		}
+	}
+	// The renames are only durable once the directories are synced. Those
+	// left empty by removals may already be gone.
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *extractTxn) apply(i int, op *txnOp) error {
+	dst := filepath.Join(t.secretsDir, op.fname)
+	if op.metaOnly {
+		if err := op.meta.apply(dst); err != nil {
+			return err
+		}
+		t.labels.relabelTree(t.secretsDir, dst)
+		return nil
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		op.backup = filepath.Join(t.dir, "backup", strconv.Itoa(i))
+		if err := os.Rename(dst, op.backup); err != nil {
+			op.backup = ""
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	t.done = append(t.done, op)
+	if len(op.staged) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), t.dirMode); err != nil {
+		return fmt.Errorf("Unable to create parent directory: %w", err)
+	}
+	if err := os.Rename(op.staged, dst); err != nil {
+		return err
+	}
+	t.labels.relabelTree(t.secretsDir, dst)
+	return nil
+}
+
+func (t *extractTxn) rollback() {
+	for i := len(t.done) - 1; i >= 0; i-- {
+		op := t.done[i]
+		dst := filepath.Join(t.secretsDir, op.fname)
+		if len(op.staged) > 0 {
+			if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+				LogEvent(EventExtractRollback, "ERROR: Unable to remove new %s: %s", dst, err)
+			}
+		}
+		if len(op.backup) > 0 {
+			if err := os.Rename(op.backup, dst); err != nil {
+				LogEvent(EventExtractRollback, "ERROR: Unable to restore %s: %s", dst, err)
+			} else {
+				t.labels.relabelTree(t.secretsDir, dst)
+			}
+		}
+	}
+	LogEvent(EventExtractRollback, "Restored previous config files")
+}
+
+// close removes the staging area
+func (t *extractTxn) close() {
+	if err := os.RemoveAll(t.dir); err != nil {
+		LogEvent(EventExtractFailed, "Unable to remove %s: %s", t.dir, err)
+	}
+}
@@ -0,0 +1,158 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractRollback(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		prev := ConfigStruct{
+			"foo": {Value: "old foo"},
+			"bar": {Value: "old bar", OnChanged: []string{"/usr/bin/touch", filepath.Join(tempdir, "bar-changed")}},
+		}
+		_, err := app.extract(context.Background(), nil, configSnapshot{nil, prev})
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "bar-changed")))
+
+		// "blocker" isn't a directory, so the commit fails part way through
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "blocker"), []byte("x"), 0o640))
+		next := ConfigStruct{
+			"foo":       {Value: "new foo"},
+			"blocker/x": {Value: "x"},
+		}
+		report, err := app.extract(context.Background(), nil, configSnapshot{prev, next})
+		var txnErr *TxnError
+		require.True(t, errors.As(err, &txnErr), err)
+		require.Equal(t, "blocker/x", txnErr.File)
+		require.Contains(t, report.Failed, "blocker/x")
+		require.Empty(t, report.Applied)
+		require.Empty(t, report.Removed)
+
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("old foo"))
+		assertFile(t, filepath.Join(tempdir, "bar"), []byte("old bar"))
+		assertNoFile(t, filepath.Join(tempdir, "bar-changed"))
+		assertNoFile(t, filepath.Join(tempdir, txnDirName))
+		require.Equal(t, sha256Hex([]byte("old foo")), app.loadManifest()["foo"])
+
+		// And once the problem is fixed it all applies
+		require.Nil(t, os.Remove(filepath.Join(tempdir, "blocker")))
+		_, err = app.extract(context.Background(), nil, configSnapshot{prev, next})
+		require.Nil(t, err)
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("new foo"))
+		assertFile(t, filepath.Join(tempdir, "blocker/x"), []byte("x"))
+		assertNoFile(t, filepath.Join(tempdir, "bar"))
+		assertFile(t, filepath.Join(tempdir, "bar-changed"), nil)
+	})
+}
+
+func TestExtractWorkers(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		app.settings.ExtractWorkers = 4
+		config := make(ConfigStruct)
+		for i := 0; i < 50; i++ {
+			config["dir"+strconv.Itoa(i%5)+"/file"+strconv.Itoa(i)] = &ConfigFile{Value: "value " + strconv.Itoa(i)}
+		}
+		report, err := app.extract(context.Background(), nil, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Len(t, report.Applied, 50)
+		for fname, cfgFile := range config {
+			assertFile(t, filepath.Join(tempdir, fname), []byte(cfgFile.Value))
+		}
+
+		next := make(ConfigStruct)
+		for fname, cfgFile := range config {
+			next[fname] = cfgFile
+		}
+		next["dir1/file6"] = &ConfigFile{Value: "changed"}
+		next["dir3/file8"] = &ConfigFile{Value: "changed"}
+		report, err = app.extract(context.Background(), nil, configSnapshot{config, next})
+		require.Nil(t, err)
+		sort.Strings(report.Applied)
+		require.Equal(t, []string{"dir1/file6", "dir3/file8"}, report.Applied)
+		assertFile(t, filepath.Join(tempdir, "dir1/file6"), []byte("changed"))
+
+		// A file that can't be staged still fails the whole extraction
+		bad := make(ConfigStruct)
+		for fname := range next {
+			bad[fname] = &ConfigFile{Value: "bad"}
+		}
+		bad["dir2/file7"] = &ConfigFile{Value: "!", Encoding: EncodingBase64}
+		report, err = app.extract(context.Background(), nil, configSnapshot{next, bad})
+		require.NotNil(t, err)
+		require.Contains(t, report.Failed, "dir2/file7")
+		assertFile(t, filepath.Join(tempdir, "dir1/file6"), []byte("changed"))
+		assertFile(t, filepath.Join(tempdir, "dir0/file0"), []byte("value 0"))
+		assertNoFile(t, filepath.Join(tempdir, txnDirName))
+
+		// However staging goes, files are committed in the config's order
+		// and removals after them
+		txn, err := beginExtract(t.TempDir(), 0o750)
+		require.Nil(t, err)
+		defer txn.close()
+		order := sortedNames(config)
+		for _, res := range stageFiles(context.Background(), txn, order, config, nil, 4, false) {
+			require.Nil(t, res.err)
+		}
+		txn.remove("gone")
+		require.Nil(t, txn.commit())
+		var committed []string
+		for _, op := range txn.done {
+			committed = append(committed, op.fname)
+		}
+		require.Equal(t, append(order, "gone"), committed)
+	})
+}
+
+func TestPartialExtract(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		prev := ConfigStruct{
+			"a": {Value: "old a"},
+			"b": {Value: "old b"},
+			"c": {Value: "old c"},
+		}
+		_, err := app.extract(context.Background(), nil, configSnapshot{nil, prev})
+		require.Nil(t, err)
+
+		next := ConfigStruct{
+			"a":         {Value: "new a"},
+			"b":         {Value: "!", Encoding: EncodingBase64},
+			"d":         {Value: "new d"},
+			"../escape": {Value: "x"},
+		}
+		// By default nothing is applied
+		report, err := app.extract(context.Background(), nil, configSnapshot{prev, next})
+		require.NotNil(t, err)
+		assertFile(t, filepath.Join(tempdir, "a"), []byte("old a"))
+
+		app.settings.PartialExtract = true
+		report, err = app.extract(context.Background(), nil, configSnapshot{prev, next})
+		require.Nil(t, err)
+		sort.Strings(report.Applied)
+		require.Equal(t, []string{"a", "d"}, report.Applied)
+		require.Equal(t, []string{"c"}, report.Removed)
+		require.Len(t, report.Failed, 2)
+		require.Contains(t, report.Failed, "b")
+		require.Contains(t, report.Failed, "../escape")
+		assertFile(t, filepath.Join(tempdir, "a"), []byte("new a"))
+		assertFile(t, filepath.Join(tempdir, "b"), []byte("old b"))
+		assertFile(t, filepath.Join(tempdir, "d"), []byte("new d"))
+		assertNoFile(t, filepath.Join(tempdir, "c"))
+
+		// The failed file keeps the hash of what's on disk, so it's tried
+		// again next time
+		require.Equal(t, sha256Hex([]byte("old b")), app.loadManifest()["b"])
+
+		err = report.partialError()
+		require.True(t, errors.Is(err, PartialExtractError), err)
+		require.Contains(t, err.Error(), "b: ")
+	})
+}
@@ -0,0 +1,135 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Config files with UbootEnv set are key=value lines for the bootloader
+// environment, e.g. "console=ttymxc0,115200". After an extraction the
+// variables of every such file that changed are set, and the ones a file
+// no longer has are deleted, with a single `fw_setenv -s` so libubootenv
+// writes the environment once, atomically.
+
+// runFwSetenv runs fw_setenv with the given script
+var runFwSetenv = func(ctx context.Context, script string) ([]byte, error) {
+	return exec.CommandContext(ctx, "fw_setenv", "-s", script).CombinedOutput()
+}
+
+// parseUbootEnv returns the variables of a UbootEnv file. Blank lines and
+// lines starting with "#" are ignored.
+func parseUbootEnv(content []byte) (map[string]string, error) {
+	vars := make(map[string]string)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || len(key) == 0 || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("Invalid boot environment variable on line %d", i+1)
+		}
+		vars[key] = strings.TrimSpace(kv[1])
+	}
+	return vars, nil
+}
+
+// validateUbootEnv checks a file can be applied to the boot environment
+func validateUbootEnv(cfgFile *ConfigFile) error {
+	if !cfgFile.UbootEnv {
+		return nil
+	}
+	content, err := cfgFile.content()
+	if err == nil {
+		_, err = parseUbootEnv(content)
+	}
+	return err
+}
+
+func ubootEnvVars(cfgFile *ConfigFile) map[string]string {
+	if cfgFile == nil || !cfgFile.UbootEnv {
+		return nil
+	}
+	content, _ := cfgFile.content()
+	vars, _ := parseUbootEnv(content) // validateUbootEnv already checked it
+	return vars
+}
+
+// applyUbootEnv updates the boot environment for the UbootEnv files that
+// changed or were removed
+func (a *App) applyUbootEnv(ctx context.Context, c *committedConfig) {
+	set := make(map[string]string)
+	unset := make(map[string]bool)
+	var files []string
+	for _, fname := range c.touched() {
+		next := ubootEnvVars(c.next[fname])
+		prev := ubootEnvVars(c.prev[fname])
+		if next == nil && prev == nil {
+			continue
+		}
+		files = append(files, fname)
+		for key, val := range next {
+			set[key] = val
+		}
+		for key := range prev {
+			if _, ok := next[key]; !ok {
+				unset[key] = true
+			}
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	for key := range set {
+		unset[key] = false
+	}
+	keys := make([]string, 0, len(unset))
+	for key := range unset {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var script bytes.Buffer
+	for _, key := range keys {
+		if unset[key] {
+			// A line with only the name deletes the variable
+			script.WriteString(key + "\n")
+		} else {
+			script.WriteString(key + " " + set[key] + "\n")
+		}
+	}
+
+	LogEvent(EventBootEnvUpdated, "Updating boot environment for %s", strings.Join(files, ", "))
+	err := a.fwSetenv(ctx, script.Bytes())
+	if err != nil {
+		LogEvent(EventHandlerFailed, "Unable to update boot environment: %s", err)
+		for _, fname := range files {
+			c.report.fail(fname, err)
+		}
+	}
+}
+
+func (a *App) fwSetenv(ctx context.Context, script []byte) error {
+	f, err := os.CreateTemp("", "fioconfig-fw_setenv-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(script)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if out, err := runFwSetenv(ctx, f.Name()); err != nil {
+		return fmt.Errorf("fw_setenv failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUbootEnv(t *testing.T) {
+	var scripts []string
+	fail := false
+	orig := runFwSetenv
+	runFwSetenv = func(ctx context.Context, script string) ([]byte, error) {
+		content, err := os.ReadFile(script)
+		require.Nil(t, err)
+		scripts = append(scripts, string(content))
+		if fail {
+			return []byte("Cannot read environment"), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+	defer func() { runFwSetenv = orig }()
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		config := ConfigStruct{
+			"boot/console": &ConfigFile{Value: "# Serial console\nconsole=ttymxc0,115200\n", UbootEnv: true},
+			"boot/order":   &ConfigFile{Value: "boot_order = mmc0 mmc1\nupgrade_available=0\n", UbootEnv: true},
+			"plain":        &ConfigFile{Value: "not=boot"},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Equal(t, []string{"boot_order mmc0 mmc1\nconsole ttymxc0,115200\nupgrade_available 0\n"}, scripts)
+
+		// Unchanged files aren't applied again, dropped variables are deleted
+		scripts = nil
+		next := ConfigStruct{
+			"boot/console": config["boot/console"],
+			"boot/order":   &ConfigFile{Value: "boot_order=mmc1 mmc0\n", UbootEnv: true},
+		}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{config, next})
+		require.Nil(t, err)
+		require.Equal(t, []string{"boot_order mmc1 mmc0\nupgrade_available\n"}, scripts)
+
+		scripts = nil
+		fail = true
+		report, err := app.extract(context.Background(), crypto, configSnapshot{next, ConfigStruct{"boot/order": next["boot/order"]}})
+		require.Nil(t, err)
+		require.Equal(t, []string{"console\n"}, scripts)
+		require.Contains(t, report.Failed["boot/console"], "Cannot read environment")
+
+		bad := ConfigStruct{"boot/bad": &ConfigFile{Value: "no equals sign", UbootEnv: true}}
+		_, err = app.extract(context.Background(), crypto, configSnapshot{nil, bad})
+		require.NotNil(t, err)
+	})
+}
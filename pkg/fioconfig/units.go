@@ -0,0 +1,116 @@
+package fioconfig
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// UnitResult is the outcome of a systemd action declared by a config file
+type UnitResult struct {
+	Unit   string `json:"unit"`
+	Action string `json:"action"` // "reload" or "restart"
+	// The job's result, e.g. "done" or "failed", and the unit's state once
+	// it finished
+	Result      string `json:"result"`
+	ActiveState string `json:"active-state,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// unitManager is the part of the systemd D-Bus API used for unit actions
+type unitManager interface {
+	RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	ReloadUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	GetUnitPropertyContext(ctx context.Context, unit string, propertyName string) (*dbus.Property, error)
+	Close()
+}
+
+var newUnitManager = func(ctx context.Context) (unitManager, error) {
+	return dbus.NewSystemConnectionContext(ctx)
+}
+
+type unitAction struct {
+	action string
+	unit   string
+}
+
+// unitActions returns the systemd actions a config file declares. Units are
+// reloaded before any are restarted.
+func (c *ConfigFile) unitActions() []unitAction {
+	var actions []unitAction
+	for _, unit := range c.Reload {
+		actions = append(actions, unitAction{"reload", unit})
+	}
+	for _, unit := range c.Restart {
+		actions = append(actions, unitAction{"restart", unit})
+	}
+	return actions
+}
+
+// hasActions is true if the file declares anything to do when it changes
+func (c *ConfigFile) hasActions() bool {
+	return len(c.OnChanged)+len(c.unitActions())+len(c.ComposeRestart) > 0
+}
+
+// runUnitActions performs a file's systemd actions over D-Bus, waits for
+// each job to finish, and checks that the unit is active afterwards.
+func (a *App) runUnitActions(ctx context.Context, fname string, cfgFile *ConfigFile) []UnitResult {
+	actions := cfgFile.unitActions()
+	results := make([]UnitResult, len(actions))
+	for i, action := range actions {
+		results[i] = UnitResult{Unit: action.unit, Action: action.action, Result: "failed"}
+	}
+	fail := func(err error) []UnitResult {
+		for i := range results {
+			results[i].Error = err.Error()
+		}
+		return results
+	}
+
+	timeout, err := a.handlerTimeout(cfgFile)
+	if err != nil {
+		return fail(err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := newUnitManager(ctx)
+	if err != nil {
+		return fail(fmt.Errorf("Unable to connect to systemd: %w", err))
+	}
+	defer conn.Close()
+
+	for i, action := range actions {
+		result := &results[i]
+		LogEvent(EventUnitAction, "Running systemd %s of %s for %s", action.action, action.unit, fname)
+		start := conn.RestartUnitContext
+		if action.action == "reload" {
+			start = conn.ReloadUnitContext
+		}
+		ch := make(chan string, 1)
+		if _, err := start(ctx, action.unit, "replace", ch); err != nil {
+			result.Error = err.Error()
+		} else {
+			select {
+			case result.Result = <-ch:
+			case <-ctx.Done():
+				result.Result = "timeout"
+			}
+			if prop, err := conn.GetUnitPropertyContext(ctx, action.unit, "ActiveState"); err == nil {
+				result.ActiveState, _ = prop.Value.Value().(string)
+			}
+			if result.Result != "done" {
+				result.Error = "Job " + result.Result
+			} else if result.ActiveState != "active" {
+				result.Error = "Unit is " + result.ActiveState
+			}
+		}
+		if len(result.Error) > 0 {
+			LogEvent(EventHandlerFailed, "Unable to %s %s: %s", action.action, action.unit, result.Error)
+		}
+	}
+	return results
+}
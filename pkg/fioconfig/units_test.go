@@ -0,0 +1,84 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	sdbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUnits struct {
+	jobs   []string
+	states map[string]string
+}
+
+func (f *fakeUnits) job(action, name string, ch chan<- string) (int, error) {
+	state, ok := f.states[name]
+	if !ok {
+		return 0, errors.New("Unit " + name + " not found")
+	}
+	f.jobs = append(f.jobs, action+" "+name)
+	if state == "active" {
+		ch <- "done"
+	} else {
+		ch <- "failed"
+	}
+	return len(f.jobs), nil
+}
+
+func (f *fakeUnits) RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return f.job("restart", name, ch)
+}
+
+func (f *fakeUnits) ReloadUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return f.job("reload", name, ch)
+}
+
+func (f *fakeUnits) GetUnitPropertyContext(ctx context.Context, unit string, propertyName string) (*sdbus.Property, error) {
+	return &sdbus.Property{Name: propertyName, Value: dbus.MakeVariant(f.states[unit])}, nil
+}
+
+func (f *fakeUnits) Close() {}
+
+func TestUnitActions(t *testing.T) {
+	units := &fakeUnits{states: map[string]string{"nginx.service": "active", "broken.service": "failed"}}
+	orig := newUnitManager
+	newUnitManager = func(ctx context.Context) (unitManager, error) { return units, nil }
+	defer func() { newUnitManager = orig }()
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		app.settings.DedupeHandlers = true
+		config := ConfigStruct{
+			"nginx/a.conf": &ConfigFile{Value: "a", Restart: []string{"nginx.service"}, Reload: []string{"nginx.service"}},
+			"nginx/b.conf": &ConfigFile{Value: "b", Restart: []string{"nginx.service"}, Reload: []string{"nginx.service"}},
+			"broken":       &ConfigFile{Value: "c", Restart: []string{"broken.service", "missing.service"}},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+
+		// The two nginx files share their actions
+		require.Equal(t, []string{"restart broken.service", "reload nginx.service", "restart nginx.service"}, units.jobs)
+		require.Len(t, report.Handlers, 2)
+
+		broken := report.Handlers[0]
+		require.Equal(t, "broken", broken.File)
+		require.Equal(t, UnitResult{"broken.service", "restart", "failed", "failed", "Job failed"}, broken.Units[0])
+		require.Equal(t, "missing.service", broken.Units[1].Unit)
+		require.Contains(t, broken.Units[1].Error, "not found")
+
+		nginx := report.Handlers[1]
+		require.Equal(t, []string{"nginx/a.conf", "nginx/b.conf"}, nginx.Files)
+		require.Equal(t, []UnitResult{
+			{"nginx.service", "reload", "done", "active", ""},
+			{"nginx.service", "restart", "done", "active", ""},
+		}, nginx.Units)
+	})
+}
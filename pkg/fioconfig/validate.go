@@ -0,0 +1,124 @@
+package fioconfig
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// Keys every sota.toml needs whatever the key source
+var requiredSotaKeys = []string{
+	"tls.server",
+	"tls.ca_source",
+	"tls.pkey_source",
+	"tls.cert_source",
+	"import.tls_cacert_path",
+	"storage.path",
+}
+
+// Validate checks a device is ready to ship: its sota.toml is complete, its
+// CA file, key and certificate load, and the config server accepts it.
+// Unlike Diagnose it doesn't need a working App, so it can report what's
+// wrong with a sota.toml NewApp would refuse. Later checks are skipped once
+// one fails.
+func Validate(sotaConfig string) []DiagnosticResult {
+	var results []DiagnosticResult
+	add := func(name, hint string, err error) bool {
+		results = append(results, DiagnosticResult{name, err, hint})
+		return err == nil
+	}
+
+	path := filepath.Join(sotaConfig, "sota.toml")
+	sota, err := toml.LoadFile(path)
+	if !add("Parse "+path, "Check the file exists and is valid TOML", err) {
+		return results
+	}
+	var missing []string
+	for _, key := range requiredSotaKeys {
+		if _, err := tomlGet(sota, key); err != nil {
+			missing = append(missing, key)
+		}
+	}
+	err = nil
+	if len(missing) > 0 {
+		err = fmt.Errorf("%w: %s", MissingConfigKeyError, strings.Join(missing, ", "))
+	}
+	if !add("Required keys", "Add the missing keys to sota.toml", err) {
+		return results
+	}
+	settings, err := loadSettings(sota)
+	if !add("Parse [fioconfig] section", "Check the types of the fioconfig.* settings", err) {
+		return results
+	}
+
+	caFile, _ := tomlGet(sota, "import.tls_cacert_path")
+	if !add("Load CA file "+caFile, "Check import.tls_cacert_path points to the factory's root CA in PEM format", validateCaFile(caFile)) {
+		return results
+	}
+
+	client, crypto, err := createClient(sota)
+	if !add("Load device key and certificate", "Check the tls and import sections of sota.toml, and that any PKCS#11 token is present", err) {
+		return results
+	}
+	defer crypto.Close()
+	if !add("Use device key", "The key couldn't decrypt a test value. Check the key matches the certificate", selfTest(crypto)) {
+		return results
+	}
+	err = nil
+	if certs := client.Transport.(*http.Transport).TLSClientConfig.Certificates; len(certs) > 0 && len(certs[0].Certificate) > 0 {
+		cert, perr := x509.ParseCertificate(certs[0].Certificate[0])
+		if perr != nil {
+			err = perr
+		} else if now := time.Now(); now.After(cert.NotAfter) {
+			err = fmt.Errorf("Expired on %s", cert.NotAfter.Format(time.RFC3339))
+		} else if now.Before(cert.NotBefore) {
+			err = fmt.Errorf("Not valid until %s", cert.NotBefore.Format(time.RFC3339))
+		}
+	}
+	if !add("Device certificate validity", "Check the system clock, or renew the certificate with `fioconfig renew-cert`", err) {
+		return results
+	}
+
+	url := configUrls(sota, settings)[0]
+	res, err := httpDoOnce(context.Background(), client, http.MethodGet, url, nil, nil)
+	if err == nil && res.StatusCode != 200 && res.StatusCode != 204 {
+		err = fmt.Errorf("HTTP_%d: %s", res.StatusCode, strings.TrimSpace(res.String()))
+	}
+	add("GET "+url, "Run `fioconfig diagnose` to find where the connection fails", classifyTlsError(err, client, time.Now()))
+	return results
+}
+
+func validateCaFile(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	found := false
+	for {
+		var block *pem.Block
+		block, buf = pem.Decode(buf)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return errors.New("No PEM certificates found")
+	}
+	return nil
+}
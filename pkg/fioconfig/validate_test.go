@@ -0,0 +1,48 @@
+package fioconfig
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	status := 200
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		results := Validate(tempdir)
+		require.Len(t, results, 8)
+		for _, result := range results {
+			require.Nil(t, result.Err, result.String())
+		}
+
+		status = 403
+		results = Validate(tempdir)
+		last := results[len(results)-1]
+		require.True(t, strings.HasPrefix(last.Name, "GET "), last.Name)
+		require.NotNil(t, last.Err)
+
+		sota, err := os.ReadFile(filepath.Join(tempdir, "sota.toml"))
+		require.Nil(t, err)
+		broken := strings.Replace(string(sota), "[storage]", "[notstorage]", 1)
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "sota.toml"), []byte(broken), 0o644))
+		results = Validate(tempdir)
+		last = results[len(results)-1]
+		require.Equal(t, "Required keys", last.Name)
+		require.Contains(t, last.Err.Error(), "storage.path")
+
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "sota.toml"), sota, 0o644))
+		require.Nil(t, os.WriteFile(filepath.Join(tempdir, "root.crt"), []byte("not a cert"), 0o644))
+		results = Validate(tempdir)
+		last = results[len(results)-1]
+		require.Contains(t, last.Name, "Load CA file")
+		require.NotNil(t, last.Err)
+	})
+}
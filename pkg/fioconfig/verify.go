@@ -0,0 +1,96 @@
+package fioconfig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A ConfigVerifier checks a new config before it's applied. dir holds the
+// complete new config as it will be written to the secrets directory.
+type ConfigVerifier func(app *App, dir string, changed []string) error
+
+var configVerifiers = map[string]ConfigVerifier{}
+
+// RegisterConfigVerifier adds a check that every new config must pass
+// before it's applied
+func RegisterConfigVerifier(name string, verifier ConfigVerifier) {
+	configVerifiers[name] = verifier
+}
+
+// How much of the verify command's output to keep for the status report
+const verifyOutputLimit = 4096
+
+// ConfigRejectedError is returned when a verifier rejects a new config. The
+// previous config is left in place.
+type ConfigRejectedError struct {
+	Verifier string
+	Reason   string
+}
+
+func (e *ConfigRejectedError) Error() string {
+	return fmt.Sprintf("New config rejected by %s: %s", e.Verifier, e.Reason)
+}
+
+func (a *App) hasVerifiers() bool {
+	return len(a.settings.VerifyCommand) > 0 || len(configVerifiers) > 0
+}
+
+// verify writes out the new config to dir and runs the verifiers on it
+func (a *App) verify(dir string, config ConfigStruct, changed []string) error {
+	for fname, cfgFile := range config {
+		content, err := cfgFile.content()
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, fname)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return err
+		}
+		if cfgFile.Symlink {
+			if err := os.Symlink(cfgFile.Value, path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for name := range configVerifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := configVerifiers[name](a, dir, changed); err != nil {
+			return &ConfigRejectedError{name, err.Error()}
+		}
+	}
+
+	if len(a.settings.VerifyCommand) == 0 {
+		return nil
+	}
+	LogEvent(EventVerifyRun, "Verifying new config with %v", a.settings.VerifyCommand)
+	cmd := exec.Command(a.settings.VerifyCommand[0], a.settings.VerifyCommand[1:]...)
+	cmd.Env = append(a.handlerEnv(), "STAGED_CONFIG_DIR="+dir, "CHANGED_FILES="+strings.Join(changed, "\n"))
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		reason := err.Error()
+		if out := strings.TrimSpace(output.String()); len(out) > 0 {
+			if len(out) > verifyOutputLimit {
+				out = out[len(out)-verifyOutputLimit:]
+			}
+			reason += ": " + out
+		}
+		return &ConfigRejectedError{"verify_command", reason}
+	}
+	return nil
+}
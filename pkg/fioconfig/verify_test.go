@@ -0,0 +1,54 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCommand(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		app.settings.VerifyCommand = []string{"/bin/sh", "-c",
+			`if grep -q bad "$STAGED_CONFIG_DIR/net.conf"; then echo "syntax error in net.conf ($CHANGED_FILES)"; exit 1; fi`}
+
+		good := ConfigStruct{"net.conf": {Value: "good"}, "other": {Value: "x"}}
+		_, err := app.extract(context.Background(), nil, configSnapshot{nil, good})
+		require.Nil(t, err)
+		assertFile(t, filepath.Join(tempdir, "net.conf"), []byte("good"))
+
+		bad := ConfigStruct{"net.conf": {Value: "bad"}, "other": {Value: "y"}}
+		report, err := app.extract(context.Background(), nil, configSnapshot{good, bad})
+		var rejected *ConfigRejectedError
+		require.True(t, errors.As(err, &rejected), err)
+		require.Equal(t, EventConfigRejected, report.Code)
+		require.Contains(t, report.Rejected, "syntax error in net.conf (net.conf\nother)")
+		assertFile(t, filepath.Join(tempdir, "net.conf"), []byte("good"))
+		assertFile(t, filepath.Join(tempdir, "other"), []byte("x"))
+		assertNoFile(t, filepath.Join(tempdir, txnDirName))
+	})
+}
+
+func TestConfigVerifier(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		RegisterConfigVerifier("test", func(app *App, dir string, changed []string) error {
+			if _, err := os.Stat(filepath.Join(dir, "required")); err != nil {
+				return errors.New("required is missing")
+			}
+			return nil
+		})
+		defer delete(configVerifiers, "test")
+
+		_, err := app.extract(context.Background(), nil, configSnapshot{nil, ConfigStruct{"foo": {Value: "1"}}})
+		require.EqualError(t, err, "New config rejected by test: required is missing")
+		assertNoFile(t, filepath.Join(tempdir, "foo"))
+
+		_, err = app.extract(context.Background(), nil, configSnapshot{nil, ConfigStruct{"foo": {Value: "1"}, "required": {}}})
+		require.Nil(t, err)
+		assertFile(t, filepath.Join(tempdir, "foo"), []byte("1"))
+	})
+}
@@ -1,12 +1,11 @@
 //go:build vpn
 // +build vpn
 
-package internal
+package fioconfig
 
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
@@ -41,19 +40,19 @@ func vpnBugFix(app *App, sotaConfig string) bool {
 	// will pick up this OTA, and we'll be able to remove this quirk.
 	secretFile := filepath.Join(app.SecretsDir, "wireguard-client")
 	if _, err := os.Stat(secretFile); os.IsNotExist(err) {
-		log.Println("Wireguard key not registered on server, will re-register")
+		logger.Println("Wireguard key not registered on server, will re-register")
 		return true
 	}
 	return false
 }
 
 func initVpn(app *App, client *http.Client, crypto CryptoHandler) error {
-	sotaConfig := filepath.Dir(app.EncryptedConfig)
+	sotaConfig := app.sotaConfig
 	wgPriv := filepath.Join(sotaConfig, "wg-priv")
 	register := false
 	if _, err := os.Stat(wgPriv); os.IsNotExist(err) {
 		register = true
-		log.Println("Wireguard private key does not exist, generating.")
+		logger.Println("Wireguard private key does not exist, generating.")
 	} else {
 		register = vpnBugFix(app, sotaConfig)
 	}
@@ -63,7 +62,7 @@ func initVpn(app *App, client *http.Client, crypto CryptoHandler) error {
 		if err != nil {
 			return fmt.Errorf("Unable to generate private key: %s", err)
 		}
-		log.Printf("Uploading Wireguard pub key(%s).", pub)
+		logger.Printf("Uploading Wireguard pub key(%s).", pub)
 		if err := updateConfig(app, client, pub); err != nil {
 			return fmt.Errorf("Unable to server config with VPN public key: %s", err)
 		}
@@ -75,5 +74,5 @@ func initVpn(app *App, client *http.Client, crypto CryptoHandler) error {
 }
 
 func init() {
-	initFunctions["wireguard-vpn"] = initVpn
+	RegisterInitFunction("wireguard-vpn", 0, initVpn, InitPolicy{})
 }
@@ -0,0 +1,46 @@
+package fioconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var waitPollInterval = 500 * time.Millisecond
+
+// filesPending returns the files that haven't been extracted yet. A file is
+// current once it exists in the secrets directory and fioconfig has recorded
+// applying it.
+func (a *App) filesPending(files []string) []string {
+	applied := a.loadManifest()
+	var pending []string
+	for _, fname := range files {
+		if _, ok := applied[fname]; !ok {
+			pending = append(pending, fname)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(a.SecretsDir, fname)); err != nil {
+			pending = append(pending, fname)
+		}
+	}
+	return pending
+}
+
+// WaitForFiles blocks until all the given config files have been extracted
+// or timeout expires. This lets services order themselves after their
+// secrets are in place rather than polling for them.
+func (a *App) WaitForFiles(files []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pending := a.filesPending(files)
+		if len(pending) == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("Timed out waiting for: %s", strings.Join(pending, ", "))
+		}
+		time.Sleep(waitPollInterval)
+	}
+}
@@ -0,0 +1,29 @@
+package fioconfig
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForFiles(t *testing.T) {
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		waitPollInterval = 10 * time.Millisecond
+
+		err := app.WaitForFiles([]string{"foo", "bar"}, 50*time.Millisecond)
+		require.NotNil(t, err)
+		require.Equal(t, "Timed out waiting for: foo, bar", err.Error())
+
+		done := make(chan error)
+		go func() {
+			done <- app.WaitForFiles([]string{"foo", "bar"}, 10*time.Second)
+		}()
+		require.Nil(t, app.Extract())
+		require.Nil(t, <-done)
+
+		err = app.WaitForFiles([]string{"foo", "does-not-exist"}, 0)
+		require.Equal(t, "Timed out waiting for: does-not-exist", err.Error())
+	})
+}
@@ -0,0 +1,91 @@
+package fioconfig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// WebhookSignatureHeader holds "sha256=" and the hex HMAC-SHA256 of the
+// payload keyed with webhook_secret. Receivers should check it, and the
+// payload's time, before trusting a request.
+const WebhookSignatureHeader = "X-Fioconfig-Signature"
+
+// WebhookPayload is the body POSTed to the webhooks
+type WebhookPayload struct {
+	Event   ChangeType `json:"event"`
+	Time    time.Time  `json:"time"`
+	Device  string     `json:"device,omitempty"`
+	Version int        `json:"version,omitempty"`
+	Files   []string   `json:"files,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// webhookSignature returns the value of WebhookSignatureHeader for a body
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhooks POSTs the events integrators care about to each webhook.
+// It runs during the check-in, so each request gets a short timeout and
+// failures are only logged.
+// setWebhooks subscribes sendWebhooks while there are webhooks configured
+func (a *App) setWebhooks() {
+	if len(a.settings.Webhooks) > 0 && a.stopWebhooks == nil {
+		a.stopWebhooks = a.Subscribe(a.sendWebhooks)
+	} else if len(a.settings.Webhooks) == 0 && a.stopWebhooks != nil {
+		a.stopWebhooks()
+		a.stopWebhooks = nil
+	}
+}
+
+func (a *App) sendWebhooks(event ChangeEvent) {
+	switch event.Type {
+	case ChangeConfigApplied, ChangeExtractFailed, ChangeCertRenewed:
+	default:
+		return
+	}
+	payload := WebhookPayload{
+		Event:   event.Type,
+		Time:    event.Time.UTC(),
+		Device:  a.deviceUuid,
+		Version: event.Version,
+		Files:   event.Files,
+	}
+	if event.Err != nil {
+		payload.Error = event.Err.Error()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		LogEvent(EventWebhookFailed, "Unable to encode webhook payload: %s", err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, url := range a.settings.Webhooks {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			LogEventWith(EventWebhookFailed, LogFields{"url": url}, "Invalid webhook %s: %s", url, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if len(a.settings.WebhookSecret) > 0 {
+			req.Header.Set(WebhookSignatureHeader, webhookSignature(a.settings.WebhookSecret, body))
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			LogEventWith(EventWebhookFailed, LogFields{"url": url}, "Unable to POST to webhook %s: %s", url, err)
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			LogEventWith(EventWebhookFailed, LogFields{"url": url, "status": res.StatusCode},
+				"Unable to POST to webhook %s: HTTP_%d", url, res.StatusCode)
+		}
+	}
+}
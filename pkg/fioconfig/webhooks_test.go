@@ -0,0 +1,69 @@
+package fioconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooks(t *testing.T) {
+	var payloads []WebhookPayload
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		require.Equal(t, webhookSignature("s3cret", body), r.Header.Get(WebhookSignatureHeader))
+		var p WebhookPayload
+		require.Nil(t, json.Unmarshal(body, &p))
+		payloads = append(payloads, p)
+	}))
+	defer hook.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	var encbuf []byte
+	doGet := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write(encbuf)
+		require.Nil(t, err)
+	})
+
+	testWrapper(t, doGet, func(app *App, client *http.Client, tempdir string) {
+		var err error
+		encbuf, err = os.ReadFile(app.EncryptedConfig)
+		require.Nil(t, err)
+		os.Remove(app.EncryptedConfig)
+
+		// A failing webhook doesn't stop the others or the check-in
+		app.settings.Webhooks = []string{failing.URL, hook.URL}
+		app.settings.WebhookSecret = "s3cret"
+		app.deviceUuid = "device-uuid"
+		defer app.Subscribe(app.sendWebhooks)()
+
+		require.Nil(t, app.CheckIn())
+		require.Len(t, payloads, 1)
+		require.Equal(t, ChangeConfigApplied, payloads[0].Event)
+		require.Equal(t, "device-uuid", payloads[0].Device)
+		require.Equal(t, 1, payloads[0].Version)
+		require.ElementsMatch(t, []string{"bar", "foo", "random", "with/subdir/1.txt"}, payloads[0].Files)
+
+		// Nothing changed
+		require.Nil(t, app.CheckIn())
+		require.Len(t, payloads, 1)
+
+		app.publishExtract(nil, errors.New("boom"), 2)
+		require.Len(t, payloads, 2)
+		require.Equal(t, ChangeExtractFailed, payloads[1].Event)
+		require.Equal(t, "boom", payloads[1].Error)
+
+		app.publish(ChangeEvent{Type: ChangeCertRenewed})
+		require.Len(t, payloads, 3)
+		require.Equal(t, ChangeCertRenewed, payloads[2].Event)
+	})
+}
@@ -0,0 +1,204 @@
+package fioconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fioctl configures a factory's WireGuard VPN with two unencrypted config
+// files of shell style key=value lines: wireguard-server has the server's
+// endpoint, public key, and address, and wireguard-client has the device's
+// VPN address and the public key of the private key the vpn build keeps in
+// wg-priv. When wireguard_interface is set, fioconfig renders them into a
+// wg-quick config and brings the interface up or down itself instead of
+// relying on an on-changed script like contrib/factory-config-vpn.
+const (
+	wireguardServerFile = "wireguard-server"
+	wireguardClientFile = "wireguard-client"
+)
+
+// wireguardDir is where wg-quick looks for <interface>.conf
+var wireguardDir = "/etc/wireguard"
+
+// runWireguard runs wg or wg-quick
+var runWireguard = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// VpnStatus is the state of the factory VPN interface
+type VpnStatus struct {
+	Interface string `json:"interface"`
+	Enabled   bool   `json:"enabled"`
+	Up        bool   `json:"up"`
+	// When the last handshake with the server completed
+	LatestHandshake *time.Time `json:"latest-handshake,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// header is the VPN status as sent with each check-in
+func (s VpnStatus) header() string {
+	val := fmt.Sprintf("interface=%s; enabled=%t; up=%t", s.Interface, s.Enabled, s.Up)
+	if s.LatestHandshake != nil {
+		val += fmt.Sprintf("; handshake=%d", s.LatestHandshake.Unix())
+	}
+	return val
+}
+
+// parseWireguardFile reads the key=value lines of a fioctl VPN config file
+func parseWireguardFile(content []byte) map[string]string {
+	vals := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+			vals[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		}
+	}
+	return vals
+}
+
+func (a *App) wireguardConf() string {
+	return filepath.Join(wireguardDir, a.settings.WireguardInterface+".conf")
+}
+
+// renderWireguard returns the wg-quick config for the VPN, or nil if the
+// server or device has it disabled
+func (a *App) renderWireguard(server, client []byte) ([]byte, error) {
+	srv := parseWireguardFile(server)
+	dev := parseWireguardFile(client)
+	if srv["enabled"] == "0" || dev["enabled"] == "0" {
+		return nil, nil
+	}
+	for _, key := range []string{"pubkey", "endpoint", "server_address"} {
+		if len(srv[key]) == 0 {
+			return nil, fmt.Errorf("%s is missing %s", wireguardServerFile, key)
+		}
+	}
+	if len(dev["address"]) == 0 {
+		return nil, fmt.Errorf("%s is missing address", wireguardClientFile)
+	}
+	priv, err := os.ReadFile(filepath.Join(a.sotaConfig, "wg-priv"))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read WireGuard private key: %w", err)
+	}
+	keepalive := srv["keepalive"]
+	if len(keepalive) == 0 {
+		keepalive = "25"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[Interface]\nPrivateKey = %s\nAddress = %s\n\n", strings.TrimSpace(string(priv)), dev["address"])
+	fmt.Fprintf(&buf, "[Peer]\nPublicKey = %s\nEndpoint = %s\nAllowedIPs = %s\nPersistentKeepalive = %s\n",
+		srv["pubkey"], srv["endpoint"], srv["server_address"], keepalive)
+	return buf.Bytes(), nil
+}
+
+// updateWireguard applies the VPN config after either of its files changed
+// or was removed
+func (a *App) updateWireguard(ctx context.Context, c *committedConfig) {
+	if len(a.settings.WireguardInterface) == 0 {
+		return
+	}
+	for _, fname := range c.touched() {
+		if fname == wireguardServerFile || fname == wireguardClientFile {
+			c.report.Vpn = a.applyWireguard(ctx, c.next)
+			return
+		}
+	}
+}
+
+// applyWireguard rewrites the interface's config and restarts it after
+// either VPN config file changed. The interface is taken down and its
+// config removed when the VPN is disabled or the files are gone.
+func (a *App) applyWireguard(ctx context.Context, config ConfigStruct) *VpnStatus {
+	status := &VpnStatus{Interface: a.settings.WireguardInterface}
+	var server, client []byte
+	if f, ok := config[wireguardServerFile]; ok {
+		server, _ = f.content()
+	}
+	if f, ok := config[wireguardClientFile]; ok {
+		client, _ = f.content()
+	}
+	conf := a.wireguardConf()
+	if _, err := os.Stat(conf); err == nil {
+		if out, err := runWireguard(ctx, "wg-quick", "down", conf); err != nil {
+			logger.Printf("Unable to take down %s: %s %s", status.Interface, err, out)
+		}
+	}
+
+	var rendered []byte
+	var err error
+	if server != nil && client != nil {
+		rendered, err = a.renderWireguard(server, client)
+	}
+	if err == nil && rendered == nil {
+		LogEvent(EventVpnConfigured, "VPN is disabled, removing %s", conf)
+		if err = os.Remove(conf); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	} else if err == nil {
+		status.Enabled = true
+		LogEvent(EventVpnConfigured, "Bringing up VPN interface %s", status.Interface)
+		err = os.MkdirAll(wireguardDir, 0o700)
+		if err == nil {
+			err = safeWriteMeta(conf, rendered, fileMeta{0o600, -1, -1})
+		}
+		zeroize(rendered)
+		if err == nil {
+			var out []byte
+			if out, err = runWireguard(ctx, "wg-quick", "up", conf); err != nil {
+				err = fmt.Errorf("wg-quick up failed: %w: %s", err, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	if err != nil {
+		LogEvent(EventHandlerFailed, "Unable to configure VPN: %s", err)
+		status.Error = err.Error()
+		return status
+	}
+	a.readVpnState(ctx, status)
+	return status
+}
+
+// VpnStatus returns the state of the wireguard_interface, or nil when
+// fioconfig isn't managing the VPN
+func (a *App) VpnStatus(ctx context.Context) *VpnStatus {
+	if len(a.settings.WireguardInterface) == 0 {
+		return nil
+	}
+	status := &VpnStatus{Interface: a.settings.WireguardInterface}
+	if _, err := os.Stat(a.wireguardConf()); err == nil {
+		status.Enabled = true
+	}
+	a.readVpnState(ctx, status)
+	return status
+}
+
+// readVpnState asks wg whether the interface is up and when it last heard
+// from the server
+func (a *App) readVpnState(ctx context.Context, status *VpnStatus) {
+	out, err := runWireguard(ctx, "wg", "show", status.Interface, "latest-handshakes")
+	if err != nil {
+		return // The interface doesn't exist
+	}
+	status.Up = true
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if secs, err := strconv.ParseInt(fields[1], 10, 64); err == nil && secs > 0 {
+			ts := time.Unix(secs, 0).UTC()
+			status.LatestHandshake = &ts
+		}
+	}
+}
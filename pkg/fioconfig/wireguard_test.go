@@ -0,0 +1,91 @@
+package fioconfig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWireguard(t *testing.T) {
+	var commands []string
+	up := false
+	origRun, origDir := runWireguard, wireguardDir
+	runWireguard = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+args[0])
+		switch {
+		case name == "wg-quick" && args[0] == "up":
+			up = true
+		case name == "wg-quick" && args[0] == "down":
+			up = false
+		case name == "wg" && up:
+			return []byte("c2VydmVyCg=\t1700000000\n"), nil
+		}
+		if !up {
+			return []byte("No such device"), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+	defer func() { runWireguard, wireguardDir = origRun, origDir }()
+
+	testWrapper(t, nil, func(app *App, client *http.Client, tempdir string) {
+		_, crypto, err := createClient(app.sota)
+		require.Nil(t, err)
+		defer crypto.Close()
+
+		wireguardDir = filepath.Join(tempdir, "wireguard")
+		app.settings.WireguardInterface = "factory-vpn0"
+		require.Nil(t, os.WriteFile(filepath.Join(app.sotaConfig, "wg-priv"), []byte("cHJpdgo=\n"), 0o600))
+		require.False(t, app.VpnStatus(context.Background()).Up)
+		commands = nil
+
+		config := ConfigStruct{
+			wireguardServerFile: &ConfigFile{Value: "endpoint=vpn.example.com:5555\npubkey=c2VydmVyCg=\nserver_address=10.42.42.1\n"},
+			wireguardClientFile: &ConfigFile{Value: "address=10.42.42.2\npubkey=Y2xpZW50Cg=\n"},
+		}
+		report, err := app.extract(context.Background(), crypto, configSnapshot{nil, config})
+		require.Nil(t, err)
+		require.Equal(t, []string{"wg-quick up", "wg show"}, commands)
+		require.NotNil(t, report.Vpn)
+		require.True(t, report.Vpn.Enabled)
+		require.True(t, report.Vpn.Up)
+		require.Equal(t, int64(1700000000), report.Vpn.LatestHandshake.Unix())
+
+		conf, err := os.ReadFile(filepath.Join(wireguardDir, "factory-vpn0.conf"))
+		require.Nil(t, err)
+		require.Contains(t, string(conf), "PrivateKey = cHJpdgo=\nAddress = 10.42.42.2\n")
+		require.Contains(t, string(conf), "Endpoint = vpn.example.com:5555\nAllowedIPs = 10.42.42.1\nPersistentKeepalive = 25\n")
+
+		status := app.VpnStatus(context.Background())
+		require.Equal(t, "interface=factory-vpn0; enabled=true; up=true; handshake=1700000000", status.header())
+
+		// Disabling the VPN takes the interface down
+		commands = nil
+		next := ConfigStruct{
+			wireguardServerFile: config[wireguardServerFile],
+			wireguardClientFile: &ConfigFile{Value: "enabled=0\npubkey=Y2xpZW50Cg=\n"},
+		}
+		report, err = app.extract(context.Background(), crypto, configSnapshot{config, next})
+		require.Nil(t, err)
+		require.Equal(t, []string{"wg-quick down", "wg show"}, commands)
+		require.False(t, report.Vpn.Enabled)
+		require.False(t, report.Vpn.Up)
+		_, err = os.Stat(filepath.Join(wireguardDir, "factory-vpn0.conf"))
+		require.True(t, errors.Is(err, os.ErrNotExist))
+
+		// A config fioconfig can't render is a warning
+		bad := ConfigStruct{
+			wireguardServerFile: &ConfigFile{Value: "endpoint=vpn.example.com:5555\n"},
+			wireguardClientFile: config[wireguardClientFile],
+		}
+		report, err = app.extract(context.Background(), crypto, configSnapshot{next, bad})
+		require.Nil(t, err)
+		require.Len(t, report.Warnings, 1)
+		require.True(t, strings.HasPrefix(report.Warnings[0], "factory-vpn0: wireguard-server is missing pubkey"), report.Warnings[0])
+	})
+}
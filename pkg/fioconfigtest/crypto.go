@@ -0,0 +1,105 @@
+// Package fioconfigtest helps programs embedding fioconfig write end-to-end
+// tests without a real device identity or device gateway.
+package fioconfigtest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Encrypter encrypts config values so a CryptoHandler can decrypt them
+type Encrypter interface {
+	Encrypt(value string) (string, error)
+}
+
+// NoopCrypto is a CryptoHandler that leaves values as they are
+type NoopCrypto struct{}
+
+func (c NoopCrypto) Decrypt(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+func (c NoopCrypto) Encrypt(value string) (string, error) {
+	return value, nil
+}
+
+func (c NoopCrypto) Close() {}
+
+// AESCrypto is a CryptoHandler using AES-GCM with a random key. Values are
+// base64 encoded like the ones the device gateway sends, so a value that
+// wasn't encrypted with the same AESCrypto fails to decrypt.
+type AESCrypto struct {
+	aead cipher.AEAD
+}
+
+func NewAESCrypto() (*AESCrypto, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("Unable to generate key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESCrypto{aead}, nil
+}
+
+func (c *AESCrypto) Encrypt(value string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("Unable to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(value), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *AESCrypto) Decrypt(value string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to base64 decode: %v", err)
+	}
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("Unable to AES decrypt: value too short")
+	}
+	nonce, sealed := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	decrypted, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to AES decrypt: %v", err)
+	}
+	return decrypted, nil
+}
+
+func (c *AESCrypto) Close() {}
+
+// File is a config file as the device gateway sends it
+type File struct {
+	Value       string
+	OnChanged   []string `json:",omitempty"`
+	Unencrypted bool     `json:",omitempty"`
+}
+
+// EncryptConfig builds the JSON config blob for the given files, encrypting
+// the values of the ones that aren't marked Unencrypted.
+func EncryptConfig(enc Encrypter, files map[string]File) ([]byte, error) {
+	config := make(map[string]File, len(files))
+	for name, file := range files {
+		if !file.Unencrypted {
+			value, err := enc.Encrypt(file.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to encrypt %s: %w", name, err)
+			}
+			file.Value = value
+		}
+		config[name] = file
+	}
+	return json.Marshal(config)
+}
@@ -0,0 +1,98 @@
+package fioconfigtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Gateway is a fake device gateway config endpoint. It returns HTTP 204
+// until a config is set, then HTTP 200 with the config or HTTP 304 when the
+// device already has it.
+type Gateway struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	config   []byte
+	etag     string
+	requests int
+	reports  []json.RawMessage
+}
+
+func NewGateway() *Gateway {
+	g := &Gateway{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", g.getConfig)
+	mux.HandleFunc("/config-status", g.postStatus)
+	g.Server = httptest.NewServer(mux)
+	return g
+}
+
+// ConfigURL is the URL to give fioconfig as its config endpoint
+func (g *Gateway) ConfigURL() string {
+	return g.URL + "/config"
+}
+
+// SetConfig changes the config blob devices get. A nil config makes the
+// gateway behave like a device with no config defined.
+func (g *Gateway) SetConfig(config []byte) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.config = config
+	g.etag = ""
+	if config != nil {
+		sum := sha256.Sum256(config)
+		g.etag = `"` + hex.EncodeToString(sum[:]) + `"`
+	}
+}
+
+// Requests returns how many times the config has been requested
+func (g *Gateway) Requests() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.requests
+}
+
+// Reports returns the status reports devices have sent
+func (g *Gateway) Reports() []json.RawMessage {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return append([]json.RawMessage(nil), g.reports...)
+}
+
+func (g *Gateway) getConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.requests++
+	if g.config == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("If-None-Match") == g.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", g.etag)
+	_, _ = w.Write(g.config)
+}
+
+func (g *Gateway) postStatus(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	g.lock.Lock()
+	g.reports = append(g.reports, body)
+	g.lock.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,65 @@
+package fioconfigtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/foundriesio/fioconfig/pkg/fioconfig"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGateway(t *testing.T) {
+	gw := NewGateway()
+	defer gw.Close()
+	crypto, err := NewAESCrypto()
+	require.Nil(t, err)
+
+	sotaDir := t.TempDir()
+	secrets := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(sotaDir, "sota.toml"), nil, 0o644))
+	app, err := fioconfig.NewApp(sotaDir,
+		fioconfig.WithSecretsDir(secrets),
+		fioconfig.WithConfigURL(gw.ConfigURL()),
+		fioconfig.WithHTTPClient(gw.Client()),
+		fioconfig.WithCryptoHandler(crypto))
+	require.Nil(t, err)
+
+	// No config defined yet
+	require.Equal(t, fioconfig.NoConfigError, app.CheckIn())
+
+	config, err := EncryptConfig(crypto, map[string]File{
+		"secret": {Value: "encrypted value"},
+		"plain":  {Value: "plain value", Unencrypted: true},
+	})
+	require.Nil(t, err)
+	require.NotContains(t, string(config), "encrypted value")
+	gw.SetConfig(config)
+	require.Nil(t, app.CheckIn())
+	content, err := os.ReadFile(filepath.Join(secrets, "secret"))
+	require.Nil(t, err)
+	require.Equal(t, "encrypted value", string(content))
+	content, err = os.ReadFile(filepath.Join(secrets, "plain"))
+	require.Nil(t, err)
+	require.Equal(t, "plain value", string(content))
+
+	// The device has the latest config
+	require.Equal(t, fioconfig.NotModifiedError, app.CheckIn())
+	require.Equal(t, 3, gw.Requests())
+}
+
+func TestAESCrypto(t *testing.T) {
+	crypto, err := NewAESCrypto()
+	require.Nil(t, err)
+	other, err := NewAESCrypto()
+	require.Nil(t, err)
+
+	encrypted, err := crypto.Encrypt("value")
+	require.Nil(t, err)
+	decrypted, err := crypto.Decrypt(encrypted)
+	require.Nil(t, err)
+	require.Equal(t, "value", string(decrypted))
+
+	_, err = other.Decrypt(encrypted)
+	require.NotNil(t, err)
+}